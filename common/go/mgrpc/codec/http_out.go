@@ -32,7 +32,10 @@ func OutboundHTTP(url string, tlsConfig *tls.Config) Codec {
 		closeNotifier: make(chan struct{}),
 		url:           url,
 	}
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	transport := &http.Transport{
+		Proxy:           ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
 	r.client = &http.Client{Transport: transport}
 	r.cond = sync.NewCond(r)
 	return r
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// localNets are the networks devices are usually on, which are never reached
+// through a proxy: loopback, private (RFC 1918, RFC 4193) and link-local.
+var localNets = func() []*net.IPNet {
+	var res []*net.IPNet
+	for _, s := range []string{
+		"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+		"::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(s)
+		res = append(res, n)
+	}
+	return res
+}()
+
+// ProxyFromEnvironment returns the proxy to use for the request, as
+// configured by HTTP_PROXY, HTTPS_PROXY and NO_PROXY, like
+// http.ProxyFromEnvironment, except that hosts on the local networks and
+// .local (mDNS) names are always connected to directly.
+func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if IsLocalHost(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// IsLocalHost returns whether the host is localhost, a .local name or an
+// address on the local networks.
+func IsLocalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".local") {
+		return true
+	}
+	// Drop the IPv6 zone, if any
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range localNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
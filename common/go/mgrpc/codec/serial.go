@@ -30,6 +30,8 @@ const (
 )
 
 type SerialCodecOptions struct {
+	// BaudRate defaults to 115200 if not set.
+	BaudRate             uint
 	SendChunkSize        int
 	SendChunkDelay       time.Duration
 	JunkHandler          func(junk []byte)
@@ -61,10 +63,14 @@ type serialCodec struct {
 }
 
 func Serial(ctx context.Context, portName string, opts *SerialCodecOptions) (Codec, error) {
-	glog.Infof("Opening %s...", portName)
+	baudRate := opts.BaudRate
+	if baudRate == 0 {
+		baudRate = 115200
+	}
+	glog.Infof("Opening %s @ %d...", portName, baudRate)
 	s, err := serial.Open(serial.OpenOptions{
 		PortName:              portName,
-		BaudRate:              115200,
+		BaudRate:              baudRate,
 		DataBits:              8,
 		ParityMode:            serial.PARITY_NONE,
 		StopBits:              1,
@@ -112,12 +112,8 @@ func Serve(ctx context.Context, c codec.Codec) MgRPC {
 // wsDialConfig does the same thing as websocket.DialConfig, but also enables
 // TCP keep-alive.
 func wsDialConfig(config *websocket.Config) (*websocket.Conn, error) {
-	host, port, err := net.SplitHostPort(config.Location.Host)
-	if err != nil {
-		// Assuming that no port specified.
-		host = config.Location.Host
-		port = ""
-	}
+	// Hostname() strips brackets from IPv6 literals, keeping the zone if any
+	host, port := config.Location.Hostname(), config.Location.Port()
 
 	switch config.Location.Scheme {
 	case "ws":
@@ -131,7 +127,20 @@ func wsDialConfig(config *websocket.Config) (*websocket.Conn, error) {
 	default:
 		return nil, errors.Trace(websocket.ErrBadScheme)
 	}
-	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+	targetAddr := net.JoinHostPort(host, port)
+
+	// If there is a proxy, connect to it instead, and then ask it to connect
+	// to the target
+	proxyURL, err := getProxyURL(config.Location)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid proxy")
+	}
+	dialAddr := targetAddr
+	if proxyURL != nil {
+		dialAddr = proxyAddr(proxyURL)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", dialAddr)
 	if err != nil {
 		return nil, errors.Annotate(err, "net.ResolveTCPAddr")
 	}
@@ -143,6 +152,16 @@ func wsDialConfig(config *websocket.Config) (*websocket.Conn, error) {
 	tc.SetKeepAlivePeriod(tcpKeepAliveInterval)
 	var nc net.Conn = tc
 
+	if proxyURL != nil {
+		if proxyURL.Scheme == "https" {
+			nc = tls.Client(nc, &tls.Config{ServerName: proxyURL.Hostname()})
+		}
+		if err := proxyConnect(nc, proxyURL, targetAddr); err != nil {
+			nc.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+
 	if config.Location.Scheme == "wss" {
 		nc = tls.Client(nc, config.TlsConfig)
 	}
@@ -0,0 +1,67 @@
+package mgrpc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"cesanta.com/common/go/mgrpc/codec"
+	"github.com/cesanta/errors"
+)
+
+// getProxyURL returns the proxy which should be used to connect to the given
+// websocket URL, as configured by HTTP_PROXY, HTTPS_PROXY and NO_PROXY, or nil
+// if the connection should be direct; see codec.ProxyFromEnvironment.
+func getProxyURL(location *url.URL) (*url.URL, error) {
+	u := *location
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	return codec.ProxyFromEnvironment(&http.Request{URL: &u})
+}
+
+// proxyConnect asks the HTTP proxy, which conn is connected to, to establish
+// a tunnel to the given address.
+func proxyConnect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return errors.Annotatef(err, "sending CONNECT to proxy %s", proxyURL.Host)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return errors.Annotatef(err, "reading CONNECT response from proxy %s", proxyURL.Host)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	return nil
+}
+
+func proxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	port := "80"
+	if proxyURL.Scheme == "https" {
+		port = "443"
+	}
+	return fmt.Sprintf("%s:%s", proxyURL.Hostname(), port)
+}
@@ -164,7 +164,7 @@ func (m *ourGitShell) Clone(srcURL, targetDir string, opts CloneOptions) error {
 
 	var berr bytes.Buffer
 	args = append(args, srcURL, targetDir)
-	cmd := exec.Command("git", args...)
+	cmd := exec.Command("git", gitArgs(args...)...)
 
 	// By default, when the user tries to clone non-existing repo, git will
 	// ask for username/password, just in case the repo exists but is private.
@@ -214,8 +214,18 @@ func (m *ourGitShell) HashesEqual(hash1, hash2 string) bool {
 	return hash1[:minLen] == hash2[:minLen]
 }
 
+// gitArgs returns the git command line with the global options: on Windows,
+// git doesn't handle paths longer than MAX_PATH unless core.longpaths is set,
+// and lib checkouts are often nested deep enough for that.
+func gitArgs(args ...string) []string {
+	if runtime.GOOS == "windows" {
+		return append([]string{"-c", "core.longpaths=true"}, args...)
+	}
+	return args
+}
+
 func shellGit(localDir string, subcmd string, args ...string) (string, error) {
-	cmd := exec.Command("git", append([]string{subcmd}, args...)...)
+	cmd := exec.Command("git", gitArgs(append([]string{subcmd}, args...)...)...)
 
 	var b bytes.Buffer
 	var berr bytes.Buffer
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cesanta.com/common/go/mgrpc/codec"
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/farm"
+	"github.com/cesanta/errors"
+	"github.com/cesanta/go-serial/serial"
+	"github.com/golang/glog"
+	"github.com/kardianos/osext"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	agentAddr    = flag.String("agent-addr", fmt.Sprintf(":%d", farm.DefaultPort), "mos agent: address to listen on")
+	agentToken   = flag.String("agent-token", "", "mos agent: access token required from clients")
+	agentDevices = flag.StringSlice("agent-devices", nil, "mos agent: devices to serve, as id=port. If not given, registered devices are served (see mos devices and --select)")
+	agentUsers   = flag.String("agent-users", "", "mos agent: YAML file with user names and their tokens, as user: token. Clients are identified by the token")
+	agentLog     = flag.String("agent-log", "~/.mos/agent.log", "mos agent: usage log file")
+	agentTLSCert = flag.String("agent-tls-cert", "", "mos agent: TLS certificate file. If set, devices are served over HTTPS, to be used with --port farms://")
+	agentTLSKey  = flag.String("agent-tls-key", "", "mos agent: TLS private key file")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "agent-addr", "agent-token", "agent-devices", "agent-users", "agent-log", "agent-tls-cert", "agent-tls-key")
+}
+
+type deviceAgent struct {
+	ports map[string]string
+	// Token -> user name
+	users map[string]string
+	locks *farm.Locks
+	usage *farm.UsageLog
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func loadAgentUsers() (map[string]string, error) {
+	if *agentUsers == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*agentUsers)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var tokens map[string]string
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.Annotatef(err, "%s", *agentUsers)
+	}
+	users := map[string]string{}
+	for user, token := range tokens {
+		if token == "" {
+			return nil, errors.Errorf("%s: empty token of %s", *agentUsers, user)
+		}
+		users[token] = user
+	}
+	return users, nil
+}
+
+func getAgentPorts() (map[string]string, error) {
+	ports := map[string]string{}
+	if len(*agentDevices) > 0 {
+		for _, d := range *agentDevices {
+			parts := strings.SplitN(d, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, errors.Errorf("invalid --agent-devices entry %q, expected id=port", d)
+			}
+			ports[parts[0]] = parts[1]
+		}
+		return ports, nil
+	}
+	devices, err := selectDevices()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, d := range devices {
+		if d.Port != "" && !strings.Contains(d.Port, "://") {
+			ports[d.Name] = d.Port
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.Errorf("no devices to serve, use --agent-devices or register serial devices with mos devices add")
+	}
+	return ports, nil
+}
+
+// agentHandler serves devices attached to this machine to remote mos
+// clients, which use --port farm://host/device-id (see the farm package).
+func agentHandler(ctx context.Context, devConn *dev.DevConn) error {
+	ports, err := getAgentPorts()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	users, err := loadAgentUsers()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Devices can be reflashed through the agent, so anyone on the network
+	// must not be able to use them
+	noAuth := users == nil && *agentToken == ""
+	if noAuth && !isLoopbackAddr(*agentAddr) {
+		return errors.Errorf("refusing to serve devices on %s without authentication: "+
+			"use --agent-token or --agent-users, or listen on a loopback address, like --agent-addr 127.0.0.1:%d",
+			*agentAddr, farm.DefaultPort)
+	}
+	if (*agentTLSCert == "") != (*agentTLSKey == "") {
+		return errors.Errorf("both --agent-tls-cert and --agent-tls-key are required")
+	}
+	logFile, err := paths.NormalizePath(*agentLog, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	lf, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer lf.Close()
+	a := &deviceAgent{
+		ports: ports,
+		users: users,
+		locks: farm.NewLocks(),
+		usage: farm.NewUsageLog(lf),
+		busy:  map[string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", a.handleList)
+	mux.HandleFunc("/devices/", a.handleDevice)
+	var handler http.Handler = mux
+	if noAuth {
+		handler = localOnlyHandler(mux)
+	}
+	for id, port := range ports {
+		ourutil.Reportf("Serving %s (%s)", id, port)
+	}
+	ourutil.Reportf("Listening on %s, usage log: %s", *agentAddr, logFile)
+	if *agentTLSCert != "" {
+		return errors.Trace(http.ListenAndServeTLS(*agentAddr, *agentTLSCert, *agentTLSKey, handler))
+	}
+	if !noAuth && !isLoopbackAddr(*agentAddr) {
+		ourutil.Reportf("Warning: tokens are sent in the clear, use --agent-tls-cert and --agent-tls-key to serve over HTTPS")
+	}
+	return errors.Trace(http.ListenAndServe(*agentAddr, handler))
+}
+
+// localOnlyHandler guards the agent which serves devices without
+// authentication on a loopback address from web pages opened in a browser on
+// this machine: the request must be made to a loopback host, and not from
+// another origin. mos clients send the origin of the agent itself (see
+// farm.Port.Origin).
+func localOnlyHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The host is checked to prevent DNS rebinding
+		if !isLoopbackHost(r.Host) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the name of the user making the request: identified
+// by the token if --agent-users is given, or as claimed by the client
+// otherwise (if the shared token, if any, matches). Claimed names are only
+// good for the usage log, locks need users identified by their tokens.
+func (a *deviceAgent) authenticate(r *http.Request) (string, bool) {
+	t := r.URL.Query().Get(farm.TokenParam)
+	if t == "" {
+		t = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if a.users != nil {
+		for token, user := range a.users {
+			if secureEqual(t, token) {
+				return user, true
+			}
+		}
+		return "", false
+	}
+	if *agentToken != "" && !secureEqual(t, *agentToken) {
+		return "", false
+	}
+	user := r.URL.Query().Get(farm.UserParam)
+	if user == "" {
+		user = "anonymous"
+	}
+	return user, true
+}
+
+// acquire marks the device as busy, since its port can only be used by one
+// client at a time.
+func (a *deviceAgent) acquire(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.busy[id] {
+		return false
+	}
+	a.busy[id] = true
+	return true
+}
+
+func (a *deviceAgent) release(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.busy, id)
+}
+
+func (a *deviceAgent) handleList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.authenticate(r); !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var res []farm.DeviceStatus
+	for _, id := range sortedKeys(a.ports) {
+		st := farm.DeviceStatus{ID: id}
+		a.mu.Lock()
+		st.Busy = a.busy[id]
+		a.mu.Unlock()
+		if lk := a.locks.Get(id); lk != nil {
+			st.LockedBy, st.LockedUntil = lk.Owner, lk.Until
+		}
+		res = append(res, st)
+	}
+	data, _ := json.Marshal(res)
+	// Checked by the client with download.VerifyDigestHeader
+	digest := sha256.Sum256(data)
+	w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func sortedKeys(m map[string]string) []string {
+	var res []string
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (a *deviceAgent) handleDevice(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/devices/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, what := parts[0], parts[1]
+	port, ok := a.ports[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown device %q", id), http.StatusNotFound)
+		return
+	}
+
+	start := time.Now()
+	err := a.serveDevice(w, r, user, id, port, what)
+	e := &farm.UsageEntry{
+		Time:     start,
+		User:     user,
+		Remote:   r.RemoteAddr,
+		Device:   id,
+		Action:   what,
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		glog.Errorf("%s: %s: %s", id, what, err)
+	}
+	if err := a.usage.Log(e); err != nil {
+		glog.Errorf("failed to write usage log: %s", err)
+	}
+}
+
+func (a *deviceAgent) serveDevice(w http.ResponseWriter, r *http.Request, user, id, port, what string) error {
+	glog.Infof("%s: %s %s by %s from %s", id, r.Method, what, user, r.RemoteAddr)
+
+	switch what {
+	case "lock", "unlock":
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return errors.Errorf("method %s not allowed", r.Method)
+		}
+		if a.users == nil {
+			http.Error(w, "locks need per-user tokens, see --agent-users", http.StatusForbidden)
+			return errors.Errorf("locks need per-user tokens")
+		}
+		var err error
+		if what == "lock" {
+			var ttl time.Duration
+			if ttl, err = time.ParseDuration(r.URL.Query().Get("ttl")); err == nil {
+				var lk *farm.Lock
+				if lk, err = a.locks.Lock(id, user, ttl); err == nil {
+					fmt.Fprintf(w, "%s is locked by %s until %s\n", id, user, lk.Until.Format(time.RFC3339))
+				}
+			}
+		} else {
+			if err = a.locks.Unlock(id, user, r.URL.Query().Get("force") != ""); err == nil {
+				fmt.Fprintf(w, "%s is unlocked\n", id)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+		return errors.Trace(err)
+	}
+
+	if err := a.locks.Check(id, user); err != nil {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return errors.Trace(err)
+	}
+	if !a.acquire(id) {
+		http.Error(w, fmt.Sprintf("device %q is busy", id), http.StatusConflict)
+		return errors.Errorf("device is busy")
+	}
+	defer a.release(id)
+
+	var err error
+	switch what {
+	case "rpc":
+		websocket.Handler(func(ws *websocket.Conn) {
+			err = agentRelayRPC(r.Context(), ws, port)
+		}).ServeHTTP(w, r)
+	case "console":
+		rate := baudRate
+		if b := r.URL.Query().Get("baud"); b != "" {
+			v, perr := strconv.ParseUint(b, 10, 32)
+			if perr != nil {
+				http.Error(w, "invalid baud rate", http.StatusBadRequest)
+				return errors.Errorf("invalid baud rate %q", b)
+			}
+			rate = uint(v)
+		}
+		websocket.Handler(func(ws *websocket.Conn) {
+			err = agentRelayConsole(ws, port, rate)
+		}).ServeHTTP(w, r)
+	case "flash":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST the firmware zip", http.StatusMethodNotAllowed)
+			return errors.Errorf("method %s not allowed", r.Method)
+		}
+		err = agentFlash(w, r, port)
+	default:
+		http.NotFound(w, r)
+		return errors.Errorf("unknown action")
+	}
+	return errors.Trace(err)
+}
+
+// agentRelayRPC relays RPC frames between the client and the device.
+func agentRelayRPC(ctx context.Context, ws *websocket.Conn, port string) error {
+	rate, err := getBaudRate(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc, err := codec.Serial(ctx, port, &codec.SerialCodecOptions{
+		BaudRate:             rate,
+		SendChunkSize:        16,
+		SendChunkDelay:       5 * time.Millisecond,
+		SetControlLines:      setControlLines,
+		InvertedControlLines: *invertedControlLines,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	wc := codec.WebSocket(ws)
+	defer sc.Close()
+	defer wc.Close()
+
+	errs := make(chan error, 2)
+	pump := func(from, to codec.Codec) {
+		for {
+			f, err := from.Recv(ctx)
+			if err == nil {
+				err = to.Send(ctx, f)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}
+	go pump(wc, sc)
+	go pump(sc, wc)
+	if err := <-errs; !codec.IsEOF(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// agentRelayConsole relays raw console data between the client and the
+// device.
+func agentRelayConsole(ws *websocket.Conn, port string, rate uint) error {
+	s, err := serial.Open(serial.OpenOptions{
+		PortName:        port,
+		BaudRate:        rate,
+		DataBits:        8,
+		ParityMode:      serial.PARITY_NONE,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		return errors.Annotatef(err, "failed to open %s", port)
+	}
+	if setControlLines || *invertedControlLines {
+		bFalse := *invertedControlLines
+		s.SetDTR(bFalse)
+		s.SetRTS(bFalse)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(ws, s)
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(s, ws)
+		errs <- err
+	}()
+	err = <-errs
+	s.Close()
+	ws.Close()
+	return errors.Trace(err)
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(data []byte) (int, error) {
+	n, err := fw.w.Write(data)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// agentFlash flashes the posted firmware by running mos flash, streaming its
+// output back to the client.
+func agentFlash(w http.ResponseWriter, r *http.Request, port string) error {
+	f, err := ioutil.TempFile("", "mos-agent-fw-")
+	if err == nil {
+		defer os.Remove(f.Name())
+		_, err = io.Copy(f, r.Body)
+		f.Close()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return errors.Trace(err)
+	}
+	exe, err := osext.Executable()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return errors.Trace(err)
+	}
+
+	w.Header().Set("Trailer", farm.StatusTrailer)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	args := []string{"flash", "--port", port, "--firmware", f.Name()}
+	if *invertedControlLines {
+		args = append(args, "--inverted-control-lines")
+	}
+	cmd := exec.Command(exe, args...)
+	out := flushWriter{w}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	status := "ok"
+	err = cmd.Run()
+	if err != nil {
+		status = fmt.Sprintf("flashing failed: %s", err)
+	}
+	w.Header().Set(farm.StatusTrailer, status)
+	return errors.Trace(err)
+}
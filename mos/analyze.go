@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"context"
+
+	"cesanta.com/mos/build/analyze"
+	"cesanta.com/mos/build/compiledb"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	analyzer     = flag.String("analyzer", analyze.Cppcheck, "mos analyze: cppcheck or clang-tidy")
+	analyzeLibs  = flag.StringSlice("analyze-lib", []string{}, "mos analyze: name of the lib whose sources are analyzed along with the app ones. Can be used multiple times.")
+	analyzeImage = flag.String("analyze-image", "", "mos analyze: container image with the analyzer to use instead of the build image pinned in mos.lock")
+	failOn       = flag.String("fail-on", "none", "mos analyze: fail if there are findings of this severity or above: error, warning, style, note or none")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "analyzer", "analyze-lib", "analyze-image", "fail-on")
+}
+
+// analyzeHandler implements "mos analyze": runs the analyzer in the build
+// container over the app sources, and those of the libs given with
+// --analyze-lib, with the compile flags from the compilation database of the
+// last local build.
+func analyzeHandler(ctx context.Context, devConn *dev.DevConn) error {
+	failLevel, err := analyze.ParseLevel(*failOn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	buildDir, err := filepath.Abs(moscommon.GetBuildDir(projectDir))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dbName := filepath.Join(buildDir, compiledb.FileName)
+	data, err := ioutil.ReadFile(dbName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s not found, run \"mos build --local --compile-commands\" first", dbName)
+		}
+		return errors.Trace(err)
+	}
+	var cmds []compiledb.Command
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return errors.Annotatef(err, "parsing %s", dbName)
+	}
+	cmds, err = selectAnalyzeCommands(cmds, buildDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cmds) == 0 {
+		return errors.Errorf("no app or selected lib sources in %s", dbName)
+	}
+
+	// The analyzer gets a database with the selected files only
+	dbDir := filepath.Join(buildDir, "analyze")
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	if data, err = compiledb.Marshal(cmds); err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dbDir, compiledb.FileName), data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+
+	var files []string
+	dirs := []string{dbDir}
+	for _, c := range cmds {
+		files = append(files, getPathForDocker(c.File))
+		dirs = append(dirs, c.Directory, filepath.Dir(c.File))
+		dirs = append(dirs, includeDirs(c.Arguments)...)
+	}
+	args, err := analyze.Args(*analyzer, getPathForDocker(dbDir), files)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	image, err := getToolchainImage(*analyzeImage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	reportf("Running %s over %d files...", *analyzer, len(cmds))
+	out, runErr := runToolchainCommand(image, mountRoots(dirs), dbDir, "", args)
+	findings, err := analyze.Parse(bytes.NewReader(out))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// clang-tidy exits with an error if there are error findings
+	if runErr != nil && len(findings) == 0 {
+		return errors.Annotatef(runErr, "%s failed (use --analyze-image to choose an image with it): %s", *analyzer, out)
+	}
+
+	for _, f := range findings {
+		if rel, err := filepath.Rel(projectDir, f.File); err == nil && !strings.HasPrefix(rel, "..") {
+			f.File = rel
+		}
+		fmt.Println(f.String())
+	}
+	reportf("%d findings, %d errors, %d warnings", len(findings),
+		analyze.Count(findings, analyze.LevelError),
+		analyze.Count(findings, analyze.LevelWarning)-analyze.Count(findings, analyze.LevelError))
+	if n := analyze.Count(findings, failLevel); n > 0 {
+		return errors.Errorf("%d findings of severity %s or above", n, *failOn)
+	}
+	return nil
+}
+
+// selectAnalyzeCommands returns the commands for the app sources, outside of
+// the build dir, and for sources of the libs given with --analyze-lib.
+func selectAnalyzeCommands(cmds []compiledb.Command, buildDir string) ([]compiledb.Command, error) {
+	appDir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	depsDir, err := filepath.Abs(getDepsDir(appDir))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	libs := map[string]bool{}
+	for _, l := range *analyzeLibs {
+		libs[l] = true
+	}
+	var res []compiledb.Command
+	for _, c := range cmds {
+		f := c.File
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(c.Directory, f)
+		}
+		switch {
+		case isUnderDir(f, depsDir):
+			// The first component is the lib dir, like "wifi" or "wifi-1.2"
+			rel, _ := filepath.Rel(depsDir, f)
+			name := strings.Split(filepath.ToSlash(rel), "/")[0]
+			for libDirSuffixRE.MatchString(name) {
+				name = libDirSuffixRE.ReplaceAllString(name, "")
+			}
+			if !libs[name] {
+				continue
+			}
+		case isUnderDir(f, buildDir) || !isUnderDir(f, appDir):
+			continue
+		}
+		c.File = f
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// includeDirs returns the include dirs given in the compiler arguments.
+func includeDirs(args []string) []string {
+	var res []string
+	for i, a := range args {
+		for _, opt := range []string{"-I", "-isystem", "-iquote"} {
+			if a == opt && i+1 < len(args) {
+				res = append(res, args[i+1])
+			} else if strings.HasPrefix(a, opt) && len(a) > len(opt) {
+				res = append(res, a[len(opt):])
+			}
+		}
+	}
+	return res
+}
+
+// mountRoots returns the existing absolute dirs among the given ones, except
+// the ones under others.
+func mountRoots(dirs []string) []string {
+	var abs []string
+	for _, d := range dirs {
+		if !filepath.IsAbs(d) {
+			continue
+		}
+		if st, err := os.Stat(d); err != nil || !st.IsDir() {
+			continue
+		}
+		abs = append(abs, filepath.Clean(d))
+	}
+	sort.Strings(abs)
+	var res []string
+next:
+	for _, d := range abs {
+		for _, r := range res {
+			if isUnderDir(d, r) {
+				continue next
+			}
+		}
+		res = append(res, d)
+	}
+	return res
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/build/arduinolib"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/download"
+	"github.com/cesanta/errors"
+)
+
+const (
+	arduinoIndexFile = "~/.mos/arduino/library_index.json.gz"
+	arduinoLibsDir   = "~/.mos/arduino/libs"
+)
+
+// readProjectArduinoLibs reads the Arduino libs from the manifest in the
+// project dir. If there is no manifest, nil is returned.
+func readProjectArduinoLibs() ([]build.ArduinoLib, error) {
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return manifest.ArduinoLibs, nil
+}
+
+// getArduinoIndex returns the Arduino library index, downloaded at most once
+// per --libs-update-interval. If it can't be downloaded, the stale copy is
+// used.
+func getArduinoIndex() (*arduinolib.Index, error) {
+	fname, err := paths.NormalizePath(arduinoIndexFile, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	st, err := os.Stat(fname)
+	if err != nil || time.Since(st.ModTime()) > *libsUpdateInterval {
+		freportf(logWriter, "Downloading the Arduino library index...")
+		data, derr := download.Get(arduinolib.IndexURL)
+		if derr == nil {
+			if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+				return nil, errors.Trace(err)
+			}
+		} else if err != nil {
+			return nil, errors.Annotatef(derr, "failed to download the Arduino library index")
+		} else {
+			freportf(logWriter, "Failed to download the Arduino library index, using the old one: %s", derr)
+		}
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return arduinolib.ParseIndex(data)
+}
+
+// prepareArduinoLibs fetches the Arduino libs of the project along with
+// their dependencies, copies them to targetDir, applies patches and runs
+// post_fetch commands, and returns the source files and include dirs to
+// build.
+func prepareArduinoLibs(targetDir string) (sources []string, includes []string, err error) {
+	libs, err := readProjectArduinoLibs()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if len(libs) == 0 {
+		return nil, nil, nil
+	}
+
+	var mods []build.SWModule
+	byName := map[string]*build.ArduinoLib{}
+	fromIndex := map[string]string{}
+	for i, l := range libs {
+		if l.Name == "" {
+			return nil, nil, errors.Errorf("arduino_libs: name is required")
+		}
+		byName[strings.ToLower(l.Name)] = &libs[i]
+		if l.Location != "" {
+			mods = append(mods, build.SWModule{
+				Type: "archive", Location: l.Location, Name: l.Name, Version: l.Version, SHA256: l.SHA256,
+			})
+		} else {
+			fromIndex[l.Name] = l.Version
+		}
+	}
+	if len(fromIndex) > 0 {
+		idx, err := getArduinoIndex()
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		rels, err := idx.Resolve(fromIndex)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		for _, r := range rels {
+			if l := byName[strings.ToLower(r.Name)]; l != nil && l.Location != "" {
+				// A dependency given explicitly
+				continue
+			}
+			mods = append(mods, build.SWModule{
+				Type: "archive", Location: r.URL, Name: r.Name, Version: r.Version, SHA256: r.SHA256(),
+			})
+		}
+	}
+
+	cacheDir, err := paths.NormalizePath(arduinoLibsDir, "")
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if err := os.RemoveAll(targetDir); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	for _, m := range mods {
+		name := m.Name
+		freportf(logWriter, "Arduino library %q: version %s", name, m.Version)
+		m.Name = arduinolib.DirName(name)
+		libDir, err := m.PrepareLocalDir(cacheDir, logWriter, true, "", *libsUpdateInterval, 0)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "preparing Arduino library %q", name)
+		}
+		dir := filepath.Join(targetDir, m.Name)
+		if err := ourio.CopyDir(libDir, dir, []string{build.ArchiveDigestFile, ".git", "examples", "extras"}); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if l := byName[strings.ToLower(name)]; l != nil {
+			if err := patchArduinoLib(l, dir); err != nil {
+				return nil, nil, errors.Annotatef(err, "Arduino library %q", name)
+			}
+		}
+		srcs, incs, err := arduinolib.Layout(dir)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		sources = append(sources, srcs...)
+		includes = append(includes, incs...)
+	}
+	return sources, includes, nil
+}
+
+// patchArduinoLib applies the patches of the lib copied to dir, and runs its
+// post_fetch commands there.
+func patchArduinoLib(l *build.ArduinoLib, dir string) error {
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The lib copy is inside the app repo, which git must not treat as the
+	// one the patch is for
+	env := append(os.Environ(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(dir))
+	for _, p := range l.Patches {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(appDir, p)
+		}
+		freportf(logWriter, "Applying %s", p)
+		cmd := exec.Command("git", "apply", p)
+		cmd.Dir = dir
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return errors.Annotatef(err, "failed to apply %s: %s", p, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, c := range l.PostFetch {
+		freportf(logWriter, "Running post_fetch command: %s", c)
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", c)
+		} else {
+			cmd = exec.Command("sh", "-c", c)
+		}
+		cmd.Dir = dir
+		cmd.Env = append(env, fmt.Sprintf("MOS_PROJECT_DIR=%s", appDir))
+		cmd.Stdout = logWriter
+		cmd.Stderr = logWriter
+		if err := cmd.Run(); err != nil {
+			return errors.Annotatef(err, "post_fetch command %q failed", c)
+		}
+	}
+	return nil
+}
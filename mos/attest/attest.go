@@ -0,0 +1,103 @@
+// Package attest checks device attestations before updates: the device is
+// identified by its device.id and the ID of the firmware it runs, as reported
+// by Sys.GetInfo, and, if it has an ATECC crypto chip, has to prove it holds
+// the enrolled key by signing a random challenge with ATCA.Sign. The
+// challenge keeps recorded signatures from being replayed.
+package attest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+
+	"cesanta.com/mos/download"
+	"github.com/cesanta/errors"
+)
+
+// SignMethod is the device RPC which signs the challenge with the ATECC key.
+const SignMethod = "ATCA.Sign"
+
+// SignArgs are the args of SignMethod.
+type SignArgs struct {
+	Slot int `json:"slot"`
+	// Base64-encoded 32-byte digest to sign.
+	Digest string `json:"digest"`
+}
+
+// SignResult is the result of SignMethod.
+type SignResult struct {
+	// Base64-encoded ECDSA P-256 signature: raw r and s, 32 bytes each, as
+	// the ATECC produces it, or ASN.1.
+	Signature string `json:"signature"`
+}
+
+// Identity is what the device reports about itself.
+type Identity struct {
+	// Value of device.id in the device config.
+	ID string
+	// fw_id of Sys.GetInfo, the build ID of the firmware.
+	FWID string
+}
+
+var (
+	// ErrUnknown means the device is not the one expected.
+	ErrUnknown = errors.New("unknown device")
+	// ErrTampered means the device runs an unexpected firmware or its
+	// signature is wrong.
+	ErrTampered = errors.New("device failed attestation")
+)
+
+// NewChallenge returns a random challenge for the device to sign.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return b, nil
+}
+
+// NewSignArgs returns the args of SignMethod for the challenge: the device
+// signs its SHA-256.
+func NewSignArgs(challenge []byte, slot int) *SignArgs {
+	digest := sha256.Sum256(challenge)
+	return &SignArgs{Slot: slot, Digest: base64.StdEncoding.EncodeToString(digest[:])}
+}
+
+// Check checks that the device has the given ID and runs one of the given
+// firmware builds.
+func Check(ident *Identity, id string, fwIDs []string) error {
+	if ident.ID != id {
+		return errors.Annotatef(ErrUnknown, "expected ID %q, got %q", id, ident.ID)
+	}
+	for _, fwID := range fwIDs {
+		if fwID == ident.FWID {
+			return nil
+		}
+	}
+	return errors.Annotatef(ErrTampered, "unexpected firmware %q", ident.FWID)
+}
+
+// CheckSignature checks the signature of the challenge, as returned by
+// SignMethod, with the given PEM-encoded public key.
+func CheckSignature(challenge []byte, r *SignResult, pubKeyPEM string) error {
+	if r.Signature == "" {
+		return errors.Annotatef(ErrTampered, "challenge is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return errors.Annotatef(ErrTampered, "invalid signature")
+	}
+	if len(sig) == 64 {
+		if sig, err = asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]),
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := download.VerifySignature(challenge, sig, pubKeyPEM); err != nil {
+		return errors.Annotatef(ErrTampered, "%s", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package attest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/cesanta/errors"
+)
+
+func TestCheck(t *testing.T) {
+	ident := &Identity{ID: "esp32_123456", FWID: "20180101-120000/master@abcd"}
+	fwIDs := []string{"20180101-120000/master@abcd", "20180202-120000/master@ef01"}
+	if err := Check(ident, "esp32_123456", fwIDs); err != nil {
+		t.Errorf("valid identity: %s", err)
+	}
+	if err := Check(ident, "esp32_654321", fwIDs); errors.Cause(err) != ErrUnknown {
+		t.Errorf("expected ErrUnknown, got %v", err)
+	}
+	if err := Check(ident, "esp32_123456", fwIDs[1:]); errors.Cause(err) != ErrTampered {
+		t.Errorf("expected ErrTampered for another firmware, got %v", err)
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sign the digest like the ATECC does, returning raw r and s
+	args := NewSignArgs(challenge, 0)
+	digest, err := base64.StdEncoding.DecodeString(args.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, ss, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, 64)
+	rb, sb := sr.Bytes(), ss.Bytes()
+	copy(raw[32-len(rb):32], rb)
+	copy(raw[64-len(sb):], sb)
+	r := &SignResult{Signature: base64.StdEncoding.EncodeToString(raw)}
+
+	if err := CheckSignature(challenge, r, pub); err != nil {
+		t.Errorf("valid signature: %s", err)
+	}
+	// Replayed signature
+	other, _ := NewChallenge()
+	if err := CheckSignature(other, r, pub); errors.Cause(err) != ErrTampered {
+		t.Errorf("expected ErrTampered for another challenge, got %v", err)
+	}
+	if err := CheckSignature(challenge, &SignResult{}, pub); errors.Cause(err) != ErrTampered {
+		t.Errorf("expected ErrTampered for a missing signature, got %v", err)
+	}
+}
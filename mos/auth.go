@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/credstore"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/rpccreds"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	credsStoreFlag = flag.String("creds-store", "auto", `Where to keep RPC credentials saved with "mos auth login": "auto" for the OS keychain if available, or "file" for an encrypted file in ~/.mos`)
+)
+
+const (
+	credsFile    = "~/.mos/rpc-creds"
+	credsKeyFile = "~/.mos/rpc-creds.key"
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "creds-store")
+}
+
+func getCredsStore() (credstore.Store, error) {
+	fname, err := paths.NormalizePath(credsFile, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	keyFname, err := paths.NormalizePath(credsKeyFile, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch *credsStoreFlag {
+	case "auto":
+		return credstore.New(fname, keyFname), nil
+	case "file":
+		return credstore.NewFileStore(fname, keyFname), nil
+	default:
+		return nil, errors.Errorf("invalid --creds-store %q, expected auto or file", *credsStoreFlag)
+	}
+}
+
+// setRPCCredsLookup makes the credentials saved for the port be used when
+// the device asks for authentication and --rpc-creds is not given. The store
+// is only looked at then, so that the keychain doesn't ask for access when
+// it's not needed.
+func setRPCCredsLookup(port string) {
+	rpccreds.Lookup = func() (string, error) {
+		s, err := getCredsStore()
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		creds, err := s.Get(port)
+		if errors.Cause(err) == credstore.ErrNotFound {
+			return "", errors.Errorf("the device requires authentication: run \"mos auth login %s\" or use --rpc-creds", port)
+		} else if err != nil {
+			return "", errors.Trace(err)
+		}
+		return creds, nil
+	}
+}
+
+func authHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: login or logout")
+	}
+	if len(args) != 2 {
+		return errors.Errorf("usage: mos auth %s <device>", args[0])
+	}
+	// Devices are stored by port, the way they are connected to
+	port := args[1]
+	if p := lookupDevicePort(port); p != "" {
+		port = p
+	}
+	s, err := getCredsStore()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch args[0] {
+	case "login":
+		return errors.Trace(authLogin(ctx, s, port))
+	case "logout":
+		if err := s.Delete(port); err != nil {
+			return errors.Annotatef(err, "failed to remove the credentials for %s", port)
+		}
+		reportf("Removed the credentials for %s from %s", port, s.Name())
+		return nil
+	default:
+		return errors.Errorf("unknown command %q, expected login or logout", args[0])
+	}
+}
+
+// authLogin checks the credentials, from --rpc-creds or typed in, with the
+// device and saves them.
+func authLogin(ctx context.Context, s credstore.Store, port string) error {
+	var username, passwd string
+	if flag.Lookup("rpc-creds").Changed {
+		var err error
+		if username, passwd, err = rpccreds.GetRPCCreds(); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		const hint = "give the credentials with --rpc-creds"
+		var err error
+		if username, err = prompt("Username:", hint); err != nil {
+			return errors.Trace(err)
+		}
+		if passwd, err = promptPassword("Password:", hint); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if username == "" {
+		return errors.Errorf("username is required")
+	}
+	if strings.Contains(username, ":") || strings.Contains(passwd, ":") {
+		return errors.Errorf("credentials can't contain \":\"")
+	}
+	creds := username + ":" + passwd
+	flag.Set("rpc-creds", creds)
+
+	reportf("Checking the credentials with %s...", port)
+	devConn, err := createDevConnForPort(ctx, port, func(junk []byte) {}, func(topic string, data []byte) {})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+	if _, err := devConn.GetInfo(ctx); err != nil {
+		return errors.Annotatef(err, "failed to talk to the device with these credentials")
+	}
+
+	if err := s.Set(port, creds); err != nil {
+		return errors.Annotatef(err, "failed to save the credentials")
+	}
+	reportf("Credentials for %s saved to %s", port, s.Name())
+	return nil
+}
@@ -119,10 +119,20 @@ func checkDevConfig(ctx context.Context, devConn *dev.DevConn, devConf *dev.DevC
 }
 
 func getSvc() (*iot.IoT, error) {
-	sess, err := session.NewSession()
+	sess, cfg, err := getAwsConfig()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	return iot.New(sess, cfg), nil
+}
+
+// getAwsConfig returns the AWS session and config with the region and
+// credentials, asking for the latter if needed.
+func getAwsConfig() (*session.Session, *aws.Config, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
 	cfg := defaults.Get().Config
 
 	if awsRegion == "" {
@@ -130,7 +140,7 @@ func getSvc() (*iot.IoT, error) {
 		if err != nil {
 			if cfg.Region == nil || *cfg.Region == "" {
 				reportf("Failed to get default AWS region, please specify --aws-region")
-				return nil, errors.New("AWS region not specified")
+				return nil, nil, errors.New("AWS region not specified")
 			} else {
 				awsRegion = *cfg.Region
 			}
@@ -146,15 +156,15 @@ func getSvc() (*iot.IoT, error) {
 	if err != nil {
 		// In UI mode, UI credentials are acquired in a different way.
 		if isUI {
-			return nil, errors.Trace(err)
+			return nil, nil, errors.Trace(err)
 		}
 		creds, err = askForCreds()
 		if err != nil {
-			return nil, errors.Annotatef(err, "bad AWS credentials")
+			return nil, nil, errors.Annotatef(err, "bad AWS credentials")
 		}
 	}
 	cfg.Credentials = creds
-	return iot.New(sess, cfg), nil
+	return sess, cfg, nil
 }
 
 func getAwsCredentials() (*credentials.Credentials, error) {
@@ -433,12 +443,22 @@ func askForCreds() (*credentials.Credentials, error) {
 	reportf("\r\nAWS credentials are missing. If this is the first time you are running this tool,\r\n" +
 		"you will need to obtain AWS credentials from the AWS console as explained here:\r\n" +
 		"  http://docs.aws.amazon.com/cli/latest/userguide/cli-chap-getting-set-up.html\r\n")
-	yn := prompt("Would you like to enter them now [y/N]?")
-	if strings.ToUpper(yn) != "Y" {
+	const hint = "set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or put them to ~/.aws/credentials"
+	yes, err := confirm("Would you like to enter them now", hint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !yes {
 		return nil, errors.New("user declined to enter creds")
 	}
-	ak := prompt("Access Key ID:")
-	sak := prompt("Secret Access Key:")
+	ak, err := prompt("Access Key ID:", hint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sak, err := prompt("Secret Access Key:", hint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	return storeCreds(ak, sak)
 }
 
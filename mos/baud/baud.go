@@ -0,0 +1,115 @@
+// Package baud detects the baud rate of a serial console by looking at the
+// output received at different rates: at the wrong rate, the output is
+// garbage; at the right one, it's lines of text.
+package baud
+
+import (
+	"io"
+	"time"
+
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+)
+
+// CommonRates are the rates tried by default, most likely first. 74880 is
+// what the ESP8266 ROM uses.
+var CommonRates = []uint{115200, 921600, 460800, 230400, 74880, 57600, 38400, 19200, 9600}
+
+const (
+	// Enough output to tell text from garbage.
+	sampleSize = 128
+	minScore   = 0.95
+	maxLineLen = 512
+)
+
+// Port is a serial port whose speed can be changed.
+type Port interface {
+	io.Reader
+	SetBaudRate(rate uint) error
+}
+
+// Score returns the fraction of the data that looks like log output:
+// printable ASCII, tabs and line endings.
+func Score(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	n := 0
+	for _, c := range data {
+		if (c >= 0x20 && c < 0x7f) || c == '\n' || c == '\r' || c == '\t' || c == 0x1b {
+			n++
+		}
+	}
+	return float64(n) / float64(len(data))
+}
+
+// IsValidOutput returns true if the data is framed as log output: mostly
+// text, split into lines of reasonable length.
+func IsValidOutput(data []byte) bool {
+	if len(data) < sampleSize || Score(data) < minScore {
+		return false
+	}
+	lineLen, numLines := 0, 0
+	for _, c := range data {
+		if c == '\n' {
+			numLines++
+			lineLen = 0
+			continue
+		}
+		lineLen++
+		if lineLen > maxLineLen {
+			return false
+		}
+	}
+	return numLines > 0
+}
+
+// Detect tries the rates in order, reading the output for up to sampleTime at
+// each, and returns the first rate at which the output is valid. Reads from
+// the port must not block for longer than sampleTime.
+func Detect(p Port, rates []uint, sampleTime time.Duration) (uint, error) {
+	gotData := false
+	for _, rate := range rates {
+		if err := p.SetBaudRate(rate); err != nil {
+			glog.Infof("%d: %s", rate, err)
+			continue
+		}
+		data, err := sample(p, sampleTime)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		glog.V(1).Infof("%d: %d bytes, score %.2f", rate, len(data), Score(data))
+		if IsValidOutput(data) {
+			return rate, nil
+		}
+		if len(data) > 0 {
+			gotData = true
+		}
+	}
+	if !gotData {
+		return 0, errors.Errorf("no output from the device, try resetting it")
+	}
+	return 0, errors.Errorf("no valid output at any of %v", rates)
+}
+
+// sample reads the output for up to sampleTime, returning early once there
+// is enough of it to make a decision.
+func sample(p Port, sampleTime time.Duration) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(sampleTime)
+	for time.Now().Before(deadline) {
+		n, err := p.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil && err != io.EOF {
+			return nil, errors.Trace(err)
+		}
+		if len(data) >= 4*sampleSize {
+			break
+		}
+		if n == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return data, nil
+}
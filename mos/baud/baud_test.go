@@ -0,0 +1,63 @@
+package baud
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type fakePort struct {
+	actual  uint
+	current uint
+	log     *bytes.Buffer
+}
+
+func (p *fakePort) SetBaudRate(rate uint) error {
+	p.current = rate
+	return nil
+}
+
+func (p *fakePort) Read(buf []byte) (int, error) {
+	if p.current != p.actual {
+		rand.Read(buf)
+		return len(buf), nil
+	}
+	if p.log.Len() == 0 {
+		for i := 0; i < 10; i++ {
+			p.log.WriteString("[Jan 1 00:00:00.123] mgos_init  Init done, RAM: 12345 free\r\n")
+		}
+	}
+	return p.log.Read(buf)
+}
+
+func TestDetect(t *testing.T) {
+	for _, rate := range []uint{115200, 74880, 9600} {
+		p := &fakePort{actual: rate, log: &bytes.Buffer{}}
+		got, err := Detect(p, CommonRates, time.Second)
+		if err != nil {
+			t.Errorf("%d: %s", rate, err)
+			continue
+		}
+		if got != rate {
+			t.Errorf("got %d, want %d", got, rate)
+		}
+	}
+
+	p := &fakePort{actual: 1234, log: &bytes.Buffer{}}
+	if _, err := Detect(p, CommonRates, 100*time.Millisecond); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestIsValidOutput(t *testing.T) {
+	if IsValidOutput(bytes.Repeat([]byte("a"), 1000)) {
+		t.Errorf("output without line breaks is not valid")
+	}
+	if IsValidOutput([]byte("short\n")) {
+		t.Errorf("too short output is not valid")
+	}
+	if !IsValidOutput(bytes.Repeat([]byte("some text\n"), 20)) {
+		t.Errorf("expected valid output")
+	}
+}
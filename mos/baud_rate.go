@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cesanta.com/mos/baud"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	"github.com/cesanta/go-serial/serial"
+	flag "github.com/spf13/pflag"
+)
+
+const rpcUARTBaudRateKey = "rpc.uart.baud_rate"
+
+var (
+	baudDetectTime = flag.Duration("baud-detect-time", 2*time.Second, "How long to listen at each baud rate when detecting it")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "baud-detect-time")
+}
+
+func openSerialForDetection(port string) (serial.Serial, error) {
+	s, err := serial.Open(serial.OpenOptions{
+		PortName:              port,
+		BaudRate:              baud.CommonRates[0],
+		DataBits:              8,
+		ParityMode:            serial.PARITY_NONE,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+		MinimumReadSize:       0,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to open %s", port)
+	}
+	if setControlLines || *invertedControlLines {
+		bFalse := *invertedControlLines
+		s.SetDTR(bFalse)
+		s.SetRTS(bFalse)
+	}
+	return s, nil
+}
+
+// getBaudRate returns the baud rate to talk to the device on the given serial
+// port at: the one given with --baud-rate or, if it's 0, the detected one.
+// The detected rate is remembered for subsequent connections.
+func getBaudRate(port string) (uint, error) {
+	if baudRate != 0 {
+		return baudRate, nil
+	}
+	s, err := openSerialForDetection(port)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.Close()
+	reportf("Detecting baud rate of %s...", port)
+	rate, err := baud.Detect(s, baud.CommonRates, *baudDetectTime)
+	if err != nil {
+		return 0, errors.Annotatef(err, "failed to detect baud rate, use --baud-rate")
+	}
+	reportf("Detected %d", rate)
+	baudRate = rate
+	return rate, nil
+}
+
+// baudRateHandler shows the detected baud rate of the device or, if a rate is
+// given, switches the device to it via RPC. If the device doesn't respond at
+// the new rate, the old one is restored.
+func baudRateHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch len(args) {
+	case 0:
+		baudRate = 0
+		if _, err := getBaudRate(port); err != nil {
+			return errors.Trace(err)
+		}
+		return nil
+	case 1:
+	default:
+		return errors.Errorf("usage: mos baud-rate [<rate>]")
+	}
+	rate, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil || rate == 0 {
+		return errors.Errorf("invalid baud rate %q", args[0])
+	}
+	newRate := uint(rate)
+	oldRate, err := getBaudRate(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if newRate == oldRate {
+		reportf("Already at %d", newRate)
+		return nil
+	}
+
+	// Make sure the host side supports the rate before switching the device.
+	s, err := openSerialForDetection(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = s.SetBaudRate(newRate)
+	s.Close()
+	if err != nil {
+		return errors.Annotatef(err, "%s doesn't support %d", port, newRate)
+	}
+
+	if err := setDeviceBaudRate(ctx, oldRate, newRate); err != nil {
+		return errors.Trace(err)
+	}
+	reportf("Checking the device at %d...", newRate)
+	if err := pingDevice(ctx, newRate); err == nil {
+		reportf("Switched to %d, use --baud-rate %d from now on", newRate, newRate)
+		return nil
+	}
+	reportf("The device doesn't respond at %d, reverting to %d...", newRate, oldRate)
+	if err := pingDevice(ctx, oldRate); err == nil {
+		// The setting didn't take effect, make sure it's not left there.
+		if err := setDeviceBaudRate(ctx, oldRate, oldRate); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Errorf("the device didn't switch to %d, staying at %d", newRate, oldRate)
+	}
+	return errors.Errorf("the device doesn't respond at %d or %d, use --baud-rate 0 to detect its rate", newRate, oldRate)
+}
+
+// setDeviceBaudRate connects at the current rate and sets the RPC UART rate
+// to the new one, rebooting the device.
+func setDeviceBaudRate(ctx context.Context, curRate, newRate uint) error {
+	baudRate = curRate
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := devConf.Set(rpcUARTBaudRateKey, strconv.Itoa(int(newRate))); err != nil {
+		return errors.Annotatef(err, "the firmware doesn't support changing the baud rate")
+	}
+	return errors.Trace(configSetAndSave(ctx, devConn, devConf))
+}
+
+func pingDevice(ctx context.Context, rate uint) error {
+	baudRate = rate
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+	ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = devConn.GetInfo(ctx2)
+	return errors.Trace(err)
+}
@@ -52,6 +52,7 @@
 // web_root/page_cloud.html
 // web_root/page_code.html
 // web_root/page_configuration.html
+// web_root/page_devices.html
 // web_root/page_docs.html
 // web_root/page_examples.html
 // web_root/page_files.html
@@ -3538,6 +3539,7 @@ var _web_rootIndexHtml = []byte(`<!DOCTYPE html>
                 <li><a tab="configuration"><i class="fa fa-gears"></i> Device&nbsp;Config </a></li>
                 <li><a tab="rpc"><i class="fa fa-puzzle-piece"></i>Device&nbsp;Services</a></li>
                 <li><a tab="terminal"><i class="fa fa-terminal"></i> Terminal</a></li>
+                <li><a tab="devices"><i class="fa fa-sitemap"></i> Devices</a></li>
 <!-- 
                 <li class="">&nbsp;</li>
                 <li class="">&nbsp;</li>
@@ -3823,7 +3825,7 @@ func web_rootIndexHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "web_root/index.html", size: 14638, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
+	info := bindataFileInfo{name: "web_root/index.html", size: 14722, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -4126,7 +4128,9 @@ var _web_rootJsDashJs = []byte(`var ui = {
   version: 'latest',
   maxLogMessages: 999,
   apps: {},
-  pageCache: {}
+  pageCache: {},
+  deviceLogs: {},     // Console output of the devices on the Devices page
+  onDeviceLog: null   // Set by the Devices page to show new output
 };
 
 PNotify.prototype.options.styling = 'fontawesome';
@@ -4568,6 +4572,16 @@ var addLog = function(msg, type) {
   if ($('#autoscroll').is(':checked')) el.scrollTop = el.scrollHeight;
 };
 
+// Console output of the devices managed on the Devices page is kept
+// separately for each device, and doesn't go to the main log.
+var addDeviceLog = function(port, msg) {
+  var log = (ui.deviceLogs[port] || '') + (msg || '');
+  var max = ui.maxLogMessages * 100;
+  if (log.length > max) log = log.substring(log.length - max);
+  ui.deviceLogs[port] = log;
+  if (ui.onDeviceLog) ui.onDeviceLog(port, msg || '');
+};
+
 // https://developer.mozilla.org/en/docs/Web/API/WindowBase64/Base64_encoding_and_decoding
 function b64enc(str) {
   return btoa(
@@ -4666,7 +4680,7 @@ func web_rootJsDashJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "web_root/js/dash.js", size: 17807, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
+	info := bindataFileInfo{name: "web_root/js/dash.js", size: 18367, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -5313,7 +5327,11 @@ var _web_rootJsWsJs = []byte(`(function($) {
     };
     ws.onmessage = function(ev) {
       var m = JSON.parse(ev.data || '');
-      addLog(m.data, m.cmd);
+      if (m.device) {
+        addDeviceLog(m.device, m.data);
+      } else {
+        addLog(m.data, m.cmd);
+      }
     };
     ws.onerror = function(ev) {
       console.log('error', ev);
@@ -5334,7 +5352,7 @@ func web_rootJsWsJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "web_root/js/ws.js", size: 599, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
+	info := bindataFileInfo{name: "web_root/js/ws.js", size: 686, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -6178,6 +6196,193 @@ func web_rootPage_configurationHtml() (*asset, error) {
 	return a, nil
 }
 
+var _web_rootPage_devicesHtml = []byte(`<div data-title="Devices" style="height: 100%;">
+  <div class="col-xs-3 main-left-column">
+    <div class="editor-navbar">
+      <button class="btn btn-sm btn-success" id="devices-refresh-button"><i class="fa fa-refresh"></i> Refresh</button>
+    </div>
+    <div class="list-group upcontrol" id="devices-list" style="padding: 0; margin: 0;"></div>
+  </div>
+  <div class="col-xs-9 main-right-column">
+    <ul class="nav nav-tabs" id="devices-tabs" style="margin-top: 5px;"></ul>
+    <div class="tab-content upcontrol" id="devices-tab-content"></div>
+  </div>
+</div>
+
+<div class="hidden" id="device-tab-template">
+  <div class="tab-pane" style="height: 100%;">
+    <div class="editor-navbar">
+      <button class="btn btn-sm btn-success device-connect-button"><i class="fa fa-plug"></i> Connect</button>
+      <button class="btn btn-sm btn-default device-disconnect-button"><i class="fa fa-times"></i> Disconnect</button>
+      &nbsp;&nbsp;|&nbsp;&nbsp;
+      <select class="device-project"></select>
+      <input class="device-arch" placeholder="arch" size="8" type="text">
+      <button class="btn btn-sm btn-info device-build-button"><i class="fa fa-cogs"></i> Build</button>
+      <button class="btn btn-sm btn-info device-flash-button"><i class="fa fa-bolt"></i> Flash</button>
+      &nbsp;&nbsp;|&nbsp;&nbsp;
+      <input class="device-method" placeholder="RPC method, e.g. Sys.GetInfo" type="text">
+      <button class="btn btn-sm btn-info device-call-button"><i class="fa fa-puzzle-piece"></i> Call</button>
+      <button class="btn btn-sm btn-default device-clear-button"><i class="fa fa-eraser"></i> Clear</button>
+    </div>
+    <pre class="device-console" style="height: 80%; overflow: auto;"></pre>
+  </div>
+</div>
+
+<script>
+  // Tab element ids can't contain port characters like "/" or ":"
+  var deviceTabId = function(port) {
+    return 'device-tab-' + port.replace(/[^\w-]/g, '_');
+  };
+
+  var deviceTab = function(port) {
+    return $('#' + deviceTabId(port));
+  };
+
+  var deviceTabPort = function(el) {
+    return $(el).closest('.tab-pane').attr('mos-port');
+  };
+
+  var deviceError = function(port, title) {
+    return function(xhr) {
+      var text = xhr.responseJSON ? xhr.responseJSON.error : 'internal error';
+      addDeviceLog(port, title + ': ' + text + '\n');
+      new PNotify({ title: port + ': ' + title, text: text, type: 'error' });
+    };
+  };
+
+  // Shows the device console in its own tab, creating one if needed
+  var openDeviceTab = function(port) {
+    if (deviceTab(port).length == 0) {
+      var id = deviceTabId(port);
+      var pane = $('#device-tab-template .tab-pane').clone()
+        .attr({ id: id, 'mos-port': port }).appendTo('#devices-tab-content');
+      $('<li/>').append($('<a data-toggle="tab"/>').attr('href', '#' + id).text(port))
+        .appendTo('#devices-tabs');
+      pane.find('.device-console').text(ui.deviceLogs[port] || '');
+      $.ajax({ url: '/list-projects', data: { type: 'app' } }).then(function(data) {
+        var sel = pane.find('.device-project').empty();
+        $.each(Object.keys(data.result || {}).sort(), function(i, k) {
+          $('<option/>').text(k).appendTo(sel);
+        });
+      });
+    }
+    $('#devices-tabs a[href="#' + deviceTabId(port) + '"]').tab('show');
+  };
+
+  var refreshDevices = function() {
+    return $.ajax({ url: '/devices/list', global: false }).then(function(data) {
+      var list = $('#devices-list').empty();
+      $.each(data.result || [], function(i, d) {
+        var icon = d.connected ? 'fa-circle text-success' : 'fa-circle-o';
+        $('<a href="#" class="list-group-item device"/>').attr('mos-port', d.port)
+          .append($('<i class="fa"/>').addClass(icon)).append(' ')
+          .append($('<span/>').text(d.port)).appendTo(list);
+      });
+    });
+  };
+
+  // Console output of the managed devices comes over the websocket with the
+  // "device" field set, see ws.js
+  ui.onDeviceLog = function(port, msg) {
+    var el = deviceTab(port).find('.device-console')[0];
+    if (!el) return;
+    $(el).append(document.createTextNode(msg));
+    el.scrollTop = el.scrollHeight;
+  };
+
+  $(document).off('click', '.list-group-item.device');
+  $(document).on('click', '.list-group-item.device', function() {
+    openDeviceTab($(this).attr('mos-port'));
+  });
+
+  $(document).off('click', '#devices-refresh-button');
+  $(document).on('click', '#devices-refresh-button', refreshDevices);
+
+  $(document).off('click', '.device-connect-button');
+  $(document).on('click', '.device-connect-button', function() {
+    var btn = $(this), port = deviceTabPort(this);
+    spin(btn);
+    $.ajax({ url: '/devices/connect', global: false, data: { port: port } }).then(function() {
+      return $.ajax({ url: '/devices/call', global: false, data: { port: port, method: 'Sys.GetInfo' } });
+    }).then(function(data) {
+      var arch = (data.result || {}).arch;
+      if (arch) deviceTab(port).find('.device-arch').val(arch);
+    }).fail(deviceError(port, 'Connect failed')).always(function() {
+      stopspin(btn);
+      refreshDevices();
+    });
+  });
+
+  $(document).off('click', '.device-disconnect-button');
+  $(document).on('click', '.device-disconnect-button', function() {
+    var port = deviceTabPort(this);
+    $.ajax({ url: '/devices/disconnect', global: false, data: { port: port } })
+      .fail(deviceError(port, 'Disconnect failed')).always(refreshDevices);
+  });
+
+  $(document).off('click', '.device-build-button');
+  $(document).on('click', '.device-build-button', function() {
+    var btn = $(this), port = deviceTabPort(this), tab = deviceTab(port);
+    var d = { app: tab.find('.device-project').val(), arch: tab.find('.device-arch').val() };
+    if (!d.app || !d.arch) {
+      new PNotify({ title: 'Need application and architecture selected', type: 'error' });
+      return;
+    }
+    spin(btn);
+    $.ajax({ url: '/app/build', global: false, data: d })
+      .fail(deviceError(port, 'Build failed')).always(function() { stopspin(btn); });
+  });
+
+  $(document).off('click', '.device-flash-button');
+  $(document).on('click', '.device-flash-button', function() {
+    var btn = $(this), port = deviceTabPort(this);
+    var d = { port: port, project: deviceTab(port).find('.device-project').val(), type: 'app' };
+    spin(btn);
+    addDeviceLog(port, 'Flashing ' + d.project + '...\n');
+    $.ajax({ url: '/devices/flash', global: false, data: d }).done(function() {
+      addDeviceLog(port, 'Flashed ' + d.project + '\n');
+    }).fail(deviceError(port, 'Flashing failed')).always(function() {
+      stopspin(btn);
+      refreshDevices();
+    });
+  });
+
+  $(document).off('click', '.device-call-button');
+  $(document).on('click', '.device-call-button', function() {
+    var port = deviceTabPort(this);
+    var method = deviceTab(port).find('.device-method').val();
+    if (!method) return;
+    $.ajax({ url: '/devices/call', global: false, data: { port: port, method: method } }).done(function(d) {
+      addDeviceLog(port, method + ': ' + JSON.stringify(d.result, null, '  ') + '\n');
+    }).fail(deviceError(port, method + ' failed'));
+  });
+
+  $(document).off('click', '.device-clear-button');
+  $(document).on('click', '.device-clear-button', function() {
+    var port = deviceTabPort(this);
+    ui.deviceLogs[port] = '';
+    deviceTab(port).find('.device-console').empty();
+  });
+
+  refreshDevices();
+</script>
+`)
+
+func web_rootPage_devicesHtmlBytes() ([]byte, error) {
+	return _web_rootPage_devicesHtml, nil
+}
+
+func web_rootPage_devicesHtml() (*asset, error) {
+	bytes, err := web_rootPage_devicesHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "web_root/page_devices.html", size: 7328, mode: os.FileMode(420), modTime: time.Unix(1, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _web_rootPage_docsHtml = []byte(`<div data-title="Mongoose OS Docs" style="height: 100%;">
 <iframe src="https://mongoose-os.com/docs/" width="100%" height="100%"></iframe>
 </div>
@@ -6758,6 +6963,7 @@ var _bindata = map[string]func() (*asset, error){
 	"web_root/page_cloud.html":                 web_rootPage_cloudHtml,
 	"web_root/page_code.html":                  web_rootPage_codeHtml,
 	"web_root/page_configuration.html":         web_rootPage_configurationHtml,
+	"web_root/page_devices.html":               web_rootPage_devicesHtml,
 	"web_root/page_docs.html":                  web_rootPage_docsHtml,
 	"web_root/page_examples.html":              web_rootPage_examplesHtml,
 	"web_root/page_files.html":                 web_rootPage_filesHtml,
@@ -6872,6 +7078,7 @@ var _bintree = &bintree{nil, map[string]*bintree{
 		"page_cloud.html":         &bintree{web_rootPage_cloudHtml, map[string]*bintree{}},
 		"page_code.html":          &bintree{web_rootPage_codeHtml, map[string]*bintree{}},
 		"page_configuration.html": &bintree{web_rootPage_configurationHtml, map[string]*bintree{}},
+		"page_devices.html":       &bintree{web_rootPage_devicesHtml, map[string]*bintree{}},
 		"page_docs.html":          &bintree{web_rootPage_docsHtml, map[string]*bintree{}},
 		"page_examples.html":      &bintree{web_rootPage_examplesHtml, map[string]*bintree{}},
 		"page_files.html":         &bintree{web_rootPage_filesHtml, map[string]*bintree{}},
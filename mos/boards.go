@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/boards"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	boardName = flag.String("board", "", "Board profile to use, like wemos-d1-mini, see mos boards")
+	boardsDir = flag.String("boards-dir", "~/.mos/boards", "Directory with user-defined board profiles")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "boards-dir")
+}
+
+func loadBoard(name string) (*boards.Board, error) {
+	dir, err := paths.NormalizePath(*boardsDir, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return boards.Get(dir, name)
+}
+
+// getBoard returns the board profile given with --board or, if fromManifest
+// is set, the one referenced by mos.yml in the project dir. Returns nil if
+// there is none.
+func getBoard(fromManifest bool) (*boards.Board, error) {
+	name := *boardName
+	if name == "" && fromManifest {
+		manifestPath := moscommon.GetManifestFilePath(projectDir)
+		if _, err := os.Stat(manifestPath); err == nil {
+			manifest, err := readProjectManifest()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			name = manifest.Board
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return loadBoard(name)
+}
+
+// applyBoard applies the board profile, if any: sets the platform, adds
+// build vars (which can still be overridden with MOS_BV_* or --build-var),
+// and sets up the reset strategy and flashing params unless given explicitly.
+func applyBoard(fromManifest bool) error {
+	b, err := getBoard(fromManifest)
+	if err != nil || b == nil {
+		return errors.Trace(err)
+	}
+	if *platform == "" {
+		flag.Set("platform", b.Platform)
+	} else if *platform != b.Platform {
+		return errors.Errorf("board %s is %s, but the platform is %s", b.Name, b.Platform, *platform)
+	}
+
+	boardBuildVarsSlice = boardBuildVars(b)
+
+	if f := flag.Lookup("inverted-control-lines"); !f.Changed {
+		*invertedControlLines = (b.Reset == boards.ResetInvertedDTRRTS)
+	}
+	applyBoardFlashOpts(b)
+	return nil
+}
+
+// boardBuildVars returns build vars of the board as NAME=VALUE, sorted.
+func boardBuildVars(b *boards.Board) []string {
+	vars := b.AllBuildVars()
+	var res []string
+	for name, value := range vars {
+		res = append(res, fmt.Sprintf("%s=%s", name, value))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func boardsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) == 1 {
+		b, err := loadBoard(args[0])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		ourutil.Reportf("%s: %s, platform %s", b.Name, b.Description, b.Platform)
+		for _, v := range boardBuildVars(b) {
+			ourutil.Reportf("  %s", v)
+		}
+		return nil
+	}
+	dir, err := paths.NormalizePath(*boardsDir, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	all, err := boards.Load(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range boards.Names(all) {
+		b := all[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", b.Name, b.Platform, b.Description)
+	}
+	return errors.Trace(w.Flush())
+}
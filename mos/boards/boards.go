@@ -0,0 +1,145 @@
+// Package boards implements board profiles: named bundles of the platform,
+// build vars, flash params, reset strategy and default pins of a board.
+// Some profiles are built in, more can be defined by the user as YAML files.
+package boards
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// ResetDTRRTS resets the device and enters the bootloader via DTR and RTS.
+	ResetDTRRTS = "dtr_rts"
+	// ResetInvertedDTRRTS is the same, with the lines of inverted polarity.
+	ResetInvertedDTRRTS = "inverted_dtr_rts"
+)
+
+type Board struct {
+	Name          string            `yaml:"name"`
+	Description   string            `yaml:"description,omitempty"`
+	Platform      string            `yaml:"platform"`
+	BuildVars     map[string]string `yaml:"build_vars,omitempty"`
+	FlashParams   string            `yaml:"flash_params,omitempty"`
+	FlashBaudRate uint              `yaml:"flash_baud_rate,omitempty"`
+	Reset         string            `yaml:"reset,omitempty"`
+	Pins          map[string]int    `yaml:"pins,omitempty"`
+}
+
+// Parse parses the board profile in YAML.
+func Parse(data []byte) (*Board, error) {
+	b := &Board{}
+	if err := yaml.Unmarshal(data, b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return b, nil
+}
+
+func (b *Board) Validate() error {
+	if b.Name == "" {
+		return errors.Errorf("board name is required")
+	}
+	switch b.Platform {
+	case "cc3200", "cc3220", "esp32", "esp8266", "stm32":
+	case "":
+		return errors.Errorf("%s: platform is required", b.Name)
+	default:
+		return errors.Errorf("%s: unknown platform %q", b.Name, b.Platform)
+	}
+	switch b.Reset {
+	case "", ResetDTRRTS, ResetInvertedDTRRTS:
+	default:
+		return errors.Errorf("%s: unknown reset strategy %q, expected %s or %s", b.Name, b.Reset, ResetDTRRTS, ResetInvertedDTRRTS)
+	}
+	return nil
+}
+
+// AllBuildVars returns build vars of the board, including the pins as
+// BOARD_<NAME>_PIN, so that they can be used in mos.yml like
+// ${build_vars.BOARD_LED_PIN}.
+func (b *Board) AllBuildVars() map[string]string {
+	res := map[string]string{}
+	for k, v := range b.BuildVars {
+		res[k] = v
+	}
+	for name, pin := range b.Pins {
+		res[fmt.Sprintf("BOARD_%s_PIN", strings.ToUpper(name))] = fmt.Sprintf("%d", pin)
+	}
+	return res
+}
+
+// Load returns built-in boards and the user-defined ones from *.yml files
+// in the given dir (which may not exist), the latter overriding the former.
+func Load(userDir string) (map[string]*Board, error) {
+	res := map[string]*Board{}
+	for _, data := range builtin {
+		b, err := Parse([]byte(data))
+		if err != nil {
+			return nil, errors.Annotatef(err, "built-in board")
+		}
+		res[b.Name] = b
+	}
+	files, err := filepath.Glob(filepath.Join(userDir, "*.yml"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, f := range files {
+		b, err := loadFile(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res[b.Name] = b
+	}
+	return res, nil
+}
+
+func loadFile(fname string) (*Board, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	b, err := Parse(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", fname)
+	}
+	return b, nil
+}
+
+// Get returns the board with the given name, or loads it from the file if
+// the name is a path to one.
+func Get(userDir, name string) (*Board, error) {
+	if strings.HasSuffix(name, ".yml") {
+		if _, err := os.Stat(name); err == nil {
+			return loadFile(name)
+		}
+	}
+	all, err := Load(userDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	b, ok := all[name]
+	if !ok {
+		return nil, errors.Errorf("unknown board %q, known boards: %s", name, strings.Join(Names(all), ", "))
+	}
+	return b, nil
+}
+
+// Names returns sorted names of the boards.
+func Names(all map[string]*Board) []string {
+	var res []string
+	for name := range all {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
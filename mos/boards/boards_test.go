@@ -0,0 +1,62 @@
+package boards
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	user := "name: wemos-d1-mini\nplatform: esp8266\nreset: inverted_dtr_rts\npins:\n  led: 4\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "d1.yml"), []byte(user), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(builtin) {
+		t.Errorf("got %d boards, want %d", len(all), len(builtin))
+	}
+	b := all["wemos-d1-mini"]
+	if b.Reset != ResetInvertedDTRRTS || b.FlashParams != "" {
+		t.Errorf("user board doesn't override the built-in one: %+v", b)
+	}
+	if v := b.AllBuildVars()["BOARD_LED_PIN"]; v != "4" {
+		t.Errorf("unexpected pin build var %q", v)
+	}
+
+	b, err = Get(dir, "esp32-devkitc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Platform != "esp32" || b.FlashBaudRate != 921600 {
+		t.Errorf("unexpected board %+v", b)
+	}
+	if _, err := Get(dir, "blah"); err == nil {
+		t.Errorf("expected an error for unknown board")
+	}
+	if _, err := Get(dir, filepath.Join(dir, "d1.yml")); err != nil {
+		t.Errorf("failed to load board from file: %s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []string{
+		"platform: esp32\n",
+		"name: a\n",
+		"name: a\nplatform: avr\n",
+		"name: a\nplatform: esp32\nreset: magic\n",
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
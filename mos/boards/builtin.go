@@ -0,0 +1,72 @@
+package boards
+
+// Boards shipped with the tool.
+var builtin = []string{
+	`
+name: esp32-devkitc
+description: Espressif ESP32-DevKitC
+platform: esp32
+flash_params: dio,32m,40m
+flash_baud_rate: 921600
+pins:
+  button: 0
+`,
+	`
+name: esp32-wrover-kit
+description: Espressif ESP-WROVER-KIT
+platform: esp32
+flash_params: dio,32m,40m
+pins:
+  led_red: 0
+  led_green: 2
+  led_blue: 4
+`,
+	`
+name: huzzah32
+description: Adafruit HUZZAH32 ESP32 Feather
+platform: esp32
+flash_params: dio,32m,40m
+pins:
+  led: 13
+`,
+	`
+name: nodemcu
+description: NodeMCU v2/v3 (ESP-12E)
+platform: esp8266
+flash_params: dio,32m,40m
+build_vars:
+  FLASH_SIZE: "4194304"
+pins:
+  led: 2
+  button: 0
+`,
+	`
+name: wemos-d1-mini
+description: WeMos D1 mini
+platform: esp8266
+flash_params: dio,32m,40m
+build_vars:
+  FLASH_SIZE: "4194304"
+pins:
+  led: 2
+`,
+	`
+name: esp-01
+description: ESP-01 module, 1MB flash
+platform: esp8266
+flash_params: dout,8m,40m
+build_vars:
+  FLASH_SIZE: "1048576"
+pins:
+  led: 1
+`,
+	`
+name: b-l475e-iot01a
+description: ST B-L475E-IOT01A Discovery kit
+platform: stm32
+build_vars:
+  BOARD: B-L475E-IOT01A
+pins:
+  led: 14
+`,
+}
@@ -0,0 +1,148 @@
+// Package bootcheck tells, from the console output of a device after it was
+// flashed, whether the firmware boots fine, crashes or keeps rebooting.
+package bootcheck
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Statuses of the verdict
+const (
+	// The firmware booted and didn't crash
+	StatusOK = "ok"
+	// The firmware crashed
+	StatusPanic = "panic"
+	// The device rebooted too many times
+	StatusBootLoop = "boot_loop"
+	// No boot was seen in the output
+	StatusNoBoot = "no_boot"
+)
+
+// Max lines of the panic report kept, starting with the panic line
+const maxPanicLines = 30
+
+// Boot banners of the ROM bootloaders, several lines in a row on a boot, and
+// of mongoose-os itself, which follows them, if any
+var (
+	romBootRE  = regexp.MustCompile(`^\s*(rst:0x[0-9a-f]+ \(|ets [A-Z][a-z]{2} +\d+ \d{4})`)
+	mgosBootRE = regexp.MustCompile(`mgos_init.*Mongoose OS`)
+)
+
+// Panic reports of the platforms and of the C runtime
+var panicRE = regexp.MustCompile(`Guru Meditation Error|abort\(\) was called|Fatal exception|^Exception \(\d+\)|` +
+	`HardFault|assert(ion)? .*failed|--- BEGIN CORE DUMP ---|^\s*panic:|Stack smashing protect failure`)
+
+// Verdict is the result of the check.
+type Verdict struct {
+	Status string `json:"status"`
+	// Number of boots seen
+	Boots int `json:"boots"`
+	// The first panic report: the panic line and the following ones, like the
+	// backtrace and registers.
+	Panic []string `json:"panic,omitempty"`
+	// Code addresses of the backtrace of the panic, to be decoded.
+	Backtrace []string `json:"backtrace,omitempty"`
+}
+
+func (v *Verdict) String() string {
+	switch v.Status {
+	case StatusOK:
+		return "firmware booted fine"
+	case StatusPanic:
+		return fmt.Sprintf("firmware crashed: %s", v.Panic[0])
+	case StatusBootLoop:
+		return fmt.Sprintf("firmware is in a boot loop, %d boots", v.Boots)
+	}
+	return "no boot was seen on the console"
+}
+
+// Checker consumes the console output line by line.
+type Checker struct {
+	// Number of boots after which the device is considered boot looping
+	MaxBoots int
+
+	v          Verdict
+	panicLines int
+	// Whether the last lines are ROM banners, and whether there was one since
+	// the last mongoose-os banner
+	inROMBanner, romBoot bool
+}
+
+// NewChecker returns a checker with the given boot loop threshold.
+func NewChecker(maxBoots int) *Checker {
+	return &Checker{MaxBoots: maxBoots}
+}
+
+// Feed processes a line of the output and returns whether the verdict is
+// final, i.e. the device crashed or is boot looping.
+func (c *Checker) Feed(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	isROMBanner, isMgosBanner := romBootRE.MatchString(line), mgosBootRE.MatchString(line)
+	if c.panicLines > 0 {
+		if isROMBanner || isMgosBanner || len(c.v.Panic) >= maxPanicLines {
+			c.panicLines = 0
+		} else {
+			c.v.Panic = append(c.v.Panic, line)
+			c.v.Backtrace = append(c.v.Backtrace, backtraceAddrs(line)...)
+		}
+	}
+	boot := false
+	switch {
+	case isROMBanner:
+		boot = !c.inROMBanner
+		c.inROMBanner, c.romBoot = true, true
+	case isMgosBanner:
+		boot = !c.romBoot
+		c.inROMBanner, c.romBoot = false, false
+	case strings.TrimSpace(line) != "":
+		c.inROMBanner = false
+	}
+	if boot {
+		c.v.Boots++
+		return c.MaxBoots > 0 && c.v.Boots >= c.MaxBoots
+	}
+	if c.v.Panic == nil && panicRE.MatchString(line) {
+		c.v.Panic = []string{strings.TrimSpace(line)}
+		c.v.Backtrace = backtraceAddrs(line)
+		c.panicLines = 1
+	}
+	return false
+}
+
+// Verdict returns the verdict on the output so far.
+func (c *Checker) Verdict() *Verdict {
+	v := c.v
+	switch {
+	case v.Panic != nil:
+		v.Status = StatusPanic
+	case v.Boots >= c.MaxBoots && c.MaxBoots > 0:
+		v.Status = StatusBootLoop
+	case v.Boots == 0:
+		v.Status = StatusNoBoot
+	default:
+		v.Status = StatusOK
+	}
+	return &v
+}
+
+var (
+	// ESP32: "Backtrace: 0x400d1234:0x3ffb5e40 0x400d2345:0x3ffb5e60"
+	espBacktraceRE = regexp.MustCompile(`(0x4[0-9a-fA-F]{7}):0x[0-9a-fA-F]{8}`)
+	// "PC: 0x40081234", "epc1=0x40201234", "pc=0x0800abcd"
+	pcRE = regexp.MustCompile(`(?i)\b(?:pc|epc1)\s*[:=]\s*(0x[0-9a-f]{8})`)
+)
+
+func backtraceAddrs(line string) []string {
+	var res []string
+	if strings.Contains(line, "Backtrace:") {
+		for _, m := range espBacktraceRE.FindAllStringSubmatch(line, -1) {
+			res = append(res, m[1])
+		}
+	}
+	for _, m := range pcRE.FindAllStringSubmatch(line, -1) {
+		res = append(res, m[1])
+	}
+	return res
+}
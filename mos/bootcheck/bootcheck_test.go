@@ -0,0 +1,55 @@
+package bootcheck
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func check(maxBoots int, output string) (*Verdict, bool) {
+	c := NewChecker(maxBoots)
+	final := false
+	for _, line := range strings.Split(output, "\n") {
+		if c.Feed(line) {
+			final = true
+			break
+		}
+	}
+	return c.Verdict(), final
+}
+
+func TestChecker(t *testing.T) {
+	boot := "rst:0x1 (POWERON_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)\n" +
+		"mgos_init            Mongoose OS 2.20.0 (20220101-000000)\n"
+
+	v, final := check(3, boot+"main.c:10  Hello\n")
+	if final || v.Status != StatusOK || v.Boots != 1 {
+		t.Errorf("unexpected verdict %+v", v)
+	}
+
+	v, _ = check(3, boot+`Guru Meditation Error: Core  0 panic'ed (LoadProhibited). Exception was unhandled.
+PC      : 0x400d1234  PS      : 0x00060330
+Backtrace: 0x400d1234:0x3ffb5e40 0x400d2345:0x3ffb5e60
+
+`+boot)
+	if v.Status != StatusPanic || len(v.Panic) != 4 {
+		t.Errorf("unexpected verdict %+v", v)
+	}
+	if exp := []string{"0x400d1234", "0x400d1234", "0x400d2345"}; !reflect.DeepEqual(v.Backtrace, exp) {
+		t.Errorf("unexpected backtrace %v", v.Backtrace)
+	}
+	if !strings.HasPrefix(v.String(), "firmware crashed: Guru Meditation Error") {
+		t.Errorf("unexpected description %q", v.String())
+	}
+
+	// Banners of the same boot count once
+	wdtBoot := "ets Jun  8 2016 00:22:57\n\nrst:0x7 (TG0WDT_SYS_RESET),boot:0x13\nload:0x3fff0018,len:4\n"
+	v, final = check(3, wdtBoot+wdtBoot+wdtBoot+wdtBoot)
+	if !final || v.Status != StatusBootLoop || v.Boots != 3 {
+		t.Errorf("unexpected verdict %+v", v)
+	}
+
+	if v, _ = check(3, "garbage\n"); v.Status != StatusNoBoot {
+		t.Errorf("unexpected verdict %+v", v)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/mqttbroker"
+	"cesanta.com/mos/mqttsniff"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+const brokerCADir = "~/.mos/broker"
+
+var (
+	brokerListen = flag.String("broker-listen", "", "mos broker: address to listen on; by default, :1883, or :8883 with --broker-tls")
+	brokerTLS    = flag.Bool("broker-tls", false, "mos broker: use TLS; unless --broker-cert is given, the cert is signed by a local CA kept in "+brokerCADir)
+	brokerCert   = flag.String("broker-cert", "", "mos broker --broker-tls: server certificate file to use instead of the generated one")
+	brokerKey    = flag.String("broker-key", "", "mos broker --broker-tls: key file of --broker-cert")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "broker-listen", "broker-tls", "broker-cert", "broker-key")
+}
+
+// brokerHandler implements "mos broker": it runs the local MQTT broker and
+// prints the commands which point a device at it. With --verbose, all
+// traffic is shown, like with "mos mqtt sniff --mqtt-listen".
+func brokerHandler(ctx context.Context, devConn *dev.DevConn) error {
+	addr := *brokerListen
+	if addr == "" {
+		addr = ":1883"
+		if *brokerTLS {
+			addr = ":8883"
+		}
+	}
+	ip := getOutboundIP()
+
+	var tlsConfig *tls.Config
+	caFile := ""
+	if *brokerTLS {
+		if *brokerCert != "" {
+			if *brokerKey == "" {
+				return errors.Errorf("--broker-key is required with --broker-cert")
+			}
+			cert, err := tls.LoadX509KeyPair(*brokerCert, *brokerKey)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		} else {
+			dir, err := paths.NormalizePath(brokerCADir, "")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			host, _ := os.Hostname()
+			if tlsConfig, err = mqttbroker.NewTLSConfig(dir, []string{ip, "127.0.0.1", "localhost", host}); err != nil {
+				return errors.Annotatef(err, "failed to create the broker certificate")
+			}
+			caFile = filepath.Join(dir, mqttbroker.CACertFileName)
+		}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	defer l.Close()
+
+	b := mqttbroker.New()
+	if *verbose {
+		sn := mqttsniff.New(os.Stdout)
+		if tsfSpec != "" {
+			sn.Timestamp = FormatTimestampNow
+		}
+		b.OnPacket = sn.Packet
+	}
+
+	ourutil.Reportf("MQTT broker is listening on %s. To point a device at it, run:", l.Addr())
+	server := getBrokerAddrForDevice(l.Addr())
+	switch {
+	case tlsConfig == nil:
+		ourutil.Reportf("  mos config-set mqtt.enable=true mqtt.server=%s mqtt.ssl_ca_cert=", server)
+	case caFile != "":
+		ourutil.Reportf("  mos put %s broker_ca.pem", caFile)
+		ourutil.Reportf("  mos config-set mqtt.enable=true mqtt.server=%s mqtt.ssl_ca_cert=broker_ca.pem", server)
+	default:
+		ourutil.Reportf("  mos put <CA of %s> broker_ca.pem", *brokerCert)
+		ourutil.Reportf("  mos config-set mqtt.enable=true mqtt.server=%s mqtt.ssl_ca_cert=broker_ca.pem", server)
+	}
+	return errors.Trace(b.Serve(l))
+}
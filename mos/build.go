@@ -3,6 +3,7 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -18,9 +19,11 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 	"unicode"
 
@@ -32,13 +35,27 @@ import (
 	"cesanta.com/common/go/ourutil"
 	"cesanta.com/mos/build"
 	"cesanta.com/mos/build/archive"
+	"cesanta.com/mos/build/buildinfo"
+	"cesanta.com/mos/build/embedassets"
+	"cesanta.com/mos/build/fsassets"
+	"cesanta.com/mos/build/idfcomp"
+	"cesanta.com/mos/build/secrets"
+	"cesanta.com/mos/buildtimings"
+	"cesanta.com/mos/buildvars"
+	"cesanta.com/mos/ci"
 	moscommon "cesanta.com/mos/common"
 	"cesanta.com/mos/common/paths"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/dirsync"
+	"cesanta.com/mos/download"
+	"cesanta.com/mos/errcode"
 	"cesanta.com/mos/flash/common"
 	"cesanta.com/mos/interpreter"
+	"cesanta.com/mos/lockfile"
 	"cesanta.com/mos/manifest_parser"
 	"cesanta.com/mos/mosgit"
+	"cesanta.com/mos/mosignore"
+	"cesanta.com/mos/resumable"
 	"cesanta.com/mos/update"
 	"cesanta.com/mos/version"
 	"github.com/cesanta/errors"
@@ -54,6 +71,7 @@ var (
 			"cc3200=docker.cesanta.com/mg-iot-cloud-project-cc3200:release",
 		"build images, arch1=image1,arch2=image2")
 	cleanBuild         = flag.Bool("clean", false, "perform a clean build, wipe the previous build state")
+	copyLibs           = flag.Bool("copy-libs", false, "local build: copy libs which are outside of the project into the build dir instead of mounting them into the container; for docker setups which can only mount some dirs")
 	buildTarget        = flag.String("build-target", moscommon.BuildTargetDefault, "target to build with make")
 	keepTempFiles      = flag.Bool("keep-temp-files", false, "keep temp files after the build is done (by default they are in ~/.mos/tmp)")
 	modules            = flag.StringSlice("module", []string{}, "location of the module from mos.yaml, in the format: \"module_name:/path/to/location\". Can be used multiple times.")
@@ -70,10 +88,17 @@ var (
 	preferPrebuiltLibs = flag.Bool("prefer-prebuilt-libs", false, "if both sources and prebuilt binary of a lib exists, use the binary")
 
 	buildVarsSlice []string
+	// Build vars from the board profile, overridden by the environment and
+	// --build-var
+	boardBuildVarsSlice []string
+	printVars           = flag.Bool("print-vars", false, "mos build: print the final build vars with their sources instead of building")
+	showContext         = flag.Bool("show-context", false, "mos build: list the files which would be uploaded to the remote builder, with sizes, instead of building")
 
 	noLibsUpdate  = flag.Bool("no-libs-update", false, "if true, never try to pull existing libs (treat existing default locations as if they were given in --lib)")
 	skipCleanLibs = flag.Bool("skip-clean-libs", true, "if false, then during the remote build all libs will be uploaded to the builder")
 
+	requireCleanLibs = flag.Bool("require-clean-libs", false, "fail the local build if any lib fetched to the libs dir has local modifications")
+
 	// In-memory buffer containing all the log messages.  It has to be
 	// thread-safe, because it's used in compProviderReal, which is an
 	// implementation of the manifest_parser.ComponentProvider interface, whose
@@ -98,22 +123,41 @@ type buildParams struct {
 	BuildTarget           string
 	CustomLibLocations    map[string]string
 	CustomModuleLocations map[string]string
+	// If not nil, durations of the build phases are measured
+	Timings *buildtimings.Tracker
+	// Build image used by the local build, set by it
+	BuildImage string
 }
 
 func init() {
 	hiddenFlags = append(hiddenFlags, "docker_images")
 
-	flag.StringSliceVar(&buildVarsSlice, "build-var", []string{}, "build variable in the format \"NAME:VALUE\" Can be used multiple times.")
+	flag.StringSliceVar(&buildVarsSlice, "build-var", []string{}, "build variable in the format \"NAME:VALUE\" Can be used multiple times. Also can be set in the environment as MOS_BV_NAME=VALUE.")
 }
 
 // Build {{{
 
 // Build command handler {{{
 func buildHandler(ctx context.Context, devConn *dev.DevConn) error {
+	bParams, err := newBuildParams(*platform)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	start := time.Now()
+	err = errcode.Wrap(errcode.BuildFailed, doBuild(ctx, bParams))
+	ciReportBuild(bParams, time.Since(start), err)
+
+	return errors.Trace(err)
+}
+
+// newBuildParams returns build params for the given platform, with the rest
+// taken from the command line flags.
+func newBuildParams(platform string) (*buildParams, error) {
 	// Create map of given lib locations, via --lib flag(s)
 	cll, err := getCustomLibLocations()
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 
 	// Create map of given module locations, via --module flag(s)
@@ -123,22 +167,33 @@ func buildHandler(ctx context.Context, devConn *dev.DevConn) error {
 		cml[parts[0]] = parts[1]
 	}
 
-	bParams := buildParams{
-		Platform:              *platform,
+	return &buildParams{
+		Platform:              platform,
 		BuildTarget:           *buildTarget,
 		CustomLibLocations:    cll,
 		CustomModuleLocations: cml,
-	}
-
-	return errors.Trace(doBuild(ctx, &bParams))
+	}, nil
 }
 
+// errBuildContextShown is returned by buildRemote with --show-context, when
+// the context is listed instead of building.
+var errBuildContextShown = errors.New("build context shown")
+
 func doBuild(ctx context.Context, bParams *buildParams) error {
 	var err error
 	buildDir := moscommon.GetBuildDir(projectDir)
 
 	start := time.Now()
 
+	if *printVars {
+		// Only the manifest is resolved, the build dir is left alone
+		manifest, _, err := readFinalManifest()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(printBuildVars(manifest))
+	}
+
 	// Request server version in parallel
 	serverVersionCh := make(chan *version.VersionJson, 1)
 	if !*local {
@@ -177,11 +232,38 @@ func doBuild(ctx context.Context, bParams *buildParams) error {
 		return errors.Errorf("No mos.yml file")
 	}
 
+	if *sanitize && !*local {
+		return errors.Errorf("--sanitize is only supported by local builds")
+	}
+
+	if *showContext && *local {
+		return errors.Errorf("--show-context is only supported by remote builds")
+	}
+
+	if err := checkFmtBeforeBuild(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := runHooks(hookPreBuild, nil, logWriterStderr); err != nil {
+		return errors.Trace(err)
+	}
+
+	if *buildTimings {
+		if *local {
+			bParams.Timings = buildtimings.NewTracker()
+		} else {
+			freportf(logWriterStderr, "--timings is only supported by local builds, ignoring")
+		}
+	}
+
 	if *local {
 		err = buildLocal(ctx, bParams)
 	} else {
 		err = buildRemote(bParams)
 	}
+	if errors.Cause(err) == errBuildContextShown {
+		return nil
+	}
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -191,6 +273,21 @@ func doBuild(ctx context.Context, bParams *buildParams) error {
 		// firmware around, etc.
 		fwFilename := moscommon.GetFirmwareZipFilePath(buildDir)
 
+		if err := applyPartitionTable(fwFilename, buildDir); err != nil {
+			return errors.Trace(err)
+		}
+
+		if *local {
+			if err := addDiffInfoToFirmware(fwFilename, buildDir); err != nil {
+				return errors.Annotatef(err, "failed to add build info to the firmware")
+			}
+		}
+
+		// Last, since it's about the final fw zip
+		if err := writeBuildProvenance(bParams, fwFilename, buildDir, start); err != nil {
+			return errors.Annotatef(err, "failed to write build provenance")
+		}
+
 		fw, err := common.NewZipFirmwareBundle(fwFilename)
 		if err != nil {
 			return errors.Trace(err)
@@ -221,6 +318,24 @@ func doBuild(ctx context.Context, bParams *buildParams) error {
 
 			freportf(logWriterStderr, "Firmware saved to %s", fwFilename)
 		}
+
+		if bParams.Timings != nil {
+			if err := saveBuildTimings(bParams.Timings.Finish(fw.Platform)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		buildDirAbs, err := filepath.Abs(buildDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := runHooks(hookPostBuild, map[string]string{
+			"MOS_PLATFORM": fw.Platform,
+			"MOS_FW_ZIP":   moscommon.GetFirmwareZipFilePath(buildDirAbs),
+			"MOS_FW_ELF":   moscommon.GetFirmwareElfFilePath(buildDirAbs),
+		}, logWriterStderr); err != nil {
+			return errors.Trace(err)
+		}
 	} else if p := moscommon.GetOrigLibArchiveFilePath(buildDir, bParams.Platform); bParams.BuildTarget == p {
 		freportf(logWriterStderr, "Lib saved to %s", moscommon.GetLibArchiveFilePath(buildDir))
 	} else {
@@ -263,7 +378,9 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 				glog.Errorf("can't read build log: %s", err)
 				return
 			}
+			ci.StartGroup(os.Stdout, "Build log")
 			io.Copy(os.Stdout, log)
+			ci.EndGroup(os.Stdout)
 		}
 	}()
 
@@ -323,6 +440,10 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		return errors.Trace(err)
 	}
 
+	if bParams.Timings != nil {
+		bParams.Timings.Start(buildtimings.PhaseFetch)
+	}
+
 	manifest, fp, err := manifest_parser.ReadManifestFinal(
 		appDir, &manifest_parser.ManifestAdjustments{
 			Platform:  bParams.Platform,
@@ -334,6 +455,40 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		return errors.Trace(err)
 	}
 
+	if err := checkDirtyLibs(compProvider.dirtyLibs); err != nil {
+		return errors.Trace(err)
+	}
+
+	idfCompNames, idfCompDirs, err := prepareIDFComponents(manifest, &compProvider, appDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var deps []moscommon.BuildDep
+	for _, l := range manifest.LibsHandled {
+		d := moscommon.BuildDep{Name: l.Name, Kind: moscommon.BuildDepLib, Dir: l.Path}
+		if l.Manifest != nil {
+			d.Version = l.Manifest.Version
+			d.License = l.Manifest.License
+		}
+		deps = append(deps, d)
+	}
+	var moduleNames []string
+	for name := range fp.ModuleDirs {
+		moduleNames = append(moduleNames, name)
+	}
+	sort.Strings(moduleNames)
+	for _, name := range moduleNames {
+		deps = append(deps, moscommon.BuildDep{Name: name, Kind: moscommon.BuildDepModule, Dir: fp.ModuleDirs[name]})
+	}
+	deps = append(deps, moscommon.BuildDep{Name: "mongoose-os", Kind: moscommon.BuildDepMongooseOS, Dir: fp.MosDirEffective})
+	if err := saveBuildDeps(buildDir, deps); err != nil {
+		return errors.Trace(err)
+	}
+	if err := checkLicenses(manifest.AllowedLicenses, deps); err != nil {
+		return errors.Trace(err)
+	}
+
 	switch manifest.Type {
 	case build.AppTypeApp:
 		// Fine
@@ -365,7 +520,12 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		var err error
 		curConfSchemaFName = moscommon.GetConfSchemaFilePath(buildDirAbs)
 
-		confSchemaData, err := yaml.Marshal(manifest.ConfigSchema)
+		// Encrypted defaults are decrypted in the generated schema only
+		confSchema, err := secrets.DecryptConfigSchema(manifest.ConfigSchema, decryptAgeValue)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		confSchemaData, err := yaml.Marshal(confSchema)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -394,8 +554,10 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		return errors.Errorf("can't build for the platform %s; only those platforms are supported: %v", manifest.Platform, manifest.Platforms)
 	}
 
-	// Amend cflags and cxxflags with the values given in command line
+	// Amend cxxflags with the C++ options, and cflags and cxxflags with the
+	// values given in command line
 	manifest.CFlags = append(manifest.CFlags, *cflagsExtra...)
+	manifest.CXXFlags = append(manifest.CXXFlags, manifest.CXX.Flags()...)
 	manifest.CXXFlags = append(manifest.CXXFlags, *cxxflagsExtra...)
 
 	appSources, err := absPathSlice(manifest.Sources)
@@ -413,11 +575,67 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		return errors.Trace(err)
 	}
 
+	tsFiles, err := buildTypeScript(appDir, moscommon.GetTypeScriptDir(buildDirAbs))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	appFSFiles = append(appFSFiles, tsFiles...)
+
+	if manifest.FSAssets != nil {
+		appFSFiles, err = fsassets.Process(
+			appFSFiles, manifest.FSAssets,
+			moscommon.GetFSAssetsDir(buildDirAbs), moscommon.GetFSSourceMapsDir(buildDirAbs),
+		)
+		if err != nil {
+			return errors.Annotatef(err, "processing filesystem files")
+		}
+	}
+
+	if len(manifest.EmbedAssets) > 0 {
+		embeddedAssetsDir := moscommon.GetEmbeddedAssetsDir(buildDirAbs)
+		srcFile, err := embedassets.Generate(manifest.EmbedAssets, appDir, embeddedAssetsDir)
+		if err != nil {
+			return errors.Annotatef(err, "generating embedded assets")
+		}
+		appSources = append(appSources, srcFile)
+		appIncludes = append(appIncludes, embeddedAssetsDir)
+	}
+
+	buildInfo, err := getBuildInfo()
+	if err != nil {
+		return errors.Annotatef(err, "getting build info")
+	}
+	if buildInfo != nil {
+		buildInfoDir := moscommon.GetBuildInfoDir(buildDirAbs)
+		srcFile, err := buildinfo.Generate(buildInfo, buildInfoDir)
+		if err != nil {
+			return errors.Annotatef(err, "generating build info")
+		}
+		appSources = append(appSources, srcFile)
+		appIncludes = append(appIncludes, buildInfoDir)
+	}
+
+	arduinoSources, arduinoIncludes, err := prepareArduinoLibs(moscommon.GetArduinoLibsDir(buildDirAbs))
+	if err != nil {
+		return errors.Annotatef(err, "preparing Arduino libraries")
+	}
+	appSources = append(appSources, arduinoSources...)
+	appIncludes = append(appIncludes, arduinoIncludes...)
+
 	appBinLibs, err := absPathSlice(manifest.BinaryLibs)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	rustLibs, rustIncludeDir, err := buildRustCrates(manifest, appDir, moscommon.GetRustDir(buildDirAbs))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(rustLibs) > 0 {
+		appBinLibs = append(appBinLibs, rustLibs...)
+		appIncludes = append(appIncludes, rustIncludeDir)
+	}
+
 	appSourceDirs, err := absPathSlice(fp.AppSourceDirs)
 	if err != nil {
 		return errors.Trace(err)
@@ -439,6 +657,12 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 
 	freportf(logWriter, "Building...")
 
+	if *sanitize {
+		if err := addSanitizerFlags(manifest); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	appName, err := fixupAppName(manifest.Name)
 	if err != nil {
 		return errors.Trace(err)
@@ -468,6 +692,18 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 			errs = multierror.Append(errs, err)
 		}
 	}
+	// Modules are exposed to make as MGOS_MODULE_<NAME>_PATH, so that e.g.
+	// platform makefiles can use external SDKs or components.
+	for name, d := range fp.ModuleDirs {
+		if err := addBuildVar(manifest, getModuleBuildVarName(name), getPathForDocker(d)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if len(idfCompNames) > 0 {
+		// Libs and the app may add components of their own too.
+		appendBuildVar(manifest, idfcomp.ComponentsBuildVar, strings.Join(idfCompNames, " "))
+		appendBuildVar(manifest, idfcomp.ComponentDirsBuildVar, strings.Join(getPathsForDocker(idfCompDirs), " "))
+	}
 	if errs != nil {
 		return errors.Trace(errs)
 	}
@@ -489,8 +725,28 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 	}
 
 	// Invoke actual build (docker or make) {{{
+	// Make output also goes to the timings tracker, which tells the link and
+	// pack phases from the compilation by it.
+	makeLogWriter := logWriter
+	if bParams.Timings != nil {
+		makeLogWriter = io.MultiWriter(logWriter, bParams.Timings)
+	}
+
+	// The compilation database is made of the commands echoed by make
+	if *compileCommands {
+		*buildCmdExtra = append(*buildCmdExtra, "V=1")
+	}
+	// Container paths of the make dir and the mounts, to map paths in the
+	// compilation database back to the host ones.
+	makeDir := appPath
+	var containerPaths mountPoints
+
 	if os.Getenv("MGOS_SDK_REVISION") == "" && os.Getenv("MIOT_SDK_REVISION") == "" {
 		// We're outside of the docker container, so invoke docker
+		containerRuntime, err := getContainerRuntime()
+		if err != nil {
+			return errors.Trace(err)
+		}
 
 		dockerRunArgs := []string{"--rm", "-i"}
 
@@ -524,24 +780,48 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		// Mount build dir
 		mp.addMountPoint(buildDirAbs, getPathForDocker(buildDirAbs))
 
+		// Mount all modules
+		for _, d := range fp.ModuleDirs {
+			mp.addMountPoint(d, getPathForDocker(d))
+		}
+		for _, d := range idfCompDirs {
+			mp.addMountPoint(d, getPathForDocker(d))
+		}
+
+		// Mount libs which are outside of the app as a whole, so that a lib is
+		// a single mount rather than one per dir with sources, includes, etc.
+		libDirs, err := mountLibs(mp, manifest.LibsHandled, appMountPath, buildDirAbs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mountDir := func(d string) {
+			// Dirs of libs mounted above are already there
+			for _, ld := range libDirs {
+				if isUnderDir(d, ld) {
+					return
+				}
+			}
+			mp.addMountPoint(d, getPathForDocker(d))
+		}
+
 		// Mount all dirs with source files
 		for _, d := range appSourceDirs {
-			mp.addMountPoint(d, getPathForDocker(d))
+			mountDir(d)
 		}
 
 		// Mount all include paths
 		for _, d := range appIncludes {
-			mp.addMountPoint(d, getPathForDocker(d))
+			mountDir(d)
 		}
 
 		// Mount all dirs with filesystem files
 		for _, d := range appFSDirs {
-			mp.addMountPoint(d, getPathForDocker(d))
+			mountDir(d)
 		}
 
 		// Mount all dirs with binary libs
 		for _, d := range appBinLibDirs {
-			mp.addMountPoint(d, getPathForDocker(d))
+			mountDir(d)
 		}
 
 		// If generated config schema file is present, mount its dir as well
@@ -550,32 +830,28 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 			mp.addMountPoint(d, getPathForDocker(d))
 		}
 
+		// With a remote docker host, volumes are mounted from the remote
+		// filesystem, so sync them there first.
+		rh, err := getRemoteDockerHost()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
 		for containerPath, hostPath := range mp {
+			if rh != nil {
+				if hostPath, err = rh.push(hostPath); err != nil {
+					return errors.Trace(err)
+				}
+			}
 			dockerRunArgs = append(dockerRunArgs, "-v", fmt.Sprintf("%s:%s", hostPath, containerPath))
 		}
 		// }}}
 
-		// On Windows and Mac, run container as root since volume sharing on those
-		// OSes doesn't play nice with unprivileged user.
-		//
-		// On other OSes, run it as the current user.
-		if runtime.GOOS == "linux" {
-			// Unfortunately, user.Current() sometimes panics when the mos binary is
-			// built statically, so we have to do the trick with "id -u". Since this
-			// code runs on Linux only, this workaround does the trick.
-			var data bytes.Buffer
-			cmd := exec.Command("id", "-u")
-			cmd.Stdout = &data
-			if err := cmd.Run(); err != nil {
-				return errors.Trace(err)
-			}
-			sdata := data.String()
-			userID := sdata[:len(sdata)-1]
-
-			dockerRunArgs = append(
-				dockerRunArgs, "--user", fmt.Sprintf("%s:%s", userID, userID),
-			)
+		userArgs, err := getContainerUserArgs(containerRuntime)
+		if err != nil {
+			return errors.Trace(err)
 		}
+		dockerRunArgs = append(dockerRunArgs, userArgs...)
 
 		// Add extra docker args
 		if buildDockerExtra != nil {
@@ -591,10 +867,18 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 		}
 
 		sdkVersion := strings.TrimSpace(string(sdkVersionBytes))
-		dockerRunArgs = append(dockerRunArgs, sdkVersion)
 
+		// Use the image pinned in the lockfile, or pin the current one
+		buildImage, err := resolveBuildImage(sdkVersion)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		bParams.BuildImage = buildImage
+		dockerRunArgs = append(dockerRunArgs, buildImage)
+
+		makeDir, containerPaths = fmt.Sprintf("%s%s", dockerAppPath, appSubdir), mp
 		makeArgs, err := getMakeArgs(
-			fmt.Sprintf("%s%s", dockerAppPath, appSubdir),
+			makeDir,
 			bParams.BuildTarget,
 			manifest,
 		)
@@ -605,8 +889,22 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 			"/bin/bash", "-c", "nice make '"+strings.Join(makeArgs, "' '")+"'",
 		)
 
-		if err := runDockerBuild(dockerRunArgs); err != nil {
-			return errors.Trace(err)
+		if bParams.Timings != nil {
+			bParams.Timings.Start(buildtimings.PhaseCompile)
+		}
+
+		buildErr := runDockerBuild(dockerRunArgs, makeLogWriter)
+
+		// Get the build results back, even if the build failed, so that the
+		// log and partial outputs are available.
+		if rh != nil {
+			if err := rh.pull(buildDirAbs); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		if buildErr != nil {
+			return errors.Trace(buildErr)
 		}
 	} else {
 		// We're already inside of the docker container, so invoke make directly
@@ -620,14 +918,24 @@ func buildLocal(ctx context.Context, bParams *buildParams) (err error) {
 
 		freportf(logWriter, "Make arguments: %s", strings.Join(makeArgs, " "))
 
+		if bParams.Timings != nil {
+			bParams.Timings.Start(buildtimings.PhaseCompile)
+		}
+
 		cmd := exec.Command("make", makeArgs...)
-		err = runCmd(cmd, logWriter)
+		err = runCmd(cmd, makeLogWriter)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 	// }}}
 
+	if *compileCommands {
+		if err := writeCompileCommandsFromLog(buildDir, makeDir, containerPaths); err != nil {
+			return errors.Annotatef(err, "writing compilation database")
+		}
+	}
+
 	if bParams.BuildTarget == moscommon.BuildTargetDefault {
 		// We were building a firmware, so perform the required actions with moving
 		// firmware around, etc.
@@ -688,7 +996,13 @@ func buildRemote(bParams *buildParams) error {
 		return errors.Trace(err)
 	}
 
-	if err := ourio.CopyDir(appDir, tmpCodeDir, nil); err != nil {
+	// Build outputs, deps, VCS dirs and whatever is in .mosignore are not
+	// uploaded
+	ignore, err := mosignore.Load(appDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := copyBuildContext(appDir, tmpCodeDir, ignore); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -699,7 +1013,11 @@ func buildRemote(bParams *buildParams) error {
 
 	interp := interpreter.NewInterpreter(newMosVars())
 
-	manifest, _, err := manifest_parser.ReadManifest(tmpCodeDir, &manifest_parser.ManifestAdjustments{
+	// Conds are expanded too, at least to ensure that manifest.Sources contain
+	// all the app's sources we need to build, so that they will be
+	// whitelisted (see whitelisting logic below) and thus uploaded to the
+	// remote builder.
+	manifest, err := manifest_parser.ReadAppManifest(tmpCodeDir, &manifest_parser.ManifestAdjustments{
 		Platform:  bParams.Platform,
 		BuildVars: buildVarsCli,
 	}, interp)
@@ -710,16 +1028,13 @@ func buildRemote(bParams *buildParams) error {
 	if manifest.Platform == "" {
 		return errors.Errorf("--platform must be specified or mos.yml should contain a platform key")
 	}
-
-	// Set the mos.platform variable
-	interp.MVars.SetVar(interpreter.GetMVarNameMosPlatform(), manifest.Platform)
-
-	// We still need to expand some conds we have so far, at least to ensure that
-	// manifest.Sources contain all the app's sources we need to build, so that
-	// they will be whitelisted (see whitelisting logic below) and thus uploaded
-	// to the remote builder.
-	if err := manifest_parser.ExpandManifestConds(manifest, manifest, interp); err != nil {
-		return errors.Trace(err)
+	if secrets.HasEncrypted(manifest.ConfigSchema) {
+		return errors.Errorf("config schema has encrypted values, which can only be decrypted by local builds, use --local")
+	}
+	if len(manifest.AllowedLicenses) > 0 {
+		// Libs are fetched by the build server, so their licenses can't be
+		// checked here
+		return errors.Errorf("allowed_licenses can only be checked by local builds, use --local")
 	}
 
 	switch manifest.Type {
@@ -749,11 +1064,153 @@ func buildRemote(bParams *buildParams) error {
 	}
 	// }}}
 
+	// TypeScript sources are compiled locally, and the JS is uploaded as
+	// filesystem files
+	{
+		tsFiles, err := buildTypeScript(tmpCodeDir, filepath.Join(tmpCodeDir, "mos_ts"))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, f := range tsFiles {
+			rel, err := filepath.Rel(tmpCodeDir, f)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			manifest.Filesystem = append(manifest.Filesystem, filepath.ToSlash(rel))
+		}
+	}
+
+	// Process filesystem files locally, and upload the results instead of the
+	// original files. Source maps are kept aside and saved to the build dir
+	// once the build is done (since the build dir is replaced by the build
+	// results).
+	fsMapsTmpDir := ""
+	if manifest.FSAssets != nil {
+		fsFiles, err := manifest_parser.ResolveFilesystemPaths(manifest.Filesystem, tmpCodeDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		fsMapsTmpDir, err = ioutil.TempDir(paths.TmpDir, "tmp_mos_fs_maps_")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer os.RemoveAll(fsMapsTmpDir)
+
+		// Not under the build dir, so that the whole build dir doesn't get
+		// whitelisted for uploading
+		fsAssetsDir := filepath.Join(tmpCodeDir, "mos_fs_assets")
+		fsFiles, err = fsassets.Process(fsFiles, manifest.FSAssets, fsAssetsDir, fsMapsTmpDir)
+		if err != nil {
+			return errors.Annotatef(err, "processing filesystem files")
+		}
+
+		manifest.Filesystem = []string{}
+		for _, f := range fsFiles {
+			rel, err := filepath.Rel(tmpCodeDir, f)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			manifest.Filesystem = append(manifest.Filesystem, filepath.ToSlash(rel))
+		}
+		manifest.FSAssets = nil
+	}
+
+	// Embedded assets are generated locally as well, and the resulting C source
+	// is uploaded as one of the app sources.
+	if len(manifest.EmbedAssets) > 0 {
+		// Not under the build dir, for the same reason as fs assets above
+		const embeddedAssetsDir = "mos_embedded_assets"
+		_, err := embedassets.Generate(
+			manifest.EmbedAssets, tmpCodeDir, filepath.Join(tmpCodeDir, embeddedAssetsDir),
+		)
+		if err != nil {
+			return errors.Annotatef(err, "generating embedded assets")
+		}
+		manifest.Sources = append(manifest.Sources, embeddedAssetsDir+"/"+embedassets.SourceName)
+		manifest.Includes = append(manifest.Includes, embeddedAssetsDir)
+		manifest.EmbedAssets = nil
+	}
+
+	// So is the build info, git is only available here
+	buildInfo, err := getBuildInfo()
+	if err != nil {
+		return errors.Annotatef(err, "getting build info")
+	}
+	if buildInfo != nil {
+		const buildInfoDir = "mos_build_info"
+		if _, err := buildinfo.Generate(buildInfo, filepath.Join(tmpCodeDir, buildInfoDir)); err != nil {
+			return errors.Annotatef(err, "generating build info")
+		}
+		manifest.Sources = append(manifest.Sources, buildInfoDir+"/"+buildinfo.SourceName)
+		manifest.Includes = append(manifest.Includes, buildInfoDir)
+	}
+	manifest.BuildInfo = nil
+
+	// Arduino libs are fetched and patched locally too, and uploaded as app
+	// sources
+	{
+		const arduinoLibsDir = "mos_arduino_libs"
+		srcs, incs, err := prepareArduinoLibs(filepath.Join(tmpCodeDir, arduinoLibsDir))
+		if err != nil {
+			return errors.Annotatef(err, "preparing Arduino libraries")
+		}
+		relPaths := func(ps []string) ([]string, error) {
+			var res []string
+			for _, p := range ps {
+				rel, err := filepath.Rel(tmpCodeDir, p)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				res = append(res, filepath.ToSlash(rel))
+			}
+			return res, nil
+		}
+		if srcs, err = relPaths(srcs); err != nil {
+			return errors.Trace(err)
+		}
+		if incs, err = relPaths(incs); err != nil {
+			return errors.Trace(err)
+		}
+		manifest.Sources = append(manifest.Sources, srcs...)
+		manifest.Includes = append(manifest.Includes, incs...)
+	}
+	manifest.ArduinoLibs = nil
+
+	// Rust crates are built locally, and the static libs and headers are
+	// uploaded as binary libs and includes
+	if len(manifest.RustCrates) > 0 {
+		const rustDir = "mos_rust"
+		rustLibs, rustIncludeDir, err := buildRustCrates(manifest, appDir, moscommon.GetRustDir(buildDir))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := ourio.CopyDir(rustIncludeDir, filepath.Join(tmpCodeDir, rustDir, "include"), nil); err != nil {
+			return errors.Trace(err)
+		}
+		for _, lib := range rustLibs {
+			if err := ourio.CopyFile(lib, filepath.Join(tmpCodeDir, rustDir, filepath.Base(lib))); err != nil {
+				return errors.Trace(err)
+			}
+			manifest.BinaryLibs = append(manifest.BinaryLibs, rustDir+"/"+filepath.Base(lib))
+		}
+		manifest.Includes = append(manifest.Includes, rustDir+"/include")
+	}
+	manifest.RustCrates = nil
+
+	// Registry components are resolved here as well, so that the remote
+	// builder only has to download archives
+	if err := resolveIDFComponents(manifest); err != nil {
+		return errors.Trace(err)
+	}
+
 	// Print a warning if APP_CONF_SCHEMA is set in manifest manually
 	printConfSchemaWarn(manifest)
 
-	// Amend cflags and cxxflags with the values given in command line
+	// Amend cxxflags with the C++ options, and cflags and cxxflags with the
+	// values given in command line
 	manifest.CFlags = append(manifest.CFlags, *cflagsExtra...)
+	manifest.CXXFlags = append(manifest.CXXFlags, manifest.CXX.Flags()...)
 	manifest.CXXFlags = append(manifest.CXXFlags, *cxxflagsExtra...)
 
 	manifest.Name, err = fixupAppName(manifest.Name)
@@ -821,15 +1278,69 @@ func buildRemote(bParams *buildParams) error {
 	}
 	os.Chdir(appDir)
 
+	if *showContext {
+		if err := printBuildContext(src); err != nil {
+			return errors.Trace(err)
+		}
+		return errBuildContextShown
+	}
+
+	server, err := serverURL()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	buildUser := "test"
+	buildPass := "test"
+
+	// invoke the fwbuild API (replace "master" with "latest")
+	fwbuildVersion := version.GetMosVersion()
+
+	if fwbuildVersion == "master" {
+		fwbuildVersion = "latest"
+	}
+
+	xfer := resumable.NewClient()
+	xfer.Prepare = func(req *http.Request) {
+		req.SetBasicAuth(buildUser, buildPass)
+	}
+
+	// Upload sources in resumable chunks if the server supports it, so that
+	// large trees survive flaky connections; otherwise, they are sent with
+	// the build request.
+	srcDigest := resumable.Digest(src)
+	uploadURL := fmt.Sprintf("%s/api/fwbuild/%s/uploads/%s", server, fwbuildVersion, srcDigest)
+	freportf(logWriterStderr, "Uploading sources (%d bytes)", len(src))
+	uploaded := true
+	if err := xfer.Upload(uploadURL, src); err != nil {
+		if errors.Cause(err) != resumable.ErrNotSupported {
+			return errors.Annotatef(err, "uploading sources")
+		}
+		glog.Infof("no resumable upload: %s", err)
+		uploaded = false
+	}
+
 	// prepare multipart body
 	body := &bytes.Buffer{}
 	mpw := multipart.NewWriter(body)
-	part, err := mpw.CreateFormFile(moscommon.FormSourcesZipName, "source.zip")
-	if err != nil {
-		return errors.Trace(err)
+	if uploaded {
+		if err := mpw.WriteField(moscommon.FormSourcesSHA256Name, srcDigest); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		part, err := mpw.CreateFormFile(moscommon.FormSourcesZipName, "source.zip")
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if _, err := part.Write(src); err != nil {
+			return errors.Trace(err)
+		}
 	}
 
-	if _, err := part.Write(src); err != nil {
+	// Ask for the link to the results instead of the results themselves, so
+	// that they can be downloaded with resumption
+	if err := mpw.WriteField(moscommon.FormResultURLName, "1"); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -870,25 +1381,10 @@ func buildRemote(bParams *buildParams) error {
 		return errors.Trace(err)
 	}
 
-	server, err := serverURL()
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	buildUser := "test"
-	buildPass := "test"
 	freportf(logWriterStderr, "Connecting to %s, user %s", server, buildUser)
 
-	// invoke the fwbuild API (replace "master" with "latest")
-	fwbuildVersion := version.GetMosVersion()
-
-	if fwbuildVersion == "master" {
-		fwbuildVersion = "latest"
-	}
-
 	uri := fmt.Sprintf("%s/api/fwbuild/%s/build", server, fwbuildVersion)
 
-	freportf(logWriterStderr, "Uploading sources (%d bytes)", len(body.Bytes()))
 	req, err := http.NewRequest("POST", uri, body)
 	req.Header.Set("Content-Type", mpw.FormDataContentType())
 	req.SetBasicAuth(buildUser, buildPass)
@@ -903,9 +1399,23 @@ func buildRemote(bParams *buildParams) error {
 	body.Reset()
 	body.ReadFrom(resp.Body)
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errcode.Errorf(errcode.AuthFailed, "build server rejected credentials: %s", resp.Status)
+	}
+
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusTeapot:
 		// Build either succeeded or failed
+		if resultURL := resp.Header.Get(moscommon.HeaderResultURL); resultURL != "" {
+			data, err := downloadBuildResults(xfer, resultURL, resp.Header.Get(moscommon.HeaderResultSHA256))
+			if err != nil {
+				return errors.Annotatef(err, "build results")
+			}
+			body.Reset()
+			body.Write(data)
+		} else if err := download.VerifyDigestHeader(resp.Header, body.Bytes()); err != nil {
+			return errors.Annotatef(err, "build results")
+		}
 
 		// unzip build results
 		r := bytes.NewReader(body.Bytes())
@@ -915,18 +1425,32 @@ func buildRemote(bParams *buildParams) error {
 		// Save local log
 		ioutil.WriteFile(moscommon.GetBuildLogLocalFilePath(buildDir), logBuf.Bytes(), 0666)
 
+		if fsMapsTmpDir != "" {
+			if err := ourio.CopyDir(fsMapsTmpDir, moscommon.GetFSSourceMapsDir(buildDir), nil); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
 		// print log in verbose mode or when build fails
 		if *verbose || resp.StatusCode != http.StatusOK {
 			log, err := os.Open(moscommon.GetBuildLogFilePath(buildDir))
 			if err != nil {
 				return errors.Trace(err)
 			}
+			ci.StartGroup(os.Stdout, "Build log")
 			io.Copy(os.Stdout, log)
+			ci.EndGroup(os.Stdout)
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			return errors.Errorf("build failed")
 		}
+
+		if *compileCommands {
+			if err := writeCompileCommandsFromManifest(buildDir); err != nil {
+				return errors.Annotatef(err, "writing compilation database")
+			}
+		}
 		return nil
 
 	default:
@@ -935,6 +1459,75 @@ func buildRemote(bParams *buildParams) error {
 	}
 }
 
+// downloadBuildResults downloads the zip with the build results from the
+// URL given by the server, resuming if the connection drops. A partial
+// download left by a previous run is only resumed if the digest is known,
+// so that it can be checked.
+func downloadBuildResults(xfer *resumable.Client, url, digest string) ([]byte, error) {
+	freportf(logWriterStderr, "Downloading build results...")
+	name := digest
+	if name == "" {
+		urlHash := sha256.Sum256([]byte(url))
+		name = hex.EncodeToString(urlHash[:])
+	}
+	tmp := filepath.Join(paths.TmpDir, "build_results_"+name+".zip")
+	if digest == "" {
+		os.Remove(tmp + ".part")
+	}
+	if err := xfer.Download(url, tmp, digest); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer os.Remove(tmp)
+	data, err := ioutil.ReadFile(tmp)
+	return data, errors.Trace(err)
+}
+
+// copyBuildContext copies the app dir to dst, skipping what's ignored.
+func copyBuildContext(appDir, dst string, ignore *mosignore.Matcher) error {
+	return errors.Trace(filepath.Walk(appDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(appDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if ignore.Match(filepath.ToSlash(rel), fi.IsDir()) {
+			glog.Infof("ignoring %q", rel)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return ourio.CopyFile(p, target)
+	}))
+}
+
+// printBuildContext lists files in the zip which would be uploaded to the
+// remote builder, with the sizes.
+func printBuildContext(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var total uint64
+	for _, f := range zr.File {
+		fmt.Fprintf(w, "%d\t%s\n", f.UncompressedSize64, strings.TrimPrefix(f.Name, "src/"))
+		total += f.UncompressedSize64
+	}
+	w.Flush()
+	fmt.Printf("%d files, %d bytes, %d bytes compressed\n", len(zr.File), total, len(data))
+	return nil
+}
+
 // zipUp takes the whitelisted files and directories under path and returns an
 // in-memory zip file. The whitelist map is applied to top-level dirs and files
 // only. If some file needs to be transformed before placing into a zip
@@ -1072,6 +1665,39 @@ func getMakeArgs(dir, target string, manifest *build.FWAppManifest) ([]string, e
 	return makeArgs, nil
 }
 
+// saveBuildDeps saves the list of libs and modules used by the build, for
+// mos bundle export and mos fw diff, and records their usage for mos clean
+// --global-cache.
+func saveBuildDeps(buildDir string, deps []moscommon.BuildDep) error {
+	gitinst := mosgit.NewOurGit()
+	var dirs []string
+	for i, d := range deps {
+		dirs = append(dirs, d.Dir)
+		// Only for own checkouts, not for libs inside of the app repo
+		if _, err := os.Stat(filepath.Join(d.Dir, ".git")); err == nil {
+			if rev, err := gitinst.GetCurrentHash(d.Dir); err == nil {
+				deps[i].Revision = rev
+			}
+		}
+	}
+	if err := recordCacheUsage(dirs); err != nil {
+		// Not fatal, it only affects mos clean --global-cache
+		freportf(logWriter, "Failed to record cache usage: %s", err)
+	}
+
+	data, err := json.MarshalIndent(deps, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(moscommon.GetBuildDepsFilePath(buildDir), data, 0644))
+}
+
+// getModuleBuildVarName returns the name of the build var with the path of
+// the module.
+func getModuleBuildVarName(name string) string {
+	return fmt.Sprintf("MGOS_MODULE_%s_PATH", strings.ToUpper(moscommon.IdentifierFromString(name)))
+}
+
 // addBuildVar adds a given build variable to manifest.BuildVars, but if the
 // variable already exists, returns an error (modulo some exceptions, which
 // result in a warning instead)
@@ -1087,32 +1713,77 @@ func addBuildVar(manifest *build.FWAppManifest, name, value string) error {
 	return nil
 }
 
+// appendBuildVar appends the space-separated value to the build var, which
+// may also be set by the manifest.
+func appendBuildVar(manifest *build.FWAppManifest, name, value string) {
+	if v := manifest.BuildVars[name]; v != "" {
+		value = v + " " + value
+	}
+	manifest.BuildVars[name] = value
+}
+
+// getBuildVarLayers returns build vars given outside of mos.yml, from the
+// lowest precedence to the highest, see the buildvars package.
+func getBuildVarLayers() ([]buildvars.Layer, error) {
+	board, err := buildvars.ParseSpecs(boardBuildVarsSlice)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	flags, err := buildvars.ParseSpecs(buildVarsSlice)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []buildvars.Layer{
+		{Name: "board", Vars: board},
+		{Name: "env", Vars: buildvars.FromEnv(os.Environ())},
+		{Name: "--build-var", Vars: flags},
+	}, nil
+}
+
 func getBuildVarsFromCLI() (map[string]string, error) {
-	m := make(map[string]string)
-
-	// Add build vars from CLI flags
-	for _, v := range buildVarsSlice {
-		pp1 := strings.SplitN(v, ":", 2)
-		pp2 := strings.SplitN(v, "=", 2)
-		var pp []string
-		switch {
-		case len(pp1) == 2 && len(pp2) == 1:
-			pp = pp1
-		case len(pp1) == 1 && len(pp2) == 2:
-			pp = pp2
-		case len(pp1) == 2 && len(pp2) == 2:
-			if len(pp1[0]) < len(pp2[0]) {
-				pp = pp1
-			} else {
-				pp = pp2
-			}
-		default:
-			return nil, errors.Errorf("invalid --build-var spec: %q", v)
+	layers, err := getBuildVarLayers()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	vars, _ := buildvars.Merge(layers...)
+	return vars, nil
+}
+
+// printBuildVars prints the final build vars and where each of them comes
+// from: a lib, the app, one of the layers given outside of mos.yml, or mos
+// itself.
+func printBuildVars(manifest *build.FWAppManifest) error {
+	sources := map[string]string{}
+	for k, v := range manifest.BuildVarsSources {
+		if v == manifest_parser.RootManifestName {
+			v = "mongoose-os"
 		}
-		m[pp[0]] = pp[1]
+		sources[k] = v
+	}
+	layers, err := getBuildVarLayers()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, layerSources := buildvars.Merge(layers...)
+	for k, v := range layerSources {
+		sources[k] = v
 	}
 
-	return m, nil
+	names := []string{}
+	for k := range manifest.BuildVars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tVALUE\tSOURCE\n")
+	for _, k := range names {
+		src := sources[k]
+		if src == "" {
+			src = "mos"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", k, manifest.BuildVars[k], src)
+	}
+	return errors.Trace(w.Flush())
 }
 
 // runCmd runs given command and redirects its output to the given log file.
@@ -1172,12 +1843,18 @@ func identityTransformer(r io.ReadCloser) (io.ReadCloser, error) {
 }
 
 func getCustomLibLocations() (map[string]string, error) {
-	customLibLocations := map[string]string{}
+	// Linked libs go first, so that --lib takes precedence
+	customLibLocations, err := getLibLinks()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for name, dir := range customLibLocations {
+		ourutil.Reportf("Using lib %q from %s (linked, \"mos libs unlink %s\" to undo)", name, dir, name)
+	}
 	for _, l := range *libs {
 		parts := strings.SplitN(l, ":", 2)
 
 		// Absolutize the given lib path
-		var err error
 		parts[1], err = filepath.Abs(parts[1])
 		if err != nil {
 			return nil, errors.Trace(err)
@@ -1277,7 +1954,7 @@ func newMosVars() *interpreter.MosVars {
 }
 
 func isInDockerToolbox() bool {
-	return os.Getenv("DOCKER_HOST") != ""
+	return os.Getenv("DOCKER_HOST") != "" && !isRemoteDockerHost()
 }
 
 func absPathSlice(slice []string) ([]string, error) {
@@ -1312,6 +1989,51 @@ func getGithubLibAssetUrl(repoUrl, platform, version string) (string, error) {
 // Docker mount points {{{
 type mountPoints map[string]string
 
+// mountLibs adds mount points for the dirs of libs which are outside of
+// appMountPath, at the same paths in the container, and returns those dirs.
+// With --copy-libs, the libs are synced into the build dir, and the copies
+// are mounted instead.
+func mountLibs(mp mountPoints, libs []build.FWAppManifestLibHandled, appMountPath, buildDirAbs string) ([]string, error) {
+	var ret []string
+	// Copies are named after the lib dirs, which are not unique
+	copied := map[string]string{}
+	for _, l := range libs {
+		d, err := filepath.Abs(l.Path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if isUnderDir(d, appMountPath) {
+			continue
+		}
+		hostPath := d
+		if *copyLibs {
+			name := filepath.Base(d)
+			if other, ok := copied[name]; ok && other != d {
+				return nil, errors.Errorf("can't copy the lib %q: both %q and %q would be copied to %q, "+
+					"build without --copy-libs", l.Name, other, d, name)
+			}
+			copied[name] = d
+			hostPath = filepath.Join(moscommon.GetLibsCopyDir(buildDirAbs), name)
+			st, err := dirsync.Sync(d, hostPath, ".git")
+			if err != nil {
+				return nil, errors.Annotatef(err, "copying the lib %q", l.Name)
+			}
+			freportf(logWriter, "Copied lib %q to %q: %d updated, %d removed", l.Name, hostPath, st.Copied, st.Removed)
+		}
+		if err := mp.addMountPoint(hostPath, getPathForDocker(d)); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// isUnderDir returns true if p is dir or is inside it.
+func isUnderDir(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // addMountPoint adds a mount point from given hostPath to containerPath. If
 // something is already mounted to the given containerPath, then it's compared
 // to the new hostPath value; if they are not equal, an error is returned.
@@ -1340,9 +2062,11 @@ func (mp mountPoints) addMountPoint(hostPath, containerPath string) error {
 // Docker paths {{{
 // getPathForDocker replaces OS-dependent separators in a given path with "/"
 func getPathForDocker(p string) string {
-	ret := path.Join(strings.Split(p, string(filepath.Separator))...)
+	// Paths from manifests written on Windows, or with the long path prefix,
+	// can have mixed separators, which must not leak into makefiles.
+	ret := paths.SlashPath(p)
 	if filepath.IsAbs(p) {
-		if runtime.GOOS == "windows" && ret[1] == ':' {
+		if runtime.GOOS == "windows" && len(ret) > 1 && ret[1] == ':' {
 			// Remove the colon after drive letter, also lowercase the drive letter
 			// (the lowercasing part is important for docker toolbox: there, host
 			// paths like C:\foo\bar don't work, this path becomse /c/foo/bar)
@@ -1366,7 +2090,12 @@ func getPathsForDocker(p []string) []string {
 // }}}
 
 // Docker build {{{
-func runDockerBuild(dockerRunArgs []string) error {
+func runDockerBuild(dockerRunArgs []string, makeLogWriter io.Writer) error {
+	containerRuntime, err := getContainerRuntime()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	containerName := fmt.Sprintf(
 		"mos_build_%s_%d", time.Now().Format("2006-01-02T15-04-05-00"), rand.Int(),
 	)
@@ -1375,7 +2104,7 @@ func runDockerBuild(dockerRunArgs []string) error {
 		[]string{"run", "--name", containerName}, dockerRunArgs...,
 	)
 
-	freportf(logWriter, "Docker arguments: %s", strings.Join(dockerArgs, " "))
+	freportf(logWriter, "%s arguments: %s", containerRuntime, strings.Join(dockerArgs, " "))
 
 	// When make runs with -j and we interrupt the container with Ctrl+C, make
 	// becomes a runaway process eating 100% of one CPU core. So far we failed
@@ -1393,7 +2122,7 @@ func runDockerBuild(dockerRunArgs []string) error {
 		}
 
 		freportf(logWriterStderr, "\nCleaning up the container %q...", containerName)
-		cmd := exec.Command("docker", "kill", containerName)
+		cmd := exec.Command(containerRuntime, "kill", containerName)
 		cmd.Run()
 
 		os.Exit(1)
@@ -1407,8 +2136,8 @@ func runDockerBuild(dockerRunArgs []string) error {
 		close(sigCh)
 	}()
 
-	cmd := exec.Command("docker", dockerArgs...)
-	if err := runCmd(cmd, logWriter); err != nil {
+	cmd := exec.Command(containerRuntime, dockerArgs...)
+	if err := runCmd(cmd, makeLogWriter); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -1423,6 +2152,11 @@ func runDockerBuild(dockerRunArgs []string) error {
 type compProviderReal struct {
 	bParams   *buildParams
 	logWriter io.Writer
+
+	// Reports on libs with local modifications, by lib name; only collected
+	// with --require-clean-libs.
+	dirtyLibsLock sync.Mutex
+	dirtyLibs     map[string]string
 }
 
 func (lpr *compProviderReal) GetLibLocalPath(
@@ -1469,7 +2203,7 @@ func (lpr *compProviderReal) GetLibLocalPath(
 
 				// Try to get current hash, ignoring errors
 				curHash := ""
-				if m.GetType() == build.SWModuleTypeGithub {
+				if m.IsGit() {
 					curHash, _ = gitinst.GetCurrentHash(localDir)
 				}
 
@@ -1518,12 +2252,12 @@ func (lpr *compProviderReal) GetLibLocalPath(
 					return "", errors.Annotatef(err, "preparing local copy of the lib %q", name)
 				}
 
-				if m.GetType() == build.SWModuleTypeGithub {
+				if m.IsGit() {
 					if newHash, err := gitinst.GetCurrentHash(localDir); err == nil && newHash != curHash {
 						freportf(logWriter, "Hash is updated: %q -> %q", curHash, newHash)
 						// The current repo hash has changed after the pull, so we need to
 						// vanish the lib we might have downloaded before
-						os.RemoveAll(moscommon.GetBinaryLibsDir(localDir))
+						os.RemoveAll(paths.LongPath(moscommon.GetBinaryLibsDir(localDir)))
 
 						// But in case the lib dir is a part of the repo itself, we have to
 						// do "git checkout ." on the repo. We shouldn't be afraid of
@@ -1551,6 +2285,21 @@ func (lpr *compProviderReal) GetLibLocalPath(
 
 			break
 		}
+
+		if *requireCleanLibs && m.IsGit() {
+			isClean, err := m.IsClean(libsDir, libsDefVersion)
+			if err != nil {
+				return "", errors.Annotatef(err, "checking the lib %q", name)
+			}
+			if !isClean {
+				lpr.dirtyLibsLock.Lock()
+				if lpr.dirtyLibs == nil {
+					lpr.dirtyLibs = map[string]string{}
+				}
+				lpr.dirtyLibs[name] = getLibChangesReport(libDirAbs)
+				lpr.dirtyLibsLock.Unlock()
+			}
+		}
 	} else {
 		ourutil.Freportf(lpr.logWriter, "Using the location %q as is (given as a --lib flag)", libDirAbs)
 	}
@@ -1559,6 +2308,45 @@ func (lpr *compProviderReal) GetLibLocalPath(
 	return libDirAbs, nil
 }
 
+// getLibChangesReport returns the local changes of the lib repo: modified
+// files and commits which are not pushed.
+func getLibChangesReport(dir string) string {
+	var report []string
+	for _, args := range [][]string{
+		{"status", "--short"},
+		{"diff", "--stat", "HEAD"},
+		{"log", "--oneline", "@{upstream}..HEAD"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		// Errors are ignored: e.g. there may be no upstream for a tag checkout
+		if out, err := cmd.Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+			report = append(report, strings.TrimRight(string(out), "\n"))
+		}
+	}
+	return strings.Join(report, "\n")
+}
+
+// checkDirtyLibs fails the build if there are libs with local modifications,
+// see --require-clean-libs.
+func checkDirtyLibs(dirtyLibs map[string]string) error {
+	if len(dirtyLibs) == 0 {
+		return nil
+	}
+	names := []string{}
+	for name := range dirtyLibs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		freportf(logWriterStderr, "Lib %q has local modifications:\n%s\n", name, dirtyLibs[name])
+	}
+	return errors.Errorf(
+		"libs have local modifications: %s; commit and push, or revert them (--require-clean-libs is given)",
+		strings.Join(names, ", "),
+	)
+}
+
 func (lpr *compProviderReal) GetModuleLocalPath(
 	m *build.SWModule, rootAppDir, modulesDefVersion, platform string,
 ) (string, error) {
@@ -1578,10 +2366,30 @@ func (lpr *compProviderReal) GetModuleLocalPath(
 			return "", errors.Trace(err)
 		}
 
+		lfPath := moscommon.GetLockFilePath(projectDir)
+		lf, err := lockfile.Load(lfPath)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		pinned := lf.ModuleVersion(name, m.Location)
+		if pinned != "" && !*updateLock {
+			freportf(logWriter, "Module %q is pinned in %s to %s", name, lfPath, pinned)
+			m.Pin(pinned)
+		}
+
 		targetDir, err = m.PrepareLocalDir(getModulesDir(appDir), logWriter, true, modulesDefVersion, *libsUpdateInterval, 0)
 		if err != nil {
+			if pinned != "" && !*updateLock {
+				return "", errors.Annotatef(err,
+					"preparing local copy of the module %q pinned to %s; run with --update-lock to pin the current version",
+					name, pinned)
+			}
 			return "", errors.Annotatef(err, "preparing local copy of the module %q", name)
 		}
+
+		if err := pinModule(m, name, targetDir); err != nil {
+			return "", errors.Annotatef(err, "pinning the module %q", name)
+		}
 	} else {
 		freportf(logWriter, "Using module %q located at %q", name, targetDir)
 	}
@@ -1589,10 +2397,37 @@ func (lpr *compProviderReal) GetModuleLocalPath(
 	return targetDir, nil
 }
 
+// pinModule records the exact version of the module fetched to localDir in
+// the lockfile.
+func pinModule(m *build.SWModule, name, localDir string) error {
+	version, err := m.GetPin(localDir)
+	if err != nil || version == "" {
+		return errors.Trace(err)
+	}
+
+	lockfileLock.Lock()
+	defer lockfileLock.Unlock()
+
+	lfPath := moscommon.GetLockFilePath(projectDir)
+	lf, err := lockfile.Load(lfPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if lf.ModuleVersion(name, m.Location) == version {
+		return nil
+	}
+	lf.PinModule(name, m.Location, version)
+	if err := lf.Save(lfPath); err != nil {
+		return errors.Trace(err)
+	}
+	freportf(logWriter, "Pinned module %q to %s in %s", name, version, lfPath)
+	return nil
+}
+
 func (lpr *compProviderReal) GetMongooseOSLocalPath(
-	rootAppDir, modulesDefVersion string,
+	rootAppDir, mongooseOSRepo, modulesDefVersion string,
 ) (string, error) {
-	targetDir, err := getMosDirEffective(modulesDefVersion, *libsUpdateInterval)
+	targetDir, err := getMosDirEffective(mongooseOSRepo, modulesDefVersion, *libsUpdateInterval)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -1616,7 +2451,13 @@ func getModulesDir(projectDir string) string {
 	}
 }
 
-func getMosDirEffective(mongooseOsVersion string, updateInterval time.Duration) (string, error) {
+// upstreamMosRepo is the mongoose-os repo used unless the app manifest
+// specifies a fork in mongoose_os_repo.
+const upstreamMosRepo = "https://github.com/cesanta/mongoose-os"
+
+// getMosDirEffective returns the mongoose-os dir: either given with --repo,
+// or the local copy of the repo at mongooseOsRepo (upstream if empty).
+func getMosDirEffective(mongooseOsRepo, mongooseOsVersion string, updateInterval time.Duration) (string, error) {
 	var mosDirEffective string
 	if *mosRepo != "" {
 		freportf(logWriter, "Using mongoose-os located at %q", *mosRepo)
@@ -1629,12 +2470,17 @@ func getMosDirEffective(mongooseOsVersion string, updateInterval time.Duration)
 			return "", errors.Trace(err)
 		}
 
+		if mongooseOsRepo == "" {
+			mongooseOsRepo = upstreamMosRepo
+		} else {
+			freportf(logWriter, "Using mongoose-os fork %q", mongooseOsRepo)
+		}
+
 		m := build.SWModule{
-			// TODO(dfrank) get upstream repo URL from a flag
-			// (and this flag needs to be forwarded to fwbuild as well, which should
-			// forward it to the mos invocation)
-			Location: "https://github.com/cesanta/mongoose-os",
+			Location: mongooseOsRepo,
 			Version:  mongooseOsVersion,
+			// Not necessarily on GitHub, but always a git repo
+			Type: "git",
 		}
 
 		// NOTE: mongoose-os repo is huge, so in order to save space and time, we
@@ -1656,19 +2502,7 @@ func fetchPrebuiltBinary(m *build.SWModule, platform, tgt string) error {
 			return errors.Trace(err)
 		}
 
-		resp, err := http.Get(assetUrl)
-		if err != nil {
-			return errors.Trace(err)
-		}
-
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return errors.Errorf("got %d status code when accessed %s", resp.StatusCode, assetUrl)
-		}
-
-		// Fetched the asset successfully
-		data, err := ioutil.ReadAll(resp.Body)
+		data, err := download.Get(assetUrl)
 		if err != nil {
 			return errors.Trace(err)
 		}
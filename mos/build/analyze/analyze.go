@@ -0,0 +1,143 @@
+// Package analyze parses findings of static analyzers, cppcheck and
+// clang-tidy, run over the sources of a build.
+package analyze
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Supported analyzers.
+const (
+	Cppcheck  = "cppcheck"
+	ClangTidy = "clang-tidy"
+)
+
+// Levels of severities, from the least severe.
+const (
+	LevelNote = iota
+	LevelStyle
+	LevelWarning
+	LevelError
+)
+
+// Finding is a problem reported by an analyzer.
+type Finding struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+	// ID of the check, like "nullPointer" or "bugprone-sizeof-expression".
+	ID string
+}
+
+func (f *Finding) String() string {
+	s := fmt.Sprintf("%s:%d:%d: %s: %s", f.File, f.Line, f.Column, f.Severity, f.Message)
+	if f.ID != "" {
+		s += fmt.Sprintf(" [%s]", f.ID)
+	}
+	return s
+}
+
+// Level returns the level of the finding severity.
+func (f *Finding) Level() int {
+	switch f.Severity {
+	case "error":
+		return LevelError
+	case "warning":
+		return LevelWarning
+	case "style", "performance", "portability":
+		return LevelStyle
+	}
+	return LevelNote
+}
+
+// ParseLevel returns the level of the severity given by the user, like
+// "warning"; "none" is above all levels.
+func ParseLevel(s string) (int, error) {
+	switch s {
+	case "none":
+		return LevelError + 1, nil
+	case "error":
+		return LevelError, nil
+	case "warning":
+		return LevelWarning, nil
+	case "style":
+		return LevelStyle, nil
+	case "note":
+		return LevelNote, nil
+	}
+	return 0, errors.Errorf("unknown severity %q, expected one of: error, warning, style, note, none", s)
+}
+
+// CppcheckTemplate is the cppcheck output template which Parse understands;
+// it matches the format of clang-tidy.
+const CppcheckTemplate = "{file}:{line}:{column}: {severity}: {message} [{id}]"
+
+// Args returns the arguments of the analyzer run over the files, given the
+// dir with compile_commands.json.
+func Args(analyzer, dbDir string, files []string) ([]string, error) {
+	switch analyzer {
+	case Cppcheck:
+		// cppcheck takes the files from the database
+		return []string{
+			"cppcheck", "--project=" + dbDir + "/compile_commands.json", "--quiet", "--inline-suppr",
+			"--enable=warning,style,performance,portability", "--template=" + CppcheckTemplate,
+		}, nil
+	case ClangTidy:
+		// Cross compilers have flags clang doesn't know about
+		return append([]string{
+			"clang-tidy", "-p", dbDir, "--quiet", "--extra-arg=-Wno-unknown-warning-option",
+		}, files...), nil
+	}
+	return nil, errors.Errorf("unknown analyzer %q, expected %s or %s", analyzer, Cppcheck, ClangTidy)
+}
+
+var findingRE = regexp.MustCompile(`^(.+?):(\d+):(\d+): (\w+): (.*?)(?: \[([\w.,-]+)\])?$`)
+
+// Parse returns findings from the analyzer output; other lines, like code
+// snippets and progress, are skipped. Repeated findings, e.g. in a header
+// included by many files, are reported once.
+func Parse(r io.Reader) ([]Finding, error) {
+	var res []Finding
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := findingRE.FindStringSubmatch(strings.TrimRight(scanner.Text(), "\r"))
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		f := Finding{File: m[1], Line: line, Column: col, Severity: m[4], Message: m[5], ID: m[6]}
+		// cppcheck doesn't look into the system headers of the toolchain, which
+		// is fine but reported for each file
+		if f.Severity == "information" && f.ID == "missingIncludeSystem" {
+			continue
+		}
+		if k := f.String(); !seen[k] {
+			seen[k] = true
+			res = append(res, f)
+		}
+	}
+	return res, errors.Trace(scanner.Err())
+}
+
+// Count returns the number of findings at the level or above.
+func Count(findings []Finding, level int) int {
+	n := 0
+	for i := range findings {
+		if findings[i].Level() >= level {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	out := strings.Join([]string{
+		"/app/src/main.c:10:5: warning: Variable 'x' is assigned a value that is never used. [unreadVariable]",
+		"/app/src/main.c:12:3: error: Null pointer dereference: p [nullPointer]",
+		"/app/src/foo.cpp:7:10: warning: suspicious usage of 'sizeof(K)' [bugprone-sizeof-expression]",
+		"  int n = sizeof(K);",
+		"         ^",
+		"/app/src/foo.h:3:1: note: expanded from macro 'FOO'",
+		"/app/src/main.c:12:3: error: Null pointer dereference: p [nullPointer]",
+		"1 warning generated.",
+		"nofile:0:0: information: Include file <stdio.h> not found. [missingIncludeSystem]",
+	}, "\n")
+	fs, err := Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 4 {
+		t.Fatalf("got %d findings, want 4: %+v", len(fs), fs)
+	}
+	if f := fs[1]; f.File != "/app/src/main.c" || f.Line != 12 || f.Column != 3 || f.Severity != "error" || f.ID != "nullPointer" {
+		t.Errorf("bad finding: %+v", f)
+	}
+	if f := fs[3]; f.ID != "" || f.Message != "expanded from macro 'FOO'" || f.Level() != LevelNote {
+		t.Errorf("bad note: %+v", f)
+	}
+	if got := fs[2].String(); got != "/app/src/foo.cpp:7:10: warning: suspicious usage of 'sizeof(K)' [bugprone-sizeof-expression]" {
+		t.Errorf("bad string: %q", got)
+	}
+
+	for _, c := range []struct {
+		level string
+		want  int
+	}{{"error", 1}, {"warning", 3}, {"note", 4}, {"none", 0}} {
+		l, err := ParseLevel(c.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Count(fs, l); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.level, got, c.want)
+		}
+	}
+	if _, err := ParseLevel("bad"); err == nil {
+		t.Errorf("no error for a bad level")
+	}
+}
+
+func TestArgs(t *testing.T) {
+	args, err := Args(ClangTidy, "/b", []string{"/a.c"})
+	if err != nil || args[0] != "clang-tidy" || args[len(args)-1] != "/a.c" {
+		t.Errorf("bad clang-tidy args: %q, %v", args, err)
+	}
+	args, err = Args(Cppcheck, "/b", []string{"/a.c"})
+	if err != nil || args[1] != "--project=/b/compile_commands.json" {
+		t.Errorf("bad cppcheck args: %q, %v", args, err)
+	}
+	if _, err := Args("lint", "/b", nil); err == nil {
+		t.Errorf("no error for an unknown analyzer")
+	}
+}
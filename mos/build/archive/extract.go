@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// IsArchive returns true if the file name has one of the extensions Extract
+// knows about.
+func IsArchive(name string) bool {
+	return isTarGz(name) || strings.HasSuffix(strings.ToLower(name), ".zip")
+}
+
+func isTarGz(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// TrimExt returns the file name without the archive extension.
+func TrimExt(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// Extract unpacks the zip or tar.gz archive, determined by the name, into the
+// directory. If all files in the archive are under a single top level
+// directory, like in release tarballs, that directory is skipped.
+func Extract(data []byte, name, dir string) error {
+	if isTarGz(name) {
+		names, err := tarNames(data)
+		if err != nil {
+			return errors.Annotatef(err, "%s", name)
+		}
+		return errors.Trace(untarGzInto(data, dir, commonTopLevel(names)))
+	}
+	r := bytes.NewReader(data)
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return errors.Annotatef(err, "%s", name)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return errors.Trace(UnzipInto(r, r.Size(), dir, commonTopLevel(names)))
+}
+
+// commonTopLevel returns 1 if all names are under the same top level
+// directory, 0 otherwise.
+func commonTopLevel(names []string) int {
+	top := ""
+	for _, n := range names {
+		n = strings.TrimPrefix(n, "./")
+		isDir := strings.HasSuffix(n, "/")
+		parts := strings.SplitN(strings.TrimSuffix(n, "/"), "/", 2)
+		if len(parts) == 1 && !isDir {
+			// A file at the top level
+			return 0
+		}
+		if top == "" {
+			top = parts[0]
+		} else if parts[0] != top {
+			return 0
+		}
+	}
+	if top == "" {
+		return 0
+	}
+	return 1
+}
+
+func tarNames(data []byte) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		names = append(names, h.Name)
+	}
+}
+
+// isLinkInside returns whether the symlink at the relative path rel,
+// pointing to target, stays within the directory it's extracted to.
+func isLinkInside(rel, target string) bool {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return false
+	}
+	p := filepath.Join(filepath.Dir(rel), filepath.FromSlash(target))
+	return p != ".." && !strings.HasPrefix(p, ".."+string(filepath.Separator))
+}
+
+func untarGzInto(data []byte, dir string, skipLevels int) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+
+		cs := strings.Split(strings.TrimPrefix(h.Name, "./"), "/")
+		if len(cs) <= skipLevels || h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		rel := filepath.Join(cs[skipLevels:]...)
+		if rel == "." || rel == "" {
+			continue
+		}
+		if strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." || filepath.IsAbs(rel) {
+			return errors.Errorf("%s: path is outside of the archive", h.Name)
+		}
+		filePath := filepath.Join(dir, rel)
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return errors.Trace(err)
+			}
+		case tar.TypeSymlink:
+			// Files extracted later could be written through the link
+			if !isLinkInside(rel, h.Linkname) {
+				return errors.Errorf("%s: link to %s is outside of the archive", h.Name, h.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return errors.Trace(err)
+			}
+			if err := os.Symlink(h.Linkname, filePath); err != nil {
+				return errors.Trace(err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return errors.Trace(err)
+			}
+			dest, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(h.Mode)&0777)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			_, err = io.Copy(dest, tr)
+			dest.Close()
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeTarGz returns a tarball with the files; data starting with "-> " makes
+// a symlink to the rest of it.
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if strings.HasPrefix(data, "-> ") {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, data[3:], 0
+			data = ""
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestExtractTarGz(t *testing.T) {
+	for i, c := range []struct {
+		files    map[string]string
+		expected string
+	}{
+		{map[string]string{"sdk-1.0/include/sdk.h": "h", "sdk-1.0/README": "r"}, "include/sdk.h"},
+		{map[string]string{"include/sdk.h": "h", "README": "r"}, "include/sdk.h"},
+		{map[string]string{"include/sdk.h": "h", "lib/sdk.h": "-> ../include/sdk.h"}, "lib/sdk.h"},
+	} {
+		dir, err := ioutil.TempDir("", "extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := Extract(makeTarGz(t, c.files), "sdk.tar.gz", dir); err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, c.expected)); err != nil || string(data) != "h" {
+			t.Errorf("#%d: %s is not extracted: %v", i, c.expected, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, files := range []map[string]string{
+		{"a/../../evil": "x"},
+		{"etc": "-> /etc"},
+		{"a/etc": "-> ../../etc"},
+	} {
+		if err := Extract(makeTarGz(t, files), "evil.tgz", dir); err == nil {
+			t.Errorf("%v: expected an error", files)
+		}
+	}
+}
+
+func TestTrimExt(t *testing.T) {
+	for in, exp := range map[string]string{"sdk-1.0.tar.gz": "sdk-1.0", "sdk.ZIP": "sdk", "sdk.tgz": "sdk", "sdk": "sdk"} {
+		if got := TrimExt(in); got != exp {
+			t.Errorf("%q: expected %q, got %q", in, exp, got)
+		}
+	}
+}
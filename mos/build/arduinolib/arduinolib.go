@@ -0,0 +1,225 @@
+// Package arduinolib resolves Arduino libraries from the Arduino library
+// index and tells their sources and include dirs, so that they can be built
+// into the firmware directly, without a wrapper lib.
+package arduinolib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// IndexURL is the location of the Arduino library index.
+const IndexURL = "https://downloads.arduino.cc/libraries/library_index.json.gz"
+
+// Release is a version of a library in the index.
+type Release struct {
+	Name            string       `json:"name"`
+	Version         string       `json:"version"`
+	URL             string       `json:"url"`
+	ArchiveFileName string       `json:"archiveFileName"`
+	Checksum        string       `json:"checksum"`
+	Dependencies    []Dependency `json:"dependencies,omitempty"`
+}
+
+// Dependency is another library the release needs.
+type Dependency struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// SHA256 returns the hex digest of the release archive, if the index has it.
+func (r *Release) SHA256() string {
+	if strings.HasPrefix(r.Checksum, "SHA-256:") {
+		return strings.ToLower(r.Checksum[len("SHA-256:"):])
+	}
+	return ""
+}
+
+// Index is the Arduino library index.
+type Index struct {
+	Libraries []Release `json:"libraries"`
+}
+
+// ParseIndex parses the index data, gzipped or not.
+func ParseIndex(data []byte) (*Index, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return nil, errors.Annotatef(err, "failed to decompress the library index")
+		}
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, errors.Annotatef(err, "invalid library index")
+	}
+	return &idx, nil
+}
+
+// Find returns the release of the library with the highest version matching
+// the spec: an exact version like "1.2.3", a prefix like "1.2", or any if
+// the spec is empty or "latest". Library names are matched case-insensitively.
+func (idx *Index) Find(name, spec string) (*Release, error) {
+	spec = strings.TrimPrefix(spec, "v")
+	var best *Release
+	found := false
+	for i, r := range idx.Libraries {
+		if !strings.EqualFold(r.Name, name) {
+			continue
+		}
+		found = true
+		if r.Version == spec {
+			return &idx.Libraries[i], nil
+		}
+		if spec != "" && spec != "latest" && !strings.HasPrefix(r.Version, spec+".") {
+			continue
+		}
+		if best == nil || compareVersions(r.Version, best.Version) > 0 {
+			best = &idx.Libraries[i]
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("library %q is not in the Arduino library index", name)
+	}
+	if best == nil {
+		return nil, errors.Errorf("library %q has no version matching %q", name, spec)
+	}
+	return best, nil
+}
+
+// Resolve returns releases of the requested libraries (name to version
+// spec) and all libraries they depend on. Requested versions win over the
+// ones dependencies ask for. Releases are sorted by name.
+func (idx *Index) Resolve(libs map[string]string) ([]*Release, error) {
+	res := map[string]*Release{}
+	var queue []Dependency
+	names := make([]string, 0, len(libs))
+	for name := range libs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		queue = append(queue, Dependency{Name: name, Version: libs[name]})
+	}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		key := strings.ToLower(d.Name)
+		if res[key] != nil {
+			continue
+		}
+		r, err := idx.Find(d.Name, d.Version)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res[key] = r
+		queue = append(queue, r.Dependencies...)
+	}
+	var rels []*Release
+	for _, r := range res {
+		rels = append(rels, r)
+	}
+	sort.Slice(rels, func(i, j int) bool { return rels[i].Name < rels[j].Name })
+	return rels, nil
+}
+
+// DirName returns the name of the library dir: the library name with
+// anything but letters, digits, dots and dashes replaced with underscores.
+func DirName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+var sourceExts = map[string]bool{".c": true, ".cpp": true, ".cc": true, ".S": true}
+
+// Layout returns the source files and include dirs of the library extracted
+// to dir. In the 1.5 layout, with the src dir, all sources under src are
+// built; in the legacy one, the sources in the root dir and in utility are.
+func Layout(dir string) (sources []string, includes []string, err error) {
+	srcDir := filepath.Join(dir, "src")
+	if st, err := os.Stat(srcDir); err == nil && st.IsDir() {
+		err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !info.IsDir() && sourceExts[filepath.Ext(path)] {
+				sources = append(sources, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return sources, []string{srcDir}, nil
+	}
+
+	includes = []string{dir}
+	for _, d := range []string{dir, filepath.Join(dir, "utility")} {
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, errors.Trace(err)
+		}
+		if d != dir {
+			includes = append(includes, d)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && sourceExts[filepath.Ext(e.Name())] {
+				sources = append(sources, filepath.Join(d, e.Name()))
+			}
+		}
+	}
+	return sources, includes, nil
+}
+
+// compareVersions compares dot-separated numeric versions; pre-release
+// suffixes, like "-beta", are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	if i := strings.IndexAny(v, "-+~"); i >= 0 {
+		v = v[:i]
+	}
+	var res []int
+	for _, p := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(p)
+		res = append(res, n)
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package arduinolib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testIndex = `{"libraries": [
+  {"name": "Adafruit NeoPixel", "version": "1.9.0", "url": "https://x/neo-1.9.0.zip", "checksum": "SHA-256:AB12"},
+  {"name": "Adafruit NeoPixel", "version": "1.10.2", "url": "https://x/neo-1.10.2.zip"},
+  {"name": "Adafruit GFX Library", "version": "1.11.0", "url": "https://x/gfx.zip",
+   "dependencies": [{"name": "Adafruit BusIO"}]},
+  {"name": "Adafruit BusIO", "version": "1.14.0", "url": "https://x/busio.zip"},
+  {"name": "Adafruit BusIO", "version": "1.2.0", "url": "https://x/busio-old.zip"}
+]}`
+
+func TestIndex(t *testing.T) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(testIndex))
+	zw.Close()
+	idx, err := ParseIndex(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := idx.Find("adafruit neopixel", "")
+	if err != nil || r.Version != "1.10.2" {
+		t.Errorf("expected 1.10.2, got %+v, %v", r, err)
+	}
+	r, err = idx.Find("Adafruit NeoPixel", "1.9")
+	if err != nil || r.Version != "1.9.0" || r.SHA256() != "ab12" {
+		t.Errorf("expected 1.9.0, got %+v, %v", r, err)
+	}
+	if _, err := idx.Find("Adafruit NeoPixel", "2"); err == nil {
+		t.Errorf("expected an error for a missing version")
+	}
+	if _, err := idx.Find("Nope", ""); err == nil {
+		t.Errorf("expected an error for a missing lib")
+	}
+
+	rels, err := idx.Resolve(map[string]string{"Adafruit GFX Library": "", "Adafruit BusIO": "1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rels {
+		got = append(got, r.Name+"@"+r.Version)
+	}
+	expected := []string{"Adafruit BusIO@1.2.0", "Adafruit GFX Library@1.11.0"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arduinolib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string) {
+		fname := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(fname), 0755)
+		if err := ioutil.WriteFile(fname, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Legacy layout
+	write("old/Foo.cpp")
+	write("old/Foo.h")
+	write("old/utility/bar.c")
+	write("old/examples/ex/ex.ino")
+	srcs, incs, err := Layout(filepath.Join(dir, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(dir, "old/Foo.cpp"), filepath.Join(dir, "old/utility/bar.c")}; !reflect.DeepEqual(srcs, expected) {
+		t.Errorf("expected %v, got %v", expected, srcs)
+	}
+	if expected := []string{filepath.Join(dir, "old"), filepath.Join(dir, "old/utility")}; !reflect.DeepEqual(incs, expected) {
+		t.Errorf("expected %v, got %v", expected, incs)
+	}
+
+	// 1.5 layout
+	write("new/src/Foo.cpp")
+	write("new/src/impl/baz.c")
+	write("new/extras/x.cpp")
+	srcs, incs, err = Layout(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(dir, "new/src/Foo.cpp"), filepath.Join(dir, "new/src/impl/baz.c")}; !reflect.DeepEqual(srcs, expected) {
+		t.Errorf("expected %v, got %v", expected, srcs)
+	}
+	if expected := []string{filepath.Join(dir, "new/src")}; !reflect.DeepEqual(incs, expected) {
+		t.Errorf("expected %v, got %v", expected, incs)
+	}
+
+	if DirName("Adafruit GFX Library") != "Adafruit_GFX_Library" {
+		t.Errorf("unexpected dir name %q", DirName("Adafruit GFX Library"))
+	}
+}
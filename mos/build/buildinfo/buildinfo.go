@@ -0,0 +1,154 @@
+// Package buildinfo generates C source which embeds custom build info, like
+// the git description of the tree, the CI job URL or the customer ID, into
+// the firmware, and finds it in firmware images, so that a unit in the field
+// can be traced to the exact build.
+//
+// The info is a JSON object stored as a C string prefixed with Marker. The
+// generated header declares
+//
+//	extern const char *build_info_custom_json;
+//	bool build_info_custom_init(void);
+//
+// build_info_custom_init, called from mgos_app_init, registers the
+// Sys.GetBuildInfo RPC handler, which returns the info, if the app has RPC.
+// It also keeps the info from being garbage collected by the linker.
+package buildinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// HeaderName is the name of the header to include in the app code.
+	HeaderName = "build_info_custom.h"
+	// SourceName is the name of the generated C file.
+	SourceName = "build_info_custom.c"
+	// Marker precedes the JSON in the firmware image.
+	Marker = "MOS_BUILD_INFO:"
+	// RPCMethod is the device method which returns the info.
+	RPCMethod = "Sys.GetBuildInfo"
+)
+
+var varRe = regexp.MustCompile(`\$\{([a-zA-Z0-9_.]+)\}`)
+
+// Expand replaces references like ${env.CI_JOB_URL} or ${git.describe} in
+// the values with what lookup returns for the names.
+func Expand(info map[string]string, lookup func(name string) (string, error)) (map[string]string, error) {
+	res := map[string]string{}
+	for k, v := range info {
+		var err error
+		res[k] = varRe.ReplaceAllStringFunc(v, func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			var val string
+			val, err = lookup(varRe.FindStringSubmatch(ref)[1])
+			return val
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "build_info.%s", k)
+		}
+	}
+	return res, nil
+}
+
+const header = `/* Generated by mos, do not edit. */
+
+#pragma once
+
+#include <stdbool.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Custom build info, a JSON object. */
+extern const char *build_info_custom_json;
+
+/* Registers the ` + RPCMethod + ` RPC handler, if the app has RPC. */
+bool build_info_custom_init(void);
+
+#ifdef __cplusplus
+}
+#endif
+`
+
+const sourceTmpl = `/* Generated by mos, do not edit. */
+
+#include "%s"
+
+static const char s_build_info[] = %s;
+
+const char *build_info_custom_json = s_build_info + %d;
+
+#if MGOS_HAVE_RPC_COMMON
+#include "mg_rpc.h"
+#include "mgos_rpc.h"
+
+static void build_info_handler(struct mg_rpc_request_info *ri, void *cb_arg,
+                               struct mg_rpc_frame_info *fi,
+                               struct mg_str args) {
+  mg_rpc_send_responsef(ri, "%%s", build_info_custom_json);
+  (void) cb_arg;
+  (void) fi;
+  (void) args;
+}
+#endif
+
+bool build_info_custom_init(void) {
+#if MGOS_HAVE_RPC_COMMON
+  mg_rpc_add_handler(mgos_rpc_get_global(), "%s", "", build_info_handler,
+                     NULL);
+#endif
+  return true;
+}
+`
+
+// Generate writes the header and the source to dir, and returns the path of
+// the source.
+func Generate(info map[string]string, dir string) (string, error) {
+	// Keys are sorted, so the output is the same for the same info
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, HeaderName), []byte(header), 0644); err != nil {
+		return "", errors.Trace(err)
+	}
+	src := fmt.Sprintf(sourceTmpl, HeaderName, strconv.Quote(Marker+string(data)), len(Marker), RPCMethod)
+	srcFile := filepath.Join(dir, SourceName)
+	if err := ioutil.WriteFile(srcFile, []byte(src), 0644); err != nil {
+		return "", errors.Trace(err)
+	}
+	return srcFile, nil
+}
+
+// Find returns the build info found in the firmware image, or nil if there
+// is none.
+func Find(data []byte) (map[string]string, error) {
+	i := bytes.Index(data, []byte(Marker))
+	if i < 0 {
+		return nil, nil
+	}
+	data = data[i+len(Marker):]
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		data = data[:end]
+	}
+	var info map[string]string
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.Annotatef(err, "invalid build info")
+	}
+	return info, nil
+}
@@ -0,0 +1,85 @@
+package buildinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cesanta/errors"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"env.CI_JOB_URL": "https://ci/jobs/42", "git.describe": "v1.2-3-gabc"}
+	lookup := func(name string) (string, error) {
+		if v, ok := vars[name]; ok {
+			return v, nil
+		}
+		return "", errors.Errorf("unknown %s", name)
+	}
+	got, err := Expand(map[string]string{
+		"ci":       "${env.CI_JOB_URL}",
+		"version":  "${git.describe} (custom)",
+		"customer": "acme",
+	}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"ci": "https://ci/jobs/42", "version": "v1.2-3-gabc (custom)", "customer": "acme"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := Expand(map[string]string{"x": "${nope}"}, lookup); err == nil {
+		t.Errorf("unknown reference expanded")
+	}
+}
+
+func TestGenerateAndFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	info := map[string]string{"customer": "acme", "note": "quote \" backslash \\ newline \n ümlaut"}
+	srcFile, err := Generate(info, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, HeaderName)); err != nil {
+		t.Errorf("no header: %s", err)
+	}
+	src, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Emulate what the compiler puts into the image: the string literal,
+	// unquoted and NUL-terminated
+	m := regexp.MustCompile(`s_build_info\[\] = (".*");`).FindStringSubmatch(string(src))
+	if m == nil {
+		t.Fatalf("no string in the source:\n%s", src)
+	}
+	str, err := strconv.Unquote(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(str, Marker) {
+		t.Errorf("no marker: %q", str)
+	}
+	image := append([]byte("\x00\x01junk"), append([]byte(str), 0, 0xff, 0xfe)...)
+	got, err := Find(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("got %v, want %v", got, info)
+	}
+
+	if got, err := Find([]byte("no info here")); got != nil || err != nil {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
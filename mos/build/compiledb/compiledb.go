@@ -0,0 +1,261 @@
+// Package compiledb produces compile_commands.json, the compilation database
+// used by clangd and IDEs, from the verbose build log, or from the manifest
+// when there is no log.
+package compiledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FileName is the name of the database file.
+const FileName = "compile_commands.json"
+
+// Command is an entry of the database.
+type Command struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+	File      string   `json:"file"`
+}
+
+var (
+	compilerRE = regexp.MustCompile(`(^|[-/])(gcc|g\+\+|cc|c\+\+|clang|clang\+\+)$`)
+	cdRE       = regexp.MustCompile(`^\s*cd\s+(\S+)\s*&&\s*(.*)$`)
+	sourceExts = map[string]bool{".c": true, ".cc": true, ".cpp": true, ".cxx": true, ".S": true}
+)
+
+// ParseLog returns the compiler invocations found in the build log, which
+// must be produced with commands echoed (like make V=1). Commands are
+// considered to run in defaultDir, unless prefixed with "cd dir &&".
+func ParseLog(r io.Reader, defaultDir string) ([]Command, error) {
+	var cmds []Command
+	seen := map[string]int{}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		dir := defaultDir
+		if m := cdRE.FindStringSubmatch(line); m != nil {
+			dir, line = m[1], m[2]
+		}
+		args := splitArgs(line)
+		if len(args) < 2 || !compilerRE.MatchString(args[0]) {
+			continue
+		}
+		file := ""
+		hasC := false
+		for i, a := range args[1:] {
+			if a == "-c" {
+				hasC = true
+			} else if !strings.HasPrefix(a, "-") && sourceExts[path.Ext(a)] && (i == 0 || args[i] != "-o") {
+				file = a
+			}
+		}
+		if !hasC || file == "" {
+			continue
+		}
+		c := Command{Directory: dir, Arguments: args, File: file}
+		// Later invocations win, e.g. after a rebuild with different flags
+		if i, ok := seen[file]; ok {
+			cmds[i] = c
+			continue
+		}
+		seen[file] = len(cmds)
+		cmds = append(cmds, c)
+	}
+	return cmds, s.Err()
+}
+
+// splitArgs splits the shell command line into arguments, handling single
+// and double quotes and backslash escapes.
+func splitArgs(line string) []string {
+	var args []string
+	var cur bytes.Buffer
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// MapPaths rewrites container paths in the commands to host paths, given
+// the container to host path map. Paths are also recognized in arguments
+// like -I/path.
+func MapPaths(cmds []Command, containerToHost map[string]string) {
+	var prefixes []string
+	for p := range containerToHost {
+		prefixes = append(prefixes, p)
+	}
+	// Longest first, so that nested mounts take precedence
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	mapPath := func(p string) string {
+		for _, cp := range prefixes {
+			if p == cp || strings.HasPrefix(p, strings.TrimSuffix(cp, "/")+"/") {
+				return filepath.Join(containerToHost[cp], filepath.FromSlash(p[len(cp):]))
+			}
+		}
+		return p
+	}
+	mapArg := func(a string) string {
+		i := strings.Index(a, "/")
+		if i < 0 {
+			return a
+		}
+		if i > 0 && (!strings.HasPrefix(a, "-") || strings.ContainsAny(a[:i], "=.")) {
+			// Not a path or an option with a path, like "-I/path"
+			if j := strings.Index(a, "=/"); j >= 0 {
+				// Option like --sysroot=/path
+				return a[:j+1] + mapPath(a[j+1:])
+			}
+			return a
+		}
+		return a[:i] + mapPath(a[i:])
+	}
+	for i := range cmds {
+		c := &cmds[i]
+		c.Directory = mapPath(c.Directory)
+		c.File = mapPath(c.File)
+		for j, a := range c.Arguments {
+			c.Arguments[j] = mapArg(a)
+		}
+	}
+}
+
+// Synthesize returns commands for the given sources built with the flags;
+// that's what the database can be made of when the build ran remotely.
+func Synthesize(
+	sources []string, dir string, cflags, cxxflags []string, cdefs map[string]string, includes []string,
+) []Command {
+	var common []string
+	names := make([]string, 0, len(cdefs))
+	for k := range cdefs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		common = append(common, fmt.Sprintf("-D%s=%s", k, cdefs[k]))
+	}
+	for _, inc := range includes {
+		common = append(common, "-I"+inc)
+	}
+	var cmds []Command
+	for _, f := range sources {
+		var args []string
+		switch filepath.Ext(f) {
+		case ".c", ".S":
+			args = append([]string{"gcc"}, cflags...)
+		case ".cc", ".cpp", ".cxx":
+			args = append([]string{"g++"}, cxxflags...)
+		default:
+			continue
+		}
+		args = append(args, common...)
+		args = append(args, "-c", f)
+		cmds = append(cmds, Command{Directory: dir, Arguments: args, File: f})
+	}
+	return cmds
+}
+
+// Merge returns the commands of the old database updated with the new ones:
+// incremental builds only compile what's changed. Entries for which keep
+// returns false, e.g. for removed files, are dropped.
+func Merge(old, cmds []Command, keep func(c *Command) bool) []Command {
+	idx := map[string]int{}
+	var res []Command
+	for _, c := range append(append([]Command{}, old...), cmds...) {
+		if i, ok := idx[c.File]; ok {
+			res[i] = c
+			continue
+		}
+		idx[c.File] = len(res)
+		res = append(res, c)
+	}
+	var kept []Command
+	for i := range res {
+		if keep == nil || keep(&res[i]) {
+			kept = append(kept, res[i])
+		}
+	}
+	return kept
+}
+
+// Marshal returns the database JSON.
+func Marshal(cmds []Command) ([]byte, error) {
+	if cmds == nil {
+		cmds = []Command{}
+	}
+	data, err := json.MarshalIndent(cmds, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ClangdName is the name of the clangd config file.
+const ClangdName = ".clangd"
+
+const clangdHeader = "# Generated by mos build --clangd"
+
+// GCC flags which clang doesn't know, and fails to parse the file with.
+var clangdRemove = []string{
+	"-mlongcalls", "-mtext-section-literals", "-mfix-esp32-psram-cache-issue*",
+	"-fstrict-volatile-bitfields", "-fno-tree-switch-conversion", "-mno-serialize-volatile",
+	"-fno-inline-functions", "-free", "-fipa-pta", "-specs=*", "-mabi=*",
+}
+
+// ClangdConfig returns the clangd config pointing to the database dir,
+// relative to the project dir.
+func ClangdConfig(dbDir string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s; edits are overwritten unless this line is removed.\n", clangdHeader)
+	fmt.Fprintf(&b, "CompileFlags:\n  CompilationDatabase: %s\n  Remove:\n", filepath.ToSlash(dbDir))
+	for _, f := range clangdRemove {
+		fmt.Fprintf(&b, "    - %q\n", f)
+	}
+	fmt.Fprintf(&b, "Diagnostics:\n  UnusedIncludes: None\n")
+	return b.Bytes()
+}
+
+// IsGeneratedClangd returns whether the clangd config was written by
+// ClangdConfig, and may be overwritten.
+func IsGeneratedClangd(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(clangdHeader))
+}
@@ -0,0 +1,86 @@
+package compiledb
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLog = `Building...
+  CC    /app/src/main.c
+xtensa-esp32-elf-gcc -std=gnu99 -Os -DFOO="\"bar baz\"" -I/app/include -I/mongoose-os/include -c /app/src/main.c -o /app/build/objs/main.o
+cd /app/build/objs && /opt/xt/bin/xtensa-esp32-elf-g++ -fno-rtti --sysroot=/mongoose-os/sysroot -c -o lib.o /libs/foo/src/lib.cpp
+xtensa-esp32-elf-gcc -o /app/build/objs/fw.elf /app/build/objs/main.o
+xtensa-esp32-elf-gcc -O0 -c /app/src/main.c -o /app/build/objs/main.o
+make: Leaving directory '/app'
+`
+
+func TestParseAndMap(t *testing.T) {
+	cmds, err := ParseLog(strings.NewReader(testLog), "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, got %+v", cmds)
+	}
+	if cmds[0].File != "/app/src/main.c" || cmds[0].Arguments[1] != "-O0" {
+		t.Errorf("expected the last main.c command, got %+v", cmds[0])
+	}
+	if cmds[1].Directory != "/app/build/objs" || cmds[1].File != "/libs/foo/src/lib.cpp" {
+		t.Errorf("unexpected lib.cpp command %+v", cmds[1])
+	}
+
+	cmds, _ = ParseLog(strings.NewReader(testLog[:strings.Index(testLog, "cd /app")]), "/app")
+	if cmds[0].Arguments[3] != `-DFOO="bar baz"` {
+		t.Errorf("unexpected quoting: %q", cmds[0].Arguments)
+	}
+
+	cmds, _ = ParseLog(strings.NewReader(testLog), "/app")
+	MapPaths(cmds, map[string]string{
+		"/app":          "/home/u/proj",
+		"/app/build":    "/home/u/build",
+		"/mongoose-os":  "/home/u/.mos/mongoose-os",
+		"/libs/foo/src": "/home/u/foo/src",
+	})
+	h := filepath.FromSlash
+	if cmds[0].File != h("/home/u/proj/src/main.c") || cmds[0].Directory != h("/home/u/proj") {
+		t.Errorf("unexpected mapping %+v", cmds[0])
+	}
+	expected := []string{
+		"/opt/xt/bin/xtensa-esp32-elf-g++", "-fno-rtti", "--sysroot=" + h("/home/u/.mos/mongoose-os/sysroot"),
+		"-c", "-o", "lib.o", h("/home/u/foo/src/lib.cpp"),
+	}
+	if !reflect.DeepEqual(cmds[1].Arguments, expected) || cmds[1].Directory != h("/home/u/build/objs") {
+		t.Errorf("expected %q, got %+v", expected, cmds[1])
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	cmds := Synthesize(
+		[]string{"/p/src/a.c", "/p/src/b.cpp", "/p/src/c.h"}, "/p",
+		[]string{"-Wall"}, []string{"-std=c++11"}, map[string]string{"X": "1"}, []string{"/p/include"},
+	)
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, got %+v", cmds)
+	}
+	if e := []string{"gcc", "-Wall", "-DX=1", "-I/p/include", "-c", "/p/src/a.c"}; !reflect.DeepEqual(cmds[0].Arguments, e) {
+		t.Errorf("expected %q, got %q", e, cmds[0].Arguments)
+	}
+	if cmds[1].Arguments[0] != "g++" || cmds[1].Arguments[1] != "-std=c++11" {
+		t.Errorf("unexpected C++ command %q", cmds[1].Arguments)
+	}
+	if !IsGeneratedClangd(ClangdConfig("build")) {
+		t.Errorf("clangd config is not recognized as generated")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	old := []Command{{File: "a.c", Directory: "1"}, {File: "b.c", Directory: "1"}, {File: "gone.c"}}
+	cmds := []Command{{File: "b.c", Directory: "2"}, {File: "c.c", Directory: "2"}}
+	res := Merge(old, cmds, func(c *Command) bool { return c.File != "gone.c" })
+	expected := []Command{{File: "a.c", Directory: "1"}, {File: "b.c", Directory: "2"}, {File: "c.c", Directory: "2"}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %+v, got %+v", expected, res)
+	}
+}
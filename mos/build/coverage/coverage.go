@@ -0,0 +1,168 @@
+// Package coverage merges lcov tracefiles, e.g. of several test binaries,
+// and summarizes the line coverage.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Data are execution counts of lines, by file and line number.
+type Data map[string]map[int]int
+
+// ParseLCOV adds the line counts from the lcov tracefile to the data.
+func (d Data) ParseLCOV(r io.Reader) error {
+	var file string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = line[3:]
+			if d[file] == nil {
+				d[file] = map[int]int{}
+			}
+		case strings.HasPrefix(line, "DA:") && file != "":
+			// DA:<line>,<count>[,<checksum>]
+			parts := strings.Split(line[3:], ",")
+			if len(parts) < 2 {
+				return errors.Errorf("invalid line %q", line)
+			}
+			n, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return errors.Errorf("invalid line %q", line)
+			}
+			count, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return errors.Errorf("invalid line %q", line)
+			}
+			d[file][n] += count
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return errors.Trace(scanner.Err())
+}
+
+// Filter removes the files for which keep returns false.
+func (d Data) Filter(keep func(file string) bool) {
+	for f := range d {
+		if !keep(f) {
+			delete(d, f)
+		}
+	}
+}
+
+// FileSummary is the line coverage of a file.
+type FileSummary struct {
+	File  string
+	Lines int
+	Hit   int
+}
+
+// Percent returns the percentage of the lines hit; no lines are 100%.
+func (s *FileSummary) Percent() float64 {
+	if s.Lines == 0 {
+		return 100
+	}
+	return float64(s.Hit) * 100 / float64(s.Lines)
+}
+
+// Summary is the line coverage of all files, and the total.
+type Summary struct {
+	Files []FileSummary
+	Total FileSummary
+}
+
+// Summarize returns the summary of the data, with files sorted by name.
+func (d Data) Summarize() *Summary {
+	s := &Summary{Total: FileSummary{File: "Total"}}
+	for f, lines := range d {
+		fs := FileSummary{File: f, Lines: len(lines)}
+		for _, count := range lines {
+			if count > 0 {
+				fs.Hit++
+			}
+		}
+		s.Files = append(s.Files, fs)
+		s.Total.Lines += fs.Lines
+		s.Total.Hit += fs.Hit
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].File < s.Files[j].File })
+	return s
+}
+
+// WriteText writes the summary as a table.
+func (s *Summary) WriteText(w io.Writer) {
+	for _, fs := range append(s.Files, s.Total) {
+		fmt.Fprintf(w, "%6.1f%% %5d/%-5d %s\n", fs.Percent(), fs.Hit, fs.Lines, fs.File)
+	}
+}
+
+// WriteLCOV writes the data as a tracefile, e.g. for genhtml or CI services.
+func (d Data) WriteLCOV(w io.Writer) error {
+	var files []string
+	for f := range d {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		var lines []int
+		hit := 0
+		for n, count := range d[f] {
+			lines = append(lines, n)
+			if count > 0 {
+				hit++
+			}
+		}
+		sort.Ints(lines)
+		if _, err := fmt.Fprintf(w, "SF:%s\n", f); err != nil {
+			return errors.Trace(err)
+		}
+		for _, n := range lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", n, d[f][n])
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+var htmlTmpl = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"pct": func(fs FileSummary) string { return fmt.Sprintf("%.1f%%", fs.Percent()) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 2px 8px; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+tr.total { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>File</th><th>Lines</th><th>Hit</th><th>Coverage</th></tr>
+{{range .Summary.Files}}<tr><td>{{.File}}</td><td>{{.Lines}}</td><td>{{.Hit}}</td><td>{{pct .}}</td></tr>
+{{end}}<tr class="total"><td>Total</td><td>{{.Summary.Total.Lines}}</td><td>{{.Summary.Total.Hit}}</td><td>{{pct .Summary.Total}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the summary as an HTML page.
+func (s *Summary) WriteHTML(w io.Writer, title string) error {
+	return errors.Trace(htmlTmpl.Execute(w, struct {
+		Title   string
+		Summary *Summary
+	}{title, s}))
+}
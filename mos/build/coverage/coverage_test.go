@@ -0,0 +1,80 @@
+package coverage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const trace1 = `TN:
+SF:/app/src/foo.c
+FN:3,foo
+DA:3,1
+DA:4,1
+DA:6,0
+LF:3
+LH:2
+end_of_record
+SF:/app/test/foo_test.c
+DA:1,1
+end_of_record
+`
+
+const trace2 = `SF:/app/src/foo.c
+DA:3,2
+DA:6,1
+end_of_record
+SF:/app/src/bar.c
+DA:1,0
+end_of_record
+`
+
+func TestMerge(t *testing.T) {
+	d := Data{}
+	for _, tr := range []string{trace1, trace2} {
+		if err := d.ParseLCOV(strings.NewReader(tr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Filter(func(f string) bool { return !strings.Contains(f, "/test/") })
+
+	if d["/app/src/foo.c"][3] != 3 || d["/app/src/foo.c"][6] != 1 {
+		t.Errorf("bad counts: %v", d)
+	}
+	s := d.Summarize()
+	if len(s.Files) != 2 || s.Files[0].File != "/app/src/bar.c" {
+		t.Fatalf("bad files: %+v", s.Files)
+	}
+	if s.Total.Lines != 4 || s.Total.Hit != 3 || s.Total.Percent() != 75 {
+		t.Errorf("bad total: %+v", s.Total)
+	}
+
+	var text bytes.Buffer
+	s.WriteText(&text)
+	if !strings.Contains(text.String(), "  75.0%     3/4     Total\n") {
+		t.Errorf("bad text:\n%s", text.String())
+	}
+	var html bytes.Buffer
+	if err := s.WriteHTML(&html, "Coverage"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<td>/app/src/foo.c</td><td>3</td><td>3</td><td>100.0%</td>") {
+		t.Errorf("bad html:\n%s", html.String())
+	}
+
+	var out bytes.Buffer
+	if err := d.WriteLCOV(&out); err != nil {
+		t.Fatal(err)
+	}
+	d2 := Data{}
+	if err := d2.ParseLCOV(&out); err != nil {
+		t.Fatal(err)
+	}
+	if s2 := d2.Summarize(); s2.Total != s.Total {
+		t.Errorf("round trip: got %+v, want %+v", s2.Total, s.Total)
+	}
+
+	if err := (Data{}).ParseLCOV(strings.NewReader("SF:x\nDA:x,1\n")); err == nil {
+		t.Errorf("no error for a bad line")
+	}
+}
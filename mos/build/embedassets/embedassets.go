@@ -0,0 +1,177 @@
+// Package embedassets generates C source for the files which are compiled
+// into the firmware (see build.EmbeddedAsset): for each file, there is
+//
+//	extern const unsigned char NAME[];
+//	extern const size_t NAME_len;
+//
+// and the embedded_assets table which allows to look assets up by the file
+// name. The data is followed by a NUL byte not counted in the length, so that
+// text files, like PEM certificates, can be used as C strings.
+package embedassets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+const (
+	// HeaderName is the name of the header to include in the app code.
+	HeaderName = "embedded_assets.h"
+	// SourceName is the name of the generated C file.
+	SourceName = "embedded_assets.c"
+
+	bytesPerLine = 12
+)
+
+var (
+	identRe    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	nonIdentRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+const header = `/* Generated by mos, do not edit. */
+
+#pragma once
+
+#include <stdbool.h>
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+struct embedded_asset {
+  const char *file_name; /* Base name of the source file */
+  const unsigned char *data;
+  size_t len;
+  bool gzipped;
+};
+
+extern const struct embedded_asset embedded_assets[];
+extern const size_t embedded_assets_count;
+
+`
+
+const footer = `
+#ifdef __cplusplus
+}
+#endif
+`
+
+// SymbolName returns the name of the C array for the asset.
+func SymbolName(a *build.EmbeddedAsset) (string, error) {
+	if a.Name != "" {
+		if !identRe.MatchString(a.Name) {
+			return "", errors.Errorf("%q is not a valid C identifier", a.Name)
+		}
+		return a.Name, nil
+	}
+	name := nonIdentRe.ReplaceAllString(filepath.Base(a.Src), "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name, nil
+}
+
+// Generate writes the header and the C source for the assets to outDir and
+// returns the path to the source. Relative asset paths are relative to appDir.
+// Files are only rewritten if their contents change, so that make doesn't
+// rebuild them needlessly.
+func Generate(assets []build.EmbeddedAsset, appDir, outDir string) (string, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	var h, c, table bytes.Buffer
+	h.WriteString(header)
+	fmt.Fprintf(&c, "/* Generated by mos, do not edit. */\n\n#include \"%s\"\n", HeaderName)
+
+	names := map[string]bool{}
+	for i := range assets {
+		a := &assets[i]
+		if a.Src == "" {
+			return "", errors.Errorf("embedded asset #%d: src is required", i)
+		}
+		name, err := SymbolName(a)
+		if err != nil {
+			return "", errors.Annotatef(err, "embedded asset %q", a.Src)
+		}
+		if names[name] {
+			return "", errors.Errorf("embedded asset %q: duplicate name %q, set the name explicitly", a.Src, name)
+		}
+		names[name] = true
+
+		src := a.Src
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(appDir, src)
+		}
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return "", errors.Annotatef(err, "embedded asset %q", a.Src)
+		}
+		if a.Gzip {
+			if data, err = gzipData(data); err != nil {
+				return "", errors.Trace(err)
+			}
+		}
+
+		fmt.Fprintf(&h, "extern const unsigned char %s[];\n", name)
+		fmt.Fprintf(&h, "extern const size_t %s_len;\n", name)
+
+		fmt.Fprintf(&c, "\n/* %s */\nconst unsigned char %s[%d] = {", filepath.ToSlash(a.Src), name, len(data)+1)
+		writeBytes(&c, append(data, 0))
+		fmt.Fprintf(&c, "\n};\nconst size_t %s_len = %d;\n", name, len(data))
+
+		fmt.Fprintf(&table, "  {%q, %s, %d, %t},\n", filepath.Base(a.Src), name, len(data), a.Gzip)
+	}
+
+	h.WriteString(footer)
+
+	fmt.Fprintf(&c, "\nconst struct embedded_asset embedded_assets[] = {\n%s", table.String())
+	if len(assets) == 0 {
+		// Empty initializer lists are not valid C
+		c.WriteString("  {NULL, NULL, 0, false},\n")
+	}
+	fmt.Fprintf(&c, "};\nconst size_t embedded_assets_count = %d;\n", len(assets))
+
+	if err := ourio.WriteFileIfDiffers(filepath.Join(outDir, HeaderName), h.Bytes(), 0644); err != nil {
+		return "", errors.Trace(err)
+	}
+	srcFile := filepath.Join(outDir, SourceName)
+	if err := ourio.WriteFileIfDiffers(srcFile, c.Bytes(), 0644); err != nil {
+		return "", errors.Trace(err)
+	}
+	return srcFile, nil
+}
+
+func writeBytes(w *bytes.Buffer, data []byte) {
+	for i, b := range data {
+		if i%bytesPerLine == 0 {
+			w.WriteString("\n ")
+		}
+		fmt.Fprintf(w, " 0x%02x,", b)
+	}
+}
+
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+package embedassets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cesanta.com/mos/build"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedassets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "1ca.pem"), []byte("PEM"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	srcFile, err := Generate([]build.EmbeddedAsset{
+		{Src: "index.html", Gzip: true},
+		{Src: "1ca.pem"},
+		{Src: "1ca.pem", Name: "ca_cert"},
+	}, dir, outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ioutil.ReadFile(filepath.Join(outDir, HeaderName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"extern const unsigned char index_html[];",
+		"extern const size_t _1ca_pem_len;",
+		"extern const unsigned char ca_cert[];",
+	} {
+		if !strings.Contains(string(h), s) {
+			t.Errorf("header doesn't contain %q:\n%s", s, h)
+		}
+	}
+
+	c, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"const unsigned char ca_cert[4] = {\n  0x50, 0x45, 0x4d, 0x00,\n};",
+		"const size_t ca_cert_len = 3;",
+		`{"index.html", index_html, `,
+		`{"1ca.pem", ca_cert, 3, false},`,
+		"const size_t embedded_assets_count = 3;",
+	} {
+		if !strings.Contains(string(c), s) {
+			t.Errorf("source doesn't contain %q:\n%s", s, c)
+		}
+	}
+
+	for _, assets := range [][]build.EmbeddedAsset{
+		{{Src: "index.html"}, {Src: "index.html"}},
+		{{Src: "index.html", Name: "not-valid"}},
+		{{Src: "missing.bin"}},
+	} {
+		if _, err := Generate(assets, dir, outDir); err == nil {
+			t.Errorf("%+v: expected an error", assets)
+		}
+	}
+}
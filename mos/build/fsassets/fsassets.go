@@ -0,0 +1,207 @@
+// Package fsassets implements processing of the filesystem files during
+// build: minification of JS files, bundling and gzipping.
+package fsassets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+type asset struct {
+	name string
+	// Path to the file, if the file is not modified
+	path string
+	// Contents of the file, if the file is generated
+	data []byte
+}
+
+// Process runs the pipeline on the given filesystem files, and returns the
+// list of files which should be put on the device filesystem instead.
+// Generated files are written to outDir, and source maps for minified and
+// bundled JS files to mapsDir. Files are only rewritten if their contents
+// change, so that make doesn't rebuild the filesystem needlessly; stale files
+// are removed.
+func Process(files []string, opts *build.FSAssetsOpts, outDir, mapsDir string) ([]string, error) {
+	if err := os.RemoveAll(mapsDir); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, d := range []string{outDir, mapsDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	assets := []*asset{}
+	bundled := map[string]bool{}
+
+	for _, b := range opts.Bundles {
+		if b.Name == "" {
+			return nil, errors.Errorf("bundle name is required")
+		}
+		bundleFiles := []string{}
+		for _, pattern := range b.Files {
+			for _, f := range files {
+				matched, err := filepath.Match(pattern, filepath.Base(f))
+				if err != nil {
+					return nil, errors.Annotatef(err, "bundle %q", b.Name)
+				}
+				if matched && !bundled[f] {
+					bundleFiles = append(bundleFiles, f)
+					bundled[f] = true
+				}
+			}
+		}
+		if len(bundleFiles) == 0 {
+			return nil, errors.Errorf("bundle %q: no files match %v", b.Name, b.Files)
+		}
+		a, err := makeBundle(b.Name, bundleFiles, opts.MinifyJS, mapsDir)
+		if err != nil {
+			return nil, errors.Annotatef(err, "bundle %q", b.Name)
+		}
+		assets = append(assets, a)
+	}
+
+	for _, f := range files {
+		if bundled[f] {
+			continue
+		}
+		name := filepath.Base(f)
+		if opts.MinifyJS && isJS(name) {
+			a, err := makeBundle(name, []string{f}, true, mapsDir)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			assets = append(assets, a)
+		} else {
+			assets = append(assets, &asset{name: name, path: f})
+		}
+	}
+
+	ret := []string{}
+	generated := []string{}
+	names := map[string]bool{}
+	for _, a := range assets {
+		gz, err := matchesAny(opts.Gzip, a.name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if gz {
+			if a.data == nil {
+				if a.data, err = ioutil.ReadFile(a.path); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			if a.data, err = gzipData(a.data); err != nil {
+				return nil, errors.Trace(err)
+			}
+			a.name += ".gz"
+		}
+
+		if names[a.name] {
+			return nil, errors.Errorf("duplicate filesystem file %q", a.name)
+		}
+		names[a.name] = true
+
+		if a.data == nil {
+			ret = append(ret, a.path)
+			continue
+		}
+		p := filepath.Join(outDir, a.name)
+		if err := ourio.WriteFileIfDiffers(p, a.data, 0644); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ret = append(ret, p)
+		generated = append(generated, a.name)
+	}
+
+	if err := ourio.RemoveFromDir(outDir, generated); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return ret, nil
+}
+
+// makeBundle concatenates given files, minifying JS ones if needed. If any of
+// the files is minified, a source map is written to mapsDir.
+func makeBundle(name string, files []string, minify bool, mapsDir string) (*asset, error) {
+	var data bytes.Buffer
+	lines := []sourceMapLine{}
+	haveMap := false
+
+	for i, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		var mappings []LineMapping
+		if minify && isJS(f) {
+			src, mappings = MinifyJS(src)
+			haveMap = true
+		} else {
+			if len(src) > 0 && src[len(src)-1] != '\n' {
+				src = append(src, '\n')
+			}
+			for l := 0; l < bytes.Count(src, []byte{'\n'}); l++ {
+				mappings = append(mappings, LineMapping{SrcLine: l})
+			}
+		}
+
+		data.Write(src)
+		for _, m := range mappings {
+			lines = append(lines, sourceMapLine{SrcIdx: i, LineMapping: m})
+		}
+	}
+
+	if haveMap {
+		sm, err := generateSourceMap(name, files, lines)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(mapsDir, name+".map"), sm, 0644); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return &asset{name: name, data: data.Bytes()}, nil
+}
+
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func matchesAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		matched, err := filepath.Match(p, name)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isJS(name string) bool {
+	return strings.HasSuffix(name, ".js")
+}
@@ -0,0 +1,220 @@
+package fsassets
+
+import (
+	"bytes"
+)
+
+// LineMapping maps an output line to the position in the source it starts
+// at. Lines and columns are zero-based.
+type LineMapping struct {
+	SrcLine int
+	SrcCol  int
+}
+
+// MinifyJS removes comments and redundant whitespace from the JS (or mJS)
+// source. Line breaks are preserved (only empty lines are dropped), so that
+// automatic semicolon insertion keeps working the same way, and each output
+// line corresponds to exactly one source line; the returned slice contains
+// the mapping for every output line.
+func MinifyJS(src []byte) ([]byte, []LineMapping) {
+	m := minifier{src: src}
+	m.run()
+	return m.out.Bytes(), m.mappings
+}
+
+type minifier struct {
+	src []byte
+	pos int
+
+	srcLine, srcCol int
+
+	out      bytes.Buffer
+	mappings []LineMapping
+
+	line         []byte
+	lineStarted  bool
+	lineMapping  LineMapping
+	pendingSpace bool
+
+	// Last significant (non-whitespace) byte written, used to tell regexp
+	// literals from divisions.
+	lastSig byte
+}
+
+func (m *minifier) run() {
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		switch {
+		case c == '\n':
+			m.advance()
+			m.flushLine(false)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			m.advance()
+			if m.lineStarted {
+				m.pendingSpace = true
+			}
+		case c == '/' && m.peek(1) == '/':
+			for m.pos < len(m.src) && m.src[m.pos] != '\n' {
+				m.advance()
+			}
+		case c == '/' && m.peek(1) == '*':
+			m.skipBlockComment()
+		case c == '"' || c == '\'' || c == '`':
+			m.copyString(c)
+		case c == '/' && m.regexpAllowed():
+			m.copyRegexp()
+		default:
+			m.emit(c)
+			m.advance()
+		}
+	}
+	m.flushLine(false)
+}
+
+func (m *minifier) peek(off int) byte {
+	if m.pos+off < len(m.src) {
+		return m.src[m.pos+off]
+	}
+	return 0
+}
+
+func (m *minifier) advance() {
+	if m.src[m.pos] == '\n' {
+		m.srcLine++
+		m.srcCol = 0
+	} else {
+		m.srcCol++
+	}
+	m.pos++
+}
+
+// emit appends a byte at the current source position to the output line.
+func (m *minifier) emit(c byte) {
+	if !m.lineStarted {
+		m.lineStarted = true
+		m.lineMapping = LineMapping{SrcLine: m.srcLine, SrcCol: m.srcCol}
+	} else if m.pendingSpace {
+		m.line = append(m.line, ' ')
+	}
+	m.pendingSpace = false
+	m.line = append(m.line, c)
+	m.lastSig = c
+}
+
+// flushLine writes the current line to the output. Empty lines are dropped
+// unless force is true (which is the case for lines inside multiline
+// strings).
+func (m *minifier) flushLine(force bool) {
+	if m.lineStarted || force {
+		m.out.Write(m.line)
+		m.out.WriteByte('\n')
+		m.mappings = append(m.mappings, m.lineMapping)
+	}
+	m.line = m.line[:0]
+	m.lineStarted = false
+	m.pendingSpace = false
+}
+
+func (m *minifier) skipBlockComment() {
+	m.advance()
+	m.advance()
+	for m.pos < len(m.src) {
+		if m.src[m.pos] == '*' && m.peek(1) == '/' {
+			m.advance()
+			m.advance()
+			break
+		}
+		wasNewline := m.src[m.pos] == '\n'
+		m.advance()
+		if wasNewline {
+			m.flushLine(false)
+		}
+	}
+	// Comment might separate two tokens
+	if m.lineStarted {
+		m.pendingSpace = true
+	}
+}
+
+// copyString copies the string or template literal verbatim. Newlines inside
+// of it (escaped ones in regular strings, or any in templates) start a new
+// output line, which is never dropped.
+func (m *minifier) copyString(quote byte) {
+	m.emit(quote)
+	m.advance()
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		if c == '\\' && m.pos+1 < len(m.src) {
+			m.emit(c)
+			m.advance()
+			c = m.src[m.pos]
+		} else if c == quote {
+			m.emit(c)
+			m.advance()
+			return
+		} else if c == '\n' && quote != '`' {
+			// Unterminated string; leave the rest to the interpreter to complain
+			return
+		}
+
+		if c == '\n' {
+			m.advance()
+			m.flushLine(true)
+			m.lineStarted = true
+			m.lineMapping = LineMapping{SrcLine: m.srcLine, SrcCol: m.srcCol}
+		} else {
+			m.emit(c)
+			m.advance()
+		}
+	}
+}
+
+// regexpAllowed returns whether a slash at the current position starts a
+// regexp literal rather than a division.
+func (m *minifier) regexpAllowed() bool {
+	if m.lastSig == 0 {
+		return true
+	}
+	if bytes.IndexByte([]byte("(,=:[!&|?{};+-*%<>~^"), m.lastSig) >= 0 {
+		return true
+	}
+	for _, kw := range []string{"return", "typeof", "case", "do", "else", "in", "void"} {
+		if bytes.HasSuffix(m.line, []byte(kw)) {
+			l := len(m.line) - len(kw)
+			if l == 0 || !isIdentChar(m.line[l-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *minifier) copyRegexp() {
+	inClass := false
+	m.emit('/')
+	m.advance()
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		if c == '\n' {
+			return
+		}
+		m.emit(c)
+		m.advance()
+		switch {
+		case c == '\\' && m.pos < len(m.src) && m.src[m.pos] != '\n':
+			m.emit(m.src[m.pos])
+			m.advance()
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass:
+			return
+		}
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
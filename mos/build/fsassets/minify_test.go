@@ -0,0 +1,71 @@
+package fsassets
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMinifyJS(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected string
+		mappings []LineMapping
+	}{
+		{
+			src:      "  let a = 1;   // comment\n\n  let b = a / 2;\n",
+			expected: "let a = 1;\nlet b = a / 2;\n",
+			mappings: []LineMapping{{0, 2}, {2, 2}},
+		},
+		{
+			src:      "/* multi\n line */ let s = '  // not a comment  ';\n",
+			expected: "let s = '  // not a comment  ';\n",
+			mappings: []LineMapping{{1, 9}},
+		},
+		{
+			src:      "let re = /\\/\\/[/]*/g; // re\nreturn /a b/.test(x)\n",
+			expected: "let re = /\\/\\/[/]*/g;\nreturn /a b/.test(x)\n",
+			mappings: []LineMapping{{0, 0}, {1, 0}},
+		},
+		{
+			src:      "let t = `a\n\n  b`;\n",
+			expected: "let t = `a\n\n  b`;\n",
+			mappings: []LineMapping{{0, 0}, {1, 0}, {2, 0}},
+		},
+		{
+			src:      "a/*x*/b\n",
+			expected: "a b\n",
+			mappings: []LineMapping{{0, 0}},
+		},
+	}
+
+	for i, c := range cases {
+		out, mappings := MinifyJS([]byte(c.src))
+		if string(out) != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, string(out))
+		}
+		if !reflect.DeepEqual(mappings, c.mappings) {
+			t.Errorf("case %d: expected mappings %v, got %v", i, c.mappings, mappings)
+		}
+	}
+}
+
+func TestEncodeVLQ(t *testing.T) {
+	cases := map[int]string{
+		0:    "A",
+		1:    "C",
+		-1:   "D",
+		15:   "e",
+		16:   "gB",
+		-17:  "jB",
+		1000: "w+B",
+	}
+
+	for v, expected := range cases {
+		var buf bytes.Buffer
+		encodeVLQ(&buf, v)
+		if buf.String() != expected {
+			t.Errorf("%d: expected %q, got %q", v, expected, buf.String())
+		}
+	}
+}
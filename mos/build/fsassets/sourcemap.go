@@ -0,0 +1,75 @@
+package fsassets
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/cesanta/errors"
+)
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// sourceMapLine is a mapping of one generated line to the source file.
+type sourceMapLine struct {
+	SrcIdx int
+	LineMapping
+}
+
+type sourceMap struct {
+	Version  int      `json:"version"`
+	File     string   `json:"file"`
+	Sources  []string `json:"sources"`
+	Names    []string `json:"names"`
+	Mappings string   `json:"mappings"`
+}
+
+// encodeVLQ appends the base64 VLQ representation of v, as used by source
+// maps v3.
+func encodeVLQ(buf *bytes.Buffer, v int) {
+	var u uint
+	if v < 0 {
+		u = uint(-v)<<1 | 1
+	} else {
+		u = uint(v) << 1
+	}
+	for {
+		digit := u & 0x1f
+		u >>= 5
+		if u > 0 {
+			digit |= 0x20
+		}
+		buf.WriteByte(base64Digits[digit])
+		if u == 0 {
+			break
+		}
+	}
+}
+
+// generateSourceMap returns a v3 source map with one segment per generated
+// line.
+func generateSourceMap(file string, sources []string, lines []sourceMapLine) ([]byte, error) {
+	var mappings bytes.Buffer
+	prev := sourceMapLine{}
+	for i, l := range lines {
+		if i > 0 {
+			mappings.WriteByte(';')
+		}
+		encodeVLQ(&mappings, 0)
+		encodeVLQ(&mappings, l.SrcIdx-prev.SrcIdx)
+		encodeVLQ(&mappings, l.SrcLine-prev.SrcLine)
+		encodeVLQ(&mappings, l.SrcCol-prev.SrcCol)
+		prev = l
+	}
+
+	data, err := json.Marshal(&sourceMap{
+		Version:  3,
+		File:     file,
+		Sources:  sources,
+		Names:    []string{},
+		Mappings: mappings.String(),
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
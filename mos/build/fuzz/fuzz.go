@@ -0,0 +1,214 @@
+// Package fuzz builds and runs fuzz targets of the project on the host, with
+// libFuzzer or AFL++, and parses the crash reports of the sanitizers.
+//
+// A target is a source in the fuzz dir of the project, fuzz/<name>.c or
+// .cc, defining LLVMFuzzerTestOneInput which feeds the input to the function
+// under test, like the one Template returns. Sources of the function are
+// listed in the target, see Sources. Its corpus is kept in
+// fuzz/corpus/<name>, and inputs which crash it in fuzz/crashes/<name>.
+package fuzz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Engines.
+const (
+	LibFuzzer = "libfuzzer"
+	AFL       = "afl"
+)
+
+// Dir is the dir of the fuzz targets in the project.
+const Dir = "fuzz"
+
+// Define is defined when compiling fuzz targets.
+const Define = "MGOS_FUZZ"
+
+// Template returns the source of a new target which fuzzes the function
+// taking a buffer and its length.
+func Template(function string) string {
+	return fmt.Sprintf(`#include <stddef.h>
+#include <stdint.h>
+
+#include "mgos_test.h"
+
+/* Sources, relative to the project dir, built into the fuzzer:
+ * mos-fuzz-sources: src/%[1]s.c
+ */
+
+/* TODO: include the header of %[1]s instead */
+int %[1]s(const char *buf, size_t len);
+
+int LLVMFuzzerTestOneInput(const uint8_t *data, size_t size) {
+  %[1]s((const char *) data, size);
+  return 0;
+}
+`, function)
+}
+
+var sourcesRE = regexp.MustCompile(`mos-fuzz-sources:(.*)`)
+
+// Sources returns the sources listed in "mos-fuzz-sources:" lines of the
+// target source, which are built into the fuzzer along with it.
+func Sources(target []byte) []string {
+	var res []string
+	for _, m := range sourcesRE.FindAllSubmatch(target, -1) {
+		res = append(res, strings.Fields(strings.TrimSuffix(strings.TrimSpace(string(m[1])), "*/"))...)
+	}
+	return res
+}
+
+// Build is a build and run of a fuzz target.
+type Build struct {
+	Engine string
+	// Sources and include dirs, as slash paths valid where the script runs.
+	Sources  []string
+	Includes []string
+	CDefs    map[string]string
+	// Binary is the path of the fuzzer; objects are put next to it.
+	Binary string
+	// Dirs of the corpus and of the crashing inputs.
+	Corpus  string
+	Crashes string
+	// Seconds to run the fuzzer for.
+	Seconds int
+}
+
+// Script returns the shell script which builds the fuzzer, instrumented and
+// with the address and undefined behavior sanitizers, and runs it. Inputs
+// which crash it are put to the crashes dir, and the crash report is printed.
+// If the input is given, the fuzzer runs on it instead, to reproduce a crash.
+func (bld *Build) Script(input string) (string, error) {
+	cc, cxx := "clang", "clang++"
+	if bld.Engine == AFL {
+		cc, cxx = "afl-clang-fast", "afl-clang-fast++"
+	} else if bld.Engine != LibFuzzer {
+		return "", errors.Errorf("unknown fuzzing engine %q, expected %s or %s", bld.Engine, LibFuzzer, AFL)
+	}
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	flags := []string{
+		"-g", "-O1", "-fno-omit-frame-pointer", "-fsanitize=fuzzer,address,undefined",
+		"-D" + Define + "=1", "-DMGOS_HOST_TEST=1",
+	}
+	var names []string
+	for k := range bld.CDefs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		flags = append(flags, quote(fmt.Sprintf("-D%s=%s", k, bld.CDefs[k])))
+	}
+	for _, inc := range bld.Includes {
+		flags = append(flags, quote("-I"+inc))
+	}
+	linker := cc
+	var objs []string
+	for i, src := range bld.Sources {
+		compiler := cc
+		if ext := path.Ext(src); ext == ".cpp" || ext == ".cc" {
+			compiler, linker = cxx, cxx
+		}
+		obj := quote(fmt.Sprintf("%s.%d.o", bld.Binary, i))
+		fmt.Fprintf(&b, "%s %s -c %s -o %s\n", compiler, strings.Join(flags, " "), quote(src), obj)
+		objs = append(objs, obj)
+	}
+	fmt.Fprintf(&b, "%s -fsanitize=fuzzer,address,undefined %s -o %s\n", linker, strings.Join(objs, " "), quote(bld.Binary))
+	b.WriteString("export ASAN_OPTIONS=symbolize=1:abort_on_error=1 UBSAN_OPTIONS=print_stacktrace=1:halt_on_error=1\n")
+	if input != "" {
+		fmt.Fprintf(&b, "%s %s\n", quote(bld.Binary), quote(input))
+		return b.String(), nil
+	}
+	fmt.Fprintf(&b, "mkdir -p %s %s\n", quote(bld.Corpus), quote(bld.Crashes))
+	switch bld.Engine {
+	case LibFuzzer:
+		fmt.Fprintf(&b, "%s -max_total_time=%d -artifact_prefix=%s/ %s\n",
+			quote(bld.Binary), bld.Seconds, quote(bld.Crashes), quote(bld.Corpus))
+	case AFL:
+		// AFL needs a seed, and keeps its state next to the binary; new
+		// inputs and crashes are copied back to the project.
+		out := quote(bld.Binary + ".afl")
+		fmt.Fprintf(&b, "[ -n \"$(ls -A %[1]s)\" ] || printf 0 > %[1]s/seed\n", quote(bld.Corpus))
+		fmt.Fprintf(&b, "rc=0\nAFL_SKIP_CPUFREQ=1 AFL_NO_UI=1 afl-fuzz -i %s -o %s -V %d -- %s || rc=$?\n",
+			quote(bld.Corpus), out, bld.Seconds, quote(bld.Binary))
+		fmt.Fprintf(&b, "cp -n %s/default/queue/id* %s/ 2>/dev/null || true\n", out, quote(bld.Corpus))
+		fmt.Fprintf(&b, "for f in %s/default/crashes/id*; do\n", out)
+		b.WriteString("  [ -f \"$f\" ] || continue\n")
+		fmt.Fprintf(&b, "  c=%s/crash-$(sha1sum \"$f\" | cut -c1-40)\n", quote(bld.Crashes))
+		b.WriteString("  cp \"$f\" \"$c\"\n")
+		// The AFL binary runs the input given as the argument, which gives
+		// the report; it's followed by the input name, like with libFuzzer
+		fmt.Fprintf(&b, "  %s \"$c\" || true\n", quote(bld.Binary))
+		b.WriteString("  echo \"Test unit written to $c\"\n")
+		b.WriteString("done\nexit $rc\n")
+	}
+	return b.String(), nil
+}
+
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Crash is a crash report of a sanitizer.
+type Crash struct {
+	// Summary, like "AddressSanitizer: heap-buffer-overflow parse.c:10:3 in parse".
+	Summary string
+	// Stack frames, like "#1 0x4f1c2a in parse /app/src/parse.c:10:3".
+	Stack []string
+	// Input which crashed the target, if saved.
+	Input string
+}
+
+var (
+	frameRE    = regexp.MustCompile(`^\s*(#\d+ 0x[0-9a-f]+ in .*)$`)
+	summaryRE  = regexp.MustCompile(`^SUMMARY: (.*)$`)
+	artifactRE = regexp.MustCompile(`Test unit written to (\S+)`)
+	reportRE   = regexp.MustCompile(`^==\d+==\s*ERROR: |runtime error: `)
+)
+
+// ParseCrashes returns the crash reports found in the fuzzer output. Stack
+// frames are the ones of the first stack of each report, which is where it
+// crashed.
+func ParseCrashes(r io.Reader) ([]Crash, error) {
+	var res []Crash
+	var cur *Crash
+	inStack := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case reportRE.MatchString(line):
+			if cur == nil || cur.Summary != "" {
+				res = append(res, Crash{})
+				cur = &res[len(res)-1]
+			}
+			inStack = false
+		case cur == nil:
+		case frameRE.MatchString(line):
+			if len(cur.Stack) == 0 {
+				inStack = true
+			}
+			if inStack {
+				cur.Stack = append(cur.Stack, frameRE.FindStringSubmatch(line)[1])
+			}
+		case summaryRE.MatchString(line):
+			cur.Summary = summaryRE.FindStringSubmatch(line)[1]
+			inStack = false
+		case artifactRE.MatchString(line):
+			cur.Input = artifactRE.FindStringSubmatch(line)[1]
+			inStack = false
+		default:
+			inStack = false
+		}
+	}
+	return res, errors.Trace(scanner.Err())
+}
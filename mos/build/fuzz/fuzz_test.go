@@ -0,0 +1,93 @@
+package fuzz
+
+import (
+	"strings"
+	"testing"
+)
+
+const libFuzzerOutput = `INFO: Seed: 1234
+#2	INITED cov: 10 ft: 11 corp: 1/1b exec/s: 0 rss: 30Mb
+=================================================================
+==42==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000011
+READ of size 1 at 0x602000000011 thread T0
+    #0 0x4f1c2a in parse /app/src/parse.c:10:3
+    #1 0x4f1d00 in LLVMFuzzerTestOneInput /app/fuzz/parse.c:12:3
+0x602000000011 is located 0 bytes to the right of 1-byte region
+allocated by thread T0 here:
+    #0 0x4ac3a2 in malloc
+    #1 0x4f1e00 in fuzzer::Fuzzer::ExecuteCallback
+SUMMARY: AddressSanitizer: heap-buffer-overflow /app/src/parse.c:10:3 in parse
+MS: 1 ChangeBit-; base unit: adc83b19e793491b1c6ea0fd8b46cd9f32e592fc
+artifact_prefix='/app/fuzz/crashes/parse/'; Test unit written to /app/fuzz/crashes/parse/crash-7a3f
+/app/src/lex.c:5:7: runtime error: signed integer overflow
+    #0 0x4f2000 in lex /app/src/lex.c:5:7
+SUMMARY: UndefinedBehaviorSanitizer: undefined-behavior /app/src/lex.c:5:7
+`
+
+func TestParseCrashes(t *testing.T) {
+	crashes, err := ParseCrashes(strings.NewReader(libFuzzerOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crashes) != 2 {
+		t.Fatalf("got %d crashes, want 2: %+v", len(crashes), crashes)
+	}
+	c := crashes[0]
+	if c.Summary != "AddressSanitizer: heap-buffer-overflow /app/src/parse.c:10:3 in parse" ||
+		c.Input != "/app/fuzz/crashes/parse/crash-7a3f" || len(c.Stack) != 2 ||
+		c.Stack[0] != "#0 0x4f1c2a in parse /app/src/parse.c:10:3" {
+		t.Errorf("bad crash: %+v", c)
+	}
+	if c := crashes[1]; c.Summary != "UndefinedBehaviorSanitizer: undefined-behavior /app/src/lex.c:5:7" ||
+		len(c.Stack) != 1 || c.Input != "" {
+		t.Errorf("bad crash: %+v", c)
+	}
+}
+
+func TestScript(t *testing.T) {
+	bld := &Build{
+		Engine:   LibFuzzer,
+		Sources:  []string{"/app/fuzz/parse.c", "/app/src/parse.cpp"},
+		Includes: []string{"/app/include"},
+		Binary:   "/app/build/fuzz/parse",
+		Corpus:   "/app/fuzz/corpus/parse",
+		Crashes:  "/app/fuzz/crashes/parse",
+		Seconds:  60,
+	}
+	s, err := bld.Script("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"clang -g -O1 -fno-omit-frame-pointer -fsanitize=fuzzer,address,undefined -DMGOS_FUZZ=1 -DMGOS_HOST_TEST=1 '-I/app/include' -c '/app/fuzz/parse.c' -o '/app/build/fuzz/parse.0.o'",
+		"clang++ -g -O1",
+		"clang++ -fsanitize=fuzzer,address,undefined '/app/build/fuzz/parse.0.o' '/app/build/fuzz/parse.1.o' -o '/app/build/fuzz/parse'",
+		"'/app/build/fuzz/parse' -max_total_time=60 -artifact_prefix='/app/fuzz/crashes/parse'/ '/app/fuzz/corpus/parse'",
+	} {
+		if !strings.Contains(s, line) {
+			t.Errorf("no %q in:\n%s", line, s)
+		}
+	}
+
+	if s, err = bld.Script("/app/fuzz/crashes/parse/crash-7a3f"); err != nil ||
+		!strings.HasSuffix(s, "\n'/app/build/fuzz/parse' '/app/fuzz/crashes/parse/crash-7a3f'\n") {
+		t.Errorf("bad repro script (%v):\n%s", err, s)
+	}
+
+	bld.Engine = AFL
+	if s, err = bld.Script(""); err != nil || !strings.Contains(s, "afl-fuzz -i '/app/fuzz/corpus/parse' -o '/app/build/fuzz/parse.afl' -V 60") {
+		t.Errorf("bad AFL script (%v):\n%s", err, s)
+	}
+	bld.Engine = "honggfuzz"
+	if _, err := bld.Script(""); err == nil {
+		t.Errorf("no error for an unknown engine")
+	}
+}
+
+func TestSources(t *testing.T) {
+	src := []byte(Template("parse") + "// mos-fuzz-sources: src/lex.c  src/util.c\n")
+	got := strings.Join(Sources(src), ",")
+	if got != "src/parse.c,src/lex.c,src/util.c" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,265 @@
+// Package hosttest builds and runs unit tests of libs and apps on the host,
+// without hardware. Test sources, listed in "tests" of mos.yml, are compiled
+// with the host compiler of the build container together with the harness:
+//
+//	#include "mgos_test.h"
+//
+//	MGOS_TEST(test_parse) {
+//	  ASSERT_EQ(parse("42"), 42);
+//	  ASSERT_STREQ(name(), "foo");
+//	}
+//
+// Sources of the lib are not linked automatically, since they usually need
+// the mongoose-os runtime; tests include or list the ones they cover. The
+// test binary prints a line per test, "PASS name" or "FAIL name: file:line:
+// message", which ParseResults understands.
+package hosttest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// HeaderName is the name of the harness header, to be included by tests.
+const HeaderName = "mgos_test.h"
+
+// MainName is the name of the harness source with main().
+const MainName = "mgos_test_main.c"
+
+// Define is defined when compiling for host tests, so that sources can
+// exclude the code which needs the device.
+const Define = "MGOS_HOST_TEST"
+
+const header = `/* Generated by mos, do not edit. */
+#ifndef MGOS_TEST_H_
+#define MGOS_TEST_H_
+
+#include <stdbool.h>
+#include <stdio.h>
+#include <string.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef void (*mgos_test_fn_t)(void);
+
+void mgos_test_register(const char *name, mgos_test_fn_t fn);
+void mgos_test_fail(const char *file, int line, const char *msg);
+/* Whether the current test has failed, to return from it. */
+bool mgos_test_failed(void);
+void mgos_test_log(const char *fmt, ...);
+
+#ifdef __cplusplus
+}
+#endif
+
+#define MGOS_TEST(name)                                                  \
+  static void name(void);                                                \
+  __attribute__((constructor)) static void name##_register(void) {       \
+    mgos_test_register(#name, name);                                     \
+  }                                                                      \
+  static void name(void)
+
+#define ASSERT(cond)                                                     \
+  do {                                                                   \
+    if (!(cond)) {                                                       \
+      mgos_test_fail(__FILE__, __LINE__, "ASSERT(" #cond ")");           \
+      return;                                                            \
+    }                                                                    \
+  } while (0)
+
+#define ASSERT_EQ(a, b) ASSERT((a) == (b))
+#define ASSERT_NE(a, b) ASSERT((a) != (b))
+#define ASSERT_STREQ(a, b) ASSERT(strcmp((a), (b)) == 0)
+
+/* Logging of the sources under test, like LOG(LL_INFO, ("x: %d", x)). */
+#ifndef LOG
+enum cs_log_level { LL_ERROR, LL_WARN, LL_INFO, LL_DEBUG, LL_VERBOSE_DEBUG };
+#define LOG(l, x)     \
+  do {                \
+    (void) (l);       \
+    mgos_test_log x;  \
+  } while (0)
+#endif
+
+#endif /* MGOS_TEST_H_ */
+`
+
+const mainSource = `/* Generated by mos, do not edit. */
+#include <stdarg.h>
+#include <stdlib.h>
+
+#include "mgos_test.h"
+
+#define MAX_TESTS 1024
+
+static struct {
+  const char *name;
+  mgos_test_fn_t fn;
+} s_tests[MAX_TESTS];
+static int s_num_tests;
+static bool s_failed;
+
+void mgos_test_register(const char *name, mgos_test_fn_t fn) {
+  if (s_num_tests < MAX_TESTS) {
+    s_tests[s_num_tests].name = name;
+    s_tests[s_num_tests].fn = fn;
+    s_num_tests++;
+  }
+}
+
+static const char *s_name;
+
+void mgos_test_fail(const char *file, int line, const char *msg) {
+  if (!s_failed) printf("FAIL %s: %s:%d: %s\n", s_name, file, line, msg);
+  s_failed = true;
+}
+
+bool mgos_test_failed(void) {
+  return s_failed;
+}
+
+void mgos_test_log(const char *fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  vfprintf(stderr, fmt, ap);
+  va_end(ap);
+  fputc('\n', stderr);
+}
+
+/* Runs the tests whose names contain the filter given as the argument. */
+int main(int argc, char **argv) {
+  int failed = 0;
+  for (int i = 0; i < s_num_tests; i++) {
+    if (argc > 1 && strstr(s_tests[i].name, argv[1]) == NULL) continue;
+    s_name = s_tests[i].name;
+    s_failed = false;
+    s_tests[i].fn();
+    fflush(stderr);
+    if (s_failed) {
+      failed++;
+    } else {
+      printf("PASS %s\n", s_name);
+    }
+    fflush(stdout);
+  }
+  return failed == 0 ? EXIT_SUCCESS : EXIT_FAILURE;
+}
+`
+
+// Files returns the harness files, by name.
+func Files() map[string]string {
+	return map[string]string{HeaderName: header, MainName: mainSource}
+}
+
+// Build is a build and run of a test binary.
+type Build struct {
+	// Sources and include dirs, as slash paths valid where the script runs.
+	Sources  []string
+	Includes []string
+	CDefs    map[string]string
+	// Binary is the path of the test binary; objects are put next to it.
+	Binary string
+	// Filter of the names of the tests to run.
+	Filter string
+	// Coverage, if not empty, is the lcov tracefile to write the coverage of
+	// the run to; lcov must be available.
+	Coverage string
+}
+
+// Script returns the shell script which compiles the sources into the
+// binary and runs it. C++ sources are compiled with c++, and then
+// everything is linked with it.
+func (bld *Build) Script() string {
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	flags := []string{"-g", "-O0", "-Wall", "-D" + Define + "=1"}
+	if bld.Coverage != "" {
+		flags = append(flags, "--coverage")
+	}
+	var names []string
+	for k := range bld.CDefs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		flags = append(flags, quote(fmt.Sprintf("-D%s=%s", k, bld.CDefs[k])))
+	}
+	for _, inc := range bld.Includes {
+		flags = append(flags, quote("-I"+inc))
+	}
+	linker := "cc"
+	var objs []string
+	for i, src := range bld.Sources {
+		compiler := "cc"
+		if ext := path.Ext(src); ext == ".cpp" || ext == ".cc" {
+			compiler, linker = "c++", "c++"
+		}
+		obj := quote(fmt.Sprintf("%s.%d.o", bld.Binary, i))
+		fmt.Fprintf(&b, "%s %s -c %s -o %s\n", compiler, strings.Join(flags, " "), quote(src), obj)
+		objs = append(objs, obj)
+	}
+	ldFlags := ""
+	if bld.Coverage != "" {
+		ldFlags = " --coverage"
+		// Counters of the previous runs would add up
+		fmt.Fprintf(&b, "rm -f %s.*.gcda\n", quote(bld.Binary))
+	}
+	fmt.Fprintf(&b, "%s%s %s -o %s\n", linker, ldFlags, strings.Join(objs, " "), quote(bld.Binary))
+	if bld.Coverage == "" {
+		fmt.Fprintf(&b, "%s %s\n", quote(bld.Binary), quote(bld.Filter))
+		return b.String()
+	}
+	// Coverage is captured even if tests fail
+	fmt.Fprintf(&b, "rc=0\n%s %s || rc=$?\n", quote(bld.Binary), quote(bld.Filter))
+	fmt.Fprintf(&b, "lcov --quiet --capture --directory %s --output-file %s\n",
+		quote(path.Dir(bld.Binary)), quote(bld.Coverage))
+	b.WriteString("exit $rc\n")
+	return b.String()
+}
+
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Failure is a failed test.
+type Failure struct {
+	Name string
+	// Location and message, like "test.c:10: ASSERT(x == 1)".
+	Message string
+}
+
+// Results are the results of a test run.
+type Results struct {
+	Passed []string
+	Failed []Failure
+}
+
+// ParseResults returns the results from the test binary output; other
+// lines, like compiler output, are skipped.
+func ParseResults(r io.Reader) (*Results, error) {
+	res := &Results{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "PASS "):
+			res.Passed = append(res.Passed, strings.TrimPrefix(line, "PASS "))
+		case strings.HasPrefix(line, "FAIL "):
+			parts := strings.SplitN(strings.TrimPrefix(line, "FAIL "), ": ", 2)
+			f := Failure{Name: parts[0]}
+			if len(parts) > 1 {
+				f.Message = parts[1]
+			}
+			res.Failed = append(res.Failed, f)
+		}
+	}
+	return res, errors.Trace(scanner.Err())
+}
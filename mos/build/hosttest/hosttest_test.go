@@ -0,0 +1,58 @@
+package hosttest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	bld := &Build{
+		Sources:  []string{"/app/test/a_test.c", "/app/test/b_test.cpp"},
+		Includes: []string{"/app/include"},
+		CDefs:    map[string]string{"FOO": "1"},
+		Binary:   "/app/build/test_host/test",
+		Filter:   "parse",
+	}
+	s := bld.Script()
+	want := strings.Join([]string{
+		"set -e",
+		"cc -g -O0 -Wall -DMGOS_HOST_TEST=1 '-DFOO=1' '-I/app/include' -c '/app/test/a_test.c' -o '/app/build/test_host/test.0.o'",
+		"c++ -g -O0 -Wall -DMGOS_HOST_TEST=1 '-DFOO=1' '-I/app/include' -c '/app/test/b_test.cpp' -o '/app/build/test_host/test.1.o'",
+		"c++ '/app/build/test_host/test.0.o' '/app/build/test_host/test.1.o' -o '/app/build/test_host/test'",
+		"'/app/build/test_host/test' 'parse'",
+		"",
+	}, "\n")
+	if s != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s, want)
+	}
+
+	bld.Sources = bld.Sources[:1]
+	bld.Coverage = "/app/build/test_host/test.info"
+	s = bld.Script()
+	for _, line := range []string{
+		"cc -g -O0 -Wall -DMGOS_HOST_TEST=1 --coverage '-DFOO=1' '-I/app/include' -c '/app/test/a_test.c' -o '/app/build/test_host/test.0.o'",
+		"rm -f '/app/build/test_host/test'.*.gcda",
+		"cc --coverage '/app/build/test_host/test.0.o' -o '/app/build/test_host/test'",
+		"rc=0\n'/app/build/test_host/test' 'parse' || rc=$?",
+		"lcov --quiet --capture --directory '/app/build/test_host' --output-file '/app/build/test_host/test.info'\nexit $rc\n",
+	} {
+		if !strings.Contains(s, line) {
+			t.Errorf("no %q in:\n%s", line, s)
+		}
+	}
+}
+
+func TestParseResults(t *testing.T) {
+	out := "cc -c x.c\nPASS test_a\n3 some log\nFAIL test_b: test.c:10: ASSERT(x == 1)\nPASS test_c\n"
+	res, err := ParseResults(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Passed, []string{"test_a", "test_c"}) {
+		t.Errorf("bad passed: %q", res.Passed)
+	}
+	if len(res.Failed) != 1 || res.Failed[0] != (Failure{Name: "test_b", Message: "test.c:10: ASSERT(x == 1)"}) {
+		t.Errorf("bad failed: %+v", res.Failed)
+	}
+}
@@ -0,0 +1,160 @@
+// Package idfcomp resolves ESP-IDF components declared in the
+// esp_idf_components section of mos.yml.
+//
+// Components are given like libs and modules: with a location, they are
+// fetched from git, an archive URL or a local dir as any other module. A
+// component without a location, named as "namespace/name", comes from the
+// ESP Component Registry: Resolve finds the archive of the requested version
+// there.
+//
+// Fetched components are passed to the ESP32 platform makefile in two build
+// vars: ESP_IDF_EXTRA_COMPONENTS, the space-separated component names, and
+// ESP_IDF_EXTRA_COMPONENT_DIRS, their dirs in the same order.
+package idfcomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/download"
+	"github.com/cesanta/errors"
+)
+
+const (
+	// RegistryURL is the API endpoint of the ESP Component Registry.
+	RegistryURL = "https://api.components.espressif.com"
+
+	// Build vars set for the ESP32 platform makefile.
+	ComponentsBuildVar    = "ESP_IDF_EXTRA_COMPONENTS"
+	ComponentDirsBuildVar = "ESP_IDF_EXTRA_COMPONENT_DIRS"
+)
+
+// IsRegistry returns whether the component is to be fetched from the
+// registry.
+func IsRegistry(m *build.SWModule) bool {
+	return m.Location == "" && strings.Contains(m.Name, "/")
+}
+
+// ComponentName returns the name the component is known by to the IDF build:
+// "namespace__name" for registry ones, like the IDF component manager does,
+// and the module name otherwise.
+func ComponentName(m *build.SWModule) (string, error) {
+	if IsRegistry(m) {
+		parts := strings.Split(m.Name, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", errors.Errorf("invalid registry component name %q, expected namespace/name", m.Name)
+		}
+		return parts[0] + "__" + parts[1], nil
+	}
+	name, err := m.GetName()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return name, nil
+}
+
+// RegistryVersion is a version of the component in the registry.
+type RegistryVersion struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	// Non-nil if the version is yanked.
+	YankedAt *string `json:"yanked_at,omitempty"`
+}
+
+type registryComponent struct {
+	Versions []RegistryVersion `json:"versions"`
+}
+
+// Resolve returns the module to fetch the registry component from: the
+// archive of the version matching the one in the manifest (see
+// SelectVersion).
+func Resolve(m *build.SWModule) (*build.SWModule, error) {
+	uri := fmt.Sprintf("%s/components/%s", RegistryURL, m.Name)
+	data, err := download.Get(uri)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to look up %q in the component registry", m.Name)
+	}
+	var c registryComponent
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.Annotatef(err, "invalid registry response for %q", m.Name)
+	}
+	v, err := SelectVersion(c.Versions, m.Version)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", m.Name)
+	}
+	name, err := ComponentName(m)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &build.SWModule{
+		Type:     "archive",
+		Location: v.URL,
+		Version:  v.Version,
+		Name:     name,
+		SHA256:   m.SHA256,
+	}, nil
+}
+
+// SelectVersion returns the highest non-yanked version matching the spec:
+// an exact version like "1.2.3", a prefix like "1.2" or "1", or any version
+// if the spec is empty or "latest". A yanked version is only returned if
+// requested exactly.
+func SelectVersion(versions []RegistryVersion, spec string) (*RegistryVersion, error) {
+	spec = strings.TrimPrefix(spec, "v")
+	var best *RegistryVersion
+	for i, v := range versions {
+		if v.Version == spec {
+			return &versions[i], nil
+		}
+		if v.YankedAt != nil {
+			continue
+		}
+		if spec != "" && spec != "latest" && !strings.HasPrefix(v.Version, spec+".") {
+			continue
+		}
+		if best == nil || compareVersions(v.Version, best.Version) > 0 {
+			best = &versions[i]
+		}
+	}
+	if best == nil {
+		return nil, errors.Errorf("no version matching %q", spec)
+	}
+	return best, nil
+}
+
+// compareVersions compares dot-separated numeric versions; pre-release
+// suffixes, like "-rc1", are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	if i := strings.IndexAny(v, "-+~"); i >= 0 {
+		v = v[:i]
+	}
+	var res []int
+	for _, p := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(p)
+		res = append(res, n)
+	}
+	return res
+}
@@ -0,0 +1,69 @@
+package idfcomp
+
+import (
+	"testing"
+
+	"cesanta.com/mos/build"
+)
+
+func TestSelectVersion(t *testing.T) {
+	yanked := "2020-01-01"
+	versions := []RegistryVersion{
+		{Version: "1.2.3"},
+		{Version: "1.10.0"},
+		{Version: "2.0.0", YankedAt: &yanked},
+		{Version: "1.2.10"},
+		{Version: "0.9.0"},
+	}
+	for _, c := range []struct {
+		spec, expected string
+	}{
+		{"", "1.10.0"},
+		{"latest", "1.10.0"},
+		{"1.2", "1.2.10"},
+		{"1", "1.10.0"},
+		{"0.9.0", "0.9.0"},
+		{"v1.2.3", "1.2.3"},
+		{"2.0.0", "2.0.0"},
+		{"2", ""},
+		{"3", ""},
+	} {
+		v, err := SelectVersion(versions, c.spec)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", c.spec, v.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.spec, err)
+		} else if v.Version != c.expected {
+			t.Errorf("%q: expected %s, got %s", c.spec, c.expected, v.Version)
+		}
+	}
+}
+
+func TestComponentName(t *testing.T) {
+	for _, c := range []struct {
+		m        build.SWModule
+		expected string
+	}{
+		{build.SWModule{Name: "espressif/led_strip"}, "espressif__led_strip"},
+		{build.SWModule{Location: "https://github.com/foo/esp-bar"}, "esp-bar"},
+		{build.SWModule{Location: "https://github.com/foo/esp-bar", Name: "bar"}, "bar"},
+		{build.SWModule{Name: "espressif/"}, ""},
+	} {
+		name, err := ComponentName(&c.m)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%+v: expected an error, got %q", c.m, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %s", c.m, err)
+		} else if name != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.m, c.expected, name)
+		}
+	}
+}
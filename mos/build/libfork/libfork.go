@@ -0,0 +1,26 @@
+// Package libfork contains helpers for "mos libs fork": pointing libs of the
+// manifest to forks.
+package libfork
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cesanta/errors"
+)
+
+// SetLibLocation returns the manifest data with the lib location, given as
+// "location" or the deprecated "origin", changed from the old one to the new
+// one. The manifest is edited textually, so that comments and formatting are
+// preserved; all the occurrences are changed, including the ones in conds.
+func SetLibLocation(data []byte, oldLocation, newLocation string) ([]byte, error) {
+	re := regexp.MustCompile(`(?m)^([ \t]*(?:-[ \t]*)?(?:location|origin):[ \t]*)(["']?)` +
+		regexp.QuoteMeta(oldLocation) + `(?:\.git)?/?(["']?)([ \t]*(?:#.*)?)$`)
+	if !re.Match(data) {
+		return nil, errors.Errorf("location %q is not found in the manifest", oldLocation)
+	}
+	return re.ReplaceAllFunc(data, func(line []byte) []byte {
+		m := re.FindSubmatch(line)
+		return []byte(fmt.Sprintf("%s%s%s%s%s", m[1], m[2], newLocation, m[3], m[4]))
+	}), nil
+}
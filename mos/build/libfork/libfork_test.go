@@ -0,0 +1,45 @@
+package libfork
+
+import (
+	"testing"
+)
+
+func TestSetLibLocation(t *testing.T) {
+	data := `name: app
+libs:
+  # Networking
+  - location: https://github.com/mongoose-os-libs/wifi  # pinned below
+    version: 1.2.3
+  - origin: "https://github.com/mongoose-os-libs/wifi.git"
+  - location: https://github.com/mongoose-os-libs/wifi-extra
+conds:
+  - when: mos.platform == "esp32"
+    apply:
+      libs:
+        - location: https://github.com/mongoose-os-libs/wifi
+`
+	exp := `name: app
+libs:
+  # Networking
+  - location: https://git.example.com/libs/wifi.git  # pinned below
+    version: 1.2.3
+  - origin: "https://git.example.com/libs/wifi.git"
+  - location: https://github.com/mongoose-os-libs/wifi-extra
+conds:
+  - when: mos.platform == "esp32"
+    apply:
+      libs:
+        - location: https://git.example.com/libs/wifi.git
+`
+	res, err := SetLibLocation([]byte(data), "https://github.com/mongoose-os-libs/wifi", "https://git.example.com/libs/wifi.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != exp {
+		t.Errorf("unexpected result:\n%s", res)
+	}
+
+	if _, err := SetLibLocation([]byte(data), "https://github.com/mongoose-os-libs/rpc", "x"); err == nil {
+		t.Errorf("expected an error for a missing location")
+	}
+}
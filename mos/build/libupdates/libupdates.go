@@ -0,0 +1,115 @@
+// Package libupdates finds out updates of libs: release tags newer than the
+// version used, whether they are major bumps, and the changelog entries of
+// the versions in between.
+package libupdates
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionTagRE = regexp.MustCompile(`^v?(\d+)(\.\d+)*$`)
+
+// ReleaseTags returns the release tags, like "v1.2.3" or "1.2", from the
+// "git ls-remote --tags" output; peeled tags and other refs are skipped.
+func ReleaseTags(lsRemote string) []string {
+	var res []string
+	scanner := bufio.NewScanner(strings.NewReader(lsRemote))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		if versionTagRE.MatchString(tag) {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// Latest returns the latest of the release tags, or an empty string.
+func Latest(tags []string) string {
+	latest := ""
+	for _, t := range tags {
+		if latest == "" || Compare(t, latest) > 0 {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// IsVersion returns whether the lib version is a release version, like
+// "1.2" or "v1.2.3", as opposed to a branch or a commit.
+func IsVersion(v string) bool {
+	return versionTagRE.MatchString(v)
+}
+
+// Compare compares the versions, with an optional "v" prefix.
+func Compare(a, b string) int {
+	pa, pb := parts(a), parts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parts(v string) []int {
+	var res []int
+	for _, p := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, _ := strconv.Atoi(p)
+		res = append(res, n)
+	}
+	return res
+}
+
+// IsMajorBump returns whether the update from the version to the newer one
+// is known to be breaking: the major version changes, or, for 0.x
+// versions, the minor one, as per semver.
+func IsMajorBump(from, to string) bool {
+	pf, pt := parts(from), parts(to)
+	if pf[0] != pt[0] {
+		return true
+	}
+	return pf[0] == 0 && len(pf) > 1 && len(pt) > 1 && pf[1] != pt[1]
+}
+
+var headingRE = regexp.MustCompile(`^#+\s*\[?v?(\d+(?:\.\d+)+)`)
+
+// ChangelogExcerpt returns the entries of the changelog, in markdown, of
+// the versions newer than from and up to to, at most maxLines lines.
+// Entries start with headings like "## 1.2.0", "## [v1.2.0] - 2020-01-01".
+func ChangelogExcerpt(changelog, from, to string, maxLines int) string {
+	var res []string
+	in := false
+	scanner := bufio.NewScanner(strings.NewReader(changelog))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := headingRE.FindStringSubmatch(line); m != nil {
+			in = Compare(m[1], from) > 0 && Compare(m[1], to) <= 0
+		}
+		if !in {
+			continue
+		}
+		if len(res) == maxLines {
+			res = append(res, "...")
+			break
+		}
+		res = append(res, line)
+	}
+	return strings.TrimSpace(strings.Join(res, "\n"))
+}
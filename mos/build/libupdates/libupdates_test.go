@@ -0,0 +1,60 @@
+package libupdates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReleaseTags(t *testing.T) {
+	out := "a1\trefs/tags/1.9\n" +
+		"b2\trefs/tags/v1.10.0\n" +
+		"c3\trefs/tags/v1.10.0^{}\n" +
+		"d4\trefs/tags/latest\n" +
+		"e5\trefs/heads/master\n" +
+		"f6\trefs/tags/2.0-rc1\n"
+	tags := ReleaseTags(out)
+	if !reflect.DeepEqual(tags, []string{"1.9", "v1.10.0"}) {
+		t.Errorf("got %q", tags)
+	}
+	if l := Latest(tags); l != "v1.10.0" {
+		t.Errorf("latest: got %q", l)
+	}
+	if l := Latest(nil); l != "" {
+		t.Errorf("latest of none: got %q", l)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	if Compare("v1.2", "1.2.0") != 0 || Compare("1.10", "1.9") != 1 || Compare("1.2.3", "1.3") != -1 {
+		t.Errorf("bad comparison")
+	}
+	for _, c := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"1.2", "1.9", false},
+		{"1.9", "v2.0", true},
+		{"0.3.1", "0.4", true},
+		{"0.3.1", "0.3.5", false},
+	} {
+		if got := IsMajorBump(c.from, c.to); got != c.want {
+			t.Errorf("%s -> %s: got %v", c.from, c.to, got)
+		}
+	}
+	if !IsVersion("v1.2") || IsVersion("master") || IsVersion("a1b2c3d") {
+		t.Errorf("bad IsVersion")
+	}
+}
+
+func TestChangelogExcerpt(t *testing.T) {
+	cl := "# Changelog\n\n## [1.3.0] - 2020-05-01\n- New API\n- Fix\n\n## v1.2.0\n- Old change\n\n## 1.1\n- Ancient\n"
+	if got := ChangelogExcerpt(cl, "1.1", "1.3.0", 10); got != "## [1.3.0] - 2020-05-01\n- New API\n- Fix\n\n## v1.2.0\n- Old change" {
+		t.Errorf("got %q", got)
+	}
+	if got := ChangelogExcerpt(cl, "1.2", "1.3", 2); got != "## [1.3.0] - 2020-05-01\n- New API\n..." {
+		t.Errorf("limited: got %q", got)
+	}
+	if got := ChangelogExcerpt(cl, "1.3", "1.3", 10); got != "" {
+		t.Errorf("none: got %q", got)
+	}
+}
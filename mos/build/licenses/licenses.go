@@ -0,0 +1,156 @@
+// Package licenses finds out licenses of libs, from their manifests and
+// license files, and checks them against the allowlist of the app.
+package licenses
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Unknown is the license of a lib which neither declares it nor has a
+// recognized license file.
+const Unknown = "unknown"
+
+// Signatures of the licenses in license files, SPDX ids to phrases; the
+// first id matching in order wins, so that, e.g., LGPL is not taken for GPL.
+var signatures = []struct {
+	id      string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge", "the above copyright notice"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"Zlib", []string{"this software is provided 'as-is'", "altered source versions must be plainly marked"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+}
+
+var spaceRE = regexp.MustCompile(`\s+`)
+
+// Detect returns the SPDX id of the license text, or an empty string.
+func Detect(text []byte) string {
+	s := spaceRE.ReplaceAllString(strings.ToLower(string(text)), " ")
+next:
+	for _, sig := range signatures {
+		for _, p := range sig.phrases {
+			if !strings.Contains(s, p) {
+				continue next
+			}
+		}
+		return sig.id
+	}
+	return ""
+}
+
+var fileRE = regexp.MustCompile(`(?i)^(licen[cs]e|copying)([.-].*)?$`)
+
+// DetectDir returns the license file in the dir and the license detected in
+// it; the license is empty if it's not recognized, and both are empty if
+// there is no license file.
+func DetectDir(dir string) (string, string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", errors.Trace(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && fileRE.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", "", errors.Trace(err)
+		}
+		if id := Detect(data); id != "" {
+			return name, id, nil
+		}
+	}
+	if len(names) > 0 {
+		return names[0], "", nil
+	}
+	return "", "", nil
+}
+
+// Lib is a lib and its licenses.
+type Lib struct {
+	Name string
+	// License declared in the lib manifest, an SPDX expression.
+	Declared string
+	// License file and the license detected in it.
+	File     string
+	Detected string
+}
+
+// License returns the license of the lib: the declared one, or the detected
+// one, or Unknown.
+func (l *Lib) License() string {
+	switch {
+	case l.Declared != "":
+		return l.Declared
+	case l.Detected != "":
+		return l.Detected
+	}
+	return Unknown
+}
+
+// Allowed returns whether the SPDX license expression is allowed: for "A OR
+// B", one of the licenses must be allowed, and for "A AND B", both. An
+// expression with WITH exceptions is allowed if it's allowed without them.
+// The comparison is case insensitive, and Unknown is only allowed if listed.
+func Allowed(expr string, allowed []string) bool {
+	allow := map[string]bool{}
+	for _, a := range allowed {
+		allow[strings.ToLower(a)] = true
+	}
+	expr = strings.NewReplacer("(", " ", ")", " ").Replace(expr)
+	for _, alt := range splitOp(expr, "or") {
+		ok := true
+		for _, id := range splitOp(alt, "and") {
+			id = strings.TrimSpace(strings.SplitN(strings.ToLower(id), " with ", 2)[0])
+			if !allow[id] && !allow[strings.TrimSuffix(id, "+")] && !allow[strings.TrimSuffix(id, "-or-later")] &&
+				!allow[strings.TrimSuffix(id, "-only")] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOp splits the expression by the operator, case insensitively.
+func splitOp(expr, op string) []string {
+	return regexp.MustCompile(`(?i)\s+`+op+`\s+`).Split(strings.TrimSpace(expr), -1)
+}
+
+// Check returns the libs whose licenses are not allowed.
+func Check(libs []Lib, allowed []string) []Lib {
+	var res []Lib
+	for _, l := range libs {
+		if !Allowed(l.License(), allowed) {
+			res = append(res, l)
+		}
+	}
+	return res
+}
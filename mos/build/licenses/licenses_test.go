@@ -0,0 +1,79 @@
+package licenses
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want string
+	}{
+		{"Apache License\n   Version 2.0, January 2004", "Apache-2.0"},
+		{"MIT License\n\nPermission is hereby granted, free of charge, to any person ... The above\ncopyright notice", "MIT"},
+		{"GNU LESSER GENERAL PUBLIC LICENSE\nVersion 2.1, February 1999", "LGPL-2.1"},
+		{"GNU GENERAL PUBLIC LICENSE\nVersion 2, June 1991", "GPL-2.0"},
+		{"Redistribution and use in source and binary forms ... Neither the name of", "BSD-3-Clause"},
+		{"All rights reserved.", ""},
+	} {
+		if got := Detect([]byte(c.text)); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestDetectDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "licenses_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if f, id, err := DetectDir(dir); f != "" || id != "" || err != nil {
+		t.Errorf("empty dir: got %q %q %v", f, id, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "LICENSE.txt"), []byte("Copyright ACME, all rights reserved"), 0644)
+	if f, id, err := DetectDir(dir); f != "LICENSE.txt" || id != "" || err != nil {
+		t.Errorf("unknown license: got %q %q %v", f, id, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "COPYING"), []byte("GNU GENERAL PUBLIC LICENSE Version 3"), 0644)
+	if f, id, err := DetectDir(dir); f != "COPYING" || id != "GPL-3.0" || err != nil {
+		t.Errorf("GPL: got %q %q %v", f, id, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	allowed := []string{"MIT", "apache-2.0", "BSD-3-Clause", "LGPL-2.1"}
+	for _, c := range []struct {
+		expr string
+		want bool
+	}{
+		{"MIT", true},
+		{"Apache-2.0", true},
+		{"GPL-2.0", false},
+		{"GPL-2.0 OR MIT", true},
+		{"(MIT AND GPL-3.0)", false},
+		{"MIT AND BSD-3-Clause", true},
+		{"LGPL-2.1-or-later", true},
+		{"LGPL-2.1+", true},
+		{"Apache-2.0 WITH LLVM-exception", true},
+		{Unknown, false},
+	} {
+		if got := Allowed(c.expr, allowed); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	libs := []Lib{
+		{Name: "a", Declared: "MIT"},
+		{Name: "b", Detected: "GPL-3.0"},
+		{Name: "c"},
+		{Name: "d", Declared: "Apache-2.0", Detected: "GPL-3.0"},
+	}
+	bad := Check(libs, allowed)
+	if len(bad) != 2 || bad[0].Name != "b" || bad[1].Name != "c" || bad[1].License() != Unknown {
+		t.Errorf("bad violations: %+v", bad)
+	}
+}
@@ -66,10 +66,47 @@ type FWAppManifest struct {
 	CXXFlags     []string           `yaml:"cxxflags,omitempty" json:"cxxflags"`
 	CDefs        map[string]string  `yaml:"cdefs,omitempty" json:"cdefs"`
 	Tags         []string           `yaml:"tags,omitempty" json:"tags"`
+	Hooks        *ManifestHooks     `yaml:"hooks,omitempty" json:"hooks,omitempty"`
+	FSAssets     *FSAssetsOpts      `yaml:"fs_assets,omitempty" json:"fs_assets,omitempty"`
+	EmbedAssets  []EmbeddedAsset    `yaml:"embed_assets,omitempty" json:"embed_assets,omitempty"`
+	Release      *ReleaseOpts       `yaml:"release,omitempty" json:"release,omitempty"`
+	// C++ standard and features; merged from all libs and the app.
+	CXX *CXXOpts `yaml:"cxx,omitempty" json:"cxx,omitempty"`
+	// Custom partition table (ESP32); only taken from the app manifest.
+	PartitionTable *PartitionTableOpts `yaml:"partition_table,omitempty" json:"partition_table,omitempty"`
+	// Board profile (see mos boards); only taken from the app manifest.
+	Board string `yaml:"board,omitempty" json:"board,omitempty"`
+	// Custom build info embedded into the firmware (see build/buildinfo); only
+	// taken from the app manifest.
+	BuildInfo map[string]string `yaml:"build_info,omitempty" json:"build_info,omitempty"`
+	// ESP-IDF components to build the firmware with (see build/idfcomp); on
+	// ESP32 only.
+	ESPIDFComponents []SWModule `yaml:"esp_idf_components,omitempty" json:"esp_idf_components,omitempty"`
+	// Arduino libraries to build the firmware with; only taken from the app
+	// manifest.
+	ArduinoLibs []ArduinoLib `yaml:"arduino_libs,omitempty" json:"arduino_libs,omitempty"`
+	// RPC calls "mos flash" makes after flashing to check that the device
+	// came up healthy; only taken from the app manifest.
+	SmokeTest []SmokeTestStep `yaml:"smoke_test,omitempty" json:"smoke_test,omitempty"`
+	// Rust staticlib crates to build and link into the firmware (see
+	// build/rust); only taken from the app manifest.
+	RustCrates []RustCrate `yaml:"rust_crates,omitempty" json:"rust_crates,omitempty"`
+	// Version of mos the project is supposed to be built with, and the minimal
+	// version; only taken from the app manifest.
+	MosVersion    string `yaml:"mos_version,omitempty" json:"mos_version,omitempty"`
+	MinMosVersion string `yaml:"min_mos_version,omitempty" json:"min_mos_version,omitempty"`
+	// License of the app or lib, an SPDX expression like "MIT" or
+	// "Apache-2.0 OR MIT".
+	License string `yaml:"license,omitempty" json:"license,omitempty"`
+	// Licenses libs are allowed to have, see build/licenses; only taken from
+	// the app manifest.
+	AllowedLicenses []string `yaml:"allowed_licenses,omitempty" json:"allowed_licenses,omitempty"`
 
 	LibsVersion       string `yaml:"libs_version,omitempty" json:"libs_version"`
 	ModulesVersion    string `yaml:"modules_version,omitempty" json:"modules_version"`
 	MongooseOsVersion string `yaml:"mongoose_os_version,omitempty" json:"mongoose_os_version"`
+	// Location of the mongoose-os repo, to use a fork instead of the upstream.
+	MongooseOsRepo string `yaml:"mongoose_os_repo,omitempty" json:"mongoose_os_repo,omitempty"`
 
 	Conds []ManifestCond `yaml:"conds,omitempty" json:"conds"`
 
@@ -82,6 +119,189 @@ type FWAppManifest struct {
 	// application. The user doesn't have to set this field manually, it's set
 	// automatically during libs "expansion" (see Libs above)
 	LibsHandled []FWAppManifestLibHandled `yaml:"libs_handled,omitempty" json:"libs_handled"`
+
+	// Names of the manifests build vars come from: lib names, "app" or
+	// manifest_parser.RootManifestName. Only set in the final manifest.
+	BuildVarsSources map[string]string `yaml:"-" json:"-"`
+}
+
+// ManifestHooks contains commands which are executed by mos at certain
+// points of the project lifecycle. Hooks are only taken from the app
+// manifest; hooks of libs are ignored.
+type ManifestHooks struct {
+	PreBuild  HookCommands `yaml:"pre_build,omitempty" json:"pre_build,omitempty"`
+	PostBuild HookCommands `yaml:"post_build,omitempty" json:"post_build,omitempty"`
+	PreFlash  HookCommands `yaml:"pre_flash,omitempty" json:"pre_flash,omitempty"`
+	PostFlash HookCommands `yaml:"post_flash,omitempty" json:"post_flash,omitempty"`
+	PreOTA    HookCommands `yaml:"pre_ota,omitempty" json:"pre_ota,omitempty"`
+	PostOTA   HookCommands `yaml:"post_ota,omitempty" json:"post_ota,omitempty"`
+}
+
+// HookCommands is a list of shell commands; in the manifest, it can be
+// given either as a single string or as a list of strings.
+type HookCommands []string
+
+func (hc *HookCommands) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*hc = HookCommands{s}
+		return nil
+	}
+
+	var ss []string
+	if err := unmarshal(&ss); err != nil {
+		return errors.Errorf("hook must be either a string or a list of strings")
+	}
+	*hc = HookCommands(ss)
+	return nil
+}
+
+// PartitionTableOpts is the custom partition table which replaces the one of
+// the platform.
+type PartitionTableOpts struct {
+	// Flash size, like "4M"; if set, partitions are checked to fit in it.
+	FlashSize  string                `yaml:"flash_size,omitempty" json:"flash_size,omitempty"`
+	Partitions []PartitionTableEntry `yaml:"partitions,omitempty" json:"partitions,omitempty"`
+}
+
+// PartitionTableEntry defines a partition like a line of the ESP-IDF
+// partition CSV file. If offset is empty, the partition follows the previous
+// one.
+type PartitionTableEntry struct {
+	Name    string `yaml:"name" json:"name"`
+	Type    string `yaml:"type" json:"type"`
+	SubType string `yaml:"subtype" json:"subtype"`
+	Offset  string `yaml:"offset,omitempty" json:"offset,omitempty"`
+	Size    string `yaml:"size" json:"size"`
+	Flags   string `yaml:"flags,omitempty" json:"flags,omitempty"`
+}
+
+// CXXOpts selects the C++ standard and features for the C++ sources of the
+// app and all libs. When merged, the newest standard wins, and features are
+// enabled if any lib or the app needs them.
+type CXXOpts struct {
+	// Standard, like "c++17" or "gnu++14".
+	Std string `yaml:"std,omitempty" json:"std,omitempty"`
+	// Whether exceptions and RTTI are enabled; unset means the platform
+	// default.
+	Exceptions *bool `yaml:"exceptions,omitempty" json:"exceptions,omitempty"`
+	RTTI       *bool `yaml:"rtti,omitempty" json:"rtti,omitempty"`
+}
+
+// Flags returns the compiler flags for the options.
+func (o *CXXOpts) Flags() []string {
+	if o == nil {
+		return nil
+	}
+	var res []string
+	if o.Std != "" {
+		res = append(res, "-std="+o.Std)
+	}
+	if o.Exceptions != nil {
+		if *o.Exceptions {
+			res = append(res, "-fexceptions")
+		} else {
+			res = append(res, "-fno-exceptions")
+		}
+	}
+	if o.RTTI != nil {
+		if *o.RTTI {
+			res = append(res, "-frtti")
+		} else {
+			res = append(res, "-fno-rtti")
+		}
+	}
+	return res
+}
+
+// FSAssetsOpts configures processing of the filesystem files during build.
+// Like hooks, it's only taken from the app manifest.
+type FSAssetsOpts struct {
+	// If true, .js files are minified (comments and redundant whitespace are
+	// removed).
+	MinifyJS bool `yaml:"minify_js,omitempty" json:"minify_js,omitempty"`
+	// Bundles are concatenated from several files.
+	Bundles []FSAssetsBundle `yaml:"bundles,omitempty" json:"bundles,omitempty"`
+	// Files whose base name matches any of the patterns are gzipped and get
+	// the .gz suffix.
+	Gzip []string `yaml:"gzip,omitempty" json:"gzip,omitempty"`
+}
+
+// FSAssetsBundle is a file concatenated from all filesystem files whose base
+// names match any of the Files patterns, in the order of patterns.
+type FSAssetsBundle struct {
+	Name  string   `yaml:"name,omitempty" json:"name"`
+	Files []string `yaml:"files,omitempty" json:"files"`
+}
+
+// EmbeddedAsset is a file compiled into the firmware as a C array, for apps
+// which can't rely on the filesystem. Like hooks, embedded assets are only
+// taken from the app manifest.
+type EmbeddedAsset struct {
+	// Path to the file, relative to the app directory.
+	Src string `yaml:"src,omitempty" json:"src"`
+	// Name of the C array; by default, derived from the file name, like
+	// index_html for index.html.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// If true, the data is gzipped.
+	Gzip bool `yaml:"gzip,omitempty" json:"gzip,omitempty"`
+}
+
+// ReleaseOpts configures "mos release". Like hooks, it's only taken from the
+// app manifest.
+type ReleaseOpts struct {
+	// Platforms to build release firmware for; if empty, the app platform is
+	// used.
+	Platforms []string `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+	// GitHub repo to create the release in, "owner/repo". GITHUB_TOKEN must
+	// be set in the environment.
+	GitHub string `yaml:"github,omitempty" json:"github,omitempty"`
+	// S3 location to upload the artifacts to, "s3://bucket[/prefix]"; the
+	// artifacts are uploaded to the subdirectory named after the release tag,
+	// using the aws CLI.
+	S3 string `yaml:"s3,omitempty" json:"s3,omitempty"`
+	// Firmware is scanned for leaked secrets (see "mos fw scan") before the
+	// release; findings matching any of these regexps are ignored.
+	ScanAllow []string `yaml:"scan_allow,omitempty" json:"scan_allow,omitempty"`
+}
+
+// ArduinoLib is a library from the Arduino library index (see
+// build/arduinolib), built into the firmware along with the libraries it
+// depends on.
+type ArduinoLib struct {
+	// Library name as in the index, like "Adafruit NeoPixel".
+	Name string `yaml:"name" json:"name"`
+	// Exact version or a prefix, like "1.10"; the latest if empty.
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	// Zip or tar.gz archive URL to take the library from instead of the
+	// index, with the optional digest.
+	Location string `yaml:"location,omitempty" json:"location,omitempty"`
+	SHA256   string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+	// Patch files, relative to the app directory, applied to the library in
+	// order.
+	Patches []string `yaml:"patches,omitempty" json:"patches,omitempty"`
+	// Commands run in the library dir after the patches are applied.
+	PostFetch HookCommands `yaml:"post_fetch,omitempty" json:"post_fetch,omitempty"`
+}
+
+// SmokeTestStep is an RPC call which has to succeed and, optionally, return
+// the expected values.
+type SmokeTestStep struct {
+	Method string `yaml:"method" json:"method"`
+	// Args, as JSON.
+	Args string `yaml:"args,omitempty" json:"args,omitempty"`
+	// Paths in the response, like "app", mapped to the expected values.
+	Expect map[string]string `yaml:"expect,omitempty" json:"expect,omitempty"`
+}
+
+// RustCrate is a Rust staticlib crate built with cargo.
+type RustCrate struct {
+	// Dir of the crate with Cargo.toml, relative to the app directory.
+	Path string `yaml:"path" json:"path"`
+	// Target triple; by default, the one of the platform.
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	// Cargo features to enable.
+	Features []string `yaml:"features,omitempty" json:"features,omitempty"`
 }
 
 // ConfigSchemaItem represents a single config schema item, like this:
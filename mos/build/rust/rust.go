@@ -0,0 +1,123 @@
+// Package rust builds Rust staticlib crates, listed in "rust_crates" of the
+// app manifest, for the target of the platform, and generates C headers for
+// them with cbindgen. The resulting static libs are linked into the firmware
+// like binary libs. Support is experimental.
+//
+// Crates must have crate-type = ["staticlib"] and, for the embedded targets,
+// be #![no_std] with a panic handler. Xtensa targets (ESP32, ESP8266) need
+// the "esp" toolchain of espup, and the core lib is built from source.
+package rust
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strings"
+
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+// Target triples of the platforms
+var targets = map[string]string{
+	"esp32":   "xtensa-esp32-none-elf",
+	"esp8266": "xtensa-esp8266-none-elf",
+	"cc3200":  "thumbv7em-none-eabi",
+	"cc3220":  "thumbv7em-none-eabi",
+	"stm32":   "thumbv7em-none-eabihf",
+	"ubuntu":  "x86_64-unknown-linux-gnu",
+}
+
+// Target returns the target triple of the crate for the platform.
+func Target(c *build.RustCrate, platform string) (string, error) {
+	if c.Target != "" {
+		return c.Target, nil
+	}
+	t, ok := targets[platform]
+	if !ok {
+		return "", errors.Errorf("no Rust target for the platform %q, set target of the crate", platform)
+	}
+	return t, nil
+}
+
+// Names returns the name of the package from Cargo.toml, which cargo and
+// cbindgen know the crate by, and the name of the lib the crate builds: the
+// name of [lib], or of [package], with dashes replaced with underscores.
+func Names(cargoToml []byte) (string, string, error) {
+	section, pkgName, libName := "", "", ""
+	scanner := bufio.NewScanner(strings.NewReader(string(cargoToml)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "name" {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(strings.SplitN(parts[1], "#", 2)[0]), `"'`)
+		switch section {
+		case "package":
+			pkgName = value
+		case "lib":
+			libName = value
+		}
+	}
+	if pkgName == "" {
+		return "", "", errors.Errorf("no package name in Cargo.toml")
+	}
+	if libName == "" {
+		libName = pkgName
+	}
+	return pkgName, strings.Replace(libName, "-", "_", -1), nil
+}
+
+// Build is a build of a crate.
+type Build struct {
+	// Paths of the crate dir, the cargo target dir and the header to generate,
+	// as slash paths valid where the script runs.
+	CrateDir  string
+	TargetDir string
+	Header    string
+	Target    string
+	Features  []string
+	// Package name, see Names
+	PackageName string
+	LibName     string
+}
+
+// Lib returns the path of the static lib the build produces, relative to the
+// target dir.
+func (bld *Build) Lib() string {
+	return path.Join(bld.Target, "release", "lib"+bld.LibName+".a")
+}
+
+// Script returns the shell script which builds the crate and generates the
+// header.
+func (bld *Build) Script() string {
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	fmt.Fprintf(&b, "cd %s\n", quote(bld.CrateDir))
+	cargo := []string{"cargo"}
+	if strings.HasPrefix(bld.Target, "xtensa-") {
+		cargo = append(cargo, "+esp")
+	}
+	cargo = append(cargo, "build", "--release", "--target", quote(bld.Target),
+		"--target-dir", quote(bld.TargetDir))
+	if strings.HasPrefix(bld.Target, "xtensa-") {
+		cargo = append(cargo, "-Zbuild-std=core")
+	}
+	if len(bld.Features) > 0 {
+		cargo = append(cargo, "--features", quote(strings.Join(bld.Features, ",")))
+	}
+	b.WriteString(strings.Join(cargo, " ") + "\n")
+	fmt.Fprintf(&b, "mkdir -p %s\n", quote(path.Dir(bld.Header)))
+	fmt.Fprintf(&b, "cbindgen --quiet --lang c --crate %s --output %s\n",
+		quote(bld.PackageName), quote(bld.Header))
+	return b.String()
+}
+
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
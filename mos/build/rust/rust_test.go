@@ -0,0 +1,63 @@
+package rust
+
+import (
+	"testing"
+
+	"cesanta.com/mos/build"
+)
+
+func TestNames(t *testing.T) {
+	for _, c := range []struct {
+		toml, expPkg, expLib string
+	}{
+		{"[package]\nname = \"sensor-filter\"\nversion = \"0.1.0\"\n", "sensor-filter", "sensor_filter"},
+		{"[package]\nname = \"foo\"\n\n[lib]\nname = 'bar' # the C name\ncrate-type = [\"staticlib\"]\n", "foo", "bar"},
+		{"[dependencies]\nname = \"x\"\n", "", ""},
+	} {
+		pkg, lib, err := Names([]byte(c.toml))
+		if c.expPkg == "" {
+			if err == nil {
+				t.Errorf("expected an error for %q", c.toml)
+			}
+			continue
+		}
+		if err != nil || pkg != c.expPkg || lib != c.expLib {
+			t.Errorf("%q: expected %q, %q, got %q, %q, %v", c.toml, c.expPkg, c.expLib, pkg, lib, err)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	target, err := Target(&build.RustCrate{}, "esp32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bld := &Build{
+		CrateDir:    "/app/rust/filter",
+		TargetDir:   "/app/build/rust",
+		Header:      "/app/build/rust/include/filter.h",
+		Target:      target,
+		Features:    []string{"fast", "log"},
+		PackageName: "sensor-filter",
+		LibName:     "filter",
+	}
+	exp := `set -e
+cd '/app/rust/filter'
+cargo +esp build --release --target 'xtensa-esp32-none-elf' --target-dir '/app/build/rust' -Zbuild-std=core --features 'fast,log'
+mkdir -p '/app/build/rust/include'
+cbindgen --quiet --lang c --crate 'sensor-filter' --output '/app/build/rust/include/filter.h'
+`
+	if s := bld.Script(); s != exp {
+		t.Errorf("unexpected script:\n%s", s)
+	}
+	if l := bld.Lib(); l != "xtensa-esp32-none-elf/release/libfilter.a" {
+		t.Errorf("unexpected lib %q", l)
+	}
+
+	if _, err := Target(&build.RustCrate{}, "nope"); err == nil {
+		t.Errorf("expected an error for an unknown platform")
+	}
+	if target, _ := Target(&build.RustCrate{Target: "riscv32imc-unknown-none-elf"}, "esp32"); target != "riscv32imc-unknown-none-elf" {
+		t.Errorf("target of the crate is not used")
+	}
+}
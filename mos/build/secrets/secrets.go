@@ -0,0 +1,83 @@
+// Package secrets finds and decrypts the values encrypted with age
+// (https://age-encryption.org), like the ones produced by
+// "age --armor -r <recipient>", in config schema defaults and config values,
+// so that credentials can be kept in the repo and decrypted at build or apply
+// time with a local key.
+//
+// Values are ASCII-armored, e.g. in mos.yml:
+//
+//	config_schema:
+//	  - ["wifi.sta.pass", |
+//	      -----BEGIN AGE ENCRYPTED FILE-----
+//	      ...
+//	      -----END AGE ENCRYPTED FILE-----
+//	    ]
+package secrets
+
+import (
+	"strings"
+
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+// ArmorHeader starts the encrypted values.
+const ArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+// DecryptFunc returns the plain text of the armored value.
+type DecryptFunc func(armored string) (string, error)
+
+// IsEncrypted returns whether the value is an encrypted one.
+func IsEncrypted(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(strings.TrimSpace(s), ArmorHeader)
+}
+
+// HasEncrypted returns whether any of the config schema items has encrypted
+// values.
+func HasEncrypted(items []build.ConfigSchemaItem) bool {
+	for _, item := range items {
+		for _, v := range item {
+			if IsEncrypted(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DecryptConfigSchema returns the config schema items with the encrypted
+// values decrypted. Items are copied, the given ones are not changed.
+func DecryptConfigSchema(items []build.ConfigSchemaItem, decrypt DecryptFunc) ([]build.ConfigSchemaItem, error) {
+	var res []build.ConfigSchemaItem
+	for _, item := range items {
+		newItem := make(build.ConfigSchemaItem, len(item))
+		for i, v := range item {
+			if IsEncrypted(v) {
+				plain, err := decrypt(strings.TrimSpace(v.(string)))
+				if err != nil {
+					return nil, errors.Annotatef(err, "decrypting the value of %v", item[0])
+				}
+				v = plain
+			}
+			newItem[i] = v
+		}
+		res = append(res, newItem)
+	}
+	return res, nil
+}
+
+// DecryptValues decrypts the encrypted values of the map in place.
+func DecryptValues(values map[string]string, decrypt DecryptFunc) error {
+	for k, v := range values {
+		if !IsEncrypted(v) {
+			continue
+		}
+		plain, err := decrypt(strings.TrimSpace(v))
+		if err != nil {
+			return errors.Annotatef(err, "decrypting the value of %s", k)
+		}
+		values[k] = plain
+	}
+	return nil
+}
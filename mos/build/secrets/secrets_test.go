@@ -0,0 +1,55 @@
+package secrets
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+const armored = ArmorHeader + "\nc2VjcmV0\n-----END AGE ENCRYPTED FILE-----\n"
+
+func fakeDecrypt(s string) (string, error) {
+	if !strings.HasPrefix(s, ArmorHeader) || strings.HasSuffix(s, "\n") {
+		return "", errors.Errorf("bad input %q", s)
+	}
+	return "secret", nil
+}
+
+func TestDecryptConfigSchema(t *testing.T) {
+	items := []build.ConfigSchemaItem{
+		{"wifi.sta.ssid", "home"},
+		{"wifi.sta.pass", "s", armored, map[interface{}]interface{}{"title": "Password"}},
+		{"wifi.sta.enable", true},
+	}
+	if !HasEncrypted(items) || HasEncrypted(items[:1]) {
+		t.Errorf("HasEncrypted is wrong")
+	}
+	res, err := DecryptConfigSchema(items, fakeDecrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []build.ConfigSchemaItem{
+		{"wifi.sta.ssid", "home"},
+		{"wifi.sta.pass", "s", "secret", map[interface{}]interface{}{"title": "Password"}},
+		{"wifi.sta.enable", true},
+	}
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("unexpected result: %v", res)
+	}
+	if items[1][2] != armored {
+		t.Errorf("the original items are changed")
+	}
+}
+
+func TestDecryptValues(t *testing.T) {
+	values := map[string]string{"mqtt.pass": "  " + armored, "mqtt.user": "dev"}
+	if err := DecryptValues(values, fakeDecrypt); err != nil {
+		t.Fatal(err)
+	}
+	if values["mqtt.pass"] != "secret" || values["mqtt.user"] != "dev" {
+		t.Errorf("unexpected result: %v", values)
+	}
+}
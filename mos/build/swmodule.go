@@ -1,20 +1,24 @@
 package build
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"cesanta.com/common/go/ourgit"
 	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build/archive"
 	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/download"
 	"cesanta.com/mos/mosgit"
 
 	"github.com/cesanta/errors"
@@ -28,6 +32,8 @@ type SWModule struct {
 	Location  string `yaml:"location,omitempty" json:"location,omitempty"`
 	Version   string `yaml:"version,omitempty" json:"version,omitempty"`
 	Name      string `yaml:"name,omitempty" json:"name,omitempty"`
+	// SHA256 is the expected digest of the archive, for archive modules.
+	SHA256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
 
 	SuffixTpl string
 
@@ -36,6 +42,8 @@ type SWModule struct {
 	Weak bool `yaml:"weak,omitempty" json:"weak,omitempty"`
 
 	localPath string
+	// Pinned version (git commit or archive digest), see Pin.
+	pinned string
 }
 
 type SWModuleType int
@@ -44,8 +52,16 @@ const (
 	SWModuleTypeInvalid SWModuleType = iota
 	SWModuleTypeLocal
 	SWModuleTypeGithub
+	// Any git repo, not necessarily on GitHub
+	SWModuleTypeGit
+	// Zip or tar.gz archive downloaded over HTTP(S)
+	SWModuleTypeArchive
 )
 
+// ArchiveDigestFile is the file with the digest of the archive the module is
+// extracted from, in the module dir.
+const ArchiveDigestFile = ".mos_archive_sha256"
+
 func (m *SWModule) Normalize() {
 	if m.Location == "" && m.OriginOld != "" {
 		m.Location = m.OriginOld
@@ -66,7 +82,7 @@ func (m *SWModule) IsClean(libsDir, defaultVersion string) (bool, error) {
 	}
 
 	switch m.GetType() {
-	case SWModuleTypeGithub:
+	case SWModuleTypeGithub, SWModuleTypeGit, SWModuleTypeArchive:
 		lp := filepath.Join(libsDir, m.getGitDirName(name, m.getVersionGit(defaultVersion)))
 
 		if _, err := os.Stat(lp); err != nil {
@@ -81,6 +97,11 @@ func (m *SWModule) IsClean(libsDir, defaultVersion string) (bool, error) {
 			return false, errors.Trace(err)
 		}
 
+		if m.GetType() == SWModuleTypeArchive {
+			// Archives are not expected to be modified
+			return true, nil
+		}
+
 		// Dir exists, check if it's clean
 		isClean, err := gitinst.IsClean(lp, m.getVersionGit(defaultVersion))
 		if err != nil {
@@ -113,8 +134,11 @@ func (m *SWModule) PrepareLocalDir(
 		}
 
 		switch m.GetType() {
-		case SWModuleTypeGithub:
+		case SWModuleTypeGithub, SWModuleTypeGit:
 			version := m.getVersionGit(defaultVersion)
+			if m.pinned != "" {
+				version = m.pinned
+			}
 			if err := prepareLocalCopyGit(m.Location, version, lp, logWriter, deleteIfFailed, pullInterval, cloneDepth); err != nil {
 				return "", errors.Trace(err)
 			}
@@ -122,6 +146,16 @@ func (m *SWModule) PrepareLocalDir(
 			// Everything went fine, so remember local path (and return it later)
 			m.localPath = lp
 
+		case SWModuleTypeArchive:
+			digest := m.SHA256
+			if m.pinned != "" {
+				digest = m.pinned
+			}
+			if err := prepareLocalCopyArchive(m.Location, digest, lp, logWriter); err != nil {
+				return "", errors.Trace(err)
+			}
+			m.localPath = lp
+
 		case SWModuleTypeLocal:
 			m.localPath = lp
 		}
@@ -130,6 +164,39 @@ func (m *SWModule) PrepareLocalDir(
 	return m.localPath, nil
 }
 
+// IsGit returns true if the module is fetched from a git repo.
+func (m *SWModule) IsGit() bool {
+	t := m.GetType()
+	return t == SWModuleTypeGithub || t == SWModuleTypeGit
+}
+
+// Pin makes PrepareLocalDir use the given git commit or archive digest
+// instead of the version from the manifest, e.g. the one from mos.lock.
+func (m *SWModule) Pin(version string) {
+	m.pinned = version
+}
+
+// GetPin returns the exact version of the prepared local copy, to be pinned:
+// the git commit, or the archive digest. Local modules, and git ones without
+// the repo, have none.
+func (m *SWModule) GetPin(localDir string) (string, error) {
+	switch m.GetType() {
+	case SWModuleTypeGithub, SWModuleTypeGit:
+		if _, err := os.Stat(filepath.Join(localDir, ".git")); err != nil {
+			// Not a checkout, e.g. imported from a bundle: nothing to pin
+			return "", nil
+		}
+		return mosgit.NewOurGit().GetCurrentHash(localDir)
+	case SWModuleTypeArchive:
+		data, err := ioutil.ReadFile(filepath.Join(localDir, ArchiveDigestFile))
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
+
 func (m *SWModule) getVersionGit(defaultVersion string) string {
 	version := m.Version
 	if version == "" {
@@ -143,7 +210,7 @@ func (m *SWModule) getVersionGit(defaultVersion string) string {
 
 func (m *SWModule) GetLocalDir(libsDir, defaultVersion string) (string, error) {
 	switch m.GetType() {
-	case SWModuleTypeGithub:
+	case SWModuleTypeGithub, SWModuleTypeGit, SWModuleTypeArchive:
 		name, err := m.GetName()
 		if err != nil {
 			return "", errors.Trace(err)
@@ -183,7 +250,7 @@ func (m *SWModule) GetName() (string, error) {
 	}
 
 	switch m.GetType() {
-	case SWModuleTypeGithub:
+	case SWModuleTypeGithub, SWModuleTypeGit, SWModuleTypeArchive:
 		// Take last path fragment
 		u, err := url.Parse(m.Location)
 		if err != nil {
@@ -195,7 +262,11 @@ func (m *SWModule) GetName() (string, error) {
 			return "", errors.Errorf("path is empty in the URL %q", u.Path)
 		}
 
-		return parts[len(parts)-1], nil
+		name := strings.TrimSuffix(parts[len(parts)-1], ".git")
+		if m.GetType() == SWModuleTypeArchive {
+			name = archive.TrimExt(name)
+		}
+		return name, nil
 	case SWModuleTypeLocal:
 		_, name := filepath.Split(m.Location)
 		if name == "" {
@@ -222,9 +293,13 @@ func (m *SWModule) GetType() SWModuleType {
 				return SWModuleTypeLocal
 			}
 
-			switch u.Host {
-			case "github.com":
+			switch {
+			case u.Host == "github.com" && !archive.IsArchive(u.Path):
 				stype = "github"
+			case (u.Scheme == "http" || u.Scheme == "https") && archive.IsArchive(u.Path):
+				stype = "archive"
+			case u.Scheme == "git" || u.Scheme == "ssh" || strings.HasSuffix(u.Path, ".git"):
+				stype = "git"
 			}
 		} else {
 			// Name is already checked to be not empty
@@ -235,6 +310,10 @@ func (m *SWModule) GetType() SWModuleType {
 	switch stype {
 	case "github":
 		return SWModuleTypeGithub
+	case "git":
+		return SWModuleTypeGit
+	case "archive":
+		return SWModuleTypeArchive
 	default:
 		return SWModuleTypeLocal
 	}
@@ -307,6 +386,20 @@ func prepareLocalCopyGit(
 			freportf(logWriter, "Repository %q is dirty, leaving it intact\n", targetDir)
 			return nil
 		}
+
+		// Make sure the repo tracks the requested origin: pulling from whatever
+		// it was cloned from would give the wrong code.
+		curOrigin, err := gitinst.GetOriginUrl(targetDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if NormalizeOrigin(curOrigin) != NormalizeOrigin(origin) {
+			freportf(logWriter, "Repository %q tracks %q instead of %q, cloning again", targetDir, curOrigin, origin)
+			if err := os.RemoveAll(paths.LongPath(targetDir)); err != nil {
+				return errors.Trace(err)
+			}
+			return prepareLocalCopyGit(origin, version, targetDir, logWriter, deleteIfFailed, pullInterval, cloneDepth)
+		}
 	}
 
 	// Now we know that the repo is either clean or non-existing, so, if asked to
@@ -326,8 +419,8 @@ func prepareLocalCopyGit(
 				}
 				for _, f := range files {
 					glog.V(2).Infof("removing %q", f.Name())
-					p := path.Join(targetDir, f.Name())
-					if err := os.RemoveAll(p); err != nil {
+					p := filepath.Join(targetDir, f.Name())
+					if err := os.RemoveAll(paths.LongPath(p)); err != nil {
 						glog.Errorf("failed to remove %q: %s", p, err)
 						return
 					}
@@ -466,10 +559,95 @@ func prepareLocalCopyGit(
 	return nil
 }
 
+// prepareLocalCopyArchive downloads and extracts the archive to targetDir,
+// unless it's already extracted there. If digest is given, the archive must
+// match it.
+func prepareLocalCopyArchive(location, digest, targetDir string, logWriter io.Writer) error {
+	digestFile := filepath.Join(targetDir, ArchiveDigestFile)
+	if data, err := ioutil.ReadFile(digestFile); err == nil {
+		have := strings.TrimSpace(string(data))
+		if digest == "" || strings.EqualFold(have, digest) {
+			freportf(logWriter, "%q is already extracted to %q", location, targetDir)
+			return nil
+		}
+		freportf(logWriter, "%q has a different digest, fetching again", targetDir)
+	}
+
+	freportf(logWriter, "Downloading %q...", location)
+	data, err := download.Get(location)
+	if err != nil {
+		return errors.Annotatef(err, "failed to download %q", location)
+	}
+	sum := sha256.Sum256(data)
+	have := hex.EncodeToString(sum[:])
+	if digest != "" && !strings.EqualFold(have, digest) {
+		return errors.Errorf("%q: digest mismatch: expected %s, got %s", location, digest, have)
+	}
+
+	// Archives of SDKs are often nested deep enough to exceed MAX_PATH
+	longDir := paths.LongPath(targetDir)
+	if err := os.RemoveAll(longDir); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(longDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	if err := archive.Extract(data, location, longDir); err != nil {
+		os.RemoveAll(longDir)
+		return errors.Annotatef(err, "failed to extract %q", location)
+	}
+	return errors.Trace(ioutil.WriteFile(digestFile, []byte(have+"\n"), 0644))
+}
+
 // getGitDirName returns given name with the appropriate version suffix
 // (see moscommon.GetVersionSuffix(repoVersion))
+//
+// For git modules from forks (i.e. not from one of the upstream orgs), the
+// name is also suffixed with the hash of the origin, so that projects using
+// different forks of the same repo don't share, and thrash, the checkout.
 func (m *SWModule) getGitDirName(name, repoVersion string) string {
-	return fmt.Sprint(name, moscommon.GetVersionSuffixTpl(repoVersion, m.SuffixTpl))
+	return fmt.Sprint(name, moscommon.GetVersionSuffixTpl(repoVersion, m.SuffixTpl), originSuffix(m.Location))
+}
+
+// Orgs whose repos are checked out without the origin suffix, so that
+// existing checkouts of upstream libs and modules keep their dirs.
+var upstreamOrigins = []string{
+	"github.com/cesanta/",
+	"github.com/mongoose-os-libs/",
+	"github.com/mongoose-os-apps/",
+}
+
+// NormalizeOrigin returns the repo location in the form which doesn't depend
+// on the protocol, e.g. both "https://github.com/foo/bar.git" and
+// "git@github.com:foo/bar" become "github.com/foo/bar".
+func NormalizeOrigin(location string) string {
+	s := strings.TrimSpace(location)
+	if u, err := url.Parse(s); err == nil && u.Scheme != "" && u.Host != "" {
+		s = strings.ToLower(u.Hostname()) + u.Path
+	} else if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s[:i], "/") {
+		// scp-like syntax: [user@]host:path
+		host := s[:i]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+		s = strings.ToLower(host) + "/" + strings.TrimPrefix(s[i+1:], "/")
+	}
+	s = strings.TrimSuffix(s, "/")
+	return strings.TrimSuffix(s, ".git")
+}
+
+func originSuffix(location string) string {
+	if location == "" {
+		return ""
+	}
+	origin := NormalizeOrigin(location)
+	for _, o := range upstreamOrigins {
+		if strings.HasPrefix(origin, o) {
+			return ""
+		}
+	}
+	sum := sha1.Sum([]byte(origin))
+	return "-" + hex.EncodeToString(sum[:4])
 }
 
 func freportf(logFile io.Writer, f string, args ...interface{}) {
@@ -0,0 +1,172 @@
+// Package tsmjs type-checks and transpiles TypeScript sources of the app, in
+// the fs_src_ts dir, to JS which mJS can run, put on the device filesystem.
+//
+// tsc emits ES5, since mJS has no arrow functions or classes; the output is
+// then adapted to mJS, which only has let: var and const become let, and
+// "use strict" is dropped. The rest of the language must stay within the
+// mJS subset, which tsc doesn't check. Declarations of the mJS builtins are
+// provided; APIs of loaded files, like Sys or Timer, need declarations in
+// the app's .d.ts files.
+package tsmjs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SourceDir is the dir of the TypeScript sources, relative to the app dir.
+const SourceDir = "fs_src_ts"
+
+// DeclarationsName is the name of the file with Declarations.
+const DeclarationsName = "mjs.d.ts"
+
+// Declarations of the mJS builtins.
+const Declarations = `/* Generated by mos, do not edit. */
+declare function load(file: string): any;
+declare function print(...args: any[]): void;
+declare function ffi(signature: string): any;
+declare function ffi_cb_free(fn: any, userdata: any): number;
+declare function die(message: string): void;
+declare function gc(full?: boolean): void;
+declare function chr(code: number): string | null;
+declare function mkstr(ptr: any, offset: number, len?: number, copy?: boolean): string;
+declare function isNaN(x: any): boolean;
+`
+
+// Default versions of the tools, which are pinned in mos.lock on first use.
+const (
+	// TypeScriptVersion is the version of the typescript package.
+	TypeScriptVersion = "5.4.5"
+	// Image is the container image with node to run tsc in.
+	Image = "node:20.12.2-alpine3.19"
+)
+
+// Script returns the shell script which type-checks the files with tsc of
+// the given version of the typescript package, fetched by npx unless
+// installed, and writes the JS to outDir.
+func Script(files []string, rootDir, outDir, version string) string {
+	args := []string{
+		"npx", "--yes", "-p", quote("typescript@" + version), "tsc",
+		"--target", "ES5", "--lib", "ES5", "--strict", "--alwaysStrict", "false",
+		"--noEmitOnError", "--pretty", "false", "--removeComments",
+		"--rootDir", quote(rootDir), "--outDir", quote(outDir),
+	}
+	for _, f := range files {
+		args = append(args, quote(f))
+	}
+	// npm needs a writable home for its cache
+	return "export HOME=${HOME:-/tmp}\n[ -w \"$HOME\" ] || export HOME=/tmp\nexec " + strings.Join(args, " ") + "\n"
+}
+
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Diagnostic is an error reported by tsc.
+type Diagnostic struct {
+	File      string
+	Line, Col int
+	// Code, like "TS2322"
+	Code    string
+	Message string
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: error %s: %s", d.File, d.Line, d.Col, d.Code, d.Message)
+}
+
+var diagRE = regexp.MustCompile(`^(.+)\((\d+),(\d+)\): error (TS\d+): (.*)$`)
+
+// ParseDiagnostics returns the errors from the tsc output. Continuation
+// lines of messages are appended to them.
+func ParseDiagnostics(out []byte) []*Diagnostic {
+	var res []*Diagnostic
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := diagRE.FindStringSubmatch(line); m != nil {
+			l, _ := strconv.Atoi(m[2])
+			c, _ := strconv.Atoi(m[3])
+			res = append(res, &Diagnostic{File: m[1], Line: l, Col: c, Code: m[4], Message: m[5]})
+		} else if len(res) > 0 && strings.HasPrefix(line, " ") && strings.TrimSpace(line) != "" {
+			d := res[len(res)-1]
+			d.Message += " " + strings.TrimSpace(line)
+		}
+	}
+	return res
+}
+
+// ToMJS adapts the tsc output to mJS: var and const declarations become let,
+// "use strict" and the empty export marker are dropped. Strings, template
+// literals and comments are left intact.
+func ToMJS(js []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(js); {
+		c := js[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(js) && js[j] != c {
+				if js[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(js) {
+				j++
+			}
+			out.Write(js[i:j])
+			i = j
+		case c == '/' && i+1 < len(js) && js[i+1] == '/':
+			j := bytes.IndexByte(js[i:], '\n')
+			if j < 0 {
+				j = len(js) - i
+			}
+			out.Write(js[i : i+j])
+			i += j
+		case c == '/' && i+1 < len(js) && js[i+1] == '*':
+			j := bytes.Index(js[i+2:], []byte("*/"))
+			if j < 0 {
+				j = len(js) - i - 4
+			}
+			out.Write(js[i : i+j+4])
+			i += j + 4
+		case isIdentByte(c):
+			j := i
+			for j < len(js) && isIdentByte(js[j]) {
+				j++
+			}
+			word := string(js[i:j])
+			// Property names, like a.var or {var: 1}, are not keywords
+			next := bytes.TrimLeft(js[j:], " \t")
+			if (word == "var" || word == "const") && (i == 0 || js[i-1] != '.') &&
+				(len(next) == 0 || next[0] != ':') {
+				word = "let"
+			}
+			out.WriteString(word)
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	var res bytes.Buffer
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		switch strings.TrimSpace(scanner.Text()) {
+		case `"use strict";`, `'use strict';`, "export {};":
+			continue
+		}
+		res.WriteString(scanner.Text())
+		res.WriteByte('\n')
+	}
+	return res.Bytes()
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
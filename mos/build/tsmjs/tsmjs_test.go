@@ -0,0 +1,51 @@
+package tsmjs
+
+import (
+	"testing"
+)
+
+func TestToMJS(t *testing.T) {
+	js := `"use strict";
+var led = ffi('int get_led_gpio_pin()')();
+const msg = "var const"; // var in a comment
+/* const */ var obj = { var: 1, n: 'it\'s const' };
+print(obj.var, msg, varName);
+export {};
+`
+	exp := `let led = ffi('int get_led_gpio_pin()')();
+let msg = "var const"; // var in a comment
+/* const */ let obj = { var: 1, n: 'it\'s const' };
+print(obj.var, msg, varName);
+`
+	if res := string(ToMJS([]byte(js))); res != exp {
+		t.Errorf("unexpected result:\n%s", res)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	out := `fs_src_ts/init.ts(3,7): error TS2322: Type 'string' is not assignable to type 'number'.
+fs_src_ts/util.ts(10,1): error TS2345: Argument of type '{}' is not assignable to parameter of type 'Opts'.
+  Property 'pin' is missing in type '{}'.
+`
+	ds := ParseDiagnostics([]byte(out))
+	if len(ds) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %d", len(ds))
+	}
+	if s := ds[0].String(); s != "fs_src_ts/init.ts:3:7: error TS2322: Type 'string' is not assignable to type 'number'." {
+		t.Errorf("unexpected diagnostic %q", s)
+	}
+	if ds[1].Line != 10 || ds[1].Message != "Argument of type '{}' is not assignable to parameter of type 'Opts'. Property 'pin' is missing in type '{}'." {
+		t.Errorf("unexpected diagnostic %+v", ds[1])
+	}
+}
+
+func TestScript(t *testing.T) {
+	exp := `export HOME=${HOME:-/tmp}
+[ -w "$HOME" ] || export HOME=/tmp
+exec npx --yes -p 'typescript@5.4.5' tsc --target ES5 --lib ES5 --strict --alwaysStrict false --noEmitOnError --pretty false --removeComments --rootDir '/app/fs_src_ts' --outDir '/app/build/gen/ts/js' '/app/fs_src_ts/init.ts' '/app/build/gen/ts/mjs.d.ts'
+`
+	s := Script([]string{"/app/fs_src_ts/init.ts", "/app/build/gen/ts/mjs.d.ts"}, "/app/fs_src_ts", "/app/build/gen/ts/js", "5.4.5")
+	if s != exp {
+		t.Errorf("unexpected script:\n%s", s)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cesanta.com/mos/build/buildinfo"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	buildInfoFlag = flag.StringSlice("build-info", nil, "key=value to add to the custom build info embedded into the firmware, overrides build_info in mos.yml; can be used multiple times")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "build-info")
+}
+
+// readProjectBuildInfo reads the custom build info from the manifest in the
+// project dir, if any.
+func readProjectBuildInfo() (map[string]string, error) {
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return manifest.BuildInfo, nil
+}
+
+// getBuildInfo returns the custom build info of the project, from the
+// manifest and --build-info, with references like ${git.describe} expanded,
+// or nil if there is none.
+func getBuildInfo() (map[string]string, error) {
+	info, err := readProjectBuildInfo()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, kv := range *buildInfoFlag {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid --build-info %q, expected key=value", kv)
+		}
+		if info == nil {
+			info = map[string]string{}
+		}
+		info[parts[0]] = parts[1]
+	}
+	if len(info) == 0 {
+		return nil, nil
+	}
+	return buildinfo.Expand(info, lookupBuildInfoVar)
+}
+
+// lookupBuildInfoVar returns values of the variables build info can refer
+// to: ${env.NAME}, ${git.describe}, ${git.sha}, ${git.branch} and
+// ${build.timestamp}.
+func lookupBuildInfoVar(name string) (string, error) {
+	if strings.HasPrefix(name, "env.") {
+		return os.Getenv(strings.TrimPrefix(name, "env.")), nil
+	}
+	var args []string
+	switch name {
+	case "git.describe":
+		args = []string{"describe", "--tags", "--always", "--dirty"}
+	case "git.sha":
+		args = []string{"rev-parse", "HEAD"}
+	case "git.branch":
+		args = []string{"rev-parse", "--abbrev-ref", "HEAD"}
+	case "build.timestamp":
+		return time.Now().UTC().Format(time.RFC3339), nil
+	default:
+		return "", errors.Errorf("unknown variable %q", name)
+	}
+	out, err := getCommandOutput("git", append([]string{"-C", projectDir}, args...)...)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// fwInfo implements "mos fw info [fw.zip|device]": it prints the firmware
+// metadata and the custom build info, read from the fw bundle, or from the
+// device at --port.
+func fwInfo(ctx context.Context, args []string) error {
+	fwFile := *firmware
+	switch len(args) {
+	case 0:
+	case 1:
+		fwFile = args[0]
+	default:
+		return errors.Errorf("usage: mos fw info [fw.zip|device]")
+	}
+
+	if fwFile == "device" {
+		devConn, err := createDevConn(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer devConn.Disconnect(ctx)
+		res, err := callDeviceService(ctx, devConn, buildinfo.RPCMethod, "")
+		if err != nil {
+			return errors.Annotatef(err, "failed to get the build info; is build_info_custom_init called by the app?")
+		}
+		fmt.Println(res)
+		return nil
+	}
+
+	fw, err := common.NewZipFirmwareBundle(fwFile)
+	if err != nil {
+		return errors.Annotatef(err, "failed to load %s", fwFile)
+	}
+	defer fw.Cleanup()
+	fmt.Printf("Name: %s\nPlatform: %s\nVersion: %s\nBuild ID: %s\n", fw.Name, fw.Platform, fw.Version, fw.BuildID)
+
+	var names []string
+	for name, p := range fw.Parts {
+		if p.Src != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := fw.GetPartData(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		info, err := buildinfo.Find(data)
+		if err != nil {
+			return errors.Annotatef(err, "%s", name)
+		}
+		if info == nil {
+			continue
+		}
+		var keys []string
+		for k := range info {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Printf("Build info (%s):\n", name)
+		for _, k := range keys {
+			fmt.Printf("  %s: %s\n", k, info[k])
+		}
+		return nil
+	}
+	fmt.Printf("No custom build info\n")
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/mosgit"
+	"cesanta.com/mos/provenance"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	provenanceKey    = flag.String("provenance-key", "", "mos build: PEM-encoded ECDSA private key file to sign the build provenance with")
+	provenancePubKey = flag.String("provenance-pubkey", "", "mos fw verify-provenance: PEM-encoded ECDSA public key file the provenance must be signed with")
+	provenanceFile   = flag.String("provenance", "", "mos fw verify-provenance: provenance file; by default, the one next to the fw zip")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "provenance-key", "provenance-pubkey", "provenance")
+}
+
+// getProvenanceFilePath returns the provenance file of the given fw zip:
+// build/fw.zip -> build/fw.provenance.json.
+func getProvenanceFilePath(fwFilename string) string {
+	return strings.TrimSuffix(fwFilename, ".zip") + ".provenance.json"
+}
+
+// writeBuildProvenance writes the provenance of the fw zip, signed if
+// --provenance-key is given. For remote builds, only the app sources are
+// known, so the materials are incomplete; so are they if the app has
+// uncommitted changes, which is also recorded as the source_dirty parameter.
+func writeBuildProvenance(bParams *buildParams, fwFilename, buildDir string, start time.Time) error {
+	fwData, err := ioutil.ReadFile(fwFilename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	st := provenance.New("fw.zip", fwData)
+	pred := &st.Predicate
+	pred.Metadata.BuildStartedOn = start.UTC()
+	pred.Metadata.BuildFinishedOn = time.Now().UTC()
+	pred.Metadata.Completeness.Materials = *local
+	pred.Invocation.Parameters = map[string]string{
+		"platform":    bParams.Platform,
+		"mos_version": version.GetMosVersion(),
+	}
+	if !*local {
+		pred.Invocation.Parameters["remote_build"] = "true"
+	}
+
+	if *local {
+		user, host := "unknown", "unknown"
+		if u, err := osuser.Current(); err == nil {
+			user = u.Username
+		}
+		if h, err := os.Hostname(); err == nil {
+			host = h
+		}
+		pred.Builder.ID = provenance.BuilderID(os.Getenv, user, host)
+	} else {
+		pred.Builder.ID = *server
+	}
+
+	gitinst := mosgit.NewOurGit()
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if top, _ := gitinst.GetToplevelDir(appDir); top != "" {
+		origin, _ := gitinst.GetOriginUrl(top)
+		if origin == "" {
+			origin = "file://" + top
+		}
+		if rev, err := gitinst.GetCurrentHash(top); err == nil {
+			pred.Materials = append(pred.Materials, provenance.GitMaterial(origin, rev))
+		} else {
+			// E.g. a new repo without commits yet
+			freportf(logWriter, "Warning: can't get the git revision of the app (%s), the provenance has no source revision", err)
+		}
+		// Uncommitted changes are not described by the revision
+		if out, err := releaseGit("status", "--porcelain"); err != nil || out != "" {
+			pred.Invocation.Parameters["source_dirty"] = "true"
+			pred.Metadata.Completeness.Materials = false
+		}
+	} else {
+		freportf(logWriter, "Warning: the app is not in a git repo, the provenance has no source revision")
+	}
+
+	for _, f := range []string{moscommon.GetManifestFilePath(projectDir), moscommon.GetLockFilePath(projectDir)} {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.Trace(err)
+		}
+		pred.Materials = append(pred.Materials, provenance.Material{
+			URI:    "file:" + filepath.Base(f),
+			Digest: map[string]string{"sha256": provenance.SHA256(data)},
+		})
+	}
+
+	if *local {
+		depsData, err := ioutil.ReadFile(moscommon.GetBuildDepsFilePath(buildDir))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var deps []moscommon.BuildDep
+		if err := json.Unmarshal(depsData, &deps); err != nil {
+			return errors.Trace(err)
+		}
+		for _, d := range deps {
+			if d.Revision == "" {
+				pred.Materials = append(pred.Materials, provenance.Material{URI: "mos-" + d.Kind + ":" + d.Name})
+				continue
+			}
+			origin, _ := gitinst.GetOriginUrl(d.Dir)
+			if origin == "" {
+				origin = "mos-" + d.Kind + ":" + d.Name
+			}
+			pred.Materials = append(pred.Materials, provenance.GitMaterial(origin, d.Revision))
+		}
+		if image := bParams.BuildImage; image != "" {
+			// Tags can be moved, the digest identifies the image
+			if !strings.Contains(image, "@sha256:") {
+				if ref, err := getImageDigestRef(image); err != nil {
+					freportf(logWriter, "Warning: %s, the provenance has no digest of the build image", err)
+				} else if ref != "" {
+					image = ref
+				}
+			}
+			pred.Materials = append(pred.Materials, provenance.ImageMaterial(image))
+		}
+	}
+
+	var key []byte
+	if *provenanceKey != "" {
+		if key, err = ioutil.ReadFile(*provenanceKey); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	data, err := provenance.Seal(st, key)
+	if err != nil {
+		return errors.Annotatef(err, "failed to sign the provenance")
+	}
+	return errors.Trace(ioutil.WriteFile(getProvenanceFilePath(fwFilename), data, 0644))
+}
+
+// fwVerifyProvenance implements "mos fw verify-provenance [fw.zip]".
+func fwVerifyProvenance(args []string) error {
+	fwFile := *firmware
+	switch len(args) {
+	case 0:
+	case 1:
+		fwFile = args[0]
+	default:
+		return errors.Errorf("usage: mos fw verify-provenance --provenance-pubkey key.pem [--provenance file] [fw.zip]")
+	}
+	if *provenancePubKey == "" {
+		return errors.Errorf("--provenance-pubkey is required")
+	}
+	pubKey, err := ioutil.ReadFile(*provenancePubKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	provFile := *provenanceFile
+	if provFile == "" {
+		provFile = getProvenanceFilePath(fwFile)
+	}
+	provData, err := ioutil.ReadFile(provFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fwData, err := ioutil.ReadFile(fwFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	st, err := provenance.Open(provData, string(pubKey))
+	if err != nil {
+		return errors.Annotatef(err, "%s", provFile)
+	}
+	if err := st.CheckSubject(fwData); err != nil {
+		return errors.Annotatef(err, "%s", fwFile)
+	}
+
+	pred := &st.Predicate
+	ourutil.Reportf("Built by %s at %s", pred.Builder.ID, pred.Metadata.BuildFinishedOn.Format(time.RFC3339))
+	for _, m := range pred.Materials {
+		var digests []string
+		for alg, d := range m.Digest {
+			digests = append(digests, alg+":"+d)
+		}
+		ourutil.Reportf("  %s %s", m.URI, strings.Join(digests, " "))
+	}
+	if pred.Invocation.Parameters["remote_build"] == "true" {
+		ourutil.Reportf("Note: remote build, libs and build images are not listed")
+	}
+	if pred.Invocation.Parameters["source_dirty"] == "true" {
+		ourutil.Reportf("Note: the app had uncommitted changes, they are not described by the revision")
+	}
+	ourutil.Reportf("%s: provenance OK", fwFile)
+	return nil
+}
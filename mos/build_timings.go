@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/buildtimings"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+const buildTimingsDir = "~/.mos/build_timings"
+
+var (
+	buildTimings       = flag.Bool("timings", false, "mos build: record the durations of the local build phases and print them along with the trend")
+	buildTimingsWindow = flag.Int("timings-window", 10, "mos build --timings, mos build-timings: how many previous builds to compare the last one against")
+	buildTimingsLast   = flag.Int("timings-history", 20, "mos build-timings: how many last builds to list")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "timings", "timings-window", "timings-history")
+}
+
+// getBuildTimingsFile returns the file with the build timings history of the
+// current project. It's outside of the project, so that it survives clean
+// builds and is never uploaded.
+func getBuildTimingsFile() (string, error) {
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	dir, err := paths.NormalizePath(buildTimingsDir, "")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return filepath.Join(dir, buildtimings.FileName(appDir)), nil
+}
+
+// saveBuildTimings adds the build to the history and prints its timings
+// along with the trend.
+func saveBuildTimings(r *buildtimings.Record) error {
+	fname, err := getBuildTimingsFile()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	recs, err := buildtimings.Append(fname, r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if buildtimings.PrintTrends(os.Stderr, recs, *buildTimingsWindow) {
+		ourutil.Reportf("The build got slower, see \"mos build-timings\" for the history")
+	}
+	return nil
+}
+
+func buildTimingsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	fname, err := getBuildTimingsFile()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	recs, err := buildtimings.Load(fname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(recs) == 0 {
+		ourutil.Reportf("No build timings recorded for this project yet, build it with \"mos build --local --timings\"")
+		return nil
+	}
+	buildtimings.PrintHistory(os.Stdout, recs, *buildTimingsLast)
+	buildtimings.PrintTrends(os.Stdout, recs, *buildTimingsWindow)
+	return nil
+}
@@ -0,0 +1,333 @@
+// Package buildtimings records how long the phases of local builds take
+// (fetching deps, compiling, linking, packing the firmware) and keeps the
+// history per project, so that build time regressions can be spotted. The
+// history is only stored on the local machine.
+package buildtimings
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+type Phase string
+
+const (
+	PhaseFetch   Phase = "fetch"
+	PhaseCompile Phase = "compile"
+	PhaseLink    Phase = "link"
+	PhasePack    Phase = "pack"
+
+	// The whole build, only used in trends.
+	PhaseTotal Phase = "total"
+)
+
+// Phases in the order they happen.
+var Phases = []Phase{PhaseFetch, PhaseCompile, PhaseLink, PhasePack}
+
+const (
+	// MaxRecords is how many builds are kept in the history of a project.
+	MaxRecords = 200
+
+	// A phase is reported as regressed if it's slower than the median of the
+	// previous builds by more than RegressionRatio and by at least
+	// RegressionMin; the latter keeps short phases from being noisy.
+	RegressionRatio = 0.2
+	RegressionMin   = time.Second
+)
+
+// Make prints the tool name first on each step, like "  LD    fw.elf". Phases
+// are only moved forward: with parallel make, a late compile line doesn't
+// take the build back from linking.
+var makeStepPhases = map[string]Phase{
+	"LD":  PhaseLink,
+	"FW":  PhasePack,
+	"ZIP": PhasePack,
+}
+
+// Record is a single build.
+type Record struct {
+	Time     time.Time       `json:"time"`
+	Platform string          `json:"platform,omitempty"`
+	TotalMS  int64           `json:"total_ms"`
+	PhasesMS map[Phase]int64 `json:"phases_ms"`
+}
+
+func (r *Record) Phase(p Phase) time.Duration {
+	return time.Duration(r.PhasesMS[p]) * time.Millisecond
+}
+
+func (r *Record) Total() time.Duration {
+	return time.Duration(r.TotalMS) * time.Millisecond
+}
+
+// Tracker measures phases of a build. It's also an io.Writer which make
+// output is written to, and which detects the link and pack phases.
+type Tracker struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	start      time.Time
+	cur        Phase
+	curStart   time.Time
+	durations  map[Phase]time.Duration
+	lineBuf    []byte
+	phaseIndex map[Phase]int
+}
+
+func NewTracker() *Tracker {
+	return newTracker(time.Now)
+}
+
+func newTracker(now func() time.Time) *Tracker {
+	t := &Tracker{
+		now:        now,
+		durations:  map[Phase]time.Duration{},
+		phaseIndex: map[Phase]int{},
+	}
+	for i, p := range Phases {
+		t.phaseIndex[p] = i
+	}
+	return t
+}
+
+// Start ends the current phase, if any, and starts the given one.
+func (t *Tracker) Start(p Phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.startLocked(p)
+}
+
+func (t *Tracker) startLocked(p Phase) {
+	now := t.now()
+	if t.start.IsZero() {
+		t.start = now
+	}
+	if t.cur != "" {
+		t.durations[t.cur] += now.Sub(t.curStart)
+	}
+	t.cur = p
+	t.curStart = now
+}
+
+func (t *Tracker) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lineBuf = append(t.lineBuf, data...)
+	for {
+		i := bytes.IndexByte(t.lineBuf, '\n')
+		if i < 0 {
+			break
+		}
+		t.handleLineLocked(string(t.lineBuf[:i]))
+		t.lineBuf = t.lineBuf[i+1:]
+	}
+	return len(data), nil
+}
+
+func (t *Tracker) handleLineLocked(line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return
+	}
+	p, ok := makeStepPhases[fields[0]]
+	if !ok || t.cur == "" || t.phaseIndex[p] <= t.phaseIndex[t.cur] {
+		return
+	}
+	t.startLocked(p)
+}
+
+// Finish ends the current phase and returns the record of the build.
+func (t *Tracker) Finish(platform string) *Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.cur != "" {
+		t.durations[t.cur] += now.Sub(t.curStart)
+		t.cur = ""
+	}
+	r := &Record{
+		Time:     now,
+		Platform: platform,
+		TotalMS:  int64(now.Sub(t.start) / time.Millisecond),
+		PhasesMS: map[Phase]int64{},
+	}
+	for p, d := range t.durations {
+		r.PhasesMS[p] = int64(d / time.Millisecond)
+	}
+	return r
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// FileName returns the name of the history file of the project in the given
+// dir: the dir name for readability, and the hash of the full path so that
+// projects with the same name don't mix.
+func FileName(appDir string) string {
+	h := sha1.Sum([]byte(filepath.Clean(appDir)))
+	name := unsafeChars.ReplaceAllString(filepath.Base(appDir), "_")
+	return fmt.Sprintf("%s-%x.json", name, h[:4])
+}
+
+// Load reads the history from the given file; a missing file is an empty
+// history.
+func Load(fname string) ([]Record, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	var recs []Record
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", fname)
+	}
+	return recs, nil
+}
+
+// Append adds the record to the history in the given file, dropping the
+// oldest ones beyond MaxRecords, and returns the resulting history.
+func Append(fname string, r *Record) ([]Record, error) {
+	recs, err := Load(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	recs = append(recs, *r)
+	if len(recs) > MaxRecords {
+		recs = recs[len(recs)-MaxRecords:]
+	}
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return recs, nil
+}
+
+// PhaseTrend compares the phase of the last build with the median of the
+// previous ones.
+type PhaseTrend struct {
+	Phase Phase
+	Last  time.Duration
+	// Median of the previous builds, and their number; 0 if there are none.
+	Median     time.Duration
+	NumPrev    int
+	Regression bool
+}
+
+// Trends compares the last record of the history with up to window
+// previous builds for the same platform, phase by phase and then in total.
+func Trends(recs []Record, window int) []PhaseTrend {
+	if len(recs) == 0 {
+		return nil
+	}
+	last := recs[len(recs)-1]
+	var prev []Record
+	for i := len(recs) - 2; i >= 0 && len(prev) < window; i-- {
+		if recs[i].Platform == last.Platform {
+			prev = append(prev, recs[i])
+		}
+	}
+	var ret []PhaseTrend
+	for _, p := range append(append([]Phase{}, Phases...), PhaseTotal) {
+		get := func(r *Record) time.Duration {
+			if p == PhaseTotal {
+				return r.Total()
+			}
+			return r.Phase(p)
+		}
+		pt := PhaseTrend{Phase: p, Last: get(&last), NumPrev: len(prev)}
+		if len(prev) > 0 {
+			var ds []time.Duration
+			for i := range prev {
+				ds = append(ds, get(&prev[i]))
+			}
+			pt.Median = median(ds)
+			diff := pt.Last - pt.Median
+			pt.Regression = diff >= RegressionMin && float64(diff) > float64(pt.Median)*RegressionRatio
+		}
+		ret = append(ret, pt)
+	}
+	return ret
+}
+
+func median(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	n := len(ds)
+	if n%2 == 1 {
+		return ds[n/2]
+	}
+	return (ds[n/2-1] + ds[n/2]) / 2
+}
+
+// PrintTrends prints the phases of the last build along with their change
+// against the median of the previous ones, and returns whether any of them
+// regressed.
+func PrintTrends(w io.Writer, recs []Record, window int) bool {
+	trends := Trends(recs, window)
+	if len(trends) == 0 {
+		fmt.Fprintf(w, "No build timings recorded yet\n")
+		return false
+	}
+	last := recs[len(recs)-1]
+	fmt.Fprintf(w, "Build timings (%s, %s):\n", last.Platform, last.Time.Local().Format("2006-01-02 15:04"))
+	regressed := false
+	for _, pt := range trends {
+		fmt.Fprintf(w, "  %-8s %9s", pt.Phase, fmtDuration(pt.Last))
+		if pt.NumPrev > 0 {
+			change := "    n/a"
+			if pt.Median > 0 {
+				change = fmt.Sprintf("%+6.0f%%", 100*float64(pt.Last-pt.Median)/float64(pt.Median))
+			}
+			fmt.Fprintf(w, "   median of %d: %9s %s", pt.NumPrev, fmtDuration(pt.Median), change)
+		}
+		if pt.Regression {
+			fmt.Fprintf(w, "  SLOWER")
+			regressed = true
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	return regressed
+}
+
+// PrintHistory prints up to n last builds, one per line.
+func PrintHistory(w io.Writer, recs []Record, n int) {
+	if len(recs) > n {
+		recs = recs[len(recs)-n:]
+	}
+	fmt.Fprintf(w, "%-16s  %-10s", "TIME", "PLATFORM")
+	for _, p := range Phases {
+		fmt.Fprintf(w, " %9s", p)
+	}
+	fmt.Fprintf(w, " %9s\n", PhaseTotal)
+	for i := range recs {
+		r := &recs[i]
+		fmt.Fprintf(w, "%-16s  %-10s", r.Time.Local().Format("2006-01-02 15:04"), r.Platform)
+		for _, p := range Phases {
+			fmt.Fprintf(w, " %9s", fmtDuration(r.Phase(p)))
+		}
+		fmt.Fprintf(w, " %9s\n", fmtDuration(r.Total()))
+	}
+}
+
+func fmtDuration(d time.Duration) string {
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,109 @@
+package buildtimings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := newTracker(func() time.Time { return now })
+	step := func(d time.Duration) { now = now.Add(d) }
+
+	tr.Start(PhaseFetch)
+	step(2 * time.Second)
+	tr.Start(PhaseCompile)
+	step(10 * time.Second)
+	tr.Write([]byte("  CC    main.c\n  LD  "))
+	step(time.Second)
+	// The line is only complete now
+	tr.Write([]byte("  fw.elf\n"))
+	step(3 * time.Second)
+	// Late compile lines don't move the phase back
+	tr.Write([]byte("  CC    late.c\n  FW    fw.zip\n"))
+	step(time.Second)
+	r := tr.Finish("esp32")
+
+	exp := map[Phase]time.Duration{
+		PhaseFetch:   2 * time.Second,
+		PhaseCompile: 11 * time.Second,
+		PhaseLink:    3 * time.Second,
+		PhasePack:    time.Second,
+	}
+	for p, d := range exp {
+		if r.Phase(p) != d {
+			t.Errorf("%s: expected %s, got %s", p, d, r.Phase(p))
+		}
+	}
+	if r.Total() != 17*time.Second || r.Platform != "esp32" {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	rec := func(platform string, compile int) Record {
+		return Record{
+			Platform: platform,
+			TotalMS:  int64(compile+1) * 1000,
+			PhasesMS: map[Phase]int64{PhaseFetch: 1000, PhaseCompile: int64(compile) * 1000},
+		}
+	}
+	recs := []Record{
+		rec("esp32", 10), rec("esp32", 12), rec("esp8266", 100), rec("esp32", 11), rec("esp32", 15),
+	}
+	trends := Trends(recs, 10)
+	for _, pt := range trends {
+		switch pt.Phase {
+		case PhaseCompile:
+			if pt.NumPrev != 3 || pt.Median != 11*time.Second || !pt.Regression {
+				t.Errorf("unexpected compile trend %+v", pt)
+			}
+		case PhaseFetch:
+			if pt.Regression {
+				t.Errorf("unexpected fetch trend %+v", pt)
+			}
+		}
+	}
+
+	// Within the ratio
+	recs[len(recs)-1] = rec("esp32", 12)
+	for _, pt := range Trends(recs, 10) {
+		if pt.Regression {
+			t.Errorf("unexpected regression %+v", pt)
+		}
+	}
+
+	// Window limits the previous builds
+	if pt := Trends(recs, 1)[0]; pt.NumPrev != 1 {
+		t.Errorf("unexpected trend %+v", pt)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildtimings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "sub", FileName("/foo/my app"))
+
+	for i := 0; i < MaxRecords+5; i++ {
+		if _, err := Append(fname, &Record{TotalMS: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recs, err := Load(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != MaxRecords || recs[0].TotalMS != 5 {
+		t.Errorf("unexpected history: %d records, first %+v", len(recs), recs[0])
+	}
+
+	if FileName("/foo/my app") == FileName("/bar/my app") {
+		t.Errorf("projects with the same name should have different files")
+	}
+}
@@ -0,0 +1,84 @@
+// Package buildvars implements layering of build variables. From the lowest
+// precedence to the highest:
+//
+//   - defaults in libs, in the order of dependencies;
+//   - the app manifest;
+//   - the board profile (see mos boards);
+//   - the environment: MOS_BV_<NAME>=value;
+//   - --build-var flags.
+//
+// Manifests are merged by the manifest parser; this package handles the
+// layers given outside of manifests, which override them all.
+package buildvars
+
+import (
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// EnvPrefix is the prefix of environment variables which set build vars.
+const EnvPrefix = "MOS_BV_"
+
+// Layer is a set of build vars from a single source.
+type Layer struct {
+	Name string
+	Vars map[string]string
+}
+
+// FromEnv returns build vars set in the environment, given as returned by
+// os.Environ().
+func FromEnv(environ []string) map[string]string {
+	vars := map[string]string{}
+	for _, e := range environ {
+		if !strings.HasPrefix(e, EnvPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(e, EnvPrefix), "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
+
+// ParseSpecs parses build vars given as "NAME:VALUE" or "NAME=VALUE"; if both
+// separators are present, the first one wins.
+func ParseSpecs(specs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, v := range specs {
+		pp1 := strings.SplitN(v, ":", 2)
+		pp2 := strings.SplitN(v, "=", 2)
+		var pp []string
+		switch {
+		case len(pp1) == 2 && len(pp2) == 1:
+			pp = pp1
+		case len(pp1) == 1 && len(pp2) == 2:
+			pp = pp2
+		case len(pp1) == 2 && len(pp2) == 2:
+			if len(pp1[0]) < len(pp2[0]) {
+				pp = pp1
+			} else {
+				pp = pp2
+			}
+		default:
+			return nil, errors.Errorf("invalid --build-var spec: %q", v)
+		}
+		vars[pp[0]] = pp[1]
+	}
+	return vars, nil
+}
+
+// Merge merges the layers, later ones take precedence. Returns the values and
+// the names of the layers they come from.
+func Merge(layers ...Layer) (map[string]string, map[string]string) {
+	vars := map[string]string{}
+	sources := map[string]string{}
+	for _, l := range layers {
+		for k, v := range l.Vars {
+			vars[k] = v
+			sources[k] = l.Name
+		}
+	}
+	return vars, sources
+}
@@ -0,0 +1,35 @@
+package buildvars
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	env := FromEnv([]string{"HOME=/root", "MOS_BV_FOO=env", "MOS_BV_BAR=a=b", "MOS_BV_=x"})
+	if exp := map[string]string{"FOO": "env", "BAR": "a=b"}; !reflect.DeepEqual(env, exp) {
+		t.Errorf("expected %v, got %v", exp, env)
+	}
+
+	flags, err := ParseSpecs([]string{"FOO:flag", "URL=http://x", "OPTS:A=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"FOO": "flag", "URL": "http://x", "OPTS": "A=1"}; !reflect.DeepEqual(flags, exp) {
+		t.Errorf("expected %v, got %v", exp, flags)
+	}
+	if _, err := ParseSpecs([]string{"FOO"}); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	vars, sources := Merge(
+		Layer{"board", map[string]string{"FOO": "board", "PIN": "2"}},
+		Layer{"env", env},
+		Layer{"--build-var", flags},
+	)
+	if vars["FOO"] != "flag" || sources["FOO"] != "--build-var" ||
+		vars["BAR"] != "a=b" || sources["BAR"] != "env" ||
+		vars["PIN"] != "2" || sources["PIN"] != "board" {
+		t.Errorf("unexpected result: %v %v", vars, sources)
+	}
+}
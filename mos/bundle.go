@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build/archive"
+	"cesanta.com/mos/bundle"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/lockfile"
+	"cesanta.com/mos/mosgit"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	bundleWithImages = flag.Bool("with-images", false, "mos bundle export: also save the build images into the bundle")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "with-images")
+}
+
+func bundleHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("usage: mos bundle export [file] | mos bundle import <file> [dir]")
+	}
+	switch args[0] {
+	case "export":
+		return errors.Trace(bundleExport(args[1:]))
+	case "import":
+		return errors.Trace(bundleImport(args[1:]))
+	default:
+		return errors.Errorf("unknown command %q, expected export or import", args[0])
+	}
+}
+
+func bundleExport(args []string) error {
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	buildDir := moscommon.GetBuildDir(appDir)
+
+	depsFile := moscommon.GetBuildDepsFilePath(buildDir)
+	data, err := ioutil.ReadFile(depsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s does not exist, run \"mos build --local\" first", depsFile)
+		}
+		return errors.Trace(err)
+	}
+	var deps []moscommon.BuildDep
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return errors.Annotatef(err, "parsing %s", depsFile)
+	}
+
+	m := &bundle.Manifest{
+		AppName:    filepath.Base(appDir),
+		MosVersion: version.GetMosVersion(),
+		Created:    time.Now().UTC(),
+	}
+
+	out := m.AppName + "-bundle.tar.gz"
+	if len(args) > 0 {
+		out = args[0]
+	}
+
+	gitinst := mosgit.NewOurGit()
+	depDirs := map[string]string{}
+	for _, d := range deps {
+		rel := "deps/" + filepath.Base(d.Dir)
+		if other, ok := depDirs[rel]; ok && other != d.Dir {
+			return errors.Errorf("%q and %q would both be bundled as %s", other, d.Dir, rel)
+		}
+		depDirs[rel] = d.Dir
+		bd := bundle.Dep{Name: d.Name, Kind: d.Kind, Dir: rel}
+		if _, err := os.Stat(filepath.Join(d.Dir, ".git")); err == nil {
+			bd.Revision, _ = gitinst.GetCurrentHash(d.Dir)
+			bd.Origin, _ = gitinst.GetOriginUrl(d.Dir)
+		}
+		m.Deps = append(m.Deps, bd)
+	}
+
+	var imagesFile string
+	if *bundleWithImages {
+		if imagesFile, m.Images, err = saveBuildImages(appDir); err != nil {
+			return errors.Trace(err)
+		}
+		defer os.Remove(imagesFile)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w := bundle.NewWriter(f)
+	err = writeBundle(w, m, appDir, depDirs, imagesFile)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Bundled %s with %d deps to %s", m.AppName, len(m.Deps), out)
+	return nil
+}
+
+func writeBundle(w *bundle.Writer, m *bundle.Manifest, appDir string, depDirs map[string]string, imagesFile string) error {
+	if err := w.AddManifest(m); err != nil {
+		return errors.Trace(err)
+	}
+
+	// The app itself, without the build output, and without the deps, which
+	// are added separately because they can be outside of the app dir.
+	skipApp := func(rel string, fi os.FileInfo) bool {
+		return rel == "build" || rel == "deps" || rel == ".git"
+	}
+	ourutil.Reportf("Adding %s...", appDir)
+	if err := w.AddDir(appDir, bundle.AppDir, skipApp); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Deps are bundled without git repos: mos leaves dirs which are not git
+	// repos intact, so they are used as is on the offline machine.
+	skipDep := func(rel string, fi os.FileInfo) bool {
+		return rel == ".git"
+	}
+	var rels []string
+	for rel := range depDirs {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		ourutil.Reportf("Adding %s...", depDirs[rel])
+		if err := w.AddDir(depDirs[rel], bundle.AppDir+"/"+rel, skipDep); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if imagesFile != "" {
+		ourutil.Reportf("Adding build images...")
+		if err := w.AddFile(imagesFile, bundle.ImagesName); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// saveBuildImages saves build images pinned in mos.lock into a temp file, and
+// returns it with the mapping from the pinned references to image IDs.
+func saveBuildImages(appDir string) (string, map[string]string, error) {
+	lf, err := lockfile.Load(moscommon.GetLockFilePath(appDir))
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	refs := lf.PinnedImages()
+	if len(refs) == 0 {
+		return "", nil, errors.Errorf("no build images are pinned in mos.lock, run \"mos build --local\" first")
+	}
+
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	ids := map[string]string{}
+	for _, ref := range refs {
+		if !dockerImageExists(ref) {
+			ourutil.Reportf("Pulling %s...", ref)
+			if err := dockerPull(ref); err != nil {
+				return "", nil, errors.Trace(err)
+			}
+		}
+		out, err := exec.Command(rt, "image", "inspect", "--format", "{{.Id}}", ref).Output()
+		if err != nil {
+			return "", nil, errors.Annotatef(err, "failed to inspect image %s", ref)
+		}
+		ids[ref] = strings.TrimSpace(string(out))
+	}
+
+	f, err := ioutil.TempFile("", "mos-bundle-images-")
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	f.Close()
+	ourutil.Reportf("Saving build images...")
+	if out, err := exec.Command(rt, append([]string{"save", "-o", f.Name()}, refs...)...).CombinedOutput(); err != nil {
+		os.Remove(f.Name())
+		return "", nil, errors.Errorf("%s save failed: %s", rt, strings.TrimSpace(string(out)))
+	}
+	return f.Name(), ids, nil
+}
+
+func bundleImport(args []string) error {
+	if len(args) < 1 {
+		return errors.Errorf("usage: mos bundle import <file> [dir]")
+	}
+	bundleFile := args[0]
+	dir := strings.TrimSuffix(archive.TrimExt(filepath.Base(bundleFile)), "-bundle")
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		return errors.Errorf("%s already exists and is not empty", dir)
+	}
+
+	f, err := os.Open(bundleFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	imagesFile, err := ioutil.TempFile("", "mos-bundle-images-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	imagesFile.Close()
+	defer os.Remove(imagesFile.Name())
+
+	ourutil.Reportf("Extracting %s to %s...", bundleFile, dir)
+	m, hasImages, err := bundle.Extract(f, dir, imagesFile.Name())
+	if err != nil {
+		return errors.Annotatef(err, "extracting %s", bundleFile)
+	}
+	if m.MosVersion != version.GetMosVersion() {
+		ourutil.Reportf("Warning: the bundle was made by mos %s, this is %s", m.MosVersion, version.GetMosVersion())
+	}
+
+	if hasImages {
+		if err := loadBundleImages(dir, imagesFile.Name(), m.Images); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	buildCmd := "mos build --local --no-libs-update"
+	for _, d := range m.Deps {
+		if d.Kind == moscommon.BuildDepMongooseOS {
+			buildCmd += " --repo " + d.Dir
+		}
+	}
+	ourutil.Reportf("Imported %s with %d deps. To build it offline:\n  cd %s && %s", m.AppName, len(m.Deps), dir, buildCmd)
+	return nil
+}
+
+// loadBundleImages loads the saved build images and re-pins them in mos.lock
+// of the imported app by image ID, since references with digests don't
+// survive save and load.
+func loadBundleImages(appDir, imagesFile string, ids map[string]string) error {
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Loading build images...")
+	if out, err := exec.Command(rt, "load", "-i", imagesFile).CombinedOutput(); err != nil {
+		return errors.Errorf("%s load failed: %s", rt, strings.TrimSpace(string(out)))
+	}
+
+	lfPath := moscommon.GetLockFilePath(appDir)
+	lf, err := lockfile.Load(lfPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for image, pinned := range lf.DockerImages {
+		id := ids[pinned]
+		if id == "" {
+			continue
+		}
+		if !dockerImageExists(id) {
+			return errors.Errorf("build image %s (%s) is not found after loading", pinned, id)
+		}
+		lf.PinImage(image, id)
+	}
+	return errors.Trace(lf.Save(lfPath))
+}
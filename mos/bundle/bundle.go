@@ -0,0 +1,236 @@
+// Package bundle implements the archive for air-gapped builds: a tar.gz with
+// the project, all libs and modules it uses, and optionally the build
+// images, which can be built on a machine without network access.
+//
+// Layout of the archive:
+//
+//	bundle.yml    - Manifest
+//	app/          - the project, with the deps under app/deps/
+//	images.tar    - build images, as saved by "docker save" (optional)
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestName is the name of the bundle manifest in the archive.
+	ManifestName = "bundle.yml"
+	// AppDir is the dir of the project in the archive.
+	AppDir = "app"
+	// ImagesName is the name of the saved images in the archive.
+	ImagesName = "images.tar"
+)
+
+// Manifest describes the contents of the bundle.
+type Manifest struct {
+	AppName    string    `yaml:"app_name"`
+	MosVersion string    `yaml:"mos_version"`
+	Created    time.Time `yaml:"created"`
+	Deps       []Dep     `yaml:"deps,omitempty"`
+	// Build images: reference as pinned in mos.lock to the image ID.
+	Images map[string]string `yaml:"images,omitempty"`
+}
+
+// Dep is a lib or module in the bundle.
+type Dep struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+	// Dir relative to the app dir
+	Dir      string `yaml:"dir"`
+	Origin   string `yaml:"origin,omitempty"`
+	Revision string `yaml:"revision,omitempty"`
+}
+
+// Writer writes the bundle archive.
+type Writer struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewWriter returns a writer of the bundle to w.
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+	return &Writer{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+// AddManifest adds the bundle manifest.
+func (w *Writer) AddManifest(m *Manifest) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name: ManifestName, Mode: 0644, Size: int64(len(data)), ModTime: m.Created, Typeflag: tar.TypeReg,
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	_, err = w.tw.Write(data)
+	return errors.Trace(err)
+}
+
+// AddFile adds the file under the given name.
+func (w *Writer) AddFile(src, name string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.addFile(src, name, fi))
+}
+
+// AddDir adds the contents of the dir under the prefix. skip is called with
+// the slash-separated path relative to dir, and if it returns true, the file
+// or the entire subdir is not added.
+func (w *Writer) AddDir(dir, prefix string, skip func(rel string, fi os.FileInfo) bool) error {
+	return errors.Trace(filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if skip != nil && skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := path.Join(prefix, rel)
+		switch {
+		case fi.IsDir():
+			return w.tw.WriteHeader(&tar.Header{
+				Name: name + "/", Mode: 0755, ModTime: fi.ModTime(), Typeflag: tar.TypeDir,
+			})
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return w.tw.WriteHeader(&tar.Header{
+				Name: name, Linkname: target, Mode: 0777, ModTime: fi.ModTime(), Typeflag: tar.TypeSymlink,
+			})
+		case fi.Mode().IsRegular():
+			return w.addFile(p, name, fi)
+		}
+		// Sockets, devices etc are not bundled
+		return nil
+	}))
+}
+
+func (w *Writer) addFile(src, name string, fi os.FileInfo) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name: name, Mode: int64(fi.Mode().Perm()), Size: fi.Size(), ModTime: fi.ModTime(), Typeflag: tar.TypeReg,
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.Copy(w.tw, f)
+	return errors.Trace(err)
+}
+
+// Close finishes the archive; it doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.gz.Close())
+}
+
+// Extract reads the bundle from r, extracting the app to appDir and the saved
+// images, if any, to imagesFile. Returns the bundle manifest, and whether the
+// images were there.
+func Extract(r io.Reader, appDir, imagesFile string) (*Manifest, bool, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	tr := tar.NewReader(gz)
+	var m *Manifest
+	hasImages := false
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+
+		switch {
+		case h.Name == ManifestName:
+			m = &Manifest{}
+			if err := yaml.NewDecoder(tr).Decode(m); err != nil {
+				return nil, false, errors.Annotatef(err, "parsing %s", ManifestName)
+			}
+		case h.Name == ImagesName:
+			if err := writeFile(imagesFile, tr, 0644); err != nil {
+				return nil, false, errors.Trace(err)
+			}
+			hasImages = true
+		case strings.HasPrefix(h.Name, AppDir+"/"):
+			if err := extractEntry(h, tr, appDir, strings.TrimPrefix(h.Name, AppDir+"/")); err != nil {
+				return nil, false, errors.Trace(err)
+			}
+		}
+	}
+	if m == nil {
+		return nil, false, errors.Errorf("%s is missing, not a mos bundle", ManifestName)
+	}
+	return m, hasImages, nil
+}
+
+func extractEntry(h *tar.Header, r io.Reader, dir, name string) error {
+	rel := filepath.FromSlash(strings.TrimSuffix(name, "/"))
+	if rel == "" || rel == "." {
+		return nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return errors.Errorf("%s: path is outside of the bundle", h.Name)
+	}
+	p := filepath.Join(dir, rel)
+	switch h.Typeflag {
+	case tar.TypeDir:
+		return errors.Trace(os.MkdirAll(p, 0755))
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(os.Symlink(h.Linkname, p))
+	case tar.TypeReg:
+		return errors.Trace(writeFile(p, r, os.FileMode(h.Mode)&0777))
+	}
+	return nil
+}
+
+func writeFile(p string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,72 @@
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "deps", "lib1", ".git"), 0755)
+	os.MkdirAll(filepath.Join(src, "build"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "mos.yml"), []byte("name: app\n"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "deps", "lib1", "mos.yml"), []byte("name: lib1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "deps", "lib1", ".git", "HEAD"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "build", "fw.zip"), []byte("x"), 0644)
+	images := filepath.Join(dir, "images.tar")
+	ioutil.WriteFile(images, []byte("images"), 0644)
+
+	m := &Manifest{
+		AppName: "app",
+		Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Deps:    []Dep{{Name: "lib1", Kind: "lib", Dir: "deps/lib1", Revision: "abcd"}},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.AddManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	skip := func(rel string, fi os.FileInfo) bool {
+		return rel == "build" || filepath.Base(rel) == ".git"
+	}
+	if err := w.AddDir(src, AppDir, skip); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(images, ImagesName); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	m2, hasImages, err := Extract(&buf, dst, filepath.Join(dir, "images2.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasImages {
+		t.Errorf("expected images")
+	}
+	if m2.AppName != "app" || len(m2.Deps) != 1 || m2.Deps[0].Revision != "abcd" {
+		t.Errorf("unexpected manifest %+v", m2)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "deps", "lib1", "mos.yml")); err != nil || string(data) != "name: lib1\n" {
+		t.Errorf("lib1 is not extracted: %q %v", data, err)
+	}
+	for _, p := range []string{"build", filepath.Join("deps", "lib1", ".git")} {
+		if _, err := os.Stat(filepath.Join(dst, p)); err == nil {
+			t.Errorf("%s should have been skipped", p)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package cachegc prunes the shared cache of libs and modules: each entry
+// (a lib or module checkout) has a size and the time it was last used, and
+// the least recently used entries are removed first.
+package cachegc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// Entry is a single lib or module in the cache.
+type Entry struct {
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// Scan returns entries of the given cache dirs, i.e. their immediate
+// subdirectories. The last use time is the later of the one in lastUsed, by
+// path, and the modification time of the entry's dir. Missing dirs are
+// skipped.
+func Scan(dirs []string, lastUsed map[string]time.Time) ([]Entry, error) {
+	var ret []Entry
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Trace(err)
+		}
+		for _, f := range files {
+			if !f.IsDir() {
+				continue
+			}
+			e := Entry{Path: filepath.Join(dir, f.Name()), LastUsed: f.ModTime()}
+			if t, ok := lastUsed[e.Path]; ok && t.After(e.LastUsed) {
+				e.LastUsed = t
+			}
+			if e.Size, err = dirSize(e.Path); err != nil {
+				return nil, errors.Trace(err)
+			}
+			ret = append(ret, e)
+		}
+	}
+	return ret, nil
+}
+
+// Select returns the entries to remove: ones not used for longer than maxAge
+// (if non-zero), and then the least recently used ones until the total size
+// is within maxSize (if non-zero). The result is sorted from the least
+// recently used.
+func Select(entries []Entry, maxAge time.Duration, maxSize int64, now time.Time) []Entry {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastUsed.Before(sorted[j].LastUsed)
+	})
+
+	var total int64
+	for _, e := range sorted {
+		total += e.Size
+	}
+
+	var ret []Entry
+	for _, e := range sorted {
+		old := maxAge > 0 && now.Sub(e.LastUsed) > maxAge
+		big := maxSize > 0 && total > maxSize
+		if !old && !big {
+			break
+		}
+		ret = append(ret, e)
+		total -= e.Size
+	}
+	return ret
+}
+
+// ParseSize parses sizes like "500M" or "2G"; suffixes are binary (K is 1024).
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// FormatSize returns the size in human-readable form.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.Trace(err)
+}
@@ -0,0 +1,96 @@
+package cachegc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entries := []Entry{
+		{Path: "a", Size: 100, LastUsed: now.Add(-1 * day)},
+		{Path: "b", Size: 200, LastUsed: now.Add(-5 * day)},
+		{Path: "c", Size: 300, LastUsed: now.Add(-3 * day)},
+	}
+
+	paths := func(es []Entry) []string {
+		var ret []string
+		for _, e := range es {
+			ret = append(ret, e.Path)
+		}
+		return ret
+	}
+
+	for _, c := range []struct {
+		maxAge  time.Duration
+		maxSize int64
+		exp     []string
+	}{
+		{0, 0, nil},
+		{4 * day, 0, []string{"b"}},
+		{2 * day, 0, []string{"b", "c"}},
+		{0, 300, []string{"b", "c"}},
+		{0, 400, []string{"b"}},
+		{0, 500, []string{"b"}},
+		{0, 600, nil},
+		{4 * day, 100, []string{"b", "c"}},
+	} {
+		got := paths(Select(entries, c.maxAge, c.maxSize, now))
+		if len(got) != len(c.exp) {
+			t.Errorf("%v %d: expected %v, got %v", c.maxAge, c.maxSize, c.exp, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.exp[i] {
+				t.Errorf("%v %d: expected %v, got %v", c.maxAge, c.maxSize, c.exp, got)
+				break
+			}
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachegc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "lib1", "src"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "lib1", "src", "a.c"), make([]byte, 10), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "lib1", "b.c"), make([]byte, 5), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "file"), make([]byte, 5), 0644)
+
+	used := time.Now().Add(time.Hour)
+	es, err := Scan([]string{dir, filepath.Join(dir, "nonexistent")}, map[string]time.Time{
+		filepath.Join(dir, "lib1"): used,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].Size != 15 || !es[0].LastUsed.Equal(used) {
+		t.Errorf("unexpected entries: %+v", es)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, exp := range map[string]int64{
+		"100":   100,
+		"2K":    2048,
+		"1.5M":  3 << 19,
+		"2G":    2 << 30,
+		"500mb": 500 << 20,
+	} {
+		got, err := ParseSize(s)
+		if err != nil || got != exp {
+			t.Errorf("%q: expected %d, got %d (%v)", s, exp, got, err)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
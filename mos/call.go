@@ -3,12 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 
 	"context"
 
 	"cesanta.com/common/go/mgrpc/frame"
 	"cesanta.com/common/go/ourjson"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/errcode"
 	"cesanta.com/mos/rpccreds"
 
 	"github.com/cesanta/errors"
@@ -43,7 +45,11 @@ func callDeviceService(
 	}
 
 	if resp.Status != 0 {
-		return "", errors.Errorf("remote error %d: %s", resp.Status, resp.StatusMsg)
+		code := errcode.RPCError
+		if resp.Status == http.StatusUnauthorized || resp.Status == http.StatusForbidden {
+			code = errcode.AuthFailed
+		}
+		return "", errcode.Errorf(code, "remote error %d: %s", resp.Status, resp.StatusMsg)
 	}
 
 	// TODO(dfrank): instead of that, we should probably add a separate function
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/capture"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	capturePins     = flag.StringSlice("pins", nil, "Comma-separated list of GPIO pins to capture")
+	captureRate     = flag.String("rate", "10k", "Sample rate, e.g. 500, 10k or 1M")
+	captureDuration = flag.Duration("duration", 1*time.Second, "Capture duration")
+	captureMethod   = flag.String("capture-method", "GPIO.Capture", "RPC method used by \"mos capture gpio\"")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "capture-method")
+}
+
+// captureHandler asks the device to sample the given pins and saves the
+// result to a file: "mos capture gpio [file]". The format is determined by
+// the file extension: .vcd (default) or .csv.
+//
+// The device is expected to implement the RPC method which takes
+// {"pins": [4, 5], "rate": 10000, "count": 20000} and returns
+// {"rate": 10000, "data": "<base64>"}, where "rate" is the actual sample rate
+// and data contains samples as described in capture.DecodeSamples.
+func captureHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 || args[0] != "gpio" {
+		return errors.Errorf("usage: mos capture gpio --pins 4,5 [--rate 10k] [--duration 2s] [file]")
+	}
+
+	outFile := "capture.vcd"
+	if len(args) > 1 {
+		outFile = args[1]
+	}
+	write := capture.WriteVCD
+	switch strings.ToLower(filepath.Ext(outFile)) {
+	case ".vcd":
+	case ".csv":
+		write = capture.WriteCSV
+	default:
+		return errors.Errorf("unsupported output format %q, expected .vcd or .csv", filepath.Ext(outFile))
+	}
+
+	if len(*capturePins) == 0 {
+		return errors.Errorf("--pins is required")
+	}
+	if len(*capturePins) > capture.MaxPins {
+		return errors.Errorf("at most %d pins can be captured at once", capture.MaxPins)
+	}
+	pins := []int{}
+	for _, p := range *capturePins {
+		pin, err := strconv.Atoi(p)
+		if err != nil || pin < 0 {
+			return errors.Errorf("invalid pin %q", p)
+		}
+		pins = append(pins, pin)
+	}
+
+	rate, err := capture.ParseRate(*captureRate)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	count := int(captureDuration.Seconds() * float64(rate))
+	if count < 1 {
+		return errors.Errorf("duration is too short for the rate %d Hz", rate)
+	}
+
+	params, err := json.Marshal(map[string]interface{}{
+		"pins":  pins,
+		"rate":  rate,
+		"count": count,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Give the device the time to capture, on top of the usual timeout
+	ctx, cancel := context.WithTimeout(ctx, *captureDuration+*timeout)
+	defer cancel()
+
+	ourutil.Reportf("Capturing %d samples of %d pin(s) at %d Hz...", count, len(pins), rate)
+	res, err := callDeviceService(ctx, devConn, *captureMethod, string(params))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var resp struct {
+		Rate int    `json:"rate"`
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(res), &resp); err != nil {
+		return errors.Annotatef(err, "unexpected %s response", *captureMethod)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Data)
+	if err != nil {
+		return errors.Annotatef(err, "invalid sample data")
+	}
+
+	c := &capture.Capture{Pins: pins, Rate: rate}
+	if resp.Rate > 0 {
+		c.Rate = resp.Rate
+	}
+	c.Samples, err = capture.DecodeSamples(data, len(pins))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := write(f, c); err != nil {
+		f.Close()
+		return errors.Annotatef(err, "writing %s", outFile)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Wrote %d samples to %s", len(c.Samples), outFile)
+	return nil
+}
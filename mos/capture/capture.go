@@ -0,0 +1,133 @@
+// Package capture decodes GPIO samples captured by the device and writes
+// them in formats understood by logic analyzer software, like PulseView.
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// MaxPins is the maximum number of pins which can be captured at once.
+const MaxPins = 32
+
+// Capture is a set of samples of the given pins. Bit i of each sample is the
+// level of Pins[i].
+type Capture struct {
+	Pins    []int
+	Rate    int
+	Samples []uint32
+}
+
+// SampleSize returns the number of bytes per sample in the raw data for the
+// given number of pins.
+func SampleSize(numPins int) int {
+	return (numPins + 7) / 8
+}
+
+// DecodeSamples decodes raw sample data as sent by the device: each sample
+// takes SampleSize(numPins) bytes, little-endian.
+func DecodeSamples(data []byte, numPins int) ([]uint32, error) {
+	if numPins < 1 || numPins > MaxPins {
+		return nil, errors.Errorf("number of pins must be from 1 to %d, got %d", MaxPins, numPins)
+	}
+	sz := SampleSize(numPins)
+	if len(data)%sz != 0 {
+		return nil, errors.Errorf("data size %d is not a multiple of the sample size %d", len(data), sz)
+	}
+	ret := make([]uint32, 0, len(data)/sz)
+	for i := 0; i < len(data); i += sz {
+		var s uint32
+		for j := 0; j < sz; j++ {
+			s |= uint32(data[i+j]) << uint(8*j)
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// ParseRate parses sample rate like "500", "10k", "1M" or "10kHz".
+func ParseRate(s string) (int, error) {
+	v := strings.TrimSuffix(strings.TrimSuffix(s, "Hz"), "hz")
+	mul := 1
+	switch {
+	case strings.HasSuffix(v, "k") || strings.HasSuffix(v, "K"):
+		mul, v = 1000, v[:len(v)-1]
+	case strings.HasSuffix(v, "M"):
+		mul, v = 1000000, v[:len(v)-1]
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+	rate := int(f * float64(mul))
+	if rate < 1 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+	return rate, nil
+}
+
+func (c *Capture) level(sample uint32, pin int) int {
+	return int(sample>>uint(pin)) & 1
+}
+
+// WriteVCD writes the capture in the Value Change Dump format, with
+// nanosecond timescale.
+func WriteVCD(w io.Writer, c *Capture) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$version mos capture $end\n")
+	fmt.Fprintf(bw, "$timescale 1ns $end\n")
+	fmt.Fprintf(bw, "$scope module gpio $end\n")
+	for i, pin := range c.Pins {
+		fmt.Fprintf(bw, "$var wire 1 %s gpio%d $end\n", vcdID(i), pin)
+	}
+	fmt.Fprintf(bw, "$upscope $end\n$enddefinitions $end\n")
+
+	for n, s := range c.Samples {
+		var changes []string
+		for i := range c.Pins {
+			if n == 0 || c.level(s, i) != c.level(c.Samples[n-1], i) {
+				changes = append(changes, fmt.Sprintf("%d%s", c.level(s, i), vcdID(i)))
+			}
+		}
+		if len(changes) > 0 {
+			fmt.Fprintf(bw, "#%d\n%s\n", sampleTimeNs(n, c.Rate), strings.Join(changes, "\n"))
+		}
+	}
+	if len(c.Samples) > 0 {
+		fmt.Fprintf(bw, "#%d\n", sampleTimeNs(len(c.Samples), c.Rate))
+	}
+	return errors.Trace(bw.Flush())
+}
+
+// WriteCSV writes the capture as CSV: time in seconds, then a column per pin.
+func WriteCSV(w io.Writer, c *Capture) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "time")
+	for _, pin := range c.Pins {
+		fmt.Fprintf(bw, ",gpio%d", pin)
+	}
+	fmt.Fprintf(bw, "\n")
+	for n, s := range c.Samples {
+		fmt.Fprintf(bw, "%.9f", float64(n)/float64(c.Rate))
+		for i := range c.Pins {
+			fmt.Fprintf(bw, ",%d", c.level(s, i))
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+	return errors.Trace(bw.Flush())
+}
+
+func sampleTimeNs(n, rate int) int64 {
+	return int64(n) * 1000000000 / int64(rate)
+}
+
+// vcdID returns the VCD identifier of the i-th signal: printable ASCII chars
+// starting from "!".
+func vcdID(i int) string {
+	return string(rune('!' + i))
+}
@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeSamples(t *testing.T) {
+	s, err := DecodeSamples([]byte{0x01, 0x02, 0x03}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, []uint32{1, 2, 3}) {
+		t.Errorf("unexpected samples: %v", s)
+	}
+
+	s, err = DecodeSamples([]byte{0x01, 0x01, 0xff, 0x00}, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, []uint32{0x101, 0xff}) {
+		t.Errorf("unexpected samples: %v", s)
+	}
+
+	if _, err := DecodeSamples([]byte{1, 2, 3}, 9); err == nil {
+		t.Errorf("expected an error for a partial sample")
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for s, exp := range map[string]int{"500": 500, "10k": 10000, "2.5kHz": 2500, "1M": 1000000} {
+		if v, err := ParseRate(s); err != nil || v != exp {
+			t.Errorf("%q: expected %d, got %d (%v)", s, exp, v, err)
+		}
+	}
+	for _, s := range []string{"", "k", "-1", "abc"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestWriteVCD(t *testing.T) {
+	c := &Capture{Pins: []int{4, 5}, Rate: 1000, Samples: []uint32{0, 1, 1, 3}}
+	var b bytes.Buffer
+	if err := WriteVCD(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	exp := "$var wire 1 ! gpio4 $end\n$var wire 1 \" gpio5 $end\n"
+	if !bytes.Contains(b.Bytes(), []byte(exp)) {
+		t.Errorf("no var definitions in:\n%s", b.String())
+	}
+	exp = "#0\n0!\n0\"\n#1000000\n1!\n#3000000\n1\"\n#4000000\n"
+	if !bytes.HasSuffix(b.Bytes(), []byte(exp)) {
+		t.Errorf("unexpected value changes in:\n%s", b.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"cesanta.com/mos/ci"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	ciMode = flag.Bool("ci", false, "CI mode: emit log groups, annotations and step summaries for GitHub Actions / GitLab CI, "+
+		"and never prompt")
+	nonInteractive = flag.Bool("non-interactive", false, "Never prompt: fail with an explanation of the flag to give the answer with instead")
+)
+
+// ciReportBuild annotates compiler errors from the build log and adds the
+// build result to the job summary. Does nothing unless in CI mode.
+func ciReportBuild(bParams *buildParams, elapsed time.Duration, buildErr error) {
+	if !ci.Enabled() {
+		return
+	}
+
+	var sum bytes.Buffer
+	fmt.Fprintf(&sum, "### mos build\n\n")
+
+	buildDir := moscommon.GetBuildDir(projectDir)
+	if buildErr == nil {
+		fmt.Fprintf(&sum, "| App | Platform | Version | Build ID | Time |\n|---|---|---|---|---|\n")
+		if fw, err := common.NewZipFirmwareBundle(moscommon.GetFirmwareZipFilePath(buildDir)); err == nil {
+			fmt.Fprintf(&sum, "| %s | %s | %s | %s | %s |\n",
+				fw.Name, fw.Platform, fw.Version, fw.BuildID, elapsed.Round(time.Second))
+		}
+	} else {
+		fmt.Fprintf(&sum, "Build for `%s` **failed** after %s: %s\n\n",
+			bParams.Platform, elapsed.Round(time.Second), buildErr)
+		if log, err := ioutil.ReadFile(moscommon.GetBuildLogFilePath(buildDir)); err == nil {
+			for _, a := range ci.ParseCompilerMessages(log, ci.ProjectPathResolver(projectDir)) {
+				ci.Annotate(os.Stdout, &a)
+				fmt.Fprintf(&sum, "- `%s`\n", a.String())
+			}
+		}
+	}
+
+	if err := ci.AddSummary(sum.String()); err != nil {
+		glog.Errorf("failed to write step summary: %s", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package ci formats mos output for CI systems: log groups, annotations for
+// compiler errors and step summaries, in the format of GitHub Actions or
+// GitLab CI.
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// Provider is a CI system
+type Provider string
+
+const (
+	ProviderNone   Provider = ""
+	ProviderGitHub Provider = "github"
+	ProviderGitLab Provider = "gitlab"
+)
+
+var (
+	provider   = ProviderNone
+	groupStack []string
+
+	compilerMsgRegexp = regexp.MustCompile(
+		`(?m)^([^\s:][^:\n]*):(\d+):(?:(\d+):)?\s*(error|fatal error|warning):\s*(.*?)\s*$`)
+)
+
+// Init enables the CI mode. The provider is detected from the environment;
+// if it's neither GitHub Actions nor GitLab CI, GitHub format is used since
+// it's the most readable one.
+func Init() {
+	provider = ProviderGitHub
+	if os.Getenv("GITLAB_CI") != "" {
+		provider = ProviderGitLab
+	}
+}
+
+// Enabled returns whether the CI mode is on.
+func Enabled() bool {
+	return provider != ProviderNone
+}
+
+// StartGroup starts a collapsible group of log lines.
+func StartGroup(w io.Writer, title string) {
+	switch provider {
+	case ProviderGitHub:
+		fmt.Fprintf(w, "::group::%s\n", title)
+	case ProviderGitLab:
+		name := fmt.Sprintf("mos_%d", len(groupStack))
+		fmt.Fprintf(w, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", time.Now().Unix(), name, title)
+		groupStack = append(groupStack, name)
+	}
+}
+
+// EndGroup ends the group started by the last StartGroup.
+func EndGroup(w io.Writer) {
+	switch provider {
+	case ProviderGitHub:
+		fmt.Fprintf(w, "::endgroup::\n")
+	case ProviderGitLab:
+		if len(groupStack) == 0 {
+			return
+		}
+		name := groupStack[len(groupStack)-1]
+		groupStack = groupStack[:len(groupStack)-1]
+		fmt.Fprintf(w, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), name)
+	}
+}
+
+// Annotation is a problem in a source file, like a compiler error.
+type Annotation struct {
+	File    string
+	Line    int
+	Col     int
+	Level   string // "error" or "warning"
+	Message string
+}
+
+func (a *Annotation) String() string {
+	loc := fmt.Sprintf("%s:%d", a.File, a.Line)
+	if a.Col > 0 {
+		loc += fmt.Sprintf(":%d", a.Col)
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, a.Level, a.Message)
+}
+
+// ParseCompilerMessages returns errors and warnings found in the compiler
+// output, in the GCC format ("file:line[:col]: error: message"). Paths are
+// resolved with resolvePath, if it's not nil; messages for which it returns
+// an empty string are skipped.
+func ParseCompilerMessages(log []byte, resolvePath func(string) string) []Annotation {
+	var res []Annotation
+	seen := map[string]bool{}
+	for _, m := range compilerMsgRegexp.FindAllStringSubmatch(string(log), -1) {
+		a := Annotation{File: m[1], Level: "error", Message: m[5]}
+		a.Line, _ = strconv.Atoi(m[2])
+		a.Col, _ = strconv.Atoi(m[3])
+		if m[4] == "warning" {
+			a.Level = "warning"
+		}
+		if resolvePath != nil {
+			if a.File = resolvePath(a.File); a.File == "" {
+				continue
+			}
+		}
+		// The same message is often printed several times, e.g. for headers
+		if s := a.String(); !seen[s] {
+			seen[s] = true
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// ProjectPathResolver returns a function which maps paths from the build
+// environment (e.g. "/app/src/main.c" in the build container) to paths
+// relative to the project dir: the longest suffix of the path which exists in
+// the project dir is used. Paths which don't exist in the project are
+// resolved to an empty string.
+func ProjectPathResolver(projectDir string) func(string) string {
+	return func(p string) string {
+		parts := strings.Split(filepath.ToSlash(p), "/")
+		for i := range parts {
+			rel := strings.Join(parts[i:], "/")
+			if rel == "" {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(projectDir, rel)); err == nil {
+				return rel
+			}
+		}
+		return ""
+	}
+}
+
+// Annotate prints the annotation in the format recognized by the CI system.
+func Annotate(w io.Writer, a *Annotation) {
+	switch provider {
+	case ProviderGitHub:
+		params := fmt.Sprintf("file=%s,line=%d", escapeProperty(a.File), a.Line)
+		if a.Col > 0 {
+			params += fmt.Sprintf(",col=%d", a.Col)
+		}
+		fmt.Fprintf(w, "::%s %s::%s\n", a.Level, params, escapeData(a.Message))
+	case ProviderGitLab:
+		// GitLab has no log annotations, just highlight the line
+		color := "31"
+		if a.Level == "warning" {
+			color = "33"
+		}
+		fmt.Fprintf(w, "\x1b[%sm%s\x1b[0m\n", color, a.String())
+	}
+}
+
+// Error prints an error which is not related to any particular file.
+func Error(w io.Writer, msg string) {
+	switch provider {
+	case ProviderGitHub:
+		fmt.Fprintf(w, "::error::%s\n", escapeData(msg))
+	case ProviderGitLab:
+		fmt.Fprintf(w, "\x1b[31mError: %s\x1b[0m\n", msg)
+	}
+}
+
+// AddSummary appends markdown to the job summary. Only GitHub Actions support
+// it (via GITHUB_STEP_SUMMARY); for other providers, it's a no-op.
+func AddSummary(markdown string) error {
+	fname := os.Getenv("GITHUB_STEP_SUMMARY")
+	if provider != ProviderGitHub || fname == "" {
+		return nil
+	}
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	if _, err := io.WriteString(f, markdown+"\n"); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func escapeData(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
+
+func escapeProperty(s string) string {
+	s = escapeData(s)
+	s = strings.Replace(s, ":", "%3A", -1)
+	return strings.Replace(s, ",", "%2C", -1)
+}
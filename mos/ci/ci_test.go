@@ -0,0 +1,65 @@
+package ci
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const buildLog = `CC /app/src/main.c
+/app/src/main.c: In function 'mgos_app_init':
+/app/src/main.c:10:3: error: 'foo' undeclared (first use in this function)
+/app/src/main.c:10:3: error: 'foo' undeclared (first use in this function)
+/app/src/util.h:4: warning: unused variable 'x'
+/mongoose-os/fw/src/mgos_init.c:5:1: error: something in the SDK
+make: *** [main.o] Error 1
+`
+
+func TestParseCompilerMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ci_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "src", "main.c"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "src", "util.h"), nil, 0644)
+
+	res := ParseCompilerMessages([]byte(buildLog), ProjectPathResolver(dir))
+	if len(res) != 2 {
+		t.Fatalf("expected 2 messages, got %d: %+v", len(res), res)
+	}
+	if e := (Annotation{File: "src/main.c", Line: 10, Col: 3, Level: "error",
+		Message: "'foo' undeclared (first use in this function)"}); res[0] != e {
+		t.Errorf("expected %+v, got %+v", e, res[0])
+	}
+	if e := (Annotation{File: "src/util.h", Line: 4, Level: "warning",
+		Message: "unused variable 'x'"}); res[1] != e {
+		t.Errorf("expected %+v, got %+v", e, res[1])
+	}
+
+	if res := ParseCompilerMessages([]byte(buildLog), nil); len(res) != 3 {
+		t.Errorf("expected 3 messages without a resolver, got %d: %+v", len(res), res)
+	}
+}
+
+func TestAnnotateGitHub(t *testing.T) {
+	provider = ProviderGitHub
+	defer func() { provider = ProviderNone }()
+
+	var b bytes.Buffer
+	Annotate(&b, &Annotation{File: "src/a,b.c", Line: 1, Col: 2, Level: "error", Message: "100% bad\nreally"})
+	expected := "::error file=src/a%2Cb.c,line=1,col=2::100%25 bad%0Areally\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+
+	b.Reset()
+	StartGroup(&b, "Build log")
+	EndGroup(&b)
+	if expected := "::group::Build log\n::endgroup::\n"; b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/cachegc"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/common/state"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/mosgit"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	cleanDeps        = flag.Bool("deps", false, "mos clean: also remove the project deps dir")
+	cleanGlobalCache = flag.Bool("global-cache", false, "mos clean: prune the shared cache of libs and modules")
+	cacheMaxAge      = flag.Duration("cache-max-age", 30*24*time.Hour, "mos clean --global-cache: remove libs and modules not used for longer than that; 0 to disable")
+	cacheMaxSize     = flag.String("cache-max-size", "", "mos clean --global-cache: remove least recently used libs and modules until the cache is within this size, e.g. 2G")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "deps", "global-cache", "cache-max-age", "cache-max-size")
+}
+
+func cleanHandler(ctx context.Context, devConn *dev.DevConn) error {
+	// --dry-run is true by default for the atca commands, but for mos clean
+	// it has to be given explicitly.
+	listOnly := false
+	if f := flag.Lookup("dry-run"); f != nil && f.Changed {
+		listOnly = *dryRun
+	}
+
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	dirs := []string{moscommon.GetBuildDir(appDir)}
+	if *cleanDeps || *allFlag {
+		dirs = append(dirs, moscommon.GetDepsDir(appDir))
+	}
+	for _, d := range dirs {
+		if _, err := os.Stat(d); err != nil {
+			continue
+		}
+		if listOnly {
+			ourutil.Reportf("Would remove %s", d)
+			continue
+		}
+		ourutil.Reportf("Removing %s...", d)
+		if err := os.RemoveAll(paths.LongPath(d)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if *cleanGlobalCache || *allFlag {
+		return errors.Trace(pruneGlobalCache(listOnly))
+	}
+	return nil
+}
+
+// getGlobalCacheDirs returns dirs with libs and modules shared between
+// projects.
+func getGlobalCacheDirs() []string {
+	return []string{paths.LibsDir, paths.ModulesDir, paths.LibsDirOld, paths.ModulesDirOld}
+}
+
+func pruneGlobalCache(listOnly bool) error {
+	var maxSize int64
+	if *cacheMaxSize != "" {
+		var err error
+		if maxSize, err = cachegc.ParseSize(*cacheMaxSize); err != nil {
+			return errors.Annotatef(err, "--cache-max-size")
+		}
+	}
+
+	s := state.GetState()
+	entries, err := cachegc.Scan(getGlobalCacheDirs(), s.CacheUsage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	toRemove := cachegc.Select(entries, *cacheMaxAge, maxSize, time.Now())
+	gitinst := mosgit.NewOurGit()
+	var freed int64
+	for _, e := range toRemove {
+		age := time.Since(e.LastUsed).Truncate(time.Hour)
+		// Local changes and unpushed commits in checkouts must not be lost
+		if _, err := os.Stat(filepath.Join(e.Path, ".git")); err == nil {
+			if clean, err := gitinst.IsClean(e.Path, ""); err != nil || !clean {
+				ourutil.Reportf("Keeping %s, it has local changes", e.Path)
+				continue
+			}
+		}
+		if listOnly {
+			ourutil.Reportf("Would remove %s (%s, last used %s ago)", e.Path, cachegc.FormatSize(e.Size), age)
+		} else {
+			ourutil.Reportf("Removing %s (%s, last used %s ago)...", e.Path, cachegc.FormatSize(e.Size), age)
+			if err := os.RemoveAll(paths.LongPath(e.Path)); err != nil {
+				return errors.Trace(err)
+			}
+			delete(s.CacheUsage, e.Path)
+		}
+		freed += e.Size
+	}
+
+	if listOnly {
+		ourutil.Reportf("Shared cache: %d entries, %s; would free %s", len(entries), cachegc.FormatSize(total), cachegc.FormatSize(freed))
+		return nil
+	}
+	ourutil.Reportf("Shared cache: %d entries, %s; freed %s", len(entries), cachegc.FormatSize(total), cachegc.FormatSize(freed))
+	return errors.Trace(state.SaveState())
+}
+
+// recordCacheUsage remembers that the given dirs were used by the build, for
+// those of them which are in the shared cache.
+func recordCacheUsage(dirs []string) error {
+	s := state.GetState()
+	now := time.Now()
+	changed := false
+	for _, d := range dirs {
+		for _, cd := range getGlobalCacheDirs() {
+			if cd == "" {
+				continue
+			}
+			rel, err := filepath.Rel(cd, d)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			// Usage is tracked per top level entry of the cache dir
+			entry := filepath.Join(cd, strings.Split(filepath.ToSlash(rel), "/")[0])
+			if s.CacheUsage == nil {
+				s.CacheUsage = map[string]time.Time{}
+			}
+			s.CacheUsage[entry] = now
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return errors.Trace(state.SaveState())
+}
@@ -8,4 +8,12 @@ const (
 	FormPreferPrebuildLibsName = "prefer_prebuilt_libs"
 	FormSourcesZipName         = "file"
 	FormBuildTargetName        = "build_target"
+	// Digest of the sources uploaded beforehand, instead of FormSourcesZipName
+	FormSourcesSHA256Name = "sources_sha256"
+	// Asks the server to respond with HeaderResultURL instead of the results
+	FormResultURLName = "result_url"
+
+	// Response headers with the URL of the build results and their digest
+	HeaderResultURL    = "X-Mos-Result-Url"
+	HeaderResultSHA256 = "X-Mos-Result-Sha256"
 )
@@ -17,6 +17,10 @@ func GetManifestFilePath(projectDir string) string {
 	return filepath.Join(projectDir, "mos.yml")
 }
 
+func GetLockFilePath(projectDir string) string {
+	return filepath.Join(projectDir, "mos.lock")
+}
+
 func GetManifestArchFilePath(projectDir, arch string) string {
 	return filepath.Join(projectDir, fmt.Sprintf("mos_%s.yml", arch))
 }
@@ -37,6 +41,38 @@ func GetFilesystemStagingDir(buildDir string) string {
 	return filepath.Join(buildDir, "fs")
 }
 
+func GetFSAssetsDir(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "fs_assets")
+}
+
+func GetEmbeddedAssetsDir(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "embedded_assets")
+}
+
+func GetBuildInfoDir(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "build_info")
+}
+
+func GetArduinoLibsDir(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "arduino_libs")
+}
+
+func GetTypeScriptDir(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "ts")
+}
+
+func GetRustDir(buildDir string) string {
+	return filepath.Join(buildDir, "rust")
+}
+
+func GetFSSourceMapsDir(buildDir string) string {
+	return filepath.Join(buildDir, "fs_maps")
+}
+
+func GetGenConfigDir(buildDir string) string {
+	return filepath.Join(buildDir, "gen_config")
+}
+
 func GetBuildCtxFilePath(buildDir string) string {
 	return filepath.Join(GetGeneratedFilesDir(buildDir), "build_ctx.txt")
 }
@@ -45,6 +81,18 @@ func GetBuildStatFilePath(buildDir string) string {
 	return filepath.Join(GetGeneratedFilesDir(buildDir), "build_stat.json")
 }
 
+func GetLibsCopyDir(buildDir string) string {
+	return filepath.Join(buildDir, "libs")
+}
+
+func GetBuildDepsFilePath(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "build_deps.json")
+}
+
+func GetConfigDefaultsFilePath(buildDir string) string {
+	return filepath.Join(GetGeneratedFilesDir(buildDir), "conf0.json")
+}
+
 func GetFirmwareElfFilePath(buildDir string) string {
 	return filepath.Join(GetObjectDir(buildDir), "fw.elf")
 }
@@ -100,3 +148,7 @@ func GetBinaryLibFilePath(libDir, name, platform string) string {
 func GetSdkVersionGlob(mosDir string) string {
 	return filepath.Join(mosDir, "fw", "platforms", "*", "sdk.version")
 }
+
+func GetSettingsFilePath(projectDir string) string {
+	return filepath.Join(projectDir, ".mos", "settings.yml")
+}
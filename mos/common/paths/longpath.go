@@ -0,0 +1,61 @@
+package paths
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// Prefix which lifts the MAX_PATH (260 chars) limit on Windows.
+	longPathPrefix    = `\\?\`
+	longPathUNCPrefix = `\\?\UNC\`
+)
+
+// LongPath returns the path in the form which is not limited by MAX_PATH on
+// Windows: absolute, with backslashes and the \\?\ prefix. Deeply nested lib
+// checkouts easily exceed MAX_PATH. On other systems, p is returned as is.
+func LongPath(p string) string {
+	if runtime.GOOS != "windows" || p == "" {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	return longPathWindows(p)
+}
+
+// longPathWindows adds the long path prefix to the absolute Windows path.
+func longPathWindows(p string) string {
+	if strings.HasPrefix(p, longPathPrefix) {
+		return p
+	}
+	p = strings.Replace(p, "/", `\`, -1)
+	if strings.HasPrefix(p, `\\`) {
+		// UNC path: \\server\share\...
+		return longPathUNCPrefix + p[2:]
+	}
+	return longPathPrefix + p
+}
+
+// SlashPath returns the path with forward slashes only, regardless of the
+// host OS and of the mix of separators in it, and without the long path
+// prefix. It's used for paths which end up in makefiles and generated files,
+// where backslashes are escapes.
+func SlashPath(p string) string {
+	if strings.HasPrefix(p, longPathUNCPrefix) {
+		p = `\\` + p[len(longPathUNCPrefix):]
+	} else {
+		p = strings.TrimPrefix(p, longPathPrefix)
+	}
+	p = strings.Replace(p, `\`, "/", -1)
+	if p == "" {
+		return p
+	}
+	// path.Clean would turn the leading "//" of UNC paths into "/"
+	if strings.HasPrefix(p, "//") {
+		return "/" + path.Clean(p)
+	}
+	return path.Clean(p)
+}
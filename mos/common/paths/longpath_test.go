@@ -0,0 +1,32 @@
+package paths
+
+import "testing"
+
+func TestLongPathWindows(t *testing.T) {
+	for p, exp := range map[string]string{
+		`C:\foo\bar`:            `\\?\C:\foo\bar`,
+		`C:/foo\bar`:            `\\?\C:\foo\bar`,
+		`\\server\share\foo`:    `\\?\UNC\server\share\foo`,
+		`\\?\C:\already\prefix`: `\\?\C:\already\prefix`,
+	} {
+		if got := longPathWindows(p); got != exp {
+			t.Errorf("%q: expected %q, got %q", p, exp, got)
+		}
+	}
+}
+
+func TestSlashPath(t *testing.T) {
+	for p, exp := range map[string]string{
+		`C:\foo\bar`:               "C:/foo/bar",
+		`C:\foo/bar\\baz/`:         "C:/foo/bar/baz",
+		`\\?\C:\foo\bar`:           "C:/foo/bar",
+		`\\?\UNC\server\share\foo`: "//server/share/foo",
+		"/home/user/app/src":       "/home/user/app/src",
+		"src/../src/main.c":        "src/main.c",
+		"":                         "",
+	} {
+		if got := SlashPath(p); got != exp {
+			t.Errorf("%q: expected %q, got %q", p, exp, got)
+		}
+	}
+}
@@ -22,6 +22,9 @@ var (
 	LibsDir    = ""
 	AppsDir    = ""
 	ModulesDir = ""
+	// Store of mos binaries of different versions, used to run the version
+	// required by the project
+	VersionsDir = ""
 
 	// TODO(dfrank): remove them after a while (2017/08/03)
 	LibsDirOld    = "~/.mos/libs"
@@ -36,6 +39,7 @@ func init() {
 	flag.StringVar(&LibsDir, "libs-dir", "", "Directory to store libraries into")
 	flag.StringVar(&AppsDir, "apps-dir", AppsDirTpl, "Directory to store apps into")
 	flag.StringVar(&ModulesDir, "modules-dir", "", "Directory to store modules into")
+	flag.StringVar(&VersionsDir, "versions-dir", "~/.mos/versions", "Directory to store mos binaries of different versions into")
 
 	flag.StringVar(&StateFilepath, "state-file", "~/.mos/state.json", "Where to store internal mos state")
 }
@@ -63,6 +67,11 @@ func Init() error {
 		return errors.Trace(err)
 	}
 
+	VersionsDir, err = NormalizePath(VersionsDir, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	// TODO(dfrank) remove after a while (2017/08/03) {{{
 	LibsDirOld, err = NormalizePath(LibsDirOld, "")
 	if err != nil {
@@ -6,3 +6,24 @@ type BuildStat struct {
 	AppName     string `json:"app_name"`
 	BuildTimeMS int    `json:"build_time_ms"`
 }
+
+// Kinds of BuildDep.
+const (
+	BuildDepLib        = "lib"
+	BuildDepModule     = "module"
+	BuildDepMongooseOS = "mongoose-os"
+)
+
+// BuildDep is a lib or module used by the local build, as saved to
+// GetBuildDepsFilePath.
+type BuildDep struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Dir  string `json:"dir,omitempty"`
+	// Version from the lib manifest, if any.
+	Version string `json:"version,omitempty"`
+	// Git commit of the checkout, if it's a git repo.
+	Revision string `json:"revision,omitempty"`
+	// License from the lib manifest, if any.
+	License string `json:"license,omitempty"`
+}
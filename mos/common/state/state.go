@@ -3,6 +3,7 @@ package state
 import (
 	"encoding/json"
 	"io/ioutil"
+	"time"
 
 	"cesanta.com/mos/common/paths"
 
@@ -12,6 +13,12 @@ import (
 type State struct {
 	Versions         map[string]*StateVersion `json:"versions"`
 	OldDirsConverted bool                     `json:"old_dirs_converted"`
+	// Libs linked to local checkouts (see mos libs link): absolute project
+	// dir to lib name to the checkout dir.
+	LibLinks map[string]map[string]string `json:"lib_links,omitempty"`
+	// When libs and modules in the shared cache were last used by a build, by
+	// dir (see mos clean --global-cache).
+	CacheUsage map[string]time.Time `json:"cache_usage,omitempty"`
 }
 
 type StateVersion struct {
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/mos/build/compiledb"
+	moscommon "cesanta.com/mos/common"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	compileCommands = flag.Bool("compile-commands", false, "mos build: write build/compile_commands.json for IDEs and clangd")
+	clangdConfig    = flag.Bool("clangd", false, "mos build: with --compile-commands, also write the .clangd config to the project dir")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "compile-commands", "clangd")
+}
+
+// writeCompileCommandsFromLog writes the compilation database from the
+// compiler invocations in the build log, with the container paths mapped
+// back to the host ones.
+func writeCompileCommandsFromLog(buildDir, defaultDir string, containerToHost map[string]string) error {
+	f, err := os.Open(moscommon.GetBuildLogFilePath(buildDir))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	cmds, err := compiledb.ParseLog(f, defaultDir)
+	if err != nil {
+		return errors.Annotatef(err, "parsing build log")
+	}
+	compiledb.MapPaths(cmds, containerToHost)
+	return errors.Trace(writeCompileCommands(buildDir, cmds))
+}
+
+// writeCompileCommandsFromManifest writes the compilation database for the
+// app sources built with the flags from the manifest, for remote builds,
+// whose log has no compiler invocations.
+func writeCompileCommandsFromManifest(buildDir string) error {
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sources, err := getExportFiles(manifest.Sources, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var includes []string
+	for _, inc := range manifest.Includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(appDir, inc)
+		}
+		includes = append(includes, inc)
+	}
+	cxxflags := append(manifest.CXXFlags, manifest.CXX.Flags()...)
+	cmds := compiledb.Synthesize(sources, appDir, manifest.CFlags, cxxflags, manifest.CDefs, includes)
+	return errors.Trace(writeCompileCommands(buildDir, cmds))
+}
+
+// writeCompileCommands merges the commands into the database in the build
+// dir, and writes the clangd config if requested.
+func writeCompileCommands(buildDir string, cmds []compiledb.Command) error {
+	fname := filepath.Join(buildDir, compiledb.FileName)
+	var old []compiledb.Command
+	if data, err := ioutil.ReadFile(fname); err == nil {
+		// A broken database is just overwritten
+		json.Unmarshal(data, &old)
+	}
+	cmds = compiledb.Merge(old, cmds, func(c *compiledb.Command) bool {
+		f := c.File
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(c.Directory, f)
+		}
+		_, err := os.Stat(f)
+		return err == nil
+	})
+	data, err := compiledb.Marshal(cmds)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	freportf(logWriterStderr, "Wrote %s (%d files)", fname, len(cmds))
+
+	if !*clangdConfig {
+		return nil
+	}
+	cfgName := filepath.Join(projectDir, compiledb.ClangdName)
+	if data, err := ioutil.ReadFile(cfgName); err == nil && !compiledb.IsGeneratedClangd(data) {
+		freportf(logWriterStderr, "Not overwriting %s, which is not generated by mos", cfgName)
+		return nil
+	}
+	rel, err := filepath.Rel(projectDir, buildDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(cfgName, compiledb.ClangdConfig(rel), 0644))
+}
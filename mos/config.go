@@ -8,6 +8,7 @@ import (
 
 	"cesanta.com/common/go/lptr"
 	fwconfig "cesanta.com/fw/defs/config"
+	"cesanta.com/mos/build/secrets"
 	"cesanta.com/mos/dev"
 	"github.com/cesanta/errors"
 	"github.com/golang/glog"
@@ -86,15 +87,25 @@ func internalConfigSet(
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err := secrets.DecryptValues(paramValues, decryptAgeValue); err != nil {
+		return errors.Trace(err)
+	}
 
-	// Try to set all provided values
+	// Try to set all provided values, remembering the old ones in case the
+	// change has to be reverted
+	old := map[string]string{}
 	for path, val := range paramValues {
+		// Unset (null) values can't be read back, they're reverted to ""
+		old[path], _ = devConf.Get(path)
 		err := devConf.Set(path, val)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 
+	if *configConfirmTimeout > 0 {
+		return configSetTransaction(ctx, devConn, devConf, old)
+	}
 	return configSetAndSave(ctx, devConn, devConf)
 }
 
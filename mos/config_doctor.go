@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/history"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+// configHandler implements "mos config doctor [flag ...]", which prints the
+// effective values of the flags and where they come from: the command line,
+// the environment (MOS_<FLAG>), the project settings or the user settings.
+// By default, only the flags not at their defaults are shown; with --all,
+// all of them are.
+func configHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 || args[0] != "doctor" {
+		return errors.Errorf("usage: mos config doctor [flag ...]")
+	}
+	var flags []*flag.Flag
+	if names := args[1:]; len(names) > 0 {
+		for _, name := range names {
+			f := flag.Lookup(strings.TrimLeft(name, "-"))
+			if f == nil {
+				return errors.Errorf("unknown flag %s", name)
+			}
+			flags = append(flags, f)
+		}
+	} else {
+		flag.VisitAll(func(f *flag.Flag) {
+			if _, ok := flagSources[f.Name]; ok || *allFlag {
+				flags = append(flags, f)
+			}
+		})
+		sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FLAG\tVALUE\tSOURCE\n")
+	for _, f := range flags {
+		source, ok := flagSources[f.Name]
+		if !ok {
+			source = sourceDefault
+		}
+		fmt.Fprintf(w, "--%s\t%s\t%s\n", f.Name, redactFlagValue(f.Name, f.Value.String()), source)
+	}
+	return errors.Trace(w.Flush())
+}
+
+// redactFlagValue returns the value of the flag, or a placeholder if it's a
+// password, a key or a token.
+func redactFlagValue(name, value string) string {
+	prefix := "--" + name + "="
+	return strings.TrimPrefix(history.RedactArgs([]string{prefix + value}, nil)[0], prefix)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"cesanta.com/mos/build/secrets"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/guard"
+	"cesanta.com/mos/rollout"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	rolloutDevices       = flag.String("devices", "", "File with the list of devices, one --port value per line")
+	rolloutCanary        = flag.String("canary", "1", "Number, or percentage like 10%, of devices to apply the change to first")
+	rolloutCheckMethod   = flag.String("check-method", "Sys.GetInfo", "RPC method to call on a device to verify its health after the change")
+	rolloutCheckArgs     = flag.String("check-args", "", "Args of the health check RPC, as JSON")
+	rolloutCheckExpect   = flag.StringSlice("check-expect", nil, "path=value pairs the health check RPC response must contain")
+	rolloutCheckWait     = flag.Duration("check-wait", 10*time.Second, "Time to wait after the change before checking device health")
+	rolloutCheckAttempts = flag.Int("check-attempts", 3, "Number of health check attempts before considering the device unhealthy")
+)
+
+// rolloutDevice is a device the change was applied to, along with the values
+// it had before, to be able to revert.
+type rolloutDevice struct {
+	port string
+	old  map[string]string
+}
+
+// configRollout applies config values to the devices listed in --devices, or
+// registered devices matching --select, in stages: first to the canaries,
+// then, if they all pass the health check, to the rest. Once any device fails,
+// the rollout is aborted and all devices changed so far are reverted to their
+// previous values.
+func configRollout(ctx context.Context, devConn *dev.DevConn) error {
+	devices, err := getRolloutDevices()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	canaries, rest, err := rollout.Split(devices, *rolloutCanary)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	paramValues, err := parseParamValues(flag.Args()[1:])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := secrets.DecryptValues(paramValues, decryptAgeValue); err != nil {
+		return errors.Trace(err)
+	}
+	if len(paramValues) == 0 {
+		return errors.Errorf("at least one path.to.value=value pair should be given")
+	}
+	check, err := rollout.NewCheck(*rolloutCheckMethod, *rolloutCheckArgs, *rolloutCheckExpect)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := guardOperation(guard.OpConfigRollout, "", fmt.Sprintf("change the config of %d devices", len(devices))); err != nil {
+		return errors.Trace(err)
+	}
+
+	var done []*rolloutDevice
+	for _, stage := range []struct {
+		name    string
+		devices []string
+	}{
+		{"canary", canaries},
+		{"rollout", rest},
+	} {
+		if len(stage.devices) == 0 {
+			continue
+		}
+		reportf("Applying to %d %s device(s)...", len(stage.devices), stage.name)
+		for _, port := range stage.devices {
+			d, err := rolloutApply(ctx, port, paramValues)
+			if d != nil {
+				done = append(done, d)
+			}
+			if err == nil {
+				err = rolloutCheck(ctx, port, check)
+			}
+			if err != nil {
+				reportf("%s: %s", port, err)
+				rolloutRevert(ctx, done)
+				return errors.Errorf("%s failed on %s, rollout aborted and reverted", stage.name, port)
+			}
+			reportf("%s: OK", port)
+		}
+	}
+	reportf("Applied to all %d devices", len(devices))
+	return nil
+}
+
+func getRolloutDevices() ([]string, error) {
+	if *rolloutDevices == "" {
+		if *deviceSelect == "" {
+			return nil, errors.Errorf("either --devices or --select is required")
+		}
+		devs, err := selectDevices()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var res []string
+		for _, d := range devs {
+			res = append(res, d.Port)
+		}
+		return res, nil
+	}
+	data, err := ioutil.ReadFile(*rolloutDevices)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	devices := rollout.ParseDevices(data)
+	if len(devices) == 0 {
+		return nil, errors.Errorf("no devices in %s", *rolloutDevices)
+	}
+	return devices, nil
+}
+
+// rolloutApply applies the values to the device. If the values were changed,
+// the returned rolloutDevice is non-nil, even if there was an error later on.
+func rolloutApply(
+	ctx context.Context, port string, paramValues map[string]string,
+) (*rolloutDevice, error) {
+	reportf("%s: applying...", port)
+	devConn, err := createDevConnForPort(ctx, port, func(junk []byte) {}, func(topic string, data []byte) {})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	d := &rolloutDevice{port: port, old: map[string]string{}}
+	for path, val := range paramValues {
+		if d.old[path], err = devConf.Get(path); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := devConf.Set(path, val); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return d, errors.Trace(configSetAndSave(ctx, devConn, devConf))
+}
+
+// rolloutCheck waits for the device to settle and runs the health check.
+func rolloutCheck(ctx context.Context, port string, check *rollout.Check) error {
+	time.Sleep(*rolloutCheckWait)
+	var err error
+	for i := 0; i < *rolloutCheckAttempts; i++ {
+		if i > 0 {
+			glog.Warningf("%s: health check failed: %s", port, err)
+			time.Sleep(*rolloutCheckWait)
+		}
+		if err = rolloutCheckOnce(ctx, port, check); err == nil {
+			return nil
+		}
+	}
+	return errors.Annotatef(err, "health check failed")
+}
+
+func rolloutCheckOnce(ctx context.Context, port string, check *rollout.Check) error {
+	devConn, err := createDevConnForPort(ctx, port, func(junk []byte) {}, func(topic string, data []byte) {})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	resp, err := callDeviceService(ctx, devConn, check.Method, check.Args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(check.Verify([]byte(resp)))
+}
+
+// rolloutRevert restores previous values on the given devices, in reverse
+// order. Errors are reported, but don't stop reverting other devices.
+func rolloutRevert(ctx context.Context, devices []*rolloutDevice) {
+	for i := len(devices) - 1; i >= 0; i-- {
+		d := devices[i]
+		reportf("%s: reverting...", d.port)
+		if err := rolloutRevertDevice(ctx, d); err != nil {
+			reportf("%s: failed to revert: %s", d.port, err)
+		}
+	}
+}
+
+func rolloutRevertDevice(ctx context.Context, d *rolloutDevice) error {
+	devConn, err := createDevConnForPort(ctx, d.port, func(junk []byte) {}, func(topic string, data []byte) {})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for path, val := range d.old {
+		if err := devConf.Set(path, val); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(configSetAndSave(ctx, devConn, devConf))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/mos/conftx"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/rollout"
+	"cesanta.com/mos/rpccreds"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	configConfirmTimeout = flag.Duration("confirm-timeout", 0, "mos config-set: apply the change as a transaction, reverted unless the device is reachable again within this time")
+	configConfirmPort    = flag.String("confirm-port", "", "mos config-set --confirm-timeout: where to reach the device after the change, e.g. ws://192.168.1.5/rpc; by default, --port")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "confirm-timeout", "confirm-port")
+}
+
+// configSetTransaction saves devConf, in which the values of old were changed,
+// tentatively and waits for the device to pass the --check-method health
+// check at --confirm-port. If it does, the change is committed; otherwise, it
+// is reverted. See the conftx package for the device side.
+func configSetTransaction(
+	ctx context.Context, devConn *dev.DevConn, devConf *dev.DevConf, old map[string]string,
+) error {
+	if noSave || noReboot {
+		return errors.Errorf("--confirm-timeout can't be used with --no-save or --no-reboot")
+	}
+	check, err := rollout.NewCheck(*rolloutCheckMethod, *rolloutCheckArgs, *rolloutCheckExpect)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	confirmPort := *configConfirmPort
+	if confirmPort == "" {
+		confirmPort = port
+	}
+
+	reportf("Setting new configuration...")
+	if err := devConn.SetConfig(ctx, devConf); err != nil {
+		return errors.Trace(err)
+	}
+	argsJSON, err := json.Marshal(conftx.NewTrySaveArgs(*configConfirmTimeout))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reportf("Saving and rebooting, the change is reverted unless confirmed within %s...", *configConfirmTimeout)
+	if _, err := callDeviceService(ctx, devConn, conftx.SaveMethod, string(argsJSON)); err != nil {
+		return errors.Trace(err)
+	}
+	waitForReboot()
+	// The port may be needed for the checks and the revert
+	devConn.Disconnect(ctx)
+
+	err = conftx.WaitConfirmed(ctx, *configConfirmTimeout, time.Second, func(ctx context.Context) error {
+		return rolloutCheckOnce(ctx, confirmPort, check)
+	})
+	if err == nil {
+		reportf("Device is reachable at %s, committing...", confirmPort)
+		return errors.Trace(configCommit(ctx, confirmPort))
+	}
+
+	reportf("%s, reverting via %s...", err, port)
+	if rerr := rolloutRevertDevice(ctx, &rolloutDevice{port: port, old: old}); rerr != nil {
+		// After a network change, the device is likely not at the old
+		// address anymore
+		reportf("Failed to revert from the host, the device is not reachable at %s: %s", port, rerr)
+		reportf("Firmware supporting %s reverts on its own within %s; "+
+			"other firmware keeps the new config, which has to be fixed over serial.",
+			conftx.CommitMethod, *configConfirmTimeout)
+	}
+	return errors.Trace(err)
+}
+
+func configCommit(ctx context.Context, port string) error {
+	devConn, err := createDevConnForPort(ctx, port, func(junk []byte) {}, func(topic string, data []byte) {})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+	resp, err := devConn.RPC.Call(ctx, devConn.Dest, &frame.Command{Cmd: conftx.CommitMethod}, rpccreds.GetRPCCreds)
+	if err != nil {
+		return errors.Annotatef(err, "%s", conftx.CommitMethod)
+	}
+	switch {
+	case conftx.IsCommitUnsupported(resp.Status):
+		// Stock firmware has saved the config for good already
+		reportf("The firmware doesn't support %s, the change is saved permanently", conftx.CommitMethod)
+	case resp.Status != 0:
+		return errors.Errorf("%s: remote error %d: %s", conftx.CommitMethod, resp.Status, resp.StatusMsg)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package conftx implements config transactions: new config values are
+// applied tentatively, and unless the device is confirmed reachable within a
+// timeout, they are reverted. This protects against cutting a device off with
+// a bad wifi or mqtt change.
+//
+// The device side of a transaction is SaveMethod with TrySaveArgs: firmware
+// which supports it boots the new config once and, unless CommitMethod is
+// called within the timeout, reboots back to the old one. Firmware which
+// doesn't ignores the extra args and saves the config for good, so the old
+// values are also reverted from the host when the device can still be
+// reached via the original port, and there's nothing to commit.
+package conftx
+
+import (
+	"context"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// SaveMethod saves the config.
+	SaveMethod = "Config.Save"
+	// CommitMethod makes the tentatively saved config permanent.
+	CommitMethod = "Config.Commit"
+)
+
+// TrySaveArgs are the args of SaveMethod which start the transaction.
+type TrySaveArgs struct {
+	Reboot  bool `json:"reboot"`
+	TryOnce bool `json:"try_once"`
+	// Seconds the device waits for CommitMethod before reverting.
+	Timeout int `json:"timeout"`
+}
+
+// statusNotFound is the RPC status of calls to unknown methods.
+const statusNotFound = 404
+
+// IsCommitUnsupported returns whether the RPC status of CommitMethod means
+// the firmware doesn't know it, and so has saved the config for good.
+func IsCommitUnsupported(status int) bool {
+	return status == statusNotFound
+}
+
+// NewTrySaveArgs returns the args for a transaction with the given timeout.
+func NewTrySaveArgs(timeout time.Duration) *TrySaveArgs {
+	secs := int((timeout + time.Second - 1) / time.Second)
+	return &TrySaveArgs{Reboot: true, TryOnce: true, Timeout: secs}
+}
+
+// ErrNotConfirmed means the device didn't confirm it is reachable in time.
+var ErrNotConfirmed = errors.New("device did not confirm the config change")
+
+// WaitConfirmed calls probe every interval until it succeeds or the timeout
+// expires, in which case ErrNotConfirmed, annotated with the last probe
+// error, is returned. Each probe gets a context which expires with the
+// timeout.
+func WaitConfirmed(ctx context.Context, timeout, interval time.Duration, probe func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := probe(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(ErrNotConfirmed, "%s", err)
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package conftx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+func TestNewTrySaveArgs(t *testing.T) {
+	if a := NewTrySaveArgs(1500 * time.Millisecond); a.Timeout != 2 || !a.Reboot || !a.TryOnce {
+		t.Errorf("unexpected args %+v", a)
+	}
+}
+
+func TestIsCommitUnsupported(t *testing.T) {
+	if !IsCommitUnsupported(404) || IsCommitUnsupported(0) || IsCommitUnsupported(500) {
+		t.Errorf("only 404 means no %s", CommitMethod)
+	}
+}
+
+func TestWaitConfirmed(t *testing.T) {
+	n := 0
+	err := WaitConfirmed(context.Background(), time.Second, time.Millisecond, func(ctx context.Context) error {
+		if n++; n < 3 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Errorf("expected success on the 3rd probe, got %v after %d", err, n)
+	}
+
+	err = WaitConfirmed(context.Background(), 20*time.Millisecond, time.Millisecond, func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+	if errors.Cause(err) != ErrNotConfirmed {
+		t.Errorf("expected ErrNotConfirmed, got %v", err)
+	}
+}
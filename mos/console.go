@@ -3,14 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
+	"cesanta.com/mos/consoleenc"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/farm"
 	"cesanta.com/mos/timestamp"
 
 	"github.com/cesanta/errors"
 	"github.com/cesanta/go-serial/serial"
+	"github.com/golang/glog"
 	flag "github.com/spf13/pflag"
 )
 
@@ -21,6 +25,8 @@ var (
 	hwFC            bool
 	setControlLines bool
 	tsfSpec         string
+	consoleEncoding string
+	consoleHexdump  string
 )
 
 var (
@@ -28,7 +34,7 @@ var (
 )
 
 func init() {
-	flag.UintVar(&baudRate, "baud-rate", 115200, "Serial port speed")
+	flag.UintVar(&baudRate, "baud-rate", 115200, "Serial port speed, 0 to detect automatically")
 	flag.BoolVar(&noInput, "no-input", false,
 		"Do not read from stdin, only print device's output to stdout")
 	flag.BoolVar(&hwFC, "hw-flow-control", false, "Enable hardware flow control (CTS/RTS)")
@@ -43,7 +49,12 @@ func init() {
 
 	flag.Lookup("timestamp").NoOptDefVal = "true" // support just passing --timestamp
 
-	for _, f := range []string{"no-input", "timestamp"} {
+	flag.StringVar(&consoleEncoding, "console-encoding", consoleenc.UTF8,
+		"Encoding of the device output: utf-8, latin-1, or hex for a hex dump; control characters and invalid bytes are shown as \\xNN")
+	flag.StringVar(&consoleHexdump, "console-hexdump", "",
+		"Also write a hex dump of the raw device output to this file, or to stderr if \"-\", for debugging binary protocols")
+
+	for _, f := range []string{"no-input", "timestamp", "console-encoding", "console-hexdump"} {
 		hiddenFlags = append(hiddenFlags, f)
 	}
 }
@@ -62,30 +73,78 @@ func FormatTimestampNow() string {
 	return ts
 }
 
+// newConsoleDecoder returns the decoder of the device output for
+// --console-encoding and, if --console-hexdump is given, the writer of the
+// hex dump of the raw output, which has to be closed.
+func newConsoleDecoder() (*consoleenc.Decoder, io.WriteCloser, error) {
+	dec, err := consoleenc.New(consoleEncoding)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	switch consoleHexdump {
+	case "":
+		return dec, nil, nil
+	case "-":
+		return dec, nopWriteCloser{consoleenc.NewHexdumpWriter(os.Stderr)}, nil
+	}
+	f, err := os.Create(consoleHexdump)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return dec, hexdumpFile{consoleenc.NewHexdumpWriter(f), f}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type hexdumpFile struct {
+	*consoleenc.HexdumpWriter
+	f *os.File
+}
+
+func (h hexdumpFile) Close() error { return h.f.Close() }
+
 func console(ctx context.Context, devConn *dev.DevConn) error {
+	if *replayMock != "" {
+		return errors.Trace(replayConsole())
+	}
+	if len(allPorts) > 1 || *allFlag {
+		return errors.Trace(consoleMulti(ctx))
+	}
+
 	in, out := os.Stdin, os.Stdout
 	port, err := getPort()
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if farm.IsPort(port) {
+		return errors.Trace(farmConsole(ctx, port))
+	}
 
-	s, err := serial.Open(serial.OpenOptions{
-		PortName:            port,
-		BaudRate:            baudRate,
-		HardwareFlowControl: hwFC,
-		DataBits:            8,
-		ParityMode:          serial.PARITY_NONE,
-		StopBits:            1,
-		MinimumReadSize:     1,
-	})
+	dec, hexdump, err := newConsoleDecoder()
 	if err != nil {
-		return errors.Annotatef(err, "failed to open %s", port)
+		return errors.Trace(err)
+	}
+	if hexdump != nil {
+		defer hexdump.Close()
 	}
 
-	if setControlLines || *invertedControlLines {
-		bFalse := *invertedControlLines
-		s.SetDTR(bFalse)
-		s.SetRTS(bFalse)
+	cp, err := newConsolePort(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := cp.resume(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Let other mos processes (e.g. mos flash) use the port meanwhile
+	if h, err := registerPortHolder(port, cp.suspend, cp.resume); err == nil {
+		defer h.Close()
+	} else {
+		glog.Warningf("failed to register %s for sharing: %s", port, err)
 	}
 
 	cctx, cancel := context.WithCancel(ctx)
@@ -93,22 +152,39 @@ func console(ctx context.Context, devConn *dev.DevConn) error {
 		lineStart := true
 		for {
 			buf := make([]byte, 100)
+			s := cp.wait()
+			if s == nil {
+				out.Write(dec.Flush())
+				reportf("%s failed to reopen", port)
+				cancel()
+				return
+			}
 			n, err := s.Read(buf)
 			if n > 0 {
-				removeNonText(buf[:n])
+				if recorder != nil {
+					recorder.RecordConsole(buf[:n])
+				}
+				if hexdump != nil {
+					hexdump.Write(buf[:n])
+				}
+				text := dec.Decode(buf[:n])
 				if tsfSpec != "" {
-					for i, b := range buf[:n] {
+					for i, b := range text {
 						if lineStart {
 							fmt.Printf("%s", FormatTimestampNow())
 						}
-						out.Write(buf[i : i+1])
+						out.Write(text[i : i+1])
 						lineStart = (b == '\n')
 					}
 				} else {
-					out.Write(buf[:n])
+					out.Write(text)
 				}
 			}
 			if err != nil {
+				if cp.waitResumed(s) {
+					continue
+				}
+				out.Write(dec.Flush())
 				reportf("read err %s", err)
 				cancel()
 				return
@@ -124,7 +200,10 @@ func console(ctx context.Context, devConn *dev.DevConn) error {
 			buf := make([]byte, 1)
 			n, err := in.Read(buf)
 			if n > 0 {
-				s.Write(buf[:n])
+				// Input is dropped while the port is released
+				if s := cp.get(); s != nil {
+					s.Write(buf[:n])
+				}
 			}
 			if err != nil {
 				cancel()
@@ -136,6 +215,36 @@ func console(ctx context.Context, devConn *dev.DevConn) error {
 	return nil
 }
 
+// newConsolePort returns the console port, which is not opened yet.
+func newConsolePort(port string) (*sharedConsolePort, error) {
+	rate, err := getBaudRate(port)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return newSharedConsolePort(func() (serial.Serial, error) {
+		s, err := serial.Open(serial.OpenOptions{
+			PortName:            port,
+			BaudRate:            rate,
+			HardwareFlowControl: hwFC,
+			DataBits:            8,
+			ParityMode:          serial.PARITY_NONE,
+			StopBits:            1,
+			MinimumReadSize:     1,
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to open %s", port)
+		}
+
+		if setControlLines || *invertedControlLines {
+			bFalse := *invertedControlLines
+			s.SetDTR(bFalse)
+			s.SetRTS(bFalse)
+		}
+		return s, nil
+	}), nil
+}
+
 func removeNonText(data []byte) {
 	for i, c := range data {
 		if (c < 0x20 && c != 0x0a && c != 0x0d && c != 0x1b /* Esc */) || c >= 0x80 {
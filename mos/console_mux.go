@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cesanta.com/mos/consoleenc"
+	"cesanta.com/mos/consolemux"
+	"cesanta.com/mos/farm"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var consoleShow = flag.StringSlice("show", nil, "mos console with several ports: only show output of these devices")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "show")
+}
+
+// muxConsole is one of the consoles of consoleMulti.
+type muxConsole struct {
+	cp  *sharedConsolePort
+	src *consolemux.Source
+	dec *consoleenc.Decoder
+}
+
+// consoleMulti implements "mos console --port A --port B ..." and
+// "mos console --all": output of all the devices is interleaved line by line,
+// prefixed with device names. Input lines are sent to all devices, or, if
+// they start with "@name ", to the named one.
+func consoleMulti(ctx context.Context) error {
+	names, ports, err := getConsolePorts()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mux := consolemux.New(os.Stdout, *consoleShow)
+	if tsfSpec != "" {
+		mux.Timestamp = FormatTimestampNow
+	}
+	consoles := map[string]*muxConsole{}
+	for i, port := range ports {
+		if farm.IsPort(port) {
+			return errors.Errorf("%s: farm ports are only supported on their own", names[i])
+		}
+		cp, err := newConsolePort(port)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := cp.resume(); err != nil {
+			return errors.Trace(err)
+		}
+		if h, err := registerPortHolder(port, cp.suspend, cp.resume); err == nil {
+			defer h.Close()
+		} else {
+			glog.Warningf("failed to register %s for sharing: %s", port, err)
+		}
+		dec, err := consoleenc.New(consoleEncoding)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		consoles[names[i]] = &muxConsole{cp: cp, src: mux.NewSource(names[i]), dec: dec}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, c := range consoles {
+		wg.Add(1)
+		go func(c *muxConsole) { // Serial -> Stdout
+			defer wg.Done()
+			for {
+				buf := make([]byte, 100)
+				s := c.cp.wait()
+				if s == nil {
+					c.src.Write(c.dec.Flush())
+					c.src.Flush()
+					reportf("%s: failed to reopen the port", c.src.Name())
+					return
+				}
+				n, err := s.Read(buf)
+				if n > 0 {
+					c.src.Write(c.dec.Decode(buf[:n]))
+				}
+				if err != nil {
+					if c.cp.waitResumed(s) {
+						continue
+					}
+					c.src.Write(c.dec.Flush())
+					c.src.Flush()
+					reportf("%s: read err %s", c.src.Name(), err)
+					return
+				}
+			}
+		}(c)
+	}
+	// Stop once all the ports failed
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+	go func() { // Stdin -> Serial
+		if noInput {
+			return
+		}
+		in := bufio.NewScanner(os.Stdin)
+		for in.Scan() {
+			line := in.Text()
+			targets := consoles
+			if strings.HasPrefix(line, "@") {
+				parts := strings.SplitN(line[1:], " ", 2)
+				c := consoles[parts[0]]
+				if c == nil {
+					reportf("unknown device %q", parts[0])
+					continue
+				}
+				targets = map[string]*muxConsole{parts[0]: c}
+				line = ""
+				if len(parts) > 1 {
+					line = parts[1]
+				}
+			}
+			for _, c := range targets {
+				// Input is dropped while the port is released
+				if s := c.cp.get(); s != nil {
+					s.Write([]byte(line + "\n"))
+				}
+			}
+		}
+		cancel()
+	}()
+	<-cctx.Done()
+	return nil
+}
+
+// getConsolePorts returns names and ports of the devices given with --port,
+// which may be registered device names, or of all serial ports with --all.
+func getConsolePorts() ([]string, []string, error) {
+	var names, ports []string
+	if *allFlag {
+		for _, p := range enumerateSerialPorts() {
+			names = append(names, filepath.Base(p))
+			ports = append(ports, p)
+		}
+		if len(ports) == 0 {
+			return nil, nil, errors.Errorf("no serial ports found")
+		}
+		return names, ports, nil
+	}
+	for _, p := range allPorts {
+		port := lookupDevicePort(p)
+		if port == "" {
+			port = p
+			p = filepath.Base(p)
+		}
+		names = append(names, p)
+		ports = append(ports, port)
+	}
+	return names, ports, nil
+}
@@ -0,0 +1,210 @@
+// Package consoleenc decodes the output of devices for the terminal: text in
+// the given encoding is converted to UTF-8, and control characters and bytes
+// which are not valid text are escaped as \xNN, so that binary garbage on
+// the UART doesn't mess up the terminal. Binary output can also be shown as
+// a hex dump.
+package consoleenc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cesanta/errors"
+)
+
+// Supported encodings
+const (
+	UTF8   = "utf-8"
+	Latin1 = "latin-1"
+	// Hex shows the output as a hex dump.
+	Hex = "hex"
+)
+
+// Max bytes per hex dump line
+const hexLineLen = 16
+
+// Max length of a color sequence which is let through
+const maxSGRLen = 32
+
+// Decoder decodes the console output. Output of a device comes in chunks
+// which can split multi-byte characters, so the decoder keeps state between
+// the chunks.
+type Decoder struct {
+	enc string
+	// Start of a multi-byte character or a color sequence at the end of the
+	// last chunk
+	pending []byte
+	hex     *HexdumpWriter
+}
+
+// New returns the decoder for the given encoding.
+func New(encoding string) (*Decoder, error) {
+	switch strings.ToLower(encoding) {
+	case "utf-8", "utf8":
+		return &Decoder{enc: UTF8}, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return &Decoder{enc: Latin1}, nil
+	case "hex":
+		return &Decoder{enc: Hex, hex: &HexdumpWriter{}}, nil
+	}
+	return nil, errors.Errorf("unknown console encoding %q, expected %s, %s or %s", encoding, UTF8, Latin1, Hex)
+}
+
+// Decode returns the text to show for the chunk of output.
+func (d *Decoder) Decode(data []byte) []byte {
+	var b bytes.Buffer
+	switch d.enc {
+	case Hex:
+		d.hex.w = &b
+		d.hex.Write(data)
+	default:
+		data = append(d.pending, data...)
+		d.pending = nil
+		for len(data) > 0 {
+			if data[0] == 0x1b {
+				n, incomplete := sgrLen(data)
+				if incomplete {
+					d.pending = append([]byte(nil), data...)
+					break
+				}
+				if n > 0 {
+					b.Write(data[:n])
+				} else {
+					escape(&b, data[0])
+					n = 1
+				}
+				data = data[n:]
+				continue
+			}
+			if d.enc == Latin1 {
+				if c := data[0]; isControl(rune(c)) {
+					escape(&b, c)
+				} else {
+					b.WriteRune(rune(c))
+				}
+				data = data[1:]
+				continue
+			}
+			r, size := utf8.DecodeRune(data)
+			if r == utf8.RuneError && size <= 1 {
+				if !utf8.FullRune(data) {
+					// The rest of the character is in the next chunk
+					d.pending = append([]byte(nil), data...)
+					break
+				}
+				escape(&b, data[0])
+			} else if isControl(r) {
+				escape(&b, data[0])
+			} else {
+				b.Write(data[:size])
+			}
+			data = data[size:]
+		}
+	}
+	return b.Bytes()
+}
+
+// Flush returns the text for the incomplete character or color sequence at
+// the end of the output, if any: it's escaped.
+func (d *Decoder) Flush() []byte {
+	var b bytes.Buffer
+	if len(d.pending) > 0 && d.pending[0] == 0x1b {
+		// The rest of an incomplete color sequence is printable
+		escape(&b, d.pending[0])
+		b.Write(d.pending[1:])
+	} else {
+		for _, c := range d.pending {
+			escape(&b, c)
+		}
+	}
+	d.pending = nil
+	return b.Bytes()
+}
+
+// isControl returns whether the character would be interpreted by the
+// terminal rather than shown. Line breaks and tabs are let through; escape is
+// only let through as the start of a color sequence, see sgrLen.
+func isControl(r rune) bool {
+	switch r {
+	case '\n', '\r', '\t':
+		return false
+	}
+	return r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0)
+}
+
+// sgrLen returns the length of the color (SGR) sequence, "ESC [ params m"
+// with only digits and semicolons as the params, at the start of data, which
+// starts with ESC; 0 if it's not one, or whether data ends before it does.
+// Devices use these in logs; other escape sequences can move the cursor,
+// change the title or remap keys, so they are escaped.
+func sgrLen(data []byte) (int, bool) {
+	for i := 1; i < len(data) && i < maxSGRLen; i++ {
+		c := data[i]
+		switch {
+		case i == 1:
+			if c != '[' {
+				return 0, false
+			}
+		case c == 'm':
+			return i + 1, false
+		case (c < '0' || c > '9') && c != ';':
+			return 0, false
+		}
+	}
+	return 0, len(data) < maxSGRLen
+}
+
+func escape(b *bytes.Buffer, c byte) {
+	fmt.Fprintf(b, `\x%02x`, c)
+}
+
+// HexdumpWriter writes the data written to it as a hex dump, like
+// "hexdump -C", to the underlying writer. Each write is dumped right away,
+// so a line has less than 16 bytes if the write is short; offsets continue
+// across the writes.
+type HexdumpWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+// NewHexdumpWriter returns the writer which writes hex dumps to w.
+func NewHexdumpWriter(w io.Writer) *HexdumpWriter {
+	return &HexdumpWriter{w: w}
+}
+
+func (h *HexdumpWriter) Write(data []byte) (int, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(data); i += hexLineLen {
+		line := data[i:]
+		if len(line) > hexLineLen {
+			line = line[:hexLineLen]
+		}
+		fmt.Fprintf(&b, "%08x ", h.offset)
+		for j := 0; j < hexLineLen; j++ {
+			if j == hexLineLen/2 {
+				b.WriteByte(' ')
+			}
+			if j < len(line) {
+				fmt.Fprintf(&b, " %02x", line[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range line {
+			if c < 0x20 || c >= 0x7f {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+		h.offset += int64(len(line))
+	}
+	if _, err := h.w.Write(b.Bytes()); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return len(data), nil
+}
@@ -0,0 +1,71 @@
+package consoleenc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func decodeChunks(t *testing.T, enc string, chunks ...string) string {
+	d, err := New(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []byte
+	for _, c := range chunks {
+		res = append(res, d.Decode([]byte(c))...)
+	}
+	return string(append(res, d.Flush()...))
+}
+
+func TestUTF8(t *testing.T) {
+	for _, c := range []struct {
+		chunks []string
+		exp    string
+	}{
+		{[]string{"hello\r\n"}, "hello\r\n"},
+		{[]string{"temp 25\xc2\xb0C\n"}, "temp 25°C\n"},
+		// Multi-byte character split between the chunks
+		{[]string{"Gr\xc3", "\xbc\xc3\x9fe"}, "Grüße"},
+		{[]string{"a\x00b\x07c\xffd"}, `a\x00b\x07c\xffd`},
+		{[]string{"\x1b[31mred\x1b[0m"}, "\x1b[31mred\x1b[0m"},
+		{[]string{"\x1b[1;3", "2mbold"}, "\x1b[1;32mbold"},
+		// Other escape sequences are escaped
+		{[]string{"\x1b]0;title\x07\x1b[2J\x1bc"}, `\x1b]0;title\x07\x1b[2J\x1bc`},
+		{[]string{"\x1b[31"}, `\x1b[31`},
+		// Incomplete character at the end
+		{[]string{"x\xe2\x82"}, `x\xe2\x82`},
+	} {
+		if got := decodeChunks(t, "utf-8", c.chunks...); got != c.exp {
+			t.Errorf("%q: expected %q, got %q", c.chunks, c.exp, got)
+		}
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	if got, exp := decodeChunks(t, "latin1", "25\xb0C caf\xe9\x85\x01\n"), `25°C café\x85\x01`+"\n"; got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+	if got, exp := decodeChunks(t, "latin1", "\x1b[0", "m\x1b[H"), "\x1b[0m"+`\x1b[H`; got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestHex(t *testing.T) {
+	exp := "00000000  48 65 6c 6c 6f 00 01 02  03 04 05 06 07 08 09 0a  |Hello...........|\n" +
+		"00000010  ff                                                |.|\n" +
+		"00000011  41 42                                             |AB|\n"
+	if got := decodeChunks(t, "hex", "Hello\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\xff", "AB"); got != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, got)
+	}
+	var b bytes.Buffer
+	NewHexdumpWriter(&b).Write([]byte("AB"))
+	if got, exp := b.String(), "00000000  41 42                                             |AB|\n"; got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	if _, err := New("ebcdic"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,114 @@
+// Package consolemux interleaves console output of several devices line by
+// line, prefixing each line with the device name in its own color.
+package consolemux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+var colors = []color.Attribute{
+	color.FgGreen, color.FgYellow, color.FgCyan, color.FgMagenta, color.FgBlue, color.FgRed,
+}
+
+// Mux writes lines of its sources to the output, whole lines at a time, so
+// that output of different devices doesn't mix within a line.
+type Mux struct {
+	// Timestamp, if set, returns the string to put before each line.
+	Timestamp func() string
+
+	mu    sync.Mutex
+	out   io.Writer
+	show  map[string]bool
+	width int
+	n     int
+}
+
+// New returns a mux writing to out. If show is not empty, only lines of the
+// sources with these names are written.
+func New(out io.Writer, show []string) *Mux {
+	m := &Mux{out: out}
+	if len(show) > 0 {
+		m.show = map[string]bool{}
+		for _, s := range show {
+			m.show[s] = true
+		}
+	}
+	return m
+}
+
+// Source is the output of one device.
+type Source struct {
+	m      *Mux
+	name   string
+	hidden bool
+	color  *color.Color
+	buf    []byte
+}
+
+// NewSource adds a source. Names of all sources should be added before any
+// output, to align the prefixes.
+func (m *Mux) NewSource(name string) *Source {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(name) > m.width {
+		m.width = len(name)
+	}
+	s := &Source{m: m, name: name, hidden: m.show != nil && !m.show[name]}
+	s.color = color.New(colors[m.n%len(colors)])
+	m.n++
+	return s
+}
+
+// Name returns the name of the source.
+func (s *Source) Name() string {
+	return s.name
+}
+
+// Write buffers data and writes out complete lines. Carriage returns are
+// dropped, every line is terminated with "\r\n" for terminals in raw mode.
+func (s *Source) Write(data []byte) (int, error) {
+	s.buf = append(s.buf, data...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(s.buf[:i], "\r")
+		if err := s.writeLine(line); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes out the incomplete last line, if any.
+func (s *Source) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.writeLine(s.buf)
+	s.buf = nil
+	return err
+}
+
+func (s *Source) writeLine(line []byte) error {
+	if s.hidden {
+		return nil
+	}
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := ""
+	if m.Timestamp != nil {
+		ts = m.Timestamp()
+	}
+	prefix := s.color.Sprintf("%-*s |", m.width, s.name)
+	_, err := fmt.Fprintf(m.out, "%s%s %s\r\n", ts, prefix, line)
+	return err
+}
@@ -0,0 +1,36 @@
+package consolemux
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestMux(t *testing.T) {
+	color.NoColor = true
+	var out bytes.Buffer
+	m := New(&out, nil)
+	a, b := m.NewSource("a"), m.NewSource("dev2")
+	a.Write([]byte("hel"))
+	b.Write([]byte("one\r\ntw"))
+	a.Write([]byte("lo\n"))
+	b.Flush()
+	want := "dev2 | one\r\na    | hello\r\ndev2 | tw\r\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMuxShow(t *testing.T) {
+	color.NoColor = true
+	var out bytes.Buffer
+	m := New(&out, []string{"b"})
+	m.Timestamp = func() string { return "[ts] " }
+	a, b := m.NewSource("a"), m.NewSource("b")
+	a.Write([]byte("hidden\n"))
+	b.Write([]byte("shown\n"))
+	if got, want := out.String(), "[ts] b | shown\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
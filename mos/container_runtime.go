@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	containerRuntimeFlag = flag.String("container-runtime", "auto", `Container runtime for local builds: "docker", "podman" or "auto" (docker if available, podman otherwise)`)
+
+	// Resolved container runtime, see getContainerRuntime
+	containerRuntimeName string
+)
+
+// Supported container runtimes; they have compatible command line interfaces.
+const (
+	containerRuntimeDocker = "docker"
+	containerRuntimePodman = "podman"
+)
+
+// getContainerRuntime returns the name of the container runtime binary to
+// use.
+func getContainerRuntime() (string, error) {
+	if containerRuntimeName != "" {
+		return containerRuntimeName, nil
+	}
+
+	switch *containerRuntimeFlag {
+	case "auto":
+		for _, rt := range []string{containerRuntimeDocker, containerRuntimePodman} {
+			if _, err := exec.LookPath(rt); err == nil {
+				containerRuntimeName = rt
+				return rt, nil
+			}
+		}
+		return "", errors.Errorf("neither docker nor podman is found, please install one of them, " +
+			"or use remote build (without --local)")
+	case containerRuntimeDocker, containerRuntimePodman:
+		if _, err := exec.LookPath(*containerRuntimeFlag); err != nil {
+			return "", errors.Annotatef(err, "container runtime %q is not found", *containerRuntimeFlag)
+		}
+		containerRuntimeName = *containerRuntimeFlag
+		return containerRuntimeName, nil
+	}
+
+	return "", errors.Errorf("unknown container runtime %q, expected docker, podman or auto", *containerRuntimeFlag)
+}
+
+// isRootlessContainerRuntime returns whether the container runtime runs
+// without root privileges, in which case root inside the container is mapped
+// to the current user on the host.
+func isRootlessContainerRuntime(rt string) bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	switch rt {
+	case containerRuntimePodman:
+		return os.Getuid() != 0
+	case containerRuntimeDocker:
+		out, err := exec.Command(rt, "info", "--format", "{{json .SecurityOptions}}").Output()
+		return err == nil && strings.Contains(string(out), "rootless")
+	}
+	return false
+}
+
+// getContainerUserArgs returns the container runtime arguments which make the
+// files created in mounted volumes owned by the current user.
+func getContainerUserArgs(rt string) ([]string, error) {
+	var ret []string
+
+	// On Fedora/RHEL, SELinux denies access to volumes unless they are
+	// relabeled; instead of relabeling user's files, disable labeling for the
+	// build container.
+	if rt == containerRuntimePodman {
+		ret = append(ret, "--security-opt", "label=disable")
+	}
+
+	// On Windows and Mac, run container as root since volume sharing on those
+	// OSes doesn't play nice with unprivileged user.
+	//
+	// On other OSes, run it as the current user.
+	if runtime.GOOS != "linux" {
+		return ret, nil
+	}
+
+	if isRootlessContainerRuntime(rt) {
+		if rt == containerRuntimePodman {
+			// Map the current user to the same UID inside the container
+			ret = append(ret, "--userns=keep-id")
+		}
+		// Rootless docker: root inside the container is the current user
+		// outside, so nothing else to do.
+		return ret, nil
+	}
+
+	// Unfortunately, user.Current() sometimes panics when the mos binary is
+	// built statically, so we have to do the trick with "id -u". Since this
+	// code runs on Linux only, this workaround does the trick.
+	out, err := exec.Command("id", "-u").Output()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	userID := strings.TrimSpace(string(out))
+	ret = append(ret, "--user", userID+":"+userID)
+
+	return ret, nil
+}
@@ -0,0 +1,283 @@
+// Package credstore keeps per-device secrets, like RPC credentials, in the OS
+// keychain when there is one, or in an encrypted file otherwise.
+package credstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Service is the name secrets are filed under in the keychain.
+const Service = "mos-rpc"
+
+// ErrNotFound is returned by Get if there is no secret for the device.
+var ErrNotFound = errors.New("no stored credentials")
+
+// Store keeps secrets by device.
+type Store interface {
+	Get(device string) (string, error)
+	Set(device, secret string) error
+	Delete(device string) error
+	// Name describes where the secrets are kept.
+	Name() string
+}
+
+// New returns the OS keychain store if the keychain tool is available, or
+// the file store with the given file names otherwise.
+func New(fname, keyFname string) Store {
+	if s := NewKeychain(); s != nil {
+		return s
+	}
+	return NewFileStore(fname, keyFname)
+}
+
+// NewKeychain returns the store in the OS keychain: the macOS keychain via
+// security(1), or the Secret Service (GNOME Keyring, KWallet) via
+// secret-tool(1). Returns nil if there is neither.
+func NewKeychain() Store {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return macKeychain{}
+		}
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretService{}
+		}
+	}
+	return nil
+}
+
+type macKeychain struct{}
+
+func (macKeychain) Name() string {
+	return "macOS keychain"
+}
+
+func (macKeychain) Get(device string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", Service, "-a", device, "-w").Output()
+	if err != nil {
+		// security exits with 44 if the item is not found, treat any failure
+		// as that, so that the user is asked to log in
+		return "", ErrNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (k macKeychain) Set(device, secret string) error {
+	if strings.ContainsAny(secret, "\r\n") {
+		return errors.Errorf("the secret can't contain line breaks")
+	}
+	// The command is read from stdin in the interactive mode, so that the
+	// secret doesn't show up in ps
+	cmd := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		securityQuote(Service), securityQuote(device), securityQuote(secret))
+	if err := runTool(strings.NewReader(cmd), "security", "-i"); err != nil {
+		return errors.Trace(err)
+	}
+	// The interactive mode doesn't fail if the command does, so check
+	if s, err := k.Get(device); err != nil || s != secret {
+		return errors.Errorf("failed to store the secret in the keychain")
+	}
+	return nil
+}
+
+// securityQuote quotes the argument for the interactive mode of security(1).
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (macKeychain) Delete(device string) error {
+	return errors.Trace(runTool(nil, "security", "delete-generic-password", "-s", Service, "-a", device))
+}
+
+type secretService struct{}
+
+func (secretService) Name() string {
+	return "Secret Service keyring"
+}
+
+func (secretService) Get(device string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", Service, "device", device).Output()
+	if err != nil || len(out) == 0 {
+		return "", ErrNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (secretService) Set(device, secret string) error {
+	// The secret is read from stdin, so that it doesn't show up in ps
+	return errors.Trace(runTool(strings.NewReader(secret),
+		"secret-tool", "store", "--label", "mos RPC credentials for "+device, "service", Service, "device", device))
+}
+
+func (secretService) Delete(device string) error {
+	return errors.Trace(runTool(nil, "secret-tool", "clear", "service", Service, "device", device))
+}
+
+func runTool(stdin *strings.Reader, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Annotatef(err, "%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// FileStore keeps the secrets in a JSON file encrypted with AES-256-GCM. The
+// key is in a separate file, created on first use; both are only readable by
+// the user. This keeps the secrets out of backups and accidental copies of
+// the file, but not from someone who can read both files.
+type FileStore struct {
+	fname    string
+	keyFname string
+}
+
+// NewFileStore returns the store in the given file, encrypted with the key
+// in keyFname.
+func NewFileStore(fname, keyFname string) *FileStore {
+	return &FileStore{fname: fname, keyFname: keyFname}
+}
+
+func (fs *FileStore) Name() string {
+	return fs.fname
+}
+
+func (fs *FileStore) Get(device string) (string, error) {
+	m, err := fs.load()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	secret, ok := m[device]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (fs *FileStore) Set(device, secret string) error {
+	m, err := fs.load()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	m[device] = secret
+	return errors.Trace(fs.save(m))
+}
+
+func (fs *FileStore) Delete(device string) error {
+	m, err := fs.load()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, ok := m[device]; !ok {
+		return ErrNotFound
+	}
+	delete(m, device)
+	return errors.Trace(fs.save(m))
+}
+
+// Devices returns the devices there are secrets for, sorted.
+func (fs *FileStore) Devices() ([]string, error) {
+	m, err := fs.load()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var res []string
+	for d := range m {
+		res = append(res, d)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (fs *FileStore) getAEAD(create bool) (cipher.AEAD, error) {
+	key, err := ioutil.ReadFile(fs.keyFname)
+	if os.IsNotExist(err) && create {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(fs.keyFname), 0700); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(fs.keyFname, key, 0600); err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("%s: invalid key size %d", fs.keyFname, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return aead, nil
+}
+
+func (fs *FileStore) load() (map[string]string, error) {
+	m := map[string]string{}
+	data, err := ioutil.ReadFile(fs.fname)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := fs.getAEAD(false)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to get the key for %s", fs.fname)
+	}
+	ns := aead.NonceSize()
+	if len(data) < ns {
+		return nil, errors.Errorf("%s is corrupted", fs.fname)
+	}
+	plain, err := aead.Open(nil, data[:ns], data[ns:], nil)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to decrypt %s", fs.fname)
+	}
+	if err := json.Unmarshal(plain, &m); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return m, nil
+}
+
+func (fs *FileStore) save(m map[string]string) error {
+	plain, err := json.Marshal(m)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	aead, err := fs.getAEAD(true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fs.fname), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(fs.fname, aead.Seal(nonce, nonce, plain, nil), 0600))
+}
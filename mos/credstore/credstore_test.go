@@ -0,0 +1,73 @@
+package credstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cesanta/errors"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "creds")
+	s := NewFileStore(fname, filepath.Join(dir, "creds.key"))
+
+	if _, err := s.Get("dev1"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if err := s.Set("ws://10.0.0.1/rpc", "admin:secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("dev2", "user:pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened
+	s = NewFileStore(fname, filepath.Join(dir, "creds.key"))
+	got, err := s.Get("ws://10.0.0.1/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "admin:secret" {
+		t.Errorf("got %q", got)
+	}
+	devices, err := s.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[0] != "dev2" {
+		t.Errorf("got devices %q", devices)
+	}
+
+	// Not in plain text on disk
+	data, _ := ioutil.ReadFile(fname)
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("secret stored in plain text")
+	}
+
+	if err := s.Delete("dev2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("dev2"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("got %v after delete, want ErrNotFound", err)
+	}
+
+	// Wrong key
+	ioutil.WriteFile(filepath.Join(dir, "other.key"), bytes.Repeat([]byte{1}, 32), 0600)
+	if _, err := NewFileStore(fname, filepath.Join(dir, "other.key")).Get("dev1"); err == nil {
+		t.Errorf("decrypted with a wrong key")
+	}
+}
+
+func TestSecurityQuote(t *testing.T) {
+	if got, exp := securityQuote(`p"a\ss word`), `"p\"a\\ss word"`; got != exp {
+		t.Errorf("expected %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/cron"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+// cronJob is a job as returned by Crontab.List
+type cronJob struct {
+	ID      int             `json:"id"`
+	At      string          `json:"at"`
+	Enable  *bool           `json:"enable,omitempty"`
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func cronHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: list, add or remove")
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	switch args[0] {
+	case "list":
+		return errors.Trace(cronList(ctx, devConn))
+	case "add":
+		return errors.Trace(cronAdd(ctx, devConn, args[1:]))
+	case "remove":
+		return errors.Trace(cronRemove(ctx, devConn, args[1:]))
+	}
+
+	return errors.Errorf("unknown command %q, expected list, add or remove", args[0])
+}
+
+func cronList(ctx context.Context, devConn *dev.DevConn) error {
+	res, err := callDeviceService(ctx, devConn, "Crontab.List", "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var jobs []cronJob
+	if err := json.Unmarshal([]byte(res), &jobs); err != nil {
+		// Some versions of the crontab lib wrap the list into an object
+		var wrapped struct {
+			Jobs []cronJob `json:"jobs"`
+		}
+		if err2 := json.Unmarshal([]byte(res), &wrapped); err2 != nil {
+			return errors.Annotatef(err, "unexpected Crontab.List response: %s", res)
+		}
+		jobs = wrapped.Jobs
+	}
+
+	if len(jobs) == 0 {
+		ourutil.Reportf("No cron jobs")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tAT\tENABLED\tACTION\tPAYLOAD\n")
+	for _, j := range jobs {
+		enabled := "yes"
+		if j.Enable != nil && !*j.Enable {
+			enabled = "no"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", j.ID, j.At, enabled, j.Action, string(j.Payload))
+	}
+	return errors.Trace(w.Flush())
+}
+
+// cronAdd adds a job: mos cron add <expr> <action> [payload]
+func cronAdd(ctx context.Context, devConn *dev.DevConn, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.Errorf(`usage: mos cron add "<expr>" <action> [payload JSON]`)
+	}
+
+	expr, action := args[0], args[1]
+	if err := cron.Validate(expr); err != nil {
+		return errors.Annotatef(err, "invalid cron expression %q", expr)
+	}
+
+	params := map[string]interface{}{
+		"at":     expr,
+		"action": action,
+	}
+	if len(args) == 3 {
+		if !isJSON(args[2]) {
+			return errors.Errorf("payload [%s] is not a valid JSON string", args[2])
+		}
+		params["payload"] = json.RawMessage(args[2])
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	res, err := callDeviceService(ctx, devConn, "Crontab.Add", string(data))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(res), &resp); err != nil {
+		return errors.Annotatef(err, "unexpected Crontab.Add response: %s", res)
+	}
+
+	ourutil.Reportf("Added cron job %d", resp.ID)
+	return nil
+}
+
+// cronRemove removes a job: mos cron remove <id>
+func cronRemove(ctx context.Context, devConn *dev.DevConn, args []string) error {
+	if len(args) != 1 {
+		return errors.Errorf("usage: mos cron remove <id>")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.Errorf("invalid job id %q", args[0])
+	}
+
+	if _, err := callDeviceService(ctx, devConn, "Crontab.Remove", fmt.Sprintf(`{"id": %d}`, id)); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Removed cron job %d", id)
+	return nil
+}
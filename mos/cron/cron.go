@@ -0,0 +1,150 @@
+// Package cron validates cron expressions in the format understood by the
+// crontab library on the device.
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names []string
+	// Whether "?" is allowed in this field
+	anyOK bool
+}
+
+var fields = []field{
+	{name: "seconds", min: 0, max: 59},
+	{name: "minutes", min: 0, max: 59},
+	{name: "hours", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31, anyOK: true},
+	{name: "month", min: 1, max: 12, names: []string{
+		"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC",
+	}},
+	{name: "day of week", min: 0, max: 7, anyOK: true, names: []string{
+		"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT",
+	}},
+}
+
+// Validate checks the cron expression. Expressions can have one of the
+// following forms:
+//
+//	<sec> <min> <hour> <day of month> <month> <day of week>
+//	@sunrise[+-offset] [<day of month> <month> <day of week>]
+//	@sunset[+-offset] [<day of month> <month> <day of week>]
+//
+// where offset is a duration like "1h30m".
+func Validate(expr string) error {
+	parts := strings.Fields(expr)
+	if len(parts) == 0 {
+		return errors.Errorf("empty cron expression")
+	}
+
+	if strings.HasPrefix(parts[0], "@") {
+		if err := validateSunEvent(parts[0]); err != nil {
+			return errors.Trace(err)
+		}
+		switch len(parts) {
+		case 1:
+			return nil
+		case 4:
+			return errors.Trace(validateFields(parts[1:], fields[3:]))
+		}
+		return errors.Errorf("%s must be followed by either none or 3 fields (day of month, month, day of week), got %d", parts[0], len(parts)-1)
+	}
+
+	if len(parts) != len(fields) {
+		return errors.Errorf("expected %d fields (seconds, minutes, hours, day of month, month, day of week), got %d", len(fields), len(parts))
+	}
+	return errors.Trace(validateFields(parts, fields))
+}
+
+func validateSunEvent(s string) error {
+	for _, ev := range []string{"@sunrise", "@sunset"} {
+		if !strings.HasPrefix(s, ev) {
+			continue
+		}
+		offset := s[len(ev):]
+		if offset == "" {
+			return nil
+		}
+		if offset[0] != '+' && offset[0] != '-' {
+			return errors.Errorf("invalid offset %q, expected something like +1h30m", offset)
+		}
+		if _, err := time.ParseDuration(offset[1:]); err != nil {
+			return errors.Errorf("invalid offset %q: %s", offset, err)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown special expression %q, expected @sunrise or @sunset", s)
+}
+
+func validateFields(parts []string, ff []field) error {
+	for i, p := range parts {
+		if err := validateField(p, ff[i]); err != nil {
+			return errors.Annotatef(err, "%s", ff[i].name)
+		}
+	}
+	return nil
+}
+
+func validateField(s string, f field) error {
+	if s == "?" {
+		if !f.anyOK {
+			return errors.Errorf("\"?\" is only allowed for day of month and day of week")
+		}
+		return nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, ""
+		if i := strings.Index(item, "/"); i >= 0 {
+			rng, step = item[:i], item[i+1:]
+			n, err := strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return errors.Errorf("invalid step %q", step)
+			}
+		}
+
+		if rng == "*" {
+			continue
+		}
+
+		bounds := strings.SplitN(rng, "-", 2)
+		var vals []int
+		for _, b := range bounds {
+			v, err := parseValue(b, f)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			vals = append(vals, v)
+		}
+		if len(vals) == 2 && vals[0] > vals[1] {
+			return errors.Errorf("invalid range %q", rng)
+		}
+	}
+
+	return nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	for i, n := range f.names {
+		if strings.EqualFold(s, n) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, errors.Errorf("value %d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,43 @@
+package cron
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"0 */5 * * * *",
+		"0 0 9 * * MON-FRI",
+		"30 15 10 1,15 JAN-jun ?",
+		"0 0 0-12/2 * * 0,7",
+		"@sunrise",
+		"@sunset-1h30m",
+		"@sunrise+15m * * SAT,SUN",
+	}
+	for _, expr := range valid {
+		if err := Validate(expr); err != nil {
+			t.Errorf("%q: unexpected error: %s", expr, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * * *",
+		"60 * * * * *",
+		"0 0 24 * * *",
+		"0 0 0 0 * *",
+		"0 0 0 * 13 *",
+		"0 0 0 * * FOO",
+		"? * * * * *",
+		"0 0 5-1 * * *",
+		"*/0 * * * * *",
+		"@noon",
+		"@sunset 10m",
+		"@sunrise * *",
+	}
+	for _, expr := range invalid {
+		if err := Validate(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
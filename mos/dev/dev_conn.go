@@ -34,6 +34,11 @@ type DevConn struct {
 	Reconnect   bool
 	codecOpts   codec.Options
 
+	// If set, wraps every RPC channel created by Connect, e.g. for recording
+	RPCWrapper func(mgrpc.MgRPC) mgrpc.MgRPC
+	// If set, used instead of connecting anywhere
+	fixedRPC mgrpc.MgRPC
+
 	CConf       fwconfig.Service
 	CSys        fwsys.Service
 	CFilesystem fwfilesystem.Service
@@ -155,6 +160,11 @@ func (dc *DevConn) ConnectWithOpts(ctx context.Context, reconnect bool, tlsConfi
 		return nil
 	}
 
+	if dc.fixedRPC != nil {
+		dc.SetRPC(dc.fixedRPC)
+		return nil
+	}
+
 	if codecOpts != nil {
 		dc.codecOpts = *codecOpts
 	}
@@ -166,13 +176,30 @@ func (dc *DevConn) ConnectWithOpts(ctx context.Context, reconnect bool, tlsConfi
 		mgrpc.CodecOptions(dc.codecOpts),
 	}
 
-	dc.RPC, err = mgrpc.New(ctx, dc.ConnectAddr, opts...)
+	rpc, err := mgrpc.New(ctx, dc.ConnectAddr, opts...)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if dc.RPCWrapper != nil {
+		rpc = dc.RPCWrapper(rpc)
+	}
 
+	dc.SetRPC(rpc)
+	return nil
+}
+
+// SetRPC makes the connection use the given RPC channel.
+func (dc *DevConn) SetRPC(rpc mgrpc.MgRPC) {
+	dc.RPC = rpc
 	dc.CConf = fwconfig.NewClient(dc.RPC, debugDevId, rpccreds.GetRPCCreds)
 	dc.CSys = fwsys.NewClient(dc.RPC, debugDevId, rpccreds.GetRPCCreds)
 	dc.CFilesystem = fwfilesystem.NewClient(dc.RPC, debugDevId, rpccreds.GetRPCCreds)
-	return nil
+}
+
+// NewDevConnWithRPC returns the connection which uses the given RPC channel
+// instead of connecting anywhere.
+func NewDevConnWithRPC(rpc mgrpc.MgRPC) *DevConn {
+	dc := &DevConn{c: &Client{}, Dest: debugDevId, fixedRPC: rpc}
+	dc.SetRPC(rpc)
+	return dc
 }
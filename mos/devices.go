@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/devreg"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	deviceRegistryFile = flag.String("device-registry", "~/.mos/devices.yml", "File with the registry of devices")
+	deviceSelect       = flag.String("select", "", `Tag expression selecting registered devices, like "site:lab && hw:rev2"`)
+	deviceTags         = flag.StringSlice("tags", nil, "Tags of the device, like site:lab,hw:rev2")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "device-registry")
+}
+
+func loadDeviceRegistry() (*devreg.Registry, string, error) {
+	fname, err := paths.NormalizePath(*deviceRegistryFile, "")
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	r, err := devreg.Load(fname)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	return r, fname, nil
+}
+
+// selectDevices returns devices from the registry matching --select.
+func selectDevices() ([]*devreg.Device, error) {
+	r, _, err := loadDeviceRegistry()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	devs, err := r.Select(*deviceSelect)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(devs) == 0 {
+		return nil, errors.Errorf("no registered devices match %q", *deviceSelect)
+	}
+	return devs, nil
+}
+
+// lookupDevicePort returns the port of the registered device with the given
+// name, or an empty string.
+func lookupDevicePort(name string) string {
+	r, _, err := loadDeviceRegistry()
+	if err != nil {
+		glog.Warningf("failed to load device registry: %s", err)
+		return ""
+	}
+	if d := r.Get(name); d != nil {
+		return d.Port
+	}
+	return ""
+}
+
+func devicesHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: list, add, remove, tag, untag or sync")
+	}
+
+	r, fname, err := loadDeviceRegistry()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	switch args[0] {
+	case "list":
+		return errors.Trace(devicesList(r))
+	case "add":
+		if len(args) != 3 {
+			return errors.Errorf("usage: mos devices add <name> <port> [--tags tag1,tag2]")
+		}
+		r.Add(args[1], args[2], *deviceTags)
+	case "remove":
+		if len(args) != 2 {
+			return errors.Errorf("usage: mos devices remove <name>")
+		}
+		if err := r.Remove(args[1]); err != nil {
+			return errors.Trace(err)
+		}
+	case "tag", "untag":
+		if len(args) < 3 {
+			return errors.Errorf("usage: mos devices %s <name> <tag>...", args[0])
+		}
+		d := r.Get(args[1])
+		if d == nil {
+			return errors.Errorf("no device %q", args[1])
+		}
+		if args[0] == "tag" {
+			r.Tag(d, args[2:])
+		} else {
+			r.Untag(d, args[2:])
+		}
+	case "sync":
+		total, added, err := devicesSync(r)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reportDevicesSync(total, added)
+	default:
+		return errors.Errorf("unknown command %q, expected list, add, remove, tag, untag or sync", args[0])
+	}
+
+	if err := r.Save(fname); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Saved %s", fname)
+	return nil
+}
+
+func devicesList(r *devreg.Registry) error {
+	devs, err := r.Select(*deviceSelect)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(devs) == 0 {
+		ourutil.Reportf("No devices")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tPORT\tTAGS\tCLOUD TAGS\n")
+	for _, d := range devs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, d.Port, strings.Join(d.Tags, ","), strings.Join(d.CloudTags, ","))
+	}
+	return errors.Trace(w.Flush())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/devreg"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	devicesSyncFrom = flag.String("from", "", "mos devices sync: cloud registry to import devices from: aws-iot, azure or gcp")
+	azureIoTHub     = flag.String("azure-iot-hub", "", "Azure IoT Hub name")
+)
+
+// devicesSync imports devices from the cloud registry into the local one.
+// Cloud attributes become "key:value" tags, groups become "group:name" tags,
+// and every device is tagged with "cloud:<name>".
+func devicesSync(r *devreg.Registry) (int, int, error) {
+	var devs []*devreg.Device
+	var err error
+	switch *devicesSyncFrom {
+	case "aws-iot":
+		devs, err = getAWSIoTDevices()
+	case "azure":
+		devs, err = getAzureDevices()
+	case "gcp":
+		devs, err = getGCPDevices()
+	case "":
+		return 0, 0, errors.Errorf("--from is required: aws-iot, azure or gcp")
+	default:
+		return 0, 0, errors.Errorf("unknown cloud registry %q, expected aws-iot, azure or gcp", *devicesSyncFrom)
+	}
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "failed to get devices from %s", *devicesSyncFrom)
+	}
+	return len(devs), r.Sync(devs), nil
+}
+
+func attrTags(attrs map[string]string) []string {
+	var res []string
+	for k, v := range attrs {
+		res = append(res, fmt.Sprintf("%s:%s", k, v))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getAWSIoTDevices returns AWS IoT things. They are reachable via the MQTT
+// endpoint of the account, so ports are set accordingly.
+func getAWSIoTDevices() ([]*devreg.Device, error) {
+	iotSvc, err := getSvc()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ep, err := iotSvc.DescribeEndpoint(&iot.DescribeEndpointInput{})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var res []*devreg.Device
+	byName := map[string]*devreg.Device{}
+	err = iotSvc.ListThingsPages(&iot.ListThingsInput{}, func(out *iot.ListThingsOutput, last bool) bool {
+		for _, t := range out.Things {
+			name := aws.StringValue(t.ThingName)
+			attrs := map[string]string{}
+			for k, v := range t.Attributes {
+				attrs[k] = aws.StringValue(v)
+			}
+			d := &devreg.Device{
+				Name:      name,
+				Port:      fmt.Sprintf("mqtts://%s:8883/%s", aws.StringValue(ep.EndpointAddress), name),
+				CloudTags: append(attrTags(attrs), "cloud:aws"),
+			}
+			if t.ThingTypeName != nil {
+				d.CloudTags = append(d.CloudTags, "type:"+aws.StringValue(t.ThingTypeName))
+			}
+			res = append(res, d)
+			byName[name] = d
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var groups []string
+	err = iotSvc.ListThingGroupsPages(&iot.ListThingGroupsInput{}, func(out *iot.ListThingGroupsOutput, last bool) bool {
+		for _, g := range out.ThingGroups {
+			groups = append(groups, aws.StringValue(g.GroupName))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, g := range groups {
+		err = iotSvc.ListThingsInThingGroupPages(&iot.ListThingsInThingGroupInput{
+			ThingGroupName: aws.String(g),
+		}, func(out *iot.ListThingsInThingGroupOutput, last bool) bool {
+			for _, name := range out.Things {
+				if d := byName[aws.StringValue(name)]; d != nil {
+					d.CloudTags = append(d.CloudTags, "group:"+g)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return res, nil
+}
+
+// getAzureDevices returns devices of the Azure IoT Hub given by
+// --azure-iot-hub, using the az CLI. Device twin tags become device tags;
+// the device list doesn't have them, so the twin of each device is fetched.
+func getAzureDevices() ([]*devreg.Device, error) {
+	if *azureIoTHub == "" {
+		return nil, errors.Errorf("please set --azure-iot-hub")
+	}
+	out, err := cloudCLIOutput("az", "iot", "hub", "device-identity", "list",
+		"--hub-name", *azureIoTHub, "--output", "json")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ids []struct {
+		DeviceID string `json:"deviceId"`
+	}
+	if err := json.Unmarshal(out, &ids); err != nil {
+		return nil, errors.Annotatef(err, "invalid az output")
+	}
+	var res []*devreg.Device
+	for _, id := range ids {
+		out, err := cloudCLIOutput("az", "iot", "hub", "device-twin", "show",
+			"--hub-name", *azureIoTHub, "--device-id", id.DeviceID, "--output", "json")
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var twin struct {
+			Tags map[string]interface{} `json:"tags"`
+		}
+		if err := json.Unmarshal(out, &twin); err != nil {
+			return nil, errors.Annotatef(err, "invalid az output")
+		}
+		attrs := map[string]string{}
+		for k, v := range twin.Tags {
+			if s, ok := v.(string); ok {
+				attrs[k] = s
+			} else {
+				data, _ := json.Marshal(v)
+				attrs[k] = string(data)
+			}
+		}
+		res = append(res, &devreg.Device{
+			Name:      id.DeviceID,
+			CloudTags: append(attrTags(attrs), "cloud:azure"),
+		})
+	}
+	return res, nil
+}
+
+// getGCPDevices returns devices of the Google IoT Core registry given by
+// --gcp-project, --gcp-region and --gcp-registry, using the gcloud CLI.
+// Device metadata become device tags; the device list doesn't have them, so
+// each device is described.
+func getGCPDevices() ([]*devreg.Device, error) {
+	if gcpProject == "" || gcpRegion == "" || gcpRegistry == "" {
+		return nil, errors.Errorf("Please set --gcp-project, --gcp-region, --gcp-registry")
+	}
+	registryArgs := []string{"--project", gcpProject, "--region", gcpRegion, "--registry", gcpRegistry, "--format", "json"}
+	out, err := cloudCLIOutput("gcloud", append([]string{"beta", "iot", "devices", "list"}, registryArgs...)...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ids []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(out, &ids); err != nil {
+		return nil, errors.Annotatef(err, "invalid gcloud output")
+	}
+	var res []*devreg.Device
+	for _, id := range ids {
+		out, err := cloudCLIOutput("gcloud", append([]string{"beta", "iot", "devices", "describe", id.ID}, registryArgs...)...)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var d struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal(out, &d); err != nil {
+			return nil, errors.Annotatef(err, "invalid gcloud output")
+		}
+		res = append(res, &devreg.Device{
+			Name:      id.ID,
+			CloudTags: append(attrTags(d.Metadata), "cloud:gcp", "registry:"+gcpRegistry),
+		})
+	}
+	return res, nil
+}
+
+// cloudCLIOutput runs the cloud CLI and returns its output; errors include
+// what the command printed to stderr.
+func cloudCLIOutput(name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		msg := ""
+		if ee, ok := err.(*exec.ExitError); ok {
+			msg = strings.TrimSpace(string(ee.Stderr))
+		}
+		return nil, errors.Annotatef(err, "%s %s: %s", name, strings.Join(args, " "), msg)
+	}
+	return out, nil
+}
+
+func reportDevicesSync(total, added int) {
+	ourutil.Reportf("Imported %d devices from %s, %d new", total, *devicesSyncFrom, added)
+}
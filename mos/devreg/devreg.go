@@ -0,0 +1,207 @@
+// Package devreg implements the local registry of devices, stored in
+// ~/.mos/devices.yml: devices have names, addresses (as accepted by --port)
+// and tags, like "site:lab" or "hw:rev2", which fleet commands can select
+// devices by.
+package devreg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Device struct {
+	Name string   `yaml:"name" json:"name"`
+	Port string   `yaml:"port" json:"port"`
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// CloudTags are imported from the cloud registry by Sync, and replaced on
+	// each sync.
+	CloudTags []string `yaml:"cloud_tags,omitempty" json:"cloud_tags,omitempty"`
+	// What the device is expected to attest to before an OTA, set when it's
+	// enrolled with mos ota --attest-enroll.
+	Attestation *Attestation `yaml:"attestation,omitempty" json:"attestation,omitempty"`
+}
+
+type Attestation struct {
+	ID string `yaml:"id" json:"id"`
+	// PEM-encoded public key of the device's ATECC slot, if it has one; then
+	// the device must sign the challenge with it.
+	PubKey string `yaml:"pubkey,omitempty" json:"pubkey,omitempty"`
+	Slot   int    `yaml:"slot,omitempty" json:"slot,omitempty"`
+	// IDs of the firmware builds the device may be running: the current one
+	// and, right after an OTA, the new one.
+	FWIDs []string `yaml:"fw_ids,omitempty" json:"fw_ids,omitempty"`
+}
+
+// HasTag returns whether the device has the given tag, either local or
+// imported from the cloud.
+func (d *Device) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	for _, t := range d.CloudTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type Registry struct {
+	Devices []*Device `yaml:"devices"`
+}
+
+// Load reads the registry from the given file; if it doesn't exist, the
+// registry is empty.
+func Load(fname string) (*Registry, error) {
+	r := &Registry{}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", fname)
+	}
+	return r, nil
+}
+
+// Save writes the registry to the given file.
+func (r *Registry) Save(fname string) error {
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(fname, data, 0644))
+}
+
+// Get returns the device with the given name, or nil.
+func (r *Registry) Get(name string) *Device {
+	for _, d := range r.Devices {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// Find returns the device with the given name or port, or nil.
+func (r *Registry) Find(namePort string) *Device {
+	if d := r.Get(namePort); d != nil {
+		return d
+	}
+	return r.GetByPort(namePort)
+}
+
+// GetByPort returns the device with the given port, or nil.
+func (r *Registry) GetByPort(port string) *Device {
+	for _, d := range r.Devices {
+		if d.Port == port {
+			return d
+		}
+	}
+	return nil
+}
+
+// Add adds the device, or updates the port of the existing one with the same
+// name. Tags are merged.
+func (r *Registry) Add(name, port string, tags []string) *Device {
+	d := r.Get(name)
+	if d == nil {
+		d = &Device{Name: name}
+		r.Devices = append(r.Devices, d)
+	}
+	d.Port = port
+	r.Tag(d, tags)
+	return d
+}
+
+// Remove removes the device with the given name.
+func (r *Registry) Remove(name string) error {
+	for i, d := range r.Devices {
+		if d.Name == name {
+			r.Devices = append(r.Devices[:i], r.Devices[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("no device %q", name)
+}
+
+// Tag adds tags to the device.
+func (r *Registry) Tag(d *Device, tags []string) {
+	for _, t := range tags {
+		if !d.HasTag(t) {
+			d.Tags = append(d.Tags, t)
+		}
+	}
+	sort.Strings(d.Tags)
+}
+
+// Untag removes tags from the device.
+func (r *Registry) Untag(d *Device, tags []string) {
+	var res []string
+	for _, t := range d.Tags {
+		keep := true
+		for _, tt := range tags {
+			if t == tt {
+				keep = false
+			}
+		}
+		if keep {
+			res = append(res, t)
+		}
+	}
+	d.Tags = res
+}
+
+// Sync merges devices imported from a cloud registry: new devices are added,
+// existing ones get their cloud tags replaced, and the port set if it's
+// empty. Locally set tags are kept. Returns the number of added devices.
+func (r *Registry) Sync(devs []*Device) int {
+	added := 0
+	for _, cd := range devs {
+		tags := append([]string(nil), cd.CloudTags...)
+		sort.Strings(tags)
+		d := r.Get(cd.Name)
+		if d == nil {
+			d = &Device{Name: cd.Name}
+			r.Devices = append(r.Devices, d)
+			added++
+		}
+		if d.Port == "" {
+			d.Port = cd.Port
+		}
+		d.CloudTags = tags
+	}
+	return added
+}
+
+// Select returns devices matching the tag expression (see ParseSelector).
+// Empty expression matches all devices.
+func (r *Registry) Select(expr string) ([]*Device, error) {
+	if expr == "" {
+		return r.Devices, nil
+	}
+	sel, err := ParseSelector(expr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var res []*Device
+	for _, d := range r.Devices {
+		if sel.Match(d) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,138 @@
+package devreg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testRegistry() *Registry {
+	r := &Registry{}
+	r.Add("a", "ws://10.0.0.1/rpc", []string{"site:lab", "hw:rev2"})
+	r.Add("b", "ws://10.0.0.2/rpc", []string{"site:lab", "hw:rev3"})
+	r.Add("c", "ws://10.0.0.3/rpc", []string{"site:office", "hw:rev2", "broken"})
+	r.Add("d", "/dev/ttyUSB0", nil)
+	return r
+}
+
+func names(devs []*Device) []string {
+	res := []string{}
+	for _, d := range devs {
+		res = append(res, d.Name)
+	}
+	return res
+}
+
+func TestSelect(t *testing.T) {
+	r := testRegistry()
+	for _, c := range []struct {
+		expr string
+		want []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{"site:lab", []string{"a", "b"}},
+		{"site:lab && hw:rev2", []string{"a"}},
+		{"site:lab&&hw:rev2", []string{"a"}},
+		{"hw:rev2 || hw:rev3", []string{"a", "b", "c"}},
+		{"hw:rev2 && !broken", []string{"a"}},
+		{"!(site:lab || site:office)", []string{"d"}},
+		{"site:office || site:lab && hw:rev3", []string{"b", "c"}},
+		{"name:d || broken", []string{"c", "d"}},
+		{"nosuchtag", []string{}},
+	} {
+		devs, err := r.Select(c.expr)
+		if err != nil {
+			t.Errorf("%q: %s", c.expr, err)
+			continue
+		}
+		if got := names(devs); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %q, want %q", c.expr, got, c.want)
+		}
+	}
+	for _, expr := range []string{"a &&", "(a", "a)", "a & b", "|| a", "!", "a b"} {
+		if _, err := r.Select(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devreg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "sub", "devices.yml")
+
+	r, err := Load(fname)
+	if err != nil || len(r.Devices) != 0 {
+		t.Fatalf("missing file: %v %v", r, err)
+	}
+
+	r = testRegistry()
+	r.Add("a", "ws://10.0.0.10/rpc", []string{"hw:rev2", "new"})
+	r.Untag(r.Get("c"), []string{"broken"})
+	if err := r.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove("b"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := r.Save(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := Load(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, r2) {
+		t.Errorf("loaded registry differs: %+v vs %+v", r2, r)
+	}
+	a := r2.Get("a")
+	if a.Port != "ws://10.0.0.10/rpc" || !reflect.DeepEqual(a.Tags, []string{"hw:rev2", "new", "site:lab"}) {
+		t.Errorf("unexpected a: %+v", a)
+	}
+	if c := r2.Get("c"); c.HasTag("broken") {
+		t.Errorf("c is still broken: %+v", c)
+	}
+}
+
+func TestSync(t *testing.T) {
+	r := testRegistry()
+	added := r.Sync([]*Device{
+		{Name: "a", Port: "mqtts://iot:8883/a", CloudTags: []string{"group:west", "cloud:aws"}},
+		{Name: "e", Port: "mqtts://iot:8883/e", CloudTags: []string{"cloud:aws"}},
+	})
+	if added != 1 {
+		t.Errorf("added %d, want 1", added)
+	}
+	a := r.Get("a")
+	if a.Port != "ws://10.0.0.1/rpc" || !reflect.DeepEqual(a.CloudTags, []string{"cloud:aws", "group:west"}) || !a.HasTag("site:lab") {
+		t.Errorf("unexpected a: %+v", a)
+	}
+	devs, err := r.Select("group:west && site:lab")
+	if err != nil || !reflect.DeepEqual(names(devs), []string{"a"}) {
+		t.Errorf("unexpected selection: %v %v", names(devs), err)
+	}
+
+	r.Sync([]*Device{{Name: "a", CloudTags: []string{"group:east"}}})
+	if a.HasTag("group:west") || !a.HasTag("group:east") {
+		t.Errorf("cloud tags not replaced: %+v", a)
+	}
+}
+
+func TestFind(t *testing.T) {
+	r := testRegistry()
+	if d := r.Find("b"); d == nil || d.Name != "b" {
+		t.Errorf("by name: %+v", d)
+	}
+	if d := r.Find("/dev/ttyUSB0"); d == nil || d.Name != "d" {
+		t.Errorf("by port: %+v", d)
+	}
+	if d := r.Find("/dev/ttyUSB1"); d != nil {
+		t.Errorf("unexpected %+v", d)
+	}
+}
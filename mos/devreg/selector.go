@@ -0,0 +1,157 @@
+package devreg
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/cesanta/errors"
+)
+
+// Selector is a parsed tag expression.
+type Selector interface {
+	Match(d *Device) bool
+}
+
+type tagSel string
+
+func (s tagSel) Match(d *Device) bool { return d.HasTag(string(s)) }
+
+type notSel struct{ s Selector }
+
+func (s notSel) Match(d *Device) bool { return !s.s.Match(d) }
+
+type andSel struct{ a, b Selector }
+
+func (s andSel) Match(d *Device) bool { return s.a.Match(d) && s.b.Match(d) }
+
+type orSel struct{ a, b Selector }
+
+func (s orSel) Match(d *Device) bool { return s.a.Match(d) || s.b.Match(d) }
+
+// ParseSelector parses the tag expression, like:
+//
+//	site:lab && (hw:rev2 || hw:rev3) && !broken
+//
+// Tags are matched exactly; a device name, prefixed with "name:", is matched
+// as well, so that individual devices can be selected.
+func ParseSelector(expr string) (Selector, error) {
+	p := &selParser{tokens: tokenize(expr)}
+	s, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid selector %q", expr)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.Errorf("invalid selector %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+	return s, nil
+}
+
+func tokenize(expr string) []string {
+	var res []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			res = append(res, expr[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			res = append(res, string(c))
+			i++
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n!()&|", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				// Single "&" or "|"
+				j++
+			}
+			res = append(res, expr[i:j])
+			i = j
+		}
+	}
+	return res
+}
+
+type selParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *selParser) parseOr() (Selector, error) {
+	s, err := p.parseAnd()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for p.peek() == "||" {
+		p.pos++
+		s2, err := p.parseAnd()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s = orSel{s, s2}
+	}
+	return s, nil
+}
+
+func (p *selParser) parseAnd() (Selector, error) {
+	s, err := p.parseUnary()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		s2, err := p.parseUnary()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s = andSel{s, s2}
+	}
+	return s, nil
+}
+
+func (p *selParser) parseUnary() (Selector, error) {
+	tok := p.peek()
+	switch tok {
+	case "":
+		return nil, errors.Errorf("unexpected end of expression")
+	case "!":
+		p.pos++
+		s, err := p.parseUnary()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return notSel{s}, nil
+	case "(":
+		p.pos++
+		s, err := p.parseOr()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if p.peek() != ")" {
+			return nil, errors.Errorf("missing )")
+		}
+		p.pos++
+		return s, nil
+	case ")", "&&", "||", "&", "|":
+		return nil, errors.Errorf("unexpected %q", tok)
+	}
+	p.pos++
+	if strings.HasPrefix(tok, "name:") {
+		return nameSel(strings.TrimPrefix(tok, "name:")), nil
+	}
+	return tagSel(tok), nil
+}
+
+type nameSel string
+
+func (s nameSel) Match(d *Device) bool { return d.Name == string(s) }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/sntp"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	ntpServer     = flag.String("ntp-server", "pool.ntp.org", "NTP server used by \"mos time sync\"")
+	configureSNTP = flag.Bool("configure-sntp", false, "When syncing time, also enable SNTP on the device with the given --ntp-server")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "configure-sntp")
+}
+
+// Device time is exchanged as a number of seconds since the Unix epoch, with
+// a fractional part: Sys.GetTime returns {"time": 1520000000.5}, Sys.SetTime
+// takes the same.
+const (
+	timeGetMethod = "Sys.GetTime"
+	timeSetMethod = "Sys.SetTime"
+)
+
+func timeHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: get, set or sync")
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	switch args[0] {
+	case "get":
+		return errors.Trace(timeGet(ctx, devConn))
+	case "set":
+		return errors.Trace(timeSet(ctx, devConn, args[1:]))
+	case "sync":
+		return errors.Trace(timeSync(ctx, devConn))
+	}
+
+	return errors.Errorf("unknown command %q, expected get, set or sync", args[0])
+}
+
+// getDeviceTime returns the device time and the host time at the moment the
+// device time was read (the middle of the RPC round trip).
+func getDeviceTime(ctx context.Context, devConn *dev.DevConn) (time.Time, time.Time, error) {
+	start := time.Now()
+	res, err := callDeviceService(ctx, devConn, timeGetMethod, "")
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Trace(err)
+	}
+	hostTime := start.Add(time.Since(start) / 2)
+
+	var resp struct {
+		Time float64 `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(res), &resp); err != nil {
+		return time.Time{}, time.Time{}, errors.Annotatef(err, "unexpected %s response: %s", timeGetMethod, res)
+	}
+	return unixFloatToTime(resp.Time), hostTime, nil
+}
+
+func setDeviceTime(ctx context.Context, devConn *dev.DevConn, t time.Time) error {
+	params := fmt.Sprintf(`{"time": %.3f}`, float64(t.UnixNano())/1e9)
+	_, err := callDeviceService(ctx, devConn, timeSetMethod, params)
+	return errors.Trace(err)
+}
+
+func unixFloatToTime(v float64) time.Time {
+	secs, frac := math.Modf(v)
+	return time.Unix(int64(secs), int64(frac*1e9))
+}
+
+func reportDrift(devTime, refTime time.Time, refName string) {
+	ourutil.Reportf("Device time: %s", devTime.UTC().Format(time.RFC3339Nano))
+	ourutil.Reportf("%s time: %s", refName, refTime.UTC().Format(time.RFC3339Nano))
+	ourutil.Reportf("Drift: %s", devTime.Sub(refTime).Round(time.Millisecond))
+}
+
+func timeGet(ctx context.Context, devConn *dev.DevConn) error {
+	devTime, hostTime, err := getDeviceTime(ctx, devConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reportDrift(devTime, hostTime, "Host")
+	return nil
+}
+
+// timeSet sets device time from the host clock, or to the given RFC3339 time.
+func timeSet(ctx context.Context, devConn *dev.DevConn, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("usage: mos time set [RFC3339 time]")
+	}
+
+	devTime, hostTime, err := getDeviceTime(ctx, devConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reportDrift(devTime, hostTime, "Host")
+
+	var t time.Time
+	if len(args) == 1 {
+		t, err = time.Parse(time.RFC3339, args[0])
+		if err != nil {
+			return errors.Annotatef(err, "invalid time")
+		}
+	} else {
+		t = time.Now()
+	}
+
+	if err := setDeviceTime(ctx, devConn, t); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Device time set to %s", t.UTC().Format(time.RFC3339Nano))
+	return nil
+}
+
+// timeSync sets device time from NTP and, if requested, enables SNTP on the
+// device.
+func timeSync(ctx context.Context, devConn *dev.DevConn) error {
+	ourutil.Reportf("Querying %s...", *ntpServer)
+	ntp, err := sntp.Query(*ntpServer, 5*time.Second)
+	if err != nil {
+		return errors.Annotatef(err, "failed to get time from %s", *ntpServer)
+	}
+	ourutil.Reportf("Host clock offset: %s (RTT %s)", ntp.Offset.Round(time.Millisecond), ntp.RTT.Round(time.Millisecond))
+
+	devTime, hostTime, err := getDeviceTime(ctx, devConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reportDrift(devTime, hostTime.Add(ntp.Offset), "NTP")
+
+	t := time.Now().Add(ntp.Offset)
+	if err := setDeviceTime(ctx, devConn, t); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Device time set to %s", t.UTC().Format(time.RFC3339Nano))
+
+	if *configureSNTP {
+		return errors.Trace(internalConfigSet(ctx, devConn, []string{
+			"sntp.enable=true",
+			fmt.Sprintf("sntp.server=%s", *ntpServer),
+		}))
+	}
+	return nil
+}
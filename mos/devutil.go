@@ -5,14 +5,21 @@ import (
 	"crypto/x509"
 	"flag"
 	"io/ioutil"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"context"
 
+	"cesanta.com/common/go/mgrpc"
 	"cesanta.com/common/go/mgrpc/codec"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/errcode"
+	"cesanta.com/mos/farm"
+	"cesanta.com/mos/mdns"
 	"github.com/cesanta/errors"
+	"github.com/golang/glog"
 )
 
 var (
@@ -32,45 +39,66 @@ func createDevConn(ctx context.Context) (*dev.DevConn, error) {
 func createDevConnWithJunkHandler(
 	ctx context.Context, junkHandler func(junk []byte), logHandler func(string, []byte),
 ) (*dev.DevConn, error) {
+	if dc, err := getReplayMockDevConn(); dc != nil || err != nil {
+		return dc, errors.Trace(err)
+	}
 	port, err := getPort()
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, errors.Trace(errcode.Wrap(errcode.DeviceNotFound, err))
+	}
+	return createDevConnForPort(ctx, port, junkHandler, logHandler)
+}
+
+// createDevConnForPort is like createDevConnWithJunkHandler, but connects to
+// the given port instead of the one specified with --port.
+func createDevConnForPort(
+	ctx context.Context, port string, junkHandler func(junk []byte), logHandler func(string, []byte),
+) (*dev.DevConn, error) {
+	setRPCCredsLookup(port)
+
+	// Devices attached to a farm agent are talked to via its RPC relay
+	if farm.IsPort(port) {
+		p, err := parseFarmPort(port, farm.ParsePort)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		port = p.RPCURL()
 	}
 	c := dev.Client{Port: port, Timeout: *timeout, Reconnect: *reconnect}
 	prefix := "serial://"
 	if strings.Index(port, "://") > 0 {
 		prefix = ""
 	}
-	addr := prefix + port
+	rate := uint(0)
+	if serialPort := strings.TrimPrefix(prefix+port, "serial://"); serialPort != prefix+port {
+		var err error
+		if rate, err = getBaudRate(serialPort); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	addr, err := normalizeNetworkPort(prefix + port)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	// Init and pass TLS config if --cert-file and --key-file are specified
 	var tlsConfig *tls.Config = nil
 	if certFile != "" || strings.HasPrefix(port, "wss") || strings.HasPrefix(port, "https") || strings.HasPrefix(port, "mqtts") {
-
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: caFile == "",
+		if tlsConfig, err = newClientTLSConfig(); err != nil {
+			return nil, errors.Trace(err)
 		}
+	}
 
-		// Load client cert / key if specified
-		if certFile != "" && keyFile == "" {
-			return nil, errors.Errorf("Please specify --key-file")
-		}
-		if certFile != "" {
-			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-			tlsConfig.Certificates = []tls.Certificate{cert}
-		}
+	sealer, err := getRPCSealer()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-		// Load CA cert if specified
-		if caFile != "" {
-			caCert, err := ioutil.ReadFile(caFile)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-			tlsConfig.RootCAs = x509.NewCertPool()
-			tlsConfig.RootCAs.AppendCertsFromPEM(caCert)
+	if recorder != nil {
+		origJunkHandler := junkHandler
+		junkHandler = func(junk []byte) {
+			recorder.RecordConsole(junk)
+			origJunkHandler(junk)
 		}
 	}
 
@@ -79,6 +107,7 @@ func createDevConnWithJunkHandler(
 			LogCallback: logHandler,
 		},
 		Serial: codec.SerialCodecOptions{
+			BaudRate:    rate,
 			JunkHandler: junkHandler,
 			// Due to lack of flow control, we send data in chunks and wait after each.
 			SendChunkSize:        16,
@@ -88,5 +117,101 @@ func createDevConnWithJunkHandler(
 		},
 	}
 	devConn, err := c.CreateDevConnWithOpts(ctx, addr, *reconnect, tlsConfig, codecOpts)
-	return devConn, errors.Trace(err)
+	if err != nil {
+		return nil, errors.Trace(errcode.Wrap(errcode.DeviceNotFound, err))
+	}
+	// Sealing goes first, so that the recorder sees the plain calls
+	var wrappers []func(mgrpc.MgRPC) mgrpc.MgRPC
+	if sealer != nil {
+		wrappers = append(wrappers, wrapSealedRPC(sealer))
+	}
+	if recorder != nil {
+		wrappers = append(wrappers, recorder.WrapRPC)
+	}
+	if len(wrappers) > 0 {
+		devConn.RPCWrapper = func(rpc mgrpc.MgRPC) mgrpc.MgRPC {
+			for _, w := range wrappers {
+				rpc = w(rpc)
+			}
+			return rpc
+		}
+		devConn.SetRPC(devConn.RPCWrapper(devConn.RPC))
+	}
+	return devConn, nil
+}
+
+// newClientTLSConfig returns the TLS config for connecting to servers: the
+// client cert is from --cert-file and --key-file, the server is verified
+// with --ca-cert-file, if given.
+func newClientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: caFile == "",
+	}
+
+	// Load client cert / key if specified
+	if certFile != "" && keyFile == "" {
+		return nil, errors.Errorf("Please specify --key-file")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Load CA cert if specified
+	if caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(caCert)
+	}
+	return tlsConfig, nil
+}
+
+// Matches IPv6 literals with the zone ID in URLs, like "[fe80::1%en0]"
+var ipv6ZoneRegexp = regexp.MustCompile(`\[([0-9a-fA-F:.]+)%(25)?([^\]]+)\]`)
+
+// normalizeNetworkPort prepares the network port address for connecting:
+// IPv6 zone IDs are escaped as required in URLs ("%" becomes "%25", so that
+// users can write "ws://[fe80::1%en0]/rpc"), and ".local" host names are
+// resolved via mDNS, since the system resolver might not support that.
+// Serial ports are returned as is.
+func normalizeNetworkPort(addr string) (string, error) {
+	if strings.HasPrefix(addr, "serial://") {
+		return addr, nil
+	}
+
+	addr = ipv6ZoneRegexp.ReplaceAllString(addr, "[$1%25$3]")
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid port %q", addr)
+	}
+	if !mdns.IsLocalName(u.Hostname()) {
+		return addr, nil
+	}
+
+	ips, err := mdns.Resolve(u.Hostname(), 2*time.Second)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	ip := ips[0]
+	host := ip.IP.String()
+	if ip.IP.To4() == nil {
+		if ip.Zone != "" {
+			host += "%25" + ip.Zone
+		}
+		host = "[" + host + "]"
+	}
+	if u.Port() != "" {
+		host += ":" + u.Port()
+	}
+	glog.Infof("Resolved %s to %s", u.Hostname(), host)
+
+	// Not using u.String() because it'd escape the "%" in the zone again
+	return strings.Replace(addr, u.Host, host, 1), nil
 }
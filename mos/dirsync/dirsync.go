@@ -0,0 +1,126 @@
+// Package dirsync mirrors a directory tree into another one, copying only
+// the files whose size or modification time has changed, like
+// "rsync -a --delete" does by default.
+package dirsync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cesanta/errors"
+)
+
+// Stats of the sync.
+type Stats struct {
+	Copied  int
+	Removed int
+}
+
+// Sync makes dst a copy of src. Entries named in skip (e.g. ".git") are not
+// copied, at any level.
+func Sync(src, dst string, skip ...string) (*Stats, error) {
+	st := &Stats{}
+	skipNames := map[string]bool{}
+	for _, s := range skip {
+		skipNames[s] = true
+	}
+
+	// Relative paths which exist in src
+	seen := map[string]bool{}
+	err := filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skipNames[fi.Name()] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, 0755)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if cur, err := os.Readlink(target); err == nil && cur == link {
+				return nil
+			}
+			os.RemoveAll(target)
+			st.Copied++
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			if dfi, err := os.Lstat(target); err == nil && dfi.Mode().IsRegular() &&
+				dfi.Size() == fi.Size() && dfi.ModTime().Equal(fi.ModTime()) {
+				return nil
+			}
+			st.Copied++
+			return copyFile(p, target, fi)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Remove what's not in src anymore
+	var toRemove []string
+	err = filepath.Walk(dst, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, p)
+		if err != nil {
+			return err
+		}
+		if !seen[rel] {
+			toRemove = append(toRemove, p)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, p := range toRemove {
+		if err := os.RemoveAll(p); err != nil {
+			return nil, errors.Trace(err)
+		}
+		st.Removed++
+	}
+	return st, nil
+}
+
+func copyFile(src, dst string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// The file can be read-only, e.g. in git objects
+	os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,61 @@
+package dirsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	write := func(p, s string) {
+		p = filepath.Join(src, p)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.c", "a")
+	write("include/b.h", "b")
+	write(".git/HEAD", "ref")
+
+	st, err := Sync(src, dst, ".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Copied != 2 || st.Removed != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".git")); err == nil {
+		t.Errorf(".git should be skipped")
+	}
+
+	// Nothing changed: nothing is copied
+	if st, err = Sync(src, dst, ".git"); err != nil || st.Copied != 0 {
+		t.Errorf("unexpected stats %+v (%v)", st, err)
+	}
+
+	// Change one file, remove another
+	write("a.c", "aa")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(src, "a.c"), future, future)
+	os.RemoveAll(filepath.Join(src, "include"))
+	if st, err = Sync(src, dst, ".git"); err != nil || st.Copied != 1 || st.Removed != 1 {
+		t.Errorf("unexpected stats %+v (%v)", st, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dst, "a.c")); string(data) != "aa" {
+		t.Errorf("a.c is not updated: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "include")); err == nil {
+		t.Errorf("include should be removed")
+	}
+}
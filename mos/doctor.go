@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"cesanta.com/mos/cachegc"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/doctor"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	doctorMinFree  = flag.String("doctor-min-free", "500M", "mos doctor: free disk space below which the check fails")
+	doctorWarnFree = flag.String("doctor-warn-free", "2G", "mos doctor: free disk space below which a warning is given")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "doctor-min-free", "doctor-warn-free")
+}
+
+// doctorHandler implements "mos doctor", which checks the environment: the
+// container runtime for local builds, serial ports and access to them,
+// reachability of the build server and GitHub, and free disk space in the
+// dirs mos keeps libs, apps and temp files in. Problems are printed with
+// what to do about them; the command fails if any check fails.
+func doctorHandler(ctx context.Context, devConn *dev.DevConn) error {
+	minFree, err := cachegc.ParseSize(*doctorMinFree)
+	if err != nil {
+		return errors.Annotatef(err, "--doctor-min-free")
+	}
+	warnFree, err := cachegc.ParseSize(*doctorWarnFree)
+	if err != nil {
+		return errors.Annotatef(err, "--doctor-warn-free")
+	}
+	checks := []doctor.Check{
+		{Name: "container runtime", Run: checkContainerRuntime},
+		{Name: "serial ports", Run: checkSerialPorts},
+	}
+	server, err := serverURL()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, u := range []string{server.String(), "https://github.com"} {
+		u := u
+		checks = append(checks, doctor.Check{Name: "network", Run: func() *doctor.Result { return checkReachable(u) }})
+	}
+	for _, dir := range doctorDiskDirs() {
+		dir := dir
+		checks = append(checks, doctor.Check{Name: "disk space", Run: func() *doctor.Result {
+			free, err := diskFreeSpace(dir)
+			if err != nil {
+				return doctor.Warning("", "%s: failed to get free space: %s", dir, err)
+			}
+			return doctor.DiskSpace(dir, free, minFree, warnFree)
+		}})
+	}
+	results := doctor.Run(checks)
+	doctor.Print(os.Stdout, results)
+	return errors.Trace(doctor.Error(results))
+}
+
+func checkContainerRuntime() *doctor.Result {
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return doctor.Warning("Install Docker (https://docs.docker.com/get-docker/) or podman; "+
+			"it's only needed for local builds (--local)", "%s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, rt, "info").CombinedOutput()
+	if err != nil {
+		fix := fmt.Sprintf("Start the %s daemon", rt)
+		if runtime.GOOS == "linux" {
+			fix = fmt.Sprintf("Start the %s daemon: sudo systemctl start %s", rt, rt)
+			if strings.Contains(string(out), "permission denied") {
+				fix = fmt.Sprintf("Add yourself to the %s group and log in again: sudo usermod -aG %s $USER", rt, rt)
+			}
+		}
+		return doctor.Failed(fix, "%s is installed, but doesn't work: %s", rt, firstLine(string(out)))
+	}
+	v, _ := exec.Command(rt, "--version").Output()
+	return doctor.OK("%s", strings.TrimSpace(string(v)))
+}
+
+func checkSerialPorts() *doctor.Result {
+	ports := enumerateSerialPorts()
+	if len(ports) == 0 {
+		return doctor.Warning(serialDriverHint(), "no serial ports found")
+	}
+	var denied []string
+	var fixes []string
+	for _, port := range ports {
+		if err := checkSerialPortAccess(port); err != nil {
+			denied = append(denied, port)
+			fixes = append(fixes, serialPortAccessFix(port))
+		}
+	}
+	if len(denied) > 0 {
+		return doctor.Failed(strings.Join(uniqueStrings(fixes), "\n"),
+			"no read/write access to %s", strings.Join(denied, ", "))
+	}
+	return doctor.OK("%s", strings.Join(ports, ", "))
+}
+
+func checkReachable(u string) *doctor.Result {
+	client := &http.Client{Timeout: *timeout}
+	start := time.Now()
+	resp, err := client.Head(u)
+	if err != nil {
+		return doctor.Failed("Check the network connection; if you are behind a proxy, "+
+			"set HTTPS_PROXY or use --proxy", "%s is not reachable: %s", u, err)
+	}
+	resp.Body.Close()
+	return doctor.OK("%s is reachable (%s)", u, time.Since(start).Round(time.Millisecond))
+}
+
+// doctorDiskDirs returns the dirs whose disks are checked for free space:
+// the shared cache of libs and modules, the ones mos keeps apps and temp
+// files in, and the project dir. Dirs which don't exist yet are checked at their closest existing
+// parent.
+func doctorDiskDirs() []string {
+	var res []string
+	seen := map[string]bool{}
+	dirs := append(getGlobalCacheDirs(), paths.AppsDir, paths.TmpDir, projectDir)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		for {
+			if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			res = append(res, dir)
+		}
+	}
+	return res
+}
+
+func uniqueStrings(ss []string) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+// Package doctor implements the checks of the environment mos works in, like
+// availability of the container runtime, access to serial ports and free
+// disk space, reporting what is wrong along with how to fix it.
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cesanta.com/mos/cachegc"
+	"github.com/cesanta/errors"
+)
+
+// Statuses of the check results
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusFailed  = "failed"
+)
+
+// Check is a named check of the environment.
+type Check struct {
+	Name string
+	Run  func() *Result
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// What the user can do about it, for warnings and failures.
+	Fix string `json:"fix,omitempty"`
+}
+
+// OK returns a successful result.
+func OK(format string, args ...interface{}) *Result {
+	return &Result{Status: StatusOK, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warning returns a result for a problem which may or may not matter.
+func Warning(fix, format string, args ...interface{}) *Result {
+	return &Result{Status: StatusWarning, Message: fmt.Sprintf(format, args...), Fix: fix}
+}
+
+// Failed returns a result for a problem which breaks some commands.
+func Failed(fix, format string, args ...interface{}) *Result {
+	return &Result{Status: StatusFailed, Message: fmt.Sprintf(format, args...), Fix: fix}
+}
+
+// Run runs the checks one by one and returns their results.
+func Run(checks []Check) []*Result {
+	var res []*Result
+	for _, c := range checks {
+		r := c.Run()
+		r.Name = c.Name
+		res = append(res, r)
+	}
+	return res
+}
+
+// Print prints the results, with fixes indented under the problems.
+func Print(w io.Writer, results []*Result) {
+	for _, r := range results {
+		fmt.Fprintf(w, "[%s] %s: %s\n", statusMark(r.Status), r.Name, r.Message)
+		if r.Status != StatusOK && r.Fix != "" {
+			for _, l := range strings.Split(r.Fix, "\n") {
+				fmt.Fprintf(w, "    %s\n", l)
+			}
+		}
+	}
+}
+
+func statusMark(status string) string {
+	switch status {
+	case StatusOK:
+		return " OK "
+	case StatusWarning:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// Error returns an error listing the failed checks, or nil if there are
+// none; warnings are not errors.
+func Error(results []*Result) error {
+	var failed []string
+	for _, r := range results {
+		if r.Status == StatusFailed {
+			failed = append(failed, r.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("%d check(s) failed: %s", len(failed), strings.Join(failed, ", "))
+}
+
+// DiskSpace returns the result of the free disk space check of the dir:
+// below min, it's a failure, and below warn, a warning.
+func DiskSpace(dir string, free, min, warn int64) *Result {
+	fix := fmt.Sprintf("Free up space on the disk of %s, e.g. with \"mos clean --global-cache\"", dir)
+	switch {
+	case free < min:
+		return Failed(fix, "%s: only %s free", dir, cachegc.FormatSize(free))
+	case free < warn:
+		return Warning(fix, "%s: %s free", dir, cachegc.FormatSize(free))
+	}
+	return OK("%s: %s free", dir, cachegc.FormatSize(free))
+}
@@ -0,0 +1,42 @@
+package doctor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunAndPrint(t *testing.T) {
+	results := Run([]Check{
+		{"docker", func() *Result { return OK("docker 20.10") }},
+		{"network", func() *Result { return Warning("Check the proxy", "github.com is not reachable") }},
+		{"disk", func() *Result { return DiskSpace("/tmp", 100<<20, 500<<20, 2<<30) }},
+	})
+	var buf bytes.Buffer
+	Print(&buf, results)
+	exp := "[ OK ] docker: docker 20.10\n" +
+		"[WARN] network: github.com is not reachable\n" +
+		"    Check the proxy\n" +
+		"[FAIL] disk: /tmp: only 100.0 MiB free\n" +
+		"    Free up space on the disk of /tmp, e.g. with \"mos clean --global-cache\"\n"
+	if got := buf.String(); got != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, got)
+	}
+	if err := Error(results); err == nil || err.Error() != "1 check(s) failed: disk" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := Error(results[:2]); err != nil {
+		t.Errorf("warnings are not errors, got %s", err)
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	for free, exp := range map[int64]string{
+		100 << 20: StatusFailed,
+		1 << 30:   StatusWarning,
+		10 << 30:  StatusOK,
+	} {
+		if got := DiskSpace("/x", free, 500<<20, 2<<30).Status; got != exp {
+			t.Errorf("%d: expected %s, got %s", free, exp, got)
+		}
+	}
+}
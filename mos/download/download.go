@@ -0,0 +1,173 @@
+// Package download fetches assets (firmware, prebuilt libs, mos binaries)
+// over HTTP and verifies them against the published checksums and, if the
+// signing key is built in, signatures.
+//
+// Checksum of the asset at some URL is published at the same URL with the
+// ".sha256" suffix, in the sha256sum format: hex-encoded hash, optionally
+// followed by the file name. Signature is published with the ".sig" suffix,
+// see FetchSignature.
+//
+// Checksums come from the same server as the assets, so they only catch
+// corruption. Only signatures protect from a compromised server, but only
+// mos binaries and version.json are signed (see GetSigned): the empty app,
+// prebuilt libs and archive modules come from GitHub and other third-party
+// hosts.
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"cesanta.com/common/go/ourutil"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	insecure = flag.Bool("insecure", false, "Do not verify checksums and signatures of downloaded assets")
+)
+
+// Get fetches the asset and verifies it, see Verify.
+func Get(url string) ([]byte, error) {
+	return getVerified(url, Verify)
+}
+
+// GetSigned fetches the asset which must be signed and verifies it, see
+// VerifySigned.
+func GetSigned(url string) ([]byte, error) {
+	return getVerified(url, VerifySigned)
+}
+
+func getVerified(url string, verify func(url string, data []byte) error) ([]byte, error) {
+	data, err := get(url)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if data == nil {
+		return nil, errors.Errorf("%s: failed to fetch: not found", url)
+	}
+	if err := verify(url, data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+// get fetches the data, returns (nil, nil) if there is none.
+func get(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s: failed to fetch", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s: failed to fetch: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s: failed to fetch body", url)
+	}
+	return data, nil
+}
+
+// Verify checks the data downloaded from the given URL against the published
+// checksum and, if the signing key is built in and the asset is signed, the
+// signature. Mismatch is an error; missing checksum is only reported. Nothing
+// is checked with --insecure.
+func Verify(url string, data []byte) error {
+	return errors.Trace(verify(url, data, false))
+}
+
+// VerifySigned is like Verify, but if the signing key is built in, the
+// signature is required. It's used for mos binaries, which the release build
+// signs.
+func VerifySigned(url string, data []byte) error {
+	return errors.Trace(verify(url, data, true))
+}
+
+func verify(url string, data []byte, mustBeSigned bool) error {
+	if *insecure {
+		return nil
+	}
+
+	sumData, err := get(url + ".sha256")
+	if err != nil {
+		return errors.Annotatef(err, "failed to get checksum (use --insecure to skip verification)")
+	}
+	if sumData == nil {
+		ourutil.Reportf("Warning: no checksum is published for %s, can't verify it", url)
+	} else if err := verifyChecksum(data, string(sumData)); err != nil {
+		return errors.Annotatef(err, "%s", url)
+	}
+
+	if SigningKey != "" {
+		sig, err := FetchSignature(url)
+		if err != nil {
+			return errors.Annotatef(err, "failed to get signature (use --insecure to skip verification)")
+		}
+		switch {
+		case sig != nil:
+			if err := VerifySignature(data, sig, signingKeyPEM()); err != nil {
+				return errors.Annotatef(err, "%s", url)
+			}
+		case mustBeSigned:
+			return errors.Errorf("no signature is published for %s (use --insecure to skip verification)", url)
+		}
+	}
+
+	return nil
+}
+
+// Checksum returns the checksum of the data in the sha256sum format, i.e. the
+// contents of the ".sha256" file.
+func Checksum(data []byte, name string) []byte {
+	return []byte(fmt.Sprintf("%x  %s\n", sha256.Sum256(data), name))
+}
+
+// verifyChecksum checks data against the checksum in the sha256sum format.
+func verifyChecksum(data []byte, sumData string) error {
+	fields := strings.Fields(sumData)
+	if len(fields) == 0 {
+		return errors.Errorf("empty checksum")
+	}
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil || len(expected) != sha256.Size {
+		return errors.Errorf("invalid checksum %q", fields[0])
+	}
+	actual := sha256.Sum256(data)
+	if string(actual[:]) != string(expected) {
+		return errors.Errorf("checksum mismatch: expected %x, got %x (use --insecure to skip verification)",
+			expected, actual)
+	}
+	return nil
+}
+
+// VerifyDigestHeader checks data against the "Digest: SHA-256=..." header
+// (RFC 3230) of the response, if it's present.
+func VerifyDigestHeader(h http.Header, data []byte) error {
+	if *insecure {
+		return nil
+	}
+	for _, d := range strings.Split(h.Get("Digest"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "SHA-256") {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return errors.Errorf("invalid digest %q", parts[1])
+		}
+		actual := sha256.Sum256(data)
+		if string(actual[:]) != string(expected) {
+			return errors.Errorf("digest mismatch, the response is corrupted (use --insecure to skip verification)")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// SigningKey is the PEM-encoded ECDSA P-256 public key which mos binaries
+// and other assets are signed with. It's set at build time (see
+// SIGNING_KEY in the Makefile) with:
+//
+//	--ldflags "-X cesanta.com/mos/download.SigningKey=..."
+//
+// Since newlines are hard to pass that way, the base64 body of the PEM
+// block, on one line, is accepted too. If empty, signatures are not verified.
+var SigningKey string
+
+// signingKeyPEM returns SigningKey as a PEM block.
+func signingKeyPEM() string {
+	if SigningKey == "" || strings.Contains(SigningKey, "-----BEGIN") {
+		return SigningKey
+	}
+	return "-----BEGIN PUBLIC KEY-----\n" + SigningKey + "\n-----END PUBLIC KEY-----\n"
+}
+
+// FetchSignature downloads the signature of the asset at the given URL: it's
+// the base64-encoded ASN.1 ECDSA signature of the SHA-256 of the asset,
+// published next to it with the ".sig" suffix. If there is no signature,
+// (nil, nil) is returned.
+func FetchSignature(url string) ([]byte, error) {
+	data, err := get(url + ".sig")
+	if err != nil || data == nil {
+		return nil, errors.Trace(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid signature")
+	}
+	return sig, nil
+}
+
+// VerifySignature checks the signature of the data with the given PEM-encoded
+// public key.
+func VerifySignature(data, sig []byte, pubKeyPEM string) error {
+	block, _ := pem.Decode([]byte(pubKeyPEM))
+	if block == nil {
+		return errors.Errorf("invalid signing key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return errors.Annotatef(err, "invalid signing key")
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.Errorf("signing key is not an ECDSA key")
+	}
+
+	hash := sha256.Sum256(data)
+
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return errors.Annotatef(err, "invalid signature")
+	}
+	if !ecdsa.Verify(ecKey, hash[:], rs.R, rs.S) {
+		return errors.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// Sign signs the data with the given PEM-encoded ECDSA private key and
+// returns the signature in the format expected by FetchSignature, i.e. the
+// contents of the ".sig" file.
+func Sign(data, privKeyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(privKeyPEM)
+	if block == nil {
+		return nil, errors.Errorf("invalid private key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid private key")
+	}
+
+	hash := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), nil
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	data := []byte("new mos binary")
+
+	hash := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifySignature(data, sig, pubPEM); err != nil {
+		t.Errorf("valid signature: %s", err)
+	}
+
+	// The key as passed with -X
+	SigningKey = base64.StdEncoding.EncodeToString(der)
+	defer func() { SigningKey = "" }()
+	if err := VerifySignature(data, sig, signingKeyPEM()); err != nil {
+		t.Errorf("valid signature with a one-line key: %s", err)
+	}
+
+	sig[len(sig)-1] ^= 1
+	if err := VerifySignature(data, sig, pubPEM); err == nil {
+		t.Errorf("expected an error for a corrupted signature")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("hello")
+	sum := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if err := verifyChecksum(data, sum+"  hello.txt\n"); err != nil {
+		t.Errorf("valid checksum: %s", err)
+	}
+	if err := verifyChecksum([]byte("hellO"), sum); err == nil {
+		t.Errorf("expected an error for a wrong checksum")
+	}
+	if err := verifyChecksum(data, "xyz"); err == nil {
+		t.Errorf("expected an error for an invalid checksum")
+	}
+}
+
+func TestSign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privDER})
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	data := []byte("release artifact")
+	sigFile, err := Sign(data, privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature(data, sig, pubPEM); err != nil {
+		t.Errorf("signature made by Sign doesn't verify: %s", err)
+	}
+
+	sum := Checksum(data, "fw.zip")
+	if err := verifyChecksum(data, string(sum)); err != nil {
+		t.Errorf("checksum made by Checksum doesn't verify: %s", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Package errcode defines the stable taxonomy of mos failures: each failure
+// has a machine-readable code and a process exit status, so that automation
+// can tell e.g. a build failure from a missing device without parsing error
+// messages.
+//
+// Codes and exit statuses are part of the mos interface: existing ones must
+// never be changed, only new ones can be added.
+package errcode
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/cesanta/errors"
+)
+
+// Code is a machine-readable failure category
+type Code string
+
+const (
+	Generic        Code = "GENERIC_ERROR"
+	BuildFailed    Code = "BUILD_FAILED"
+	DeviceNotFound Code = "DEVICE_NOT_FOUND"
+	RPCTimeout     Code = "RPC_TIMEOUT"
+	AuthFailed     Code = "AUTH_FAILED"
+	RPCError       Code = "RPC_ERROR"
+	FlashFailed    Code = "FLASH_FAILED"
+	NetworkError   Code = "NETWORK_ERROR"
+	UsageError     Code = "USAGE_ERROR"
+	BootFailed     Code = "BOOT_FAILED"
+)
+
+var exitStatuses = map[Code]int{
+	Generic:        1,
+	BuildFailed:    2,
+	DeviceNotFound: 3,
+	RPCTimeout:     4,
+	AuthFailed:     5,
+	RPCError:       6,
+	FlashFailed:    7,
+	NetworkError:   8,
+	UsageError:     9,
+	BootFailed:     10,
+}
+
+// ExitStatus returns the process exit status for the code.
+func (c Code) ExitStatus() int {
+	if s, ok := exitStatuses[c]; ok {
+		return s
+	}
+	return exitStatuses[Generic]
+}
+
+type codedError struct {
+	code Code
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+// Wrap attaches the code to the error, unless it already has a more specific
+// one (e.g. an RPC timeout during the build stays an RPC timeout). The
+// returned error keeps the original message; nil is returned for nil error.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	if Of(err) != Generic {
+		return err
+	}
+	return &codedError{code: code, err: err}
+}
+
+// Errorf returns a new error with the given code.
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &codedError{code: code, err: errors.Errorf(format, args...)}
+}
+
+// Of returns the code of the error: either attached to it with Wrap or
+// Errorf (possibly traced or annotated since then), or guessed from the
+// underlying error.
+func Of(err error) Code {
+	if err == nil {
+		return ""
+	}
+	switch e := errors.Cause(err).(type) {
+	case *codedError:
+		return e.code
+	case net.Error:
+		if e.Timeout() {
+			return RPCTimeout
+		}
+		return NetworkError
+	}
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return RPCTimeout
+	}
+	return Generic
+}
+
+// ExitStatus returns the process exit status for the error; 0 for nil.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	return Of(err).ExitStatus()
+}
+
+// JSON returns the machine-readable description of the error:
+//
+//	{"error": {"code": "BUILD_FAILED", "exit_status": 2, "message": "..."}}
+func JSON(err error) []byte {
+	code := Of(err)
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":        code,
+			"exit_status": code.ExitStatus(),
+			"message":     err.Error(),
+		},
+	})
+	return data
+}
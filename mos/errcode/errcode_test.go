@@ -0,0 +1,57 @@
+package errcode
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cesanta/errors"
+)
+
+func TestOf(t *testing.T) {
+	buildErr := Wrap(BuildFailed, errors.Errorf("build failed"))
+	for i, c := range []struct {
+		err    error
+		code   Code
+		status int
+	}{
+		{err: errors.Errorf("foo"), code: Generic, status: 1},
+		{err: buildErr, code: BuildFailed, status: 2},
+		{err: errors.Trace(buildErr), code: BuildFailed, status: 2},
+		{err: errors.Annotatef(buildErr, "for %s", "esp32"), code: BuildFailed, status: 2},
+		{err: Wrap(Generic, buildErr), code: BuildFailed, status: 2},
+		{err: errors.Trace(context.DeadlineExceeded), code: RPCTimeout, status: 4},
+		{err: Wrap(BuildFailed, errors.Trace(context.DeadlineExceeded)), code: RPCTimeout, status: 4},
+		{err: Errorf(AuthFailed, "remote error %d", 401), code: AuthFailed, status: 5},
+	} {
+		if code := Of(c.err); code != c.code {
+			t.Errorf("case %d: expected code %s, got %s", i, c.code, code)
+		}
+		if status := ExitStatus(c.err); status != c.status {
+			t.Errorf("case %d: expected status %d, got %d", i, c.status, status)
+		}
+	}
+
+	if ExitStatus(nil) != 0 || Wrap(BuildFailed, nil) != nil {
+		t.Errorf("nil error must stay nil with status 0")
+	}
+	if msg := errors.Trace(buildErr).Error(); msg != "build failed" {
+		t.Errorf("message must be preserved, got %q", msg)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var res struct {
+		Error struct {
+			Code       string `json:"code"`
+			ExitStatus int    `json:"exit_status"`
+			Message    string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(JSON(Errorf(DeviceNotFound, "no port")), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error.Code != "DEVICE_NOT_FOUND" || res.Error.ExitStatus != 3 || res.Error.Message != "no port" {
+		t.Errorf("unexpected JSON: %+v", res)
+	}
+}
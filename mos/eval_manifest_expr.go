@@ -9,6 +9,7 @@ import (
 
 	"context"
 
+	"cesanta.com/mos/build"
 	"cesanta.com/mos/dev"
 	"cesanta.com/mos/interpreter"
 	"cesanta.com/mos/manifest_parser"
@@ -17,11 +18,6 @@ import (
 )
 
 func evalManifestExpr(ctx context.Context, devConn *dev.DevConn) error {
-	cll, err := getCustomLibLocations()
-	if err != nil {
-		return errors.Trace(err)
-	}
-
 	args := flag.Args()[1:]
 
 	if len(args) == 0 {
@@ -30,6 +26,62 @@ func evalManifestExpr(ctx context.Context, devConn *dev.DevConn) error {
 
 	expr := args[0]
 
+	manifest, interp, err := readFinalManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := interpreter.SetManifestVars(interp.MVars, manifest); err != nil {
+		return errors.Trace(err)
+	}
+
+	res, err := interp.EvaluateExpr(expr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// TODO(dfrank): probably add a flag whether to expand vars (the default
+	// being to expand)
+	sdata, err := interpreter.ExpandVars(interp, string(data), false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Println(sdata)
+
+	return nil
+}
+
+// readProjectManifest reads the app manifest of the project, for --platform
+// and with --build-var, see manifest_parser.ReadAppManifest. Libs are not
+// read.
+func readProjectManifest() (*build.FWAppManifest, error) {
+	buildVarsCli, err := getBuildVarsFromCLI()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	manifest, err := manifest_parser.ReadAppManifest(projectDir, &manifest_parser.ManifestAdjustments{
+		Platform:  *platform,
+		BuildVars: buildVarsCli,
+	}, interpreter.NewInterpreter(newMosVars()))
+	return manifest, errors.Trace(err)
+}
+
+// readFinalManifest reads the final manifest of the project in the current
+// directory, with all libs expanded, without updating libs and without
+// building anything. Returns the manifest and the interpreter which was used
+// for it.
+func readFinalManifest() (*build.FWAppManifest, *interpreter.MosInterpreter, error) {
+	cll, err := getCustomLibLocations()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
 	bParams := &buildParams{
 		Platform:           *platform,
 		CustomLibLocations: cll,
@@ -46,7 +98,7 @@ func evalManifestExpr(ctx context.Context, devConn *dev.DevConn) error {
 
 	appDir, err := getCodeDirAbs()
 	if err != nil {
-		return errors.Trace(err)
+		return nil, nil, errors.Trace(err)
 	}
 
 	// Never update libs on that command
@@ -67,7 +119,7 @@ func evalManifestExpr(ctx context.Context, devConn *dev.DevConn) error {
 
 	buildVarsCli, err := getBuildVarsFromCLI()
 	if err != nil {
-		return errors.Trace(err)
+		return nil, nil, errors.Trace(err)
 	}
 
 	manifest, _, err := manifest_parser.ReadManifestFinal(
@@ -78,31 +130,8 @@ func evalManifestExpr(ctx context.Context, devConn *dev.DevConn) error {
 		&manifest_parser.ReadManifestCallbacks{ComponentProvider: &compProvider}, false, *preferPrebuiltLibs,
 	)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, nil, errors.Trace(err)
 	}
 
-	if err := interpreter.SetManifestVars(interp.MVars, manifest); err != nil {
-		return errors.Trace(err)
-	}
-
-	res, err := interp.EvaluateExpr(expr)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	data, err := json.MarshalIndent(res, "", "  ")
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	// TODO(dfrank): probably add a flag whether to expand vars (the default
-	// being to expand)
-	sdata, err := interpreter.ExpandVars(interp, string(data), false)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	fmt.Println(sdata)
-
-	return nil
+	return manifest, interp, nil
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"context"
+
+	"cesanta.com/common/go/ourio"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/pioexport"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	pioBoard = flag.String("pio-board", "", "mos export: PlatformIO board to use instead of the default one of the platform")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "pio-board")
+}
+
+var exportSourceExts = map[string]bool{
+	".c": true, ".cpp": true, ".cc": true, ".S": true, ".h": true, ".hpp": true,
+}
+
+// exportHandler implements "mos export --format platformio [dir]": writes
+// platformio.ini, with the build flags and libs of mos.yml, and copies the
+// app sources and includes next to it, by default to build/platformio. Conds
+// are not evaluated, and mos.yml remains the source of truth.
+func exportHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if format != "" && format != "platformio" {
+		return errors.Errorf("unknown format %q, expected platformio", format)
+	}
+	outDir := filepath.Join(moscommon.GetBuildDir(projectDir), "platformio")
+	if len(args) > 0 {
+		outDir = args[0]
+	}
+
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p := *platform
+	if p == "" {
+		p = manifest.Platform
+	}
+	if p == "" {
+		return errors.Errorf("--platform must be specified or mos.yml should contain a platform key")
+	}
+	if !pioexport.Supported(p) {
+		return errors.Errorf("platform %q is not supported by PlatformIO", p)
+	}
+	appName, err := fixupAppName(manifest.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := prepareExportDir(outDir); err != nil {
+		return errors.Trace(err)
+	}
+	srcDir := filepath.Join(outDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+
+	sources, err := getExportFiles(manifest.Sources, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, f := range sources {
+		if err := copyExportFile(f, srcDir); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	var includes []string
+	for _, inc := range manifest.Includes {
+		if strings.HasPrefix(inc, "$") || strings.HasPrefix(inc, "@") {
+			reportf("Skipping include dir %s", inc)
+			continue
+		}
+		files, err := getExportFiles([]string{inc}, true)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		incDir := filepath.Join(outDir, "include")
+		for _, f := range files {
+			if err := copyExportFile(f, incDir); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		includes = append(includes, filepath.ToSlash(filepath.Join("include", exportRelPath(inc))))
+	}
+
+	proj := &pioexport.Project{
+		Name:       appName,
+		Platform:   p,
+		Board:      *pioBoard,
+		BuildFlags: pioexport.BuildFlags(manifest.CFlags, manifest.CDefs, includes),
+		CXXFlags:   manifest.CXXFlags,
+		BuildVars:  manifest.BuildVars,
+	}
+	for _, l := range manifest.Libs {
+		l.Normalize()
+		if dep := pioexport.LibDep(l.Location, l.Version); dep != "" {
+			proj.LibDeps = append(proj.LibDeps, dep)
+		} else {
+			proj.UnresolvedLibs = append(proj.UnresolvedLibs, l.Name)
+		}
+	}
+
+	var ini bytes.Buffer
+	if err := pioexport.WriteINI(&ini, proj); err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, pioexport.ININame), ini.Bytes(), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	if len(proj.CXXFlags) > 0 {
+		var script bytes.Buffer
+		if err := pioexport.WriteScript(&script, proj.CXXFlags); err != nil {
+			return errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, pioexport.ScriptName), script.Bytes(), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	reportf("Exported %d source files and %d libs to %s", len(sources), len(proj.LibDeps), outDir)
+	return nil
+}
+
+// prepareExportDir empties the output dir. Only the dirs written by a
+// previous export, which have the marker file in them, are emptied; other
+// non-empty dirs are refused unless --force is given, and the project dir
+// or a dir containing it, always.
+func prepareExportDir(outDir string) error {
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	absProj, err := filepath.Abs(projectDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if rel, err := filepath.Rel(absOut, absProj); err == nil && !strings.HasPrefix(rel, "..") {
+		return errors.Errorf("%s contains the project, refusing to export to it", outDir)
+	}
+	entries, err := ioutil.ReadDir(outDir)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	if len(entries) > 0 {
+		if _, err := os.Stat(filepath.Join(outDir, pioexport.MarkerName)); err != nil && !*force {
+			return errors.Errorf("%s is not empty and was not written by mos export; "+
+				"use an empty dir, or --force to replace its contents", outDir)
+		}
+		if err := os.RemoveAll(outDir); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(filepath.Join(outDir, pioexport.MarkerName), nil, 0644))
+}
+
+// getExportFiles returns the files the manifest paths refer to: globs are
+// expanded, and dirs give all the source and header files in them (only
+// headers if headersOnly is set). Paths with a "-" prefix are excluded.
+func getExportFiles(manifestPaths []string, headersOnly bool) ([]string, error) {
+	var files []string
+	excluded := map[string]bool{}
+	for _, mp := range manifestPaths {
+		exclude := strings.HasPrefix(mp, "-")
+		mp = strings.TrimLeft(mp, "+-")
+		if strings.HasPrefix(mp, "$") || strings.HasPrefix(mp, "@") {
+			reportf("Skipping %s", mp)
+			continue
+		}
+		path := mp
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectDir, path)
+		}
+		var matches []string
+		if strings.ContainsAny(path, "*?[") {
+			m, err := filepath.Glob(path)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			matches = m
+		} else if st, err := os.Stat(path); err != nil {
+			return nil, errors.Trace(err)
+		} else if !st.IsDir() {
+			matches = []string{path}
+		} else {
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			for _, e := range entries {
+				ext := filepath.Ext(e.Name())
+				if e.IsDir() || !exportSourceExts[ext] || (headersOnly && ext != ".h" && ext != ".hpp") {
+					continue
+				}
+				matches = append(matches, filepath.Join(path, e.Name()))
+			}
+		}
+		for _, m := range matches {
+			if exclude {
+				excluded[m] = true
+			} else {
+				files = append(files, m)
+			}
+		}
+	}
+	var res []string
+	for _, f := range files {
+		if !excluded[f] {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// exportRelPath returns the path relative to the project dir; paths outside
+// of it are flattened to their base name.
+func exportRelPath(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectDir, path)
+	}
+	projectDirAbs, _ := filepath.Abs(projectDir)
+	pathAbs, _ := filepath.Abs(path)
+	rel, err := filepath.Rel(projectDirAbs, pathAbs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// copyExportFile copies the file to the same path relative to dstDir as it
+// has relative to the project dir.
+func copyExportFile(f, dstDir string) error {
+	dst := filepath.Join(dstDir, exportRelPath(f))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ourio.CopyFile(f, dst))
+}
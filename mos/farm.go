@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	osuser "os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/download"
+	"cesanta.com/mos/farm"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	farmUser = flag.String("farm-user", "", "User name to identify as to device farm agents without per-user tokens, for their usage log, if not given in the port. Defaults to user@host")
+	lockTTL  = flag.Duration("lock-ttl", time.Hour, "mos farm lock: how long to lock the device for")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "farm-user", "lock-ttl")
+}
+
+func getFarmUser() string {
+	if *farmUser != "" {
+		return *farmUser
+	}
+	name := "unknown"
+	if u, err := osuser.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// parseFarmPort parses the farm port, identifying the user if it doesn't.
+func parseFarmPort(port string, parse func(string) (*farm.Port, error)) (*farm.Port, error) {
+	p, err := parse(port)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if p.User == "" {
+		p.User = getFarmUser()
+	}
+	return p, nil
+}
+
+func farmHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: list, lock or unlock")
+	}
+	var port string
+	if len(args) > 1 {
+		port = args[1]
+	} else {
+		var err error
+		if port, err = getPort(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	switch args[0] {
+	case "list":
+		p, err := parseFarmPort(port, farm.ParseAgent)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(farmList(p))
+	case "lock", "unlock":
+		p, err := parseFarmPort(port, farm.ParsePort)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		u := p.LockURL(*lockTTL)
+		if args[0] == "unlock" {
+			u = p.UnlockURL(*force)
+		}
+		resp, err := http.Post(u, "text/plain", nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		ourutil.Reportf("%s", strings.TrimSpace(string(msg)))
+		return nil
+	}
+	return errors.Errorf("unknown command %q, expected list, lock or unlock", args[0])
+}
+
+func farmList(p *farm.Port) error {
+	resp, err := http.Get(p.DevicesURL())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := download.VerifyDigestHeader(resp.Header, data); err != nil {
+		return errors.Annotatef(err, "device list")
+	}
+	var devices []farm.DeviceStatus
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return errors.Trace(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, d := range devices {
+		status := "free"
+		if d.LockedBy != "" {
+			status = fmt.Sprintf("locked by %s until %s", d.LockedBy, d.LockedUntil.Local().Format(time.Stamp))
+		}
+		if d.Busy {
+			status += ", in use"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", d.ID, status)
+	}
+	return errors.Trace(w.Flush())
+}
+
+// farmConsole is the console of a device attached to a device farm agent.
+func farmConsole(ctx context.Context, port string) error {
+	p, err := parseFarmPort(port, farm.ParsePort)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dec, hexdump, err := newConsoleDecoder()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if hexdump != nil {
+		defer hexdump.Close()
+	}
+	rate := baudRate
+	ws, err := websocket.Dial(p.ConsoleURL(rate), "", p.Origin())
+	if err != nil {
+		return errors.Annotatef(err, "failed to connect to %s", p.Host)
+	}
+	defer ws.Close()
+	ws.PayloadType = websocket.BinaryFrame
+
+	errs := make(chan error, 2)
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			n, err := ws.Read(buf)
+			if n > 0 {
+				if recorder != nil {
+					recorder.RecordConsole(buf[:n])
+				}
+				if hexdump != nil {
+					hexdump.Write(buf[:n])
+				}
+				os.Stdout.Write(dec.Decode(buf[:n]))
+			}
+			if err != nil {
+				os.Stdout.Write(dec.Flush())
+				errs <- err
+				return
+			}
+		}
+	}()
+	if !noInput {
+		go func() {
+			_, err := io.Copy(ws, os.Stdin)
+			errs <- err
+		}()
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return errors.Trace(err)
+}
+
+// farmFlash sends the firmware to the device farm agent to flash.
+func farmFlash(fwname, port string) error {
+	p, err := parseFarmPort(port, farm.ParsePort)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.Open(fwname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	ourutil.Reportf("Flashing %s via %s...", p.DeviceID, p.Host)
+	resp, err := http.Post(p.FlashURL(), "application/zip", f)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if _, err := io.Copy(os.Stderr, resp.Body); err != nil {
+		return errors.Trace(err)
+	}
+	if status := resp.Trailer.Get(farm.StatusTrailer); status != "ok" {
+		if status == "" {
+			status = "no status from the agent"
+		}
+		return errors.Errorf("%s", status)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package farm implements the addressing of devices attached to a device farm
+// agent (see mos agent): farm://[user@]host[:port]/device-id[?token=...],
+// or farms:// for TLS.
+//
+// The agent serves, for each device:
+//   - /devices/<id>/rpc: RPC frames over WebSocket, relayed to the device;
+//   - /devices/<id>/console?baud=N: raw console over WebSocket;
+//   - /devices/<id>/flash: POST the firmware zip to flash it, the output is
+//     streamed back and the status is in the X-Mos-Status trailer;
+//   - /devices/<id>/lock?ttl=D, /devices/<id>/unlock[?force=1]: POST to lock
+//     the device for the user for a while, so that it's not used by others
+//     between sessions.
+//
+// /devices returns the list of devices with their lock status.
+package farm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// DefaultPort is the port the agent listens on by default.
+	DefaultPort = 1994
+
+	// TokenParam is the query parameter with the access token.
+	TokenParam = "token"
+	// UserParam is the query parameter with the name of the user, if not
+	// identified by the token.
+	UserParam = "user"
+	// StatusTrailer is the trailer with the status of flashing, "ok" or the
+	// error message.
+	StatusTrailer = "X-Mos-Status"
+)
+
+type Port struct {
+	Host     string
+	DeviceID string
+	TLS      bool
+	Token    string
+	User     string
+}
+
+// DeviceStatus is what the agent reports about a device.
+type DeviceStatus struct {
+	ID          string    `json:"id"`
+	Busy        bool      `json:"busy,omitempty"`
+	LockedBy    string    `json:"locked_by,omitempty"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// IsPort returns true if the port refers to a device farm.
+func IsPort(port string) bool {
+	return strings.HasPrefix(port, "farm://") || strings.HasPrefix(port, "farms://")
+}
+
+// ParsePort parses the farm port address.
+func ParsePort(port string) (*Port, error) {
+	p, err := parse(port)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if p.DeviceID == "" || strings.Contains(p.DeviceID, "/") {
+		return nil, errors.Errorf("invalid farm port %q, expected farm://host[:port]/device-id", port)
+	}
+	return p, nil
+}
+
+// ParseAgent parses the agent address, farm://host[:port], device ID is
+// optional.
+func ParseAgent(addr string) (*Port, error) {
+	return parse(addr)
+}
+
+func parse(port string) (*Port, error) {
+	u, err := url.Parse(port)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid farm port %q", port)
+	}
+	switch u.Scheme {
+	case "farm", "farms":
+	default:
+		return nil, errors.Errorf("invalid farm port %q: scheme must be farm or farms", port)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf("invalid farm port %q: host is required", port)
+	}
+	p := &Port{
+		Host:     u.Host,
+		DeviceID: strings.Trim(u.Path, "/"),
+		TLS:      u.Scheme == "farms",
+		Token:    u.Query().Get(TokenParam),
+	}
+	if u.User != nil {
+		p.User = u.User.Username()
+	}
+	if u.Port() == "" {
+		p.Host = fmt.Sprintf("%s:%d", u.Host, DefaultPort)
+	}
+	return p, nil
+}
+
+func (p *Port) url(ws bool, path string, query url.Values) string {
+	scheme := "http"
+	if ws {
+		scheme = "ws"
+	}
+	if p.TLS {
+		scheme += "s"
+	}
+	if p.Token != "" {
+		query.Set(TokenParam, p.Token)
+	}
+	if p.User != "" {
+		query.Set(UserParam, p.User)
+	}
+	u := url.URL{Scheme: scheme, Host: p.Host, Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+func (p *Port) devicePath(what string) string {
+	return fmt.Sprintf("/devices/%s/%s", url.PathEscape(p.DeviceID), what)
+}
+
+// RPCURL returns the WebSocket URL to talk RPC to the device at.
+func (p *Port) RPCURL() string {
+	return p.url(true, p.devicePath("rpc"), url.Values{})
+}
+
+// ConsoleURL returns the WebSocket URL of the device console.
+func (p *Port) ConsoleURL(baudRate uint) string {
+	q := url.Values{}
+	if baudRate != 0 {
+		q.Set("baud", fmt.Sprintf("%d", baudRate))
+	}
+	return p.url(true, p.devicePath("console"), q)
+}
+
+// FlashURL returns the URL to post the firmware to.
+func (p *Port) FlashURL() string {
+	return p.url(false, p.devicePath("flash"), url.Values{})
+}
+
+// DevicesURL returns the URL of the device list.
+func (p *Port) DevicesURL() string {
+	return p.url(false, "/devices", url.Values{})
+}
+
+// LockURL returns the URL to lock the device for the given time at.
+func (p *Port) LockURL(ttl time.Duration) string {
+	return p.url(false, p.devicePath("lock"), url.Values{"ttl": []string{ttl.String()}})
+}
+
+// UnlockURL returns the URL to unlock the device at. Forced unlocking
+// releases locks held by other users.
+func (p *Port) UnlockURL(force bool) string {
+	q := url.Values{}
+	if force {
+		q.Set("force", "1")
+	}
+	return p.url(false, p.devicePath("unlock"), q)
+}
+
+// Origin returns the origin to use for WebSocket connections.
+func (p *Port) Origin() string {
+	scheme := "http"
+	if p.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/", scheme, p.Host)
+}
@@ -0,0 +1,47 @@
+package farm
+
+import "testing"
+
+func TestParsePort(t *testing.T) {
+	p, err := ParsePort("farm://lab1/esp32-a?token=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Host != "lab1:1994" || p.DeviceID != "esp32-a" || p.TLS || p.Token != "s3cret" {
+		t.Errorf("unexpected port %+v", p)
+	}
+	for _, c := range []struct{ got, want string }{
+		{p.RPCURL(), "ws://lab1:1994/devices/esp32-a/rpc?token=s3cret"},
+		{p.ConsoleURL(921600), "ws://lab1:1994/devices/esp32-a/console?baud=921600&token=s3cret"},
+		{p.FlashURL(), "http://lab1:1994/devices/esp32-a/flash?token=s3cret"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %s, want %s", c.got, c.want)
+		}
+	}
+
+	p, err = ParsePort("farms://lab1:8443/dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RPCURL() != "wss://lab1:8443/devices/dev1/rpc" {
+		t.Errorf("unexpected URL %s", p.RPCURL())
+	}
+
+	p, err = ParsePort("farm://alice@lab1/dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.User != "alice" || p.UnlockURL(true) != "http://lab1:1994/devices/dev1/unlock?force=1&user=alice" {
+		t.Errorf("unexpected port %+v", p)
+	}
+	if _, err := ParseAgent("farm://lab1"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	for _, s := range []string{"farm://lab1", "farm:///dev1", "farm://lab1/a/b", "ws://lab1/dev1"} {
+		if _, err := ParsePort(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
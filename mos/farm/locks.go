@@ -0,0 +1,90 @@
+package farm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// Lock is held by a user on a device until it expires or is released.
+type Lock struct {
+	Owner string
+	Until time.Time
+}
+
+// Locks keeps device locks of the agent.
+type Locks struct {
+	mu    sync.Mutex
+	locks map[string]*Lock
+	now   func() time.Time
+}
+
+func NewLocks() *Locks {
+	return &Locks{locks: map[string]*Lock{}, now: time.Now}
+}
+
+// get returns the active lock of the device, dropping the expired one.
+// Must be called with mu held.
+func (l *Locks) get(id string) *Lock {
+	lk := l.locks[id]
+	if lk != nil && !l.now().Before(lk.Until) {
+		delete(l.locks, id)
+		lk = nil
+	}
+	return lk
+}
+
+// Get returns the active lock of the device, or nil.
+func (l *Locks) Get(id string) *Lock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lk := l.get(id); lk != nil {
+		res := *lk
+		return &res
+	}
+	return nil
+}
+
+// Lock locks the device for the user. Locking the device again by the same
+// user extends the lock.
+func (l *Locks) Lock(id, user string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, errors.Errorf("lock time must be positive")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lk := l.get(id); lk != nil && lk.Owner != user {
+		return nil, errors.Errorf("%s is locked by %s until %s", id, lk.Owner, lk.Until.Format(time.RFC3339))
+	}
+	lk := &Lock{Owner: user, Until: l.now().Add(ttl)}
+	l.locks[id] = lk
+	res := *lk
+	return &res, nil
+}
+
+// Unlock releases the lock of the device. Only the owner can do that, unless
+// forced.
+func (l *Locks) Unlock(id, user string, force bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lk := l.get(id)
+	if lk == nil {
+		return nil
+	}
+	if lk.Owner != user && !force {
+		return errors.Errorf("%s is locked by %s", id, lk.Owner)
+	}
+	delete(l.locks, id)
+	return nil
+}
+
+// Check returns an error if the device is locked by another user.
+func (l *Locks) Check(id, user string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lk := l.get(id); lk != nil && lk.Owner != user {
+		return errors.Errorf("%s is locked by %s until %s", id, lk.Owner, lk.Until.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package farm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLocks()
+	l.now = func() time.Time { return now }
+
+	if _, err := l.Lock("dev1", "alice", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check("dev1", "alice"); err != nil {
+		t.Errorf("owner must be allowed: %s", err)
+	}
+	if err := l.Check("dev1", "bob"); err == nil {
+		t.Errorf("expected an error for another user")
+	}
+	if _, err := l.Lock("dev1", "bob", time.Hour); err == nil {
+		t.Errorf("expected an error locking a locked device")
+	}
+	if err := l.Unlock("dev1", "bob", false); err == nil {
+		t.Errorf("expected an error unlocking somebody else's lock")
+	}
+
+	now = now.Add(30 * time.Minute)
+	lk, err := l.Lock("dev1", "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lk.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("lock is not extended: %s", lk.Until)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if l.Get("dev1") != nil {
+		t.Errorf("lock must have expired")
+	}
+	if _, err := l.Lock("dev1", "bob", time.Hour); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := l.Unlock("dev1", "alice", true); err != nil || l.Get("dev1") != nil {
+		t.Errorf("forced unlock failed: %v", err)
+	}
+}
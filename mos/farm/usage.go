@@ -0,0 +1,40 @@
+package farm
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// UsageEntry is a record of the usage log of the agent.
+type UsageEntry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Remote   string    `json:"remote"`
+	Device   string    `json:"device"`
+	Action   string    `json:"action"`
+	Duration float64   `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// UsageLog writes usage entries as JSON lines.
+type UsageLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewUsageLog(w io.Writer) *UsageLog {
+	return &UsageLog{w: w}
+}
+
+func (ul *UsageLog) Log(e *UsageEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	_, err = ul.w.Write(append(data, '\n'))
+	return err
+}
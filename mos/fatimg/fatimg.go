@@ -0,0 +1,424 @@
+// Package fatimg builds FAT12/FAT16 filesystem images from host dirs, for SD
+// cards and external flash chips. Names which don't fit 8.3 get long file
+// name entries.
+package fatimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	sectorSize     = 512
+	dirEntrySize   = 32
+	rootDirEntries = 512
+	numFATs        = 2
+	reservedSecs   = 1
+
+	// FAT16 can't have more clusters than this.
+	maxFAT16Clusters = 65524
+	// Filesystems with fewer clusters than this are FAT12.
+	maxFAT12Clusters = 4084
+
+	attrDir       = 0x10
+	attrArchive   = 0x20
+	attrLongName  = 0x0f
+	lastLongEntry = 0x40
+)
+
+// MinSize and MaxSize are the image size limits.
+const (
+	MinSize = 64 * 1024
+	MaxSize = 2 * 1024 * 1024 * 1024
+)
+
+// node is a file or a dir to put into the image.
+type node struct {
+	name     string
+	dir      bool
+	data     []byte
+	mtime    time.Time
+	children []*node
+
+	firstCluster uint16
+}
+
+// layout is the geometry of the image.
+type layout struct {
+	totalSecs  int
+	secsPerClu int
+	fatSecs    int
+	clusters   int
+	fat12      bool
+}
+
+func (l *layout) rootDirSec() int { return reservedSecs + numFATs*l.fatSecs }
+func (l *layout) dataSec() int    { return l.rootDirSec() + rootDirEntries*dirEntrySize/sectorSize }
+func (l *layout) cluSize() int    { return l.secsPerClu * sectorSize }
+
+func newLayout(size int64) (*layout, error) {
+	if size < MinSize || size > MaxSize {
+		return nil, errors.Errorf("image size must be between %d and %d bytes", MinSize, MaxSize)
+	}
+	l := &layout{totalSecs: int(size / sectorSize)}
+	for l.secsPerClu = 1; l.secsPerClu <= 64; l.secsPerClu *= 2 {
+		// The FAT size depends on the number of clusters and vice versa,
+		// start with the upper bound and shrink
+		l.fatSecs = 1
+		for {
+			data := l.totalSecs - reservedSecs - numFATs*l.fatSecs - rootDirEntries*dirEntrySize/sectorSize
+			l.clusters = data / l.secsPerClu
+			l.fat12 = l.clusters <= maxFAT12Clusters
+			var fatBytes int
+			if l.fat12 {
+				fatBytes = (l.clusters + 2) * 3 / 2
+			} else {
+				fatBytes = (l.clusters + 2) * 2
+			}
+			need := (fatBytes + sectorSize - 1) / sectorSize
+			if need <= l.fatSecs {
+				break
+			}
+			l.fatSecs = need
+		}
+		if l.clusters <= maxFAT16Clusters {
+			return l, nil
+		}
+	}
+	return nil, errors.Errorf("image is too big for FAT16")
+}
+
+// Build returns the image of the given size with the contents of dir.
+func Build(dir string, size int64, label string) ([]byte, error) {
+	root, err := readDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	l, err := newLayout(size)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	img := make([]byte, int64(l.totalSecs)*sectorSize)
+	w := &writer{l: l, img: img, nextClu: 2}
+	w.writeBootSector(label)
+	if err := w.writeDir(root, true, nil); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Both FATs are the same
+	fat := img[reservedSecs*sectorSize : (reservedSecs+l.fatSecs)*sectorSize]
+	copy(img[(reservedSecs+l.fatSecs)*sectorSize:], fat)
+	return img, nil
+}
+
+func readDir(dir string) (*node, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	n := &node{name: fi.Name(), dir: true, mtime: fi.ModTime()}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			c, err := readDir(p)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			n.children = append(n.children, c)
+			continue
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		n.children = append(n.children, &node{name: fi.Name(), data: data, mtime: fi.ModTime()})
+	}
+	return n, nil
+}
+
+type writer struct {
+	l       *layout
+	img     []byte
+	nextClu int
+}
+
+func (w *writer) writeBootSector(label string) {
+	b := w.img[:sectorSize]
+	copy(b, []byte{0xeb, 0x3c, 0x90})
+	copy(b[3:11], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(b[11:], sectorSize)
+	b[13] = byte(w.l.secsPerClu)
+	binary.LittleEndian.PutUint16(b[14:], reservedSecs)
+	b[16] = numFATs
+	binary.LittleEndian.PutUint16(b[17:], rootDirEntries)
+	if w.l.totalSecs < 0x10000 {
+		binary.LittleEndian.PutUint16(b[19:], uint16(w.l.totalSecs))
+	} else {
+		binary.LittleEndian.PutUint32(b[32:], uint32(w.l.totalSecs))
+	}
+	b[21] = 0xf8 // Fixed media
+	binary.LittleEndian.PutUint16(b[22:], uint16(w.l.fatSecs))
+	binary.LittleEndian.PutUint16(b[24:], 63)  // Sectors per track
+	binary.LittleEndian.PutUint16(b[26:], 255) // Heads
+	b[36] = 0x80                               // Drive number
+	b[38] = 0x29                               // Extended boot signature
+	binary.LittleEndian.PutUint32(b[39:], uint32(time.Now().Unix()))
+	copy(b[43:54], pad(strings.ToUpper(label), 11))
+	if w.l.fat12 {
+		copy(b[54:62], "FAT12   ")
+	} else {
+		copy(b[54:62], "FAT16   ")
+	}
+	b[510], b[511] = 0x55, 0xaa
+
+	// Media descriptor and end-of-chain markers of clusters 0 and 1
+	w.setFAT(0, 0xff8)
+	w.setFAT(1, 0xfff)
+}
+
+// setFAT sets the FAT entry; values are given in FAT12 terms, 0xfff is the end
+// of a chain.
+func (w *writer) setFAT(clu int, val int) {
+	fat := w.img[reservedSecs*sectorSize:]
+	if w.l.fat12 {
+		off := clu * 3 / 2
+		if clu%2 == 0 {
+			fat[off] = byte(val)
+			fat[off+1] = fat[off+1]&0xf0 | byte(val>>8)&0x0f
+		} else {
+			fat[off] = fat[off]&0x0f | byte(val<<4)
+			fat[off+1] = byte(val >> 4)
+		}
+		return
+	}
+	if val >= 0xff8 {
+		val |= 0xf000
+	}
+	binary.LittleEndian.PutUint16(fat[clu*2:], uint16(val))
+}
+
+// alloc allocates a contiguous chain of clusters for size bytes and returns
+// its first cluster, 0 for empty files.
+func (w *writer) alloc(size int) (int, error) {
+	n := (size + w.l.cluSize() - 1) / w.l.cluSize()
+	if n == 0 {
+		return 0, nil
+	}
+	first := w.nextClu
+	if first+n-2 > w.l.clusters {
+		return 0, errors.Errorf("image is too small")
+	}
+	for i := 0; i < n-1; i++ {
+		w.setFAT(first+i, first+i+1)
+	}
+	w.setFAT(first+n-1, 0xfff)
+	w.nextClu += n
+	return first, nil
+}
+
+func (w *writer) cluOffset(clu int) int {
+	return (w.l.dataSec() + (clu-2)*w.l.secsPerClu) * sectorSize
+}
+
+// writeDir writes the dir entries of n, and the contents of its children.
+// parent is nil for the root dir and the dirs in it.
+func (w *writer) writeDir(n *node, isRoot bool, parent *node) error {
+	var entries bytes.Buffer
+	if !isRoot {
+		entries.Write(dirEntry(".          ", attrDir, n.firstCluster, 0, n.mtime))
+		parentClu := uint16(0)
+		if parent != nil {
+			parentClu = parent.firstCluster
+		}
+		entries.Write(dirEntry("..         ", attrDir, parentClu, 0, n.mtime))
+	}
+
+	// Children first get their clusters, so that the entries can point to them
+	used := map[string]bool{}
+	for _, c := range n.children {
+		size := len(c.data)
+		if c.dir {
+			// ".", "..", and the entries of the children, long names
+			// included
+			entries := 2
+			for _, cc := range c.children {
+				entries += 1 + longEntriesNeeded(cc.name)
+			}
+			size = entries * dirEntrySize
+		}
+		clu, err := w.alloc(size)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		c.firstCluster = uint16(clu)
+
+		short, exact := shortName(c.name, used)
+		used[short] = true
+		if !exact {
+			entries.Write(longEntries(c.name, short))
+		}
+		attr, fsize := byte(attrArchive), uint32(len(c.data))
+		if c.dir {
+			attr, fsize = attrDir, 0
+		}
+		entries.Write(dirEntry(short, attr, c.firstCluster, fsize, c.mtime))
+	}
+
+	if isRoot {
+		if entries.Len() > rootDirEntries*dirEntrySize {
+			return errors.Errorf("too many files in the root dir")
+		}
+		copy(w.img[w.l.rootDirSec()*sectorSize:], entries.Bytes())
+	} else {
+		copy(w.img[w.cluOffset(int(n.firstCluster)):], entries.Bytes())
+	}
+
+	for _, c := range n.children {
+		if c.dir {
+			var p *node
+			if !isRoot {
+				p = n
+			}
+			if err := w.writeDir(c, false, p); err != nil {
+				return errors.Trace(err)
+			}
+		} else if c.firstCluster != 0 {
+			copy(w.img[w.cluOffset(int(c.firstCluster)):], c.data)
+		}
+	}
+	return nil
+}
+
+func dirEntry(name string, attr byte, clu uint16, size uint32, mtime time.Time) []byte {
+	e := make([]byte, dirEntrySize)
+	copy(e, name)
+	e[11] = attr
+	t, d := fatTime(mtime)
+	binary.LittleEndian.PutUint16(e[14:], t) // Created
+	binary.LittleEndian.PutUint16(e[16:], d)
+	binary.LittleEndian.PutUint16(e[18:], d) // Accessed
+	binary.LittleEndian.PutUint16(e[22:], t) // Modified
+	binary.LittleEndian.PutUint16(e[24:], d)
+	binary.LittleEndian.PutUint16(e[26:], clu)
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return e
+}
+
+func fatTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	tm := uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	dt := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	return tm, dt
+}
+
+func pad(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// shortName returns the 11-char 8.3 name and whether it represents the name
+// exactly; if not, a long name entry is needed.
+func shortName(name string, used map[string]bool) (string, bool) {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	clean := func(s string) string {
+		var b bytes.Buffer
+		for _, c := range strings.ToUpper(s) {
+			if c < 0x80 && (c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'()-@^_`{}~", c)) {
+				b.WriteRune(c)
+			} else if c != ' ' && c != '.' {
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	cb, ce := clean(base), clean(ext)
+	exact := cb == base && ce == ext && len(cb) <= 8 && len(ce) <= 3 && cb != ""
+	if exact {
+		s := pad(cb, 8) + pad(ce, 3)
+		if !used[s] {
+			return s, true
+		}
+	}
+	if cb == "" {
+		cb = "_"
+	}
+	for i := 1; ; i++ {
+		suffix := "~" + strconv.Itoa(i)
+		b := cb
+		if len(b)+len(suffix) > 8 {
+			b = b[:8-len(suffix)]
+		}
+		s := pad(b+suffix, 8) + pad(ce, 3)
+		if !used[s] {
+			return s, false
+		}
+	}
+}
+
+func longEntriesNeeded(name string) int {
+	return (len(utf16.Encode([]rune(name))) + 12) / 13
+}
+
+// longEntries returns the long file name entries for the name, which go
+// before the short entry, last part first.
+func longEntries(name, short string) []byte {
+	var sum byte
+	for i := 0; i < 11; i++ {
+		sum = (sum>>1 | sum<<7) + short[i]
+	}
+	u := utf16.Encode([]rune(name))
+	n := longEntriesNeeded(name)
+	// Terminated with 0 and padded with 0xffff
+	if len(u) < n*13 {
+		u = append(u, 0)
+	}
+	for len(u) < n*13 {
+		u = append(u, 0xffff)
+	}
+	var res bytes.Buffer
+	for i := n; i >= 1; i-- {
+		e := make([]byte, dirEntrySize)
+		e[0] = byte(i)
+		if i == n {
+			e[0] |= lastLongEntry
+		}
+		e[11] = attrLongName
+		e[13] = sum
+		part := u[(i-1)*13 : i*13]
+		for j, c := range part {
+			var off int
+			switch {
+			case j < 5:
+				off = 1 + j*2
+			case j < 11:
+				off = 14 + (j-5)*2
+			default:
+				off = 28 + (j-11)*2
+			}
+			binary.LittleEndian.PutUint16(e[off:], c)
+		}
+		res.Write(e)
+	}
+	return res.Bytes()
+}
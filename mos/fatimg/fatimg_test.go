@@ -0,0 +1,177 @@
+package fatimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// reader is just enough of a FAT reader to check the images.
+type reader struct {
+	img                     []byte
+	secsPerClu, fatOff      int
+	rootOff, dataOff, fat12 int
+}
+
+func newReader(img []byte) *reader {
+	r := &reader{img: img}
+	r.secsPerClu = int(img[13])
+	reserved := int(binary.LittleEndian.Uint16(img[14:]))
+	fatSecs := int(binary.LittleEndian.Uint16(img[22:]))
+	rootEntries := int(binary.LittleEndian.Uint16(img[17:]))
+	r.fatOff = reserved * 512
+	r.rootOff = (reserved + int(img[16])*fatSecs) * 512
+	r.dataOff = r.rootOff + rootEntries*32
+	if string(img[54:59]) == "FAT12" {
+		r.fat12 = 1
+	}
+	return r
+}
+
+func (r *reader) next(clu int) int {
+	fat := r.img[r.fatOff:]
+	if r.fat12 == 1 {
+		v := int(binary.LittleEndian.Uint16(fat[clu*3/2:]))
+		if clu%2 == 1 {
+			return v >> 4
+		}
+		return v & 0xfff
+	}
+	v := int(binary.LittleEndian.Uint16(fat[clu*2:]))
+	if v >= 0xfff8 {
+		return 0xfff
+	}
+	return v
+}
+
+func (r *reader) read(clu, size int) []byte {
+	var res []byte
+	for clu >= 2 && clu < 0xff8 {
+		off := r.dataOff + (clu-2)*r.secsPerClu*512
+		res = append(res, r.img[off:off+r.secsPerClu*512]...)
+		clu = r.next(clu)
+	}
+	if size >= 0 {
+		res = res[:size]
+	}
+	return res
+}
+
+type entry struct {
+	name      string
+	dir       bool
+	clu, size int
+}
+
+func (r *reader) list(data []byte) []entry {
+	var res []entry
+	var long []uint16
+	for off := 0; off+32 <= len(data) && data[off] != 0; off += 32 {
+		e := data[off : off+32]
+		if e[11] == 0x0f {
+			var part []uint16
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part = append(part, binary.LittleEndian.Uint16(e[o:]))
+			}
+			long = append(part, long...)
+			continue
+		}
+		name := strings.TrimSpace(string(e[:8]))
+		if ext := strings.TrimSpace(string(e[8:11])); ext != "" {
+			name += "." + ext
+		}
+		if long != nil {
+			var u []uint16
+			for _, c := range long {
+				if c == 0 {
+					break
+				}
+				u = append(u, c)
+			}
+			name = string(utf16.Decode(u))
+			long = nil
+		}
+		res = append(res, entry{name, e[11]&0x10 != 0, int(binary.LittleEndian.Uint16(e[26:])), int(binary.LittleEndian.Uint32(e[28:]))})
+	}
+	return res
+}
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fatimg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	big := bytes.Repeat([]byte("0123456789"), 3000)
+	os.MkdirAll(filepath.Join(dir, "media", "sounds"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "INDEX.HTM"), []byte("<html/>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "settings.json"), []byte(`{"a":1}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "media", "sounds", "Long Sound Name.wav"), big, 0644)
+
+	for _, size := range []int64{MinSize, 8 * 1024 * 1024} {
+		img, err := Build(dir, size, "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(img)) != size {
+			t.Fatalf("image size %d, expected %d", len(img), size)
+		}
+		r := newReader(img)
+		if (size == MinSize) != (r.fat12 == 1) {
+			t.Errorf("%d: unexpected FAT type %s", size, img[54:62])
+		}
+		root := r.list(img[r.rootOff:r.dataOff])
+		names := map[string]entry{}
+		for _, e := range root {
+			names[e.name] = e
+		}
+		for _, n := range []string{"INDEX.HTM", "settings.json", "empty", "media"} {
+			if _, ok := names[n]; !ok {
+				t.Errorf("%d: %s is not in the root dir: %v", size, n, root)
+			}
+		}
+		if got := r.read(names["settings.json"].clu, names["settings.json"].size); string(got) != `{"a":1}` {
+			t.Errorf("%d: unexpected settings.json %q", size, got)
+		}
+		media := r.list(r.read(names["media"].clu, -1))
+		if len(media) != 3 || media[0].name != "." || media[2].name != "sounds" {
+			t.Fatalf("%d: unexpected media dir %v", size, media)
+		}
+		sounds := r.list(r.read(media[2].clu, -1))
+		if len(sounds) != 3 || sounds[1].clu != media[0].clu || sounds[2].name != "Long Sound Name.wav" {
+			t.Fatalf("%d: unexpected sounds dir %v", size, sounds)
+		}
+		if got := r.read(sounds[2].clu, sounds[2].size); !bytes.Equal(got, big) {
+			t.Errorf("%d: wav contents differ", size)
+		}
+	}
+
+	if _, err := Build(dir, MinSize/2, ""); err == nil {
+		t.Errorf("tiny image accepted")
+	}
+}
+
+func TestShortName(t *testing.T) {
+	used := map[string]bool{}
+	for _, c := range []struct {
+		name, short string
+		exact       bool
+	}{
+		{"README.TXT", "README  TXT", true},
+		{"readme.txt", "README~1TXT", false},
+		{"readme.txt", "README~2TXT", false},
+		{"verylongname.json", "VERYLO~1JSO", false},
+	} {
+		s, exact := shortName(c.name, used)
+		used[s] = true
+		if s != c.short || exact != c.exact {
+			t.Errorf("%s: got %q %v, want %q %v", c.name, s, exact, c.short, c.exact)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"cesanta.com/common/go/multierror"
@@ -31,6 +32,69 @@ var (
 	}
 )
 
+// allFlag is --all, which is shared by the commands which use it, each in its
+// own sense.
+var allFlag = flag.Bool("all", false, "mos clean: same as --deps --global-cache; mos console: show consoles of all serial ports; mos config doctor: also show flags at their defaults")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "all")
+}
+
+// Sources of flag values, in the order of precedence: a flag given on the
+// command line wins over the environment, which wins over the project
+// settings, and then the user settings.
+const (
+	sourceCommandLine     = "command line"
+	sourceEnv             = "environment"
+	sourceProjectSettings = "project settings"
+	sourceUserSettings    = "user settings"
+	sourceDefault         = "default"
+)
+
+// flagSources is where the values of the flags which are not at their
+// defaults come from, by flag name (see mos config doctor).
+var flagSources = map[string]string{}
+
+// recordFlagSources records the source of the flags set so far which don't
+// have one yet.
+func recordFlagSources(source func(f *flag.Flag) string) {
+	flag.Visit(func(f *flag.Flag) {
+		if _, ok := flagSources[f.Name]; !ok {
+			flagSources[f.Name] = source(f)
+		}
+	})
+}
+
+// envVarName returns the name of the environment variable for the flag,
+// as looked up by pflagenv.
+func envVarName(name string) string {
+	return envPrefix + strings.Replace(strings.ToUpper(name), "-", "_", -1)
+}
+
+// repeatedString is a string flag which can be given several times: its value
+// is the last one given, and all values are appended to all.
+type repeatedString struct {
+	val *string
+	all *[]string
+}
+
+func (f *repeatedString) String() string { return *f.val }
+func (f *repeatedString) Type() string   { return "string" }
+
+func (f *repeatedString) Set(v string) error {
+	*f.val = v
+	*f.all = append(*f.all, v)
+	return nil
+}
+
+// repeatedStringFlag defines a repeatedString flag and returns its value.
+func repeatedStringFlag(name, value string, all *[]string, usage string) *string {
+	p := new(string)
+	*p = value
+	flag.Var(&repeatedString{val: p, all: all}, name, usage)
+	return p
+}
+
 func initFlags() {
 	initATCAFlags()
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
@@ -3,6 +3,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -10,13 +12,18 @@ import (
 	"context"
 
 	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/boards"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/errcode"
+	"cesanta.com/mos/farm"
 	"cesanta.com/mos/flash/cc3200"
 	"cesanta.com/mos/flash/cc3220"
 	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/flash/dfu"
 	"cesanta.com/mos/flash/esp"
 	espFlasher "cesanta.com/mos/flash/esp/flasher"
 	"cesanta.com/mos/flash/stm32"
+	"cesanta.com/mos/guard"
 	"github.com/cesanta/errors"
 	flag "github.com/spf13/pflag"
 )
@@ -26,6 +33,10 @@ var (
 	cc3220FlashOpts cc3220.FlashOpts
 	espFlashOpts    esp.FlashOpts
 	stm32FlashOpts  stm32.FlashOpts
+	dfuFlashOpts    dfu.FlashOpts
+
+	esp32NVS     = ""
+	esp32NVSAddr = uint32(0)
 )
 
 // register advanced flash specific commands
@@ -65,6 +76,11 @@ func init() {
 			"Encryption is only applied to parts with encrypt=true.")
 	flag.Uint32Var(&espFlashOpts.ESP32FlashCryptConf, "esp32-flash-crypt-conf", 0xf,
 		"Value of the FLASH_CRYPT_CONF eFuse setting, affecting how key is tweaked.")
+	flag.StringVar(&esp32NVS, "esp32-nvs", "",
+		"If specified, the NVS partition is flashed with this image, or generated from "+
+			"this CSV file (see mos nvs).")
+	flag.Uint32Var(&esp32NVSAddr, "esp32-nvs-addr", 0x9000,
+		"Address of the NVS partition, if the firmware doesn't define it.")
 
 	// STM32
 	if runtime.GOOS == "windows" {
@@ -75,21 +91,44 @@ func init() {
 	} else {
 		flag.DurationVar(&stm32FlashOpts.Timeout, "flash-timeout", 30*time.Second, "Maximum flashing time")
 	}
+	// USB DFU, used with --port dfu or dfu://VID:PID
+	flag.StringVar(&dfuFlashOpts.DfuUtil, "dfu-util", "dfu-util", "dfu-util binary to use for flashing DFU devices")
+	flag.BoolVar(&dfuFlashOpts.Reset, "dfu-reset", true, "Leave DFU mode and start the firmware after flashing")
+
 	// add these flags to the hiddenFlags list so that they can be hidden and shown again with --helpfull
 	flag.VisitAll(func(f *flag.Flag) {
-		if strings.HasPrefix(f.Name, "cc3200-") || strings.HasPrefix(f.Name, "esp-") || strings.HasPrefix(f.Name, "esp32-") {
+		if strings.HasPrefix(f.Name, "cc3200-") || strings.HasPrefix(f.Name, "esp-") || strings.HasPrefix(f.Name, "esp32-") || strings.HasPrefix(f.Name, "dfu-") {
 			hiddenFlags = append(hiddenFlags, f.Name)
 		}
 	})
 }
 
+// applyBoardFlashOpts sets flashing params from the board profile, unless
+// they are given explicitly.
+func applyBoardFlashOpts(b *boards.Board) {
+	if b.FlashParams != "" && !flag.Lookup("esp-flash-params").Changed {
+		espFlashOpts.FlashParams = b.FlashParams
+	}
+	if b.FlashBaudRate != 0 && !flag.Lookup("esp-baud-rate").Changed {
+		espFlashOpts.FlasherBaudRate = b.FlashBaudRate
+	}
+}
+
 func flash(ctx context.Context, devConn *dev.DevConn) error {
 	fwname := *firmware
 	args := flag.Args()
 	if len(args) == 2 {
 		fwname = args[1]
 	}
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(flashFirmware(ctx, devConn, fwname, port))
+}
 
+// flashFirmware flashes the given fw zip to the device on the given port.
+func flashFirmware(ctx context.Context, devConn *dev.DevConn, fwname, port string) error {
 	fw, err := common.NewZipFirmwareBundle(fwname)
 	if err != nil {
 		return errors.Annotatef(err, "failed to load %s", fwname)
@@ -107,13 +146,77 @@ func flash(ctx context.Context, devConn *dev.DevConn) error {
 		defer devConn.Connect(ctx, devConn.Reconnect)
 	}
 
-	port, err := getPort()
+	op, description := guard.OpFlash, "flash "+fw.Name
+	if espFlashOpts.EraseChip {
+		// Wipes everything, like mos wipe
+		op, description = guard.OpFlashErase, "erase the whole flash and flash "+fw.Name
+	}
+	if _, err := guardOperation(op, port, description); err != nil {
+		return errors.Trace(err)
+	}
+
+	fwnameAbs, err := filepath.Abs(fwname)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	hookEnv := map[string]string{
+		"MOS_PLATFORM": fw.Platform,
+		"MOS_FW_ZIP":   fwnameAbs,
+		"MOS_PORT":     port,
+	}
+	if err := runHooks(hookPreFlash, hookEnv, os.Stderr); err != nil {
+		return errors.Trace(err)
+	}
 
 	espFlashOpts.InvertedControlLines = *invertedControlLines
 
+	switch {
+	case farm.IsPort(port):
+		// Flashed by the agent the device is attached to
+		err = farmFlash(fwname, port)
+	case port == "dfu" || strings.HasPrefix(port, "dfu://"):
+		// Any DFU-capable target, regardless of platform
+		dfuFlashOpts.Device = strings.TrimPrefix(port, "dfu://")
+		if dfuFlashOpts.Device == "dfu" {
+			dfuFlashOpts.Device = ""
+		}
+		err = dfu.Flash(fw, &dfuFlashOpts)
+	default:
+		var resume func()
+		if resume, err = suspendPortHolder(port); err != nil {
+			break
+		}
+		err = flashPlatform(fw, port)
+		resume()
+	}
+
+	if err != nil {
+		return errors.Trace(errcode.Wrap(errcode.FlashFailed, err))
+	}
+
+	if err := runHooks(hookPostFlash, hookEnv, os.Stderr); err != nil {
+		return errors.Trace(err)
+	}
+
+	if *verifyBoot > 0 {
+		if farm.IsPort(port) || port == "dfu" || strings.HasPrefix(port, "dfu://") {
+			return errors.Errorf("--verify-boot needs the console of the device on a serial port")
+		}
+		if err := verifyFlashedBoot(fw, fwname, port); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := runSmokeTest(ctx, fwname, port); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("All done!")
+
+	return nil
+}
+
+func flashPlatform(fw *common.FirmwareBundle, port string) error {
+	var err error
 	switch strings.ToLower(fw.Platform) {
 	case "cc3200":
 		cc3200FlashOpts.Port = port
@@ -122,6 +225,11 @@ func flash(ctx context.Context, devConn *dev.DevConn) error {
 		cc3220FlashOpts.Port = port
 		err = cc3220.Flash(fw, &cc3220FlashOpts)
 	case "esp32":
+		if esp32NVS != "" {
+			if err := addNVSPart(fw); err != nil {
+				return errors.Trace(err)
+			}
+		}
 		espFlashOpts.ControlPort = port
 		err = espFlasher.Flash(esp.ChipESP32, fw, &espFlashOpts)
 	case "esp8266":
@@ -133,10 +241,30 @@ func flash(ctx context.Context, devConn *dev.DevConn) error {
 	default:
 		err = errors.Errorf("%s: unsupported platform '%s'", *firmware, fw.Platform)
 	}
+	return errors.Trace(err)
+}
 
-	if err == nil {
-		ourutil.Reportf("All done!")
+// addNVSPart adds the NVS partition image given by --esp32-nvs to the fw
+// bundle. If the firmware has the nvs part already, it's replaced, keeping
+// the address and size.
+func addNVSPart(fw *common.FirmwareBundle) error {
+	size, err := getNVSSize()
+	if err != nil {
+		return errors.Trace(err)
 	}
-
-	return errors.Trace(err)
+	addr := esp32NVSAddr
+	if p := fw.Parts["nvs"]; p != nil && p.ESPFlashAddress != 0 {
+		addr = p.ESPFlashAddress
+		if p.Size != 0 {
+			size = int(p.Size)
+		}
+	}
+	data, err := getNVSImage(esp32NVS, size)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fw.Parts["nvs"] = &common.FirmwarePart{Name: "nvs", Src: "nvs.bin", ESPFlashAddress: addr, Size: uint32(len(data))}
+	fw.Blobs["nvs.bin"] = data
+	ourutil.Reportf("Flashing NVS partition from %s at 0x%x", esp32NVS, addr)
+	return nil
 }
@@ -53,6 +53,9 @@ type FirmwarePart struct {
 	CC32XXFileSignatureOld string `json:"sign,omitempty"` // Deprecated since 2017/08/22
 	CC32XXFileSignature    string `json:"sig,omitempty"`
 	CC32XXSigningCert      string `json:"sig_cert,omitempty"`
+	// USB DFU targets
+	DFUAlt     *int    `json:"dfu_alt,omitempty"`
+	DFUAddress *uint32 `json:"dfu_addr,omitempty"`
 }
 
 func (fw *FirmwareBundle) GetTempDir() (string, error) {
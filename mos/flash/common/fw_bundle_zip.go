@@ -6,11 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
 	"strings"
 
+	"cesanta.com/mos/download"
 	"cesanta.com/mos/version"
 
 	"github.com/cesanta/errors"
@@ -51,17 +51,9 @@ func NewZipFirmwareBundle(fname string) (*FirmwareBundle, error) {
 
 	if strings.HasPrefix(fname, "http://") || strings.HasPrefix(fname, "https://") {
 		Reportf("Fetching %s...", fname)
-		resp, err := http.Get(fname)
+		b, err := download.Get(fname)
 		if err != nil {
-			return nil, errors.Annotatef(err, "%s: failed to fetch", fname)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			return nil, errors.Errorf("%s: failed to fetch: %s", fname, resp.Status)
-		}
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, errors.Annotatef(err, "%s: failed to fetch body", fname)
+			return nil, errors.Trace(err)
 		}
 		r, err = zip.NewReader(bytes.NewReader(b), int64(len(b)))
 	} else {
@@ -104,3 +96,66 @@ func NewZipFirmwareBundle(fname string) (*FirmwareBundle, error) {
 	}
 	return fwb, nil
 }
+
+// UpdateZipFirmwareBundle rewrites the firmware zip file with the parts and
+// blobs of the bundle. New blobs are placed next to the manifest.
+func UpdateZipFirmwareBundle(fname string, fw *FirmwareBundle) error {
+	rc, err := zip.OpenReader(fname)
+	if err != nil {
+		return errors.Annotatef(err, "%s: invalid firmware file", fname)
+	}
+	defer rc.Close()
+
+	// Only update parts, to keep fields of the manifest we don't know about
+	var m map[string]interface{}
+	if err := json.Unmarshal(fw.Blobs[manifestFileName], &m); err != nil {
+		return errors.Annotatef(err, "%s: failed to parse manifest", fname)
+	}
+	m["parts"] = fw.Parts
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	blobs := map[string][]byte{}
+	for n, data := range fw.Blobs {
+		blobs[n] = data
+	}
+	blobs[manifestFileName] = manifest
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	dir := ""
+	for _, f := range rc.File {
+		name := path.Base(f.Name)
+		if name == manifestFileName {
+			dir = path.Dir(f.Name)
+		}
+		data, ok := blobs[name]
+		if !ok {
+			// Removed from the bundle
+			continue
+		}
+		delete(blobs, name)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for name, data := range blobs {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(dir, name), Method: zip.Deflate})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	rc.Close()
+	return errors.Trace(ioutil.WriteFile(fname, buf.Bytes(), 0644))
+}
@@ -0,0 +1,226 @@
+// Package dfu implements flashing of USB DFU-capable targets (like STM32 in
+// the system bootloader mode) with dfu-util.
+package dfu
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cesanta.com/mos/flash/common"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+)
+
+type FlashOpts struct {
+	// DfuUtil is the dfu-util binary.
+	DfuUtil string
+	// Device is the vendor:product ID of the device; if empty, the only
+	// connected DFU device is used.
+	Device string
+	// Reset, if set, makes the device leave DFU mode and start the app after
+	// flashing.
+	Reset bool
+}
+
+// Device is an interface (alt setting) of a DFU device, as listed by
+// "dfu-util -l".
+type Device struct {
+	ID     string // vendor:product
+	Path   string
+	Alt    int
+	Name   string
+	Serial string
+}
+
+// BaseAddress returns the start address of the memory described by the DfuSe
+// alt setting name, like "@Internal Flash  /0x08000000/04*016Kg,01*064Kg".
+func (d *Device) BaseAddress() (uint32, bool) {
+	parts := strings.Split(d.Name, "/")
+	if len(parts) < 2 {
+		return 0, false
+	}
+	addr, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 0, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(addr), true
+}
+
+var foundRegexp = regexp.MustCompile(`^Found DFU: \[([0-9a-fA-F]{4}:[0-9a-fA-F]{4})\].*?(?:path="([^"]*)")?, alt=(\d+), name="([^"]*)"(?:, serial="([^"]*)")?`)
+
+// ParseList parses the output of "dfu-util -l".
+func ParseList(out string) []*Device {
+	var res []*Device
+	for _, line := range strings.Split(out, "\n") {
+		m := foundRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		alt, _ := strconv.Atoi(m[3])
+		res = append(res, &Device{
+			ID:     strings.ToLower(m[1]),
+			Path:   m[2],
+			Alt:    alt,
+			Name:   m[4],
+			Serial: m[5],
+		})
+	}
+	return res
+}
+
+// List returns connected DFU devices.
+func List(dfuUtil string) ([]*Device, error) {
+	out, err := exec.Command(dfuUtil, "-l").CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, errors.Annotatef(err, "failed to run %s, is dfu-util installed?", dfuUtil)
+		}
+		// Some versions of dfu-util exit with non-zero status when there are
+		// no devices
+		glog.Infof("%s -l: %s\n%s", dfuUtil, err, out)
+	}
+	return ParseList(string(out)), nil
+}
+
+// Download is a single dfu-util invocation writing a part.
+type Download struct {
+	Part    string
+	Alt     int
+	Address uint32
+}
+
+// Plan determines the alt settings and addresses to write fw parts to. Parts
+// may specify them explicitly with dfu_alt and dfu_addr; parts without either
+// are skipped. If no part has them, the "boot" part is written to the start
+// of the alt setting 0 (which is the internal flash for DfuSe devices).
+func Plan(fw *common.FirmwareBundle, alts []*Device) ([]*Download, error) {
+	var res []*Download
+	for name, p := range fw.Parts {
+		if p.DFUAlt == nil && p.DFUAddress == nil {
+			continue
+		}
+		d := &Download{Part: name}
+		if p.DFUAlt != nil {
+			d.Alt = *p.DFUAlt
+		}
+		if p.DFUAddress != nil {
+			d.Address = *p.DFUAddress
+		} else {
+			addr, err := altBaseAddress(alts, d.Alt)
+			if err != nil {
+				return nil, errors.Annotatef(err, "%s", name)
+			}
+			d.Address = addr
+		}
+		res = append(res, d)
+	}
+	if len(res) == 0 {
+		if fw.Parts["boot"] == nil {
+			return nil, errors.Errorf("no parts to flash via DFU: none have dfu_alt or dfu_addr, and there is no boot part")
+		}
+		addr, err := altBaseAddress(alts, 0)
+		if err != nil {
+			return nil, errors.Annotatef(err, "boot")
+		}
+		res = append(res, &Download{Part: "boot", Address: addr})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Alt != res[j].Alt {
+			return res[i].Alt < res[j].Alt
+		}
+		return res[i].Address < res[j].Address
+	})
+	return res, nil
+}
+
+func altBaseAddress(alts []*Device, alt int) (uint32, error) {
+	for _, d := range alts {
+		if d.Alt == alt {
+			if addr, ok := d.BaseAddress(); ok {
+				return addr, nil
+			}
+			return 0, errors.Errorf("alt %d (%q) has no address, set dfu_addr", alt, d.Name)
+		}
+	}
+	return 0, errors.Errorf("device has no alt setting %d", alt)
+}
+
+// selectDevice returns alt settings of the device to flash.
+func selectDevice(devs []*Device, id string) ([]*Device, error) {
+	id = strings.ToLower(id)
+	var res []*Device
+	paths := map[string]bool{}
+	for _, d := range devs {
+		if id != "" && d.ID != id {
+			continue
+		}
+		res = append(res, d)
+		paths[d.ID+" "+d.Path] = true
+	}
+	if len(res) == 0 {
+		if id != "" {
+			return nil, errors.Errorf("DFU device %s not found", id)
+		}
+		return nil, errors.Errorf("no DFU devices found, is the device in DFU mode?")
+	}
+	if len(paths) > 1 {
+		var ids []string
+		for p := range paths {
+			ids = append(ids, p)
+		}
+		sort.Strings(ids)
+		return nil, errors.Errorf("multiple DFU devices found (%s), please specify one with --port dfu://VID:PID", strings.Join(ids, ", "))
+	}
+	return res, nil
+}
+
+func Flash(fw *common.FirmwareBundle, opts *FlashOpts) error {
+	devs, err := List(opts.DfuUtil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	alts, err := selectDevice(devs, opts.Device)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dev := alts[0]
+	common.Reportf("Using DFU device %s at %s", dev.ID, dev.Path)
+
+	dls, err := Plan(fw, alts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for i, dl := range dls {
+		fname, size, err := fw.GetPartDataFile(dl.Part)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		addr := fmt.Sprintf("0x%08x", dl.Address)
+		if opts.Reset && i == len(dls)-1 {
+			addr += ":leave"
+		}
+		args := []string{"-d", dev.ID, "-a", strconv.Itoa(dl.Alt), "-s", addr, "-D", fname}
+		if dev.Path != "" {
+			args = append(args, "-p", dev.Path)
+		}
+		common.Reportf("Writing %s (%d bytes) to alt %d @ 0x%x...", dl.Part, size, dl.Alt, dl.Address)
+		glog.Infof("%s %s", opts.DfuUtil, strings.Join(args, " "))
+		out, err := exec.Command(opts.DfuUtil, args...).CombinedOutput()
+		if err != nil {
+			// dfu-util fails to get the status after :leave on some devices,
+			// even though the image has been written
+			if strings.HasSuffix(addr, ":leave") && strings.Contains(string(out), "File downloaded successfully") {
+				glog.Warningf("%s: %s", opts.DfuUtil, err)
+				continue
+			}
+			return errors.Annotatef(err, "dfu-util failed: %s", out)
+		}
+		glog.V(1).Infof("%s", out)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package dfu
+
+import (
+	"reflect"
+	"testing"
+
+	"cesanta.com/mos/flash/common"
+)
+
+const listOutput = `dfu-util 0.9
+
+Copyright 2005-2009 Weston Schmidt, Harald Welte and OpenMoko Inc.
+
+Found DFU: [0483:df11] ver=2200, devnum=5, cfg=1, intf=0, path="1-1.2", alt=1, name="@Option Bytes  /0x1FFFC000/01*016 e", serial="376535743231"
+Found DFU: [0483:DF11] ver=2200, devnum=5, cfg=1, intf=0, path="1-1.2", alt=0, name="@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg", serial="376535743231"
+Found Runtime: [05ac:8290] ver=0104, devnum=2, cfg=1, intf=5, path="1-3", alt=0, name="UNKNOWN", serial="UNKNOWN"
+`
+
+func TestParseList(t *testing.T) {
+	devs := ParseList(listOutput)
+	if len(devs) != 2 {
+		t.Fatalf("expected 2 alt settings, got %d", len(devs))
+	}
+	want := &Device{ID: "0483:df11", Path: "1-1.2", Alt: 0, Name: "@Internal Flash  /0x08000000/04*016Kg,01*064Kg,07*128Kg", Serial: "376535743231"}
+	if !reflect.DeepEqual(devs[1], want) {
+		t.Errorf("got %+v, want %+v", devs[1], want)
+	}
+	if addr, ok := devs[0].BaseAddress(); !ok || addr != 0x1FFFC000 {
+		t.Errorf("unexpected base address %x %v", addr, ok)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	alts := ParseList(listOutput)
+
+	fw := &common.FirmwareBundle{}
+	fw.Parts = map[string]*common.FirmwarePart{
+		"boot": {Src: "fw.bin"},
+		"fs":   {Src: "fs.bin"},
+	}
+	dls, err := Plan(fw, alts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dls, []*Download{{Part: "boot", Alt: 0, Address: 0x08000000}}) {
+		t.Errorf("unexpected plan: %+v", dls[0])
+	}
+
+	one, fsAddr := 1, uint32(0x08100000)
+	fw.Parts["fs"].DFUAddress = &fsAddr
+	fw.Parts["boot"].DFUAlt = new(int)
+	fw.Parts["opts"] = &common.FirmwarePart{Src: "opts.bin", DFUAlt: &one}
+	dls, err = Plan(fw, alts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Download{
+		{Part: "boot", Alt: 0, Address: 0x08000000},
+		{Part: "fs", Alt: 0, Address: 0x08100000},
+		{Part: "opts", Alt: 1, Address: 0x1FFFC000},
+	}
+	if !reflect.DeepEqual(dls, want) {
+		t.Errorf("unexpected plan: %+v %+v %+v", dls[0], dls[1], dls[2])
+	}
+
+	two := 2
+	fw.Parts["opts"].DFUAlt = &two
+	if _, err := Plan(fw, alts); err == nil {
+		t.Errorf("expected an error for missing alt setting")
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	devs := ParseList(listOutput)
+	if alts, err := selectDevice(devs, ""); err != nil || len(alts) != 2 {
+		t.Errorf("unexpected result: %v %v", alts, err)
+	}
+	if _, err := selectDevice(devs, "1234:5678"); err == nil {
+		t.Errorf("expected an error")
+	}
+	devs = append(devs, &Device{ID: "0483:df11", Path: "1-1.3"})
+	if _, err := selectDevice(devs, "0483:DF11"); err == nil {
+		t.Errorf("expected an error for multiple devices")
+	}
+}
@@ -0,0 +1,61 @@
+package flasher
+
+import (
+	"bytes"
+	"time"
+
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/flash/esp"
+	"github.com/cesanta/errors"
+)
+
+// Region is a region of flash to erase.
+type Region struct {
+	Name string
+	Addr uint32
+	Size uint32
+}
+
+// EraseFlash erases the given regions of flash, or the entire chip if no
+// regions are given. Regions must be sector-aligned.
+func EraseFlash(ct esp.ChipType, regions []Region, opts *esp.FlashOpts) error {
+	cfr, err := ConnectToFlasherClient(ct, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cfr.rc.Disconnect()
+
+	flashSize := uint32(cfr.flashParams.Size())
+	start := time.Now()
+	if len(regions) == 0 {
+		common.Reportf("Erasing chip...")
+		if err := cfr.fc.EraseChip(); err != nil {
+			return errors.Annotatef(err, "failed to erase chip")
+		}
+	} else {
+		for _, r := range regions {
+			if r.Addr%flashSectorSize != 0 || r.Size%flashSectorSize != 0 {
+				return errors.Errorf("%s: 0x%x-0x%x is not aligned to sector size", r.Name, r.Addr, r.Addr+r.Size)
+			}
+			if r.Addr+r.Size > flashSize {
+				return errors.Errorf("%s: 0x%x-0x%x exceeds flash size (%d)", r.Name, r.Addr, r.Addr+r.Size, flashSize)
+			}
+			common.Reportf("Erasing %s (%d @ 0x%x)...", r.Name, r.Size, r.Addr)
+			// There is no erase command, so we write 0xff which erases the
+			// sectors and, being compressed, is cheap to transfer.
+			data := bytes.Repeat([]byte{0xff}, int(r.Size))
+			if _, err := cfr.fc.Write(r.Addr, data, true /* erase */, true /* compress */); err != nil {
+				return errors.Annotatef(err, "%s: failed to erase", r.Name)
+			}
+		}
+	}
+	common.Reportf("Erased in %.2f seconds", time.Since(start).Seconds())
+
+	if opts.BootFirmware {
+		common.Reportf("Booting firmware...")
+		if err := cfr.fc.BootFirmware(); err != nil {
+			return errors.Annotatef(err, "failed to reboot into firmware")
+		}
+	}
+	return nil
+}
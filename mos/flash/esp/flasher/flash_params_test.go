@@ -0,0 +1,38 @@
+package flasher
+
+import (
+	"fmt"
+	"testing"
+
+	"cesanta.com/mos/boards"
+	"cesanta.com/mos/flash/esp"
+)
+
+func TestBuiltinBoardsFlashParams(t *testing.T) {
+	all, err := boards.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range all {
+		var ct esp.ChipType
+		switch b.Platform {
+		case "esp8266":
+			ct = esp.ChipESP8266
+		case "esp32":
+			ct = esp.ChipESP32
+		default:
+			continue
+		}
+		if b.FlashParams == "" {
+			continue
+		}
+		var fp flashParams
+		if err := fp.ParseString(ct, b.FlashParams); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if size := b.AllBuildVars()["FLASH_SIZE"]; size != "" && size != fmt.Sprintf("%d", fp.Size()) {
+			t.Errorf("%s: flash_params size is %d, FLASH_SIZE is %s", name, fp.Size(), size)
+		}
+	}
+}
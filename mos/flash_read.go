@@ -50,6 +50,12 @@ func flashRead(ctx context.Context, devConn *dev.DevConn) error {
 		return errors.Trace(err)
 	}
 
+	resume, err := suspendPortHolder(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resume()
+
 	var data []byte
 	switch *platform {
 	case "cc3200":
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/errcode"
+	"cesanta.com/mos/rollout"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var skipSmokeTest = flag.Bool("skip-smoke-test", false, "mos flash: don't run the smoke_test of mos.yml after flashing")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "skip-smoke-test")
+}
+
+// runSmokeTest runs the smoke_test calls of the project manifest, if any, on
+// the just flashed device: after --check-wait, all the calls have to succeed,
+// within --check-attempts attempts. It's only done when the flashed firmware
+// is the one built in the project, since the calls are about it.
+func runSmokeTest(ctx context.Context, fwname, port string) error {
+	if *skipSmokeTest {
+		return nil
+	}
+	fwAbs, err := filepath.Abs(fwname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	projectFwAbs, err := filepath.Abs(moscommon.GetFirmwareZipFilePath(moscommon.GetBuildDir(projectDir)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if fwAbs != projectFwAbs {
+		glog.Infof("%s is not the firmware of the project, not running the smoke test", fwname)
+		return nil
+	}
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	if _, err := os.Stat(manifestPath); err != nil {
+		return nil
+	}
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(manifest.SmokeTest) == 0 {
+		return nil
+	}
+	var checks []*rollout.Check
+	for _, s := range manifest.SmokeTest {
+		if s.Method == "" {
+			return errors.Errorf("smoke_test: method is required")
+		}
+		checks = append(checks, &rollout.Check{Method: s.Method, Args: s.Args, Expect: s.Expect})
+	}
+
+	ourutil.Reportf("Running the smoke test, %d calls...", len(checks))
+	time.Sleep(*rolloutCheckWait)
+	for i := 0; i < *rolloutCheckAttempts; i++ {
+		if i > 0 {
+			glog.Warningf("smoke test failed: %s", err)
+			time.Sleep(*rolloutCheckWait)
+		}
+		if err = runSmokeTestOnce(ctx, port, checks); err == nil {
+			ourutil.Reportf("Smoke test passed")
+			return nil
+		}
+	}
+	return errcode.Errorf(errcode.BootFailed, "smoke test failed: %s", err)
+}
+
+func runSmokeTestOnce(ctx context.Context, port string, checks []*rollout.Check) error {
+	for _, c := range checks {
+		if err := rolloutCheckOnce(ctx, port, c); err != nil {
+			return errors.Annotatef(err, "%s", c.Method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/bootcheck"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/errcode"
+	"cesanta.com/mos/flash/common"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	verifyBoot    = flag.Duration("verify-boot", 0, "mos flash: after flashing, watch the console for this long and fail if the firmware crashes or keeps rebooting")
+	bootLoopCount = flag.Int("boot-loop-count", 3, "mos flash --verify-boot: number of boots after which the device is considered boot looping")
+	rollbackFW    = flag.String("rollback-fw", "", "mos flash --verify-boot: firmware zip, like the previous release, to flash if the verification fails")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "verify-boot", "boot-loop-count", "rollback-fw")
+}
+
+// addr2line tools of the platforms, to decode backtraces with
+var platformAddr2line = map[string]string{
+	"esp32":   "xtensa-esp32-elf-addr2line",
+	"esp8266": "xtensa-lx106-elf-addr2line",
+	"cc3200":  "arm-none-eabi-addr2line",
+	"cc3220":  "arm-none-eabi-addr2line",
+	"stm32":   "arm-none-eabi-addr2line",
+}
+
+// verifyFlashedBoot watches the console of the just flashed device and
+// returns an error with the verdict if the firmware crashes or keeps
+// rebooting. On failure, the firmware given with --rollback-fw is flashed.
+func verifyFlashedBoot(fw *common.FirmwareBundle, fwname, port string) error {
+	ourutil.Reportf("Verifying the boot for %s...", *verifyBoot)
+	v, err := watchBoot(port, *verifyBoot)
+	if err != nil {
+		return errors.Annotatef(err, "verifying the boot")
+	}
+	var decoded []string
+	if len(v.Backtrace) > 0 {
+		decoded = decodeBacktrace(fw.Platform, fwname, v.Backtrace)
+	}
+
+	if *fsJSON {
+		data, _ := json.MarshalIndent(struct {
+			*bootcheck.Verdict
+			Decoded []string `json:"decoded_backtrace,omitempty"`
+		}{v, decoded}, "", "  ")
+		fmt.Printf("%s\n", data)
+	} else {
+		for _, l := range v.Panic {
+			reportf("  %s", l)
+		}
+		if len(decoded) > 0 {
+			reportf("Decoded backtrace:")
+			for _, l := range decoded {
+				reportf("  %s", l)
+			}
+		}
+	}
+	if v.Status == bootcheck.StatusOK {
+		ourutil.Reportf("Boot verified: %s, %d boots", v, v.Boots)
+		return nil
+	}
+
+	verr := errcode.Errorf(errcode.BootFailed, "%s", v)
+	if *rollbackFW == "" {
+		return verr
+	}
+	reportf("Boot verification failed: %s; rolling back to %s...", v, *rollbackFW)
+	rfw, err := common.NewZipFirmwareBundle(*rollbackFW)
+	if err != nil {
+		return errors.Annotatef(err, "%s, and failed to load the rollback firmware", verr)
+	}
+	if !*keepTempFiles {
+		defer rfw.Cleanup()
+	}
+	if err := flashPlatform(rfw, port); err != nil {
+		return errors.Annotatef(err, "%s, and failed to flash the rollback firmware", verr)
+	}
+	return errcode.Errorf(errcode.BootFailed, "%s; rolled back to %s", v, *rollbackFW)
+}
+
+// watchBoot reads the console for the duration, or until the verdict is
+// final.
+func watchBoot(port string, d time.Duration) (*bootcheck.Verdict, error) {
+	cp, err := newConsolePort(port)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := cp.resume(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := cp.wait()
+	if s == nil {
+		return nil, errors.Errorf("%s failed to reopen", port)
+	}
+	defer s.Close()
+
+	// Closing the port stops the reader once we return, and done unblocks it
+	// if it's sending a line nobody reads anymore
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(s)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	checker := bootcheck.NewChecker(*bootLoopCount)
+	timeout := time.After(d)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return checker.Verdict(), nil
+			}
+			if *verbose {
+				reportf("%s", line)
+			}
+			if checker.Feed(line) {
+				return checker.Verdict(), nil
+			}
+		case <-timeout:
+			return checker.Verdict(), nil
+		}
+	}
+}
+
+// decodeBacktrace returns the function and source line of the addresses,
+// decoded with addr2line in the build container, if the ELF of the firmware
+// is there: only for the firmware of the local build. Returns nil if the
+// addresses can't be decoded.
+func decodeBacktrace(platform, fwname string, addrs []string) []string {
+	tool := platformAddr2line[strings.ToLower(platform)]
+	buildDir, err := filepath.Abs(moscommon.GetBuildDir(projectDir))
+	if err != nil || tool == "" {
+		return nil
+	}
+	if fwAbs, err := filepath.Abs(fwname); err != nil || fwAbs != moscommon.GetFirmwareZipFilePath(buildDir) {
+		return nil
+	}
+	elf := moscommon.GetFirmwareElfFilePath(buildDir)
+	if _, err := os.Stat(elf); err != nil {
+		return nil
+	}
+	image, err := getToolchainImage("")
+	if err != nil {
+		return nil
+	}
+	args := append([]string{tool, "-pfiaC", "-e", getPathForDocker(elf)}, addrs...)
+	out, err := runToolchainCommand(image, []string{filepath.Dir(elf)}, filepath.Dir(elf), "", args)
+	if err != nil {
+		glog.Warningf("failed to decode the backtrace: %s: %s", err, out)
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
@@ -0,0 +1,100 @@
+// +build !noflash
+
+package main
+
+import (
+	"strings"
+
+	"context"
+
+	"cesanta.com/mos/flash/esp"
+	espFlasher "cesanta.com/mos/flash/esp/flasher"
+	"cesanta.com/mos/ptable"
+	"github.com/cesanta/errors"
+)
+
+// wipeFlash erases the given targets (fs, ota, nvs or all) in flash.
+func wipeFlash(ctx context.Context, targets []string) error {
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	resume, err := suspendPortHolder(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resume()
+
+	var ct esp.ChipType
+	switch *platform {
+	case "esp32":
+		ct = esp.ChipESP32
+	case "esp8266":
+		ct = esp.ChipESP8266
+	case "cc3200", "cc3220", "stm32":
+		return errors.NotImplementedf("wiping %s on %s", strings.Join(targets, ", "), *platform)
+	default:
+		return errors.Errorf("unsupported platform '%s'", *platform)
+	}
+
+	espFlashOpts.ControlPort = port
+	opts := espFlashOpts
+	if len(targets) == 1 && targets[0] == "all" {
+		// There is nothing to boot.
+		opts.BootFirmware = false
+		return errors.Trace(espFlasher.EraseFlash(ct, nil, &opts))
+	}
+	if ct != esp.ChipESP32 {
+		return errors.NotImplementedf("wiping %s on %s, only all is supported", strings.Join(targets, ", "), *platform)
+	}
+
+	ptData, err := espFlasher.ReadFlash(ct, ptable.Offset, ptable.MaxSize, &espFlashOpts)
+	if err != nil {
+		return errors.Annotatef(err, "failed to read partition table")
+	}
+	t, err := ptable.ParseBinary(ptData)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	regions, err := esp32WipeRegions(t, targets)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(espFlasher.EraseFlash(ct, regions, &opts))
+}
+
+// esp32WipeRegions returns partitions to erase for the given targets. For ota,
+// these are the OTA data and all the app slots except the factory or ota_0
+// one, which the bootloader falls back to.
+func esp32WipeRegions(t *ptable.Table, targets []string) ([]espFlasher.Region, error) {
+	var regions []espFlasher.Region
+	for _, target := range targets {
+		n := len(regions)
+		for _, p := range t.Partitions {
+			what := ""
+			switch p.Type {
+			case ptable.TypeApp:
+				if p.SubType > ptable.SubTypeOTA0 && p.SubType < ptable.SubTypeOTA0+16 {
+					what = "ota"
+				}
+			case ptable.TypeData:
+				switch p.SubType {
+				case ptable.SubTypeOTAData:
+					what = "ota"
+				case ptable.SubTypeNVS:
+					what = "nvs"
+				case ptable.SubTypeFAT, ptable.SubTypeSPIFFS:
+					what = "fs"
+				}
+			}
+			if what == target {
+				regions = append(regions, espFlasher.Region{Name: p.Name, Addr: p.Offset, Size: p.Size})
+			}
+		}
+		if len(regions) == n {
+			return nil, errors.Errorf("no %s partitions found", target)
+		}
+	}
+	return regions, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"context"
+
+	"cesanta.com/common/go/ourio"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	fmtCheck = flag.Bool("fmt-check", false, "mos fmt: only list the files which are not formatted, and fail if there are any; mos build: run this check before building")
+	fmtImage = flag.String("fmt-image", "", "mos fmt: container image with clang-format to use instead of the build image pinned in mos.lock")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "fmt-check", "fmt-image")
+}
+
+// mgosClangFormatStyle is the mongoose-os code style, used unless the project
+// has its own .clang-format.
+const mgosClangFormatStyle = "{BasedOnStyle: Google, AllowShortFunctionsOnASingleLine: Empty, " +
+	"AllowShortIfStatementsOnASingleLine: false, AllowShortLoopsOnASingleLine: false, " +
+	"DerivePointerAlignment: false, PointerAlignment: Right, SortIncludes: false}"
+
+var fmtSourceExts = map[string]bool{
+	".c": true, ".cpp": true, ".cc": true, ".h": true, ".hpp": true,
+}
+
+// fmtHandler implements "mos fmt [file...]": formats the C/C++ sources and
+// headers of the app, by default the ones under the project dir which
+// sources and includes of mos.yml refer to. With --fmt-check, files are not
+// changed, and the ones which need formatting are listed.
+func fmtHandler(ctx context.Context, devConn *dev.DevConn) error {
+	files := flag.Args()[1:]
+	if len(files) == 0 {
+		var err error
+		if files, err = getFmtFiles(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(files) == 0 {
+		reportf("No C/C++ files to format")
+		return nil
+	}
+
+	changed, err := runClangFormat(files, !*fmtCheck)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if *fmtCheck {
+		return errors.Trace(fmtCheckResult(changed))
+	}
+	for _, f := range changed {
+		reportf("Formatted %s", f)
+	}
+	reportf("%d of %d files changed", len(changed), len(files))
+	return nil
+}
+
+// checkFmtBeforeBuild fails the build with --fmt-check if the app sources are
+// not formatted.
+func checkFmtBeforeBuild() error {
+	if !*fmtCheck {
+		return nil
+	}
+	files, err := getFmtFiles()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	changed, err := runClangFormat(files, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(fmtCheckResult(changed))
+}
+
+func fmtCheckResult(changed []string) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	for _, f := range changed {
+		reportf("Not formatted: %s", f)
+	}
+	return errors.Errorf("%d files are not formatted, run \"mos fmt\"", len(changed))
+}
+
+// getFmtFiles returns the C/C++ files which sources and includes of the
+// project manifest refer to. Files outside of the project dir, e.g. of libs,
+// and generated files in the build dir are skipped.
+func getFmtFiles() ([]string, error) {
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	files, err := getExportFiles(manifest.Sources, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, inc := range manifest.Includes {
+		if strings.HasPrefix(inc, "$") || strings.HasPrefix(inc, "@") {
+			continue
+		}
+		headers, err := getExportFiles([]string{inc}, true)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		files = append(files, headers...)
+	}
+
+	projectDirAbs, err := filepath.Abs(projectDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	buildDirAbs, err := filepath.Abs(moscommon.GetBuildDir(projectDir))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	seen := map[string]bool{}
+	var res []string
+	for _, f := range files {
+		fa, err := filepath.Abs(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if seen[fa] || !fmtSourceExts[filepath.Ext(fa)] ||
+			!isUnderDir(fa, projectDirAbs) || isUnderDir(fa, buildDirAbs) {
+			continue
+		}
+		seen[fa] = true
+		res = append(res, fa)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// runClangFormat formats copies of the files in a single clang-format run
+// and returns the files whose formatting differs. If write is set, the
+// formatted contents are written back to them.
+func runClangFormat(files []string, write bool) ([]string, error) {
+	tmpDir, err := ioutil.TempDir("", "mos_fmt_")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	style := mgosClangFormatStyle
+	for _, name := range []string{".clang-format", "_clang-format"} {
+		if _, err := os.Stat(filepath.Join(projectDir, name)); err == nil {
+			if err := ourio.CopyFile(filepath.Join(projectDir, name), filepath.Join(tmpDir, ".clang-format")); err != nil {
+				return nil, errors.Trace(err)
+			}
+			style = "file"
+			break
+		}
+	}
+
+	// Copies are named by their index, so that any paths work in the
+	// container, but keep the extension, which tells C from C++.
+	var names []string
+	for i, f := range files {
+		name := filepath.Join("src", fmt.Sprintf("%04d%s", i, filepath.Ext(f)))
+		if err := os.MkdirAll(filepath.Join(tmpDir, "src"), 0755); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := ourio.CopyFile(f, filepath.Join(tmpDir, name)); err != nil {
+			return nil, errors.Trace(err)
+		}
+		names = append(names, filepath.ToSlash(name))
+	}
+
+	image, err := getToolchainImage(*fmtImage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfArgs := append([]string{"clang-format", "-style=" + style, "-i"}, names...)
+	if out, err := runToolchainCommand(image, []string{tmpDir}, tmpDir, tmpDir, cfArgs); err != nil {
+		return nil, errors.Annotatef(err, "clang-format failed (use --fmt-image to choose an image with it): %s", out)
+	}
+
+	var changed []string
+	for i, f := range files {
+		orig, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		formatted, err := ioutil.ReadFile(filepath.Join(tmpDir, names[i]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if bytes.Equal(orig, formatted) {
+			continue
+		}
+		changed = append(changed, f)
+		if write {
+			st, err := os.Stat(f)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if err := ioutil.WriteFile(f, formatted, st.Mode()); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	}
+	return changed, nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path"
@@ -21,13 +23,24 @@ import (
 
 const (
 	chunkSize = 512
+	// Default chunk size for files on SD cards: base64 makes it 3416 bytes,
+	// so that the frame with the rest of FS.Put args stays under 4K, the
+	// frame size limit of the firmware.
+	sdChunkSize = 2560
 )
 
 var (
 	fsOpTimeout  = 7 * time.Second
 	fsOpAttempts = 3
+
+	fsChunkSize = flag.Int("fs-chunk-size", 0, "mos put: size of the chunks files are sent in; by default, 512, or 2560 for files on mounts like /mnt/sd")
+	fsVerify    = flag.Bool("fs-verify", false, "mos put: read the file back to check it after writing, for firmware which ignores the CRC32 of the chunks")
 )
 
+func init() {
+	hiddenFlags = append(hiddenFlags, "fs-chunk-size", "fs-verify")
+}
+
 func listFiles(ctx context.Context, devConn *dev.DevConn, path string) (res []fwfs.ListExtResult, err error) {
 	if *longFormat {
 		res, err = devConn.CFilesystem.ListExt(ctx, &fwfs.ListExtArgs{Path: &path})
@@ -55,7 +68,10 @@ func fsLs(ctx context.Context, devConn *dev.DevConn) error {
 	args := flag.Args()
 	path := "/"
 	if len(args) >= 2 {
-		path = args[1]
+		var err error
+		if path, err = resolveDevicePath(ctx, devConn, args[1]); err != nil {
+			return errors.Trace(err)
+		}
 	}
 	files, err := listFiles(ctx, devConn, path)
 	if err != nil {
@@ -124,7 +140,10 @@ func fsGet(ctx context.Context, devConn *dev.DevConn) error {
 	if len(args) > 2 {
 		return errors.Errorf("extra arguments")
 	}
-	filename := args[1]
+	filename, err := resolveDevicePath(ctx, devConn, args[1])
+	if err != nil {
+		return errors.Trace(err)
+	}
 	text, err := getFile(ctx, devConn, filename)
 	if err != nil {
 		return errors.Trace(err)
@@ -144,9 +163,15 @@ func fsPut(ctx context.Context, devConn *dev.DevConn) error {
 	hostFilename := args[1]
 	devFilename := path.Base(hostFilename)
 
-	// If device filename was given, use it.
+	// If device filename was given, use it. It may be a dir, like "sd:".
 	if len(args) >= 3 {
-		devFilename = args[2]
+		var err error
+		if devFilename, err = resolveDevicePath(ctx, devConn, args[2]); err != nil {
+			return errors.Trace(err)
+		}
+		if strings.HasSuffix(devFilename, "/") {
+			devFilename += path.Base(hostFilename)
+		}
 	}
 
 	return fsPutFile(ctx, devConn, hostFilename, devFilename)
@@ -162,9 +187,34 @@ func fsPutFile(ctx context.Context, devConn *dev.DevConn, hostFilename, devFilen
 	return fsPutData(ctx, devConn, file, devFilename)
 }
 
+// fsPutArgs are the args of FS.Put. Firmware which supports crc32 checks
+// the chunk with it and fails the call on mismatch, so that the chunk is sent
+// again; older firmware ignores it.
+type fsPutArgs struct {
+	Filename string `json:"filename"`
+	Data     string `json:"data"`
+	Append   bool   `json:"append"`
+	CRC32    uint32 `json:"crc32"`
+}
+
+// getFSChunkSize returns the size of the chunks the file is sent to the
+// device in.
+func getFSChunkSize(devFilename string) int {
+	if *fsChunkSize > 0 {
+		return *fsChunkSize
+	}
+	// Files on other mounts, like /mnt/sd/data.bin, are usually on SD cards,
+	// which take big writes well
+	if dir := path.Dir(devFilename); strings.HasPrefix(devFilename, "/") && dir != "/" {
+		return sdChunkSize
+	}
+	return chunkSize
+}
+
 func fsPutData(ctx context.Context, devConn *dev.DevConn, r io.Reader, devFilename string) error {
-	data := make([]byte, chunkSize)
+	data := make([]byte, getFSChunkSize(devFilename))
 	appendFlag := false
+	sum := crc32.NewIEEE()
 
 	attempts := fsOpAttempts
 	for {
@@ -175,11 +225,16 @@ func fsPutData(ctx context.Context, devConn *dev.DevConn, r io.Reader, devFilena
 				ctx2, cancel := context.WithTimeout(ctx, fsOpTimeout)
 				defer cancel()
 				glog.V(1).Infof("Sending %s %d (attempts %d)", devFilename, n, attempts)
-				err := devConn.CFilesystem.Put(ctx2, &fwfs.PutArgs{
-					Filename: &devFilename,
-					Data:     lptr.String(base64.StdEncoding.EncodeToString(data[:n])),
-					Append:   lptr.Bool(appendFlag),
+				argsJSON, err := json.Marshal(&fsPutArgs{
+					Filename: devFilename,
+					Data:     base64.StdEncoding.EncodeToString(data[:n]),
+					Append:   appendFlag,
+					CRC32:    crc32.ChecksumIEEE(data[:n]),
 				})
+				if err != nil {
+					return errors.Trace(err)
+				}
+				_, err = callDeviceService(ctx2, devConn, "FS.Put", string(argsJSON))
 				if err != nil {
 					attempts -= 1
 					if attempts > 0 {
@@ -192,6 +247,7 @@ func fsPutData(ctx context.Context, devConn *dev.DevConn, r io.Reader, devFilena
 				}
 			}
 		}
+		sum.Write(data[:n])
 		attempts = fsOpAttempts
 		if readErr != nil {
 			if errors.Cause(readErr) == io.EOF {
@@ -206,6 +262,18 @@ func fsPutData(ctx context.Context, devConn *dev.DevConn, r io.Reader, devFilena
 		appendFlag = true
 	}
 
+	// Older firmware ignores crc32 of the chunks, the file can be read back
+	// to make sure it's written right. Newer firmware fails FS.Put instead.
+	if !*fsVerify {
+		return nil
+	}
+	written, err := getFile(ctx, devConn, devFilename)
+	if err != nil {
+		return errors.Annotatef(err, "reading back %s", devFilename)
+	}
+	if crc32.ChecksumIEEE([]byte(written)) != sum.Sum32() {
+		return errors.Errorf("%s is corrupted on the device, its checksum doesn't match after writing", devFilename)
+	}
 	return nil
 }
 
@@ -223,6 +291,9 @@ func fsRm(ctx context.Context, devConn *dev.DevConn) error {
 	if len(args) > 2 {
 		return errors.Errorf("extra arguments")
 	}
-	filename := args[1]
+	filename, err := resolveDevicePath(ctx, devConn, args[1])
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return errors.Trace(fsRemoveFile(ctx, devConn, filename))
 }
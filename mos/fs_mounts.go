@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/fsmount"
+	"github.com/cesanta/errors"
+)
+
+// getDeviceMounts returns the root filesystem and the one mounted according
+// to the sys.mount config, if any.
+func getDeviceMounts(ctx context.Context, devConn *dev.DevConn) ([]fsmount.Mount, error) {
+	info, err := devConn.GetInfo(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	arch := ""
+	if info.Arch != nil {
+		arch = *info.Arch
+	}
+	mounts := []fsmount.Mount{{Path: "/", FSType: getRootFSType(arch)}}
+
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Unset values are null and can't be read
+	if p, _ := devConf.Get("sys.mount.path"); p != "" {
+		m := fsmount.Mount{Path: p}
+		m.DevType, _ = devConf.Get("sys.mount.dev_type")
+		m.FSType, _ = devConf.Get("sys.mount.fs_type")
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// getRootFSType returns the type of the root filesystem on the given arch,
+// as far as it is known: on ESP chips, it's SPIFFS, unless the app replaces
+// it.
+func getRootFSType(arch string) string {
+	switch arch {
+	case "esp32", "esp8266":
+		return "SPIFFS"
+	}
+	return ""
+}
+
+// resolveDevicePath resolves a mount-qualified path, like "sd:log.txt", to
+// the VFS path on the device. Other paths are returned as is.
+func resolveDevicePath(ctx context.Context, devConn *dev.DevConn, p string) (string, error) {
+	if !fsmount.IsQualified(p) {
+		return p, nil
+	}
+	mounts, err := getDeviceMounts(ctx, devConn)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	res, err := fsmount.Resolve(p, mounts)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// "sd:" and "sd:dir/" name a dir
+	if strings.HasSuffix(p, ":") || strings.HasSuffix(p, "/") {
+		res += "/"
+	}
+	return res, nil
+}
+
+// fsMounts implements "mos fs mounts".
+func fsMounts(ctx context.Context, devConn *dev.DevConn) error {
+	mounts, err := getDeviceMounts(ctx, devConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tPATH\tDEVICE\tFS\n")
+	for _, m := range mounts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Name(), m.Path, orDash(m.DevType), orDash(m.FSType))
+	}
+	tw.Flush()
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
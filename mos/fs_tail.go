@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"time"
+
+	"cesanta.com/common/go/lptr"
+	fwfs "cesanta.com/fw/defs/fs"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/fstail"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	fsFollow       = flag.Bool("follow", false, "mos fs tail: keep printing data appended to the file")
+	fsTailBytes    = flag.Int64("tail-bytes", 1024, "mos fs tail: print this many bytes from the end of the file first")
+	fsTailInterval = flag.Duration("tail-interval", time.Second, "mos fs tail: how often to check the file for new data")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "follow", "tail-bytes", "tail-interval")
+}
+
+// getDeviceFileChunk reads at most n bytes of the device file at the given
+// offset, and returns the data and the number of bytes after it.
+func getDeviceFileChunk(ctx context.Context, devConn *dev.DevConn, name string, offset, n int64) ([]byte, int64, error) {
+	ctx2, cancel := context.WithTimeout(ctx, fsOpTimeout)
+	defer cancel()
+	chunk, err := devConn.CFilesystem.Get(ctx2, &fwfs.GetArgs{
+		Filename: &name,
+		Offset:   lptr.Int64(offset),
+		Len:      lptr.Int64(n),
+	})
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	var left int64
+	if chunk.Left != nil {
+		left = *chunk.Left
+	}
+	if chunk.Data == nil {
+		return nil, left, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(*chunk.Data)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	return data, left, nil
+}
+
+// fsTail implements "mos fs tail <file>": it prints the end of a device file
+// and, with --follow, the data appended to it, reading only what is new. This
+// is for devices which log to flash rather than to UART.
+func fsTail(ctx context.Context, devConn *dev.DevConn, args []string) error {
+	if len(args) != 1 {
+		return errors.Errorf("usage: mos fs tail <file> [--follow]")
+	}
+	name, err := resolveDevicePath(ctx, devConn, args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	t := &fstail.Tailer{
+		Size: func(ctx context.Context) (int64, error) {
+			// The device has no stat, the first byte and the number of bytes
+			// after it give the size
+			data, left, err := getDeviceFileChunk(ctx, devConn, name, 0, 1)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			return int64(len(data)) + left, nil
+		},
+		Read: func(ctx context.Context, offset, n int64) ([]byte, error) {
+			data, _, err := getDeviceFileChunk(ctx, devConn, name, offset, n)
+			return data, errors.Trace(err)
+		},
+		ChunkSize: int64(getFSChunkSize(name)),
+	}
+	if err := t.Start(ctx, *fsTailBytes); err != nil {
+		return errors.Trace(err)
+	}
+
+	for {
+		truncated, err := t.Poll(ctx, os.Stdout)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if truncated {
+			reportf("%s was truncated, reading from the start", name)
+		}
+		if !*fsFollow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*fsTailInterval):
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	fwfs "cesanta.com/fw/defs/fs"
+	"cesanta.com/mos/cachegc"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/fatimg"
+	"cesanta.com/mos/fsusage"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	fsSort       = flag.String("sort", "name", "mos fs usage: sort files by name or size")
+	fsJSON       = flag.Bool("json", false, "mos fs usage, mos history, mos flash --verify-boot: print JSON")
+	fsImageSize  = flag.String("image-size", "1M", "mos fs image: size of the FAT image, e.g. 512K or 4M")
+	fsImageLabel = flag.String("image-label", "MOS", "mos fs image: volume label of the FAT image")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "sort", "json", "image-size", "image-label")
+}
+
+func fsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: usage, mounts, tail or image")
+	}
+	if args[0] == "image" {
+		// Building an image doesn't need the device
+		return errors.Trace(fsImage(args[1:]))
+	}
+
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	switch args[0] {
+	case "usage":
+		return errors.Trace(fsUsage(ctx, devConn, args[1:]))
+	case "mounts":
+		return errors.Trace(fsMounts(ctx, devConn))
+	case "tail":
+		return errors.Trace(fsTail(ctx, devConn, args[1:]))
+	default:
+		return errors.Errorf("unknown command %q, expected usage, mounts, tail or image", args[0])
+	}
+}
+
+// fsUsage implements "mos fs usage [path]". The totals are only known for
+// the root filesystem, so they are only shown for its root dir, where the
+// files listed are all the files on it.
+func fsUsage(ctx context.Context, devConn *dev.DevConn, args []string) error {
+	path := "/"
+	if len(args) > 0 {
+		var err error
+		if path, err = resolveDevicePath(ctx, devConn, args[0]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	list, err := devConn.CFilesystem.ListExt(ctx, &fwfs.ListExtArgs{Path: &path})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r := &fsusage.Report{}
+	var filesSize int64
+	for _, f := range list {
+		if f.Name == nil {
+			continue
+		}
+		file := fsusage.File{Name: *f.Name}
+		if f.Size != nil {
+			file.Size = *f.Size
+		}
+		filesSize += file.Size
+		r.Files = append(r.Files, file)
+	}
+	if err := fsusage.Sort(r.Files, *fsSort); err != nil {
+		return errors.Trace(err)
+	}
+
+	info, err := devConn.GetInfo(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if strings.TrimRight(path, "/") == "" && info.Fs_size != nil && info.Fs_free != nil {
+		fs := &fsusage.FS{
+			Mount:     "/",
+			Size:      *info.Fs_size,
+			Free:      *info.Fs_free,
+			Used:      *info.Fs_size - *info.Fs_free,
+			FilesSize: filesSize,
+		}
+		if info.Arch != nil {
+			fs.Type = getRootFSType(*info.Arch)
+		}
+		fs.Check()
+		r.Filesystems = append(r.Filesystems, fs)
+	}
+
+	if *fsJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+	r.Print(os.Stdout)
+	return nil
+}
+
+// fsImage implements "mos fs image <dir> <out.img>": it builds a FAT image
+// with the contents of a host directory, to be written to an SD card or
+// external flash.
+func fsImage(args []string) error {
+	if len(args) != 2 {
+		return errors.Errorf("usage: mos fs image <dir> <out.img>")
+	}
+	size, err := cachegc.ParseSize(*fsImageSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := fatimg.Build(args[0], size, *fsImageLabel)
+	if err != nil {
+		return errors.Annotatef(err, "failed to build image from %s", args[0])
+	}
+	if err := ioutil.WriteFile(args[1], data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	reportf("Wrote %s (%s)", args[1], cachegc.FormatSize(size))
+	return nil
+}
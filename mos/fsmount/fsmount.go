@@ -0,0 +1,60 @@
+// Package fsmount resolves mount-qualified device paths, like "sd:log.txt",
+// to VFS paths, like "/mnt/sd/log.txt".
+package fsmount
+
+import (
+	"path"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Mount is a filesystem mounted on the device.
+type Mount struct {
+	Path    string `json:"path"`
+	DevType string `json:"dev_type,omitempty"`
+	FSType  string `json:"fs_type,omitempty"`
+}
+
+// RootName is the name of the root filesystem in qualified paths.
+const RootName = "root"
+
+// Name returns the name of the mount used in qualified paths: the last
+// element of the mount path, or RootName.
+func (m *Mount) Name() string {
+	if m.Path == "/" || m.Path == "" {
+		return RootName
+	}
+	return path.Base(m.Path)
+}
+
+// IsQualified returns whether p is a mount-qualified path.
+func IsQualified(p string) bool {
+	i := strings.Index(p, ":")
+	return i > 0 && !strings.Contains(p[:i], "/")
+}
+
+// Resolve returns the VFS path for p. Paths which are not mount-qualified are
+// returned as is.
+func Resolve(p string, mounts []Mount) (string, error) {
+	if !IsQualified(p) {
+		return p, nil
+	}
+	parts := strings.SplitN(p, ":", 2)
+	var names []string
+	for _, m := range mounts {
+		if m.Name() != parts[0] {
+			names = append(names, m.Name())
+			continue
+		}
+		rest := strings.TrimPrefix(parts[1], "/")
+		if m.Name() == RootName {
+			return rest, nil
+		}
+		if rest == "" {
+			return m.Path, nil
+		}
+		return path.Join(m.Path, rest), nil
+	}
+	return "", errors.Errorf("no filesystem %q mounted, expected one of: %s", parts[0], strings.Join(names, ", "))
+}
@@ -0,0 +1,27 @@
+package fsmount
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	mounts := []Mount{{Path: "/"}, {Path: "/mnt/sd", FSType: "FAT"}}
+	for _, c := range []struct {
+		in, out string
+	}{
+		{"conf9.json", "conf9.json"},
+		{"/mnt/sd/a.txt", "/mnt/sd/a.txt"},
+		{"sd:a.txt", "/mnt/sd/a.txt"},
+		{"sd:/logs/a.txt", "/mnt/sd/logs/a.txt"},
+		{"sd:", "/mnt/sd"},
+		{"root:index.html", "index.html"},
+	} {
+		got, err := Resolve(c.in, mounts)
+		if err != nil {
+			t.Errorf("%s: %s", c.in, err)
+		} else if got != c.out {
+			t.Errorf("%s: got %q, want %q", c.in, got, c.out)
+		}
+	}
+	if _, err := Resolve("usb:a.txt", mounts); err == nil {
+		t.Errorf("unknown mount accepted")
+	}
+}
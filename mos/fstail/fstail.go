@@ -0,0 +1,71 @@
+// Package fstail follows a file on the device as it grows, reading only the
+// appended data.
+package fstail
+
+import (
+	"context"
+	"io"
+
+	"github.com/cesanta/errors"
+)
+
+// SizeFunc returns the current size of the file.
+type SizeFunc func(ctx context.Context) (int64, error)
+
+// ReadFunc reads at most n bytes of the file at the given offset.
+type ReadFunc func(ctx context.Context, offset, n int64) ([]byte, error)
+
+// Tailer tracks the offset in the file up to which the data has been read.
+type Tailer struct {
+	Size      SizeFunc
+	Read      ReadFunc
+	ChunkSize int64
+	// Offset of the first byte not read yet.
+	Offset int64
+}
+
+// Start positions the tailer at the last n bytes of the file.
+func (t *Tailer) Start(ctx context.Context, n int64) error {
+	size, err := t.Size(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	t.Offset = size - n
+	if t.Offset < 0 {
+		t.Offset = 0
+	}
+	return nil
+}
+
+// Poll writes the data appended to the file since the last call to w. If the
+// file got smaller, it is assumed to have been truncated or rotated, and is
+// read from the beginning; truncated is true then.
+func (t *Tailer) Poll(ctx context.Context, w io.Writer) (truncated bool, err error) {
+	size, err := t.Size(ctx)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if size < t.Offset {
+		t.Offset = 0
+		truncated = true
+	}
+	for t.Offset < size {
+		n := size - t.Offset
+		if n > t.ChunkSize {
+			n = t.ChunkSize
+		}
+		data, err := t.Read(ctx, t.Offset, n)
+		if err != nil {
+			return truncated, errors.Trace(err)
+		}
+		if len(data) == 0 {
+			// The file got smaller between the calls, pick it up next time
+			break
+		}
+		if _, err := w.Write(data); err != nil {
+			return truncated, errors.Trace(err)
+		}
+		t.Offset += int64(len(data))
+	}
+	return truncated, nil
+}
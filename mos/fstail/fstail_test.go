@@ -0,0 +1,83 @@
+package fstail
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func newTailer(file *[]byte) *Tailer {
+	return &Tailer{
+		Size: func(ctx context.Context) (int64, error) {
+			return int64(len(*file)), nil
+		},
+		Read: func(ctx context.Context, offset, n int64) ([]byte, error) {
+			end := offset + n
+			if end > int64(len(*file)) {
+				end = int64(len(*file))
+			}
+			return (*file)[offset:end], nil
+		},
+		ChunkSize: 3,
+	}
+}
+
+func TestTail(t *testing.T) {
+	ctx := context.Background()
+	file := []byte("first line\n")
+	tl := newTailer(&file)
+	if err := tl.Start(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if _, err := tl.Poll(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "line\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	file = append(file, "second line\n"...)
+	out.Reset()
+	if _, err := tl.Poll(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "second line\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Nothing new
+	out.Reset()
+	if _, err := tl.Poll(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// Rotated
+	file = []byte("new\n")
+	out.Reset()
+	truncated, err := tl.Poll(ctx, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated {
+		t.Errorf("truncation not detected")
+	}
+	if got, want := out.String(), "new\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStartShortFile(t *testing.T) {
+	file := []byte("ab")
+	tl := newTailer(&file)
+	if err := tl.Start(context.Background(), 1024); err != nil {
+		t.Fatal(err)
+	}
+	if tl.Offset != 0 {
+		t.Errorf("offset %d, want 0", tl.Offset)
+	}
+}
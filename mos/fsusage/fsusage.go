@@ -0,0 +1,117 @@
+// Package fsusage summarizes the space taken on device filesystems: sizes of
+// the files, totals per filesystem and signs of trouble, like SPIFFS running
+// out of space for garbage collection.
+package fsusage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cesanta/errors"
+)
+
+// File is a device file.
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// FS is a filesystem, sizes are in bytes.
+type FS struct {
+	Mount string `json:"mount"`
+	Type  string `json:"type,omitempty"`
+	Size  int64  `json:"size"`
+	Used  int64  `json:"used"`
+	Free  int64  `json:"free"`
+	// Sum of the sizes of the files on the filesystem.
+	FilesSize int64    `json:"files_size"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// Report is what "mos fs usage" shows.
+type Report struct {
+	Files       []File `json:"files"`
+	Filesystems []*FS  `json:"filesystems"`
+}
+
+// Sort sorts the files by name or, biggest first, by size.
+func Sort(files []File, by string) error {
+	switch by {
+	case "name":
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	case "size":
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].Size != files[j].Size {
+				return files[i].Size > files[j].Size
+			}
+			return files[i].Name < files[j].Name
+		})
+	default:
+		return errors.Errorf("invalid sort order %q, expected name or size", by)
+	}
+	return nil
+}
+
+const (
+	// Above this share of used space, SPIFFS slows down and may fail writes,
+	// as garbage collection has no free blocks to move pages to.
+	spiffsFullPct = 75
+	// If the space used beyond the file contents exceeds this share, deleted
+	// or overwritten pages are piling up.
+	overheadPct = 30
+)
+
+// Check fills in the warnings of the filesystem.
+func (fs *FS) Check() {
+	fs.Warnings = nil
+	if fs.Size <= 0 {
+		return
+	}
+	usedPct := fs.Used * 100 / fs.Size
+	if fs.Type == "SPIFFS" && usedPct >= spiffsFullPct {
+		fs.Warnings = append(fs.Warnings, fmt.Sprintf(
+			"%d%% used: SPIFFS needs free blocks for garbage collection, writes may get slow or fail above %d%%", usedPct, spiffsFullPct))
+	}
+	if overhead := fs.Used - fs.FilesSize; fs.Used > 0 && overhead*100/fs.Used >= overheadPct {
+		msg := fmt.Sprintf("%d bytes (%d%% of used) are not taken by file data", overhead, overhead*100/fs.Used)
+		if fs.Type == "SPIFFS" {
+			msg += ": fragmented, deleted pages are not reclaimed yet; rewriting the files or reformatting helps"
+		}
+		fs.Warnings = append(fs.Warnings, msg)
+	}
+}
+
+// Print prints the report as tables.
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	var total int64
+	for _, f := range r.Files {
+		total += f.Size
+	}
+	for _, f := range r.Files {
+		pct := ""
+		if total > 0 {
+			pct = fmt.Sprintf("%.1f%%", float64(f.Size)*100/float64(total))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t %s\n", f.Size, pct, f.Name)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d files, %d bytes\n", len(r.Files), total)
+
+	for _, fs := range r.Filesystems {
+		typ := ""
+		if fs.Type != "" {
+			typ = " (" + fs.Type + ")"
+		}
+		pct := int64(0)
+		if fs.Size > 0 {
+			pct = fs.Used * 100 / fs.Size
+		}
+		fmt.Fprintf(w, "\n%s%s: size %d, used %d (%d%%), free %d\n", fs.Mount, typ, fs.Size, fs.Used, pct, fs.Free)
+		for _, warn := range fs.Warnings {
+			fmt.Fprintf(w, "  Warning: %s\n", warn)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package fsusage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	files := []File{{"b.js", 10}, {"a.json", 300}, {"c.txt", 10}}
+	if err := Sort(files, "size"); err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Name != "a.json" || files[1].Name != "b.js" || files[2].Name != "c.txt" {
+		t.Errorf("unexpected order %v", files)
+	}
+	if err := Sort(files, "date"); err == nil {
+		t.Errorf("invalid order accepted")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fs := &FS{Mount: "/", Type: "SPIFFS", Size: 1000, Used: 800, Free: 200, FilesSize: 400}
+	fs.Check()
+	if len(fs.Warnings) != 2 || !strings.Contains(fs.Warnings[1], "fragmented") {
+		t.Errorf("unexpected warnings %q", fs.Warnings)
+	}
+	ok := &FS{Mount: "/", Size: 1000, Used: 300, Free: 700, FilesSize: 280}
+	ok.Check()
+	if len(ok.Warnings) != 0 {
+		t.Errorf("unexpected warnings %q", ok.Warnings)
+	}
+
+	var out bytes.Buffer
+	r := &Report{Files: []File{{"conf9.json", 300}}, Filesystems: []*FS{ok}}
+	r.Print(&out)
+	if !strings.Contains(out.String(), "/: size 1000, used 300 (30%), free 700") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
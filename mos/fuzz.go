@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"context"
+
+	"cesanta.com/mos/build/fuzz"
+	"cesanta.com/mos/build/hosttest"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	fuzzEngine = flag.String("fuzz-engine", fuzz.LibFuzzer, "mos fuzz: libfuzzer or afl")
+	fuzzTime   = flag.Duration("fuzz-time", 60*time.Second, "mos fuzz run: how long to fuzz for")
+	fuzzImage  = flag.String("fuzz-image", "", "mos fuzz: container image with clang and libFuzzer or AFL++ to use instead of the build image pinned in mos.lock")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "fuzz-engine", "fuzz-time", "fuzz-image")
+}
+
+var fuzzTargetExts = []string{".c", ".cc", ".cpp"}
+
+// fuzzHandler implements "mos fuzz":
+//
+//	mos fuzz init <name> [function]  creates the target fuzz/<name>.c
+//	mos fuzz list                    lists the targets
+//	mos fuzz run <name>              builds and runs the fuzzer
+//	mos fuzz repro <name> <input>    runs the fuzzer on the input
+func fuzzHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	usage := errors.Errorf("usage: mos fuzz init <name> [function] | list | run <name> | repro <name> <input>")
+	if len(args) < 1 {
+		return usage
+	}
+	switch {
+	case args[0] == "init" && (len(args) == 2 || len(args) == 3):
+		function := args[1]
+		if len(args) == 3 {
+			function = args[2]
+		}
+		return errors.Trace(fuzzInit(args[1], function))
+	case args[0] == "list" && len(args) == 1:
+		targets, err := getFuzzTargets()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, t := range targets {
+			fmt.Println(t)
+		}
+		return nil
+	case args[0] == "run" && len(args) == 2:
+		return errors.Trace(fuzzRun(args[1], ""))
+	case args[0] == "repro" && len(args) == 3:
+		input, err := filepath.Abs(args[2])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(fuzzRun(args[1], input))
+	}
+	return usage
+}
+
+func fuzzInit(name, function string) error {
+	fname := filepath.Join(projectDir, fuzz.Dir, name+".c")
+	if _, err := os.Stat(fname); err == nil {
+		return errors.Errorf("%s already exists", fname)
+	}
+	for _, d := range []string{filepath.Dir(fname), getFuzzCorpusDir(name)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := ioutil.WriteFile(fname, []byte(fuzz.Template(function)), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	reportf("Created %s, edit it and run \"mos fuzz run %s\"", fname, name)
+	return nil
+}
+
+// getFuzzTargets returns names of the targets in the fuzz dir.
+func getFuzzTargets() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(projectDir, fuzz.Dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	var res []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		for _, te := range fuzzTargetExts {
+			if !e.IsDir() && ext == te {
+				res = append(res, strings.TrimSuffix(e.Name(), ext))
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func getFuzzCorpusDir(name string) string {
+	return filepath.Join(projectDir, fuzz.Dir, "corpus", name)
+}
+
+func getFuzzCrashesDir(name string) string {
+	return filepath.Join(projectDir, fuzz.Dir, "crashes", name)
+}
+
+// fuzzRun builds the fuzzer of the target and runs it, on the input if given,
+// and reports the crashes.
+func fuzzRun(name, input string) error {
+	target := ""
+	for _, ext := range fuzzTargetExts {
+		fname := filepath.Join(projectDir, fuzz.Dir, name+ext)
+		if _, err := os.Stat(fname); err == nil {
+			target = fname
+			break
+		}
+	}
+	if target == "" {
+		return errors.Errorf("no fuzz target %q in %s, create one with \"mos fuzz init %s\"",
+			name, filepath.Join(projectDir, fuzz.Dir), name)
+	}
+	targetData, err := ioutil.ReadFile(target)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	appDir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	buildDir, err := filepath.Abs(filepath.Join(moscommon.GetBuildDir(projectDir), "fuzz"))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	corpusDir, crashesDir := getFuzzCorpusDir(name), getFuzzCrashesDir(name)
+	for _, d := range []string{buildDir, corpusDir, crashesDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// The test harness has LOG and other stubs the sources may need
+	for fname, content := range hosttest.Files() {
+		if err := ioutil.WriteFile(filepath.Join(buildDir, fname), []byte(content), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	targetAbs, err := filepath.Abs(target)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sources := []string{getPathForDocker(targetAbs)}
+	for _, s := range fuzz.Sources(targetData) {
+		if !filepath.IsAbs(s) {
+			s = filepath.Join(appDir, s)
+		}
+		if _, err := os.Stat(s); err != nil {
+			return errors.Annotatef(err, "source of the fuzz target %q", name)
+		}
+		sources = append(sources, getPathForDocker(s))
+	}
+	includes := []string{getPathForDocker(buildDir)}
+	dirs := []string{appDir, buildDir}
+	for _, inc := range manifest.Includes {
+		if inc == "" || inc[0] == '$' || inc[0] == '@' {
+			continue
+		}
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(appDir, inc)
+		}
+		includes = append(includes, getPathForDocker(inc))
+		dirs = append(dirs, inc)
+	}
+
+	bld := &fuzz.Build{
+		Engine:   *fuzzEngine,
+		Sources:  sources,
+		Includes: includes,
+		CDefs:    manifest.CDefs,
+		Binary:   getPathForDocker(filepath.Join(buildDir, name+"_"+*fuzzEngine)),
+		Corpus:   getPathForDocker(corpusDir),
+		Crashes:  getPathForDocker(crashesDir),
+		Seconds:  int(fuzzTime.Seconds()),
+	}
+	script, err := bld.Script(getPathForDocker(input))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if input != "" {
+		dirs = append(dirs, filepath.Dir(input))
+	}
+	scriptName := filepath.Join(buildDir, name+".sh")
+	if err := ioutil.WriteFile(scriptName, []byte(script), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	image, err := getToolchainImage(*fuzzImage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if input != "" {
+		reportf("Running the fuzz target %s on %s...", name, input)
+	} else {
+		reportf("Fuzzing %s with %s for %s...", name, *fuzzEngine, *fuzzTime)
+	}
+	// New corpus entries and crashes are written to the project dir
+	out, runErr := runToolchainCommand(image, mountRoots(dirs), appDir, appDir, []string{"/bin/sh", getPathForDocker(scriptName)})
+	if *verbose {
+		reportf("%s", out)
+	}
+	crashes, err := fuzz.ParseCrashes(bytes.NewReader(out))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(crashes) == 0 {
+		if runErr != nil {
+			return errors.Annotatef(runErr, "fuzzer failed (use --fuzz-image to choose an image with %s): %s", *fuzzEngine, out)
+		}
+		if input != "" {
+			reportf("The input doesn't crash %s", name)
+		} else {
+			reportf("No crashes")
+		}
+		return nil
+	}
+	for _, c := range crashes {
+		reportf("\n%s", c.Summary)
+		for _, f := range c.Stack {
+			reportf("    %s", f)
+		}
+		if c.Input != "" {
+			reportf("Input: %s, reproduce with \"mos fuzz repro %s %s\"", c.Input, name, c.Input)
+		}
+	}
+	return errors.Errorf("%d crashes found", len(crashes))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/fwexport"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	fwBaseAddr  = flag.String("base-addr", "", "mos fw export: address of parts without explicit addresses; 0x08000000 for STM32, 0 otherwise")
+	fwUF2Family = flag.String("uf2-family", "", "mos fw export: UF2 family ID, as a number or a name like stm32f4; by default, determined by the platform")
+)
+
+func fwHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: export, scan, diff, verify-provenance, info")
+	}
+	switch args[0] {
+	case "export":
+		return errors.Trace(fwExport(args[1:]))
+	case "scan":
+		return errors.Trace(fwScan(args[1:]))
+	case "diff":
+		return errors.Trace(fwDiff(args[1:]))
+	case "verify-provenance":
+		return errors.Trace(fwVerifyProvenance(args[1:]))
+	case "info":
+		return errors.Trace(fwInfo(ctx, args[1:]))
+	}
+	return errors.Errorf("unknown command %q, expected export, scan, diff, verify-provenance or info", args[0])
+}
+
+// fwExport converts the fw bundle: mos fw export [--format uf2|hex|merged-bin] <output>
+func fwExport(args []string) error {
+	if len(args) != 1 {
+		return errors.Errorf("usage: mos fw export [--firmware fw.zip] [--format uf2|hex|merged-bin] <output file>")
+	}
+	outFile := args[0]
+
+	f := format
+	if f == "" {
+		switch strings.ToLower(filepath.Ext(outFile)) {
+		case ".uf2":
+			f = "uf2"
+		case ".hex", ".ihex":
+			f = "hex"
+		case ".bin":
+			f = "merged-bin"
+		default:
+			return errors.Errorf("%s: format not specified and could not be guessed", outFile)
+		}
+	}
+
+	fw, err := common.NewZipFirmwareBundle(*firmware)
+	if err != nil {
+		return errors.Annotatef(err, "failed to load %s", *firmware)
+	}
+	defer fw.Cleanup()
+	platform := strings.ToLower(fw.Platform)
+
+	baseAddr := uint64(0)
+	if *fwBaseAddr != "" {
+		baseAddr, err = strconv.ParseUint(*fwBaseAddr, 0, 32)
+		if err != nil {
+			return errors.Annotatef(err, "invalid --base-addr")
+		}
+	} else if platform == "stm32" {
+		baseAddr = 0x08000000
+	}
+
+	segs, err := fwexport.Segments(fw, uint32(baseAddr))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var data []byte
+	switch f {
+	case "merged-bin":
+		var start uint32
+		data, start = fwexport.MergedBin(segs)
+		ourutil.Reportf("Merged image is to be written at 0x%x", start)
+	case "uf2":
+		family, err := getUF2Family(platform)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data = fwexport.UF2(segs, family)
+	case "hex":
+		data = fwexport.IHex(segs)
+	default:
+		return errors.Errorf("unknown format %q, expected uf2, hex or merged-bin", f)
+	}
+
+	if err := ioutil.WriteFile(outFile, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Wrote %s (%s, %d bytes)", outFile, f, len(data))
+	return nil
+}
+
+func getUF2Family(platform string) (uint32, error) {
+	name := *fwUF2Family
+	if name == "" {
+		name = platform
+	}
+	if id, ok := fwexport.UF2Families[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+	if *fwUF2Family == "" {
+		// No known family for the platform, don't set it
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(name, 0, 32)
+	if err != nil {
+		return 0, errors.Errorf("unknown UF2 family %q", name)
+	}
+	return uint32(id), nil
+}
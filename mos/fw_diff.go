@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/fwdiff"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	diffELF     = flag.StringSlice("diff-elf", nil, "mos fw diff: ELF files of the two firmwares, for the symbol sizes, if they are not in the zips: --diff-elf a.elf,b.elf")
+	diffSymbols = flag.Int("diff-symbols", 30, "mos fw diff: how many symbols with the largest size change to show")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "diff-elf", "diff-symbols")
+}
+
+// fwDiff implements "mos fw diff a.zip b.zip".
+func fwDiff(args []string) error {
+	if len(args) != 2 {
+		return errors.Errorf("usage: mos fw diff [--diff-elf a.elf,b.elf] a.zip b.zip")
+	}
+	if len(*diffELF) != 0 && len(*diffELF) != 2 {
+		return errors.Errorf("--diff-elf needs two files")
+	}
+	var fws []*common.FirmwareBundle
+	var elfs [][]byte
+	for i, fname := range args {
+		fw, err := common.NewZipFirmwareBundle(fname)
+		if err != nil {
+			return errors.Annotatef(err, "failed to load %s", fname)
+		}
+		defer fw.Cleanup()
+		fws = append(fws, fw)
+
+		elfData := fwdiff.FindELF(fw)
+		if len(*diffELF) == 2 {
+			if elfData, err = ioutil.ReadFile((*diffELF)[i]); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		elfs = append(elfs, elfData)
+	}
+
+	d, err := fwdiff.Compare(fws[0], fws[1], elfs[0], elfs[1])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.Print(os.Stdout, *diffSymbols)
+	return nil
+}
+
+// addDiffInfoToFirmware adds the versions of libs and modules used by the
+// local build and the config defaults to the fw zip, for mos fw diff. Local
+// dirs of the deps are not included.
+func addDiffInfoToFirmware(fwFilename, buildDir string) error {
+	fw, err := common.NewZipFirmwareBundle(fwFilename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	depsData, err := ioutil.ReadFile(moscommon.GetBuildDepsFilePath(buildDir))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var deps []moscommon.BuildDep
+	if err := json.Unmarshal(depsData, &deps); err != nil {
+		return errors.Trace(err)
+	}
+	for i := range deps {
+		deps[i].Dir = ""
+	}
+	if fw.Blobs[fwdiff.DepsFileName], err = json.MarshalIndent(deps, "", "  "); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Generated by the build along with the fs image
+	conf0, err := ioutil.ReadFile(moscommon.GetConfigDefaultsFilePath(buildDir))
+	if err == nil {
+		fw.Blobs[fwdiff.ConfigDefaultsFileName] = conf0
+	} else if !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(common.UpdateZipFirmwareBundle(fwFilename, fw))
+}
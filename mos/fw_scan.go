@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/fwscan"
+	"cesanta.com/mos/redact"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	scanAllow       = flag.StringSlice("scan-allow", nil, "mos fw scan: regexps of findings to ignore")
+	scanSecretsFrom = flag.StringSlice("scan-secrets-from", nil, "mos fw scan: config files with secrets to look for; by default, mos.yml, *.json and fs/conf*.json in the project dir")
+)
+
+// fwScan implements "mos fw scan [fw.zip]".
+func fwScan(args []string) error {
+	fwFile := *firmware
+	switch len(args) {
+	case 0:
+	case 1:
+		fwFile = args[0]
+	default:
+		return errors.Errorf("usage: mos fw scan [fw.zip]")
+	}
+
+	findings, err := scanFirmware(fwFile, *scanAllow)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(findings) > 0 {
+		return errors.Errorf("%s: %d possible leaks found", fwFile, len(findings))
+	}
+	ourutil.Reportf("%s: nothing found", fwFile)
+	return nil
+}
+
+// scanFirmware scans all parts of the firmware and reports what's found.
+func scanFirmware(fwFile string, allow []string) ([]fwscan.Finding, error) {
+	fw, err := common.NewZipFirmwareBundle(fwFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to load %s", fwFile)
+	}
+	defer fw.Cleanup()
+
+	opts := &fwscan.Options{}
+	for _, s := range allow {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid allow pattern %q", s)
+		}
+		opts.Allow = append(opts.Allow, re)
+	}
+	if opts.Secrets, err = getScanSecrets(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var names []string
+	for name, p := range fw.Parts {
+		if p.Src != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var findings []fwscan.Finding
+	for _, name := range names {
+		data, err := fw.GetPartData(name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		findings = append(findings, fwscan.Scan(name, data, opts)...)
+	}
+	for _, f := range findings {
+		ourutil.Reportf("%s", f.String())
+	}
+	return findings, nil
+}
+
+// getScanSecrets returns secrets found in the local config files.
+func getScanSecrets() ([]fwscan.Secret, error) {
+	files := *scanSecretsFrom
+	if len(files) == 0 {
+		files = []string{moscommon.GetManifestFilePath(projectDir)}
+		for _, pattern := range []string{"*.json", filepath.Join("fs", "conf*.json")} {
+			matches, err := filepath.Glob(filepath.Join(projectDir, pattern))
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			files = append(files, matches...)
+		}
+	}
+
+	var ret []fwscan.Secret
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			// Default files may well be missing
+			if len(*scanSecretsFrom) > 0 {
+				return nil, errors.Trace(err)
+			}
+			glog.V(1).Infof("%s: %s", f, err)
+			continue
+		}
+		for _, s := range redact.Secrets(string(data)) {
+			ret = append(ret, fwscan.Secret{Value: s, Source: f})
+		}
+	}
+	return ret, nil
+}
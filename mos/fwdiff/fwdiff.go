@@ -0,0 +1,411 @@
+// Package fwdiff compares two firmware bundles for release review: parts and
+// their sizes, sizes of symbols (if ELF files are available), versions of
+// libs and modules, and config defaults.
+package fwdiff
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"github.com/cesanta/errors"
+)
+
+const (
+	// Blobs which mos build adds to the bundle next to the manifest: the libs
+	// and modules used by the build (without local dirs), and config defaults.
+	DepsFileName           = "build_deps.json"
+	ConfigDefaultsFileName = "conf0.json"
+)
+
+// Info identifies the firmware.
+type Info struct {
+	Name     string
+	Platform string
+	Version  string
+	BuildID  string
+}
+
+// SizeDiff is a part or a symbol which differs. Sizes are -1 if it's
+// missing on the corresponding side.
+type SizeDiff struct {
+	Name  string
+	SizeA int64
+	SizeB int64
+}
+
+func (sd *SizeDiff) Delta() int64 {
+	a, b := sd.SizeA, sd.SizeB
+	if a < 0 {
+		a = 0
+	}
+	if b < 0 {
+		b = 0
+	}
+	return b - a
+}
+
+// ValueDiff is a dep version or a config value which differs; empty if it's
+// missing on the corresponding side.
+type ValueDiff struct {
+	Name string
+	A    string
+	B    string
+}
+
+// Diff is the result of the comparison.
+type Diff struct {
+	A, B Info
+
+	// Parts whose contents differ.
+	Parts []SizeDiff
+	// Symbols whose size differs, by the absolute delta, largest first. Nil
+	// unless both ELF files are given.
+	Symbols []SizeDiff
+	// Libs and modules whose version differs, nil if either bundle has no
+	// deps info.
+	Deps []ValueDiff
+	// Config defaults which differ, by dotted key, nil if either bundle has
+	// no defaults.
+	Config []ValueDiff
+
+	// Which of the optional sections are compared.
+	HaveSymbols, HaveDeps, HaveConfig bool
+}
+
+// Compare compares two bundles. elfA and elfB are contents of the
+// corresponding ELF files, may be nil.
+func Compare(a, b *common.FirmwareBundle, elfA, elfB []byte) (*Diff, error) {
+	d := &Diff{A: info(a), B: info(b)}
+	var err error
+	if d.Parts, err = compareParts(a, b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if elfA != nil && elfB != nil {
+		symsA, err := symbolSizes(elfA)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s", d.A.Name)
+		}
+		symsB, err := symbolSizes(elfB)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s", d.B.Name)
+		}
+		d.Symbols = compareSizes(symsA, symsB)
+		sort.SliceStable(d.Symbols, func(i, j int) bool {
+			return abs(d.Symbols[i].Delta()) > abs(d.Symbols[j].Delta())
+		})
+		d.HaveSymbols = true
+	}
+	depsA, okA, err := deps(a)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	depsB, okB, err := deps(b)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if okA && okB {
+		d.Deps = compareValues(depsA, depsB)
+		d.HaveDeps = true
+	}
+	confA, okA, err := configDefaults(a)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	confB, okB, err := configDefaults(b)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if okA && okB {
+		d.Config = compareValues(confA, confB)
+		d.HaveConfig = true
+	}
+	return d, nil
+}
+
+// FindELF returns the ELF file from the bundle, if it's there.
+func FindELF(fw *common.FirmwareBundle) []byte {
+	var names []string
+	for name := range fw.Blobs {
+		if strings.HasSuffix(name, ".elf") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return fw.Blobs[names[0]]
+}
+
+func info(fw *common.FirmwareBundle) Info {
+	return Info{Name: fw.Name, Platform: fw.Platform, Version: fw.Version, BuildID: fw.BuildID}
+}
+
+func compareParts(a, b *common.FirmwareBundle) ([]SizeDiff, error) {
+	type part struct {
+		size   int64
+		digest [sha1.Size]byte
+	}
+	var names []string
+	read := func(fw *common.FirmwareBundle) (map[string]part, error) {
+		ret := map[string]part{}
+		for name := range fw.Parts {
+			data, err := fw.GetPartData(name)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ret[name] = part{size: int64(len(data)), digest: sha1.Sum(data)}
+			names = append(names, name)
+		}
+		return ret, nil
+	}
+	pa, err := read(a)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pb, err := read(b)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ret []SizeDiff
+	for _, name := range unionKeys(names) {
+		x, okA := pa[name]
+		y, okB := pb[name]
+		if okA && okB && x.digest == y.digest {
+			continue
+		}
+		sd := SizeDiff{Name: name, SizeA: -1, SizeB: -1}
+		if okA {
+			sd.SizeA = x.size
+		}
+		if okB {
+			sd.SizeB = y.size
+		}
+		ret = append(ret, sd)
+	}
+	return ret, nil
+}
+
+// symbolSizes returns sizes of functions and objects; static symbols with
+// the same name are added up.
+func symbolSizes(data []byte) (map[string]int64, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid ELF file")
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, errors.Annotatef(err, "no symbol table")
+	}
+	ret := map[string]int64{}
+	for _, s := range syms {
+		t := elf.ST_TYPE(s.Info)
+		if (t != elf.STT_FUNC && t != elf.STT_OBJECT) || s.Size == 0 || s.Name == "" {
+			continue
+		}
+		ret[s.Name] += int64(s.Size)
+	}
+	return ret, nil
+}
+
+func compareSizes(a, b map[string]int64) []SizeDiff {
+	var ret []SizeDiff
+	for _, name := range unionKeys(sizeKeys(a), sizeKeys(b)) {
+		x, okA := a[name]
+		y, okB := b[name]
+		if okA && okB && x == y {
+			continue
+		}
+		sd := SizeDiff{Name: name, SizeA: -1, SizeB: -1}
+		if okA {
+			sd.SizeA = x
+		}
+		if okB {
+			sd.SizeB = y
+		}
+		ret = append(ret, sd)
+	}
+	return ret
+}
+
+// deps returns versions of libs and modules, by "kind name"; the version is
+// the one from the manifest and the git revision, whichever are known.
+func deps(fw *common.FirmwareBundle) (map[string]string, bool, error) {
+	data := fw.Blobs[DepsFileName]
+	if data == nil {
+		return nil, false, nil
+	}
+	var deps []moscommon.BuildDep
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return nil, false, errors.Annotatef(err, "%s: invalid %s", fw.Name, DepsFileName)
+	}
+	ret := map[string]string{}
+	for _, d := range deps {
+		var v []string
+		if d.Version != "" {
+			v = append(v, d.Version)
+		}
+		if d.Revision != "" {
+			v = append(v, d.Revision)
+		}
+		if len(v) == 0 {
+			v = append(v, "unknown")
+		}
+		ret[fmt.Sprintf("%s %s", d.Kind, d.Name)] = strings.Join(v, " ")
+	}
+	return ret, true, nil
+}
+
+// configDefaults returns config defaults flattened to dotted keys, with
+// JSON values.
+func configDefaults(fw *common.FirmwareBundle) (map[string]string, bool, error) {
+	data := fw.Blobs[ConfigDefaultsFileName]
+	if data == nil {
+		return nil, false, nil
+	}
+	var conf interface{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, false, errors.Annotatef(err, "%s: invalid %s", fw.Name, ConfigDefaultsFileName)
+	}
+	ret := map[string]string{}
+	flatten("", conf, ret)
+	return ret, true, nil
+}
+
+func flatten(prefix string, v interface{}, out map[string]string) {
+	if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+		for k, vv := range m {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(k, vv, out)
+		}
+		return
+	}
+	data, _ := json.Marshal(v)
+	out[prefix] = string(data)
+}
+
+func compareValues(a, b map[string]string) []ValueDiff {
+	var ret []ValueDiff
+	for _, name := range unionKeys(stringKeys(a), stringKeys(b)) {
+		if x, y := a[name], b[name]; x != y {
+			ret = append(ret, ValueDiff{Name: name, A: x, B: y})
+		}
+	}
+	return ret
+}
+
+// unionKeys returns sorted unique keys from all the lists.
+func unionKeys(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, keys := range lists {
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				ret = append(ret, k)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func stringKeys(m map[string]string) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func sizeKeys(m map[string]int64) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func fmtSize(v int64) string {
+	if v < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+func fmtValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// Print prints the diff, with up to maxSymbols symbols.
+func (d *Diff) Print(w io.Writer, maxSymbols int) {
+	for _, x := range []struct {
+		label string
+		info  Info
+	}{{"A", d.A}, {"B", d.B}} {
+		fmt.Fprintf(w, "%s: %s %s %s (%s)\n", x.label, x.info.Name, x.info.Platform, x.info.Version, x.info.BuildID)
+	}
+
+	fmt.Fprintf(w, "\nParts:\n")
+	if len(d.Parts) == 0 {
+		fmt.Fprintf(w, "  no changes\n")
+	}
+	for _, p := range d.Parts {
+		fmt.Fprintf(w, "  %-20s %10s -> %-10s %+d\n", p.Name, fmtSize(p.SizeA), fmtSize(p.SizeB), p.Delta())
+	}
+
+	if d.HaveSymbols {
+		fmt.Fprintf(w, "\nSymbols (%d changed):\n", len(d.Symbols))
+		var total int64
+		for i, s := range d.Symbols {
+			total += s.Delta()
+			if i < maxSymbols {
+				fmt.Fprintf(w, "  %+8d  %-40s %8s -> %s\n", s.Delta(), s.Name, fmtSize(s.SizeA), fmtSize(s.SizeB))
+			}
+		}
+		if len(d.Symbols) > maxSymbols {
+			fmt.Fprintf(w, "  ... %d more\n", len(d.Symbols)-maxSymbols)
+		}
+		fmt.Fprintf(w, "  %+8d  total\n", total)
+	} else {
+		fmt.Fprintf(w, "\nSymbols: no ELF files to compare\n")
+	}
+
+	for _, x := range []struct {
+		label string
+		have  bool
+		diffs []ValueDiff
+	}{{"Libs and modules", d.HaveDeps, d.Deps}, {"Config defaults", d.HaveConfig, d.Config}} {
+		if !x.have {
+			fmt.Fprintf(w, "\n%s: not recorded in both firmwares\n", x.label)
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", x.label)
+		if len(x.diffs) == 0 {
+			fmt.Fprintf(w, "  no changes\n")
+		}
+		for _, v := range x.diffs {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", v.Name, fmtValue(v.A), fmtValue(v.B))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package fwdiff
+
+import (
+	"reflect"
+	"testing"
+
+	"cesanta.com/mos/flash/common"
+)
+
+func bundle(parts map[string]string, blobs map[string]string) *common.FirmwareBundle {
+	fw := &common.FirmwareBundle{Blobs: map[string][]byte{}}
+	fw.Name = "app"
+	fw.Parts = map[string]*common.FirmwarePart{}
+	for name, data := range parts {
+		fw.Parts[name] = &common.FirmwarePart{Name: name, Src: name + ".bin"}
+		fw.Blobs[name+".bin"] = []byte(data)
+	}
+	for name, data := range blobs {
+		fw.Blobs[name] = []byte(data)
+	}
+	return fw
+}
+
+func TestCompare(t *testing.T) {
+	a := bundle(map[string]string{
+		"boot": "bootloader",
+		"app":  "app v1",
+		"old":  "x",
+	}, map[string]string{
+		DepsFileName:           `[{"name": "wifi", "kind": "lib", "version": "1.0"}, {"name": "rpc", "kind": "lib", "revision": "abc"}]`,
+		ConfigDefaultsFileName: `{"wifi": {"ap": {"enable": true}, "sta": {"ssid": ""}}, "debug": {"level": 2}}`,
+	})
+	b := bundle(map[string]string{
+		"boot": "bootloader",
+		"app":  "app v1.1",
+		"new":  "yy",
+	}, map[string]string{
+		DepsFileName:           `[{"name": "wifi", "kind": "lib", "version": "1.1"}, {"name": "rpc", "kind": "lib", "revision": "abc"}, {"name": "dns", "kind": "module"}]`,
+		ConfigDefaultsFileName: `{"wifi": {"ap": {"enable": false}, "sta": {"ssid": ""}}, "debug": {"level": 2, "udp_log_addr": "1.2.3.4:1993"}}`,
+	})
+
+	d, err := Compare(a, b, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expParts := []SizeDiff{
+		{Name: "app", SizeA: 6, SizeB: 8},
+		{Name: "new", SizeA: -1, SizeB: 2},
+		{Name: "old", SizeA: 1, SizeB: -1},
+	}
+	if !reflect.DeepEqual(d.Parts, expParts) {
+		t.Errorf("unexpected parts %+v", d.Parts)
+	}
+	if d.Parts[1].Delta() != 2 || d.Parts[2].Delta() != -1 {
+		t.Errorf("unexpected deltas %+v", d.Parts)
+	}
+
+	expDeps := []ValueDiff{
+		{Name: "lib wifi", A: "1.0", B: "1.1"},
+		{Name: "module dns", A: "", B: "unknown"},
+	}
+	if !d.HaveDeps || !reflect.DeepEqual(d.Deps, expDeps) {
+		t.Errorf("unexpected deps %+v", d.Deps)
+	}
+
+	expConfig := []ValueDiff{
+		{Name: "debug.udp_log_addr", A: "", B: `"1.2.3.4:1993"`},
+		{Name: "wifi.ap.enable", A: "true", B: "false"},
+	}
+	if !d.HaveConfig || !reflect.DeepEqual(d.Config, expConfig) {
+		t.Errorf("unexpected config %+v", d.Config)
+	}
+
+	if d.HaveSymbols {
+		t.Errorf("no symbols expected")
+	}
+}
+
+func TestCompareSizes(t *testing.T) {
+	got := compareSizes(
+		map[string]int64{"main": 100, "same": 10, "gone": 5},
+		map[string]int64{"main": 150, "same": 10, "added": 7},
+	)
+	exp := []SizeDiff{
+		{Name: "added", SizeA: -1, SizeB: 7},
+		{Name: "gone", SizeA: 5, SizeB: -1},
+		{Name: "main", SizeA: 100, SizeB: 150},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected diff %+v", got)
+	}
+}
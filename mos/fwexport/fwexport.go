@@ -0,0 +1,166 @@
+// Package fwexport converts firmware bundles into single-file formats: merged
+// binary images, UF2 and Intel HEX.
+package fwexport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cesanta.com/mos/flash/common"
+	"github.com/cesanta/errors"
+)
+
+// Segment is a contiguous piece of data to be written at the given address.
+type Segment struct {
+	Name    string
+	Address uint32
+	Data    []byte
+}
+
+func (s *Segment) End() uint32 {
+	return s.Address + uint32(len(s.Data))
+}
+
+// Segments returns fw parts placed at their flash addresses, sorted by
+// address. Parts with explicit addresses (addr for ESP, dfu_addr) are placed
+// at them; others, like the boot image on STM32, at baseAddr.
+func Segments(fw *common.FirmwareBundle, baseAddr uint32) ([]*Segment, error) {
+	switch strings.ToLower(fw.Platform) {
+	case "cc3200", "cc3220":
+		return nil, errors.Errorf("%s firmware consists of files, not flash images, and cannot be exported", fw.Platform)
+	}
+	var res []*Segment
+	for name, p := range fw.Parts {
+		data, err := fw.GetPartData(name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		addr := baseAddr + p.ESPFlashAddress
+		if p.DFUAddress != nil {
+			addr = *p.DFUAddress
+		}
+		res = append(res, &Segment{Name: name, Address: addr, Data: data})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Address < res[j].Address })
+	for i := 1; i < len(res); i++ {
+		if res[i].Address < res[i-1].End() {
+			return nil, errors.Errorf("parts %s (0x%x-0x%x) and %s (0x%x-0x%x) overlap",
+				res[i-1].Name, res[i-1].Address, res[i-1].End(), res[i].Name, res[i].Address, res[i].End())
+		}
+	}
+	return res, nil
+}
+
+// MergedBin returns a single image covering all segments, with gaps filled
+// with 0xff (erased flash), and the address it should be written at.
+func MergedBin(segs []*Segment) ([]byte, uint32) {
+	if len(segs) == 0 {
+		return nil, 0
+	}
+	start, end := segs[0].Address, segs[len(segs)-1].End()
+	res := bytes.Repeat([]byte{0xff}, int(end-start))
+	for _, s := range segs {
+		copy(res[s.Address-start:], s.Data)
+	}
+	return res, start
+}
+
+const (
+	uf2MagicStart0 = 0x0A324655
+	uf2MagicStart1 = 0x9E5D5157
+	uf2MagicEnd    = 0x0AB16F30
+	uf2FlagFamily  = 0x00002000
+	uf2PayloadSize = 256
+	uf2BlockSize   = 512
+)
+
+// UF2Families maps platforms to UF2 family IDs, which bootloaders use to
+// reject images for other chips.
+var UF2Families = map[string]uint32{
+	"esp32":   0x1c5f21b0,
+	"esp8266": 0x7eab61ed,
+	"stm32f2": 0x5d1a0a2e,
+	"stm32f4": 0x57755a57,
+	"stm32f7": 0x53b80f00,
+	"stm32l4": 0x00ff6919,
+}
+
+// UF2 returns the UF2 image of the segments. If familyID is non-zero, it's
+// set in every block.
+func UF2(segs []*Segment, familyID uint32) []byte {
+	type chunk struct {
+		addr uint32
+		data []byte
+	}
+	var chunks []chunk
+	for _, s := range segs {
+		for off := 0; off < len(s.Data); off += uf2PayloadSize {
+			end := off + uf2PayloadSize
+			if end > len(s.Data) {
+				end = len(s.Data)
+			}
+			chunks = append(chunks, chunk{s.Address + uint32(off), s.Data[off:end]})
+		}
+	}
+	flags := uint32(0)
+	if familyID != 0 {
+		flags |= uf2FlagFamily
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(chunks)*uf2BlockSize))
+	for i, c := range chunks {
+		block := make([]byte, uf2BlockSize)
+		le := binary.LittleEndian
+		le.PutUint32(block[0:], uf2MagicStart0)
+		le.PutUint32(block[4:], uf2MagicStart1)
+		le.PutUint32(block[8:], flags)
+		le.PutUint32(block[12:], c.addr)
+		le.PutUint32(block[16:], uint32(len(c.data)))
+		le.PutUint32(block[20:], uint32(i))
+		le.PutUint32(block[24:], uint32(len(chunks)))
+		le.PutUint32(block[28:], familyID)
+		copy(block[32:], c.data)
+		le.PutUint32(block[uf2BlockSize-4:], uf2MagicEnd)
+		buf.Write(block)
+	}
+	return buf.Bytes()
+}
+
+// IHex returns the Intel HEX image of the segments.
+func IHex(segs []*Segment) []byte {
+	buf := &bytes.Buffer{}
+	upper := uint32(0)
+	for _, s := range segs {
+		for off := 0; off < len(s.Data); {
+			addr := s.Address + uint32(off)
+			if addr>>16 != upper {
+				upper = addr >> 16
+				ihexRecord(buf, 0, 0x04, []byte{byte(upper >> 8), byte(upper)})
+			}
+			// Don't let records cross 64K boundaries
+			n := 16
+			if left := 0x10000 - int(addr&0xffff); n > left {
+				n = left
+			}
+			if n > len(s.Data)-off {
+				n = len(s.Data) - off
+			}
+			ihexRecord(buf, uint16(addr), 0x00, s.Data[off:off+n])
+			off += n
+		}
+	}
+	ihexRecord(buf, 0, 0x01, nil)
+	return buf.Bytes()
+}
+
+func ihexRecord(buf *bytes.Buffer, addr uint16, typ byte, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+	fmt.Fprintf(buf, ":%02X%04X%02X", len(data), addr, typ)
+	for _, b := range data {
+		fmt.Fprintf(buf, "%02X", b)
+		sum += b
+	}
+	fmt.Fprintf(buf, "%02X\n", -sum)
+}
@@ -0,0 +1,92 @@
+package fwexport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"cesanta.com/mos/flash/common"
+)
+
+func testBundle() *common.FirmwareBundle {
+	fill := uint8(0xaa)
+	fw := &common.FirmwareBundle{
+		Blobs: map[string][]byte{
+			"boot.bin": bytes.Repeat([]byte{1}, 300),
+			"fw.bin":   []byte{2, 3, 4},
+		},
+	}
+	fw.Platform = "esp8266"
+	fw.Parts = map[string]*common.FirmwarePart{
+		"fw":   {Src: "fw.bin", ESPFlashAddress: 0x1000},
+		"boot": {Src: "boot.bin"},
+		"fill": {Fill: &fill, Size: 2, ESPFlashAddress: 0x2000},
+	}
+	return fw
+}
+
+func TestSegments(t *testing.T) {
+	fw := testBundle()
+	segs, err := Segments(fw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 3 || segs[0].Name != "boot" || segs[1].Name != "fw" || segs[2].Address != 0x2000 {
+		t.Fatalf("unexpected segments: %+v %+v %+v", segs[0], segs[1], segs[2])
+	}
+
+	data, start := MergedBin(segs)
+	if start != 0 || len(data) != 0x2002 {
+		t.Fatalf("unexpected merged image: start 0x%x, len 0x%x", start, len(data))
+	}
+	if data[299] != 1 || data[300] != 0xff || data[0x1002] != 4 || data[0x2001] != 0xaa {
+		t.Errorf("unexpected merged image contents")
+	}
+
+	fw.Parts["fw"].ESPFlashAddress = 0x100
+	if _, err := Segments(fw, 0); err == nil {
+		t.Errorf("expected an error for overlapping parts")
+	}
+	fw.Platform = "cc3200"
+	if _, err := Segments(fw, 0); err == nil {
+		t.Errorf("expected an error for CC3200")
+	}
+}
+
+func TestUF2(t *testing.T) {
+	segs, err := Segments(testBundle(), 0x08000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := UF2(segs, UF2Families["esp8266"])
+	// boot is split into 2 blocks, fw and fill take one each
+	if len(data) != 4*512 {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	le := binary.LittleEndian
+	b := data[512:1024]
+	if le.Uint32(b[0:]) != uf2MagicStart0 || le.Uint32(b[508:]) != uf2MagicEnd ||
+		le.Uint32(b[8:]) != uf2FlagFamily || le.Uint32(b[12:]) != 0x08000100 ||
+		le.Uint32(b[16:]) != 44 || le.Uint32(b[20:]) != 1 || le.Uint32(b[24:]) != 4 ||
+		le.Uint32(b[28:]) != 0x7eab61ed {
+		t.Errorf("unexpected block header: %x", b[:32])
+	}
+}
+
+func TestIHex(t *testing.T) {
+	segs := []*Segment{
+		{Address: 0x0800fff8, Data: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	want := strings.Join([]string{
+		":020000040800F2",
+		":08FFF8000001020304050607E5",
+		":020000040801F1",
+		":020000000809ED",
+		":00000001FF",
+		"",
+	}, "\n")
+	if got := string(IHex(segs)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,256 @@
+// Package fwscan searches firmware images for things which should not be
+// released: private keys, known secrets (like Wi-Fi passwords from local
+// config files), high-entropy strings which look like tokens or keys, and
+// URLs of debug endpoints.
+package fwscan
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Kinds of findings.
+const (
+	KindPrivateKey  = "private key"
+	KindSecret      = "known secret"
+	KindHighEntropy = "high-entropy string"
+	KindDebugURL    = "debug URL"
+)
+
+const (
+	// Shortest printable string which is considered.
+	minStringLen = 6
+	// Shortest token which is checked for entropy.
+	minTokenLen = 20
+	// Entropy thresholds, bits per character. Hex strings can't have more than
+	// 4 bits per character.
+	minEntropy    = 4.3
+	minHexEntropy = 3.4
+	minHexLen     = 32
+	// Secrets shorter than that are too likely to match by accident.
+	minSecretLen = 4
+)
+
+var (
+	rePrivateKey = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	// Certificates and public keys, like ca.pem, are public, but their base64
+	// bodies look like high-entropy tokens.
+	rePublicPEM = regexp.MustCompile(`(?s)-----BEGIN (CERTIFICATE|[A-Z ]*PUBLIC KEY)-----.*?-----END (CERTIFICATE|[A-Z ]*PUBLIC KEY)-----`)
+	reToken      = regexp.MustCompile(`[A-Za-z0-9+/_-]{20,}={0,2}`)
+	reHex        = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	reURL        = regexp.MustCompile(`(?i)\b(?:https?|wss?|mqtts?)://[^\s"'<>]+`)
+	reDebugPath  = regexp.MustCompile(`(?i)/(?:debug|_debug|test)(?:/|$)`)
+
+	debugHostSuffixes = []string{".local", ".lan", ".internal", ".ngrok.io", ".ngrok-free.app", ".requestbin.net"}
+)
+
+// Secret is a known secret value and where it comes from.
+type Secret struct {
+	Value  string
+	Source string
+}
+
+// Finding is a single thing found in the image.
+type Finding struct {
+	Part   string
+	Offset int
+	Kind   string
+	// Text is what is found, masked if it's a secret.
+	Text string
+	// Source of the known secret.
+	Source string
+}
+
+func (f *Finding) String() string {
+	s := fmt.Sprintf("%s @ 0x%x: %s: %s", f.Part, f.Offset, f.Kind, f.Text)
+	if f.Source != "" {
+		s += fmt.Sprintf(" (from %s)", f.Source)
+	}
+	return s
+}
+
+// Options of the scan.
+type Options struct {
+	// Known secrets to look for.
+	Secrets []Secret
+	// Findings whose (unmasked) text matches any of these are ignored.
+	Allow []*regexp.Regexp
+}
+
+// Scan returns what's found in data, which is the named part of the image.
+func Scan(part string, data []byte, opts *Options) []Finding {
+	var ret []Finding
+	// raw is the unmasked text, which the allow patterns are matched against
+	add := func(f Finding, raw string) {
+		for _, re := range opts.Allow {
+			if re.MatchString(raw) {
+				return
+			}
+		}
+		f.Part = part
+		ret = append(ret, f)
+	}
+
+	for _, m := range rePrivateKey.FindAllIndex(data, -1) {
+		add(Finding{Offset: m[0], Kind: KindPrivateKey, Text: string(data[m[0]:m[1]])}, string(data[m[0]:m[1]]))
+	}
+
+	for _, s := range opts.Secrets {
+		if len(s.Value) < minSecretLen {
+			continue
+		}
+		v := []byte(s.Value)
+		for off := 0; ; {
+			i := bytes.Index(data[off:], v)
+			if i < 0 {
+				break
+			}
+			add(Finding{Offset: off + i, Kind: KindSecret, Text: mask(s.Value), Source: s.Source}, s.Value)
+			off += i + len(v)
+		}
+	}
+
+	publicPEMs := rePublicPEM.FindAllIndex(data, -1)
+	for _, str := range Strings(data, minStringLen) {
+		for _, m := range reToken.FindAllStringIndex(str.Text, -1) {
+			tok := str.Text[m[0]:m[1]]
+			if isHighEntropy(tok) && !inRanges(publicPEMs, str.Offset+m[0]) {
+				add(Finding{Offset: str.Offset + m[0], Kind: KindHighEntropy, Text: mask(tok)}, tok)
+			}
+		}
+		for _, m := range reURL.FindAllStringIndex(str.Text, -1) {
+			u := str.Text[m[0]:m[1]]
+			if IsDebugURL(u) {
+				add(Finding{Offset: str.Offset + m[0], Kind: KindDebugURL, Text: u}, u)
+			}
+		}
+	}
+	return ret
+}
+
+// inRanges returns whether the offset is within any of the ranges.
+func inRanges(ranges [][]int, off int) bool {
+	for _, r := range ranges {
+		if off >= r[0] && off < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// String is a printable string in the image.
+type String struct {
+	Offset int
+	Text   string
+}
+
+// Strings returns runs of printable ASCII characters at least minLen long,
+// like strings(1).
+func Strings(data []byte, minLen int) []String {
+	var ret []String
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && (data[i] >= 0x20 && data[i] < 0x7f || data[i] == '\t') {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minLen {
+			ret = append(ret, String{Offset: start, Text: string(data[start:i])})
+		}
+		start = -1
+	}
+	return ret
+}
+
+// Entropy returns Shannon entropy of s, in bits per character.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	var e float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func isHighEntropy(tok string) bool {
+	if len(tok) < minTokenLen || hasSequence(tok) {
+		return false
+	}
+	if reHex.MatchString(tok) {
+		return len(tok) >= minHexLen && Entropy(tok) >= minHexEntropy
+	}
+	return Entropy(tok) >= minEntropy
+}
+
+// hasSequence returns true if s contains a run of 6 consecutive characters,
+// like "abcdef" in alphabet tables, which have high entropy but are not
+// secrets.
+func hasSequence(s string) bool {
+	run := 1
+	for i := 1; i < len(s); i++ {
+		if s[i] == s[i-1]+1 {
+			run++
+			if run >= 6 {
+				return true
+			}
+		} else {
+			run = 1
+		}
+	}
+	return false
+}
+
+// IsDebugURL returns true if the URL points to a local or private host, or
+// to a debug endpoint, which is not expected in release firmware.
+func IsDebugURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || reDebugPath.MatchString(u.Path) {
+		return true
+	}
+	for _, sfx := range debugHostSuffixes {
+		if strings.HasSuffix(host, sfx) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || isPrivateIP(ip)
+	}
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask hides most of the secret, so that it doesn't end up in logs.
+func mask(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return fmt.Sprintf("%s... (%d chars)", s[:4], len(s))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/genconfig"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	genConfigDir = flag.String("gen-config-dir", "", "Output directory for \"mos gen config\"; default is build/gen_config")
+)
+
+func genHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("what to generate is required: config")
+	}
+
+	switch args[0] {
+	case "config":
+		return errors.Trace(genConfig())
+	}
+
+	return errors.Errorf("unknown generator %q, expected config", args[0])
+}
+
+// genConfig generates C code and defaults for the config schema of the app,
+// merged the same way as during the build: the core schema from mongoose-os
+// goes first, then the schema of all libs and of the app.
+func genConfig() error {
+	manifest, _, err := readFinalManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mosDir, err := getMosDirEffective(manifest.MongooseOsRepo, manifest.MongooseOsVersion, time.Hour*99999)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	schema, err := readCoreConfigSchema(mosDir, manifest.Platform)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	schema = append(schema, manifest.ConfigSchema...)
+
+	root, err := genconfig.ParseSchema(schema)
+	if err != nil {
+		return errors.Annotatef(err, "invalid config schema")
+	}
+
+	outDir := *genConfigDir
+	if outDir == "" {
+		outDir = moscommon.GetGenConfigDir(moscommon.GetBuildDir(projectDir))
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+
+	defaults, err := json.MarshalIndent(genconfig.Defaults(root), "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	const name = "mgos_config"
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{name + ".h", genconfig.GenerateHeader(root, name)},
+		{name + ".c", genconfig.GenerateSource(root, name)},
+		{name + "_defaults.json", append(defaults, '\n')},
+	}
+	for _, f := range files {
+		fname := filepath.Join(outDir, f.name)
+		if err := ourio.WriteFileIfDiffers(fname, f.data, 0644); err != nil {
+			return errors.Annotatef(err, "writing %s", fname)
+		}
+		ourutil.Reportf("Wrote %s", fname)
+	}
+
+	return nil
+}
+
+// readCoreConfigSchema returns the core config schema from mongoose-os: the
+// common part, followed by the part of the platform, if any.
+func readCoreConfigSchema(mosDir, platform string) ([]build.ConfigSchemaItem, error) {
+	var schema []build.ConfigSchemaItem
+	files := []string{
+		filepath.Join(mosDir, "fw", "src", "mgos_sys_config.yaml"),
+		filepath.Join(mosDir, "fw", "platforms", platform, "src", platform+"_sys_config.yaml"),
+	}
+	for i, fname := range files {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			// Only the common part is required
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Annotatef(err, "reading core config schema")
+		}
+		var items []build.ConfigSchemaItem
+		if err := yaml.Unmarshal(data, &items); err != nil {
+			return nil, errors.Annotatef(err, "parsing %s", fname)
+		}
+		schema = append(schema, items...)
+	}
+	return schema, nil
+}
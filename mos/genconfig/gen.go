@@ -0,0 +1,187 @@
+package genconfig
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GenerateHeader returns the contents of the <name>.h file: config structs
+// and accessor declarations.
+func GenerateHeader(root *Entry, name string) []byte {
+	var b bytes.Buffer
+	guard := strings.ToUpper(name) + "_H_"
+
+	fmt.Fprintf(&b, "/* clang-format off */\n")
+	fmt.Fprintf(&b, "/*\n * Generated file - do not edit.\n * Command: mos gen config\n */\n\n")
+	fmt.Fprintf(&b, "#ifndef %s\n#define %s\n\n", guard, guard)
+	fmt.Fprintf(&b, "#include \"mgos_config_util.h\"\n\n")
+	fmt.Fprintf(&b, "#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+
+	writeStructs(&b, root, name)
+
+	fmt.Fprintf(&b, "/* Parametrized accessor prototypes {{{ */\n")
+	walk(root, func(e *Entry) {
+		fmt.Fprintf(&b, "%s%s_get_%s(struct %s *cfg);\n", getterType(e, name), name, cIdent(e.Key), name)
+	})
+	walk(root, func(e *Entry) {
+		if e.Type != TypeObject {
+			fmt.Fprintf(&b, "void %s_set_%s(struct %s *cfg, %sv);\n", name, cIdent(e.Key), name, cTypePrefix(e.Type))
+		}
+	})
+	fmt.Fprintf(&b, "/* }}} */\n\n")
+
+	fmt.Fprintf(&b, "const struct mgos_conf_entry *%s_schema();\n\n", name)
+	fmt.Fprintf(&b, "#ifdef __cplusplus\n}\n#endif\n\n#endif /* %s */\n", guard)
+
+	return b.Bytes()
+}
+
+func writeStructs(b *bytes.Buffer, e *Entry, name string) {
+	// Nested structs go first
+	for _, c := range e.Children {
+		if c.Type == TypeObject {
+			writeStructs(b, c, name)
+		}
+	}
+
+	fmt.Fprintf(b, "struct %s {\n", structName(e, name))
+	for _, c := range e.Children {
+		if c.Type == TypeObject {
+			fmt.Fprintf(b, "  struct %s %s;\n", structName(c, name), c.Name())
+		} else {
+			fmt.Fprintf(b, "  %s%s;\n", cTypePrefix(c.Type), c.Name())
+		}
+	}
+	fmt.Fprintf(b, "};\n\n")
+}
+
+// GenerateSource returns the contents of the <name>.c file: the schema table
+// and accessor definitions.
+func GenerateSource(root *Entry, name string) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "/* clang-format off */\n")
+	fmt.Fprintf(&b, "/*\n * Generated file - do not edit.\n * Command: mos gen config\n */\n\n")
+	fmt.Fprintf(&b, "#include <stddef.h>\n#include \"%s.h\"\n\n", name)
+
+	fmt.Fprintf(&b, "const struct mgos_conf_entry %s_schema_[%d] = {\n", name, countEntries(root)+1)
+	fmt.Fprintf(&b, "  {.type = CONF_TYPE_OBJECT, .key = \"\", .offset = 0, .num_desc = %d},\n", countEntries(root))
+	walk(root, func(e *Entry) {
+		if e.Type == TypeObject {
+			fmt.Fprintf(&b, "  {.type = CONF_TYPE_OBJECT, .key = %s, .offset = offsetof(struct %s, %s), .num_desc = %d},\n",
+				strconv.Quote(e.Name()), name, e.Key, countEntries(e))
+		} else {
+			fmt.Fprintf(&b, "  {.type = %s, .key = %s, .offset = offsetof(struct %s, %s)},\n",
+				confType(e.Type), strconv.Quote(e.Name()), name, e.Key)
+		}
+	})
+	fmt.Fprintf(&b, "};\n\n")
+
+	fmt.Fprintf(&b, "const struct mgos_conf_entry *%s_schema() {\n  return %s_schema_;\n}\n\n", name, name)
+
+	fmt.Fprintf(&b, "/* Getters {{{ */\n")
+	walk(root, func(e *Entry) {
+		ref := "&cfg->"
+		if e.Type != TypeObject {
+			ref = "cfg->"
+		}
+		fmt.Fprintf(&b, "%s%s_get_%s(struct %s *cfg) {\n  return %s%s;\n}\n",
+			getterType(e, name), name, cIdent(e.Key), name, ref, e.Key)
+	})
+	fmt.Fprintf(&b, "/* }}} */\n\n")
+
+	fmt.Fprintf(&b, "/* Setters {{{ */\n")
+	walk(root, func(e *Entry) {
+		if e.Type == TypeString {
+			fmt.Fprintf(&b, "void %s_set_%s(struct %s *cfg, const char *v) {\n  mgos_conf_set_str(&cfg->%s, v);\n}\n",
+				name, cIdent(e.Key), name, e.Key)
+		} else if e.Type != TypeObject {
+			fmt.Fprintf(&b, "void %s_set_%s(struct %s *cfg, %sv) {\n  cfg->%s = v;\n}\n",
+				name, cIdent(e.Key), name, cTypePrefix(e.Type), e.Key)
+		}
+	})
+	fmt.Fprintf(&b, "/* }}} */\n")
+
+	return b.Bytes()
+}
+
+// walk calls f for all descendants of e, depth-first, in the schema order.
+func walk(e *Entry, f func(e *Entry)) {
+	for _, c := range e.Children {
+		f(c)
+		if c.Type == TypeObject {
+			walk(c, f)
+		}
+	}
+}
+
+func countEntries(e *Entry) int {
+	n := 0
+	walk(e, func(*Entry) { n++ })
+	return n
+}
+
+func cIdent(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
+
+func structName(e *Entry, name string) string {
+	if e.Key == "" {
+		return name
+	}
+	return name + "_" + cIdent(e.Key)
+}
+
+// getterType returns the getter return type, with the space where needed.
+func getterType(e *Entry, name string) string {
+	if e.Type == TypeObject {
+		return fmt.Sprintf("const struct %s *", structName(e, name))
+	}
+	return cTypePrefix(e.Type)
+}
+
+func cType(typ string) string {
+	switch typ {
+	case TypeString:
+		return "const char *"
+	case TypeBool, TypeInt:
+		return "int"
+	case TypeUnsigned:
+		return "unsigned int"
+	case TypeDouble:
+		return "double"
+	case TypeFloat:
+		return "float"
+	}
+	return ""
+}
+
+// cTypePrefix returns the type for use in a declaration, with the space
+// where needed.
+func cTypePrefix(typ string) string {
+	t := cType(typ)
+	if strings.HasSuffix(t, "*") {
+		return t
+	}
+	return t + " "
+}
+
+func confType(typ string) string {
+	switch typ {
+	case TypeString:
+		return "CONF_TYPE_STRING"
+	case TypeBool:
+		return "CONF_TYPE_BOOL"
+	case TypeInt:
+		return "CONF_TYPE_INT"
+	case TypeUnsigned:
+		return "CONF_TYPE_UNSIGNED_INT"
+	case TypeDouble:
+		return "CONF_TYPE_DOUBLE"
+	case TypeFloat:
+		return "CONF_TYPE_FLOAT"
+	}
+	return ""
+}
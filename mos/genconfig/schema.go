@@ -0,0 +1,223 @@
+// Package genconfig generates C code for accessing the device configuration,
+// given the config schema from the manifests.
+package genconfig
+
+import (
+	"strings"
+
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+// Entry types, as used in the config schema
+const (
+	TypeObject   = "o"
+	TypeString   = "s"
+	TypeInt      = "i"
+	TypeUnsigned = "ui"
+	TypeBool     = "b"
+	TypeDouble   = "d"
+	TypeFloat    = "f"
+)
+
+// Entry is a single node of the config tree.
+type Entry struct {
+	// Full dotted key, e.g. "wifi.sta.ssid"; empty for the root.
+	Key      string
+	Type     string
+	Default  interface{}
+	Title    string
+	Children []*Entry
+}
+
+// Name returns the last component of the key.
+func (e *Entry) Name() string {
+	return e.Key[strings.LastIndex(e.Key, ".")+1:]
+}
+
+func (e *Entry) child(name string) *Entry {
+	for _, c := range e.Children {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ParseSchema builds the config tree from the schema items, in the order
+// given. Items can have one of the following forms:
+//
+//	[key, default]                 (type is inferred from the value)
+//	[key, type, {opts}]
+//	[key, type, default, {opts}]
+//
+// An item without a type for the existing key overrides its default value.
+// Parent objects which are not defined explicitly are created implicitly.
+func ParseSchema(items []build.ConfigSchemaItem) (*Entry, error) {
+	root := &Entry{Type: TypeObject}
+	for _, item := range items {
+		if err := addItem(root, item); err != nil {
+			return nil, errors.Annotatef(err, "schema item %v", []interface{}(item))
+		}
+	}
+	return root, nil
+}
+
+func addItem(root *Entry, item build.ConfigSchemaItem) error {
+	if len(item) < 2 || len(item) > 4 {
+		return errors.Errorf("expected 2 to 4 elements, got %d", len(item))
+	}
+	key, ok := item[0].(string)
+	if !ok || key == "" {
+		return errors.Errorf("key must be a non-empty string")
+	}
+
+	typ, def, title := "", item[1], ""
+	haveDefault := true
+	if len(item) > 2 {
+		typ, ok = item[1].(string)
+		if !ok {
+			return errors.Errorf("type must be a string")
+		}
+		def, haveDefault = item[2], true
+		opts := item[len(item)-1]
+		if m, ok := opts.(map[interface{}]interface{}); ok {
+			if len(item) == 3 {
+				def, haveDefault = nil, false
+			}
+			if t, ok := m["title"].(string); ok {
+				title = t
+			}
+		} else if len(item) == 4 {
+			return errors.Errorf("options must be a map")
+		}
+	}
+
+	// Find or create the parent
+	parent := root
+	parts := strings.Split(key, ".")
+	for i, p := range parts[:len(parts)-1] {
+		c := parent.child(p)
+		if c == nil {
+			c = &Entry{Key: strings.Join(parts[:i+1], "."), Type: TypeObject}
+			parent.Children = append(parent.Children, c)
+		} else if c.Type != TypeObject {
+			return errors.Errorf("%q is not an object", c.Key)
+		}
+		parent = c
+	}
+
+	e := parent.child(parts[len(parts)-1])
+	if e == nil {
+		if typ == "" {
+			if typ = inferType(def); typ == "" {
+				return errors.Errorf("can't infer type of %v (%T)", def, def)
+			}
+		}
+		switch typ {
+		case TypeObject, TypeString, TypeInt, TypeUnsigned, TypeBool, TypeDouble, TypeFloat:
+		default:
+			return errors.Errorf("unknown type %q", typ)
+		}
+		e = &Entry{Key: key, Type: typ}
+		parent.Children = append(parent.Children, e)
+	} else if typ != "" && typ != e.Type {
+		return errors.Errorf("%q is already defined with type %q", key, e.Type)
+	}
+	if title != "" {
+		e.Title = title
+	}
+
+	if e.Type == TypeObject {
+		if len(item) == 2 {
+			return errors.Errorf("%q is an object and can't have a default value", key)
+		}
+		return nil
+	}
+
+	if haveDefault {
+		v, err := convertDefault(e.Type, def)
+		if err != nil {
+			return errors.Annotatef(err, "%q", key)
+		}
+		e.Default = v
+	}
+
+	return nil
+}
+
+func inferType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	case int:
+		return TypeInt
+	case float64:
+		return TypeDouble
+	}
+	return ""
+}
+
+// convertDefault checks that the default value matches the type, and
+// converts it to the canonical Go type for it.
+func convertDefault(typ string, v interface{}) (interface{}, error) {
+	switch typ {
+	case TypeString:
+		if v == nil {
+			return "", nil
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case TypeInt, TypeUnsigned:
+		if i, ok := v.(int); ok {
+			if typ == TypeUnsigned && i < 0 {
+				return nil, errors.Errorf("negative default for an unsigned value")
+			}
+			return i, nil
+		}
+	case TypeDouble, TypeFloat:
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	default:
+		return nil, errors.Errorf("unknown type %q", typ)
+	}
+	return nil, errors.Errorf("default value %v doesn't match type %q", v, typ)
+}
+
+// Defaults returns the nested map of default values.
+func Defaults(root *Entry) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for _, c := range root.Children {
+		if c.Type == TypeObject {
+			ret[c.Name()] = Defaults(c)
+		} else if c.Default != nil {
+			ret[c.Name()] = c.Default
+		} else {
+			ret[c.Name()] = zeroValue(c.Type)
+		}
+	}
+	return ret
+}
+
+func zeroValue(typ string) interface{} {
+	switch typ {
+	case TypeString:
+		return ""
+	case TypeBool:
+		return false
+	case TypeDouble, TypeFloat:
+		return 0.0
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package genconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cesanta.com/mos/build"
+)
+
+func TestParseSchema(t *testing.T) {
+	items := []build.ConfigSchemaItem{
+		{"foo", "o", map[interface{}]interface{}{"title": "Foo settings"}},
+		{"foo.enable", "b", false, map[interface{}]interface{}{"title": "Enable foo"}},
+		{"foo.name", "s", "", map[interface{}]interface{}{}},
+		{"foo.timeout", 1.5},
+		{"bar.baz.count", 10},
+		// Override of the default value
+		{"foo.enable", true},
+	}
+
+	root, err := ParseSchema(items)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"foo": map[string]interface{}{
+			"enable":  true,
+			"name":    "",
+			"timeout": 1.5,
+		},
+		"bar": map[string]interface{}{
+			"baz": map[string]interface{}{
+				"count": 10,
+			},
+		},
+	}
+	if got := Defaults(root); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected defaults %v, got %v", expected, got)
+	}
+
+	h := string(GenerateHeader(root, "mgos_config"))
+	for _, s := range []string{
+		"struct mgos_config_bar_baz {\n  int count;\n};",
+		"struct mgos_config {\n  struct mgos_config_foo foo;\n  struct mgos_config_bar bar;\n};",
+		"const char *mgos_config_get_foo_name(struct mgos_config *cfg);",
+	} {
+		if !strings.Contains(h, s) {
+			t.Errorf("header doesn't contain %q:\n%s", s, h)
+		}
+	}
+
+	c := string(GenerateSource(root, "mgos_config"))
+	for _, s := range []string{
+		`{.type = CONF_TYPE_OBJECT, .key = "", .offset = 0, .num_desc = 7},`,
+		`{.type = CONF_TYPE_DOUBLE, .key = "timeout", .offset = offsetof(struct mgos_config, foo.timeout)},`,
+	} {
+		if !strings.Contains(c, s) {
+			t.Errorf("source doesn't contain %q:\n%s", s, c)
+		}
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	cases := [][]build.ConfigSchemaItem{
+		{{"foo", "x", map[interface{}]interface{}{}}},
+		{{"foo", "i", "not a number", map[interface{}]interface{}{}}},
+		{{"foo", 1}, {"foo.bar", 2}},
+		{{"foo", "s", "", map[interface{}]interface{}{}}, {"foo", "i", 1, map[interface{}]interface{}{}}},
+		{{"foo"}},
+		{{"foo", "o", map[interface{}]interface{}{}}, {"foo", 1}},
+	}
+
+	for i, items := range cases {
+		if _, err := ParseSchema(items); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+}
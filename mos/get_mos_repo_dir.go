@@ -46,7 +46,7 @@ func getMosRepoDir(ctx context.Context, devConn *dev.DevConn) error {
 		return errors.Trace(err)
 	}
 
-	mosDirEffective, err := getMosDirEffective(manifest.MongooseOsVersion, time.Hour*99999)
+	mosDirEffective, err := getMosDirEffective(manifest.MongooseOsRepo, manifest.MongooseOsVersion, time.Hour*99999)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	osuser "os/user"
+	"time"
+
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/guard"
+	"cesanta.com/mos/history"
+	"cesanta.com/mos/redact"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	policyFile = flag.String("policy", "~/.mos/policy.yml", "Policy file for destructive operations: confirmation, flags or roles they require, and the audit log")
+	yesIMeanIt = flag.Bool("yes-i-mean-it", false, "Confirm a destructive operation for which the policy requires it")
+)
+
+const defaultAuditLog = "~/.mos/audit.log"
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "policy", "yes-i-mean-it")
+}
+
+func getUserName() string {
+	if u, err := osuser.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// guardOperation checks that the destructive operation on the device at the
+// port is allowed by the policy, asking for confirmation if the policy says
+// so, and records it in the audit log. Returns whether the user has
+// confirmed the operation, so that the command doesn't ask again.
+func guardOperation(op, port, description string) (bool, error) {
+	fname, err := paths.NormalizePath(*policyFile, "")
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	p, err := guard.Load(fname)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	req := &guard.Request{Operation: op, Port: port, User: getUserName()}
+	if lookupDevicePort(*portFlag) != "" {
+		req.Device = *portFlag
+	}
+	d := p.Decide(req)
+
+	confirmed := false
+	outcome, reason := "allowed", ""
+	switch d.Require {
+	case guard.RequireDeny:
+		outcome, reason = "denied", d.Reason
+	case guard.RequireFlag:
+		if *yesIMeanIt {
+			confirmed = true
+		} else {
+			outcome, reason = "denied", "the policy requires --yes-i-mean-it to "+description
+		}
+	case guard.RequireConfirm:
+		if *yesIMeanIt {
+			confirmed = true
+			break
+		}
+		target := port
+		if target == "" {
+			target = "the devices"
+		}
+		yes, err := confirm("This will "+description+" on "+target+". Are you sure", "use --yes-i-mean-it to confirm")
+		switch {
+		case err != nil:
+			outcome, reason = "denied", err.Error()
+		case yes:
+			confirmed = true
+		default:
+			outcome, reason = "aborted", "aborted, use --yes-i-mean-it to confirm"
+		}
+	}
+
+	auditLog := p.AuditLog
+	if auditLog == "" {
+		auditLog = defaultAuditLog
+	}
+	// Like the history, the log must not keep passwords and tokens
+	e := &guard.AuditEntry{
+		Time:      time.Now(),
+		User:      req.User,
+		Operation: op,
+		Port:      redact.String(port),
+		Args:      history.RedactArgs(os.Args[1:], flag.Args()),
+		Outcome:   outcome,
+	}
+	e.Host, _ = os.Hostname()
+	if auditLog, err = paths.NormalizePath(auditLog, ""); err == nil {
+		err = guard.AppendAudit(auditLog, e)
+	}
+	if err != nil {
+		// The log the policy asks for must be kept, the default one is best
+		// effort
+		if p.AuditLog != "" {
+			return false, errors.Annotatef(err, "failed to write the audit log")
+		}
+		glog.Warningf("failed to write the audit log: %s", err)
+	}
+
+	if reason != "" {
+		return false, errors.Errorf("%s", reason)
+	}
+	return confirmed, nil
+}
@@ -0,0 +1,233 @@
+// Package guard implements the safety policy for destructive operations, like
+// erasing flash or resetting config, for teams sharing lab hardware: the
+// policy can require confirmation or an explicit flag, or restrict an
+// operation to some roles, and the operations are recorded in an audit log.
+//
+// The policy is a YAML file:
+//
+//	roles:
+//	  lab-admin: [alice, bob]
+//	rules:
+//	  - operations: [flash-erase, config-reset]
+//	    ports: ["/dev/ttyUSB*", "bench-*"]
+//	    require: flag
+//	    allow_roles: [lab-admin]
+//	  - operations: ["*"]
+//	    require: confirm
+//	audit_log: /shared/lab/mos-audit.log
+//
+// The first rule matching the operation and the port (or the device name)
+// applies.
+package guard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Guarded operations.
+const (
+	OpFlash         = "flash"
+	OpFlashErase    = "flash-erase"
+	OpConfigReset   = "config-reset"
+	OpOTA           = "ota"
+	OpConfigRollout = "config-rollout"
+)
+
+// Operations are all the guarded operations.
+var Operations = []string{OpFlash, OpFlashErase, OpConfigReset, OpOTA, OpConfigRollout}
+
+// What a rule requires for the operation to proceed.
+const (
+	RequireNone    = "none"
+	RequireConfirm = "confirm"
+	RequireFlag    = "flag"
+	RequireDeny    = "deny"
+)
+
+// Policy is the contents of the policy file.
+type Policy struct {
+	// Users by role.
+	Roles    map[string][]string `yaml:"roles,omitempty"`
+	Rules    []Rule              `yaml:"rules,omitempty"`
+	AuditLog string              `yaml:"audit_log,omitempty"`
+}
+
+// Rule says what is required for the operations on the ports.
+type Rule struct {
+	// Operation names, "*" matches all.
+	Operations []string `yaml:"operations"`
+	// Globs matched against the port and the device name; all ports if empty.
+	Ports   []string `yaml:"ports,omitempty"`
+	Require string   `yaml:"require,omitempty"`
+	// If not empty, only users with these roles may perform the operations.
+	AllowRoles []string `yaml:"allow_roles,omitempty"`
+}
+
+// Request is an operation about to be performed.
+type Request struct {
+	Operation string
+	Port      string
+	// Name of the device in the registry, if it was given by name.
+	Device string
+	User   string
+}
+
+// Decision is what the policy says about the request.
+type Decision struct {
+	Require string
+	// Why the request is denied, if it is.
+	Reason string
+}
+
+// Load reads the policy file; if it does not exist, the policy is empty and
+// allows everything.
+func Load(fname string) (*Policy, error) {
+	p := &Policy{}
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", fname)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, errors.Annotatef(err, "%s", fname)
+	}
+	return p, nil
+}
+
+// Validate checks that the rules refer to known operations, requirements and
+// roles.
+func (p *Policy) Validate() error {
+	known := map[string]bool{"*": true}
+	for _, op := range Operations {
+		known[op] = true
+	}
+	for i, r := range p.Rules {
+		if len(r.Operations) == 0 {
+			return errors.Errorf("rule %d: no operations", i+1)
+		}
+		for _, op := range r.Operations {
+			if !known[op] {
+				return errors.Errorf("rule %d: unknown operation %q", i+1, op)
+			}
+		}
+		switch r.Require {
+		case "", RequireNone, RequireConfirm, RequireFlag, RequireDeny:
+		default:
+			return errors.Errorf("rule %d: invalid require %q, expected none, confirm, flag or deny", i+1, r.Require)
+		}
+		for _, role := range r.AllowRoles {
+			if _, ok := p.Roles[role]; !ok {
+				return errors.Errorf("rule %d: unknown role %q", i+1, role)
+			}
+		}
+		for _, glob := range r.Ports {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.Errorf("rule %d: invalid port pattern %q", i+1, glob)
+			}
+		}
+	}
+	return nil
+}
+
+// Decide returns what is required for the request.
+func (p *Policy) Decide(req *Request) *Decision {
+	for _, r := range p.Rules {
+		if !r.matches(req) {
+			continue
+		}
+		if len(r.AllowRoles) > 0 && !p.hasRole(req.User, r.AllowRoles) {
+			return &Decision{Require: RequireDeny, Reason: "user " + req.User + " doesn't have a role allowed to do " + req.Operation}
+		}
+		d := &Decision{Require: r.Require}
+		if d.Require == "" {
+			d.Require = RequireNone
+		}
+		if d.Require == RequireDeny {
+			d.Reason = req.Operation + " is not allowed by the policy"
+		}
+		return d
+	}
+	return &Decision{Require: RequireNone}
+}
+
+func (r *Rule) matches(req *Request) bool {
+	opMatches := false
+	for _, op := range r.Operations {
+		if op == "*" || op == req.Operation {
+			opMatches = true
+			break
+		}
+	}
+	if !opMatches {
+		return false
+	}
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, glob := range r.Ports {
+		for _, s := range []string{req.Port, req.Device} {
+			if s == "" {
+				continue
+			}
+			if ok, _ := path.Match(glob, s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Policy) hasRole(user string, roles []string) bool {
+	for _, role := range roles {
+		for _, u := range p.Roles[role] {
+			if u == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuditEntry is a line of the audit log.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Host      string    `json:"host,omitempty"`
+	Operation string    `json:"operation"`
+	Port      string    `json:"port,omitempty"`
+	Args      []string  `json:"args,omitempty"`
+	// "allowed", "denied" or "aborted".
+	Outcome string `json:"outcome"`
+}
+
+// AppendAudit appends the entry to the audit log, as a line of JSON.
+func AppendAudit(fname string, e *AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
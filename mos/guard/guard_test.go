@@ -0,0 +1,116 @@
+package guard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+roles:
+  lab-admin: [alice]
+rules:
+  - operations: [flash-erase, config-reset]
+    ports: ["/dev/ttyUSB*", "bench-*"]
+    require: flag
+    allow_roles: [lab-admin]
+  - operations: [config-rollout]
+    require: deny
+  - operations: ["*"]
+    require: confirm
+`
+
+func loadTestPolicy(t *testing.T, data string) (*Policy, error) {
+	dir, err := ioutil.TempDir("", "guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "policy.yml")
+	if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(fname)
+}
+
+func TestDecide(t *testing.T) {
+	p, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		req     Request
+		require string
+	}{
+		{Request{Operation: OpFlashErase, Port: "/dev/ttyUSB0", User: "alice"}, RequireFlag},
+		{Request{Operation: OpFlashErase, Port: "/dev/ttyUSB0", User: "bob"}, RequireDeny},
+		{Request{Operation: OpConfigReset, Port: "ws://10.0.0.5/rpc", Device: "bench-3", User: "alice"}, RequireFlag},
+		{Request{Operation: OpFlashErase, Port: "/dev/ttyACM0", User: "bob"}, RequireConfirm},
+		{Request{Operation: OpConfigRollout, User: "alice"}, RequireDeny},
+		{Request{Operation: OpFlash, Port: "/dev/ttyUSB0", User: "bob"}, RequireConfirm},
+	} {
+		d := p.Decide(&c.req)
+		if d.Require != c.require {
+			t.Errorf("%+v: got %q, want %q", c.req, d.Require, c.require)
+		}
+		if d.Require == RequireDeny && d.Reason == "" {
+			t.Errorf("%+v: no reason for denial", c.req)
+		}
+	}
+}
+
+func TestNoPolicy(t *testing.T) {
+	p, err := Load("/nonexistent/policy.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := p.Decide(&Request{Operation: OpFlashErase}); d.Require != RequireNone {
+		t.Errorf("got %q", d.Require)
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	for _, data := range []string{
+		"rules:\n  - operations: [format-everything]\n",
+		"rules:\n  - operations: [flash]\n    require: maybe\n",
+		"rules:\n  - operations: [flash]\n    allow_roles: [nobody]\n",
+	} {
+		if _, err := loadTestPolicy(t, data); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
+
+func TestAppendAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "logs", "audit.log")
+	for _, outcome := range []string{"allowed", "denied"} {
+		e := &AuditEntry{Time: time.Now(), User: "alice", Operation: OpFlashErase, Outcome: outcome}
+		if err := AppendAudit(fname, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	var e AuditEntry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Outcome != "denied" || e.User != "alice" {
+		t.Errorf("got %+v", e)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/history"
+	"cesanta.com/mos/redact"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	historyFile    = flag.String("history-file", "~/.mos/history.log", "File to record mos invocations to, for mos history; empty to not record")
+	historyCommand = flag.String("history-command", "", "mos history: only show invocations of this command")
+	historySince   = flag.Duration("since", 0, "mos history: only show invocations within this time, e.g. 24h")
+	historyFailed  = flag.Bool("failed", false, "mos history: only show failed invocations")
+	historyLimit   = flag.Int("limit", 20, "mos history: number of the latest invocations to show, 0 for all")
+
+	// The invocation being recorded
+	invocationCmd   *command
+	invocationStart = time.Now()
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "history-file", "history-command", "since", "failed", "limit")
+}
+
+// recordInvocation appends the current invocation to the history, if it's
+// a command worth recording.
+func recordInvocation(err error) {
+	if invocationCmd == nil || invocationCmd.name == "history" || isUI || *historyFile == "" {
+		return
+	}
+	e := &history.Entry{
+		Time:       invocationStart,
+		Command:    invocationCmd.name,
+		Args:       history.RedactArgs(os.Args[1:], flag.Args()),
+		DurationMs: int64(time.Since(invocationStart) / time.Millisecond),
+		Version:    version.GetMosVersion(),
+	}
+	e.Dir, _ = os.Getwd()
+	if *portFlag != "auto" {
+		e.Port = *portFlag
+		if port := lookupDevicePort(*portFlag); port != "" {
+			e.Device, e.Port = *portFlag, port
+		}
+	} else {
+		// Detected, if the command needed a port at all
+		e.Port = defaultPort
+	}
+	// Like the ?token= of farm:// ports
+	e.Port = redact.String(e.Port)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	fname, err := paths.NormalizePath(*historyFile, "")
+	if err == nil {
+		err = history.Append(fname, e)
+	}
+	if err != nil {
+		glog.Warningf("failed to record the invocation: %s", err)
+	}
+}
+
+// historyHandler implements "mos history [device]".
+func historyHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) > 1 {
+		return errors.Errorf("usage: mos history [device]")
+	}
+	fname, err := paths.NormalizePath(*historyFile, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	entries, err := history.Load(fname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f := &history.Filter{Command: *historyCommand, Failed: *historyFailed, Limit: *historyLimit}
+	if len(args) > 0 {
+		f.Port = args[0]
+	}
+	if *historySince > 0 {
+		f.Since = time.Now().Add(-*historySince)
+	}
+	entries = f.Apply(entries)
+
+	if *fsJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+	history.Print(os.Stdout, entries)
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package history keeps the log of mos invocations: the command, the device
+// it was run against, the result and how long it took, to be able to tell
+// what was done to a board and when.
+//
+// The log is a file with a line of JSON per invocation, only ever appended
+// to.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cesanta.com/mos/redact"
+	"github.com/cesanta/errors"
+)
+
+// Entry is an invocation.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args,omitempty"`
+	Port    string    `json:"port,omitempty"`
+	// Name of the device in the registry, if it was given by name.
+	Device string `json:"device,omitempty"`
+	Dir    string `json:"dir,omitempty"`
+	// Duration in milliseconds.
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Version    string `json:"version,omitempty"`
+}
+
+// Failed returns whether the invocation failed.
+func (e *Entry) Failed() bool {
+	return e.Error != ""
+}
+
+// Names of flags whose values are secret; files are not.
+var secretFlagRe = regexp.MustCompile(`(?i)^--?[\w-]*(pass|secret|token|psk|creds|credentials|api-key)[\w-]*$`)
+
+// Positions of secret positional args of commands, counting the command
+// itself as 0, like the password in "mos wifi SSID PASS".
+var secretPositional = map[string][]int{
+	"wifi": {2},
+}
+
+// RedactArgs returns the args with secrets, like --rpc-creds values,
+// passwords in config-set or the password of "mos wifi", replaced.
+// positional are the positional args, starting with the command, as parsed
+// from args; they tell which args are positional secrets.
+func RedactArgs(args, positional []string) []string {
+	var secret []int
+	if len(positional) > 0 {
+		secret = secretPositional[positional[0]]
+	}
+	res := make([]string, len(args))
+	pos := 0
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, _, hasValue := splitFlag(a)
+		if name == "" && pos < len(positional) && a == positional[pos] {
+			isSecret := false
+			for _, p := range secret {
+				if p == pos {
+					isSecret = true
+				}
+			}
+			pos++
+			if isSecret {
+				res[i] = redact.Replacement
+				continue
+			}
+		}
+		switch {
+		case !secretFlagRe.MatchString(name) || strings.HasSuffix(name, "-file"):
+			res[i] = redact.String(a)
+		case hasValue:
+			res[i] = name + "=" + redact.Replacement
+		default:
+			res[i] = a
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				res[i] = redact.Replacement
+			}
+		}
+	}
+	return res
+}
+
+func splitFlag(a string) (string, string, bool) {
+	if !strings.HasPrefix(a, "-") {
+		return "", "", false
+	}
+	if i := strings.Index(a, "="); i > 0 {
+		return a[:i], a[i+1:], true
+	}
+	return a, "", false
+}
+
+// Append appends the entry to the log. The log is only readable by the user:
+// even with secrets redacted, it tells which devices are where.
+func Append(fname string, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
+
+// Load reads the log; lines which can't be parsed, like the one being written
+// by a concurrent invocation, are skipped.
+func Load(fname string) ([]*Entry, error) {
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	var res []*Entry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res, errors.Trace(s.Err())
+}
+
+// Filter selects entries.
+type Filter struct {
+	Command string
+	// Matches the port or the device name.
+	Port   string
+	Since  time.Time
+	Failed bool
+	// Only the last Limit entries, if positive.
+	Limit int
+}
+
+// Apply returns the matching entries, oldest first.
+func (f *Filter) Apply(entries []*Entry) []*Entry {
+	var res []*Entry
+	for _, e := range entries {
+		if f.Command != "" && e.Command != f.Command {
+			continue
+		}
+		if f.Port != "" && e.Port != f.Port && e.Device != f.Port {
+			continue
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			continue
+		}
+		if f.Failed && !e.Failed() {
+			continue
+		}
+		res = append(res, e)
+	}
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[len(res)-f.Limit:]
+	}
+	return res
+}
+
+// Print prints the entries as a table.
+func Print(w io.Writer, entries []*Entry) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\tDEVICE\tDURATION\tRESULT\tCOMMAND\n")
+	for _, e := range entries {
+		dev := e.Port
+		if e.Device != "" {
+			dev = e.Device
+		}
+		if dev == "" {
+			dev = "-"
+		}
+		result := "ok"
+		if e.Failed() {
+			result = "error: " + e.Error
+			if len(result) > 60 {
+				result = result[:57] + "..."
+			}
+		}
+		d := time.Duration(e.DurationMs) * time.Millisecond
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), dev, d.Round(100*time.Millisecond), result,
+			strings.Join(append([]string{"mos"}, e.Args...), " "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,103 @@
+package history
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cesanta.com/mos/redact"
+)
+
+func TestRedactArgs(t *testing.T) {
+	got := RedactArgs([]string{
+		"call", "--rpc-creds", "admin:secret", "--rpc-psk=0123456789abcdef",
+		"--port", "ws://10.0.0.1/rpc", "--key-file", "key.pem", "wifi.sta.pass=hunter2",
+	}, []string{"call", "wifi.sta.pass=hunter2"})
+	want := []string{
+		"call", "--rpc-creds", redact.Replacement, "--rpc-psk=" + redact.Replacement,
+		"--port", "ws://10.0.0.1/rpc", "--key-file", "key.pem", "wifi.sta.pass=" + redact.Replacement,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Positional password
+	got = RedactArgs([]string{"--port", "/dev/ttyUSB0", "wifi", "MyNet", "hunter2", "--verbose"}, []string{"wifi", "MyNet", "hunter2"})
+	want = []string{"--port", "/dev/ttyUSB0", "wifi", "MyNet", redact.Replacement, "--verbose"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Token of a farm port
+	got = RedactArgs([]string{"--port=farms://lab/esp32-1?token=s3cr3t", "ls"}, []string{"ls"})
+	want = []string{"--port=farms://lab/esp32-1?token=" + redact.Replacement, "ls"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "history.log")
+
+	now := time.Now()
+	for _, e := range []*Entry{
+		{Time: now.Add(-48 * time.Hour), Command: "flash", Args: []string{"flash"}, Port: "/dev/ttyUSB0", DurationMs: 30000},
+		{Time: now.Add(-time.Hour), Command: "flash", Args: []string{"flash"}, Port: "/dev/ttyUSB1", Device: "bench-1", Error: "timeout"},
+		{Time: now, Command: "console", Args: []string{"console"}, Port: "/dev/ttyUSB0"},
+	} {
+		if err := Append(fname, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, err := os.Stat(fname); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("expected the log to be 0600, got %v, %v", st.Mode(), err)
+	}
+	// A partially written line
+	f, _ := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte(`{"time":`))
+	f.Close()
+
+	entries, err := Load(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+
+	for _, c := range []struct {
+		f    Filter
+		want int
+	}{
+		{Filter{}, 3},
+		{Filter{Command: "flash"}, 2},
+		{Filter{Port: "bench-1"}, 1},
+		{Filter{Port: "/dev/ttyUSB0"}, 2},
+		{Filter{Since: now.Add(-24 * time.Hour)}, 2},
+		{Filter{Failed: true}, 1},
+		{Filter{Limit: 1}, 1},
+	} {
+		if got := c.f.Apply(entries); len(got) != c.want {
+			t.Errorf("%+v: got %d entries, want %d", c.f, len(got), c.want)
+		}
+	}
+	if got := (&Filter{Limit: 1}).Apply(entries); got[0].Command != "console" {
+		t.Errorf("limit should keep the latest entries")
+	}
+
+	var out bytes.Buffer
+	Print(&out, entries)
+	if !strings.Contains(out.String(), "bench-1") || !strings.Contains(out.String(), "error: timeout") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
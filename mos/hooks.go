@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+type hookType string
+
+const (
+	hookPreBuild  hookType = "pre_build"
+	hookPostBuild hookType = "post_build"
+	hookPreFlash  hookType = "pre_flash"
+	hookPostFlash hookType = "post_flash"
+	hookPreOTA    hookType = "pre_ota"
+	hookPostOTA   hookType = "post_ota"
+)
+
+var (
+	noHooks = flag.Bool("no-hooks", false, "do not run lifecycle hooks defined in the \"hooks\" section of mos.yml")
+)
+
+// getHookCommands returns commands for the given hook type.
+func getHookCommands(hooks *build.ManifestHooks, ht hookType) build.HookCommands {
+	if hooks == nil {
+		return nil
+	}
+	switch ht {
+	case hookPreBuild:
+		return hooks.PreBuild
+	case hookPostBuild:
+		return hooks.PostBuild
+	case hookPreFlash:
+		return hooks.PreFlash
+	case hookPostFlash:
+		return hooks.PostFlash
+	case hookPreOTA:
+		return hooks.PreOTA
+	case hookPostOTA:
+		return hooks.PostOTA
+	}
+	return nil
+}
+
+// readProjectHooks reads hooks from the manifest in the project dir. If there
+// is no manifest, nil is returned.
+func readProjectHooks() (*build.ManifestHooks, error) {
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return manifest.Hooks, nil
+}
+
+// runHooks runs commands of the given hook type defined in the project
+// manifest, if any. Commands are executed by the shell from the project
+// directory; besides the mos environment, they get the following variables:
+//
+// - MOS_HOOK: hook type, e.g. "post_build"
+// - MOS_VERSION: version of mos
+// - MOS_PROJECT_DIR: absolute path to the project dir
+// - MOS_BUILD_DIR: absolute path to the build dir
+//
+// plus all the values from the given env, e.g. MOS_FW_ZIP or MOS_PORT.
+func runHooks(ht hookType, env map[string]string, out io.Writer) error {
+	if *noHooks {
+		return nil
+	}
+
+	hooks, err := readProjectHooks()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	cmds := getHookCommands(hooks, ht)
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	projectDirAbs, err := filepath.Abs(projectDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	hookEnv := map[string]string{
+		"MOS_HOOK":        string(ht),
+		"MOS_VERSION":     version.GetMosVersion(),
+		"MOS_PROJECT_DIR": projectDirAbs,
+		"MOS_BUILD_DIR":   moscommon.GetBuildDir(projectDirAbs),
+	}
+	for k, v := range env {
+		hookEnv[k] = v
+	}
+
+	envList := os.Environ()
+	keys := make([]string, 0, len(hookEnv))
+	for k := range hookEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		envList = append(envList, fmt.Sprintf("%s=%s", k, hookEnv[k]))
+	}
+
+	for _, c := range cmds {
+		freportf(out, "Running %s hook: %s", ht, c)
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", c)
+		} else {
+			cmd = exec.Command("sh", "-c", c)
+		}
+		cmd.Dir = projectDirAbs
+		cmd.Env = envList
+		cmd.Stdout = out
+		cmd.Stderr = out
+
+		if err := cmd.Run(); err != nil {
+			return errors.Annotatef(err, "%s hook %q failed", ht, c)
+		}
+	}
+
+	return nil
+}
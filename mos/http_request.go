@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/httpdigest"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	httpHost    = flag.String("http-host", "", "mos http: host[:port] of the device web server; by default, the host of a ws:// or http:// --port, or the device's station IP; required for other network ports")
+	httpHTTPS   = flag.Bool("https", false, "mos http: use HTTPS")
+	httpCreds   = flag.String("http-creds", "", `mos http: digest auth "user:pass"; by default, the user and hash from the device's http.auth_file are used`)
+	httpUser    = flag.String("http-user", "", "mos http: user to pick from the device's http.auth_file; by default, the first one")
+	httpHeaders = flag.StringSlice("http-header", nil, `mos http: extra request headers, "Name: value"`)
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "http-host", "https", "http-creds", "http-user", "http-header")
+}
+
+// httpHandler implements "mos http get|post|put|delete <path> [body|@file]":
+// it sends the request to the device's web server, authenticating if asked
+// to, and prints the response, JSON pretty-printed.
+func httpHandler(ctx context.Context, _ *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 2 || len(args) > 3 {
+		return errors.Errorf("usage: mos http get|post|put|delete <path> [body|@file]")
+	}
+	method := strings.ToUpper(args[0])
+	switch method {
+	case "GET", "POST", "PUT", "DELETE":
+	default:
+		return errors.Errorf("unknown command %q, expected get, post, put or delete", args[0])
+	}
+	path := args[1]
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	var body []byte
+	if len(args) == 3 {
+		body = []byte(args[2])
+		if strings.HasPrefix(args[2], "@") {
+			var err error
+			if body, err = ioutil.ReadFile(args[2][1:]); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	// The device is only connected to if needed
+	var devConn *dev.DevConn
+	getDevConn := func() (*dev.DevConn, error) {
+		if devConn != nil {
+			return devConn, nil
+		}
+		var err error
+		devConn, err = createDevConn(ctx)
+		return devConn, errors.Trace(err)
+	}
+	defer func() {
+		if devConn != nil {
+			devConn.Disconnect(ctx)
+		}
+	}()
+
+	host, err := getHTTPHost(ctx, getDevConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	scheme := "http"
+	if *httpHTTPS {
+		scheme = "https"
+	}
+	u := scheme + "://" + host + path
+
+	resp, err := doHTTPRequest(method, u, body, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		auth, err := getHTTPAuthorization(ctx, resp, method, path, getDevConn)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if resp, err = doHTTPRequest(method, u, body, auth); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	defer resp.Body.Close()
+	return errors.Trace(printHTTPResponse(resp))
+}
+
+// getHTTPHost returns --http-host, the host of a websocket or HTTP --port,
+// or the station IP the device reports over a serial port. For other network
+// ports, like mqtt://, the host is not the device, so --http-host is required.
+func getHTTPHost(ctx context.Context, getDevConn func() (*dev.DevConn, error)) (string, error) {
+	if *httpHost != "" {
+		return *httpHost, nil
+	}
+	port, err := getPort()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if strings.Contains(port, "://") && !strings.HasPrefix(port, "serial://") {
+		addr, err := normalizeNetworkPort(port)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		switch u.Scheme {
+		case "ws", "wss", "http", "https":
+			if u.Hostname() != "" {
+				// The port of the RPC endpoint is the web server port, too
+				return u.Host, nil
+			}
+		}
+		return "", errors.Errorf("can't tell the device web server address from --port %s, use --http-host", port)
+	}
+	devConn, err := getDevConn()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	info, err := devConn.GetInfo(ctx)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if info.Wifi == nil || info.Wifi.Sta_ip == nil || *info.Wifi.Sta_ip == "" {
+		return "", errors.Errorf("the device has no station IP, use --http-host")
+	}
+	return *info.Wifi.Sta_ip, nil
+}
+
+func doHTTPRequest(method, u string, body []byte, auth string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(body) > 0 {
+		if isJSON(string(body)) {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "text/plain")
+		}
+	}
+	for _, h := range *httpHeaders {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	return resp, errors.Trace(err)
+}
+
+// getHTTPAuthorization answers the digest challenge of the response, with
+// --http-creds or the credentials from the device's auth file.
+func getHTTPAuthorization(
+	ctx context.Context, resp *http.Response, method, path string, getDevConn func() (*dev.DevConn, error),
+) (string, error) {
+	c, err := httpdigest.ParseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return "", errors.Annotatef(err, "authentication required")
+	}
+	if *httpCreds != "" {
+		parts := strings.SplitN(*httpCreds, ":", 2)
+		if len(parts) != 2 {
+			return "", errors.Errorf("invalid --http-creds, expected user:pass")
+		}
+		return c.Authorization(method, path, parts[0], httpdigest.HA1(parts[0], c.Realm, parts[1]))
+	}
+
+	devConn, err := getDevConn()
+	if err != nil {
+		return "", errors.Annotatef(err, "authentication required, use --http-creds")
+	}
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	authFile, _ := devConf.Get("http.auth_file")
+	if authFile == "" {
+		return "", errors.Errorf("authentication required, but http.auth_file is not set on the device, use --http-creds")
+	}
+	data, err := getFile(ctx, devConn, authFile)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to read %s", authFile)
+	}
+	for _, e := range httpdigest.ParseHTDigest(data, c.Realm) {
+		if *httpUser == "" || e.User == *httpUser {
+			reportf("Authenticating as %s", e.User)
+			return c.Authorization(method, path, e.User, e.HA1)
+		}
+	}
+	return "", errors.Errorf("no matching user for realm %q in %s, use --http-creds", c.Realm, authFile)
+}
+
+func printHTTPResponse(resp *http.Response) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reportf("%s %s", resp.Proto, resp.Status)
+	if *verbose {
+		for name, vals := range resp.Header {
+			for _, v := range vals {
+				reportf("%s: %s", name, v)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if json.Indent(&buf, data, "", "  ") == nil {
+		data = append(buf.Bytes(), '\n')
+	}
+	os.Stdout.Write(data)
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package httpdigest implements the client side of HTTP digest
+// authentication (RFC 2617, MD5, qop=auth), as used by Mongoose OS HTTP
+// servers. Credentials can be given either as passwords or as HA1 hashes,
+// which is what htdigest files on devices contain.
+package httpdigest
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Challenge is the parsed WWW-Authenticate: Digest header.
+type Challenge struct {
+	Realm  string
+	Nonce  string
+	Opaque string
+	QOP    string
+}
+
+// ParseChallenge parses the value of the WWW-Authenticate header.
+func ParseChallenge(header string) (*Challenge, error) {
+	const prefix = "Digest "
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errors.Errorf("not a digest challenge: %q", header)
+	}
+	c := &Challenge{}
+	for _, kv := range splitParams(header[len(prefix):]) {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		v := strings.Trim(parts[1], `"`)
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "realm":
+			c.Realm = v
+		case "nonce":
+			c.Nonce = v
+		case "opaque":
+			c.Opaque = v
+		case "qop":
+			// Only auth is supported
+			for _, q := range strings.Split(v, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					c.QOP = "auth"
+				}
+			}
+		}
+	}
+	if c.Nonce == "" {
+		return nil, errors.Errorf("no nonce in the digest challenge: %q", header)
+	}
+	return c, nil
+}
+
+// splitParams splits comma-separated params, leaving commas in quoted values
+// alone.
+func splitParams(s string) []string {
+	var res []string
+	quoted, start := false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			res = append(res, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(res, strings.TrimSpace(s[start:]))
+}
+
+// HA1 returns the hash of the credentials.
+func HA1(user, realm, pass string) string {
+	return md5hex(user + ":" + realm + ":" + pass)
+}
+
+// Authorization returns the value of the Authorization header for the
+// request.
+func (c *Challenge) Authorization(method, uri, user, ha1 string) (string, error) {
+	ha2 := md5hex(method + ":" + uri)
+	res := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, c.Realm, c.Nonce, uri)
+	if c.QOP != "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", errors.Trace(err)
+		}
+		cnonce, nc := hex.EncodeToString(b), "00000001"
+		resp := md5hex(strings.Join([]string{ha1, c.Nonce, nc, cnonce, c.QOP, ha2}, ":"))
+		res += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, c.QOP, nc, cnonce, resp)
+	} else {
+		res += fmt.Sprintf(`, response="%s"`, md5hex(ha1+":"+c.Nonce+":"+ha2))
+	}
+	if c.Opaque != "" {
+		res += fmt.Sprintf(`, opaque="%s"`, c.Opaque)
+	}
+	return res, nil
+}
+
+// Entry is a line of an htdigest file.
+type Entry struct {
+	User  string
+	Realm string
+	HA1   string
+}
+
+// ParseHTDigest returns the entries of an htdigest file, user:realm:ha1 per
+// line, for the given realm.
+func ParseHTDigest(data, realm string) []Entry {
+	var res []Entry
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Split(strings.TrimSpace(line), ":")
+		if len(parts) != 3 || parts[1] != realm {
+			continue
+		}
+		res = append(res, Entry{User: parts[0], Realm: parts[1], HA1: strings.ToLower(parts[2])})
+	}
+	return res
+}
+
+func md5hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
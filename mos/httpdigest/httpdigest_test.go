@@ -0,0 +1,38 @@
+package httpdigest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	c, err := ParseChallenge(`Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Realm != "testrealm@host.com" || c.QOP != "auth" || c.Opaque != "5ccc069c403ebaf9f0171e9517f40e41" {
+		t.Errorf("unexpected challenge %+v", c)
+	}
+
+	// Example from RFC 2617, without qop as the cnonce is random
+	c.QOP = ""
+	ha1 := HA1("Mufasa", c.Realm, "Circle Of Life")
+	a, err := c.Authorization("GET", "/dir/index.html", "Mufasa", ha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(a, `response="670fd8c2df070c60b045671b8b24ff02"`) {
+		t.Errorf("unexpected authorization %s", a)
+	}
+
+	if _, err := ParseChallenge(`Basic realm="x"`); err == nil {
+		t.Errorf("basic challenge accepted")
+	}
+}
+
+func TestParseHTDigest(t *testing.T) {
+	es := ParseHTDigest("admin:dev:0123ABCD\nguest:other:ffff\n\nuser:dev:abcd\n", "dev")
+	if len(es) != 2 || es[0].User != "admin" || es[0].HA1 != "0123abcd" || es[1].User != "user" {
+		t.Errorf("unexpected entries %+v", es)
+	}
+}
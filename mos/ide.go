@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"context"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/devreg"
+	"cesanta.com/mos/jsonrpc2"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	ideAddr     = flag.String("ide-addr", "", "mos ide serve: TCP address to listen on, like 127.0.0.1:1993; stdin and stdout are used if empty")
+	ideToken    = flag.String("ide-token", "", "mos ide serve: access token the IDE has to give in initialize, required when --ide-addr is not a loopback address")
+	ideInsecure = flag.Bool("ide-insecure", false, "mos ide serve: allow --ide-addr which is not a loopback address without --ide-token")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "ide-addr", "ide-token", "ide-insecure")
+}
+
+// ideHandler implements "mos ide serve": a JSON-RPC 2.0 API for IDE
+// extensions, framed like LSP, over stdio or TCP. Builds, flashing and the
+// console run as tasks, mos subprocesses whose output is sent as
+// notifications:
+//
+//	task/log       {"task": "build-1", "line": "..."}
+//	task/progress  {"task": "build-1", "stage": "build", "percent": 45, "message": "..."}
+//	console/output {"task": "console-2", "data": "..."}
+//
+// Methods: initialize, devices/list, config/schema, build, flash,
+// console/start, console/stop, task/cancel. With --ide-token, initialize has
+// to be called first with {"token": "..."}.
+func ideHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) != 1 || args[0] != "serve" {
+		return errors.Errorf("usage: mos ide serve [--ide-addr host:port]")
+	}
+	if *ideAddr == "" {
+		return errors.Trace(newIDEServer().serve(ctx, os.Stdin, os.Stdout))
+	}
+	// The API builds and flashes whatever it's told to, so anyone on the
+	// network must not be able to use it
+	if *ideToken == "" && !*ideInsecure && !isLoopbackAddr(*ideAddr) {
+		return errors.Errorf("refusing to serve the IDE API on %s without authentication: "+
+			"use --ide-token, or listen on a loopback address, like --ide-addr 127.0.0.1:1993", *ideAddr)
+	}
+	ln, err := net.Listen("tcp", *ideAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer ln.Close()
+	reportf("Serving the IDE API on %s", ln.Addr())
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Trace(err)
+		}
+		go func() {
+			defer c.Close()
+			s := newIDEServer()
+			s.token = *ideToken
+			if err := s.serve(ctx, c, c); err != nil {
+				glog.Errorf("IDE connection from %s: %s", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+type ideServer struct {
+	// Token the client has to give in initialize, if not empty.
+	token string
+
+	mu         sync.Mutex
+	authorized bool
+	lastID     int
+	tasks      map[string]context.CancelFunc
+}
+
+func newIDEServer() *ideServer {
+	return &ideServer{tasks: map[string]context.CancelFunc{}}
+}
+
+func (s *ideServer) serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Running tasks are stopped when the IDE goes away
+	defer cancel()
+	c := jsonrpc2.NewConn(r, w)
+	c.Handle("initialize", s.initialize)
+	c.Handle("devices/list", s.auth(s.devicesList))
+	c.Handle("config/schema", s.auth(s.configSchema))
+	c.HandleAsync("build", s.auth(s.build))
+	c.HandleAsync("flash", s.auth(s.flash))
+	c.Handle("console/start", s.auth(s.consoleStart))
+	c.Handle("console/stop", s.auth(s.taskCancel))
+	c.Handle("task/cancel", s.auth(s.taskCancel))
+	return errors.Trace(c.Serve(ctx))
+}
+
+// auth wraps the handler so that it fails until the client gives the token
+// in initialize.
+func (s *ideServer) auth(h jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+		s.mu.Lock()
+		authorized := s.token == "" || s.authorized
+		s.mu.Unlock()
+		if !authorized {
+			return nil, errors.Errorf("unauthorized, call initialize with the token first")
+		}
+		return h(ctx, c, params)
+	}
+}
+
+type ideInitializeParams struct {
+	Token string `json:"token,omitempty"`
+}
+
+func (s *ideServer) initialize(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	var p ideInitializeParams
+	if err := unmarshalIDEParams(params, &p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if s.token != "" {
+		if !secureEqual(p.Token, s.token) {
+			return nil, errors.Errorf("invalid token")
+		}
+		s.mu.Lock()
+		s.authorized = true
+		s.mu.Unlock()
+	}
+	projectDirAbs, err := filepath.Abs(projectDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return map[string]interface{}{
+		"mosVersion": version.GetMosVersion(),
+		"projectDir": projectDirAbs,
+		"methods": []string{
+			"initialize", "devices/list", "config/schema", "build", "flash",
+			"console/start", "console/stop", "task/cancel",
+		},
+	}, nil
+}
+
+func (s *ideServer) devicesList(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	r, _, err := loadDeviceRegistry()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	devs, err := r.Select("")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if devs == nil {
+		devs = []*devreg.Device{}
+	}
+	ports := enumerateSerialPorts()
+	if ports == nil {
+		ports = []string{}
+	}
+	// No default port is fine: one is chosen by the user then
+	port, _ := getPort()
+	return map[string]interface{}{
+		"registered":  devs,
+		"serialPorts": ports,
+		"defaultPort": port,
+	}, nil
+}
+
+// configSchema returns the config schema of the last build.
+func (s *ideServer) configSchema(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	fname := moscommon.GetConfSchemaFilePath(moscommon.GetBuildDir(projectDir))
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("%s does not exist, build the project first", fname)
+		}
+		return nil, errors.Trace(err)
+	}
+	var schema []build.ConfigSchemaItem
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return nil, errors.Annotatef(err, "parsing %s", fname)
+	}
+	return schema, nil
+}
+
+type ideBuildParams struct {
+	Platform string   `json:"platform,omitempty"`
+	Local    bool     `json:"local,omitempty"`
+	Clean    bool     `json:"clean,omitempty"`
+	Args     []string `json:"args,omitempty"`
+}
+
+func (s *ideServer) build(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	var p ideBuildParams
+	if err := unmarshalIDEParams(params, &p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	args := []string{"build"}
+	if p.Platform != "" {
+		args = append(args, "--platform", p.Platform)
+	}
+	if p.Local {
+		args = append(args, "--local")
+	}
+	if p.Clean {
+		args = append(args, "--clean")
+	}
+	res, err := s.runTask(ctx, c, "build", append(args, p.Args...), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if res.OK {
+		res.Firmware, _ = filepath.Abs(moscommon.GetFirmwareZipFilePath(moscommon.GetBuildDir(projectDir)))
+	}
+	return res, nil
+}
+
+type ideFlashParams struct {
+	Port     string   `json:"port,omitempty"`
+	Firmware string   `json:"firmware,omitempty"`
+	Args     []string `json:"args,omitempty"`
+}
+
+func (s *ideServer) flash(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	var p ideFlashParams
+	if err := unmarshalIDEParams(params, &p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	args := []string{"flash"}
+	if p.Port != "" {
+		args = append(args, "--port", p.Port)
+	}
+	if p.Firmware != "" {
+		args = append(args, "--firmware", p.Firmware)
+	}
+	// There is nobody to answer prompts, so questions fail instead of being
+	// answered yes
+	args = append(args, "--non-interactive")
+	return s.runTask(ctx, c, "flash", append(args, p.Args...), nil)
+}
+
+type ideConsoleParams struct {
+	Port string `json:"port,omitempty"`
+}
+
+// consoleStart starts streaming the device console as console/output
+// notifications, until console/stop.
+func (s *ideServer) consoleStart(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	var p ideConsoleParams
+	if err := unmarshalIDEParams(params, &p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	args := []string{"console"}
+	if p.Port != "" {
+		args = append(args, "--port", p.Port)
+	}
+	task, taskCtx := s.newTask(ctx, "console")
+	go func() {
+		defer s.endTask(task)
+		res, err := s.runTaskCmd(taskCtx, c, task, "console", args, func(data []byte) {
+			c.Notify("console/output", map[string]string{"task": task, "data": string(data)})
+		})
+		if err != nil {
+			glog.Errorf("console: %s", err)
+			return
+		}
+		c.Notify("task/progress", map[string]interface{}{"task": task, "stage": "console", "message": "exited", "exitCode": res.ExitCode})
+	}()
+	return map[string]string{"task": task}, nil
+}
+
+type ideTaskParams struct {
+	Task string `json:"task"`
+}
+
+func (s *ideServer) taskCancel(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	var p ideTaskParams
+	if err := unmarshalIDEParams(params, &p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.mu.Lock()
+	cancel := s.tasks[p.Task]
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil, jsonrpc2.InvalidParams(errors.Errorf("no task %q", p.Task))
+	}
+	cancel()
+	return true, nil
+}
+
+type ideTaskResult struct {
+	Task     string `json:"task"`
+	OK       bool   `json:"ok"`
+	ExitCode int    `json:"exitCode"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+func (s *ideServer) newTask(ctx context.Context, stage string) (string, context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	task := fmt.Sprintf("%s-%d", stage, s.lastID)
+	taskCtx, cancel := context.WithCancel(ctx)
+	s.tasks[task] = cancel
+	return task, taskCtx
+}
+
+func (s *ideServer) endTask(task string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel := s.tasks[task]; cancel != nil {
+		cancel()
+		delete(s.tasks, task)
+	}
+}
+
+// runTask runs mos with the given args as a task, and waits for it.
+func (s *ideServer) runTask(
+	ctx context.Context, c *jsonrpc2.Conn, stage string, args []string, onData func(data []byte),
+) (*ideTaskResult, error) {
+	task, taskCtx := s.newTask(ctx, stage)
+	defer s.endTask(task)
+	return s.runTaskCmd(taskCtx, c, task, stage, args, onData)
+}
+
+var ideProgressRE = regexp.MustCompile(`(\d{1,3})%`)
+
+// runTaskCmd runs mos with the given args in the project dir. Output is
+// passed to onData if given, otherwise it's sent line by line as task/log
+// notifications, with task/progress for lines with percentages.
+func (s *ideServer) runTaskCmd(
+	ctx context.Context, c *jsonrpc2.Conn, task, stage string, args []string, onData func(data []byte),
+) (*ideTaskResult, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Dir = projectDir
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	c.Notify("task/progress", map[string]interface{}{"task": task, "stage": stage, "message": "started"})
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if onData != nil {
+			buf := make([]byte, 4096)
+			for {
+				n, err := pr.Read(buf)
+				if n > 0 {
+					onData(buf[:n])
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+		sc := bufio.NewScanner(pr)
+		sc.Split(scanIDELines)
+		for sc.Scan() {
+			line := sc.Text()
+			c.Notify("task/log", map[string]string{"task": task, "line": line})
+			if m := ideProgressRE.FindStringSubmatch(line); m != nil {
+				pct, _ := strconv.Atoi(m[1])
+				c.Notify("task/progress", map[string]interface{}{"task": task, "stage": stage, "percent": pct, "message": line})
+			}
+		}
+		io.Copy(ioutil.Discard, pr)
+	}()
+	err = cmd.Wait()
+	pw.Close()
+	<-done
+
+	res := &ideTaskResult{Task: task, OK: err == nil}
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, errors.Trace(err)
+		}
+	}
+	c.Notify("task/progress", map[string]interface{}{"task": task, "stage": stage, "message": "done", "ok": res.OK})
+	return res, nil
+}
+
+// scanIDELines splits the output into lines on either \n or \r, since
+// progress is often updated in place.
+func scanIDELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func unmarshalIDEParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return jsonrpc2.InvalidParams(err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/build/idfcomp"
+	"github.com/cesanta/errors"
+)
+
+// prepareIDFComponents fetches the ESP-IDF components from the manifest, the
+// same way modules are fetched, and returns their IDF names and local dirs.
+// If a component is given more than once, e.g. by a lib and the app, the
+// latter wins.
+func prepareIDFComponents(
+	manifest *build.FWAppManifest, cp *compProviderReal, appDir string,
+) (names []string, dirs []string, err error) {
+	if len(manifest.ESPIDFComponents) == 0 {
+		return nil, nil, nil
+	}
+	if !strings.HasPrefix(manifest.Platform, "esp32") {
+		return nil, nil, errors.Errorf("esp_idf_components are only supported on ESP32, not %s", manifest.Platform)
+	}
+	idx := map[string]int{}
+	for _, m := range manifest.ESPIDFComponents {
+		m.Normalize()
+		name, err := idfcomp.ComponentName(&m)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if idfcomp.IsRegistry(&m) {
+			rm, err := idfcomp.Resolve(&m)
+			if err != nil {
+				return nil, nil, errors.Trace(err)
+			}
+			freportf(logWriter, "ESP-IDF component %q: using version %s", m.Name, rm.Version)
+			m = *rm
+		} else if m.Name == "" {
+			m.Name = name
+		}
+		dir, err := cp.GetModuleLocalPath(&m, appDir, "", manifest.Platform)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "preparing ESP-IDF component %q", name)
+		}
+		if i, ok := idx[name]; ok {
+			dirs[i] = dir
+			continue
+		}
+		idx[name] = len(names)
+		names = append(names, name)
+		dirs = append(dirs, dir)
+	}
+	return names, dirs, nil
+}
+
+// resolveIDFComponents replaces registry components in the manifest with the
+// archives of the matching versions, for the remote builder.
+func resolveIDFComponents(manifest *build.FWAppManifest) error {
+	for i, m := range manifest.ESPIDFComponents {
+		if !idfcomp.IsRegistry(&m) {
+			continue
+		}
+		rm, err := idfcomp.Resolve(&m)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		manifest.ESPIDFComponents[i] = *rm
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -14,6 +13,7 @@ import (
 	"cesanta.com/mos/build/archive"
 	moscommon "cesanta.com/mos/common"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/download"
 	"github.com/cesanta/errors"
 )
 
@@ -35,20 +35,7 @@ func initFW(ctx context.Context, devConn *dev.DevConn) error {
 	fmt.Println("Downloading empty app...")
 
 	url := fmt.Sprintf("https://github.com/mongoose-os-apps/empty/archive/master.zip")
-	resp, err := http.Get(url)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("bad response %d on %q", resp.StatusCode, url)
-	}
-
-	defer resp.Body.Close()
-
-	// We have to create a new reader since resp.Body doesn't implement ReadAt
-	// which is needed for unzip.UnzipInto.
-	zipData, err := ioutil.ReadAll(resp.Body)
+	zipData, err := download.Get(url)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"context"
+
+	"cesanta.com/common/go/multierror"
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/manifest_parser"
+	"cesanta.com/mos/mjs"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	jsEvalMethod = flag.String("js-eval-method", "MJS.Exec", "RPC method used by \"mos js eval\"; it's called with {\"code\": \"...\"}")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "js-eval-method")
+}
+
+func jsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: check or eval")
+	}
+
+	switch args[0] {
+	case "check":
+		return errors.Trace(jsCheck(args[1:]))
+	case "eval":
+		return errors.Trace(jsEval(ctx, args[1:]))
+	}
+
+	return errors.Errorf("unknown command %q, expected check or eval", args[0])
+}
+
+// getProjectJSFiles returns all JS files from the project's filesystem.
+func getProjectJSFiles() ([]string, error) {
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	fsFiles, err := manifest_parser.ResolveFilesystemPaths(manifest.Filesystem, projectDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ret := []string{}
+	for _, f := range fsFiles {
+		if filepath.Ext(f) == ".js" {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+// jsCheck checks syntax of the given JS files, or, if none are given, of all
+// JS files from the project's filesystem.
+func jsCheck(files []string) error {
+	if len(files) == 0 {
+		var err error
+		files, err = getProjectJSFiles()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(files) == 0 {
+			ourutil.Reportf("No JS files found")
+			return nil
+		}
+	}
+
+	var errs error
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := mjs.CheckFile(f, data); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+
+	ourutil.Reportf("Checked %d file(s), no errors found", len(files))
+	return nil
+}
+
+// jsEval evaluates the given code on the device and prints the result.
+func jsEval(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.Errorf("code required")
+	}
+	code := strings.Join(args, " ")
+
+	// Catch syntax errors before bothering the device
+	if err := mjs.Check([]byte(code)); err != nil {
+		return errors.Annotatef(err, "syntax error")
+	}
+
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	params, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// callDeviceService returns pretty-printed JSON already
+	result, err := callDeviceService(ctx, devConn, *jsEvalMethod, string(params))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Println(result)
+	return nil
+}
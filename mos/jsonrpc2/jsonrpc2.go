@@ -0,0 +1,206 @@
+// Package jsonrpc2 implements JSON-RPC 2.0 over a stream, with messages
+// framed by the Content-Length header like in the Language Server Protocol.
+// It's what IDE integrations talk to mos with.
+package jsonrpc2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cesanta/errors"
+)
+
+// Error codes defined by the spec.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// Returned for errors of handlers which are not *Error.
+	CodeServerError = -32000
+)
+
+// Error is the JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// InvalidParams returns the error for params which can't be parsed.
+func InvalidParams(err error) *Error {
+	return &Error{Code: CodeInvalidParams, Message: err.Error()}
+}
+
+// Message is a request, a notification (no ID) or a response.
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// ReadMessage reads a framed message.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	hdr, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(hdr.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, errors.Errorf("invalid Content-Length %q", hdr.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, &Error{Code: CodeParseError, Message: err.Error()}
+	}
+	return &m, nil
+}
+
+// WriteMessage writes the message, framed.
+func WriteMessage(w io.Writer, m *Message) error {
+	m.JSONRPC = "2.0"
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Handler handles a request or a notification; for notifications, the
+// result is dropped.
+type Handler func(ctx context.Context, c *Conn, params json.RawMessage) (interface{}, error)
+
+type handlerEntry struct {
+	h     Handler
+	async bool
+}
+
+// Conn serves requests coming from r, and writes responses and
+// notifications to w.
+type Conn struct {
+	r        *bufio.Reader
+	w        io.Writer
+	wmu      sync.Mutex
+	handlers map[string]handlerEntry
+	wg       sync.WaitGroup
+}
+
+// NewConn returns the connection over the given reader and writer.
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{r: bufio.NewReader(r), w: w, handlers: map[string]handlerEntry{}}
+}
+
+// Handle registers the handler of the method; it's run in the order
+// messages come in, so the next message waits for it.
+func (c *Conn) Handle(method string, h Handler) {
+	c.handlers[method] = handlerEntry{h: h}
+}
+
+// HandleAsync registers the handler of the method which is run in its own
+// goroutine, for long running requests like builds.
+func (c *Conn) HandleAsync(method string, h Handler) {
+	c.handlers[method] = handlerEntry{h: h, async: true}
+}
+
+// Notify sends the notification to the peer.
+func (c *Conn) Notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return c.write(&Message{Method: method, Params: data})
+}
+
+func (c *Conn) write(m *Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return WriteMessage(c.w, m)
+}
+
+// Serve reads and handles messages until the reader is closed or the
+// context is done, and waits for async handlers to finish.
+func (c *Conn) Serve(ctx context.Context) error {
+	defer c.wg.Wait()
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m, err := ReadMessage(c.r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if e, ok := err.(*Error); ok {
+				c.write(&Message{ID: nullID(), Error: e})
+				continue
+			}
+			return errors.Trace(err)
+		}
+		if m.Method == "" {
+			// A response; we don't send requests
+			continue
+		}
+		he, ok := c.handlers[m.Method]
+		if !ok {
+			if m.ID != nil {
+				c.write(&Message{ID: m.ID, Error: &Error{Code: CodeMethodNotFound, Message: "method not found: " + m.Method}})
+			}
+			continue
+		}
+		if he.async {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.call(ctx, he.h, m)
+			}()
+		} else {
+			c.call(ctx, he.h, m)
+		}
+	}
+}
+
+func (c *Conn) call(ctx context.Context, h Handler, m *Message) {
+	res, err := h(ctx, c, m.Params)
+	if m.ID == nil {
+		return
+	}
+	resp := &Message{ID: m.ID}
+	if err != nil {
+		e, ok := errors.Cause(err).(*Error)
+		if !ok {
+			e = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		resp.Error = e
+	} else if res == nil {
+		resp.Result = json.RawMessage("null")
+	} else {
+		resp.Result = res
+	}
+	c.write(resp)
+}
+
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
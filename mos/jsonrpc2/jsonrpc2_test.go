@@ -0,0 +1,84 @@
+package jsonrpc2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func frame(s string) string {
+	var b bytes.Buffer
+	var m Message
+	json.Unmarshal([]byte(s), &m)
+	WriteMessage(&b, &m)
+	return b.String()
+}
+
+func TestServe(t *testing.T) {
+	in := frame(`{"id": 1, "method": "add", "params": [1, 2]}`) +
+		frame(`{"method": "note", "params": "hi"}`) +
+		frame(`{"id": "x", "method": "fail"}`) +
+		frame(`{"id": 3, "method": "nope"}`) +
+		frame(`{"id": 4, "method": "add", "params": "bad"}`)
+	var out bytes.Buffer
+	c := NewConn(strings.NewReader(in), &out)
+	var noted string
+	c.Handle("add", func(ctx context.Context, c *Conn, params json.RawMessage) (interface{}, error) {
+		var args []int
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, InvalidParams(err)
+		}
+		c.Notify("progress", map[string]int{"n": len(args)})
+		return args[0] + args[1], nil
+	})
+	c.Handle("note", func(ctx context.Context, c *Conn, params json.RawMessage) (interface{}, error) {
+		json.Unmarshal(params, &noted)
+		return nil, nil
+	})
+	c.HandleAsync("fail", func(ctx context.Context, c *Conn, params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("oops")
+	})
+	if err := c.Serve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if noted != "hi" {
+		t.Errorf("notification is not handled: %q", noted)
+	}
+
+	r := bufio.NewReader(&out)
+	var got []string
+	for {
+		m, err := ReadMessage(r)
+		if err != nil {
+			break
+		}
+		data, _ := json.Marshal(m)
+		got = append(got, string(data))
+	}
+	expected := []string{
+		`{"jsonrpc":"2.0","method":"progress","params":{"n":2}}`,
+		`{"jsonrpc":"2.0","id":1,"result":3}`,
+		`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"method not found: nope"}}`,
+		`{"jsonrpc":"2.0","id":4,"error":{"code":-32602,"message":"json: cannot unmarshal string into Go value of type []int"}}`,
+		`{"jsonrpc":"2.0","id":"x","error":{"code":-32000,"message":"oops"}}`,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d messages, got %q", len(expected), got)
+	}
+	// The async response may come at any point
+	for _, e := range expected {
+		found := false
+		for _, g := range got {
+			if g == e {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected %s in %q", e, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/state"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// libsHandler implements "mos libs link <dir> [name]", "mos libs unlink
+// [name]" and "mos libs links": linked libs are used from the given local
+// checkouts, like given with --lib, until unlinked. Links are per project and
+// are kept in the mos state, so mos.yml stays intact. "mos libs outdated"
+// reports updates of the libs, and "mos libs fork" moves a lib to a fork.
+func libsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: link, unlink, links, outdated, fork")
+	}
+	switch args[0] {
+	case "link":
+		return errors.Trace(libsLink(args[1:]))
+	case "unlink":
+		return errors.Trace(libsUnlink(args[1:]))
+	case "links":
+		return errors.Trace(libsLinks())
+	case "outdated":
+		return errors.Trace(libsOutdated(args[1:]))
+	case "fork":
+		return errors.Trace(libsFork(args[1:]))
+	}
+	return errors.Errorf("unknown command %q, expected link, unlink, links, outdated or fork", args[0])
+}
+
+func libsLink(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.Errorf("usage: mos libs link <dir> [name]")
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return errors.Trace(err)
+	} else if !fi.IsDir() {
+		return errors.Errorf("%s is not a directory", dir)
+	}
+
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	} else if name, err = getLibNameFromDir(dir); err != nil {
+		return errors.Trace(err)
+	}
+
+	links, err := getLibLinks()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	links[name] = dir
+	if err := saveLibLinks(links); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Lib %q is linked to %s", name, dir)
+	return nil
+}
+
+func libsUnlink(args []string) error {
+	links, err := getLibLinks()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch len(args) {
+	case 0:
+		// Unlink all
+		for name := range links {
+			delete(links, name)
+			ourutil.Reportf("Lib %q is unlinked", name)
+		}
+	case 1:
+		if _, ok := links[args[0]]; !ok {
+			return errors.Errorf("lib %q is not linked", args[0])
+		}
+		delete(links, args[0])
+		ourutil.Reportf("Lib %q is unlinked", args[0])
+	default:
+		return errors.Errorf("usage: mos libs unlink [name]")
+	}
+	return errors.Trace(saveLibLinks(links))
+}
+
+func libsLinks() error {
+	links, err := getLibLinks()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names := []string{}
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tDIR\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, links[name])
+	}
+	return errors.Trace(w.Flush())
+}
+
+// getLibNameFromDir returns the lib name from the manifest in the dir or, if
+// there is no name there, the dir name.
+func getLibNameFromDir(dir string) (string, error) {
+	data, err := ioutil.ReadFile(moscommon.GetManifestFilePath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Errorf("%s is not a lib: no mos.yml", dir)
+		}
+		return "", errors.Trace(err)
+	}
+	var m build.FWAppManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return "", errors.Annotatef(err, "parsing manifest in %s", dir)
+	}
+	if m.Name != "" {
+		return m.Name, nil
+	}
+	return filepath.Base(dir), nil
+}
+
+// getLibLinks returns the libs of the project which are linked to local
+// checkouts, by name.
+func getLibLinks() (map[string]string, error) {
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	links := map[string]string{}
+	for name, dir := range state.GetState().LibLinks[appDir] {
+		links[name] = dir
+	}
+	return links, nil
+}
+
+func saveLibLinks(links map[string]string) error {
+	appDir, err := getCodeDirAbs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s := state.GetState()
+	if len(links) == 0 {
+		delete(s.LibLinks, appDir)
+	} else {
+		if s.LibLinks == nil {
+			s.LibLinks = map[string]map[string]string{}
+		}
+		s.LibLinks[appDir] = links
+	}
+	return errors.Trace(state.SaveState())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/build/libfork"
+	moscommon "cesanta.com/mos/common"
+	"github.com/cesanta/errors"
+)
+
+// libsFork implements "mos libs fork <name> <url> [dir]": mirrors the repo of
+// the lib, with all branches and tags, to the given (empty) repo, points the
+// lib in mos.yml to it, and clones it into the dir, by default next to the
+// project, with the original repo added as the "upstream" remote, for syncs.
+func libsFork(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.Errorf("usage: mos libs fork <name> <url> [dir]")
+	}
+	name, forkURL := args[0], args[1]
+
+	if t := (&build.SWModule{Location: forkURL}).GetType(); t != build.SWModuleTypeGit && t != build.SWModuleTypeGithub {
+		return errors.Errorf("%q is not recognized as a git repo, use a URL ending with .git", forkURL)
+	}
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	upstream := ""
+	for _, l := range manifest.Libs {
+		if n, err := l.GetName(); err != nil || n != name {
+			continue
+		}
+		upstream = l.Location
+		if upstream == "" {
+			upstream = l.OriginOld
+		}
+		if t := l.GetType(); t != build.SWModuleTypeGit && t != build.SWModuleTypeGithub {
+			return errors.Errorf("lib %q is not a git repo", name)
+		}
+		break
+	}
+	if upstream == "" {
+		return errors.Errorf("lib %q is not found in %s", name, manifestPath)
+	}
+
+	dir := ""
+	if len(args) > 2 {
+		dir = args[2]
+	} else {
+		appDir, err := filepath.Abs(projectDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dir = filepath.Join(filepath.Dir(appDir), name)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return errors.Errorf("%s already exists", dir)
+	}
+
+	// Mirroring deletes the refs of the target which are not in the upstream,
+	// so the target must be empty
+	refs, err := releaseGit("ls-remote", forkURL)
+	if err != nil {
+		return errors.Annotatef(err, "make sure %s exists", forkURL)
+	}
+	if refs != "" {
+		return errors.Errorf("%s is not empty, refusing to overwrite its branches and tags", forkURL)
+	}
+
+	mirrorDir, err := ioutil.TempDir("", "mos_fork_")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.RemoveAll(mirrorDir)
+	reportf("Mirroring %s to %s...", upstream, forkURL)
+	if _, err := releaseGit("clone", "--quiet", "--mirror", upstream, mirrorDir); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := releaseGit("-C", mirrorDir, "push", "--quiet", "--mirror", forkURL); err != nil {
+		return errors.Trace(err)
+	}
+
+	reportf("Cloning %s to %s...", forkURL, dir)
+	if _, err := releaseGit("clone", "--quiet", forkURL, dir); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := releaseGit("-C", dir, "remote", "add", "upstream", upstream); err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if data, err = libfork.SetLibLocation(data, upstream, forkURL); err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(manifestPath, data, 0666); err != nil {
+		return errors.Trace(err)
+	}
+	reportf("Lib %q now comes from %s. To sync with the upstream, run in %s:", name, forkURL, dir)
+	reportf("  git fetch upstream && git merge upstream/master && git push --follow-tags")
+	return nil
+}
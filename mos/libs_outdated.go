@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"cesanta.com/mos/build/libupdates"
+	moscommon "cesanta.com/mos/common"
+	"github.com/cesanta/errors"
+)
+
+// Max lines of the changelog excerpt of a lib update.
+const libChangelogLines = 15
+
+type libUpdate struct {
+	name, current, latest string
+	major                 bool
+	changelog             string
+}
+
+// libsOutdated implements "mos libs outdated": compares versions of the libs
+// used by the last local build with the latest release tags upstream, and
+// prints the updates with the changelog entries of the newer versions.
+func libsOutdated(args []string) error {
+	if len(args) != 0 {
+		return errors.Errorf("usage: mos libs outdated")
+	}
+	depsFile := moscommon.GetBuildDepsFilePath(moscommon.GetBuildDir(projectDir))
+	data, err := ioutil.ReadFile(depsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s does not exist, run \"mos build --local\" first", depsFile)
+		}
+		return errors.Trace(err)
+	}
+	var deps []moscommon.BuildDep
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return errors.Annotatef(err, "parsing %s", depsFile)
+	}
+
+	// Versions requested in mos.yml take precedence over the lib manifests
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	requested := map[string]string{}
+	for _, l := range manifest.Libs {
+		if name, err := l.GetName(); err == nil {
+			requested[name] = l.Version
+		}
+	}
+
+	var updates []libUpdate
+	for _, d := range deps {
+		if d.Kind != moscommon.BuildDepLib || d.Dir == "" {
+			continue
+		}
+		if _, err := os.Stat(d.Dir + "/.git"); err != nil {
+			// Local libs are not released
+			continue
+		}
+		u, err := getLibUpdate(d, requested[d.Name])
+		if err != nil {
+			reportf("%s: %s", d.Name, err)
+			continue
+		}
+		if u != nil {
+			updates = append(updates, *u)
+		}
+	}
+	if len(updates) == 0 {
+		reportf("All libs are up to date")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LIB\tCURRENT\tLATEST\tNOTE\n")
+	for _, u := range updates {
+		note := ""
+		if u.major {
+			note = "major, may break"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.name, u.current, u.latest, note)
+	}
+	w.Flush()
+	for _, u := range updates {
+		if u.changelog == "" {
+			continue
+		}
+		fmt.Printf("\n%s %s -> %s:\n%s\n", u.name, u.current, u.latest, u.changelog)
+	}
+	return nil
+}
+
+// getLibUpdate returns the update of the lib checked out in the dir, or nil
+// if it's up to date. The current version is the requested one if it's a
+// release, or the tag of the checkout, or the version in the lib manifest.
+func getLibUpdate(d moscommon.BuildDep, requested string) (*libUpdate, error) {
+	current := ""
+	switch {
+	case libupdates.IsVersion(requested):
+		current = requested
+	default:
+		if tag, err := releaseGit("-C", d.Dir, "describe", "--tags", "--exact-match", "HEAD"); err == nil && libupdates.IsVersion(tag) {
+			current = tag
+		} else if libupdates.IsVersion(d.Version) {
+			current = d.Version
+		}
+	}
+	if current == "" {
+		return nil, errors.Errorf("version %q is not a release, skipping", requested)
+	}
+
+	out, err := releaseGit("-C", d.Dir, "ls-remote", "--tags", "origin")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	latest := libupdates.Latest(libupdates.ReleaseTags(out))
+	if latest == "" || libupdates.Compare(latest, current) <= 0 {
+		return nil, nil
+	}
+
+	u := &libUpdate{
+		name: d.Name, current: current, latest: latest,
+		major: libupdates.IsMajorBump(current, latest),
+	}
+	// The changelog is best effort: the tag is fetched into the checkout, and
+	// the changelog is taken from there
+	ref := "refs/tags/" + latest
+	if _, err := releaseGit("-C", d.Dir, "fetch", "--quiet", "origin", ref+":"+ref); err == nil {
+		for _, name := range []string{"CHANGELOG.md", "CHANGES.md", "ChangeLog"} {
+			if cl, err := releaseGit("-C", d.Dir, "show", latest+":"+name); err == nil {
+				u.changelog = libupdates.ChangelogExcerpt(cl, current, latest, libChangelogLines)
+				break
+			}
+		}
+	}
+	return u, nil
+}
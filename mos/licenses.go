@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"context"
+
+	"cesanta.com/mos/build/licenses"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+)
+
+// getLibLicenses returns licenses of the libs among the build deps.
+func getLibLicenses(deps []moscommon.BuildDep) ([]licenses.Lib, error) {
+	var res []licenses.Lib
+	for _, d := range deps {
+		if d.Kind != moscommon.BuildDepLib {
+			continue
+		}
+		l := licenses.Lib{Name: d.Name, Declared: d.License}
+		if d.Dir != "" {
+			var err error
+			if l.File, l.Detected, err = licenses.DetectDir(d.Dir); err != nil {
+				return nil, errors.Annotatef(err, "lib %s", d.Name)
+			}
+		}
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+// checkLicenses fails the build if there is the allowlist in the app
+// manifest, and some libs have licenses which are not in it.
+func checkLicenses(allowed []string, deps []moscommon.BuildDep) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	libs, err := getLibLicenses(deps)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bad := licenses.Check(libs, allowed)
+	if len(bad) == 0 {
+		return nil
+	}
+	var msgs []string
+	for _, l := range bad {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", l.Name, l.License()))
+	}
+	return errors.Errorf(
+		"libs have licenses which are not in allowed_licenses of %s (%s); run \"mos licenses\" for details",
+		moscommon.GetManifestFilePath(""), strings.Join(msgs, ", "),
+	)
+}
+
+// licensesHandler implements "mos licenses": reports licenses of the libs
+// used by the last local build, and fails if some of them are not allowed
+// by allowed_licenses of the app manifest.
+func licensesHandler(ctx context.Context, devConn *dev.DevConn) error {
+	depsFile := moscommon.GetBuildDepsFilePath(moscommon.GetBuildDir(projectDir))
+	data, err := ioutil.ReadFile(depsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s does not exist, run \"mos build --local\" first", depsFile)
+		}
+		return errors.Trace(err)
+	}
+	var deps []moscommon.BuildDep
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return errors.Annotatef(err, "parsing %s", depsFile)
+	}
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	libs, err := getLibLicenses(deps)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bad := map[string]bool{}
+	for _, l := range licenses.Check(libs, manifest.AllowedLicenses) {
+		bad[l.Name] = true
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LIB\tLICENSE\tDECLARED\tFILE\tSTATUS\n")
+	for _, l := range libs {
+		status := "ok"
+		switch {
+		case len(manifest.AllowedLicenses) == 0:
+			status = "-"
+		case bad[l.Name] && l.License() == licenses.Unknown:
+			status = "unknown"
+		case bad[l.Name]:
+			status = "forbidden"
+		}
+		file := l.File
+		if file != "" && l.Detected != "" {
+			file += " (" + l.Detected + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.Name, l.License(), orDash(l.Declared), orDash(file), status)
+	}
+	w.Flush()
+
+	if len(manifest.AllowedLicenses) == 0 {
+		reportf("No allowed_licenses in %s, nothing is checked", manifestPath)
+		return nil
+	}
+	if len(bad) > 0 {
+		return errors.Errorf("%d libs have licenses which are not allowed", len(bad))
+	}
+	return nil
+}
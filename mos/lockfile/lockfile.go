@@ -0,0 +1,133 @@
+// Package lockfile implements mos.lock, the file which pins exact versions of
+// things used for the build, so that builds are reproducible.
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"cesanta.com/common/go/ourio"
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const header = "# Generated by mos, do not edit manually unless you know what you're doing.\n"
+
+// Lockfile is the contents of mos.lock.
+type Lockfile struct {
+	// Docker images used for the local build: image reference as given by the
+	// SDK (e.g. "docker.cesanta.com/esp32-build:3.0-r5") to the pinned reference
+	// with the digest (e.g. "docker.cesanta.com/esp32-build@sha256:...").
+	DockerImages map[string]string `yaml:"docker_images,omitempty"`
+	// Modules fetched from git repos or archives, by module name.
+	Modules map[string]ModulePin `yaml:"modules,omitempty"`
+	// Versions of the tools fetched for the build, like the TypeScript
+	// compiler, by name.
+	Tools map[string]string `yaml:"tools,omitempty"`
+}
+
+// ModulePin is the exact version of a module.
+type ModulePin struct {
+	Location string `yaml:"location"`
+	// Commit hash for git modules, archive digest for archive modules.
+	Version string `yaml:"version"`
+}
+
+// Load reads the lockfile; if the file does not exist, an empty lockfile is
+// returned.
+func Load(path string) (*Lockfile, error) {
+	lf := &Lockfile{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lf, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, lf); err != nil {
+		return nil, errors.Annotatef(err, "parsing %s", path)
+	}
+	return lf, nil
+}
+
+// Save writes the lockfile, if it has changed.
+func (lf *Lockfile) Save(path string) error {
+	data, err := yaml.Marshal(lf)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ourio.WriteFileIfDiffers(path, append([]byte(header), data...), 0644))
+}
+
+// PinImage records the pinned reference for the given image.
+func (lf *Lockfile) PinImage(image, pinned string) {
+	if lf.DockerImages == nil {
+		lf.DockerImages = map[string]string{}
+	}
+	lf.DockerImages[image] = pinned
+}
+
+// PinModule records the exact version of the module.
+func (lf *Lockfile) PinModule(name, location, version string) {
+	if lf.Modules == nil {
+		lf.Modules = map[string]ModulePin{}
+	}
+	lf.Modules[name] = ModulePin{Location: location, Version: version}
+}
+
+// PinTool records the exact version of the tool.
+func (lf *Lockfile) PinTool(name, version string) {
+	if lf.Tools == nil {
+		lf.Tools = map[string]string{}
+	}
+	lf.Tools[name] = version
+}
+
+// ModuleVersion returns the pinned version of the module, or an empty string
+// if it's not pinned or was pinned for a different location.
+func (lf *Lockfile) ModuleVersion(name, location string) string {
+	p, ok := lf.Modules[name]
+	if !ok || p.Location != location {
+		return ""
+	}
+	return p.Version
+}
+
+// PinnedImages returns all pinned image references, sorted.
+func (lf *Lockfile) PinnedImages() []string {
+	var ret []string
+	for _, v := range lf.DockerImages {
+		ret = append(ret, v)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ImageDigestRef returns the reference with the digest ("repo@sha256:...")
+// for the given image, given the list of its repo digests as reported by
+// "docker image inspect". Returns an empty string if there's no digest for
+// the image's repo, e.g. if the image was built locally and never pushed.
+func ImageDigestRef(image string, repoDigests []string) string {
+	repo := imageRepo(image)
+	for _, d := range repoDigests {
+		if i := strings.Index(d, "@"); i > 0 && d[:i] == repo {
+			return d
+		}
+	}
+	return ""
+}
+
+// imageRepo returns the image reference without the tag or digest.
+func imageRepo(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// Colon after the last slash separates the tag; the one before it can be
+	// a registry port.
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
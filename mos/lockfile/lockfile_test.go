@@ -0,0 +1,65 @@
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImageDigestRef(t *testing.T) {
+	digests := []string{
+		"other.com/esp32-build@sha256:1111",
+		"localhost:5000/esp32-build@sha256:2222",
+		"docker.cesanta.com/esp32-build@sha256:3333",
+	}
+	for image, exp := range map[string]string{
+		"docker.cesanta.com/esp32-build:3.0-r5": "docker.cesanta.com/esp32-build@sha256:3333",
+		"localhost:5000/esp32-build:1.0":        "localhost:5000/esp32-build@sha256:2222",
+		"localhost:5000/esp32-build":            "localhost:5000/esp32-build@sha256:2222",
+		"docker.cesanta.com/cc3200-build:1.0":   "",
+	} {
+		if got := ImageDigestRef(image, digests); got != exp {
+			t.Errorf("%q: expected %q, got %q", image, exp, got)
+		}
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mos.lock")
+
+	lf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lf.PinnedImages()) != 0 {
+		t.Errorf("expected an empty lockfile")
+	}
+
+	lf.PinImage("a/b:1", "a/b@sha256:1")
+	lf.PinModule("sdk", "https://example.com/sdk.tar.gz", "abcd")
+	lf.PinTool("typescript", "5.4.5")
+	if err := lf.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	lf2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lf, lf2) {
+		t.Errorf("expected %+v, got %+v", lf, lf2)
+	}
+	if v := lf2.ModuleVersion("sdk", "https://example.com/sdk.tar.gz"); v != "abcd" {
+		t.Errorf("expected pinned module version, got %q", v)
+	}
+	if v := lf2.ModuleVersion("sdk", "https://example.com/sdk2.tar.gz"); v != "" {
+		t.Errorf("expected no version for a different location, got %q", v)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/jsonrpc2"
+	"cesanta.com/mos/manifestlsp"
+	"github.com/cesanta/errors"
+)
+
+// lspHandler implements "mos lsp": a language server for mos.yml over stdin
+// and stdout, to be configured in any LSP capable editor.
+func lspHandler(ctx context.Context, devConn *dev.DevConn) error {
+	s := manifestlsp.NewServer(
+		manifestlsp.KeysFromStruct(build.FWAppManifest{}, manifestlsp.ManifestDocs),
+		manifestlsp.KeysFromStruct(build.SWModule{}, manifestlsp.LibDocs),
+		knownLibNames,
+	)
+	c := jsonrpc2.NewConn(os.Stdin, os.Stdout)
+	s.Register(c)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-s.Exited
+		cancel()
+	}()
+	return errors.Trace(c.Serve(ctx))
+}
+
+// Version and origin suffixes of the lib dirs, like "-1.2" or "-a1b2c3d4".
+var libDirSuffixRE = regexp.MustCompile(`-(v?\d[\w.]*|[0-9a-f]{8})$`)
+
+// knownLibNames returns names of the libs fetched before, to the deps dir of
+// the project in the current dir or to ~/.mos/libs.
+func knownLibNames() []string {
+	seen := map[string]bool{}
+	dirs := []string{paths.LibsDirOld}
+	if cwd, err := getCodeDirAbs(); err == nil {
+		dirs = append(dirs, getDepsDir(cwd))
+	}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, e.Name(), "mos.yml")); err != nil {
+				continue
+			}
+			name := e.Name()
+			for libDirSuffixRE.MatchString(name) {
+				name = libDirSuffixRE.ReplaceAllString(name, "")
+			}
+			seen[name] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -16,10 +16,13 @@ import (
 	"context"
 
 	"cesanta.com/common/go/pflagenv"
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/ci"
 	moscommon "cesanta.com/mos/common"
 	"cesanta.com/mos/common/paths"
 	"cesanta.com/mos/common/state"
 	"cesanta.com/mos/dev"
+	"cesanta.com/mos/errcode"
 	"cesanta.com/mos/update"
 	"cesanta.com/mos/version"
 	"github.com/cesanta/errors"
@@ -48,8 +51,13 @@ var (
 	devicePass = flag.String("device-pass", "", "Device pass/key")
 	dryRun     = flag.Bool("dry-run", true, "Do not apply changes, print what would be done")
 	firmware   = flag.String("firmware", moscommon.GetFirmwareZipFilePath(moscommon.GetBuildDir("")), "Firmware .zip file location (file of HTTP URL)")
-	portFlag   = flag.String("port", "auto", "Serial port where the device is connected. "+
-		"If set to 'auto', ports on the system will be enumerated and the first will be used.")
+	portFlag   = repeatedStringFlag("port", "auto", &allPorts, "Serial port where the device is connected. "+
+		"If set to 'auto', ports on the system will be enumerated and the first will be used. "+
+		"Devices on the network are not discovered, their address has to be given, "+
+		"e.g. ws://[fe80::1%en0]/rpc or ws://name.local/rpc (resolved via mDNS over IPv4 and IPv6). "+
+		"mos console accepts several ports.")
+	// All --port values, for commands which talk to several devices
+	allPorts  []string
 	timeout   = flag.Duration("timeout", 10*time.Second, "Timeout for the device connection and call operation")
 	reconnect = flag.Bool("reconnect", false, "Enable reconnection")
 	force     = flag.Bool("force", false, "Use the force")
@@ -59,6 +67,7 @@ var (
 
 	versionFlag = flag.Bool("version", false, "Print version and exit")
 	helpFull    = flag.Bool("helpfull", false, "Show full help, including advanced flags")
+	errorFormat = flag.String("error-format", "text", `Error output format: "text", or "json" for a machine-readable error code and exit status`)
 
 	extendedMode = false
 	isUI         = false
@@ -95,6 +104,33 @@ type command struct {
 
 type handler func(ctx context.Context, devConn *dev.DevConn) error
 
+// Commands which work on the project in the current dir, and so have to be
+// run by the mos version it requires.
+var projectCommands = map[string]bool{
+	"build": true, "clean": true, "libs": true, "gen": true, "release": true,
+	"export": true, "bundle": true, "toolchain": true, "fmt": true, "analyze": true,
+	"test": true, "fuzz": true, "run": true, "licenses": true, "eval-manifest-expr": true,
+}
+
+// readProjectManifestIfAny returns the manifest of the project in the
+// current dir, or nil if it's not a project or the manifest can't be parsed.
+func readProjectManifestIfAny() *build.FWAppManifest {
+	if _, err := os.Stat(moscommon.GetManifestFilePath(projectDir)); err != nil {
+		return nil
+	}
+	manifest, err := readProjectManifest()
+	if err != nil {
+		glog.Infof("failed to parse the manifest: %s", err)
+		return nil
+	}
+	return manifest
+}
+
+// updateHandler implements "mos update".
+func updateHandler(ctx context.Context, devConn *dev.DevConn) error {
+	return errors.Trace(update.Update(ctx, readProjectManifestIfAny()))
+}
+
 // channel of "junk" messages, which go to the console
 var consoleMsgs chan []byte
 
@@ -107,21 +143,67 @@ func init() {
 	commands = []command{
 		{"ui", startUI, `Start GUI`, nil, nil, false},
 		{"init", initFW, `Initialise firmware directory structure in the current directory`, nil, []string{"arch", "platform", "force"}, false},
-		{"build", buildHandler, `Build a firmware from the sources located in the current directory`, nil, []string{"arch", "platform", "local", "repo", "clean", "server"}, false},
-		{"flash", flash, `Flash firmware to the device`, nil, []string{"port", "firmware"}, false},
+		{"build", buildHandler, `Build a firmware from the sources located in the current directory`, nil, []string{"arch", "platform", "board", "local", "repo", "clean", "server", "require-clean-libs", "print-vars", "copy-libs", "show-context", "timings", "provenance-key", "build-info", "compile-commands", "clangd", "fmt-check", "sanitize", "age-key", "rust-image", "ts-image"}, false},
+		{"build-timings", buildTimingsHandler, `Show the history and the trend of local build timings of this project, recorded by "mos build --timings"`, nil, []string{"timings-window", "timings-history"}, false},
+		{"flash", flash, `Flash firmware to the device`, nil, []string{"port", "firmware", "board", "yes-i-mean-it", "policy", "verify-boot", "boot-loop-count", "rollback-fw", "json", "skip-smoke-test", "check-wait", "check-attempts"}, false},
+		{"ota", otaHandler, `Update the firmware over the air: "mos ota [fw.zip]" sends it to the device, "mos ota publish [fw.zip] --to s3://bucket/path|gs://bucket/path" uploads it and makes the device download it; with --attest, the device identity and firmware are checked against the device registry first`, nil, []string{"port", "firmware", "attest", "attest-enroll", "attest-pubkey", "to", "url-ttl", "gcs-key-file", "no-update", "aws-region", "yes-i-mean-it", "policy"}, false},
+		{"settings", settingsHandler, `Project settings in .mos/settings.yml, used for the flags not given explicitly: "mos settings" shows them, "mos settings set <key> <value>", "mos settings unset <key>", --global for the user settings in ~/.mos/settings.yml; keys are port, board, build (local or remote) and flags.<name>`, nil, []string{"global"}, false},
+		{"config", configHandler, `Flag configuration: "mos config doctor [flag ...]" prints the effective values of the flags and their sources; flags can also be given as MOS_<FLAG> environment variables and in the project and user settings (see mos settings), in that order of precedence`, nil, []string{"all"}, false},
+		{"boards", boardsHandler, `List board profiles, or show the given one`, nil, nil, false},
+		{"clean", cleanHandler, `Remove build artifacts; with --deps, also the deps dir; with --global-cache, prune the shared lib cache`, nil, []string{"deps", "global-cache", "all", "cache-max-age", "cache-max-size", "dry-run"}, false},
+		{"bundle", bundleHandler, `Export the project with all its deps for building offline, or import it: "mos bundle export [file]", "mos bundle import <file> [dir]"`, nil, []string{"with-images"}, false},
+		{"libs", libsHandler, `Link libs to local checkouts for the builds of this project: "mos libs link <dir> [name]", "mos libs unlink [name]", "mos libs links", "mos libs outdated", "mos libs fork <name> <url> [dir]"`, nil, nil, false},
 		{"flash-read", flashRead, `Read a region of flash`, []string{"platform"}, []string{"port"}, false},
-		{"console", console, `Simple serial port console`, nil, []string{"port"}, false}, //TODO: needDevConn
-		{"ls", fsLs, `List files at the local device's filesystem`, nil, []string{"port"}, true},
+		{"wipe", wipe, `Erase config, filesystem, OTA slots or the entire flash`, nil, []string{"port", "platform", "force", "yes-i-mean-it", "policy"}, false},
+		{"baud-rate", baudRateHandler, `Detect the device baud rate, or switch the device to the given one`, nil, []string{"port", "baud-rate"}, false},
+		{"console", console, `Simple serial port console; with several --port or --all, consoles of all the devices are interleaved`, nil, []string{"port", "all", "show", "console-encoding", "console-hexdump"}, false}, //TODO: needDevConn
+		{"serial", serialHandler, `Raw serial terminal for devices not running mongoose-os, like vendor bootloaders: keys are sent as typed, Ctrl-] exits, Ctrl-T f sends --send-file, Ctrl-T e toggles local echo`, nil, []string{"port", "baud-rate", "echo", "eol", "send-file", "send-delay", "no-input"}, false},
+		{"mqtt", mqttHandler, `MQTT tools: "mos mqtt sniff" shows the messages at the broker the device uses, or, with --mqtt-listen, runs a local broker and shows all traffic of its clients`, nil, []string{"port", "mqtt-broker", "mqtt-listen", "topic", "raw-payload", "cert-file", "key-file", "ca-cert-file"}, false},
+		{"broker", brokerHandler, `Run a local MQTT broker for development and print the device config to use it`, nil, []string{"broker-listen", "broker-tls", "broker-cert", "broker-key", "verbose"}, false},
+		{"ls", fsLs, `List files at the local device's filesystem; paths may be mount-qualified, like sd:logs`, nil, []string{"port"}, true},
 		{"get", fsGet, `Read file from the local device's filesystem and print to stdout`, nil, []string{"port"}, true},
-		{"put", fsPut, `Put file from the host machine to the local device's filesystem`, nil, []string{"port"}, true},
+		{"put", fsPut, `Put file from the host machine to the local device's filesystem; files on SD cards are sent in bigger chunks`, nil, []string{"port", "fs-chunk-size", "fs-verify"}, true},
 		{"rm", fsRm, `Delete a file from the device's filesystem`, nil, []string{"port"}, true},
+		{"fs", fsHandler, `Device filesystem tools: "mos fs usage [path]" shows file sizes and free space, "mos fs mounts" lists mounted filesystems, "mos fs tail <file> [--follow]" streams data appended to a file, "mos fs image <dir> <out.img>" builds a FAT image for SD cards`, nil, []string{"port", "sort", "json", "follow", "tail-bytes", "tail-interval", "image-size", "image-label"}, false},
 		{"config-get", configGet, `Get config value from the locally attached device`, nil, []string{"port"}, true},
-		{"config-set", configSet, `Set config value at the locally attached device`, nil, []string{"port"}, true},
+		{"config-set", configSet, `Set config value at the locally attached device; with --confirm-timeout, the change is reverted unless the device stays reachable`, nil, []string{"port", "confirm-timeout", "confirm-port", "check-method", "check-args", "check-expect", "age-key"}, true},
+		{"config-rollout", configRollout, `Set config values on a fleet of devices, canaries first, verifying health and reverting on failure`, nil, []string{"devices", "select", "canary", "check-method", "check-args", "check-expect", "check-wait", "check-attempts", "yes-i-mean-it", "policy", "age-key"}, false},
 		{"call", call, `Perform a device API call. "mos call RPC.List" shows available methods`, nil, []string{"port"}, true},
+		{"http", httpHandler, `Send a request to the device web server and print the response: "mos http get|post|put|delete <path> [body|@file]"`, nil, []string{"port", "http-host", "https", "http-creds", "http-user", "http-header", "verbose"}, false},
 		{"aws-iot-setup", awsIoTSetup, `Provision the device for AWS IoT cloud`, nil, []string{"atca-slot", "aws-region", "port", "use-atca"}, true},
 		{"gcp-iot-setup", gcpIoTSetup, `Provision the device for Google IoT Core`, nil, []string{"atca-slot", "gcp-region", "port", "use-atca", "registry"}, true},
-		{"update", update.Update, `Self-update mos tool; optionally update channel can be given (e.g. "latest", "release", or some exact version)`, nil, nil, false},
+		{"update", updateHandler, `Self-update mos tool; optionally update channel can be given (e.g. "latest", "release", or some exact version)`, nil, nil, false},
 		{"wifi", wifi, `Setup WiFi - shortcut to config-set wifi...`, nil, nil, true},
+		{"capture", captureHandler, `Capture GPIO levels like a logic analyzer: "mos capture gpio --pins 4,5 [file.vcd|file.csv]"; needs device support`, nil, []string{"pins", "rate", "duration", "port"}, true},
+		{"cron", cronHandler, `Manage device cron jobs: "mos cron list", "mos cron add <expr> <action> [payload]", "mos cron remove <id>"`, nil, []string{"port"}, true},
+		{"time", timeHandler, `Device time: "mos time get" shows drift, "mos time set [time]" sets it from the host clock, "mos time sync" sets it from NTP`, nil, []string{"ntp-server", "configure-sntp", "port"}, true},
+		{"tz", tzHandler, `Device time zone: "mos tz set Europe/Berlin" sets the POSIX TZ string of the zone from the host tzdata, "mos tz get" shows it, "mos tz spec <zone>" prints it`, nil, []string{"port"}, false},
+		{"toolchain", toolchainHandler, `Toolchain management: "mos toolchain pull" prefetches build images pinned in mos.lock`, nil, nil, false},
+		{"doctor", doctorHandler, `Check the environment: the container runtime for local builds, serial ports and access to them, reachability of the build server and GitHub, free disk space; problems are printed with how to fix them`, nil, []string{"doctor-min-free", "doctor-warn-free", "server", "proxy"}, false},
+		{"setup-usb", setupUSBHandler, `Set up access to USB-UART and JTAG adapters on Linux: installs udev rules, adds the user to the dialout group using sudo, and checks access to the serial ports`, nil, []string{"usb-group", "force"}, false},
+		{"report", reportHandler, `Save diagnostic info for a bug report to a zip file, with secrets redacted; device info is included if --port is given`, nil, []string{"port"}, false},
+		{"gen", genHandler, `Code generation: "mos gen config" generates mgos_config.h/c and default config from the config schema`, nil, []string{"platform", "gen-config-dir"}, false},
+		{"js", jsHandler, `mJS tools: "mos js check [file ...]" checks JS files syntax, "mos js eval <code>" evaluates code on the device`, nil, []string{"port"}, false},
+		{"release", releaseHandler, `Release the app: "mos release [major|minor|patch|<version>]" bumps the version, tags the repo, builds for the platforms from mos.yml, scans the firmware for leaked secrets, signs the artifacts and uploads them to GitHub Releases or S3`, nil, []string{"platform", "local", "release-sign-key", "no-upload", "no-scan", "scan-allow"}, false},
+		{"version", versionHandler, `App version: "mos version" prints the version from mos.yml, "mos version bump [major|minor|patch|<version>]" updates it, adds the commits since the last tag to CHANGELOG.md, commits and tags the repo`, nil, []string{"changelog", "no-tag"}, false},
+		{"export", exportHandler, `Export the project for other tools: "mos export --format platformio [dir]" writes platformio.ini with the build flags and libs from mos.yml, and copies the sources next to it (build/platformio by default)`, nil, []string{"format", "platform", "pio-board", "force"}, false},
+		{"ide", ideHandler, `Serve a JSON-RPC API for IDE extensions: "mos ide serve" exposes build, flash, console streaming, the device list and the config schema over stdio, or TCP with --ide-addr`, nil, []string{"ide-addr"}, false},
+		{"lsp", lspHandler, `Serve the Language Server Protocol over stdio: completion, hover docs and diagnostics for mos.yml in any editor`, nil, nil, false},
+		{"fmt", fmtHandler, `Format C/C++ sources of the app with clang-format of the toolchain container, in the mongoose-os style unless there is a .clang-format`, nil, []string{"fmt-check", "fmt-image"}, false},
+		{"analyze", analyzeHandler, `Run cppcheck or clang-tidy from the build container over the app sources with the compile flags of the last local build`, nil, []string{"analyzer", "analyze-lib", "analyze-image", "fail-on"}, false},
+		{"test", testHandler, `Build and run unit tests of the app or lib, listed in "tests" of mos.yml, on the host: "mos test --host [filter]"`, nil, []string{"host", "test-image", "coverage", "coverage-threshold", "verbose"}, false},
+		{"fuzz", fuzzHandler, `Fuzz functions of the app or lib on the host with libFuzzer or AFL++: "mos fuzz init <name> [function] | list | run <name> | repro <name> <input>"`, nil, []string{"fuzz-engine", "fuzz-time", "fuzz-image", "verbose"}, false},
+		{"run", runHandler, `Build the app for the POSIX platform and run it on the host: "mos run --sim [--sanitize] [args...]"`, nil, []string{"sim", "sanitize", "repo", "clean"}, false},
+		{"licenses", licensesHandler, `Report licenses of the libs of the last local build, and check them against allowed_licenses of mos.yml`, nil, nil, false},
+		{"simdevice", simDeviceHandler, `Run a simulated device serving Sys, Config, FS and OTA RPCs over ws:// and http://, with optional fault injection`, nil, []string{"sim-addr", "sim-id", "sim-fs-dir", "sim-latency", "sim-error-rate", "sim-drop-rate", "sim-fail-methods"}, false},
+		{"agent", agentHandler, `Serve devices attached to this machine to remote clients using --port farm://host/device-id`, nil, []string{"agent-addr", "agent-token", "agent-devices", "agent-users", "agent-log", "agent-tls-cert", "agent-tls-key", "select", "baud-rate"}, false},
+		{"farm", farmHandler, `Device farm: "mos farm list [farm://host]", "mos farm lock|unlock [farm://host/device-id]"`, nil, []string{"port", "farm-user", "lock-ttl", "force"}, false},
+		{"auth", authHandler, `Save RPC credentials of a device, so that they don't have to be given with --rpc-creds: "mos auth login <device>" checks and saves them, "mos auth logout <device>" removes them`, nil, []string{"rpc-creds", "creds-store"}, false},
+		{"history", historyHandler, `Show the log of mos invocations: "mos history [device]" lists the latest commands run, against which device, with the result and duration`, nil, []string{"history-command", "since", "failed", "limit", "json", "history-file"}, false},
+		{"devices", devicesHandler, `Manage the local registry of devices: "mos devices list [--select expr]", "mos devices add <name> <port> [--tags t1,t2]", "mos devices remove <name>", "mos devices tag|untag <name> <tag>...", "mos devices sync --from aws-iot|azure|gcp"`, nil, []string{"select", "tags", "from", "aws-region", "azure-iot-hub", "gcp-project", "gcp-region", "gcp-registry"}, false},
+		{"fw", fwHandler, `Firmware tools: "mos fw export [--format uf2|hex|merged-bin] <file>" converts the fw zip into a single file, "mos fw scan [fw.zip]" looks for leaked keys, passwords and debug URLs, "mos fw diff a.zip b.zip" compares parts, symbol sizes, lib versions and config defaults, "mos fw verify-provenance [fw.zip]" checks the signed build provenance, "mos fw info [fw.zip|device]" shows the firmware metadata and custom build info`, nil, []string{"firmware", "format", "base-addr", "uf2-family", "scan-allow", "scan-secrets-from", "diff-elf", "diff-symbols", "provenance-pubkey", "provenance", "port"}, false},
+		{"nvs", nvsHandler, `ESP32 NVS partitions: "mos nvs gen <in.csv> <out.bin>", "mos nvs dump <nvs.bin>", "mos nvs set <nvs.bin> <ns> <key> <type> <value>", "mos nvs rm <nvs.bin> <ns> <key>"`, nil, []string{"nvs-size"}, false},
+		{"replay", replayHandler, `Re-run a session recorded with --record against mocked responses, or with --live against the device`, nil, []string{"port", "live"}, false},
 	}
 }
 
@@ -129,7 +211,7 @@ func run(c *command, ctx context.Context, devConn *dev.DevConn) error {
 	if c != nil {
 		// check required flags
 		if err := checkFlags(c.required); err != nil {
-			return errors.Trace(err)
+			return errors.Trace(errcode.Wrap(errcode.UsageError, err))
 		}
 
 		// run the handler
@@ -185,7 +267,28 @@ func main() {
 	osSpecificInit()
 
 	goflag.CommandLine.Parse([]string{}) // Workaround for noise in golang/glog
+	recordFlagSources(func(f *flag.Flag) string { return sourceCommandLine })
 	pflagenv.Parse(envPrefix)
+	recordFlagSources(func(f *flag.Flag) string {
+		return fmt.Sprintf("%s (%s)", sourceEnv, envVarName(f.Name))
+	})
+
+	// Flags not given explicitly are taken from the project and user
+	// settings, except for "mos settings" itself, so that broken settings
+	// can be fixed
+	if flag.Arg(0) != "settings" {
+		if err := applySettings(); err != nil {
+			exitWithError(errcode.Wrap(errcode.UsageError, err))
+		}
+	}
+
+	if *ciMode {
+		ci.Init()
+	}
+
+	if err := setupProxy(); err != nil {
+		log.Fatal(err)
+	}
 
 	if err := paths.Init(); err != nil {
 		log.Fatal(err)
@@ -206,6 +309,9 @@ func main() {
 	consoleInit()
 
 	if len(flag.Args()) == 0 || flag.Arg(0) == "ui" {
+		if ci.Enabled() {
+			exitWithError(errcode.Errorf(errcode.UsageError, "no command given (the UI is not available in CI mode)"))
+		}
 		isUI = true
 	}
 
@@ -228,19 +334,55 @@ func main() {
 	if !isUI {
 		cmd = getCommand(flag.Arg(0))
 	}
+	invocationCmd = cmd
+
+	// Board profile from mos.yml is only looked at by commands working on the project
+	if cmd != nil {
+		if err := applyBoard(cmd.name == "build" || cmd.name == "flash"); err != nil {
+			exitWithError(err)
+		}
+		recordFlagSources(func(f *flag.Flag) string { return "board profile" })
+	}
+
+	// Make sure the project is handled by the mos version it requires
+	if cmd != nil && projectCommands[cmd.name] {
+		if err := update.EnforceProjectMosVersion(projectDir, readProjectManifestIfAny(), os.Args[1:]); err != nil {
+			exitWithError(err)
+		}
+	}
+	// Before the connection is created, so that it's recorded too
+	startRecording()
 	if cmd != nil && cmd.needDevConn {
 		var err error
 		devConn, err = createDevConn(ctx)
 		if err != nil {
-			fmt.Println(errors.Trace(err))
-			return
+			exitWithError(err)
 		}
 	}
 
-	if err := run(cmd, ctx, devConn); err != nil {
+	err := run(cmd, ctx, devConn)
+	if err != nil {
 		glog.Infof("Error: %+v", errors.ErrorStack(err))
+		exitWithError(err)
+	}
+	saveRecording()
+	recordInvocation(nil)
+}
+
+// exitWithError prints the error, in JSON if --error-format=json, and exits
+// with the status corresponding to the error code (see the errcode package).
+func exitWithError(err error) {
+	// The session is saved even if it failed, which is when it's most useful
+	saveRecording()
+	recordInvocation(err)
+	if *errorFormat == "json" {
+		fmt.Fprintf(os.Stderr, "%s\n", errcode.JSON(err))
+	} else {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-		glog.Flush()
-		os.Exit(1)
 	}
+	if ci.Enabled() {
+		ci.Error(os.Stdout, err.Error())
+	}
+	glog.Flush()
+	os.Exit(errcode.ExitStatus(err))
 }
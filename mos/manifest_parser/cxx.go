@@ -0,0 +1,59 @@
+package manifest_parser
+
+import (
+	"strings"
+
+	"cesanta.com/mos/build"
+	"github.com/cesanta/errors"
+)
+
+// C++ standards, oldest first
+var cxxStds = []string{"98", "03", "11", "14", "17", "20", "23"}
+
+// cxxStdIndex returns the index of the standard, like "c++17" or "gnu++17",
+// in cxxStds.
+func cxxStdIndex(std string) (int, error) {
+	year := strings.TrimPrefix(strings.TrimPrefix(std, "gnu++"), "c++")
+	for i, s := range cxxStds {
+		if year == s && year != std {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("unknown C++ standard %q, expected one like c++17 or gnu++17", std)
+}
+
+// mergeCXXOpts returns C++ options satisfying both: the newer standard, GNU
+// one on a tie if any is, and features enabled if any of the options enables
+// them.
+func mergeCXXOpts(o1, o2 *build.CXXOpts) (*build.CXXOpts, error) {
+	if o1 == nil && o2 == nil {
+		return nil, nil
+	}
+	res := &build.CXXOpts{}
+	for _, o := range []*build.CXXOpts{o1, o2} {
+		if o == nil {
+			continue
+		}
+		if o.Std != "" {
+			i, err := cxxStdIndex(o.Std)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cxx.std")
+			}
+			if res.Std == "" {
+				res.Std = o.Std
+			} else if resIdx, _ := cxxStdIndex(res.Std); i > resIdx || (i == resIdx && strings.HasPrefix(o.Std, "gnu")) {
+				res.Std = o.Std
+			}
+		}
+		res.Exceptions = mergeCXXFeature(res.Exceptions, o.Exceptions)
+		res.RTTI = mergeCXXFeature(res.RTTI, o.RTTI)
+	}
+	return res, nil
+}
+
+func mergeCXXFeature(f1, f2 *bool) *bool {
+	if f1 == nil || (f2 != nil && *f2) {
+		return f2
+	}
+	return f1
+}
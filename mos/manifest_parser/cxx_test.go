@@ -0,0 +1,45 @@
+package manifest_parser
+
+import (
+	"reflect"
+	"testing"
+
+	"cesanta.com/common/go/lptr"
+	"cesanta.com/mos/build"
+)
+
+func TestMergeCXXOpts(t *testing.T) {
+	for i, c := range []struct {
+		o1, o2, exp *build.CXXOpts
+	}{
+		{nil, nil, nil},
+		{&build.CXXOpts{Std: "c++11"}, nil, &build.CXXOpts{Std: "c++11"}},
+		{
+			&build.CXXOpts{Std: "c++17", Exceptions: lptr.Bool(true)},
+			&build.CXXOpts{Std: "c++14", Exceptions: lptr.Bool(false), RTTI: lptr.Bool(false)},
+			&build.CXXOpts{Std: "c++17", Exceptions: lptr.Bool(true), RTTI: lptr.Bool(false)},
+		},
+		{
+			&build.CXXOpts{Std: "gnu++11", RTTI: lptr.Bool(false)},
+			&build.CXXOpts{Std: "c++20", RTTI: lptr.Bool(true)},
+			&build.CXXOpts{Std: "c++20", RTTI: lptr.Bool(true)},
+		},
+		{
+			&build.CXXOpts{Std: "c++17"},
+			&build.CXXOpts{Std: "gnu++17"},
+			&build.CXXOpts{Std: "gnu++17"},
+		},
+	} {
+		res, err := mergeCXXOpts(c.o1, c.o2)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(res, c.exp) {
+			t.Errorf("%d: expected %+v, got %+v", i, c.exp, res)
+		}
+	}
+
+	if _, err := mergeCXXOpts(&build.CXXOpts{Std: "17"}, nil); err == nil {
+		t.Errorf("expected an error for the bad standard")
+	}
+}
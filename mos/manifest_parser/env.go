@@ -0,0 +1,113 @@
+package manifest_parser
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// envRegexp matches references to the environment variables:
+//
+//	${env.NAME}             the value, empty if the variable is not set
+//	${env.NAME:-default}    the default if the variable is not set or empty
+//	${env.NAME:?message}    an error with the message if it's not set or empty
+var envRegexp = regexp.MustCompile(`\$\{env\.([A-Za-z_][A-Za-z0-9_]*)(?:(:-|:\?)([^}]*))?\}`)
+
+// expandEnv replaces the references to the environment variables in the
+// string values of the parsed manifest, so that e.g. lib versions or build
+// vars can be given by CI. It's only applied to the app manifest (with its
+// includes and the arch-specific manifest), never to the libs, so that a
+// third-party lib can't read the environment of the build; and since it works
+// on the parsed values, the values of the variables can't change the
+// structure of the manifest.
+func expandEnv(v interface{}, fname string, lookup func(name string) (string, bool)) error {
+	return errors.Trace(expandEnvValue(reflect.ValueOf(v), fname, lookup))
+}
+
+func expandEnvValue(v reflect.Value, fname string, lookup func(name string) (string, bool)) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		s, err := expandEnvString(v.String(), fname, lookup)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return expandEnvValue(v.Elem(), fname, lookup)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// Values inside an interface are not addressable, so expand a copy and
+		// put it back.
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		if err := expandEnvValue(e, fname, lookup); err != nil {
+			return errors.Trace(err)
+		}
+		if v.CanSet() {
+			v.Set(e)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// Unexported field.
+				continue
+			}
+			if err := expandEnvValue(v.Field(i), fname, lookup); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandEnvValue(v.Index(i), fname, lookup); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			if err := expandEnvValue(e, fname, lookup); err != nil {
+				return errors.Trace(err)
+			}
+			v.SetMapIndex(k, e)
+		}
+	}
+	return nil
+}
+
+func expandEnvString(s, fname string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${env.") {
+		return s, nil
+	}
+	var err error
+	res := envRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRegexp.FindStringSubmatch(ref)
+		val, _ := lookup(m[1])
+		if val != "" {
+			return val
+		}
+		switch m[2] {
+		case ":-":
+			return m[3]
+		case ":?":
+			msg := m[3]
+			if msg == "" {
+				msg = "not set"
+			}
+			if err == nil {
+				err = errors.Errorf("%s: environment variable %s is required: %s", fname, m[1], msg)
+			}
+		}
+		return ""
+	})
+	return res, err
+}
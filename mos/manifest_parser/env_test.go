@@ -0,0 +1,53 @@
+package manifest_parser
+
+import (
+	"reflect"
+	"testing"
+
+	"cesanta.com/mos/build"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"LIB_VERSION": "1.2.3", "EMPTY": "", "INJECT": "x\nplatform: esp8266"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	m := &build.FWAppManifest{
+		Libs: []build.SWModule{{
+			Location: "${env.LIBS_ORIGIN:-https://github.com/mongoose-os-libs}/wifi",
+			Version:  "${env.LIB_VERSION}",
+		}},
+		BuildVars: map[string]string{
+			"FOO": "${env.EMPTY:-default}",
+			"BAR": "${env.UNSET}",
+			"MOS": "${mos.version}",
+			"INJ": "${env.INJECT}",
+		},
+		ConfigSchema: []build.ConfigSchemaItem{{"foo.bar", "s", "${env.LIB_VERSION}", map[string]interface{}{}}},
+	}
+	if err := expandEnv(m, "mos.yml", lookup); err != nil {
+		t.Fatal(err)
+	}
+	if m.Libs[0].Location != "https://github.com/mongoose-os-libs/wifi" || m.Libs[0].Version != "1.2.3" {
+		t.Errorf("unexpected libs: %+v", m.Libs)
+	}
+	exp := map[string]string{"FOO": "default", "BAR": "", "MOS": "${mos.version}", "INJ": "x\nplatform: esp8266"}
+	if !reflect.DeepEqual(m.BuildVars, exp) {
+		t.Errorf("unexpected build vars: %v", m.BuildVars)
+	}
+	if m.Platform != "" {
+		t.Errorf("unexpected platform %q", m.Platform)
+	}
+	if m.ConfigSchema[0][2] != "1.2.3" {
+		t.Errorf("unexpected config schema: %v", m.ConfigSchema)
+	}
+
+	err := expandEnv(&build.FWAppManifest{
+		AppManifest: build.AppManifest{Version: "${env.EMPTY:?set the app version}"},
+	}, "mos.yml", lookup)
+	if err == nil || err.Error() != "mos.yml: environment variable EMPTY is required: set the app version" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
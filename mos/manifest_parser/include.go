@@ -0,0 +1,115 @@
+package manifest_parser
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Max nesting of includes, to catch the cycles
+const maxIncludeDepth = 16
+
+// includeRegexp matches the "!include <file>" lines, which can be:
+//
+//	!include common.yml            at any level, the file contents are put in
+//	                               place of the line
+//	key: !include file.yml         the file contents become the value of key
+//	- !include file.yml            the file contents become an item of the
+//	                               list; if the file is a list itself, its
+//	                               items are spliced into the list
+var includeRegexp = regexp.MustCompile(
+	`^([ ]*)(-[ ]+)?([^\s#'"!-][^:#]*:[ ]+)?!include[ ]+("[^"]+"|'[^']+'|[^\s#]+)[ ]*(?:#.*)?$`)
+
+// expandIncludes returns the manifest data with the "!include" lines replaced
+// with the contents of the files they refer to, relative to the dir of the
+// including file. Since includes are expanded textually, before the YAML is
+// parsed, anchors defined in included files can be referred to after the
+// include. Returns the names of all the included files.
+func expandIncludes(data []byte, fname string) ([]byte, []string, error) {
+	return expandIncludesDepth(data, fname, 0)
+}
+
+func expandIncludesDepth(data []byte, fname string, depth int) ([]byte, []string, error) {
+	if !bytes.Contains(data, []byte("!include")) {
+		return data, nil, nil
+	}
+	if depth >= maxIncludeDepth {
+		return nil, nil, errors.Errorf("%s: includes are nested too deep, is there a cycle?", fname)
+	}
+
+	var res bytes.Buffer
+	var included []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		m := includeRegexp.FindStringSubmatch(line)
+		if m == nil {
+			res.WriteString(line)
+			res.WriteByte('\n')
+			continue
+		}
+		indent, dash, key := m[1], m[2], strings.TrimSpace(m[3])
+		incName := strings.Trim(m[4], `"'`)
+		if !filepath.IsAbs(incName) {
+			incName = filepath.Join(filepath.Dir(fname), incName)
+		}
+		incData, err := ioutil.ReadFile(incName)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "%s:%d: include", fname, lineNo)
+		}
+		incData, incIncluded, err := expandIncludesDepth(incData, incName, depth+1)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		included = append(append(included, incName), incIncluded...)
+
+		incLines := strings.Split(strings.TrimRight(string(incData), "\n"), "\n")
+		switch {
+		case key != "":
+			res.WriteString(indent + dash + key + "\n")
+			writeIndented(&res, incLines, strings.Repeat(" ", len(indent)+len(dash)+2), "")
+		case dash != "" && !isYAMLList(incLines):
+			writeIndented(&res, incLines, indent+"  ", indent+dash)
+		default:
+			writeIndented(&res, incLines, indent, "")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return res.Bytes(), included, nil
+}
+
+// writeIndented writes the lines with the given indent, the first non-empty
+// one with the first indent if given.
+func writeIndented(w *bytes.Buffer, lines []string, indent, firstIndent string) {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "---") {
+			w.WriteByte('\n')
+			continue
+		}
+		if firstIndent != "" && !strings.HasPrefix(strings.TrimSpace(l), "#") {
+			w.WriteString(firstIndent + l + "\n")
+			firstIndent = ""
+			continue
+		}
+		w.WriteString(indent + l + "\n")
+	}
+}
+
+// isYAMLList returns whether the top level of the document is a list.
+func isYAMLList(lines []string) bool {
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, "---") {
+			continue
+		}
+		return t == "-" || strings.HasPrefix(t, "- ")
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package manifest_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos_include_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"common/libs.yml": "# Common libs\n- location: https://github.com/mongoose-os-libs/wifi\n- !include rpc.yml\n",
+		"common/rpc.yml":  "location: https://github.com/mongoose-os-libs/rpc-common\nversion: 1.0\n",
+		"defaults.yml":    "- [\"wifi.ap.enable\", false]\n",
+		"fragments.yml":   "x-cdefs: &cdefs\n  FOO: 1\n",
+		"cycle.yml":       "!include cycle.yml\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := `name: app
+!include fragments.yml
+cdefs: *cdefs
+libs:
+  - location: https://github.com/mongoose-os-libs/ca-bundle
+  - !include common/libs.yml
+config_schema: !include "defaults.yml"  # Per-customer defaults
+`
+	exp := `name: app
+x-cdefs: &cdefs
+  FOO: 1
+cdefs: *cdefs
+libs:
+  - location: https://github.com/mongoose-os-libs/ca-bundle
+  # Common libs
+  - location: https://github.com/mongoose-os-libs/wifi
+  - location: https://github.com/mongoose-os-libs/rpc-common
+    version: 1.0
+config_schema:
+  - ["wifi.ap.enable", false]
+`
+	res, included, err := expandIncludes([]byte(manifest), filepath.Join(dir, "mos.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != exp {
+		t.Errorf("unexpected result:\n%s", res)
+	}
+	if len(included) != 4 {
+		t.Errorf("expected 4 included files, got %v", included)
+	}
+
+	if _, _, err := expandIncludes([]byte("!include cycle.yml\n"), filepath.Join(dir, "mos.yml")); err == nil {
+		t.Errorf("expected an error for the include cycle")
+	}
+	if _, _, err := expandIncludes([]byte("!include missing.yml\n"), filepath.Join(dir, "mos.yml")); err == nil {
+		t.Errorf("expected an error for the missing file")
+	}
+}
@@ -63,9 +63,15 @@ type ComponentProvider interface {
 		m *build.SWModule, rootAppDir, modulesDefVersion, platform string,
 	) (string, error)
 
-	GetMongooseOSLocalPath(rootAppDir, mongooseOSVersion string) (string, error)
+	// GetMongooseOSLocalPath returns local path to the mongoose-os repo; the
+	// repo location is empty for the upstream one.
+	GetMongooseOSLocalPath(rootAppDir, mongooseOSRepo, mongooseOSVersion string) (string, error)
 }
 
+// RootManifestName is the name of the mongoose-os root manifest, as the
+// source of build vars in FWAppManifest.BuildVarsSources.
+const RootManifestName = "root_manifest"
+
 type ReadManifestCallbacks struct {
 	ComponentProvider ComponentProvider
 }
@@ -83,6 +89,9 @@ type RMFOut struct {
 
 	MosDirEffective string
 
+	// Local dirs of the modules, by module name.
+	ModuleDirs map[string]string
+
 	AppSourceDirs []string
 	AppFSDirs     []string
 	AppBinLibDirs []string
@@ -137,13 +146,22 @@ func ReadManifestFinal(
 			return nil, nil, errors.Trace(err)
 		}
 
+		moduleDir, err = filepath.Abs(moduleDir)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "getting absolute path of %q", moduleDir)
+		}
+
 		interpreter.SetModuleVars(interp.MVars, name, moduleDir)
+		if fp.ModuleDirs == nil {
+			fp.ModuleDirs = map[string]string{}
+		}
+		fp.ModuleDirs[name] = moduleDir
 	}
 	// }}}
 
 	// Determine mongoose-os dir (fp.MosDirEffective) {{{
 	fp.MosDirEffective, err = cbs.ComponentProvider.GetMongooseOSLocalPath(
-		dir, manifest.MongooseOsVersion,
+		dir, manifest.MongooseOsRepo, manifest.MongooseOsVersion,
 	)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
@@ -434,6 +452,11 @@ func readManifestWithLibs2(pc manifestParseContext) (*build.FWAppManifest, time.
 	// If the given appManifest is nil, it means that we've just read one, so
 	// remember it as such
 	if pc.appManifest == nil {
+		// Only the app manifest may refer to the environment.
+		if err := expandEnv(manifest, moscommon.GetManifestFilePath(pc.dir), os.LookupEnv); err != nil {
+			return nil, time.Time{}, errors.Trace(err)
+		}
+
 		pc.appManifest = manifest
 
 		// Also, remove any build vars from adjustments, so that they won't be set on
@@ -654,6 +677,27 @@ func ReadManifest(
 	return manifest, mtime, nil
 }
 
+// ReadAppManifest reads the app manifest from the given directory the way
+// the build sees it before handling the libs: with arch-specific adjustments,
+// the references to the environment expanded and the conds evaluated. Sets
+// the mos.platform variable of interp.
+func ReadAppManifest(
+	appDir string, adjustments *ManifestAdjustments, interp *interpreter.MosInterpreter,
+) (*build.FWAppManifest, error) {
+	manifest, _, err := ReadManifest(appDir, adjustments, interp)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := expandEnv(manifest, moscommon.GetManifestFilePath(appDir), os.LookupEnv); err != nil {
+		return nil, errors.Trace(err)
+	}
+	interp.MVars.SetVar(interpreter.GetMVarNameMosPlatform(), manifest.Platform)
+	if err := ExpandManifestConds(manifest, manifest, interp); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return manifest, nil
+}
+
 // ReadManifestFile reads single manifest file (which can be either "main" app
 // or lib manifest, or some arch-specific adjustment manifest)
 func ReadManifestFile(
@@ -675,6 +719,14 @@ func ReadManifestFile(
 		return nil, time.Time{}, errors.Annotatef(err, "reading manifest %q", manifestFullName)
 	}
 
+	var included []string
+	if !strings.HasPrefix(manifestFullName, assetPrefix) {
+		manifestSrc, included, err = expandIncludes(manifestSrc, manifestFullName)
+		if err != nil {
+			return nil, time.Time{}, errors.Annotatef(err, "reading manifest %q", manifestFullName)
+		}
+	}
+
 	var manifest build.FWAppManifest
 	if err := yaml.Unmarshal(manifestSrc, &manifest); err != nil {
 		return nil, time.Time{}, errors.Annotatef(err, "parsing manifest %q", manifestFullName)
@@ -757,6 +809,11 @@ func ReadManifestFile(
 		return nil, time.Time{}, errors.Trace(err)
 	}
 
+	manifest.MongooseOsRepo, err = interpreter.ExpandVars(interp, manifest.MongooseOsRepo, false)
+	if err != nil {
+		return nil, time.Time{}, errors.Trace(err)
+	}
+
 	manifest.LibsVersion, err = interpreter.ExpandVars(interp, manifest.LibsVersion, false)
 	if err != nil {
 		return nil, time.Time{}, errors.Trace(err)
@@ -776,6 +833,17 @@ func ReadManifestFile(
 		}
 
 		modTime = stat.ModTime()
+
+		// Changes in the included files count as changes of the manifest
+		for _, f := range included {
+			stat, err := os.Stat(f)
+			if err != nil {
+				return nil, time.Time{}, errors.Trace(err)
+			}
+			if stat.ModTime().After(modTime) {
+				modTime = stat.ModTime()
+			}
+		}
 	}
 
 	return &manifest, modTime, nil
@@ -834,7 +902,7 @@ func expandManifestLibsAndConds(
 		})
 
 		allManifests = append(allManifests, &build.FWAppManifestLibHandled{
-			Name:     "root_manifest",
+			Name:     RootManifestName,
 			Path:     "",
 			Manifest: rootManifest,
 		})
@@ -853,6 +921,9 @@ func expandManifestLibsAndConds(
 		// a dummy empty manifest.
 		commonManifest := allManifests[0].Manifest
 
+		// The last manifest in the chain which sets the build var wins
+		buildVarsSources := map[string]string{}
+
 		// Iterate all the rest of the manifests, at every step extending the
 		// current one with all previous manifests accumulated so far, and the
 		// current one takes precedence.
@@ -870,6 +941,9 @@ func expandManifestLibsAndConds(
 			); err != nil {
 				return errors.Annotatef(err, `expanding %q`, lcur.Name)
 			}
+			for name := range lcur.Manifest.BuildVars {
+				buildVarsSources[name] = lcur.Name
+			}
 
 			commonManifest = &curManifest
 		}
@@ -885,6 +959,7 @@ func expandManifestLibsAndConds(
 				commonManifest.LibsHandled[k].Manifest = nil
 			}
 			*manifest = *commonManifest
+			manifest.BuildVarsSources = buildVarsSources
 
 			return nil
 		}
@@ -1094,6 +1169,7 @@ func extendManifest(
 	// Add modules and libs from lib
 	mMain.Modules = append(m1.Modules, m2.Modules...)
 	mMain.Libs = append(m1.Libs, m2.Libs...)
+	mMain.ESPIDFComponents = append(m1.ESPIDFComponents, m2.ESPIDFComponents...)
 	mMain.ConfigSchema = append(m1.ConfigSchema, m2.ConfigSchema...)
 	mMain.CFlags = append(m1.CFlags, m2.CFlags...)
 	mMain.CXXFlags = append(m1.CXXFlags, m2.CXXFlags...)
@@ -1106,6 +1182,10 @@ func extendManifest(
 
 	var err error
 
+	if mMain.CXX, err = mergeCXXOpts(m1.CXX, m2.CXX); err != nil {
+		return errors.Trace(err)
+	}
+
 	mMain.BuildVars, err = mergeMapsString(m1.BuildVars, m2.BuildVars, interp, opts.skipFailedExpansions)
 	if err != nil {
 		return errors.Annotatef(err, "handling build_vars")
@@ -1323,6 +1403,17 @@ func resolvePaths(srcPaths []string, globs []string) (files []string, dirs []str
 	return addFiles, addDirs, nil
 }
 
+// ResolveFilesystemPaths takes filesystem entries from the manifest
+// (files, dirs or globs, possibly prefixed with "-" or "+"), relative to the
+// given dir, and returns paths to concrete existing files.
+func ResolveFilesystemPaths(items []string, dir string) ([]string, error) {
+	files, _, err := resolvePaths(prependPaths(items, dir), []string{"*"})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return files, nil
+}
+
 // resolvePathsUnprefixed is like resolvePaths, but doesn't support
 // `-` and `+` as filename prefixes.
 func resolvePathsUnprefixed(srcPaths []string, globs []string) (files []string, dirs []string, err error) {
@@ -193,7 +193,7 @@ func (lpt *compProviderTest) GetModuleLocalPath(
 }
 
 func (lpt *compProviderTest) GetMongooseOSLocalPath(
-	rootAppDir, modulesDefVersion string,
+	rootAppDir, mongooseOSRepo, modulesDefVersion string,
 ) (string, error) {
 	return repoRoot, nil
 }
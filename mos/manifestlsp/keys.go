@@ -0,0 +1,101 @@
+package manifestlsp
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Key is a manifest key known to the server.
+type Key struct {
+	Name string
+	Doc  string
+}
+
+// KeysFromStruct returns the keys of the YAML-tagged struct, like
+// build.FWAppManifest, including the ones of inlined structs, with docs from
+// the given map.
+func KeysFromStruct(v interface{}, docs map[string]string) []Key {
+	var keys []Key
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		parts := strings.Split(tag, ",")
+		if len(parts) > 1 && parts[1] == "inline" {
+			keys = append(keys, KeysFromStruct(reflect.Zero(f.Type).Interface(), docs)...)
+			continue
+		}
+		name := parts[0]
+		if name == "" || name == "-" || f.PkgPath != "" {
+			continue
+		}
+		keys = append(keys, Key{Name: name, Doc: docs[name]})
+	}
+	return keys
+}
+
+// ManifestDocs are the docs of the top level mos.yml keys, shown on hover.
+var ManifestDocs = map[string]string{
+	"name":                "Name of the app or lib.",
+	"type":                "`app` (default) or `lib`.",
+	"version":             "Version of the app or lib, like `1.0.0`.",
+	"summary":             "One-line description.",
+	"description":         "Longer description.",
+	"author":              "Author, like `Jane Doe <jane@example.com>`.",
+	"platform":            "Platform to build for by default, like `esp32`.",
+	"platforms":           "Platforms the lib supports.",
+	"arch":                "Deprecated, use `platform`.",
+	"sources":             "Source files and dirs; `-path` excludes.",
+	"includes":            "Include dirs.",
+	"filesystem":          "Files and dirs put on the device filesystem.",
+	"binary_libs":         "Prebuilt static libs to link with.",
+	"extra_files":         "Extra files added to the firmware zip.",
+	"ffi_symbols":         "C symbols callable from mJS.",
+	"tests":               "Test sources.",
+	"modules":             "Modules, like SDKs, to fetch: `location`, `version`.",
+	"libs":                "Libs to build with: `location` or `name`, `version`, `weak`.",
+	"init_after":          "Libs to initialize before this one.",
+	"config_schema":       "Config entries, like `[\"foo.bar\", \"i\", 1, {title: \"Bar\"}]`.",
+	"build_vars":          "Variables passed to make.",
+	"cflags":              "C compiler flags.",
+	"cxxflags":            "C++ compiler flags.",
+	"cxx":                 "C++ options: `std` like `c++17`, `exceptions`, `rtti`; merged from all libs.",
+	"cdefs":               "Preprocessor definitions, for both C and C++.",
+	"tags":                "Tags, like `c` or `js`.",
+	"hooks":               "Commands run at `pre_build`, `post_build`, `pre_flash`, `post_flash`, `pre_ota`, `post_ota`.",
+	"fs_assets":           "Filesystem files processing: `minify_js`, `bundles`, `gzip`.",
+	"embed_assets":        "Files compiled into the firmware as C arrays.",
+	"release":             "`mos release` options: `platforms`, `github`, `s3`, `scan_allow`.",
+	"partition_table":     "Custom ESP32 partition table.",
+	"board":               "Board profile, see `mos boards`.",
+	"build_info":          "Custom key/values embedded into the firmware, see `mos fw info`.",
+	"esp_idf_components":  "ESP-IDF components: `name: namespace/name` from the registry, or `location`.",
+	"arduino_libs":        "Arduino libraries from the library index: `name`, `version`, `patches`.",
+	"smoke_test":          "RPC calls `mos flash` makes afterwards: `method`, `args` as JSON, `expect` path/value pairs.",
+	"rust_crates":         "Rust staticlib crates linked into the firmware: `path`, `target`, `features`.",
+	"mos_version":         "Version of mos the project is built with.",
+	"min_mos_version":     "Minimal version of mos needed.",
+	"license":             "License of the app or lib, an SPDX expression like `MIT`.",
+	"allowed_licenses":    "Licenses libs may have; the build fails on other and unknown ones.",
+	"libs_version":        "Default version of libs.",
+	"modules_version":     "Default version of modules.",
+	"mongoose_os_version": "Version of mongoose-os.",
+	"mongoose_os_repo":    "Location of a mongoose-os fork.",
+	"conds":               "Conditional additions: `when` expression, `apply` manifest or `error`.",
+	"manifest_version":    "Manifest format version, a date like `2017-09-29`.",
+	"skeleton_version":    "Deprecated, use `manifest_version`.",
+}
+
+// LibDocs are the docs of the keys of libs and modules entries.
+var LibDocs = map[string]string{
+	"location": "Git repo URL, archive URL or local path.",
+	"origin":   "Deprecated, use `location`.",
+	"name":     "Name of the lib; the last component of the location by default.",
+	"version":  "Git tag, branch or commit, or `latest`.",
+	"type":     "`git`, `github`, `archive` or `local`; guessed from the location by default.",
+	"sha256":   "Expected digest of the archive.",
+	"weak":     "If true, the lib is optional.",
+}
@@ -0,0 +1,441 @@
+// Package manifestlsp is a minimal language server for mos.yml: completion
+// of keys and known lib names, hover docs, and diagnostics for syntax
+// errors, unknown keys and version formats.
+package manifestlsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"cesanta.com/mos/jsonrpc2"
+)
+
+// Severities of diagnostics.
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+)
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type CompletionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	InsertText    string `json:"insertText,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// Completion item kinds.
+const (
+	kindProperty = 10
+	kindModule   = 9
+)
+
+// Server keeps open documents and answers requests about them.
+type Server struct {
+	// Top level keys, and keys of libs and modules entries.
+	Keys    []Key
+	LibKeys []Key
+	// KnownLibs returns lib names for completion and hints, e.g. of the libs
+	// fetched before; may be nil.
+	KnownLibs func() []string
+
+	// Exited is closed on the exit notification.
+	Exited chan struct{}
+
+	mu   sync.Mutex
+	docs map[string]string
+}
+
+// NewServer returns the server knowing the given keys.
+func NewServer(keys, libKeys []Key, knownLibs func() []string) *Server {
+	return &Server{
+		Keys: keys, LibKeys: libKeys, KnownLibs: knownLibs,
+		Exited: make(chan struct{}),
+		docs:   map[string]string{},
+	}
+}
+
+// Register adds the LSP methods to the connection.
+func (s *Server) Register(c *jsonrpc2.Conn) {
+	c.Handle("initialize", s.initialize)
+	c.Handle("initialized", nop)
+	c.Handle("shutdown", nop)
+	c.Handle("exit", func(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+		close(s.Exited)
+		return nil, nil
+	})
+	c.Handle("textDocument/didOpen", s.didOpen)
+	c.Handle("textDocument/didChange", s.didChange)
+	c.Handle("textDocument/didClose", s.didClose)
+	c.Handle("textDocument/didSave", nop)
+	c.Handle("textDocument/completion", s.completion)
+	c.Handle("textDocument/hover", s.hover)
+}
+
+func nop(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *Server) initialize(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			// Full document sync
+			"textDocumentSync":   1,
+			"hoverProvider":      true,
+			"completionProvider": map[string]interface{}{"triggerCharacters": []string{":", " "}},
+		},
+		"serverInfo": map[string]string{"name": "mos lsp"},
+	}, nil
+}
+
+type textDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type docParams struct {
+	TextDocument   textDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position Position `json:"position"`
+}
+
+func parseParams(params json.RawMessage) (*docParams, error) {
+	var p docParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, jsonrpc2.InvalidParams(err)
+	}
+	return &p, nil
+}
+
+func (s *Server) didOpen(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	p, err := parseParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.update(c, p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	p, err := parseParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	return nil, s.update(c, p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	p, err := parseParams(params)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	delete(s.docs, p.TextDocument.URI)
+	s.mu.Unlock()
+	return nil, c.Notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri": p.TextDocument.URI, "diagnostics": []Diagnostic{},
+	})
+}
+
+func (s *Server) update(c *jsonrpc2.Conn, uri, text string) error {
+	s.mu.Lock()
+	s.docs[uri] = text
+	s.mu.Unlock()
+	diags := s.Diagnose(text)
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	return c.Notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diags})
+}
+
+func (s *Server) doc(uri string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.docs[uri]
+}
+
+func (s *Server) completion(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	p, err := parseParams(params)
+	if err != nil {
+		return nil, err
+	}
+	items := s.Complete(s.doc(p.TextDocument.URI), p.Position)
+	if items == nil {
+		items = []CompletionItem{}
+	}
+	return items, nil
+}
+
+func (s *Server) hover(ctx context.Context, c *jsonrpc2.Conn, params json.RawMessage) (interface{}, error) {
+	p, err := parseParams(params)
+	if err != nil {
+		return nil, err
+	}
+	doc := s.Hover(s.doc(p.TextDocument.URI), p.Position)
+	if doc == "" {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": doc},
+	}, nil
+}
+
+var (
+	topKeyRE   = regexp.MustCompile(`^([A-Za-z_][\w]*)\s*:`)
+	itemKeyRE  = regexp.MustCompile(`^\s*-?\s*([A-Za-z_]\w*)\s*:\s*(.*?)\s*$`)
+	yamlLineRE = regexp.MustCompile(`line (\d+)`)
+
+	appVersionRE      = regexp.MustCompile(`^\d+\.\d+(\.\d+)?([-+][\w.-]+)?$`)
+	manifestVersionRE = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// listKeys are the top level keys whose items are libs or modules.
+var listKeys = map[string]bool{"libs": true, "modules": true, "esp_idf_components": true}
+
+// enclosingKey returns the top level key the line belongs to.
+func enclosingKey(lines []string, line int) string {
+	for i := line; i >= 0 && i < len(lines); i-- {
+		if m := topKeyRE.FindStringSubmatch(lines[i]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// Complete returns completion items at the position.
+func (s *Server) Complete(text string, pos Position) []CompletionItem {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return nil
+	}
+	line := lines[pos.Line]
+	if pos.Character < len(line) {
+		line = line[:pos.Character]
+	}
+
+	if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") && !strings.Contains(line, ":") {
+		return keyItems(s.Keys, ": ")
+	}
+	if !listKeys[enclosingKey(lines, pos.Line)] {
+		return nil
+	}
+	if m := itemKeyRE.FindStringSubmatch(line); m != nil {
+		if (m[1] == "name" || m[1] == "location" || m[1] == "origin") && s.KnownLibs != nil {
+			var items []CompletionItem
+			for _, name := range s.KnownLibs() {
+				it := CompletionItem{Label: name, Kind: kindModule}
+				if m[1] != "name" {
+					it.InsertText = "https://github.com/mongoose-os-libs/" + name
+				}
+				items = append(items, it)
+			}
+			return items
+		}
+		return nil
+	}
+	return keyItems(s.LibKeys, ": ")
+}
+
+func keyItems(keys []Key, suffix string) []CompletionItem {
+	var items []CompletionItem
+	for _, k := range keys {
+		items = append(items, CompletionItem{
+			Label: k.Name, Kind: kindProperty, InsertText: k.Name + suffix, Documentation: k.Doc,
+		})
+	}
+	return items
+}
+
+// Hover returns the docs of the key at the position.
+func (s *Server) Hover(text string, pos Position) string {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+	if m := topKeyRE.FindStringSubmatch(line); m != nil && pos.Character <= len(m[1]) {
+		return findDoc(s.Keys, m[1])
+	}
+	if m := itemKeyRE.FindStringSubmatch(line); m != nil && listKeys[enclosingKey(lines, pos.Line)] {
+		if i := strings.Index(line, m[1]); pos.Character >= i && pos.Character <= i+len(m[1]) {
+			return findDoc(s.LibKeys, m[1])
+		}
+	}
+	return ""
+}
+
+func findDoc(keys []Key, name string) string {
+	for _, k := range keys {
+		if k.Name == name {
+			if k.Doc == "" {
+				return fmt.Sprintf("`%s`", name)
+			}
+			return fmt.Sprintf("`%s`: %s", name, k.Doc)
+		}
+	}
+	return ""
+}
+
+// Diagnose returns the problems found in the manifest text.
+func (s *Server) Diagnose(text string) []Diagnostic {
+	lines := strings.Split(text, "\n")
+	var diags []Diagnostic
+	add := func(line int, sev int, msg string) {
+		end := 0
+		if line >= 0 && line < len(lines) {
+			end = len(lines[line])
+		}
+		diags = append(diags, Diagnostic{
+			Range:    Range{Start: Position{Line: line}, End: Position{Line: line, Character: end}},
+			Severity: sev, Source: "mos", Message: msg,
+		})
+	}
+	// findLine returns the first line at or after from matching re.
+	findLine := func(from int, re *regexp.Regexp) int {
+		for i := from; i < len(lines); i++ {
+			if re.MatchString(lines[i]) {
+				return i
+			}
+		}
+		return from
+	}
+	keyLine := func(key string) int {
+		return findLine(0, regexp.MustCompile(`^`+regexp.QuoteMeta(key)+`\s*:`))
+	}
+
+	var m yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(text), &m); err != nil {
+		line := 0
+		if lm := yamlLineRE.FindStringSubmatch(err.Error()); lm != nil {
+			fmt.Sscanf(lm[1], "%d", &line)
+			line--
+		}
+		add(line, SeverityError, strings.TrimPrefix(err.Error(), "yaml: "))
+		return diags
+	}
+
+	known := map[string]bool{}
+	for _, k := range s.Keys {
+		known[k.Name] = true
+	}
+	knownLib := map[string]bool{}
+	var knownLibs []string
+	if s.KnownLibs != nil {
+		knownLibs = s.KnownLibs()
+		for _, l := range knownLibs {
+			knownLib[l] = true
+		}
+	}
+
+	for _, item := range m {
+		key, _ := item.Key.(string)
+		line := keyLine(key)
+		if !known[key] {
+			add(line, SeverityWarning, fmt.Sprintf("unknown key %q", key))
+			continue
+		}
+		val := fmt.Sprint(item.Value)
+		switch key {
+		case "version":
+			if !appVersionRE.MatchString(val) {
+				add(line, SeverityWarning, fmt.Sprintf("version %q is not like 1.2.3", val))
+			}
+		case "manifest_version":
+			if !manifestVersionRE.MatchString(val) {
+				add(line, SeverityError, fmt.Sprintf("manifest_version %q is not a date like 2017-09-29", val))
+			}
+		case "mos_version", "min_mos_version", "libs_version", "modules_version", "mongoose_os_version":
+			if val != "latest" && val != "release" && !appVersionRE.MatchString(val) {
+				add(line, SeverityWarning, fmt.Sprintf("%s %q is not latest, release or like 2.20.0", key, val))
+			}
+		case "libs", "modules":
+			list, ok := item.Value.([]interface{})
+			if !ok {
+				add(line, SeverityError, fmt.Sprintf("%s must be a list", key))
+				continue
+			}
+			from := line
+			for _, e := range list {
+				em, ok := e.(yaml.MapSlice)
+				if !ok {
+					add(from, SeverityError, fmt.Sprintf("%s entries must be maps with location or name", key))
+					continue
+				}
+				from = findLine(from+1, regexp.MustCompile(`^\s*-`))
+				s.diagnoseLib(key, em, from, knownLib, add)
+			}
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Range.Start.Line < diags[j].Range.Start.Line })
+	return diags
+}
+
+// diagnoseLib checks a libs or modules entry starting at the line; problems
+// are reported with add.
+func (s *Server) diagnoseLib(
+	key string, e yaml.MapSlice, line int, knownLib map[string]bool, add func(int, int, string),
+) {
+	libKeys := map[string]bool{}
+	for _, k := range s.LibKeys {
+		libKeys[k.Name] = true
+	}
+	vals := map[string]string{}
+	for _, kv := range e {
+		k, _ := kv.Key.(string)
+		if !libKeys[k] {
+			add(line, SeverityWarning, fmt.Sprintf("unknown %s entry key %q", key, k))
+			continue
+		}
+		vals[k] = fmt.Sprint(kv.Value)
+	}
+	loc := vals["location"]
+	if loc == "" {
+		loc = vals["origin"]
+	}
+	if loc == "" && vals["name"] == "" {
+		add(line, SeverityError, fmt.Sprintf("%s entry must have location or name", key))
+		return
+	}
+	if v, ok := vals["version"]; ok && (v == "" || strings.ContainsAny(v, " \t")) {
+		add(line, SeverityWarning, fmt.Sprintf("invalid version %q: expected a git tag, branch, commit or latest", v))
+	}
+	if key == "libs" && len(knownLib) > 0 {
+		name := vals["name"]
+		if name == "" {
+			parts := strings.Split(strings.TrimSuffix(strings.TrimSuffix(loc, "/"), ".git"), "/")
+			name = parts[len(parts)-1]
+		}
+		if !knownLib[name] {
+			add(line, SeverityInformation, fmt.Sprintf("lib %q has not been fetched before, check the name", name))
+		}
+	}
+}
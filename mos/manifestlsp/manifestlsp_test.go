@@ -0,0 +1,93 @@
+package manifestlsp
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestServer() *Server {
+	keys := []Key{{Name: "name"}, {Name: "version", Doc: "Version."}, {Name: "manifest_version"}, {Name: "libs"}, {Name: "mos_version"}}
+	libKeys := []Key{{Name: "location"}, {Name: "name", Doc: "Lib name."}, {Name: "version"}}
+	return NewServer(keys, libKeys, func() []string { return []string{"rpc-common", "wifi"} })
+}
+
+func TestDiagnose(t *testing.T) {
+	s := newTestServer()
+	text := strings.Join([]string{
+		"name: app",
+		"version: one",
+		"manifest_version: 2017-9-29",
+		"foo: bar",
+		"mos_version: latest",
+		"libs:",
+		"  - location: https://github.com/mongoose-os-libs/wifi",
+		"  - name: wfii",
+		"  - version: 1.0",
+		"",
+	}, "\n")
+	diags := s.Diagnose(text)
+	want := []struct {
+		line int
+		sev  int
+		msg  string
+	}{
+		{1, SeverityWarning, "version"},
+		{2, SeverityError, "manifest_version"},
+		{3, SeverityWarning, `unknown key "foo"`},
+		{7, SeverityInformation, `"wfii"`},
+		{8, SeverityError, "location or name"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %+v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		d := diags[i]
+		if d.Range.Start.Line != w.line || d.Severity != w.sev || !strings.Contains(d.Message, w.msg) {
+			t.Errorf("diagnostic %d: got %+v, want line %d severity %d %q", i, d, w.line, w.sev, w.msg)
+		}
+	}
+
+	diags = s.Diagnose("name: app\nlibs: [\n")
+	if len(diags) != 1 || diags[0].Severity != SeverityError {
+		t.Errorf("syntax error: got %+v", diags)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newTestServer()
+	text := "name: app\nver\nlibs:\n  - \n  - name: \n"
+	labels := func(items []CompletionItem) string {
+		var l []string
+		for _, it := range items {
+			l = append(l, it.Label)
+		}
+		return strings.Join(l, ",")
+	}
+	for _, c := range []struct {
+		pos  Position
+		want string
+	}{
+		{Position{Line: 1, Character: 3}, "name,version,manifest_version,libs,mos_version"},
+		{Position{Line: 3, Character: 4}, "location,name,version"},
+		{Position{Line: 4, Character: 10}, "rpc-common,wifi"},
+		{Position{Line: 0, Character: 6}, ""},
+	} {
+		if got := labels(s.Complete(text, c.pos)); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.pos, got, c.want)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	s := newTestServer()
+	text := "version: 1.0\nlibs:\n  - name: wifi\n"
+	if got := s.Hover(text, Position{Line: 0, Character: 2}); got != "`version`: Version." {
+		t.Errorf("got %q", got)
+	}
+	if got := s.Hover(text, Position{Line: 2, Character: 5}); got != "`name`: Lib name." {
+		t.Errorf("got %q", got)
+	}
+	if got := s.Hover(text, Position{Line: 2, Character: 12}); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,215 @@
+// Package mdns implements a minimal mDNS (RFC 6762) resolver for ".local"
+// host names: it sends one-shot A and AAAA queries over IPv4 and IPv6
+// multicast and collects the answers.
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	port = 5353
+)
+
+var (
+	groupV4 = net.ParseIP("224.0.0.251")
+	groupV6 = net.ParseIP("ff02::fb")
+)
+
+// IsLocalName returns whether the host name should be resolved via mDNS.
+func IsLocalName(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".local")
+}
+
+// Resolve returns the addresses of the given ".local" host. IPv6 link-local
+// addresses have the zone set to the interface the answer came from.
+func Resolve(name string, timeout time.Duration) ([]net.IPAddr, error) {
+	query := encodeQuery(name)
+
+	var mtx sync.Mutex
+	var ret []net.IPAddr
+	add := func(addrs []net.IPAddr) {
+		mtx.Lock()
+		defer mtx.Unlock()
+	outer:
+		for _, a := range addrs {
+			for _, r := range ret {
+				if r.IP.Equal(a.IP) && r.Zone == a.Zone {
+					continue outer
+				}
+			}
+			ret = append(ret, a)
+		}
+	}
+
+	var wg sync.WaitGroup
+	ask := func(network string, group *net.UDPAddr) {
+		defer wg.Done()
+		conn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.WriteToUDP(query, group); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			addrs, err := parseResponse(buf[:n], name)
+			if err != nil {
+				continue
+			}
+			for i := range addrs {
+				if addrs[i].IP.To4() == nil && addrs[i].IP.IsLinkLocalUnicast() {
+					addrs[i].Zone = from.Zone
+				}
+			}
+			add(addrs)
+		}
+	}
+
+	wg.Add(1)
+	go ask("udp4", &net.UDPAddr{IP: groupV4, Port: port})
+
+	// IPv6 multicast group is link-local, so query on each interface
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		wg.Add(1)
+		go ask("udp6", &net.UDPAddr{IP: groupV6, Port: port, Zone: iface.Name})
+	}
+
+	wg.Wait()
+
+	if len(ret) == 0 {
+		return nil, errors.Errorf("failed to resolve %s via mDNS", name)
+	}
+	return ret, nil
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// encodeQuery returns the query for both A and AAAA records of the name.
+func encodeQuery(name string) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[4:], 2) // QDCOUNT
+	for _, t := range []uint16{typeA, typeAAAA} {
+		b = append(b, encodeName(name)...)
+		b = append(b, byte(t>>8), byte(t), 0, classIN)
+	}
+	return b
+}
+
+// readName reads a possibly compressed name at the given offset, and returns
+// it along with the offset right after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.Errorf("name is out of bounds")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.Errorf("pointer is out of bounds")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if jumps++; jumps > 10 {
+				return "", 0, errors.Errorf("too many pointers")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.Errorf("label is out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// parseResponse returns addresses of the given name from the answer and
+// additional sections of the response.
+func parseResponse(msg []byte, name string) ([]net.IPAddr, error) {
+	if len(msg) < 12 {
+		return nil, errors.Errorf("message is too short")
+	}
+	if msg[2]&0x80 == 0 {
+		return nil, errors.Errorf("not a response")
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrCount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		off = next + 4
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	var ret []net.IPAddr
+	for i := 0; i < rrCount; i++ {
+		rrName, next, err := readName(msg, off)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if next+10 > len(msg) {
+			return nil, errors.Errorf("record is out of bounds")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		rdLen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdLen > len(msg) {
+			return nil, errors.Errorf("record data is out of bounds")
+		}
+		if strings.EqualFold(rrName, name) {
+			switch {
+			case rrType == typeA && rdLen == net.IPv4len,
+				rrType == typeAAAA && rdLen == net.IPv6len:
+				ip := make(net.IP, rdLen)
+				copy(ip, msg[rdata:rdata+rdLen])
+				ret = append(ret, net.IPAddr{IP: ip})
+			}
+		}
+		off = rdata + rdLen
+	}
+	return ret, nil
+}
@@ -0,0 +1,49 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	// Response with the question echoed, an AAAA answer using a pointer to the
+	// question name, and an A record for another host.
+	msg := []byte{0, 0, 0x84, 0, 0, 1, 0, 2, 0, 0, 0, 0}
+	msg = append(msg, encodeName("dev.local")...)
+	msg = append(msg, 0, typeAAAA, 0, classIN)
+	msg = append(msg, 0xc0, 12, 0, typeAAAA, 0x80, classIN, 0, 0, 0, 120, 0, 16)
+	msg = append(msg, net.ParseIP("fe80::1")...)
+	msg = append(msg, encodeName("other.local")...)
+	msg = append(msg, 0, typeA, 0x80, classIN, 0, 0, 0, 120, 0, 4, 192, 168, 1, 2)
+
+	addrs, err := parseResponse(msg, "DEV.local.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+
+	if _, err := parseResponse(msg[:len(msg)-3], "dev.local"); err == nil {
+		t.Errorf("expected an error for a truncated message")
+	}
+
+	// Pointer loop
+	loop := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0xc0, 12}
+	if _, err := parseResponse(loop, "dev.local"); err == nil {
+		t.Errorf("expected an error for a pointer loop")
+	}
+}
+
+func TestIsLocalName(t *testing.T) {
+	for name, exp := range map[string]bool{
+		"mydevice.local":  true,
+		"MyDevice.LOCAL.": true,
+		"example.com":     false,
+		"local":           false,
+	} {
+		if got := IsLocalName(name); got != exp {
+			t.Errorf("%q: expected %v", name, exp)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package mjs
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	col  int
+	// Whether there is a line break between the previous token and this one;
+	// needed for automatic semicolon insertion.
+	nlBefore bool
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return fmt.Sprintf("number %s", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var keywords = map[string]bool{
+	"break": true, "case": true, "continue": true, "default": true,
+	"delete": true, "do": true, "else": true, "for": true, "function": true,
+	"if": true, "in": true, "instanceof": true, "let": true, "return": true,
+	"switch": true, "typeof": true, "void": true, "while": true,
+
+	// Not supported by mJS, but reserved
+	"catch": true, "class": true, "const": true, "debugger": true,
+	"export": true, "extends": true, "finally": true, "import": true,
+	"new": true, "super": true, "throw": true, "try": true, "var": true,
+	"with": true, "yield": true,
+}
+
+// Punctuators, longest first.
+var puncts = []string{
+	">>>=",
+	"===", "!==", "<<=", ">>=", ">>>",
+	"==", "!=", "<=", ">=", "&&", "||", "++", "--", "+=", "-=", "*=", "/=",
+	"%=", "&=", "|=", "^=", "<<", ">>", "=>",
+	"{", "}", "(", ")", "[", "]", ";", ",", "<", ">", "+", "-", "*", "/",
+	"%", "&", "|", "^", "!", "~", "?", ":", "=", ".",
+}
+
+// SyntaxError describes the location and the reason of a syntax error.
+// Lines and columns are one-based.
+type SyntaxError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) peek(off int) byte {
+	if l.pos+off < len(l.src) {
+		return l.src[l.pos+off]
+	}
+	return 0
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+// skipSpace skips whitespace and comments, and returns whether there was a
+// line break.
+func (l *lexer) skipSpace() (bool, error) {
+	nl := false
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			nl = true
+			l.advance(1)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.advance(1)
+		case c == '/' && l.peek(1) == '/':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case c == '/' && l.peek(1) == '*':
+			line, col := l.line, l.col
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return false, l.errorf(line, col, "unterminated comment")
+			}
+			if strings.Contains(l.src[l.pos:l.pos+2+end], "\n") {
+				nl = true
+			}
+			l.advance(end + 4)
+		default:
+			return nl, nil
+		}
+	}
+	return nl, nil
+}
+
+func (l *lexer) next() (token, error) {
+	nl, err := l.skipSpace()
+	if err != nil {
+		return token{}, err
+	}
+
+	tok := token{line: l.line, col: l.col, nlBefore: nl}
+	if l.pos >= len(l.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+
+	c := l.src[l.pos]
+	start := l.pos
+	switch {
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.advance(1)
+		}
+		tok.text = l.src[start:l.pos]
+		tok.kind = tokIdent
+		if keywords[tok.text] {
+			tok.kind = tokKeyword
+		}
+
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		if c == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+			l.advance(2)
+			for isHexDigit(l.peek(0)) {
+				l.advance(1)
+			}
+		} else {
+			for isDigit(l.peek(0)) || l.peek(0) == '.' {
+				l.advance(1)
+			}
+			if l.peek(0) == 'e' || l.peek(0) == 'E' {
+				l.advance(1)
+				if l.peek(0) == '+' || l.peek(0) == '-' {
+					l.advance(1)
+				}
+				for isDigit(l.peek(0)) {
+					l.advance(1)
+				}
+			}
+		}
+		tok.text = l.src[start:l.pos]
+		tok.kind = tokNumber
+		if isIdentStart(l.peek(0)) {
+			return token{}, l.errorf(tok.line, tok.col, "invalid number %s", l.src[start:l.pos+1])
+		}
+
+	case c == '"' || c == '\'':
+		l.advance(1)
+		for {
+			if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+				return token{}, l.errorf(tok.line, tok.col, "unterminated string")
+			}
+			if l.src[l.pos] == '\\' {
+				l.advance(2)
+				continue
+			}
+			if l.src[l.pos] == c {
+				l.advance(1)
+				break
+			}
+			l.advance(1)
+		}
+		tok.text = l.src[start:l.pos]
+		tok.kind = tokString
+
+	case c == '`':
+		return token{}, l.errorf(tok.line, tok.col, "template strings are not supported by mJS")
+
+	default:
+		for _, p := range puncts {
+			if strings.HasPrefix(l.src[l.pos:], p) {
+				l.advance(len(p))
+				tok.text = p
+				tok.kind = tokPunct
+				return tok, nil
+			}
+		}
+		return token{}, l.errorf(tok.line, tok.col, "unexpected character %q", c)
+	}
+
+	return tok, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
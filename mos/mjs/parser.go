@@ -0,0 +1,609 @@
+// Package mjs implements a syntax checker for mJS, the restricted JavaScript
+// engine used by Mongoose OS. It doesn't build any AST, but catches syntax
+// errors and use of the JavaScript features which mJS doesn't support, so
+// that those can be reported before flashing.
+package mjs
+
+import (
+	"github.com/cesanta/errors"
+)
+
+// Binary operators precedence; higher binds tighter.
+var binaryPrec = map[string]int{
+	"||":  1,
+	"&&":  2,
+	"|":   3,
+	"^":   4,
+	"&":   5,
+	"===": 6, "!==": 6, "==": 6, "!=": 6,
+	"<": 7, ">": 7, "<=": 7, ">=": 7, "in": 7, "instanceof": 7,
+	"<<": 8, ">>": 8, ">>>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+var assignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"<<=": true, ">>=": true, ">>>=": true, "&=": true, "|=": true, "^=": true,
+}
+
+var unsupportedKeywords = map[string]string{
+	"var":   "var is not supported by mJS, use let",
+	"const": "const is not supported by mJS, use let",
+	"new":   "new is not supported by mJS",
+	"class": "classes are not supported by mJS",
+	"try":   "exceptions are not supported by mJS",
+	"throw": "exceptions are not supported by mJS",
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// Check parses the given mJS source and returns the first syntax error
+// (of type *SyntaxError), or nil if there are no errors.
+func Check(src []byte) error {
+	p := &parser{lex: newLexer(string(src))}
+	if err := p.next(); err != nil {
+		return err
+	}
+	for p.tok.kind != tokEOF {
+		if err := p.parseStatement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.lex.errorf(p.tok.line, p.tok.col, format, args...)
+}
+
+func (p *parser) is(text string) bool {
+	return (p.tok.kind == tokPunct || p.tok.kind == tokKeyword) && p.tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q, got %s", text, p.tok)
+	}
+	return p.next()
+}
+
+// expectSemicolon handles the end of the statement, taking automatic
+// semicolon insertion into account.
+func (p *parser) expectSemicolon() error {
+	if p.is(";") {
+		return p.next()
+	}
+	if p.tok.nlBefore || p.is("}") || p.tok.kind == tokEOF {
+		return nil
+	}
+	return p.errorf("expected \";\", got %s", p.tok)
+}
+
+func (p *parser) parseStatement() error {
+	if p.tok.kind == tokKeyword {
+		if msg, ok := unsupportedKeywords[p.tok.text]; ok {
+			return p.errorf("%s", msg)
+		}
+	}
+
+	switch {
+	case p.is(";"):
+		return p.next()
+
+	case p.is("{"):
+		return p.parseBlock()
+
+	case p.is("let"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseLetBindings(false); err != nil {
+			return err
+		}
+		return p.expectSemicolon()
+
+	case p.is("if"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseParenExpr(); err != nil {
+			return err
+		}
+		if err := p.parseStatement(); err != nil {
+			return err
+		}
+		if p.is("else") {
+			if err := p.next(); err != nil {
+				return err
+			}
+			return p.parseStatement()
+		}
+		return nil
+
+	case p.is("while"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseParenExpr(); err != nil {
+			return err
+		}
+		return p.parseStatement()
+
+	case p.is("do"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseStatement(); err != nil {
+			return err
+		}
+		if err := p.expect("while"); err != nil {
+			return err
+		}
+		if err := p.parseParenExpr(); err != nil {
+			return err
+		}
+		return p.expectSemicolon()
+
+	case p.is("for"):
+		return p.parseFor()
+
+	case p.is("switch"):
+		return p.parseSwitch()
+
+	case p.is("break"), p.is("continue"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		return p.expectSemicolon()
+
+	case p.is("return"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if !p.is(";") && !p.is("}") && !p.tok.nlBefore && p.tok.kind != tokEOF {
+			if err := p.parseExpr(false); err != nil {
+				return err
+			}
+		}
+		return p.expectSemicolon()
+
+	case p.is("function"):
+		// Function declaration
+		if err := p.next(); err != nil {
+			return err
+		}
+		if p.tok.kind != tokIdent {
+			return p.errorf("expected function name, got %s", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		return p.parseFunctionRest()
+	}
+
+	if err := p.parseExpr(false); err != nil {
+		return err
+	}
+	return p.expectSemicolon()
+}
+
+func (p *parser) parseBlock() error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.is("}") {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected \"}\", got %s", p.tok)
+		}
+		if err := p.parseStatement(); err != nil {
+			return err
+		}
+	}
+	return p.next()
+}
+
+func (p *parser) parseParenExpr() error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if err := p.parseExpr(false); err != nil {
+		return err
+	}
+	return p.expect(")")
+}
+
+// parseLetBindings parses "a = 1, b, c = 2" after "let". If noIn is true,
+// the "in" operator is not consumed, so that "for (let k in obj)" works.
+func (p *parser) parseLetBindings(noIn bool) error {
+	for {
+		if p.tok.kind != tokIdent {
+			return p.errorf("expected variable name, got %s", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		if p.is("=") {
+			if err := p.next(); err != nil {
+				return err
+			}
+			if err := p.parseAssign(noIn); err != nil {
+				return err
+			}
+		}
+		if !p.is(",") {
+			return nil
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseFor() error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+
+	if p.is("let") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseLetBindings(true); err != nil {
+			return err
+		}
+	} else if !p.is(";") {
+		if err := p.parseExpr(true); err != nil {
+			return err
+		}
+	}
+
+	if p.is("in") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseExpr(false); err != nil {
+			return err
+		}
+	} else {
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		if !p.is(";") {
+			if err := p.parseExpr(false); err != nil {
+				return err
+			}
+		}
+		if err := p.expect(";"); err != nil {
+			return err
+		}
+		if !p.is(")") {
+			if err := p.parseExpr(false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := p.expect(")"); err != nil {
+		return err
+	}
+	return p.parseStatement()
+}
+
+func (p *parser) parseSwitch() error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.parseParenExpr(); err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.is("}") {
+		switch {
+		case p.is("case"):
+			if err := p.next(); err != nil {
+				return err
+			}
+			if err := p.parseExpr(false); err != nil {
+				return err
+			}
+		case p.is("default"):
+			if err := p.next(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("expected \"case\" or \"default\", got %s", p.tok)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		for !p.is("case") && !p.is("default") && !p.is("}") {
+			if p.tok.kind == tokEOF {
+				return p.errorf("expected \"}\", got %s", p.tok)
+			}
+			if err := p.parseStatement(); err != nil {
+				return err
+			}
+		}
+	}
+	return p.next()
+}
+
+// parseFunctionRest parses function parameters and body.
+func (p *parser) parseFunctionRest() error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.is(")") {
+		if p.tok.kind != tokIdent {
+			return p.errorf("expected parameter name, got %s", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		if !p.is(")") {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	return p.parseBlock()
+}
+
+// parseExpr parses comma-separated expressions. If noIn is true, the "in"
+// operator is not consumed (needed for "for (x in obj)").
+func (p *parser) parseExpr(noIn bool) error {
+	for {
+		if err := p.parseAssign(noIn); err != nil {
+			return err
+		}
+		if !p.is(",") {
+			return nil
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseAssign(noIn bool) error {
+	if err := p.parseConditional(noIn); err != nil {
+		return err
+	}
+	if p.tok.kind == tokPunct && assignOps[p.tok.text] {
+		if err := p.next(); err != nil {
+			return err
+		}
+		return p.parseAssign(noIn)
+	}
+	if p.is("=>") {
+		return p.errorf("arrow functions are not supported by mJS")
+	}
+	return nil
+}
+
+func (p *parser) parseConditional(noIn bool) error {
+	if err := p.parseBinary(1, noIn); err != nil {
+		return err
+	}
+	if !p.is("?") {
+		return nil
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.parseAssign(false); err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	return p.parseAssign(noIn)
+}
+
+func (p *parser) parseBinary(minPrec int, noIn bool) error {
+	if err := p.parseUnary(); err != nil {
+		return err
+	}
+	for {
+		if p.tok.kind != tokPunct && p.tok.kind != tokKeyword {
+			return nil
+		}
+		prec, ok := binaryPrec[p.tok.text]
+		if !ok || prec < minPrec || (noIn && p.tok.text == "in") {
+			return nil
+		}
+		switch p.tok.text {
+		case "==":
+			return p.errorf("== is not supported by mJS, use ===")
+		case "!=":
+			return p.errorf("!= is not supported by mJS, use !==")
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseBinary(prec+1, noIn); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseUnary() error {
+	switch {
+	case p.is("!"), p.is("~"), p.is("+"), p.is("-"), p.is("++"), p.is("--"),
+		p.is("typeof"), p.is("void"), p.is("delete"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		return p.parseUnary()
+	}
+
+	if err := p.parsePostfix(); err != nil {
+		return err
+	}
+	if (p.is("++") || p.is("--")) && !p.tok.nlBefore {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) parsePostfix() error {
+	if err := p.parsePrimary(); err != nil {
+		return err
+	}
+	for {
+		switch {
+		case p.is("."):
+			if err := p.next(); err != nil {
+				return err
+			}
+			// Keywords are allowed as property names
+			if p.tok.kind != tokIdent && p.tok.kind != tokKeyword {
+				return p.errorf("expected property name, got %s", p.tok)
+			}
+			if err := p.next(); err != nil {
+				return err
+			}
+		case p.is("["):
+			if err := p.next(); err != nil {
+				return err
+			}
+			if err := p.parseExpr(false); err != nil {
+				return err
+			}
+			if err := p.expect("]"); err != nil {
+				return err
+			}
+		case p.is("("):
+			if err := p.next(); err != nil {
+				return err
+			}
+			for !p.is(")") {
+				if err := p.parseAssign(false); err != nil {
+					return err
+				}
+				if !p.is(")") {
+					if err := p.expect(","); err != nil {
+						return err
+					}
+				}
+			}
+			if err := p.next(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() error {
+	if p.tok.kind == tokKeyword {
+		if msg, ok := unsupportedKeywords[p.tok.text]; ok {
+			return p.errorf("%s", msg)
+		}
+	}
+
+	switch {
+	case p.tok.kind == tokIdent, p.tok.kind == tokNumber, p.tok.kind == tokString:
+		return p.next()
+
+	case p.is("("):
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseExpr(false); err != nil {
+			return err
+		}
+		return p.expect(")")
+
+	case p.is("["):
+		if err := p.next(); err != nil {
+			return err
+		}
+		for !p.is("]") {
+			if err := p.parseAssign(false); err != nil {
+				return err
+			}
+			if !p.is("]") {
+				if err := p.expect(","); err != nil {
+					return err
+				}
+			}
+		}
+		return p.next()
+
+	case p.is("{"):
+		return p.parseObjectLiteral()
+
+	case p.is("function"):
+		if err := p.next(); err != nil {
+			return err
+		}
+		// Function expressions can be named
+		if p.tok.kind == tokIdent {
+			if err := p.next(); err != nil {
+				return err
+			}
+		}
+		return p.parseFunctionRest()
+	}
+
+	return p.errorf("unexpected %s", p.tok)
+}
+
+func (p *parser) parseObjectLiteral() error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.is("}") {
+		switch p.tok.kind {
+		case tokIdent, tokKeyword, tokString, tokNumber:
+			if err := p.next(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("expected property name, got %s", p.tok)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.parseAssign(false); err != nil {
+			return err
+		}
+		if !p.is("}") {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	}
+	return p.next()
+}
+
+// CheckFile is like Check, but also prefixes the error with the file name.
+func CheckFile(name string, src []byte) error {
+	if err := Check(src); err != nil {
+		return errors.Errorf("%s:%s", name, err)
+	}
+	return nil
+}
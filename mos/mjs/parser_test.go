@@ -0,0 +1,66 @@
+package mjs
+
+import (
+	"testing"
+)
+
+func TestCheckValid(t *testing.T) {
+	cases := []string{
+		``,
+		`load('api_gpio.js');`,
+		"let a = 1\nlet b = a + 2 * (3 - 1)\n",
+		`let f = function(x, y) { return x === y ? x : y; };`,
+		`function g() { return }`,
+		`for (let i = 0; i < 10; i++) { if (i % 2 !== 0) continue; else break; }`,
+		`for (let k in obj) print(k, obj[k]);`,
+		`let o = {a: 1, 'b': [1, 2, ], default: {}, };`,
+		`while (true) { x += 0x10 >>> 1; }`,
+		`switch (x) { case 1: y = 2; break; default: y = typeof x; }`,
+		`Timer.set(1000, Timer.REPEAT, function() { GPIO.toggle(2); }, null);`,
+		`/* comment */ let s = "a\"b" + 'c\'d'; // comment`,
+		`let x = !a && (b || c) && -d;`,
+	}
+
+	for _, c := range cases {
+		if err := Check([]byte(c)); err != nil {
+			t.Errorf("%q: unexpected error: %s", c, err)
+		}
+	}
+}
+
+func TestCheckInvalid(t *testing.T) {
+	cases := []struct {
+		src       string
+		line, col int
+	}{
+		{"let a = ;", 1, 9},
+		{"let a = 1\nlet b = (a + 2;", 2, 15},
+		{"var a = 1;", 1, 1},
+		{"let a = new Foo();", 1, 9},
+		{"if (a == b) {}", 1, 7},
+		{"let s = `tpl`;", 1, 9},
+		{"let f = (a) => a;", 1, 13},
+		{"let a = 1 let b = 2", 1, 11},
+		{"function() {}", 1, 9},
+		{"let s = 'unterminated;", 1, 9},
+		{"{ let a = 1;", 1, 13},
+		{"try { f(); } catch (e) {}", 1, 1},
+		{"let o = {a 1};", 1, 12},
+	}
+
+	for _, c := range cases {
+		err := Check([]byte(c.src))
+		if err == nil {
+			t.Errorf("%q: expected an error", c.src)
+			continue
+		}
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: expected *SyntaxError, got %T", c.src, err)
+			continue
+		}
+		if serr.Line != c.line || serr.Col != c.col {
+			t.Errorf("%q: expected error at %d:%d, got %s", c.src, c.line, c.col, serr)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package mosignore implements .mosignore: the file in the app dir with
+// patterns of files which are not uploaded to the remote builder. The syntax
+// is a subset of .gitignore:
+//
+//	# comment
+//	*.bin      - matches at any level
+//	/notes     - matches only at the top level
+//	tmp/       - matches only dirs
+//	docs/**    - "**" matches any number of path components
+//	!keep.bin  - negation: un-ignores what earlier patterns ignored
+package mosignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// FileName is the name of the ignore file in the app dir.
+const FileName = ".mosignore"
+
+// DefaultPatterns are always applied before the ones from .mosignore: build
+// outputs, fetched deps and VCS dirs are never needed by the builder.
+var DefaultPatterns = []string{
+	"/build/",
+	"/deps/",
+	".git/",
+	".hg/",
+	".svn/",
+	".DS_Store",
+	"*.swp",
+	"*~",
+}
+
+type pattern struct {
+	glob     string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Matcher tells whether a path is ignored.
+type Matcher struct {
+	patterns []pattern
+}
+
+// New returns a matcher for the given patterns, in .mosignore syntax.
+func New(lines []string) *Matcher {
+	m := &Matcher{}
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		var p pattern
+		if strings.HasPrefix(l, "!") {
+			p.negate = true
+			l = l[1:]
+		}
+		if strings.HasSuffix(l, "/") {
+			p.dirOnly = true
+			l = strings.TrimSuffix(l, "/")
+		}
+		if strings.HasPrefix(l, "/") {
+			p.anchored = true
+			l = strings.TrimPrefix(l, "/")
+		} else if strings.Contains(l, "/") {
+			// As in .gitignore, a pattern with a slash in the middle is relative
+			// to the top level
+			p.anchored = true
+		}
+		if l == "" {
+			continue
+		}
+		p.glob = l
+		m.patterns = append(m.patterns, p)
+	}
+	return m
+}
+
+// Load returns the matcher with the default patterns followed by the ones
+// from .mosignore in the dir, if it exists.
+func Load(dir string) (*Matcher, error) {
+	lines := append([]string(nil), DefaultPatterns...)
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(lines), nil
+		}
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Annotatef(err, "reading %s", FileName)
+	}
+	return New(lines), nil
+}
+
+// Match returns true if the path, relative to the app dir and with forward
+// slashes, is ignored. Callers walking the tree should not descend into
+// ignored dirs; files in them are not matched against the dir's patterns.
+func (m *Matcher) Match(rel string, isDir bool) bool {
+	rel = strings.TrimPrefix(path.Clean(rel), "./")
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.negate == ignored && p.match(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func (p *pattern) match(rel string) bool {
+	if p.anchored {
+		return matchParts(strings.Split(p.glob, "/"), strings.Split(rel, "/"))
+	}
+	// Unanchored patterns match the base name
+	ok, _ := path.Match(p.glob, path.Base(rel))
+	return ok
+}
+
+// matchParts matches path components, with "**" matching any number of them.
+func matchParts(globs, parts []string) bool {
+	for len(globs) > 0 {
+		if globs[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(globs[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(globs[0], parts[0]); !ok {
+			return false
+		}
+		globs, parts = globs[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,41 @@
+package mosignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	m := New(append(DefaultPatterns,
+		"# comment",
+		"*.bin",
+		"!keep.bin",
+		"/notes.txt",
+		"tmp/",
+		"docs/**/*.pdf",
+	))
+	for _, c := range []struct {
+		rel   string
+		isDir bool
+		exp   bool
+	}{
+		{"build", true, true},
+		{"src/build", true, false},
+		{"deps", true, true},
+		{".git", true, true},
+		{"src/.git", true, true},
+		{"src/main.c", false, false},
+		{"fs/data.bin", false, true},
+		{"fs/keep.bin", false, false},
+		{"notes.txt", false, true},
+		{"src/notes.txt", false, false},
+		{"tmp", true, true},
+		{"src/tmp", true, true},
+		{"tmp", false, false},
+		{"docs/a.pdf", false, true},
+		{"docs/x/y/a.pdf", false, true},
+		{"docs/a.md", false, false},
+		{"src/main.c~", false, true},
+	} {
+		if got := m.Match(c.rel, c.isDir); got != c.exp {
+			t.Errorf("%q (dir %v): expected %v, got %v", c.rel, c.isDir, c.exp, got)
+		}
+	}
+}
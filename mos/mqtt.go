@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/mqttbroker"
+	"cesanta.com/mos/mqttsniff"
+	"github.com/cesanta/errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	mqttBrokerURL  = flag.String("mqtt-broker", "", "mos mqtt sniff: broker to connect to, mqtt://[user:pass@]host[:port] or mqtts://...; by default, the one configured on the device")
+	mqttListen     = flag.String("mqtt-listen", "", "mos mqtt sniff: instead of connecting to a broker, run a local one listening on this address, e.g. :1883")
+	mqttTopics     = flag.StringSlice("topic", nil, "mos mqtt sniff: topic filters of the messages to show, like devices/+/events; by default, all")
+	mqttRawPayload = flag.Bool("raw-payload", false, "mos mqtt sniff: don't pretty-print JSON payloads")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "mqtt-broker", "mqtt-listen", "topic", "raw-payload")
+}
+
+func mqttHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: sniff")
+	}
+	switch args[0] {
+	case "sniff":
+		return errors.Trace(mqttSniff(ctx))
+	default:
+		return errors.Errorf("unknown command %q, expected sniff", args[0])
+	}
+}
+
+// mqttSniff implements "mos mqtt sniff": it either subscribes to the topics
+// at the broker the device uses, which shows the messages published by all
+// clients, or runs a local broker, which also shows what the clients connect
+// and subscribe with.
+func mqttSniff(ctx context.Context) error {
+	sn := mqttsniff.New(os.Stdout)
+	sn.Filters = *mqttTopics
+	sn.Raw = *mqttRawPayload
+	if tsfSpec != "" {
+		sn.Timestamp = FormatTimestampNow
+	}
+
+	if *mqttListen != "" {
+		l, err := net.Listen("tcp", *mqttListen)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer l.Close()
+		b := mqttbroker.New()
+		b.OnPacket = sn.Packet
+		reportf("MQTT broker is listening on %s, point the device at it with:\n  mos config-set mqtt.enable=true mqtt.server=%s mqtt.ssl_ca_cert=", l.Addr(), getBrokerAddrForDevice(l.Addr()))
+		return errors.Trace(b.Serve(l))
+	}
+
+	broker, user, pass, err := getSniffBroker(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(fmt.Sprintf("mos-sniff-%d", time.Now().Unix()))
+	opts.SetUsername(user)
+	opts.SetPassword(pass)
+	if strings.HasPrefix(broker, "ssl://") {
+		tlsConfig, err := newClientTLSConfig()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	lost := make(chan error, 1)
+	opts.SetConnectionLostHandler(func(cli mqtt.Client, err error) {
+		lost <- err
+	})
+
+	reportf("Connecting to %s...", broker)
+	cli := mqtt.NewClient(opts)
+	if token := cli.Connect(); token.Wait() && token.Error() != nil {
+		return errors.Annotatef(token.Error(), "MQTT connect error")
+	}
+	defer cli.Disconnect(0)
+	filters := *mqttTopics
+	if len(filters) == 0 {
+		filters = []string{"#"}
+	}
+	for _, f := range filters {
+		token := cli.Subscribe(f, 0, func(cli mqtt.Client, msg mqtt.Message) {
+			sn.Publish("", msg.Topic(), msg.Qos(), msg.Retained(), msg.Payload())
+		})
+		if token.Wait() && token.Error() != nil {
+			return errors.Annotatef(token.Error(), "failed to subscribe to %s", f)
+		}
+	}
+	reportf("Subscribed to %s; only publishes are visible this way, use --mqtt-listen to see subscriptions too", strings.Join(filters, ", "))
+
+	select {
+	case err := <-lost:
+		return errors.Annotatef(err, "lost connection to the broker")
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// getSniffBroker returns the paho broker URL, the user and the password,
+// from --mqtt-broker or the device config.
+func getSniffBroker(ctx context.Context) (string, string, string, error) {
+	var server, user, pass string
+	tls := false
+	if *mqttBrokerURL != "" {
+		u, err := url.Parse(*mqttBrokerURL)
+		if err != nil || u.Host == "" {
+			return "", "", "", errors.Errorf("invalid --mqtt-broker %q, expected mqtt://host:port or mqtts://host:port", *mqttBrokerURL)
+		}
+		switch u.Scheme {
+		case "mqtt":
+		case "mqtts":
+			tls = true
+		default:
+			return "", "", "", errors.Errorf("unsupported --mqtt-broker scheme %q, expected mqtt or mqtts", u.Scheme)
+		}
+		server = u.Host
+		if u.User != nil {
+			user = u.User.Username()
+			pass, _ = u.User.Password()
+		}
+	} else {
+		devConn, err := createDevConn(ctx)
+		if err != nil {
+			return "", "", "", errors.Annotatef(err, "failed to get the broker from the device, use --mqtt-broker")
+		}
+		defer devConn.Disconnect(ctx)
+		devConf, err := devConn.GetConfig(ctx)
+		if err != nil {
+			return "", "", "", errors.Trace(err)
+		}
+		if server, err = devConf.Get("mqtt.server"); err != nil || server == "" {
+			return "", "", "", errors.Errorf("MQTT server is not configured on the device, use --mqtt-broker")
+		}
+		user, _ = devConf.Get("mqtt.user")
+		pass, _ = devConf.Get("mqtt.pass")
+		ca, _ := devConf.Get("mqtt.ssl_ca_cert")
+		tls = ca != ""
+	}
+
+	scheme, port := "tcp", "1883"
+	if tls {
+		scheme, port = "ssl", "8883"
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, port)
+	}
+	return scheme + "://" + server, user, pass, nil
+}
+
+// getBrokerAddrForDevice returns the address the devices on the LAN can
+// reach the local listener at.
+func getBrokerAddrForDevice(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return net.JoinHostPort(getOutboundIP(), port)
+}
+
+// getOutboundIP returns the IP address of the interface with the default
+// route, which is usually the one on the LAN.
+func getOutboundIP() string {
+	// No packets are sent, this only picks the outgoing interface
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
@@ -0,0 +1,133 @@
+package mqttbroker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// CACertFileName is the file of the CA which the devices have to trust.
+	CACertFileName = "ca.pem"
+	caKeyFileName  = "ca.key"
+)
+
+// NewTLSConfig returns the server TLS config with a certificate for the
+// given hosts, signed by the CA kept in dir. The CA is created on first use
+// and then reused, so that devices which trust it don't need to be updated.
+func NewTLSConfig(dir string, hosts []string) (*tls.Config, error) {
+	caCert, caKey, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tmpl, err := certTemplate("mos broker")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
+
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile, keyFile := filepath.Join(dir, CACertFileName), filepath.Join(dir, caKeyFileName)
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err == nil {
+		keyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		cb, _ := pem.Decode(certPEM)
+		kb, _ := pem.Decode(keyPEM)
+		if cb == nil || kb == nil {
+			return nil, nil, errors.Errorf("invalid CA in %s", dir)
+		}
+		cert, err := x509.ParseCertificate(cb.Bytes)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		key, err := x509.ParseECPrivateKey(kb.Bytes)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return cert, key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, nil, errors.Trace(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	tmpl, err := certTemplate("mos broker CA")
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	tmpl.NotAfter = tmpl.NotBefore.AddDate(10, 0, 0)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, errors.Trace(err)
+}
+
+func certTemplate(cn string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Devices may not know the time yet when they connect
+	now := time.Now().AddDate(0, 0, -1)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now,
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package mqttbroker
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqttbroker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := NewTLSConfig(dir, []string{"192.168.1.10", "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, CACertFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CA is reused
+	if _, err := NewTLSConfig(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if caPEM2, _ := ioutil.ReadFile(filepath.Join(dir, CACertFileName)); string(caPEM2) != string(caPEM) {
+		t.Errorf("CA was regenerated")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"192.168.1.10", "localhost"} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("%s: %s", host, err)
+		}
+	}
+}
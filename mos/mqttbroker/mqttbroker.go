@@ -0,0 +1,251 @@
+// Package mqttbroker is a minimal MQTT 3.1.1 broker for local development:
+// it keeps no sessions, delivers all messages with QoS 0 and accepts any
+// client.
+package mqttbroker
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang/glog"
+)
+
+// Broker routes messages between the clients connected to it.
+type Broker struct {
+	// OnPacket, if set, is called for every packet received from a client
+	// (fromClient is true) or sent to it, except pings.
+	OnPacket func(clientID string, fromClient bool, p packets.ControlPacket)
+
+	mu       sync.Mutex
+	clients  map[*client]bool
+	retained map[string]*packets.PublishPacket
+}
+
+// New returns a broker.
+func New() *Broker {
+	return &Broker{
+		clients:  map[*client]bool{},
+		retained: map[string]*packets.PublishPacket{},
+	}
+}
+
+type client struct {
+	b    *Broker
+	conn net.Conn
+	id   string
+
+	wmu  sync.Mutex
+	subs map[string]bool // Protected by b.mu
+}
+
+// Serve accepts connections on l until it's closed.
+func (b *Broker) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		go b.ServeConn(conn)
+	}
+}
+
+// ServeConn talks to the client on conn until it disconnects.
+func (b *Broker) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	c := &client{b: b, conn: conn, subs: map[string]bool{}}
+
+	p, err := packets.ReadPacket(conn)
+	if err != nil {
+		glog.V(1).Infof("%s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	cp, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		glog.V(1).Infof("%s: expected CONNECT, got %s", conn.RemoteAddr(), p)
+		return
+	}
+	c.id = cp.ClientIdentifier
+	b.packet(c, true, cp)
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ack.ReturnCode = cp.Validate()
+	if err := c.write(ack); err != nil || ack.ReturnCode != packets.Accepted {
+		return
+	}
+	if c.id == "" {
+		c.id = fmt.Sprintf("%s", conn.RemoteAddr())
+	}
+
+	b.mu.Lock()
+	b.clients[c] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+	}()
+
+	if err := c.serve(cp.Keepalive); err != nil {
+		glog.V(1).Infof("%s: %s", c.id, err)
+		if cp.WillFlag {
+			will := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			will.TopicName = cp.WillTopic
+			will.Payload = cp.WillMessage
+			will.Retain = cp.WillRetain
+			b.publish(will)
+		}
+	}
+}
+
+// serve handles packets of the client, returns nil after DISCONNECT.
+func (c *client) serve(keepalive uint16) error {
+	b := c.b
+	for {
+		if keepalive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(time.Duration(keepalive) * 1500 * time.Millisecond))
+		}
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, ok := p.(*packets.PingreqPacket); !ok {
+			b.packet(c, true, p)
+		}
+		switch p := p.(type) {
+		case *packets.PingreqPacket:
+			err = c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				err = c.write(ack)
+			case 2:
+				rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				rec.MessageID = p.MessageID
+				err = c.write(rec)
+			}
+			b.publish(p)
+		case *packets.PubrelPacket:
+			comp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			comp.MessageID = p.MessageID
+			err = c.write(comp)
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			var retained []*packets.PublishPacket
+			b.mu.Lock()
+			for _, t := range p.Topics {
+				c.subs[t] = true
+				// QoS 0 is all we deliver with
+				ack.ReturnCodes = append(ack.ReturnCodes, 0)
+				for topic, rp := range b.retained {
+					if TopicMatch(t, topic) {
+						retained = append(retained, rp)
+					}
+				}
+			}
+			b.mu.Unlock()
+			if err = c.write(ack); err == nil {
+				for _, rp := range retained {
+					if err = c.write(rp); err != nil {
+						break
+					}
+				}
+			}
+		case *packets.UnsubscribePacket:
+			b.mu.Lock()
+			for _, t := range p.Topics {
+				delete(c.subs, t)
+			}
+			b.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			err = c.write(ack)
+		case *packets.DisconnectPacket:
+			return nil
+		case *packets.PubackPacket, *packets.PubrecPacket, *packets.PubcompPacket:
+			// We only send QoS 0
+		default:
+			return errors.Errorf("unexpected packet %s", p)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+func (c *client) write(p packets.ControlPacket) error {
+	if _, ok := p.(*packets.PingrespPacket); !ok {
+		c.b.packet(c, false, p)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return errors.Trace(p.Write(c.conn))
+}
+
+func (b *Broker) packet(c *client, fromClient bool, p packets.ControlPacket) {
+	if b.OnPacket != nil {
+		b.OnPacket(c.id, fromClient, p)
+	}
+}
+
+// publish delivers the message to all subscribed clients.
+func (b *Broker) publish(p *packets.PublishPacket) {
+	out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	out.TopicName = p.TopicName
+	out.Payload = p.Payload
+
+	var targets []*client
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			rp := out.Copy()
+			rp.Retain = true
+			b.retained[p.TopicName] = rp
+		}
+	}
+	for c := range b.clients {
+		for f := range c.subs {
+			if TopicMatch(f, p.TopicName) {
+				targets = append(targets, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	for _, c := range targets {
+		if err := c.write(out); err != nil {
+			glog.V(1).Infof("%s: %s", c.id, err)
+		}
+	}
+}
+
+// TopicMatch returns whether the topic matches the filter, which may contain
+// "+" and "#" wildcards.
+func TopicMatch(filter, topic string) bool {
+	// Wildcards don't match topics like $SYS/...
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
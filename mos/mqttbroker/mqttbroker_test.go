@@ -0,0 +1,88 @@
+package mqttbroker
+
+import (
+	"net"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func TestTopicMatch(t *testing.T) {
+	for _, c := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/x", false},
+		{"+/b", "a/b", true},
+		{"a/b/c", "a/b", false},
+	} {
+		if got := TopicMatch(c.filter, c.topic); got != c.match {
+			t.Errorf("TopicMatch(%q, %q) = %v", c.filter, c.topic, got)
+		}
+	}
+}
+
+func connect(t *testing.T, b *Broker, id string) net.Conn {
+	conn, srv := net.Pipe()
+	go b.ServeConn(srv)
+	cp := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	cp.ProtocolName, cp.ProtocolVersion, cp.ClientIdentifier = "MQTT", 4, id
+	if err := cp.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	read(t, conn, packets.Connack)
+	return conn
+}
+
+func read(t *testing.T, conn net.Conn, typ byte) packets.ControlPacket {
+	p, err := packets.ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := packets.PacketNames[typ]; p.String()[:len(name)] != name {
+		t.Fatalf("expected %s, got %s", name, p)
+	}
+	return p
+}
+
+func TestBroker(t *testing.T) {
+	b := New()
+	var seen []string
+	done := make(chan bool, 10)
+	b.OnPacket = func(id string, fromClient bool, p packets.ControlPacket) {
+		if pp, ok := p.(*packets.PublishPacket); ok && fromClient {
+			seen = append(seen, id+":"+pp.TopicName)
+			done <- true
+		}
+	}
+	sub := connect(t, b, "sub")
+	s := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	s.MessageID, s.Topics, s.Qoss = 1, []string{"dev/+/state"}, []byte{1}
+	s.Write(sub)
+	read(t, sub, packets.Suback)
+
+	pub := connect(t, b, "pub")
+	go func() {
+		for _, topic := range []string{"dev/1/state", "dev/1/other"} {
+			p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			p.TopicName, p.Payload = topic, []byte(`{"on":true}`)
+			p.Write(pub)
+		}
+	}()
+	got := read(t, sub, packets.Publish).(*packets.PublishPacket)
+	if got.TopicName != "dev/1/state" || string(got.Payload) != `{"on":true}` {
+		t.Errorf("unexpected message %s %s", got.TopicName, got.Payload)
+	}
+	<-done
+	<-done
+	if len(seen) != 2 || seen[0] != "pub:dev/1/state" {
+		t.Errorf("unexpected packets seen: %q", seen)
+	}
+}
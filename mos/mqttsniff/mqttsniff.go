@@ -0,0 +1,129 @@
+// Package mqttsniff formats MQTT traffic for humans: publishes with their
+// payloads, JSON payloads pretty-printed, and, when the traffic goes through
+// the local broker, connects and subscriptions of the clients.
+package mqttsniff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"cesanta.com/mos/mqttbroker"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Sniffer prints the traffic.
+type Sniffer struct {
+	// Topic filters of the publishes to print; empty means all.
+	Filters []string
+	// Don't pretty-print JSON payloads.
+	Raw bool
+	// Timestamp, if set, returns the string to put before each message.
+	Timestamp func() string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New returns a sniffer printing to out.
+func New(out io.Writer) *Sniffer {
+	return &Sniffer{out: out}
+}
+
+// Publish prints the publish, if it matches the filters.
+func (s *Sniffer) Publish(from, topic string, qos byte, retain bool, payload []byte) {
+	if !s.match(topic) {
+		return
+	}
+	flags := fmt.Sprintf("qos %d", qos)
+	if retain {
+		flags += ", retained"
+	}
+	head := fmt.Sprintf("PUBLISH %s (%s, %d bytes)", topic, flags, len(payload))
+	if from != "" {
+		head = from + " " + head
+	}
+	s.print(head, s.formatPayload(payload))
+}
+
+// Packet prints the packet received from (fromClient is true) or sent to the
+// client by the local broker. Only publishes from clients are printed, so
+// that each message shows up once, and acknowledgements are skipped.
+func (s *Sniffer) Packet(clientID string, fromClient bool, p packets.ControlPacket) {
+	if !fromClient {
+		if ca, ok := p.(*packets.ConnackPacket); ok && ca.ReturnCode != packets.Accepted {
+			s.print(fmt.Sprintf("%s CONNACK: %s", clientID, packets.ConnackReturnCodes[ca.ReturnCode]), "")
+		}
+		return
+	}
+	switch p := p.(type) {
+	case *packets.ConnectPacket:
+		head := fmt.Sprintf("%s CONNECT (keepalive %ds", clientID, p.Keepalive)
+		if p.Username != "" {
+			head += ", user " + p.Username
+		}
+		if p.WillFlag {
+			head += ", will " + p.WillTopic
+		}
+		s.print(head+")", "")
+	case *packets.PublishPacket:
+		s.Publish(clientID, p.TopicName, p.Qos, p.Retain, p.Payload)
+	case *packets.SubscribePacket:
+		var topics []string
+		for i, t := range p.Topics {
+			topics = append(topics, fmt.Sprintf("%s (qos %d)", t, p.Qoss[i]))
+		}
+		s.print(fmt.Sprintf("%s SUBSCRIBE %s", clientID, strings.Join(topics, ", ")), "")
+	case *packets.UnsubscribePacket:
+		s.print(fmt.Sprintf("%s UNSUBSCRIBE %s", clientID, strings.Join(p.Topics, ", ")), "")
+	case *packets.DisconnectPacket:
+		s.print(fmt.Sprintf("%s DISCONNECT", clientID), "")
+	}
+}
+
+func (s *Sniffer) match(topic string) bool {
+	if len(s.Filters) == 0 {
+		return true
+	}
+	for _, f := range s.Filters {
+		if mqttbroker.TopicMatch(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPayload pretty-prints JSON, quotes other text and shows binary data
+// as hex.
+func (s *Sniffer) formatPayload(payload []byte) string {
+	if len(payload) == 0 {
+		return ""
+	}
+	if !s.Raw {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, payload, "  ", "  "); err == nil {
+			return "  " + buf.String()
+		}
+	}
+	if utf8.Valid(payload) {
+		return "  " + string(payload)
+	}
+	return fmt.Sprintf("  % x", payload)
+}
+
+func (s *Sniffer) print(head, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := ""
+	if s.Timestamp != nil {
+		ts = s.Timestamp()
+	}
+	fmt.Fprintf(s.out, "%s%s\n", ts, head)
+	if body != "" {
+		fmt.Fprintf(s.out, "%s\n", body)
+	}
+}
@@ -0,0 +1,33 @@
+package mqttsniff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func TestSniffer(t *testing.T) {
+	var out bytes.Buffer
+	s := New(&out)
+	s.Filters = []string{"devices/+/events"}
+
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sub.Topics, sub.Qoss = []string{"devices/esp32_1/config"}, []byte{1}
+	s.Packet("esp32_1", true, sub)
+	s.Publish("", "devices/esp32_1/events", 1, false, []byte(`{"temp":21.5}`))
+	s.Publish("", "devices/esp32_1/state", 0, true, []byte(`hidden`))
+	s.Publish("", "devices/esp32_2/events", 0, true, []byte{0xff, 0x01})
+
+	want := `esp32_1 SUBSCRIBE devices/esp32_1/config (qos 1)
+PUBLISH devices/esp32_1/events (qos 1, 13 bytes)
+  {
+    "temp": 21.5
+  }
+PUBLISH devices/esp32_2/events (qos 0, retained, 2 bytes)
+  ff 01
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 
+	"cesanta.com/mos/boards"
 	"cesanta.com/mos/dev"
 	"github.com/cesanta/errors"
 )
@@ -29,6 +30,17 @@ func flash(ctx context.Context, devConn *dev.DevConn) error {
 	return errors.NotImplementedf("flash: this build was built without flashing support")
 }
 
+func flashFirmware(ctx context.Context, devConn *dev.DevConn, fwname, port string) error {
+	return errors.NotImplementedf("flash: this build was built without flashing support")
+}
+
 func flashRead(ctx context.Context, devConn *dev.DevConn) error {
 	return errors.NotImplementedf("flash-read: this build was built without flashing support")
 }
+
+func wipeFlash(ctx context.Context, targets []string) error {
+	return errors.NotImplementedf("wipe: this build was built without flashing support")
+}
+
+func applyBoardFlashOpts(b *boards.Board) {
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/nvs"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	nvsSize = flag.String("nvs-size", "0x4000", "Size of the NVS partition")
+)
+
+func nvsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: gen, dump, set or rm")
+	}
+	switch args[0] {
+	case "gen":
+		if len(args) != 3 {
+			return errors.Errorf("usage: mos nvs gen <input.csv> <output.bin> [--nvs-size N]")
+		}
+		return errors.Trace(nvsGen(args[1], args[2]))
+	case "dump":
+		if len(args) != 2 {
+			return errors.Errorf("usage: mos nvs dump <nvs.bin>")
+		}
+		p, err := loadNVS(args[1])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(p.WriteCSV(os.Stdout))
+	case "set":
+		if len(args) != 6 {
+			return errors.Errorf("usage: mos nvs set <nvs.bin> <namespace> <key> <type> <value>")
+		}
+		e, err := nvs.NewEntry(args[2], args[3], args[4], args[5])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(nvsModify(args[1], func(p *nvs.Partition) error {
+			p.Set(e)
+			return nil
+		}))
+	case "rm":
+		if len(args) != 4 {
+			return errors.Errorf("usage: mos nvs rm <nvs.bin> <namespace> <key>")
+		}
+		return errors.Trace(nvsModify(args[1], func(p *nvs.Partition) error {
+			return p.Delete(args[2], args[3])
+		}))
+	}
+	return errors.Errorf("unknown command %q, expected gen, dump, set or rm", args[0])
+}
+
+func getNVSSize() (int, error) {
+	size, err := strconv.ParseUint(*nvsSize, 0, 32)
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid --nvs-size")
+	}
+	return int(size), nil
+}
+
+// genNVS generates the NVS partition image from the CSV file.
+func genNVS(csvFile string, size int) ([]byte, error) {
+	f, err := os.Open(csvFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	p, err := nvs.ReadCSV(f, filepath.Dir(csvFile))
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", csvFile)
+	}
+	data, err := p.Generate(size)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+func nvsGen(csvFile, outFile string) error {
+	size, err := getNVSSize()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := genNVS(csvFile, size)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(outFile, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Wrote %s (%d bytes)", outFile, len(data))
+	return nil
+}
+
+func loadNVS(fname string) (*nvs.Partition, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p, err := nvs.Parse(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", fname)
+	}
+	return p, nil
+}
+
+// nvsModify applies the change to the partition image and rewrites it,
+// keeping the size.
+func nvsModify(fname string, f func(p *nvs.Partition) error) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p, err := nvs.Parse(data)
+	if err != nil {
+		return errors.Annotatef(err, "%s", fname)
+	}
+	if err := f(p); err != nil {
+		return errors.Trace(err)
+	}
+	newData, err := p.Generate(len(data))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if bytes.Equal(data, newData) {
+		return nil
+	}
+	if err := ioutil.WriteFile(fname, newData, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Updated %s", fname)
+	return nil
+}
+
+// getNVSImage returns the NVS partition image to flash: either a binary
+// image, or generated from a CSV file.
+func getNVSImage(fname string, size int) ([]byte, error) {
+	if strings.ToLower(filepath.Ext(fname)) == ".csv" {
+		return genNVS(fname, size)
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(data) > size {
+		return nil, errors.Errorf("%s is larger (%d) than the NVS partition (%d)", fname, len(data), size)
+	}
+	return data, nil
+}
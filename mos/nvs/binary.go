@@ -0,0 +1,294 @@
+package nvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	PageSize = 4096
+
+	entrySize       = 32
+	entriesPerPage  = 126
+	firstEntryOff   = 64
+	bitmapOff       = 32
+	pageVersion2    = 0xfe
+	pageStateEmpty  = 0xffffffff
+	pageStateActive = 0xfffffffe
+	pageStateFull   = 0xfffffffc
+
+	typeString   = 0x21
+	typeBlob     = 0x41 // Version 1 blobs, read only
+	typeBlobData = 0x42
+	typeBlobIdx  = 0x48
+
+	noChunk = 0xff
+
+	entryStateWritten = 2
+	entryStateEmpty   = 3
+)
+
+// crc is the CRC used by NVS: CRC-32 (IEEE) with initial value 0xffffffff,
+// i.e. zlib.crc32(data, 0xffffffff).
+func crc(data ...[]byte) uint32 {
+	c := uint32(0xffffffff)
+	for _, d := range data {
+		c = crc32.Update(c, crc32.IEEETable, d)
+	}
+	return c
+}
+
+type pageWriter struct {
+	pages [][]byte
+	entry int
+}
+
+func (w *pageWriter) page() []byte {
+	return w.pages[len(w.pages)-1]
+}
+
+func (w *pageWriter) newPage() {
+	if len(w.pages) > 0 {
+		binary.LittleEndian.PutUint32(w.page()[0:], pageStateFull)
+	}
+	p := bytes.Repeat([]byte{0xff}, PageSize)
+	binary.LittleEndian.PutUint32(p[0:], pageStateActive)
+	binary.LittleEndian.PutUint32(p[4:], uint32(len(w.pages)))
+	p[8] = pageVersion2
+	binary.LittleEndian.PutUint32(p[28:], crc(p[4:28]))
+	w.pages = append(w.pages, p)
+	w.entry = 0
+}
+
+// free returns the number of free entries in the current page.
+func (w *pageWriter) free() int {
+	if len(w.pages) == 0 {
+		return 0
+	}
+	return entriesPerPage - w.entry
+}
+
+// write writes an entry, followed by span-1 entries of data, which must fit in
+// the current page.
+func (w *pageWriter) write(ns, typ, chunk byte, key string, data8 []byte, extra []byte) {
+	span := 1 + (len(extra)+entrySize-1)/entrySize
+	if w.free() < span {
+		w.newPage()
+	}
+	p := w.page()
+	e := p[firstEntryOff+w.entry*entrySize:]
+	e[0], e[1], e[2], e[3] = ns, typ, byte(span), chunk
+	k := make([]byte, 16)
+	copy(k, key)
+	copy(e[8:24], k)
+	copy(e[24:32], data8)
+	binary.LittleEndian.PutUint32(e[4:], crc(e[0:4], e[8:32]))
+	copy(e[entrySize:], extra)
+	for i := 0; i < span; i++ {
+		n := w.entry + i
+		p[bitmapOff+n/4] &^= 1 << uint((n%4)*2)
+	}
+	w.entry += span
+}
+
+// varLenHeader returns the data field of string and blob data entries.
+func varLenHeader(data []byte) []byte {
+	h := make([]byte, 8)
+	binary.LittleEndian.PutUint16(h[0:], uint16(len(data)))
+	binary.LittleEndian.PutUint16(h[2:], 0xffff)
+	binary.LittleEndian.PutUint32(h[4:], crc(data))
+	return h
+}
+
+// Generate returns the binary partition image of the given size, which must
+// be a multiple of PageSize. One page is always left empty, as NVS requires
+// it for garbage collection.
+func (p *Partition) Generate(size int) ([]byte, error) {
+	if size%PageSize != 0 || size < 2*PageSize {
+		return nil, errors.Errorf("invalid NVS partition size %d, must be a multiple of %d, at least 2 pages", size, PageSize)
+	}
+	w := &pageWriter{}
+	w.newPage()
+	nsIdx := map[string]byte{}
+	for _, e := range p.Entries {
+		idx, ok := nsIdx[e.Namespace]
+		if !ok {
+			if len(nsIdx) == 254 {
+				return nil, errors.Errorf("too many namespaces")
+			}
+			idx = byte(len(nsIdx) + 1)
+			nsIdx[e.Namespace] = idx
+			w.write(0, intTypes[TypeU8].code, noChunk, e.Namespace, []byte{idx}, nil)
+		}
+		switch e.Type {
+		case TypeString:
+			data := append(append([]byte(nil), e.Value...), 0)
+			w.write(idx, typeString, noChunk, e.Key, varLenHeader(data), data)
+		case TypeBlob:
+			data := e.Value
+			chunks := 0
+			for {
+				if w.free() < 2 {
+					w.newPage()
+				}
+				n := (w.free() - 1) * entrySize
+				if n > len(data) {
+					n = len(data)
+				}
+				w.write(idx, typeBlobData, byte(chunks), e.Key, varLenHeader(data[:n]), data[:n])
+				chunks++
+				data = data[n:]
+				if len(data) == 0 {
+					break
+				}
+			}
+			h := bytes.Repeat([]byte{0xff}, 8)
+			binary.LittleEndian.PutUint32(h[0:], uint32(len(e.Value)))
+			h[4], h[5] = byte(chunks), 0
+			w.write(idx, typeBlobIdx, noChunk, e.Key, h, nil)
+		default:
+			it, ok := intTypes[e.Type]
+			if !ok {
+				return nil, errors.Errorf("%s: unknown type %q", e.Key, e.Type)
+			}
+			w.write(idx, it.code, noChunk, e.Key, e.Value, nil)
+		}
+	}
+	if (len(w.pages)+1)*PageSize > size {
+		return nil, errors.Errorf("data needs %d pages, partition of %d bytes only fits %d (one page is reserved)",
+			len(w.pages), size, size/PageSize-1)
+	}
+	res := bytes.Join(w.pages, nil)
+	return append(res, bytes.Repeat([]byte{0xff}, size-len(res))...), nil
+}
+
+type rawEntry struct {
+	ns, typ, span, chunk byte
+	key                  string
+	data                 []byte
+	extra                []byte
+}
+
+// Parse reads the binary partition image. Erased entries are skipped; entries
+// with invalid CRCs result in an error.
+func Parse(data []byte) (*Partition, error) {
+	if len(data)%PageSize != 0 {
+		return nil, errors.Errorf("invalid size %d, must be a multiple of %d", len(data), PageSize)
+	}
+	type page struct {
+		seq     uint32
+		entries []*rawEntry
+	}
+	var pages []*page
+	for off := 0; off < len(data); off += PageSize {
+		p := data[off : off+PageSize]
+		state := binary.LittleEndian.Uint32(p[0:])
+		if state == pageStateEmpty {
+			continue
+		}
+		if binary.LittleEndian.Uint32(p[28:]) != crc(p[4:28]) {
+			return nil, errors.Errorf("page at 0x%x: invalid header CRC", off)
+		}
+		pg := &page{seq: binary.LittleEndian.Uint32(p[4:])}
+		for i := 0; i < entriesPerPage; {
+			st := (p[bitmapOff+i/4] >> uint((i%4)*2)) & 3
+			if st != entryStateWritten {
+				i++
+				continue
+			}
+			e := p[firstEntryOff+i*entrySize : firstEntryOff+(i+1)*entrySize]
+			re := &rawEntry{ns: e[0], typ: e[1], span: e[2], chunk: e[3], key: string(bytes.TrimRight(e[8:24], "\x00")), data: e[24:32]}
+			if re.span == 0 || i+int(re.span) > entriesPerPage {
+				return nil, errors.Errorf("page at 0x%x, entry %d: invalid span %d", off, i, re.span)
+			}
+			if binary.LittleEndian.Uint32(e[4:]) != crc(e[0:4], e[8:32]) {
+				return nil, errors.Errorf("page at 0x%x, entry %d (%s): invalid CRC", off, i, re.key)
+			}
+			end := firstEntryOff + (i+int(re.span))*entrySize
+			re.extra = p[firstEntryOff+(i+1)*entrySize : end]
+			pg.entries = append(pg.entries, re)
+			i += int(re.span)
+		}
+		pages = append(pages, pg)
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].seq < pages[j].seq })
+
+	var all []*rawEntry
+	for _, pg := range pages {
+		all = append(all, pg.entries...)
+	}
+
+	namespaces := map[byte]string{}
+	for _, re := range all {
+		if re.ns == 0 {
+			namespaces[re.data[0]] = re.key
+		}
+	}
+
+	res := &Partition{}
+	blobChunks := map[string]map[byte][]byte{}
+	for _, re := range all {
+		if re.ns == 0 {
+			continue
+		}
+		ns, ok := namespaces[re.ns]
+		if !ok {
+			return nil, errors.Errorf("%s: unknown namespace index %d", re.key, re.ns)
+		}
+		switch re.typ {
+		case typeString, typeBlob, typeBlobData:
+			size := int(binary.LittleEndian.Uint16(re.data[0:]))
+			if size > len(re.extra) {
+				return nil, errors.Errorf("%s: invalid size %d", re.key, size)
+			}
+			value := re.extra[:size]
+			if binary.LittleEndian.Uint32(re.data[4:]) != crc(value) {
+				return nil, errors.Errorf("%s: invalid data CRC", re.key)
+			}
+			switch re.typ {
+			case typeString:
+				res.Set(&Entry{Namespace: ns, Key: re.key, Type: TypeString, Value: bytes.TrimRight(value, "\x00")})
+			case typeBlob:
+				res.Set(&Entry{Namespace: ns, Key: re.key, Type: TypeBlob, Value: value})
+			default:
+				k := ns + "\x00" + re.key
+				if blobChunks[k] == nil {
+					blobChunks[k] = map[byte][]byte{}
+				}
+				blobChunks[k][re.chunk] = value
+			}
+		case typeBlobIdx:
+			size := binary.LittleEndian.Uint32(re.data[0:])
+			count, start := re.data[4], re.data[5]
+			chunks := blobChunks[ns+"\x00"+re.key]
+			var value []byte
+			for i := 0; i < int(count); i++ {
+				c, ok := chunks[start+byte(i)]
+				if !ok {
+					return nil, errors.Errorf("%s: missing blob chunk %d", re.key, int(start)+i)
+				}
+				value = append(value, c...)
+			}
+			if uint32(len(value)) != size {
+				return nil, errors.Errorf("%s: blob size mismatch: %d vs %d", re.key, len(value), size)
+			}
+			res.Set(&Entry{Namespace: ns, Key: re.key, Type: TypeBlob, Value: value})
+		default:
+			found := false
+			for typ, it := range intTypes {
+				if it.code == re.typ {
+					res.Set(&Entry{Namespace: ns, Key: re.key, Type: typ, Value: append([]byte(nil), re.data[:it.size]...)})
+					found = true
+				}
+			}
+			if !found {
+				return nil, errors.Errorf("%s: unknown entry type 0x%02x", re.key, re.typ)
+			}
+		}
+	}
+	return res, nil
+}
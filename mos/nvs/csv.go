@@ -0,0 +1,117 @@
+package nvs
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// ReadCSV parses the partition description in the nvs_partition_gen.py
+// format:
+//
+//	key,type,encoding,value
+//	storage,namespace,,
+//	serial,data,string,ABC123
+//	cert,file,string,certs/dev.crt
+//
+// Relative file paths are resolved against baseDir.
+func ReadCSV(r io.Reader, baseDir string) (*Partition, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &Partition{}
+	ns := ""
+	for i, row := range rows {
+		line := i + 1
+		if len(row) == 0 || (len(row) == 1 && row[0] == "") {
+			continue
+		}
+		for len(row) < 4 {
+			row = append(row, "")
+		}
+		key, typ, enc, value := row[0], row[1], row[2], row[3]
+		if i == 0 && key == "key" {
+			// Header
+			continue
+		}
+		switch typ {
+		case "namespace":
+			ns = key
+			continue
+		case "data", "file":
+		default:
+			return nil, errors.Errorf("line %d: unknown type %q, expected namespace, data or file", line, typ)
+		}
+		if ns == "" {
+			return nil, errors.Errorf("line %d: no namespace defined", line)
+		}
+		if typ == "file" {
+			fname := value
+			if !filepath.IsAbs(fname) {
+				fname = filepath.Join(baseDir, fname)
+			}
+			data, err := ioutil.ReadFile(fname)
+			if err != nil {
+				return nil, errors.Annotatef(err, "line %d", line)
+			}
+			value = string(data)
+			if enc == "hex2bin" || enc == "base64" {
+				value = strings.TrimSpace(value)
+			}
+		}
+		e, err := csvEntry(ns, key, enc, value)
+		if err != nil {
+			return nil, errors.Annotatef(err, "line %d", line)
+		}
+		p.Set(e)
+	}
+	return p, nil
+}
+
+func csvEntry(ns, key, enc, value string) (*Entry, error) {
+	switch enc {
+	case "hex2bin":
+		return NewEntry(ns, key, TypeBlob, value)
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s: invalid base64 value", key)
+		}
+		return NewEntry(ns, key, TypeBlob, hex.EncodeToString(data))
+	case "binary":
+		return NewEntry(ns, key, TypeBlob, hex.EncodeToString([]byte(value)))
+	}
+	return NewEntry(ns, key, enc, value)
+}
+
+// WriteCSV writes the partition description in the format accepted by
+// ReadCSV; blobs are written hex-encoded.
+func (p *Partition) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", "type", "encoding", "value"})
+	ns := ""
+	for _, e := range p.Entries {
+		if e.Namespace != ns {
+			ns = e.Namespace
+			cw.Write([]string{ns, "namespace", "", ""})
+		}
+		enc := e.Type
+		if enc == TypeBlob {
+			enc = "hex2bin"
+		}
+		cw.Write([]string{e.Key, "data", enc, e.String()})
+	}
+	cw.Flush()
+	return errors.Trace(cw.Error())
+}
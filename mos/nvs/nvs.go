@@ -0,0 +1,160 @@
+// Package nvs reads and writes ESP-IDF NVS (non-volatile storage) partitions
+// and their CSV descriptions, compatible with nvs_partition_gen.py (format
+// version 2, with multi-page blobs).
+package nvs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/cesanta/errors"
+)
+
+// Supported value types. Integers are stored in little-endian byte order.
+const (
+	TypeU8     = "u8"
+	TypeI8     = "i8"
+	TypeU16    = "u16"
+	TypeI16    = "i16"
+	TypeU32    = "u32"
+	TypeI32    = "i32"
+	TypeU64    = "u64"
+	TypeI64    = "i64"
+	TypeString = "string"
+	TypeBlob   = "blob"
+)
+
+const (
+	maxKeyLen    = 15
+	maxStringLen = 3999 // 4000 with the terminating NUL
+)
+
+type Entry struct {
+	Namespace string
+	Key       string
+	Type      string
+	// Value is the raw value: little-endian integer, string without the
+	// terminating NUL, or blob data.
+	Value []byte
+}
+
+type Partition struct {
+	Entries []*Entry
+}
+
+var intTypes = map[string]struct {
+	code   byte
+	size   int
+	signed bool
+}{
+	TypeU8:  {0x01, 1, false},
+	TypeI8:  {0x11, 1, true},
+	TypeU16: {0x02, 2, false},
+	TypeI16: {0x12, 2, true},
+	TypeU32: {0x04, 4, false},
+	TypeI32: {0x14, 4, true},
+	TypeU64: {0x08, 8, false},
+	TypeI64: {0x18, 8, true},
+}
+
+// NewEntry creates an entry, parsing the value given in the text form:
+// decimal (or 0x-prefixed hex) number for integers, hex for blobs.
+func NewEntry(ns, key, typ, value string) (*Entry, error) {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return nil, errors.Errorf("%s: key must be 1 to %d characters long", key, maxKeyLen)
+	}
+	if len(ns) == 0 || len(ns) > maxKeyLen {
+		return nil, errors.Errorf("%s: namespace must be 1 to %d characters long", ns, maxKeyLen)
+	}
+	e := &Entry{Namespace: ns, Key: key, Type: typ}
+	switch typ {
+	case TypeString:
+		if len(value) > maxStringLen {
+			return nil, errors.Errorf("%s: string is too long (%d), max is %d", key, len(value), maxStringLen)
+		}
+		e.Value = []byte(value)
+	case TypeBlob:
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s: invalid hex value", key)
+		}
+		e.Value = data
+	default:
+		it, ok := intTypes[typ]
+		if !ok {
+			return nil, errors.Errorf("%s: unknown type %q", key, typ)
+		}
+		var v uint64
+		if it.signed {
+			iv, err := strconv.ParseInt(value, 0, it.size*8)
+			if err != nil {
+				return nil, errors.Annotatef(err, "%s: invalid %s value", key, typ)
+			}
+			v = uint64(iv)
+		} else {
+			uv, err := strconv.ParseUint(value, 0, it.size*8)
+			if err != nil {
+				return nil, errors.Annotatef(err, "%s: invalid %s value", key, typ)
+			}
+			v = uv
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, v)
+		e.Value = buf[:it.size]
+	}
+	return e, nil
+}
+
+// String returns the value in the text form accepted by NewEntry.
+func (e *Entry) String() string {
+	switch e.Type {
+	case TypeString:
+		return string(e.Value)
+	case TypeBlob:
+		return hex.EncodeToString(e.Value)
+	}
+	it := intTypes[e.Type]
+	buf := make([]byte, 8)
+	copy(buf, e.Value)
+	v := binary.LittleEndian.Uint64(buf)
+	if it.signed {
+		// Sign-extend
+		shift := uint(64 - it.size*8)
+		return strconv.FormatInt(int64(v<<shift)>>shift, 10)
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// Get returns the entry with the given namespace and key, or nil.
+func (p *Partition) Get(ns, key string) *Entry {
+	for _, e := range p.Entries {
+		if e.Namespace == ns && e.Key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// Set adds the entry or replaces the existing one with the same namespace
+// and key.
+func (p *Partition) Set(e *Entry) {
+	for i, ee := range p.Entries {
+		if ee.Namespace == e.Namespace && ee.Key == e.Key {
+			p.Entries[i] = e
+			return
+		}
+	}
+	p.Entries = append(p.Entries, e)
+}
+
+// Delete removes the entry with the given namespace and key.
+func (p *Partition) Delete(ns, key string) error {
+	for i, e := range p.Entries {
+		if e.Namespace == ns && e.Key == key {
+			p.Entries = append(p.Entries[:i], p.Entries[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("%s.%s not found", ns, key)
+}
@@ -0,0 +1,143 @@
+package nvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testCSV = `key,type,encoding,value
+# Device identity
+storage,namespace,,
+serial,data,string,"ABC,123"
+count,data,u8,200
+offset,data,i32,-5
+big,data,u64,0x1122334455667788
+key,data,hex2bin,deadbeef
+b64,data,base64,aGVsbG8=
+wifi,namespace,,
+cert,file,string,cert.pem
+`
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), []byte("CERT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ReadCSV(strings.NewReader(testCSV), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ ns, key, typ, value string }{
+		{"storage", "serial", TypeString, "ABC,123"},
+		{"storage", "count", TypeU8, "200"},
+		{"storage", "offset", TypeI32, "-5"},
+		{"storage", "big", TypeU64, "1234605616436508552"},
+		{"storage", "key", TypeBlob, "deadbeef"},
+		{"storage", "b64", TypeBlob, "68656c6c6f"},
+		{"wifi", "cert", TypeString, "CERT"},
+	} {
+		e := p.Get(c.ns, c.key)
+		if e == nil {
+			t.Errorf("%s.%s not found", c.ns, c.key)
+			continue
+		}
+		if e.Type != c.typ || e.String() != c.value {
+			t.Errorf("%s.%s: got %s %q, want %s %q", c.ns, c.key, e.Type, e.String(), c.typ, c.value)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := p.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	p2, err := ReadCSV(buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, p2) {
+		t.Errorf("CSV round trip failed:\n%s", buf.String())
+	}
+
+	for _, bad := range []string{
+		"k,data,u8,1\n",
+		"ns,namespace,,\nk,data,u8,256\n",
+		"ns,namespace,,\nk,data,float,1\n",
+		"ns,namespace,,\nk,blah,u8,1\n",
+		"ns,namespace,,\nthis_key_is_too_long,data,u8,1\n",
+	} {
+		if _, err := ReadCSV(strings.NewReader(bad), ""); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	p := &Partition{}
+	add := func(ns, key, typ, value string) {
+		e, err := NewEntry(ns, key, typ, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Set(e)
+	}
+	add("storage", "u8", TypeU8, "1")
+	add("storage", "i16", TypeI16, "-300")
+	add("storage", "str", TypeString, "hello")
+	add("other", "i64", TypeI64, "-1")
+	// Large blob, spanning multiple pages
+	add("other", "blob", TypeBlob, strings.Repeat("0123456789abcdef", 700))
+	add("storage", "long", TypeString, strings.Repeat("x", 3999))
+
+	data, err := p.Generate(6 * PageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 6*PageSize {
+		t.Fatalf("unexpected size %d", len(data))
+	}
+	le := binary.LittleEndian
+	if le.Uint32(data[0:]) != pageStateFull || le.Uint32(data[PageSize+4:]) != 1 || data[8] != pageVersion2 {
+		t.Errorf("unexpected page header: %x", data[:32])
+	}
+	// First entry is the "storage" namespace with index 1
+	if data[64] != 0 || data[65] != 0x01 || string(data[72:79]) != "storage" || data[88] != 1 {
+		t.Errorf("unexpected first entry: %x", data[64:96])
+	}
+	if le.Uint32(data[5*PageSize:]) != pageStateEmpty {
+		t.Errorf("last page is not empty")
+	}
+
+	p2, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p2.Entries) != len(p.Entries) {
+		t.Fatalf("got %d entries, want %d", len(p2.Entries), len(p.Entries))
+	}
+	for _, e := range p.Entries {
+		e2 := p2.Get(e.Namespace, e.Key)
+		if e2 == nil || e2.Type != e.Type || !bytes.Equal(e2.Value, e.Value) {
+			t.Errorf("%s.%s: got %+v", e.Namespace, e.Key, e2)
+		}
+	}
+
+	if _, err := p.Generate(3 * PageSize); err == nil {
+		t.Errorf("expected an error for too small partition")
+	}
+
+	data[64+40] ^= 1
+	if _, err := Parse(data); err == nil {
+		t.Errorf("expected a CRC error")
+	}
+}
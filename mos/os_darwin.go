@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	zwebview "github.com/zserge/webview"
+	"golang.org/x/sys/unix"
 )
 
 func enumerateSerialPorts() []string {
@@ -39,3 +40,18 @@ func osSpecificInit() {
 func webview(url string) {
 	zwebview.Open("Mongoose OS Web UI", url, 1024, 480, true)
 }
+
+func serialDriverHint() string {
+	return "Connect the device; for CP210x adapters, install the Silicon Labs VCP driver, " +
+		"for CH340 ones, the WCH driver, and allow it in System Preferences > Security & Privacy"
+}
+
+func serialPortAccessFix(port string) string {
+	return fmt.Sprintf("Check the permissions of %s: ls -l %s", port, port)
+}
+
+// makeStdinRaw puts the terminal of stdin into the raw mode, see
+// makeRawTerminal.
+func makeStdinRaw() (func(), error) {
+	return makeRawTerminal(0, unix.TIOCGETA, unix.TIOCSETA)
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
 func enumerateSerialPorts() []string {
@@ -21,3 +26,44 @@ func osSpecificInit() {
 func webview(url string) {
 	fmt.Println("WebView for Linux is not yet supported.")
 }
+
+func serialDriverHint() string {
+	return "Connect the device; USB-UART adapters (CP210x, CH340, FTDI) are supported by the kernel, " +
+		"check \"dmesg\" for the ttyUSB/ttyACM device it gets"
+}
+
+// serialPortAccessFix returns what the user can do to get access to the
+// port: join the group which owns the device node.
+func serialPortAccessFix(port string) string {
+	group := "dialout"
+	var st unix.Stat_t
+	if err := unix.Stat(port, &st); err == nil {
+		if name := groupName(st.Gid); name != "" {
+			group = name
+		}
+	}
+	return fmt.Sprintf("Run \"mos setup-usb\", or add yourself to the %s group and log in again: sudo usermod -aG %s $USER", group, group)
+}
+
+// groupName returns the name of the group from /etc/group; user.LookupGroupId
+// doesn't work in static builds.
+func groupName(gid uint32) string {
+	data, err := ioutil.ReadFile("/etc/group")
+	if err != nil {
+		return ""
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		// name:password:gid:members
+		parts := strings.Split(l, ":")
+		if len(parts) >= 3 && parts[2] == strconv.FormatUint(uint64(gid), 10) {
+			return parts[0]
+		}
+	}
+	return ""
+}
+
+// makeStdinRaw puts the terminal of stdin into the raw mode, see
+// makeRawTerminal.
+func makeStdinRaw() (func(), error) {
+	return makeRawTerminal(0, unix.TCGETS, unix.TCSETS)
+}
@@ -2,6 +2,11 @@
 
 package main
 
+import (
+	"github.com/cesanta/errors"
+	"golang.org/x/sys/unix"
+)
+
 func getDefaultPort() string {
 	ports := enumerateSerialPorts()
 	if len(ports) == 0 {
@@ -9,3 +14,42 @@ func getDefaultPort() string {
 	}
 	return ports[0]
 }
+
+// diskFreeSpace returns the number of bytes available to the user on the disk
+// of the dir.
+func diskFreeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// checkSerialPortAccess returns an error if the port can't be opened for
+// reading and writing by the user.
+func checkSerialPortAccess(port string) error {
+	return errors.Trace(unix.Access(port, unix.R_OK|unix.W_OK))
+}
+
+// makeRawTerminal puts the terminal into the raw mode, in which keys are read
+// one by one, without echo and without interpretation of Ctrl-C and the
+// like, and returns the function which restores the previous mode. The
+// ioctl requests to get and set the mode are OS-specific.
+func makeRawTerminal(fd int, getReq, setReq uint) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, getReq)
+	if err != nil {
+		return nil, errors.Annotatef(err, "not a terminal")
+	}
+	t := *old
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, setReq, &t); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return func() { unix.IoctlSetTermios(fd, setReq, old) }, nil
+}
@@ -19,7 +19,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 
+	"github.com/cesanta/errors"
 	"golang.org/x/sys/windows/registry"
 
 	zwebview "github.com/zserge/webview"
@@ -93,3 +96,39 @@ func osSpecificInit() {
 func webview(url string) {
 	zwebview.Open("Mongoose OS Web UI", url, 1024, 480, true)
 }
+
+// diskFreeSpace returns the number of bytes available to the user on the disk
+// of the dir.
+func diskFreeSpace(dir string) (int64, error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var free int64
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	if r, _, err := proc.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0, errors.Trace(err)
+	}
+	return free, nil
+}
+
+// checkSerialPortAccess does nothing: COM ports are accessible to all users,
+// and opening a port to check would disturb a program using it.
+func checkSerialPortAccess(port string) error {
+	return nil
+}
+
+func serialDriverHint() string {
+	return "Connect the device and install the USB-UART driver for it (CP210x: Silicon Labs VCP, CH340: WCH, FTDI: VCP); " +
+		"it should appear as a COM port in the Device Manager"
+}
+
+func serialPortAccessFix(port string) string {
+	return ""
+}
+
+// makeStdinRaw is not supported on Windows: the console stays in the line
+// mode.
+func makeStdinRaw() (func(), error) {
+	return nil, errors.Errorf("raw terminal mode is not supported on Windows")
+}
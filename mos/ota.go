@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/attest"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/devreg"
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/guard"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+// Size of the firmware pieces sent with OTA.Write, before base64.
+const otaChunkSize = 2048
+
+var (
+	otaAttest       = flag.Bool("attest", false, "mos ota: before the update, check the device ID and firmware build ID against the device registry and refuse to update unknown or tampered devices")
+	otaAttestEnroll = flag.Bool("attest-enroll", false, "mos ota --attest: trust a device which is not enrolled yet and record its ID and firmware build ID in the device registry")
+	otaAttestPubKey = flag.String("attest-pubkey", "", "mos ota --attest-enroll: PEM file with the public key of the device's ATECC slot; the device must then sign a challenge with it")
+	otaAttestSlot   = flag.Int("attest-slot", 0, "mos ota --attest-enroll: ATECC slot of the key given with --attest-pubkey")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "attest", "attest-enroll", "attest-pubkey", "attest-slot")
+}
+
+// otaHandler implements "mos ota [--attest] [fw.zip]": the fw zip is sent to
+// the device with OTA.Begin, OTA.Write and OTA.End, and "mos ota publish
+// [fw.zip] --to ...": the fw zip is uploaded to a bucket, and the device is
+// told to download it with OTA.Update.
+func otaHandler(ctx context.Context, _ *dev.DevConn) error {
+	args := flag.Args()[1:]
+	publish := len(args) > 0 && args[0] == "publish"
+	if publish {
+		args = args[1:]
+	}
+	fwFile := *firmware
+	switch len(args) {
+	case 0:
+	case 1:
+		fwFile = args[0]
+	default:
+		return errors.Errorf("usage: mos ota [--attest] [fw.zip], or mos ota publish [fw.zip] --to s3://bucket/path|gs://bucket/path")
+	}
+	data, err := ioutil.ReadFile(fwFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fw, err := common.NewZipFirmwareBundle(fwFile)
+	if err != nil {
+		return errors.Annotatef(err, "failed to load %s", fwFile)
+	}
+	defer fw.Cleanup()
+
+	fwURL := ""
+	if publish {
+		if fwURL, err = otaPublish(fwFile); err != nil {
+			return errors.Trace(err)
+		}
+		if *otaNoUpdate {
+			return nil
+		}
+	}
+
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := guardOperation(guard.OpOTA, port, "update the firmware to "+fw.Name+" "+fw.Version); err != nil {
+		return errors.Trace(err)
+	}
+
+	fwFileAbs, err := filepath.Abs(fwFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hookEnv := map[string]string{
+		"MOS_PLATFORM": fw.Platform,
+		"MOS_FW_ZIP":   fwFileAbs,
+		"MOS_PORT":     port,
+	}
+	if fwURL != "" {
+		hookEnv["MOS_FW_URL"] = fwURL
+	}
+	if err := runHooks(hookPreOTA, hookEnv, os.Stderr); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Only connect now, publishing alone doesn't need the device
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	var att *otaAttestation
+	if *otaAttest {
+		if att, err = attestDevice(ctx, devConn, port); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if publish {
+		argsJSON, err := json.Marshal(map[string]string{"url": fwURL})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := callDeviceService(ctx, devConn, "OTA.Update", string(argsJSON)); err != nil {
+			return errors.Annotatef(err, "OTA.Update")
+		}
+	} else if err := otaPush(ctx, devConn, data); err != nil {
+		return errors.Trace(err)
+	}
+
+	if att != nil {
+		// The device may run either firmware next time, depending on whether
+		// the update is committed.
+		att.dev.Attestation.FWIDs = []string{att.fwID}
+		if fw.BuildID == "" {
+			ourutil.Reportf("Warning: %s has no build ID, the device will fail attestation after the update until enrolled again", fwFile)
+		} else if fw.BuildID != att.fwID {
+			att.dev.Attestation.FWIDs = append(att.dev.Attestation.FWIDs, fw.BuildID)
+		}
+		if err := att.reg.Save(att.regFile); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err := runHooks(hookPostOTA, hookEnv, os.Stderr); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Update is started, the device will boot %s %s; unless the firmware commits itself, commit it with \"mos call OTA.Commit\"", fw.Name, fw.Version)
+	return nil
+}
+
+func otaPush(ctx context.Context, devConn *dev.DevConn, data []byte) error {
+	call := func(method string, args interface{}) error {
+		argsJSON, err := json.Marshal(args)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = callDeviceService(ctx, devConn, method, string(argsJSON))
+		return errors.Annotatef(err, "%s", method)
+	}
+
+	ourutil.Reportf("Sending %d bytes...", len(data))
+	if err := call("OTA.Begin", map[string]interface{}{"total_size": len(data)}); err != nil {
+		return errors.Trace(err)
+	}
+	lastPct := 0
+	for off := 0; off < len(data); off += otaChunkSize {
+		end := off + otaChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := call("OTA.Write", map[string]string{"data": base64.StdEncoding.EncodeToString(data[off:end])}); err != nil {
+			return errors.Trace(err)
+		}
+		if pct := end * 100 / len(data); pct/10 > lastPct/10 {
+			ourutil.Reportf("  %d%%", pct)
+			lastPct = pct
+		}
+	}
+	return errors.Trace(call("OTA.End", map[string]interface{}{}))
+}
+
+// otaAttestation is the device which passed attestation.
+type otaAttestation struct {
+	reg     *devreg.Registry
+	regFile string
+	dev     *devreg.Device
+	fwID    string
+}
+
+// attestDevice checks the identity and the key of the device at the port
+// against the device registry. A device which is not enrolled yet is only
+// trusted with --attest-enroll.
+func attestDevice(ctx context.Context, devConn *dev.DevConn, port string) (*otaAttestation, error) {
+	reg, regFile, err := loadDeviceRegistry()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// --port is either the name of a registered device, or its port
+	d := reg.Get(*portFlag)
+	if d == nil {
+		d = reg.GetByPort(port)
+	}
+	if d == nil {
+		return nil, errors.Annotatef(attest.ErrUnknown, "%s is not in the device registry, add it with \"mos devices add\"", port)
+	}
+
+	ident, err := getDeviceIdentity(ctx, devConn)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s: attestation failed", d.Name)
+	}
+
+	if d.Attestation == nil {
+		if !*otaAttestEnroll {
+			return nil, errors.Annotatef(attest.ErrUnknown, "%s is not enrolled, check it and run with --attest-enroll to trust it", d.Name)
+		}
+		a := &devreg.Attestation{ID: ident.ID, Slot: *otaAttestSlot, FWIDs: []string{ident.FWID}}
+		if *otaAttestPubKey != "" {
+			pubKey, err := ioutil.ReadFile(*otaAttestPubKey)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			a.PubKey = string(pubKey)
+		}
+		// The device has to hold the key already
+		if err := checkDeviceKey(ctx, devConn, a); err != nil {
+			return nil, errors.Annotatef(err, "%s: refusing to enroll", d.Name)
+		}
+		d.Attestation = a
+		ourutil.Reportf("%s: enrolled with ID %s, firmware %s", d.Name, ident.ID, ident.FWID)
+	} else {
+		a := d.Attestation
+		if err := attest.Check(ident, a.ID, a.FWIDs); err != nil {
+			return nil, errors.Annotatef(err, "%s: refusing to update", d.Name)
+		}
+		if err := checkDeviceKey(ctx, devConn, a); err != nil {
+			return nil, errors.Annotatef(err, "%s: refusing to update", d.Name)
+		}
+		ourutil.Reportf("%s: attestation OK", d.Name)
+	}
+	return &otaAttestation{reg: reg, regFile: regFile, dev: d, fwID: ident.FWID}, nil
+}
+
+// getDeviceIdentity returns device.id from the device config and the ID of
+// the firmware from Sys.GetInfo.
+func getDeviceIdentity(ctx context.Context, devConn *dev.DevConn) (*attest.Identity, error) {
+	devConf, err := devConn.GetConfig(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	id, err := devConf.Get("device.id")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if id == "" {
+		return nil, errors.Errorf("device.id is not set")
+	}
+	info, err := devConn.GetInfo(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if info.Fw_id == nil || *info.Fw_id == "" {
+		return nil, errors.Errorf("Sys.GetInfo doesn't report fw_id")
+	}
+	return &attest.Identity{ID: id, FWID: *info.Fw_id}, nil
+}
+
+// checkDeviceKey has the device sign a random challenge with the ATECC key of
+// the attestation, if there is one, and verifies the signature.
+func checkDeviceKey(ctx context.Context, devConn *dev.DevConn, a *devreg.Attestation) error {
+	if a.PubKey == "" {
+		return nil
+	}
+	challenge, err := attest.NewChallenge()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	argsJSON, err := json.Marshal(attest.NewSignArgs(challenge, a.Slot))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	res, err := callDeviceService(ctx, devConn, attest.SignMethod, string(argsJSON))
+	if err != nil {
+		return errors.Annotatef(err, "%s", attest.SignMethod)
+	}
+	var r attest.SignResult
+	if err := json.Unmarshal([]byte(res), &r); err != nil {
+		return errors.Annotatef(err, "invalid %s response", attest.SignMethod)
+	}
+	return errors.Trace(attest.CheckSignature(challenge, &r, a.PubKey))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cesanta.com/common/go/ourutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	otaPublishTo  = flag.String("to", "", "mos ota publish: where to upload the firmware, s3://bucket/path or gs://bucket/path; a path ending with / is a dir")
+	otaURLTTL     = flag.Duration("url-ttl", time.Hour, "mos ota publish: how long the signed download URL stays valid")
+	otaGCSKeyFile = flag.String("gcs-key-file", "", "mos ota publish: service account key file to sign gs:// URLs with; by default, gcloud signs with the active account")
+	otaNoUpdate   = flag.Bool("no-update", false, "mos ota publish: only upload the firmware and print the URL, don't call OTA.Update on the device")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "to", "url-ttl", "gcs-key-file", "no-update")
+}
+
+// otaPublish uploads the firmware to the bucket given with --to and returns
+// the signed URL it can be downloaded from, valid for --url-ttl.
+func otaPublish(fwFile string) (string, error) {
+	if *otaPublishTo == "" {
+		return "", errors.Errorf("--to is required, e.g. --to s3://bucket/path/")
+	}
+	u, err := url.Parse(*otaPublishTo)
+	if err != nil || u.Host == "" {
+		return "", errors.Errorf("invalid --to %q, expected s3://bucket/path or gs://bucket/path", *otaPublishTo)
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		key = path.Join(key, filepath.Base(fwFile))
+	}
+
+	var signedURL string
+	switch u.Scheme {
+	case "s3":
+		signedURL, err = otaPublishS3(fwFile, bucket, key)
+	case "gs":
+		signedURL, err = otaPublishGCS(fwFile, bucket, key)
+	default:
+		return "", errors.Errorf("unsupported storage %q, expected s3 or gs", u.Scheme)
+	}
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	ourutil.Reportf("Signed URL, valid for %s:\n%s", *otaURLTTL, signedURL)
+	return signedURL, nil
+}
+
+func otaPublishS3(fwFile, bucket, key string) (string, error) {
+	data, err := ioutil.ReadFile(fwFile)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sess, cfg, err := getAwsConfig()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	svc := s3.New(sess, cfg)
+
+	ourutil.Reportf("Uploading %s to s3://%s/%s...", fwFile, bucket, key)
+	if _, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/zip"),
+	}); err != nil {
+		return "", errors.Annotatef(err, "failed to upload")
+	}
+
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	signedURL, err := req.Presign(*otaURLTTL)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to sign the URL")
+	}
+	return signedURL, nil
+}
+
+// otaPublishGCS uses gcloud, like the rest of the GCP support.
+func otaPublishGCS(fwFile, bucket, key string) (string, error) {
+	obj := fmt.Sprintf("gs://%s/%s", bucket, key)
+	ourutil.Reportf("Uploading %s to %s...", fwFile, obj)
+	if _, err := getCommandOutput("gcloud", "storage", "cp", fwFile, obj); err != nil {
+		return "", errors.Annotatef(err, "failed to upload")
+	}
+
+	args := []string{
+		"storage", "sign-url", obj,
+		fmt.Sprintf("--duration=%ds", int(otaURLTTL.Seconds())),
+		"--format=value(signed_url)",
+	}
+	if *otaGCSKeyFile != "" {
+		args = append(args, "--private-key-file="+*otaGCSKeyFile)
+	}
+	out, err := getCommandOutput("gcloud", args...)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to sign the URL")
+	}
+	signedURL := strings.TrimSpace(out)
+	if !strings.HasPrefix(signedURL, "https://") {
+		return "", errors.Errorf("unexpected gcloud output: %s", out)
+	}
+	return signedURL, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/flash/common"
+	"cesanta.com/mos/ptable"
+	"github.com/cesanta/errors"
+)
+
+// readProjectPartitionTable reads the custom partition table from the
+// manifest in the project dir, if any.
+func readProjectPartitionTable() (*build.PartitionTableOpts, error) {
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return manifest.PartitionTable, nil
+}
+
+func newPartitionTable(opts *build.PartitionTableOpts) (*ptable.Table, uint32, error) {
+	t := &ptable.Table{}
+	for _, e := range opts.Partitions {
+		p, err := ptable.NewPartition(e.Name, e.Type, e.SubType, e.Offset, e.Size, e.Flags)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		t.Partitions = append(t.Partitions, p)
+	}
+	t.Resolve()
+
+	flashSize := uint32(0)
+	if opts.FlashSize != "" {
+		var err error
+		if flashSize, err = ptable.ParseNumber(opts.FlashSize); err != nil {
+			return nil, 0, errors.Annotatef(err, "flash_size")
+		}
+	}
+	if err := t.Validate(flashSize); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	return t, flashSize, nil
+}
+
+// applyPartitionTable replaces the partition table of the built firmware with
+// the one from the manifest: fw parts are checked to fit in the partitions
+// with the same names or at the same addresses, and are moved to the
+// partitions they are matched by name with. The table is also saved as
+// partitions.csv in the build dir.
+func applyPartitionTable(fwFilename string, buildDir string) error {
+	opts, err := readProjectPartitionTable()
+	if err != nil || opts == nil {
+		return errors.Trace(err)
+	}
+	fw, err := common.NewZipFirmwareBundle(fwFilename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if strings.ToLower(fw.Platform) != "esp32" {
+		return errors.Errorf("partition_table is only supported on ESP32, not %s", fw.Platform)
+	}
+
+	t, flashSize, err := newPartitionTable(opts)
+	if err != nil {
+		return errors.Annotatef(err, "invalid partition_table")
+	}
+
+	csv := &bytes.Buffer{}
+	t.WriteCSV(csv)
+	if err := ioutil.WriteFile(filepath.Join(buildDir, "partitions.csv"), csv.Bytes(), 0644); err != nil {
+		return errors.Trace(err)
+	}
+
+	var ptPart *common.FirmwarePart
+	for name, p := range fw.Parts {
+		if p.ESPFlashAddress == ptable.Offset {
+			ptPart = p
+			continue
+		}
+		if p.ESPFlashAddress < ptable.Offset {
+			// Bootloader
+			continue
+		}
+		data, err := fw.GetPartData(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		size := uint32(len(data))
+		part := t.Get(name)
+		if part == nil {
+			part = t.At(p.ESPFlashAddress)
+		}
+		if part == nil {
+			for _, pp := range t.Partitions {
+				if p.ESPFlashAddress < pp.End() && pp.Offset < p.ESPFlashAddress+size {
+					freportf(logWriterStderr, "Warning: part %s (0x%x-0x%x) overlaps partition %s (0x%x-0x%x)",
+						name, p.ESPFlashAddress, p.ESPFlashAddress+size, pp.Name, pp.Offset, pp.End())
+				}
+			}
+			if flashSize > 0 && p.ESPFlashAddress+size > flashSize {
+				return errors.Errorf("part %s (0x%x-0x%x) doesn't fit in flash", name, p.ESPFlashAddress, p.ESPFlashAddress+size)
+			}
+			continue
+		}
+		if size > part.Size {
+			return errors.Errorf("part %s (%d bytes) doesn't fit in partition %s (%d bytes)", name, size, part.Name, part.Size)
+		}
+		if p.ESPFlashAddress != part.Offset {
+			freportf(logWriter, "Moving %s from 0x%x to 0x%x", name, p.ESPFlashAddress, part.Offset)
+			p.ESPFlashAddress = part.Offset
+		}
+	}
+
+	if ptPart == nil {
+		ptPart = &common.FirmwarePart{Name: "pt", Src: "partitions.bin", ESPFlashAddress: ptable.Offset}
+		fw.Parts["pt"] = ptPart
+	}
+	ptData := t.Binary()
+	digest := sha1.Sum(ptData)
+	ptPart.ChecksumSHA1 = hex.EncodeToString(digest[:])
+	ptPart.Size = uint32(len(ptData))
+	fw.Blobs[ptPart.Src] = ptData
+
+	if err := common.UpdateZipFirmwareBundle(fwFilename, fw); err != nil {
+		return errors.Trace(err)
+	}
+	freportf(logWriter, "Applied custom partition table with %d partitions", len(t.Partitions))
+	return nil
+}
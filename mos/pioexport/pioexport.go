@@ -0,0 +1,175 @@
+// Package pioexport generates the platformio.ini of a mos project, so that
+// it can be opened in PlatformIO tooling.
+package pioexport
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// ININame is the name of the PlatformIO project file.
+const ININame = "platformio.ini"
+
+// ScriptName is the name of the extra script which passes C++ flags, which
+// platformio.ini has no option for.
+const ScriptName = "mos_flags.py"
+
+// MarkerName is the name of the file export leaves in the output dir, so that
+// the dir can be told from other dirs and replaced by the next export.
+const MarkerName = ".mos_export"
+
+// Project is what platformio.ini is generated from.
+type Project struct {
+	// Env name, the app name.
+	Name string
+	// mos platform, like "esp32".
+	Platform string
+	// PlatformIO board; the default one of the platform if empty.
+	Board string
+	// Flags for both C and C++, including -D and -I.
+	BuildFlags []string
+	// Flags for C++ only; if any, ScriptName must be generated as well (see
+	// WriteScript).
+	CXXFlags []string
+	// Entries of lib_deps.
+	LibDeps []string
+	// Libs which can't be expressed as lib_deps, listed in comments.
+	UnresolvedLibs []string
+	// mos build vars, saved as a custom option.
+	BuildVars map[string]string
+}
+
+type pioPlatform struct {
+	platform, board, framework string
+}
+
+var platforms = map[string]pioPlatform{
+	"esp32":   {"espressif32", "esp32dev", "espidf"},
+	"esp8266": {"espressif8266", "esp12e", "esp8266-rtos-sdk"},
+	"stm32":   {"ststm32", "nucleo_f746zg", "stm32cube"},
+}
+
+// Supported returns whether PlatformIO has a counterpart of the mos
+// platform.
+func Supported(platform string) bool {
+	_, ok := platforms[platform]
+	return ok
+}
+
+// LibDep returns the lib_deps entry for a lib given by location and
+// version: a git URL with the version as the ref, or a symlink for a local
+// dir. Empty string is returned for libs without a location.
+func LibDep(location, version string) string {
+	if location == "" {
+		return ""
+	}
+	if i := strings.Index(location, ":"); i > 0 && strings.Contains(location[:i], "@") {
+		// scp-like git location: git@host:path
+		return withRef(location, version)
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Local path, possibly with a Windows drive letter
+		return "symlink://" + location
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		if !strings.HasSuffix(location, ".git") && !strings.HasSuffix(location, ".zip") &&
+			!strings.HasSuffix(location, ".tar.gz") && !strings.HasSuffix(location, ".tgz") {
+			location += ".git"
+		}
+	}
+	return withRef(location, version)
+}
+
+// withRef appends the version to the git location as the ref to check out.
+func withRef(location, version string) string {
+	if version != "" && version != "latest" && strings.HasSuffix(location, ".git") {
+		location += "#" + version
+	}
+	return location
+}
+
+// BuildFlags returns build_flags for the C flags, defines and include dirs.
+func BuildFlags(cflags []string, cdefs map[string]string, includes []string) []string {
+	res := append([]string{}, cflags...)
+	names := make([]string, 0, len(cdefs))
+	for k := range cdefs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		res = append(res, fmt.Sprintf("-D%s=%s", k, cdefs[k]))
+	}
+	for _, inc := range includes {
+		res = append(res, "-I"+inc)
+	}
+	return res
+}
+
+// WriteINI writes platformio.ini for the project. Sources are expected in
+// the src dir next to it.
+func WriteINI(w io.Writer, p *Project) error {
+	pp, ok := platforms[p.Platform]
+	if !ok {
+		return errors.Errorf("platform %q is not supported by PlatformIO", p.Platform)
+	}
+	board := p.Board
+	if board == "" {
+		board = pp.board
+	}
+
+	fmt.Fprintf(w, "; Generated by \"mos export --format platformio\" from mos.yml;\n")
+	fmt.Fprintf(w, "; mos.yml remains the source of truth.\n\n")
+	fmt.Fprintf(w, "[platformio]\ndefault_envs = %s\n\n", p.Name)
+	fmt.Fprintf(w, "[env:%s]\n", p.Name)
+	fmt.Fprintf(w, "platform = %s\n", pp.platform)
+	fmt.Fprintf(w, "board = %s\n", board)
+	fmt.Fprintf(w, "framework = %s\n", pp.framework)
+	writeList(w, "build_flags", p.BuildFlags)
+	if len(p.CXXFlags) > 0 {
+		fmt.Fprintf(w, "extra_scripts = pre:%s\n", ScriptName)
+	}
+	writeList(w, "lib_deps", p.LibDeps)
+	for _, l := range p.UnresolvedLibs {
+		fmt.Fprintf(w, "; lib %s has no location and is not exported\n", l)
+	}
+	if len(p.BuildVars) > 0 {
+		names := make([]string, 0, len(p.BuildVars))
+		for k := range p.BuildVars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var vars []string
+		for _, k := range names {
+			vars = append(vars, fmt.Sprintf("%s=%s", k, p.BuildVars[k]))
+		}
+		writeList(w, "custom_mos_build_vars", vars)
+	}
+	return nil
+}
+
+func writeList(w io.Writer, name string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s =\n", name)
+	for _, it := range items {
+		fmt.Fprintf(w, "    %s\n", it)
+	}
+}
+
+// WriteScript writes the extra script adding the C++ flags.
+func WriteScript(w io.Writer, cxxflags []string) error {
+	var quoted []string
+	for _, f := range cxxflags {
+		quoted = append(quoted, fmt.Sprintf("%q", f))
+	}
+	_, err := fmt.Fprintf(w, "# Generated by \"mos export --format platformio\".\nImport(\"env\")\nenv.Append(CXXFLAGS=[%s])\n",
+		strings.Join(quoted, ", "))
+	return err
+}
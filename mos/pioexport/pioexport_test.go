@@ -0,0 +1,56 @@
+package pioexport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLibDep(t *testing.T) {
+	for _, c := range []struct {
+		location, version, expected string
+	}{
+		{"https://github.com/mongoose-os-libs/rpc-common", "2.20.0", "https://github.com/mongoose-os-libs/rpc-common.git#2.20.0"},
+		{"https://github.com/foo/bar.git", "latest", "https://github.com/foo/bar.git"},
+		{"https://example.com/bar.zip", "1.0", "https://example.com/bar.zip"},
+		{"git@github.com:foo/bar.git", "v2", "git@github.com:foo/bar.git#v2"},
+		{"ssh://git@github.com/foo/bar.git", "v1", "ssh://git@github.com/foo/bar.git#v1"},
+		{"../libs/foo", "", "symlink://../libs/foo"},
+		{"", "1.0", ""},
+	} {
+		if res := LibDep(c.location, c.version); res != c.expected {
+			t.Errorf("%q %q: expected %q, got %q", c.location, c.version, c.expected, res)
+		}
+	}
+}
+
+func TestWriteINI(t *testing.T) {
+	p := &Project{
+		Name:       "app",
+		Platform:   "esp32",
+		BuildFlags: BuildFlags([]string{"-Wall"}, map[string]string{"B": "2", "A": "1"}, []string{"include/src"}),
+		CXXFlags:   []string{"-fno-rtti"},
+		LibDeps:    []string{"https://github.com/mongoose-os-libs/ca-bundle.git"},
+		BuildVars:  map[string]string{"FOO": "bar"},
+	}
+	var b bytes.Buffer
+	if err := WriteINI(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"[env:app]\nplatform = espressif32\nboard = esp32dev\nframework = espidf\n",
+		"build_flags =\n    -Wall\n    -DA=1\n    -DB=2\n    -Iinclude/src\n",
+		"extra_scripts = pre:mos_flags.py\n",
+		"lib_deps =\n    https://github.com/mongoose-os-libs/ca-bundle.git\n",
+		"custom_mos_build_vars =\n    FOO=bar\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in:\n%s", s, b.String())
+		}
+	}
+
+	p.Platform = "cc3200"
+	if err := WriteINI(&b, p); err == nil {
+		t.Errorf("expected an error for an unsupported platform")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/portshare"
+	"github.com/cesanta/errors"
+	"github.com/cesanta/go-serial/serial"
+)
+
+const (
+	portShareDir = "~/.mos/ports"
+
+	// After flashing, the port may take a while to reappear.
+	portReopenTimeout = 10 * time.Second
+)
+
+func registerPortHolder(port string, suspend, resume func() error) (*portshare.Holder, error) {
+	dir, err := paths.NormalizePath(portShareDir, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return portshare.Register(dir, port, suspend, resume)
+}
+
+// suspendPortHolder asks the process holding the port, like mos console in
+// another terminal, to release it. The returned function lets it take the
+// port back.
+func suspendPortHolder(port string) (func(), error) {
+	dir, err := paths.NormalizePath(portShareDir, "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return portshare.Suspend(dir, port)
+}
+
+// sharedConsolePort is the serial port of mos console, which can be released
+// for another process and taken back.
+type sharedConsolePort struct {
+	open func() (serial.Serial, error)
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	s         serial.Serial
+	suspended bool
+}
+
+func newSharedConsolePort(open func() (serial.Serial, error)) *sharedConsolePort {
+	p := &sharedConsolePort{open: open}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// get returns the port, or nil if it's released.
+func (p *sharedConsolePort) get() serial.Serial {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.s
+}
+
+// wait returns the port, waiting until it's taken back if it's released, or
+// nil if it failed to reopen.
+func (p *sharedConsolePort) wait() serial.Serial {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.suspended {
+		p.cond.Wait()
+	}
+	return p.s
+}
+
+func (p *sharedConsolePort) suspend() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.suspended = true
+	if p.s != nil {
+		p.s.Close()
+		p.s = nil
+	}
+	reportf("\r\n--- Port released for another mos process ---\r")
+	return nil
+}
+
+// resume opens the port, retrying for a while.
+func (p *sharedConsolePort) resume() error {
+	var s serial.Serial
+	var err error
+	for start := time.Now(); time.Since(start) < portReopenTimeout; time.Sleep(200 * time.Millisecond) {
+		if s, err = p.open(); err == nil {
+			break
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wasSuspended := p.suspended
+	p.s, p.suspended = s, false
+	p.cond.Broadcast()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if wasSuspended {
+		reportf("--- Port is back ---\r")
+	}
+	return nil
+}
+
+// waitResumed is called after an error reading from s: if that's because the
+// port was released, waits until it's taken back and returns true.
+func (p *sharedConsolePort) waitResumed(s serial.Serial) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.suspended && p.s == s {
+		return false
+	}
+	for p.suspended {
+		p.cond.Wait()
+	}
+	return p.s != nil
+}
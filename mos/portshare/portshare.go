@@ -0,0 +1,135 @@
+// Package portshare lets processes cooperatively share a serial port: the
+// holder of the port (like mos console) registers it along with a control
+// listener on localhost; another process (like mos flash) asks the holder to
+// release the port and to take it back once done.
+//
+// The protocol is line-based: the client sends "suspend" and waits for "ok",
+// then keeps the connection open while using the port, and sends "resume" or
+// just closes the connection when done.
+package portshare
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+)
+
+const (
+	cmdSuspend = "suspend"
+	cmdResume  = "resume"
+	replyOK    = "ok"
+
+	suspendTimeout = 10 * time.Second
+)
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// fileName returns the name of the file the holder of the port registers in.
+func fileName(dir, port string) string {
+	return filepath.Join(dir, unsafeChars.ReplaceAllString(port, "_")+".addr")
+}
+
+// Holder is the registration of the port held by this process.
+type Holder struct {
+	ln    net.Listener
+	fname string
+}
+
+// Register registers the port as held by this process: suspend is called
+// when another process wants to use the port and must release it, resume is
+// called when the port can be taken back.
+func Register(dir, port string, suspend, resume func() error) (*Holder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h := &Holder{ln: ln, fname: fileName(dir, port)}
+	if err := ioutil.WriteFile(h.fname, []byte(ln.Addr().String()), 0600); err != nil {
+		ln.Close()
+		return nil, errors.Trace(err)
+	}
+	go h.serve(suspend, resume)
+	return h, nil
+}
+
+func (h *Holder) serve(suspend, resume func() error) {
+	for {
+		conn, err := h.ln.Accept()
+		if err != nil {
+			return
+		}
+		// One client at a time: others wait until the port is taken back.
+		h.handle(conn, suspend, resume)
+	}
+}
+
+func (h *Holder) handle(conn net.Conn, suspend, resume func() error) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != cmdSuspend {
+		return
+	}
+	if err := suspend(); err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "%s\n", replyOK)
+	// Either "resume" or EOF: in both cases, the port is free to take back.
+	r.ReadString('\n')
+	if err := resume(); err != nil {
+		glog.Errorf("failed to resume: %s", err)
+	}
+}
+
+// Close unregisters the port.
+func (h *Holder) Close() {
+	os.Remove(h.fname)
+	h.ln.Close()
+}
+
+// Suspend asks the holder of the port, if any, to release it. The returned
+// function must be called when done with the port, to let the holder take
+// it back. If the port is not held, the function does nothing.
+func Suspend(dir, port string) (func(), error) {
+	fname := fileName(dir, port)
+	addr, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return func() {}, nil
+	}
+	conn, err := net.DialTimeout("tcp", string(addr), time.Second)
+	if err != nil {
+		// The holder is gone without cleaning up.
+		glog.Infof("removing stale %s: %s", fname, err)
+		os.Remove(fname)
+		return func() {}, nil
+	}
+	conn.SetDeadline(time.Now().Add(suspendTimeout))
+	fmt.Fprintf(conn, "%s\n", cmdSuspend)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, errors.Annotatef(err, "no response from the process holding %s", port)
+	}
+	if reply = strings.TrimSpace(reply); reply != replyOK {
+		conn.Close()
+		return nil, errors.Errorf("the process holding %s failed to release it: %s", port, reply)
+	}
+	conn.SetDeadline(time.Time{})
+	return func() {
+		fmt.Fprintf(conn, "%s\n", cmdResume)
+		conn.Close()
+	}, nil
+}
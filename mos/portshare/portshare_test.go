@@ -0,0 +1,75 @@
+package portshare
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSuspendResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portshare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Not held by anyone
+	resume, err := Suspend(dir, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resume()
+
+	events := make(chan string, 10)
+	h, err := Register(dir, "/dev/ttyUSB0",
+		func() error { events <- "suspend"; return nil },
+		func() error { events <- "resume"; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resume, err = Suspend(dir, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := <-events; e != "suspend" {
+		t.Errorf("unexpected event %s", e)
+	}
+	resume()
+	select {
+	case e := <-events:
+		if e != "resume" {
+			t.Errorf("unexpected event %s", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("not resumed")
+	}
+
+	// Other ports are not affected
+	if _, err := Suspend(dir, "/dev/ttyUSB1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("unexpected events")
+	}
+
+	h.Close()
+	resume, err = Suspend(dir, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resume()
+
+	// Stale registration
+	if err := ioutil.WriteFile(fileName(dir, "COM3"), []byte("127.0.0.1:1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Suspend(dir, "COM3"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("stale registration is not removed: %v", files)
+	}
+}
@@ -0,0 +1,210 @@
+// Package provenance implements build provenance documents in the format of
+// SLSA provenance (an in-toto statement): what was built (the fw zip digest),
+// by whom, and from what (the app sources, the manifest, libs and modules,
+// build images). The statement is wrapped into an envelope which carries its
+// ECDSA signatures.
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cesanta.com/mos/download"
+	"github.com/cesanta/errors"
+)
+
+const (
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	PredicateType = "https://slsa.dev/provenance/v0.2"
+	BuildType     = "https://mongoose-os.com/mos/build@v1"
+	PayloadType   = "application/vnd.in-toto+json"
+)
+
+// Statement is the provenance document.
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Subject is the build result.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type Predicate struct {
+	Builder    Builder    `json:"builder"`
+	BuildType  string     `json:"buildType"`
+	Invocation Invocation `json:"invocation"`
+	Metadata   Metadata   `json:"metadata"`
+	Materials  []Material `json:"materials"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+type Invocation struct {
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+type Metadata struct {
+	BuildStartedOn  time.Time    `json:"buildStartedOn"`
+	BuildFinishedOn time.Time    `json:"buildFinishedOn"`
+	Completeness    Completeness `json:"completeness"`
+}
+
+type Completeness struct {
+	// Whether all the materials are listed, which is only the case for local
+	// builds.
+	Materials bool `json:"materials"`
+}
+
+// Material is something the build used. The digest is "sha1" with the
+// commit for git checkouts, "sha256" for files and images.
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Envelope is the signed statement, as stored in the provenance file.
+type Envelope struct {
+	PayloadType string `json:"payloadType"`
+	// Base64-encoded JSON of the Statement.
+	Payload    string      `json:"payload"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// Signature is the base64-encoded ASN.1 ECDSA signature of the SHA-256 of
+// the payload, see download.Sign.
+type Signature struct {
+	Sig string `json:"sig"`
+}
+
+// New returns the statement about the file with the given name and data.
+func New(name string, data []byte) *Statement {
+	return &Statement{
+		Type:          StatementType,
+		PredicateType: PredicateType,
+		Subject:       []Subject{{Name: name, Digest: map[string]string{"sha256": SHA256(data)}}},
+		Predicate:     Predicate{BuildType: BuildType},
+	}
+}
+
+func SHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// GitMaterial returns the material for the git checkout at the given commit.
+func GitMaterial(origin, commit string) Material {
+	return Material{URI: "git+" + origin, Digest: map[string]string{"sha1": commit}}
+}
+
+// ImageMaterial returns the material for the docker image reference; if the
+// reference has a digest ("repo@sha256:..."), it's used.
+func ImageMaterial(ref string) Material {
+	m := Material{URI: "docker://" + ref}
+	if i := strings.Index(ref, "@sha256:"); i >= 0 {
+		m.URI = "docker://" + ref[:i]
+		m.Digest = map[string]string{"sha256": ref[i+len("@sha256:"):]}
+	}
+	return m
+}
+
+// BuilderID identifies who runs the build: the CI job, or the user and the
+// host for builds outside of CI.
+func BuilderID(getenv func(string) string, user, host string) string {
+	if url := getenv("CI_JOB_URL"); url != "" {
+		return url
+	}
+	if getenv("GITHUB_ACTIONS") != "" && getenv("GITHUB_RUN_ID") != "" {
+		return fmt.Sprintf("%s/%s/actions/runs/%s",
+			getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"))
+	}
+	return fmt.Sprintf("mos://%s@%s", user, host)
+}
+
+// Seal serializes the statement and signs it with the given PEM-encoded
+// ECDSA private key; if the key is nil, the envelope is not signed.
+func Seal(st *Statement, privKeyPEM []byte) ([]byte, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	env := &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}
+	if privKeyPEM != nil {
+		sig, err := download.Sign(payload, privKeyPEM)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		env.Signatures = append(env.Signatures, Signature{Sig: strings.TrimSpace(string(sig))})
+	}
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// Open parses the envelope and returns the statement, checking that it's
+// signed with the given PEM-encoded public key. If the key is empty, the
+// signature is not checked.
+func Open(data []byte, pubKeyPEM string) (*Statement, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, errors.Annotatef(err, "invalid provenance")
+	}
+	if env.PayloadType != PayloadType {
+		return nil, errors.Errorf("unexpected payload type %q", env.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid payload")
+	}
+	if pubKeyPEM != "" {
+		if len(env.Signatures) == 0 {
+			return nil, errors.Errorf("provenance is not signed")
+		}
+		var lastErr error
+		for _, s := range env.Signatures {
+			sig, err := base64.StdEncoding.DecodeString(s.Sig)
+			if err != nil {
+				lastErr = errors.Annotatef(err, "invalid signature")
+				continue
+			}
+			if lastErr = download.VerifySignature(payload, sig, pubKeyPEM); lastErr == nil {
+				break
+			}
+		}
+		if lastErr != nil {
+			return nil, errors.Trace(lastErr)
+		}
+	}
+	var st Statement
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, errors.Annotatef(err, "invalid statement")
+	}
+	if st.Type != StatementType || st.PredicateType != PredicateType {
+		return nil, errors.Errorf("unexpected statement type %q / %q", st.Type, st.PredicateType)
+	}
+	return &st, nil
+}
+
+// CheckSubject checks that the statement is about the given data.
+func (st *Statement) CheckSubject(data []byte) error {
+	digest := SHA256(data)
+	for _, s := range st.Subject {
+		if s.Digest["sha256"] == digest {
+			return nil
+		}
+	}
+	return errors.Errorf("the provenance is not about this file (sha256 %s)", digest)
+}
@@ -0,0 +1,99 @@
+package provenance
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+)
+
+func genKey(t *testing.T) ([]byte, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+}
+
+func TestSealOpen(t *testing.T) {
+	priv, pub := genKey(t)
+	_, otherPub := genKey(t)
+
+	fw := []byte("firmware")
+	st := New("fw.zip", fw)
+	st.Predicate.Materials = append(st.Predicate.Materials,
+		GitMaterial("https://github.com/foo/app", "0123abcd"),
+		ImageMaterial("docker.io/mgos/esp32-build@sha256:feed"),
+	)
+
+	data, err := Seal(st, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st2, err := Open(data, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st2.CheckSubject(fw); err != nil {
+		t.Errorf("subject: %s", err)
+	}
+	if err := st2.CheckSubject([]byte("other")); err == nil {
+		t.Errorf("other data should not match")
+	}
+	if m := st2.Predicate.Materials[1]; m.URI != "docker://docker.io/mgos/esp32-build" || m.Digest["sha256"] != "feed" {
+		t.Errorf("unexpected image material %+v", m)
+	}
+
+	if _, err := Open(data, otherPub); err == nil {
+		t.Errorf("wrong key should fail")
+	}
+
+	// Tampered payload
+	var env Envelope
+	json.Unmarshal(data, &env)
+	st.Subject[0].Digest["sha256"] = SHA256([]byte("evil"))
+	unsigned, err := Seal(st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env2 Envelope
+	json.Unmarshal(unsigned, &env2)
+	env2.Signatures = env.Signatures
+	tampered, _ := json.Marshal(env2)
+	if _, err := Open(tampered, pub); err == nil {
+		t.Errorf("tampered payload should fail")
+	}
+	if _, err := Open(unsigned, pub); err == nil {
+		t.Errorf("unsigned provenance should fail with a key")
+	}
+	if _, err := Open(unsigned, ""); err != nil {
+		t.Errorf("unsigned provenance without a key: %s", err)
+	}
+}
+
+func TestBuilderID(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+	if id := BuilderID(getenv, "joe", "box"); id != "mos://joe@box" {
+		t.Errorf("unexpected id %q", id)
+	}
+	env["GITHUB_ACTIONS"] = "true"
+	env["GITHUB_SERVER_URL"] = "https://github.com"
+	env["GITHUB_REPOSITORY"] = "foo/app"
+	env["GITHUB_RUN_ID"] = "42"
+	if id := BuilderID(getenv, "joe", "box"); id != "https://github.com/foo/app/actions/runs/42" {
+		t.Errorf("unexpected id %q", id)
+	}
+}
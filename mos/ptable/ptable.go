@@ -0,0 +1,337 @@
+// Package ptable implements ESP32 partition tables: parsing of the
+// definitions, validation and generation of the binary table flashed at
+// 0x8000.
+package ptable
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// Offset is where the partition table is located in flash.
+	Offset = 0x8000
+	// MaxSize is the maximum size of the binary table.
+	MaxSize = 0xc00
+
+	// Partitions can only start after the table sector.
+	firstPartitionOffset = 0x9000
+
+	entryMagic = 0x50aa
+	md5Magic   = 0xebeb
+	entrySize  = 32
+
+	TypeApp  = 0x00
+	TypeData = 0x01
+
+	SubTypeFactory = 0x00
+	SubTypeOTA0    = 0x10
+	SubTypeOTAData = 0x00
+	SubTypeNVS     = 0x02
+	SubTypeFAT     = 0x81
+	SubTypeSPIFFS  = 0x82
+
+	flagEncrypted = 1
+)
+
+var typeNames = map[string]uint8{
+	"app":  TypeApp,
+	"data": TypeData,
+}
+
+var subTypeNames = map[uint8]map[string]uint8{
+	TypeApp: {
+		"factory": SubTypeFactory,
+		"test":    0x20,
+	},
+	TypeData: {
+		"ota":      SubTypeOTAData,
+		"phy":      0x01,
+		"nvs":      SubTypeNVS,
+		"coredump": 0x03,
+		"nvs_keys": 0x04,
+		"efuse":    0x05,
+		"esphttpd": 0x80,
+		"fat":      SubTypeFAT,
+		"spiffs":   SubTypeSPIFFS,
+	},
+}
+
+func init() {
+	for i := uint8(0); i < 16; i++ {
+		subTypeNames[TypeApp][fmt.Sprintf("ota_%d", i)] = SubTypeOTA0 + i
+	}
+}
+
+type Partition struct {
+	Name      string
+	Type      uint8
+	SubType   uint8
+	Offset    uint32
+	Size      uint32
+	Encrypted bool
+
+	// autoOffset is set if the offset was not given and is to be assigned by
+	// Resolve.
+	autoOffset bool
+}
+
+func (p *Partition) End() uint32 {
+	return p.Offset + p.Size
+}
+
+func (p *Partition) alignment() uint32 {
+	if p.Type == TypeApp {
+		return 0x10000
+	}
+	return 0x1000
+}
+
+// ParseNumber parses sizes and offsets: decimal or hex numbers, optionally
+// with K or M suffixes.
+func ParseNumber(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	mul := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "K") || strings.HasSuffix(s, "k"):
+		mul, s = 1024, s[:len(s)-1]
+	case strings.HasSuffix(s, "M") || strings.HasSuffix(s, "m"):
+		mul, s = 1024*1024, s[:len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || v*mul > 0xffffffff {
+		return 0, errors.Errorf("invalid number %q", s)
+	}
+	return uint32(v * mul), nil
+}
+
+func parseType(s string) (uint8, error) {
+	if t, ok := typeNames[s]; ok {
+		return t, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, errors.Errorf("invalid type %q, expected app, data or a number", s)
+	}
+	return uint8(v), nil
+}
+
+func parseSubType(typ uint8, s string) (uint8, error) {
+	if st, ok := subTypeNames[typ][s]; ok {
+		return st, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, errors.Errorf("invalid subtype %q", s)
+	}
+	return uint8(v), nil
+}
+
+// NewPartition creates a partition from the textual definition, as in the
+// ESP-IDF partition CSV files. Empty offset means "right after the previous
+// partition".
+func NewPartition(name, typ, subType, offset, size, flags string) (*Partition, error) {
+	if name == "" || len(name) > 16 {
+		return nil, errors.Errorf("%q: name must be 1 to 16 characters long", name)
+	}
+	p := &Partition{Name: name}
+	var err error
+	if p.Type, err = parseType(typ); err != nil {
+		return nil, errors.Annotatef(err, "%s", name)
+	}
+	if p.SubType, err = parseSubType(p.Type, subType); err != nil {
+		return nil, errors.Annotatef(err, "%s", name)
+	}
+	if offset == "" {
+		p.autoOffset = true
+	} else if p.Offset, err = ParseNumber(offset); err != nil {
+		return nil, errors.Annotatef(err, "%s: offset", name)
+	}
+	if p.Size, err = ParseNumber(size); err != nil {
+		return nil, errors.Annotatef(err, "%s: size", name)
+	}
+	for _, f := range strings.Split(flags, ":") {
+		switch strings.TrimSpace(f) {
+		case "":
+		case "encrypted":
+			p.Encrypted = true
+		default:
+			return nil, errors.Errorf("%s: unknown flag %q", name, f)
+		}
+	}
+	return p, nil
+}
+
+type Table struct {
+	Partitions []*Partition
+}
+
+// Resolve assigns offsets to partitions which don't have them, in order,
+// aligned as required.
+func (t *Table) Resolve() {
+	next := uint32(firstPartitionOffset)
+	for _, p := range t.Partitions {
+		if p.autoOffset {
+			a := p.alignment()
+			p.Offset = (next + a - 1) / a * a
+			p.autoOffset = false
+		}
+		next = p.End()
+	}
+}
+
+// Validate checks the table: partitions must be aligned, must not overlap
+// each other or the bootloader and the table itself, and must fit in flash
+// of the given size (if non-zero).
+func (t *Table) Validate(flashSize uint32) error {
+	names := map[string]bool{}
+	for _, p := range t.Partitions {
+		if names[p.Name] {
+			return errors.Errorf("duplicate partition %q", p.Name)
+		}
+		names[p.Name] = true
+		if p.Offset%p.alignment() != 0 {
+			return errors.Errorf("%s: offset 0x%x is not aligned to 0x%x", p.Name, p.Offset, p.alignment())
+		}
+		if p.Size == 0 {
+			return errors.Errorf("%s: size is zero", p.Name)
+		}
+		if p.Offset < firstPartitionOffset {
+			return errors.Errorf("%s: offset 0x%x overlaps the bootloader or the partition table, must be at least 0x%x", p.Name, p.Offset, firstPartitionOffset)
+		}
+		if flashSize > 0 && p.End() > flashSize {
+			return errors.Errorf("%s: 0x%x-0x%x doesn't fit in flash of %d bytes", p.Name, p.Offset, p.End(), flashSize)
+		}
+	}
+	sorted := append([]*Partition(nil), t.Partitions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	for i := 1; i < len(sorted); i++ {
+		a, b := sorted[i-1], sorted[i]
+		if b.Offset < a.End() {
+			return errors.Errorf("%s (0x%x-0x%x) overlaps %s (0x%x-0x%x)", a.Name, a.Offset, a.End(), b.Name, b.Offset, b.End())
+		}
+	}
+	if (len(t.Partitions)+1)*entrySize > MaxSize {
+		return errors.Errorf("too many partitions")
+	}
+	return nil
+}
+
+// Get returns the partition with the given name, or nil.
+func (t *Table) Get(name string) *Partition {
+	for _, p := range t.Partitions {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// At returns the partition starting at the given offset, or nil.
+func (t *Table) At(offset uint32) *Partition {
+	for _, p := range t.Partitions {
+		if p.Offset == offset {
+			return p
+		}
+	}
+	return nil
+}
+
+// Binary returns the binary table in the format expected by the ESP-IDF
+// bootloader: 32-byte entries, followed by the MD5 of them, padded with 0xff.
+func (t *Table) Binary() []byte {
+	buf := &bytes.Buffer{}
+	for _, p := range t.Partitions {
+		e := make([]byte, entrySize)
+		binary.LittleEndian.PutUint16(e[0:], entryMagic)
+		e[2], e[3] = p.Type, p.SubType
+		binary.LittleEndian.PutUint32(e[4:], p.Offset)
+		binary.LittleEndian.PutUint32(e[8:], p.Size)
+		copy(e[12:28], p.Name)
+		if p.Encrypted {
+			binary.LittleEndian.PutUint32(e[28:], flagEncrypted)
+		}
+		buf.Write(e)
+	}
+	sum := md5.Sum(buf.Bytes())
+	m := bytes.Repeat([]byte{0xff}, entrySize)
+	binary.LittleEndian.PutUint16(m[0:], md5Magic)
+	copy(m[16:], sum[:])
+	buf.Write(m)
+	buf.Write(bytes.Repeat([]byte{0xff}, MaxSize-buf.Len()))
+	return buf.Bytes()
+}
+
+func typeName(typ uint8) string {
+	for n, t := range typeNames {
+		if t == typ {
+			return n
+		}
+	}
+	return fmt.Sprintf("0x%02x", typ)
+}
+
+func subTypeName(typ, subType uint8) string {
+	for n, st := range subTypeNames[typ] {
+		if st == subType {
+			return n
+		}
+	}
+	return fmt.Sprintf("0x%02x", subType)
+}
+
+// WriteCSV writes the table in the ESP-IDF partition CSV format.
+func (t *Table) WriteCSV(w io.Writer) error {
+	fmt.Fprintf(w, "# Name, Type, SubType, Offset, Size, Flags\n")
+	for _, p := range t.Partitions {
+		flags := ""
+		if p.Encrypted {
+			flags = "encrypted"
+		}
+		if _, err := fmt.Fprintf(w, "%s, %s, %s, 0x%x, 0x%x, %s\n",
+			p.Name, typeName(p.Type), subTypeName(p.Type, p.SubType), p.Offset, p.Size, flags); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// ParseBinary parses the binary table, as read from flash. If the MD5 entry
+// is present, the checksum is verified.
+func ParseBinary(data []byte) (*Table, error) {
+	t := &Table{}
+	for i := 0; i+entrySize <= len(data); i += entrySize {
+		e := data[i : i+entrySize]
+		switch binary.LittleEndian.Uint16(e) {
+		case entryMagic:
+			p := &Partition{
+				Type:      e[2],
+				SubType:   e[3],
+				Offset:    binary.LittleEndian.Uint32(e[4:]),
+				Size:      binary.LittleEndian.Uint32(e[8:]),
+				Name:      string(bytes.TrimRight(e[12:28], "\x00")),
+				Encrypted: binary.LittleEndian.Uint32(e[28:])&flagEncrypted != 0,
+			}
+			t.Partitions = append(t.Partitions, p)
+		case md5Magic:
+			sum := md5.Sum(data[:i])
+			if !bytes.Equal(e[16:], sum[:]) {
+				return nil, errors.Errorf("partition table checksum mismatch")
+			}
+			return t, nil
+		case 0xffff:
+			return t, nil
+		default:
+			return nil, errors.Errorf("invalid partition table entry at 0x%x", i)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,115 @@
+package ptable
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func mustTable(t *testing.T, defs [][6]string) *Table {
+	tbl := &Table{}
+	for _, d := range defs {
+		p, err := NewPartition(d[0], d[1], d[2], d[3], d[4], d[5])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.Partitions = append(tbl.Partitions, p)
+	}
+	tbl.Resolve()
+	return tbl
+}
+
+func TestTable(t *testing.T) {
+	tbl := mustTable(t, [][6]string{
+		{"nvs", "data", "nvs", "", "16K", ""},
+		{"otadata", "data", "ota", "", "0x2000", ""},
+		{"app_0", "app", "ota_0", "", "1M", "encrypted"},
+		{"fs_0", "data", "spiffs", "", "256K", ""},
+		{"custom", "0x40", "0x1", "0x300000", "4096", ""},
+	})
+	for _, c := range []struct {
+		name         string
+		offset, size uint32
+	}{
+		{"nvs", 0x9000, 0x4000},
+		{"otadata", 0xd000, 0x2000},
+		{"app_0", 0x10000, 0x100000},
+		{"fs_0", 0x110000, 0x40000},
+		{"custom", 0x300000, 0x1000},
+	} {
+		p := tbl.Get(c.name)
+		if p.Offset != c.offset || p.Size != c.size {
+			t.Errorf("%s: got 0x%x 0x%x, want 0x%x 0x%x", c.name, p.Offset, p.Size, c.offset, c.size)
+		}
+	}
+	if err := tbl.Validate(4 * 1024 * 1024); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := tbl.Validate(2 * 1024 * 1024); err == nil {
+		t.Errorf("expected an error for small flash")
+	}
+
+	data := tbl.Binary()
+	if len(data) != MaxSize {
+		t.Fatalf("unexpected size %d", len(data))
+	}
+	e := data[64:96]
+	if binary.LittleEndian.Uint16(e) != entryMagic || e[2] != TypeApp || e[3] != 0x10 ||
+		binary.LittleEndian.Uint32(e[4:]) != 0x10000 || string(e[12:17]) != "app_0" ||
+		binary.LittleEndian.Uint32(e[28:]) != flagEncrypted {
+		t.Errorf("unexpected app entry: %x", e)
+	}
+	m := data[5*32 : 6*32]
+	sum := md5.Sum(data[:5*32])
+	if binary.LittleEndian.Uint16(m) != md5Magic || !bytes.Equal(m[16:], sum[:]) {
+		t.Errorf("unexpected MD5 entry: %x", m)
+	}
+	if data[6*32] != 0xff {
+		t.Errorf("table is not padded")
+	}
+
+	tbl2, err := ParseBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tbl, tbl2) {
+		t.Errorf("binary round trip failed: %+v", tbl2.Partitions)
+	}
+	data[70] ^= 1
+	if _, err := ParseBinary(data); err == nil {
+		t.Errorf("expected a checksum error")
+	}
+	data[70] ^= 1
+
+	buf := &bytes.Buffer{}
+	tbl.WriteCSV(buf)
+	if !bytes.Contains(buf.Bytes(), []byte("app_0, app, ota_0, 0x10000, 0x100000, encrypted\n")) {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, defs := range [][][6]string{
+		{{"a", "data", "nvs", "0x8000", "4K", ""}},
+		{{"a", "app", "factory", "0x11000", "64K", ""}},
+		{{"a", "data", "nvs", "0x9000", "8K", ""}, {"b", "data", "nvs", "0xa000", "4K", ""}},
+		{{"a", "data", "nvs", "", "4K", ""}, {"a", "data", "nvs", "", "4K", ""}},
+	} {
+		if err := mustTable(t, defs).Validate(0); err == nil {
+			t.Errorf("%v: expected an error", defs)
+		}
+	}
+	for _, d := range [][6]string{
+		{"", "data", "nvs", "", "4K", ""},
+		{"a", "blah", "nvs", "", "4K", ""},
+		{"a", "data", "blah", "", "4K", ""},
+		{"a", "data", "nvs", "", "4X", ""},
+		{"a", "data", "nvs", "", "4K", "readonly"},
+	} {
+		if _, err := NewPartition(d[0], d[1], d[2], d[3], d[4], d[5]); err == nil {
+			t.Errorf("%v: expected an error", d)
+		}
+	}
+}
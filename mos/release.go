@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/download"
+	"cesanta.com/mos/errcode"
+	"cesanta.com/mos/mosgit"
+	"cesanta.com/mos/release"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	releaseSignKey = flag.String("release-sign-key", "", "PEM-encoded ECDSA private key file to sign release artifacts with")
+	noUpload       = flag.Bool("no-upload", false, "mos release: only build and tag locally, do not push and upload artifacts")
+	noScan         = flag.Bool("no-scan", false, "mos release: do not scan firmware for leaked secrets")
+)
+
+// releaseHandler implements "mos release [major|minor|patch|<version>]":
+// bumps the app version in mos.yml, builds firmware for all release
+// platforms, commits and tags the new version and puts the firmware, along
+// with the changelog, SBOM, checksums and signatures, to release/<tag>; then
+// uploads it all as configured in the "release" section of mos.yml.
+func releaseHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	what := release.BumpPatch
+	if len(args) > 0 {
+		what = args[0]
+	}
+
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts := manifest.Release
+	if opts == nil {
+		opts = &build.ReleaseOpts{}
+	}
+
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		p := *platform
+		if p == "" {
+			p = manifest.Platform
+		}
+		if p == "" {
+			return errors.Errorf("no platforms to release for: set release.platforms in mos.yml or use --platform")
+		}
+		platforms = []string{p}
+	}
+
+	if out, err := releaseGit("status", "--porcelain", "--untracked-files=no"); err != nil {
+		return errors.Trace(err)
+	} else if out != "" {
+		return errors.Errorf("working tree has uncommitted changes, commit or stash them first:\n%s", out)
+	}
+
+	newVersion, err := release.BumpVersion(manifest.Version, what)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tag := "v" + newVersion
+	if _, err := releaseGit("rev-parse", "--verify", "--quiet", "refs/tags/"+tag); err == nil {
+		return errors.Errorf("tag %s already exists", tag)
+	}
+	// Previous tag is needed for the changelog; there may be none
+	prevTag, _ := releaseGit("describe", "--tags", "--abbrev=0")
+
+	ourutil.Reportf("Releasing %s (%s -> %s), platforms: %s",
+		manifest.Name, manifest.Version, newVersion, strings.Join(platforms, ", "))
+
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(manifestPath, release.SetManifestVersion(data, newVersion), 0666); err != nil {
+		return errors.Trace(err)
+	}
+	// The new version is only committed and tagged once all the builds have
+	// succeeded; until then, the manifest is restored on failure.
+	committed := false
+	defer func() {
+		if !committed {
+			ioutil.WriteFile(manifestPath, data, 0666)
+		}
+	}()
+
+	// Not in the build dir, which a remote build wipes along with the
+	// artifacts of the platforms built before
+	relDir := filepath.Join(projectDir, "release", tag)
+	if err := os.RemoveAll(relDir); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(relDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+
+	buildDir := moscommon.GetBuildDir(projectDir)
+	var artifacts []string
+	for _, p := range platforms {
+		ourutil.Reportf("Building for %s...", p)
+		bParams, err := newBuildParams(p)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := errcode.Wrap(errcode.BuildFailed, doBuild(ctx, bParams)); err != nil {
+			return errors.Annotatef(err, "build for %s failed", p)
+		}
+		if !*noScan {
+			fwFile := moscommon.GetFirmwareZipFilePath(buildDir)
+			findings, err := scanFirmware(fwFile, append(opts.ScanAllow, *scanAllow...))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(findings) > 0 {
+				return errors.Errorf("firmware for %s may leak secrets, see above; fix it, or add the findings to release.scan_allow in mos.yml", p)
+			}
+		}
+		name := fmt.Sprintf("%s-%s-%s.zip", manifest.Name, newVersion, p)
+		if err := ourio.CopyFile(moscommon.GetFirmwareZipFilePath(buildDir), filepath.Join(relDir, name)); err != nil {
+			return errors.Trace(err)
+		}
+		artifacts = append(artifacts, name)
+	}
+
+	if _, err := releaseGit("commit", "-m", fmt.Sprintf("Release %s", tag), "--", manifestPath); err != nil {
+		return errors.Trace(err)
+	}
+	committed = true
+	if _, err := releaseGit("tag", "-a", tag, "-m", fmt.Sprintf("Release %s", tag)); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Created tag %s", tag)
+
+	changelog, err := getReleaseChangelog(prevTag, tag, tag)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(relDir, "CHANGELOG.md"), changelog, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	artifacts = append(artifacts, "CHANGELOG.md")
+
+	sbom, err := getReleaseSBOM(manifest, newVersion, platforms)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(relDir, "sbom.json"), sbom, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	artifacts = append(artifacts, "sbom.json")
+
+	if err := signReleaseArtifacts(relDir, artifacts); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Release artifacts are saved to %s", relDir)
+
+	if *noUpload {
+		ourutil.Reportf("Not uploading (--no-upload); push the tag with \"git push origin HEAD %s\"", tag)
+		return nil
+	}
+
+	if opts.GitHub != "" {
+		if _, err := releaseGit("push", "origin", "HEAD", tag); err != nil {
+			return errors.Trace(err)
+		}
+		if err := uploadGitHubRelease(opts.GitHub, tag, changelog, relDir); err != nil {
+			return errors.Annotatef(err, "failed to upload to GitHub")
+		}
+	}
+	if opts.S3 != "" {
+		if err := uploadS3Release(opts.S3, tag, relDir); err != nil {
+			return errors.Annotatef(err, "failed to upload to S3")
+		}
+	}
+	if opts.GitHub == "" && opts.S3 == "" {
+		ourutil.Reportf("No upload destination is configured in mos.yml (release.github, release.s3)")
+	}
+
+	return nil
+}
+
+// releaseGit runs git in the project dir and returns its trimmed output.
+func releaseGit(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = projectDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Annotatef(err, "git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getReleaseChangelog returns the list of commits since the previous tag up
+// to rev, in markdown, titled with the given tag.
+func getReleaseChangelog(prevTag, rev, tag string) ([]byte, error) {
+	rng := rev
+	if prevTag != "" {
+		rng = fmt.Sprintf("%s..%s", prevTag, rev)
+	}
+	log, err := releaseGit("log", "--no-merges", "--pretty=format:- %s (%h)", rng)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "## %s (%s)\n\n", tag, time.Now().Format("2006-01-02"))
+	if prevTag != "" {
+		fmt.Fprintf(&b, "Changes since %s:\n\n", prevTag)
+	}
+	fmt.Fprintf(&b, "%s\n", log)
+	return b.Bytes(), nil
+}
+
+type sbomComponent struct {
+	Name     string `json:"name"`
+	Origin   string `json:"origin,omitempty"`
+	Revision string `json:"revision,omitempty"`
+}
+
+type releaseSBOM struct {
+	Name       string          `json:"name"`
+	Version    string          `json:"version"`
+	Platforms  []string        `json:"platforms"`
+	MosVersion string          `json:"mos_version"`
+	Created    string          `json:"created"`
+	Components []sbomComponent `json:"components"`
+}
+
+// getReleaseSBOM returns the list of the libs the firmware is built from,
+// with their origins and git revisions, as JSON.
+func getReleaseSBOM(manifest *build.FWAppManifest, newVersion string, platforms []string) ([]byte, error) {
+	s := releaseSBOM{
+		Name:       manifest.Name,
+		Version:    newVersion,
+		Platforms:  platforms,
+		MosVersion: version.GetMosVersion(),
+		Created:    time.Now().UTC().Format(time.RFC3339),
+		Components: []sbomComponent{},
+	}
+
+	depsDir := moscommon.GetDepsDir(projectDir)
+	entries, err := ioutil.ReadDir(depsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	gitinst := mosgit.NewOurGit()
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		c := sbomComponent{Name: e.Name()}
+		c.Revision, _ = gitinst.GetCurrentHash(filepath.Join(depsDir, e.Name()))
+		c.Origin, _ = gitinst.GetOriginUrl(filepath.Join(depsDir, e.Name()))
+		s.Components = append(s.Components, c)
+	}
+	sort.Slice(s.Components, func(i, j int) bool { return s.Components[i].Name < s.Components[j].Name })
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(data, '\n'), nil
+}
+
+// signReleaseArtifacts writes ".sha256" and, if --release-sign-key is given,
+// ".sig" files next to each artifact, in the format verified by mos when
+// downloading.
+func signReleaseArtifacts(relDir string, artifacts []string) error {
+	var key []byte
+	if *releaseSignKey != "" {
+		var err error
+		key, err = ioutil.ReadFile(*releaseSignKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, name := range artifacts {
+		fname := filepath.Join(relDir, name)
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(fname+".sha256", download.Checksum(data, name), 0644); err != nil {
+			return errors.Trace(err)
+		}
+		if key != nil {
+			sig, err := download.Sign(data, key)
+			if err != nil {
+				return errors.Annotatef(err, "failed to sign %s", name)
+			}
+			if err := ioutil.WriteFile(fname+".sig", sig, 0644); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// uploadGitHubRelease creates a release for the (already pushed) tag in the
+// given GitHub repo and uploads all files from relDir as its assets.
+func uploadGitHubRelease(repo, tag string, changelog []byte, relDir string) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return errors.Errorf("GITHUB_TOKEN is not set")
+	}
+
+	reqData, _ := json.Marshal(map[string]interface{}{
+		"tag_name": tag,
+		"name":     tag,
+		"body":     string(changelog),
+	})
+	var rel struct {
+		ID      int    `json:"id"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := githubRequest("POST", fmt.Sprintf("https://api.github.com/repos/%s/releases", repo),
+		token, "application/json", reqData, &rel); err != nil {
+		return errors.Annotatef(err, "failed to create release")
+	}
+
+	files, err := ioutil.ReadDir(relDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(relDir, f.Name()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		ourutil.Reportf("Uploading %s...", f.Name())
+		uploadURL := fmt.Sprintf("https://uploads.github.com/repos/%s/releases/%d/assets?name=%s",
+			repo, rel.ID, url.QueryEscape(f.Name()))
+		if err := githubRequest("POST", uploadURL, token, "application/octet-stream", data, nil); err != nil {
+			return errors.Annotatef(err, "failed to upload %s", f.Name())
+		}
+	}
+
+	ourutil.Reportf("Released: %s", rel.HTMLURL)
+	return nil
+}
+
+func githubRequest(method, uri, token, contentType string, body []byte, resp interface{}) error {
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Body.Close()
+	respData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if r.StatusCode/100 != 2 {
+		return errors.Errorf("%s %s: %s: %s", method, uri, r.Status, strings.TrimSpace(string(respData)))
+	}
+	if resp != nil {
+		if err := json.Unmarshal(respData, resp); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// uploadS3Release copies relDir to <s3loc>/<tag>/ using the aws CLI, which
+// takes care of credentials.
+func uploadS3Release(s3loc, tag, relDir string) error {
+	if !strings.HasPrefix(s3loc, "s3://") {
+		return errors.Errorf("invalid S3 location %q, expected s3://bucket[/prefix]", s3loc)
+	}
+	dst := strings.TrimSuffix(s3loc, "/") + "/" + tag + "/"
+	ourutil.Reportf("Uploading to %s...", dst)
+	cmd := exec.Command("aws", "s3", "cp", "--recursive", relDir, dst)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Annotatef(err, "aws s3 cp failed")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package release
+
+import (
+	"bytes"
+)
+
+// PrependChangelog returns the changelog data with the entry added on top,
+// below the "# Title" line, if there is one.
+func PrependChangelog(data, entry []byte) []byte {
+	entry = append(bytes.TrimRight(entry, "\n"), '\n')
+	var head []byte
+	if bytes.HasPrefix(data, []byte("# ")) {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			end = len(data)
+			data = append(data[:end:end], '\n')
+		}
+		head, data = data[:end+1:end+1], data[end+1:]
+	}
+	data = bytes.TrimLeft(data, "\n")
+
+	var b bytes.Buffer
+	if head != nil {
+		b.Write(head)
+		b.WriteByte('\n')
+	}
+	b.Write(entry)
+	if len(data) > 0 {
+		b.WriteByte('\n')
+		b.Write(data)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,32 @@
+package release
+
+import "testing"
+
+func TestPrependChangelog(t *testing.T) {
+	entry := "## v1.1.0 (2026-10-16)\n\n- Fix things (abc123)\n"
+	for i, c := range []struct {
+		in, expected string
+	}{
+		{
+			in:       "",
+			expected: entry,
+		},
+		{
+			in:       "# Changelog\n\n## v1.0.0\n\n- Initial\n",
+			expected: "# Changelog\n\n" + entry + "\n## v1.0.0\n\n- Initial\n",
+		},
+		{
+			in:       "## v1.0.0\n\n- Initial\n",
+			expected: entry + "\n## v1.0.0\n\n- Initial\n",
+		},
+		{
+			in:       "# Changelog",
+			expected: "# Changelog\n\n" + entry,
+		},
+	} {
+		res := string(PrependChangelog([]byte(c.in), []byte(entry)))
+		if res != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, res)
+		}
+	}
+}
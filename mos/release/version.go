@@ -0,0 +1,80 @@
+// Package release contains helpers for "mos release": version bumping and
+// updating the version in the manifest.
+package release
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Bump kinds
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+var (
+	versionRegexp         = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?$`)
+	manifestVersionRegexp = regexp.MustCompile(`(?m)^version:[ \t]*("[^"\n]*"|'[^'\n]*'|[^#\n]*?)([ \t]*(?:#.*)?)$`)
+)
+
+// BumpVersion returns the next version. what is either one of the bump kinds,
+// or the exact new version. Versions are "major.minor[.patch]"; the patch
+// part is always present in the result.
+func BumpVersion(cur, what string) (string, error) {
+	switch what {
+	case BumpMajor, BumpMinor, BumpPatch:
+	default:
+		if !versionRegexp.MatchString(what) {
+			return "", errors.Errorf("invalid version %q, expected major.minor.patch or one of: %s, %s, %s",
+				what, BumpMajor, BumpMinor, BumpPatch)
+		}
+		return what, nil
+	}
+
+	m := versionRegexp.FindStringSubmatch(strings.TrimSpace(cur))
+	if m == nil {
+		return "", errors.Errorf("current version %q is not major.minor[.patch], specify the new version explicitly", cur)
+	}
+	var parts [3]int
+	for i := range parts {
+		if m[i+1] != "" {
+			parts[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+
+	switch what {
+	case BumpMajor:
+		parts = [3]int{parts[0] + 1, 0, 0}
+	case BumpMinor:
+		parts = [3]int{parts[0], parts[1] + 1, 0}
+	case BumpPatch:
+		parts[2]++
+	}
+
+	return fmt.Sprintf("%d.%d.%d", parts[0], parts[1], parts[2]), nil
+}
+
+// SetManifestVersion returns the manifest data with the top-level version
+// set to the given one. The manifest is edited textually, so that comments
+// and formatting are preserved; if there is no version, it's added as the
+// first line.
+func SetManifestVersion(data []byte, version string) []byte {
+	if !manifestVersionRegexp.Match(data) {
+		return append([]byte(fmt.Sprintf("version: %s\n", version)), data...)
+	}
+	done := false
+	return manifestVersionRegexp.ReplaceAllFunc(data, func(line []byte) []byte {
+		if done {
+			return line
+		}
+		done = true
+		m := manifestVersionRegexp.FindSubmatch(line)
+		return []byte(fmt.Sprintf("version: %s%s", version, m[2]))
+	})
+}
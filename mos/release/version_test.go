@@ -0,0 +1,58 @@
+package release
+
+import (
+	"testing"
+)
+
+func TestBumpVersion(t *testing.T) {
+	for i, c := range []struct {
+		cur, what, expected string
+		fail                bool
+	}{
+		{cur: "1.0", what: "patch", expected: "1.0.1"},
+		{cur: "1.2.3", what: "patch", expected: "1.2.4"},
+		{cur: "1.2.3", what: "minor", expected: "1.3.0"},
+		{cur: "1.2.3", what: "major", expected: "2.0.0"},
+		{cur: "1.2.3", what: "1.5.0", expected: "1.5.0"},
+		{cur: "", what: "1.0.0", expected: "1.0.0"},
+		{cur: "", what: "patch", fail: true},
+		{cur: "1.2.3", what: "foo", fail: true},
+	} {
+		res, err := BumpVersion(c.cur, c.what)
+		if c.fail {
+			if err == nil {
+				t.Errorf("case %d: expected an error, got %q", i, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+		} else if res != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, res)
+		}
+	}
+}
+
+func TestSetManifestVersion(t *testing.T) {
+	for i, c := range []struct {
+		in, expected string
+	}{
+		{
+			in:       "author: me\nversion: 1.0\nlibs:\n  - version: 1.0\n",
+			expected: "author: me\nversion: 1.0.1\nlibs:\n  - version: 1.0\n",
+		},
+		{
+			in:       "version: \"1.0\"  # keep me\n",
+			expected: "version: 1.0.1  # keep me\n",
+		},
+		{
+			in:       "author: me\n",
+			expected: "version: 1.0.1\nauthor: me\n",
+		},
+	} {
+		res := string(SetManifestVersion([]byte(c.in), "1.0.1"))
+		if res != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, res)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	remoteDockerDir = flag.String("remote-docker-dir", "/tmp/mos_remote_build", "When DOCKER_HOST is ssh://..., directory on the remote host where the build context is synced to")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "remote-docker-dir")
+}
+
+// remoteDockerHost is the docker host accessed via ssh. Since the volumes
+// are mounted from the filesystem of that host, the build context is synced
+// there with rsync before the build, and the results are synced back after.
+type remoteDockerHost struct {
+	// [user@]host
+	sshHost string
+	sshPort string
+	baseDir string
+	// Host paths which were already pushed
+	pushed map[string]bool
+}
+
+// isRemoteDockerHost returns whether DOCKER_HOST points to a remote engine
+// accessed via ssh.
+func isRemoteDockerHost() bool {
+	return strings.HasPrefix(os.Getenv("DOCKER_HOST"), "ssh://")
+}
+
+// getRemoteDockerHost returns the remote docker host, or nil if docker is
+// local.
+func getRemoteDockerHost() (*remoteDockerHost, error) {
+	if !isRemoteDockerHost() {
+		return nil, nil
+	}
+	u, err := url.Parse(os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid DOCKER_HOST")
+	}
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return nil, errors.Errorf("rsync is required for builds on a remote docker host")
+	}
+	rh := &remoteDockerHost{
+		sshHost: u.Hostname(),
+		sshPort: u.Port(),
+		baseDir: *remoteDockerDir,
+		pushed:  map[string]bool{},
+	}
+	if u.User != nil {
+		rh.sshHost = u.User.Username() + "@" + rh.sshHost
+	}
+	return rh, nil
+}
+
+// remotePath returns the path on the remote host the given local path is
+// synced to.
+func (rh *remoteDockerHost) remotePath(hostPath string) string {
+	return path.Join(rh.baseDir, getPathForDocker(hostPath))
+}
+
+func (rh *remoteDockerHost) sshArgs() []string {
+	if rh.sshPort != "" {
+		return []string{"-p", rh.sshPort}
+	}
+	return nil
+}
+
+// rsync syncs src to dst, either of which can be remote. Remote paths are
+// passed with --protect-args, so that the remote shell doesn't interpret
+// them: quoting them instead would break with rsync 3.2.4+, which escapes
+// the remote args on its own.
+func (rh *remoteDockerHost) rsync(src, dst string, extraArgs ...string) error {
+	args := []string{"-a", "--protect-args", "-e", strings.Join(append([]string{"ssh"}, rh.sshArgs()...), " ")}
+	args = append(args, extraArgs...)
+	args = append(args, src+"/", dst+"/")
+	freportf(logWriter, "rsync %s", strings.Join(args, " "))
+	return errors.Trace(runCmd(exec.Command("rsync", args...), logWriter))
+}
+
+// push syncs the local directory to the remote host, and returns the remote
+// path.
+func (rh *remoteDockerHost) push(hostPath string) (string, error) {
+	remotePath := rh.remotePath(hostPath)
+	if rh.pushed[hostPath] {
+		return remotePath, nil
+	}
+
+	// ssh passes the command to the remote shell as is
+	sshArgs := append(rh.sshArgs(), rh.sshHost, "mkdir", "-p", shellQuote(remotePath))
+	if err := runCmd(exec.Command("ssh", sshArgs...), logWriter); err != nil {
+		return "", errors.Annotatef(err, "failed to create %s on %s", remotePath, rh.sshHost)
+	}
+	if err := rh.rsync(hostPath, rh.sshHost+":"+remotePath, "--delete"); err != nil {
+		return "", errors.Annotatef(err, "failed to sync %s to %s", hostPath, rh.sshHost)
+	}
+
+	rh.pushed[hostPath] = true
+	return remotePath, nil
+}
+
+// pull syncs the directory back from the remote host.
+func (rh *remoteDockerHost) pull(hostPath string) error {
+	if err := rh.rsync(rh.sshHost+":"+rh.remotePath(hostPath), hostPath); err != nil {
+		return errors.Annotatef(err, "failed to sync %s back from %s", hostPath, rh.sshHost)
+	}
+	return nil
+}
+
+// shellQuote quotes the string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/mosgit"
+	"cesanta.com/mos/redact"
+	"cesanta.com/mos/update"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+// reportHandler creates a zip with diagnostic information which can be
+// attached to a bug report: "mos report [file.zip]". Nothing is sent anywhere,
+// and secrets are redacted from all the files.
+func reportHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	outFile := fmt.Sprintf("mos_report_%s.zip", time.Now().Format("20060102-150405"))
+	if len(args) > 0 {
+		outFile = args[0]
+	}
+
+	files := map[string][]byte{}
+
+	var info bytes.Buffer
+	fmt.Fprintf(&info, "Secrets (passwords, keys, tokens) are redacted from all files in this report.\n\n")
+	fmt.Fprintf(&info, "Version: %s\n", version.GetMosVersion())
+	fmt.Fprintf(&info, "Build ID: %s\n", version.BuildId)
+	fmt.Fprintf(&info, "Update channel: %s\n", update.GetUpdateChannel())
+	fmt.Fprintf(&info, "OS: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&info, "Go: %s\n", runtime.Version())
+	fmt.Fprintf(&info, "Command line: %q\n", os.Args)
+	files["info.txt"] = info.Bytes()
+
+	buildDir := moscommon.GetBuildDir(projectDir)
+	for name, fname := range map[string]string{
+		"mos.yml":         moscommon.GetManifestFilePath(projectDir),
+		"mos_final.yml":   moscommon.GetMosFinalFilePath(buildDir),
+		"build.log":       moscommon.GetBuildLogFilePath(buildDir),
+		"build.log.local": moscommon.GetBuildLogLocalFilePath(buildDir),
+	} {
+		if data, err := ioutil.ReadFile(fname); err == nil {
+			files[name] = data
+		}
+	}
+
+	if libs := getLibsRevisions(); libs != nil {
+		files["libs.txt"] = libs
+	}
+
+	// Only talk to the device if the port is given explicitly
+	if f := flag.Lookup("port"); f != nil && f.Changed {
+		if data, err := getDeviceInfoForReport(ctx); err != nil {
+			files["device_info.txt"] = []byte(fmt.Sprintf("Failed to get device info: %s\n", err))
+		} else {
+			files["device_info.json"] = data
+		}
+	}
+
+	if err := writeReportZip(outFile, files); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Report saved to %s. Please review it before sharing.", outFile)
+	return nil
+}
+
+// getLibsRevisions returns the list of libs in the deps dir with their
+// current git revisions.
+func getLibsRevisions() []byte {
+	depsDir := moscommon.GetDepsDir(projectDir)
+	entries, err := ioutil.ReadDir(depsDir)
+	if err != nil {
+		return nil
+	}
+	gitinst := mosgit.NewOurGit()
+	var b bytes.Buffer
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		hash, err := gitinst.GetCurrentHash(filepath.Join(depsDir, e.Name()))
+		if err != nil {
+			hash = "(not a git repo)"
+		}
+		fmt.Fprintf(&b, "%s %s\n", e.Name(), hash)
+	}
+	return b.Bytes()
+}
+
+func getDeviceInfoForReport(ctx context.Context) ([]byte, error) {
+	devConn, err := createDevConn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer devConn.Disconnect(ctx)
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	info, err := devConn.GetInfo(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return json.MarshalIndent(info, "", "  ")
+}
+
+func writeReportZip(outFile string, files map[string][]byte) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := w.Write(redact.Bytes(data)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
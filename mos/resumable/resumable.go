@@ -0,0 +1,278 @@
+// Package resumable transfers large blobs over flaky connections: uploads
+// are sent in chunks which are retried individually, and are resumed from
+// the offset the server reports; downloads are resumed with Range requests.
+// Integrity is checked with SHA-256 digests (RFC 3230 "Digest" headers).
+//
+// Upload protocol, for the upload URL (which includes the digest of the
+// whole blob, so that the server can find a partial upload):
+//
+//	HEAD <url>  -> 200 with "Upload-Offset: <n>", the number of bytes the
+//	               server has (0 if none); any other status (like 404 or
+//	               405) means the server doesn't support resumable uploads,
+//	               at least not for this client.
+//	PUT <url>   with "Content-Range: bytes <first>-<last>/<total>" and the
+//	               digest of the chunk -> 200 or 204; 409 if the offset
+//	               doesn't match the server's one.
+package resumable
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// ErrNotSupported is returned by Upload (possibly annotated with the reason)
+// if the server doesn't support resumable uploads, or doesn't accept them
+// from this client, so that the data should be sent some other way.
+var ErrNotSupported = errors.New("resumable uploads are not supported by the server")
+
+// Client does the transfers.
+type Client struct {
+	HTTP      *http.Client
+	ChunkSize int
+	// How many times a chunk is retried.
+	Retries    int
+	RetryDelay time.Duration
+	// Called for each request, e.g. to set credentials.
+	Prepare func(req *http.Request)
+	// Called after each chunk.
+	Progress func(done, total int64)
+}
+
+// NewClient returns a client with the default settings.
+func NewClient() *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: 5 * time.Minute},
+		ChunkSize:  1 << 20,
+		Retries:    5,
+		RetryDelay: time.Second,
+	}
+}
+
+// Digest returns the hex-encoded SHA-256 of the data, used to identify
+// uploads.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// DigestHeader returns the value of the RFC 3230 Digest header for the data.
+func DigestHeader(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upload uploads the data to url, resuming from where the server has it.
+func (c *Client) Upload(url string, data []byte) error {
+	total := int64(len(data))
+	offset, err := c.uploadOffset(url)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	failures := 0
+	for offset < total {
+		end := offset + int64(c.ChunkSize)
+		if end > total {
+			end = total
+		}
+		err := c.putChunk(url, data[offset:end], offset, total)
+		if err == nil {
+			offset = end
+			failures = 0
+			if c.Progress != nil {
+				c.Progress(offset, total)
+			}
+			continue
+		}
+
+		failures++
+		if failures > c.Retries {
+			return errors.Annotatef(err, "uploading at offset %d", offset)
+		}
+		time.Sleep(c.RetryDelay * time.Duration(failures))
+		// The chunk could have been received even if the response wasn't, so
+		// ask the server where to continue from.
+		if o, err := c.uploadOffset(url); err == nil {
+			offset = o
+		}
+	}
+	return nil
+}
+
+func (c *Client) uploadOffset(url string) (int64, error) {
+	var resp *http.Response
+	var err error
+	for i := 0; i <= c.Retries; i++ {
+		if i > 0 {
+			time.Sleep(c.RetryDelay * time.Duration(i))
+		}
+		req, rerr := http.NewRequest("HEAD", url, nil)
+		if rerr != nil {
+			return 0, errors.Trace(rerr)
+		}
+		c.prepare(req)
+		if resp, err = c.HTTP.Do(req); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return 0, errors.Annotatef(ErrNotSupported, "invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+		}
+		return offset, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 0, ErrNotSupported
+	default:
+		// E.g. a proxy which doesn't know the endpoint, or doesn't let HEAD
+		// through
+		return 0, errors.Annotatef(ErrNotSupported, "upload status: %s", resp.Status)
+	}
+}
+
+func (c *Client) putChunk(url string, chunk []byte, offset, total int64) error {
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(chunk))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, total))
+	req.Header.Set("Digest", DigestHeader(chunk))
+	c.prepare(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("chunk upload status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Download downloads url to the file dst, resuming from the partial
+// download in dst+".part" if there is one, and checks the hex-encoded
+// SHA-256 digest, if given.
+func (c *Client) Download(url, dst, digest string) error {
+	part := dst + ".part"
+	failures := 0
+	for {
+		done, err := c.downloadPart(url, part)
+		if err == nil && done {
+			break
+		}
+		if err == nil {
+			// Connection dropped, but some data was received: not a failure
+			failures = 0
+			continue
+		}
+		failures++
+		if failures > c.Retries {
+			return errors.Trace(err)
+		}
+		time.Sleep(c.RetryDelay * time.Duration(failures))
+	}
+
+	if digest != "" {
+		f, err := os.Open(part)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != digest {
+			// Start over next time
+			os.Remove(part)
+			return errors.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+		}
+	}
+	return errors.Trace(os.Rename(part, dst))
+}
+
+// downloadPart appends to the partial download; returns true if it's
+// complete, false if the connection was dropped after receiving some data.
+func (c *Client) downloadPart(url, part string) (bool, error) {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	offset := fi.Size()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	c.prepare(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Either a fresh download, or the server ignores ranges
+		if offset > 0 {
+			if err := f.Truncate(0); err != nil {
+				return false, errors.Trace(err)
+			}
+		}
+		offset = 0
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Already have everything
+		return true, nil
+	default:
+		return false, errors.Errorf("download status: %s", resp.Status)
+	}
+
+	expected := resp.ContentLength
+	n, err := io.Copy(f, resp.Body)
+	if c.Progress != nil && expected >= 0 {
+		c.Progress(offset+n, offset+expected)
+	}
+	if err != nil {
+		if n > 0 {
+			return false, nil
+		}
+		return false, errors.Trace(err)
+	}
+	if expected >= 0 && n < expected {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *Client) prepare(req *http.Request) {
+	if c.Prepare != nil {
+		c.Prepare(req)
+	}
+}
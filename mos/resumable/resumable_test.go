@@ -0,0 +1,130 @@
+package resumable
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// uploadServer implements the upload protocol, failing every third request.
+type uploadServer struct {
+	mu       sync.Mutex
+	data     []byte
+	requests int
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests%3 == 0 {
+		// Receive the chunk, but pretend the connection dropped
+		if r.Method == "PUT" {
+			s.put(r)
+		}
+		http.Error(w, "oops", http.StatusBadGateway)
+		return
+	}
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+	case "PUT":
+		if !s.put(r) {
+			http.Error(w, "bad offset", http.StatusConflict)
+		}
+	}
+}
+
+func (s *uploadServer) put(r *http.Request) bool {
+	var first, last, total int
+	fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total)
+	body, _ := ioutil.ReadAll(r.Body)
+	if first != len(s.data) || r.Header.Get("Digest") != DigestHeader(body) {
+		return false
+	}
+	s.data = append(s.data, body...)
+	return true
+}
+
+func newTestClient() *Client {
+	c := NewClient()
+	c.ChunkSize = 10
+	c.RetryDelay = 0
+	return c
+}
+
+func TestUpload(t *testing.T) {
+	s := &uploadServer{}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10)
+	if err := newTestClient().Upload(ts.URL, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.data, data) {
+		t.Errorf("server got %q", s.data)
+	}
+}
+
+func TestUploadNotSupported(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusUnauthorized, http.StatusInternalServerError} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		if err := newTestClient().Upload(ts.URL, []byte("data")); errors.Cause(err) != ErrNotSupported {
+			t.Errorf("%d: expected ErrNotSupported, got %v", status, err)
+		}
+		ts.Close()
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 100)
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Drop the connection in the middle of the response
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:500])
+			return
+		}
+		http.ServeContent(w, r, "fw.zip", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "fw.zip")
+
+	if err := newTestClient().Download(ts.URL, dst, Digest(data)); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(dst)
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded data mismatch")
+	}
+	if requests != 2 {
+		t.Errorf("expected the download to be resumed, got %d requests", requests)
+	}
+
+	// Wrong digest
+	os.Remove(dst)
+	if err := newTestClient().Download(ts.URL, dst, Digest([]byte("other"))); err == nil {
+		t.Errorf("expected a digest mismatch")
+	}
+}
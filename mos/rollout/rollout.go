@@ -0,0 +1,122 @@
+// Package rollout contains helpers for applying changes to a fleet of devices
+// in stages: first to a canary subset, then, if the canaries stay healthy, to
+// the rest.
+package rollout
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// ParseDevices parses the list of devices, one address (as accepted by
+// --port) per line. Empty lines and lines starting with "#" are ignored.
+func ParseDevices(data []byte) []string {
+	var res []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+// Split splits devices into the canary subset and the rest. canary is either
+// a number of devices, like "3", or a percentage, like "10%"; a non-zero
+// percentage always selects at least one device.
+func Split(devices []string, canary string) ([]string, []string, error) {
+	n := 0
+	if strings.HasSuffix(canary, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(canary, "%"), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return nil, nil, errors.Errorf("invalid canary percentage %q", canary)
+		}
+		n = int(float64(len(devices)) * pct / 100)
+		if n == 0 && pct > 0 {
+			n = 1
+		}
+	} else {
+		var err error
+		n, err = strconv.Atoi(canary)
+		if err != nil || n < 0 {
+			return nil, nil, errors.Errorf("invalid canary size %q", canary)
+		}
+	}
+	if n > len(devices) {
+		n = len(devices)
+	}
+	return devices[:n], devices[n:], nil
+}
+
+// Check is a health check: an RPC which has to succeed and, optionally,
+// return the expected values.
+type Check struct {
+	Method string
+	Args   string
+	// Expect maps paths in the response, like "wifi.sta.status", to the
+	// expected values. Unset (null) values are compared as empty strings.
+	Expect map[string]string
+}
+
+// NewCheck creates a check calling the given method with the given args, with
+// expectations given as "path=value" strings.
+func NewCheck(method, args string, expect []string) (*Check, error) {
+	c := &Check{Method: method, Args: args, Expect: map[string]string{}}
+	for _, e := range expect {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid expectation %q, should be path=value", e)
+		}
+		c.Expect[parts[0]] = parts[1]
+	}
+	return c, nil
+}
+
+// Verify checks the response of the RPC against the expectations.
+func (c *Check) Verify(resp []byte) error {
+	if len(c.Expect) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(resp, &v); err != nil {
+		return errors.Annotatef(err, "invalid %s response", c.Method)
+	}
+	for path, want := range c.Expect {
+		got, err := getPath(v, path)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if got != want {
+			return errors.Errorf("%s: %s is %q, expected %q", c.Method, path, got, want)
+		}
+	}
+	return nil
+}
+
+func getPath(v interface{}, path string) (string, error) {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", errors.Errorf("no value at path %q", path)
+		}
+		if v, ok = m[key]; !ok {
+			return "", errors.Errorf("no value at path %q", path)
+		}
+	}
+	switch vv := v.(type) {
+	case string:
+		return vv, nil
+	case nil:
+		return "", nil
+	case float64, bool:
+		return fmt.Sprintf("%v", vv), nil
+	default:
+		data, _ := json.Marshal(vv)
+		return string(data), nil
+	}
+}
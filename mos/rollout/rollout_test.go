@@ -0,0 +1,74 @@
+package rollout
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	got := ParseDevices([]byte("# lab\nws://10.0.0.1/rpc\n\n  /dev/ttyUSB0  \n#ws://10.0.0.2/rpc\n"))
+	want := []string{"ws://10.0.0.1/rpc", "/dev/ttyUSB0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	devs := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	for _, c := range []struct {
+		canary string
+		n      int
+	}{
+		{"0", 0},
+		{"3", 3},
+		{"20", 10},
+		{"10%", 1},
+		{"25%", 2},
+		{"1%", 1},
+		{"0%", 0},
+		{"100%", 10},
+	} {
+		canary, rest, err := Split(devs, c.canary)
+		if err != nil {
+			t.Fatalf("%s: %s", c.canary, err)
+		}
+		if len(canary) != c.n || len(rest) != len(devs)-c.n {
+			t.Errorf("%s: got %d canaries and %d others, want %d", c.canary, len(canary), len(rest), c.n)
+		}
+	}
+	for _, canary := range []string{"", "-1", "x", "110%", "a%"} {
+		if _, _, err := Split(devs, canary); err == nil {
+			t.Errorf("%q: expected an error", canary)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	resp := []byte(`{"fw_version": "1.2", "wifi": {"sta_ip": "10.0.0.5", "status": "got ip"}, "uptime": 42, "ok": true, "id": null}`)
+
+	c, err := NewCheck("Sys.GetInfo", "", []string{"wifi.status=got ip", "uptime=42", "ok=true", "id="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(resp); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	for _, expect := range []string{"fw_version=1.3", "wifi.missing=x", "fw_version.x=1"} {
+		c, err := NewCheck("Sys.GetInfo", "", []string{expect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Verify(resp); err == nil {
+			t.Errorf("%s: expected an error", expect)
+		}
+	}
+
+	if _, err := NewCheck("Sys.GetInfo", "", []string{"noequals"}); err == nil {
+		t.Errorf("expected an error")
+	}
+	c, _ = NewCheck("Sys.GetInfo", "", nil)
+	if err := c.Verify([]byte("not json")); err != nil {
+		t.Errorf("no expectations, but got error: %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"cesanta.com/common/go/mgrpc"
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/common/go/ourjson"
+	"cesanta.com/mos/rpcseal"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	rpcPSKFile   = flag.String("rpc-psk-file", "", `Encrypt RPC requests and responses end to end with the pre-shared key in this file, or on the first line of stdin if it's "-"`)
+	rpcDeviceKey = flag.String("rpc-device-key", "", "Encrypt RPC requests and responses end to end with the key agreed on with the device public key (or certificate) in this PEM file")
+)
+
+// getRPCSealer returns the sealer for end to end RPC encryption, as
+// requested with --rpc-psk-file or --rpc-device-key, or nil if it's not.
+// The key itself is never given on the command line, where other users
+// could see it.
+func getRPCSealer() (*rpcseal.Sealer, error) {
+	switch {
+	case *rpcPSKFile != "" && *rpcDeviceKey != "":
+		return nil, errors.Errorf("--rpc-psk-file and --rpc-device-key are mutually exclusive")
+	case *rpcPSKFile != "":
+		psk, err := readRPCPSK(*rpcPSKFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "reading RPC PSK")
+		}
+		return rpcseal.NewPSK(psk)
+	case *rpcDeviceKey != "":
+		data, err := ioutil.ReadFile(*rpcDeviceKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		pub, err := rpcseal.ParsePublicKey(data)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid device key %s", *rpcDeviceKey)
+		}
+		return rpcseal.NewForDevice(pub)
+	}
+	return nil, nil
+}
+
+// readRPCPSK reads the pre-shared key from the file, or the first line of
+// stdin if the file is "-".
+func readRPCPSK(file string) ([]byte, error) {
+	var data []byte
+	if file == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Trace(err)
+		}
+		data = []byte(line)
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
+
+// sealedRPC sends all calls made through it to the device as sealed
+// RPC.Sealed calls.
+type sealedRPC struct {
+	mgrpc.MgRPC
+	s *rpcseal.Sealer
+}
+
+func wrapSealedRPC(s *rpcseal.Sealer) func(mgrpc.MgRPC) mgrpc.MgRPC {
+	return func(rpc mgrpc.MgRPC) mgrpc.MgRPC {
+		return &sealedRPC{MgRPC: rpc, s: s}
+	}
+}
+
+func (sr *sealedRPC) Call(
+	ctx context.Context, dst string, cmd *frame.Command, getCreds mgrpc.GetCredsCallback,
+) (*frame.Response, error) {
+	req := &rpcseal.Request{Method: cmd.Cmd}
+	if cmd.Args.IsInitialized() {
+		args, err := cmd.Args.MarshalJSON()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		req.Args = json.RawMessage(args)
+	}
+	reqFrame, err := sr.s.SealRequest(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Everything but the method and args goes as is, auth in particular
+	sealedCmd := *cmd
+	sealedCmd.Cmd = rpcseal.Method
+	sealedCmd.Args = ourjson.DelayMarshaling(reqFrame)
+	resp, err := sr.MgRPC.Call(ctx, dst, &sealedCmd, getCreds)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.Status != 0 {
+		// The device couldn't open the request, or doesn't support sealing at
+		// all: this is not a response to the original request
+		return nil, errors.Errorf("sealed RPC failed: %d %s (does the device support %s with this key?)",
+			resp.Status, resp.StatusMsg, rpcseal.Method)
+	}
+	respFrame := &rpcseal.Frame{}
+	if err := resp.Response.UnmarshalInto(respFrame); err != nil {
+		return nil, errors.Annotatef(err, "invalid sealed response")
+	}
+	inner, err := sr.s.OpenResponse(reqFrame, respFrame)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := &frame.Response{ID: resp.ID, Status: inner.Status, StatusMsg: inner.StatusMsg}
+	if len(inner.Resp) > 0 {
+		res.Response = ourjson.RawJSON(inner.Resp)
+	}
+	return res, nil
+}
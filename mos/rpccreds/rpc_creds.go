@@ -12,7 +12,18 @@ var (
 	rpcCreds = flag.String("rpc-creds", "", `Either "username:passwd" or "@filename" which contains username:passwd`)
 )
 
+// Lookup, if set, is called to get the credentials when --rpc-creds is not
+// given; they are returned as "username:passwd".
+var Lookup func() (string, error)
+
 func GetRPCCreds() (username, passwd string, err error) {
+	if *rpcCreds == "" && Lookup != nil {
+		creds, err := Lookup()
+		if err != nil {
+			return "", "", errors.Trace(err)
+		}
+		return getRPCCredsFromString(creds)
+	}
 	if len(*rpcCreds) > 0 && (*rpcCreds)[0] == '@' {
 		filename := (*rpcCreds)[1:]
 		data, err := ioutil.ReadFile(filename)
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/rpccreds"
+	"cesanta.com/mos/rpcrec"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	"github.com/kardianos/osext"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	recordFile       = flag.String("record", "", "Record all RPC requests and responses, and console output, of this invocation to the given file")
+	replayMock       = flag.String("replay-mock", "", "Answer RPC requests with the responses recorded in the given session file, instead of talking to the device")
+	replayLive       = flag.Bool("live", false, "mos replay: send the recorded requests which only read the device state to the device given by --port and compare the responses")
+	replayLiveWrites = flag.Bool("live-writes", false, "mos replay --live: also send the requests which change the device state, like Config.Set, FS.Put, Sys.Reboot or OTA.*")
+
+	recorder *rpcrec.Recorder
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "replay-mock", "live-writes")
+}
+
+// startRecording starts recording the session if --record is given; it's
+// saved by saveRecording, or on interrupt.
+func startRecording() {
+	if *recordFile == "" {
+		return
+	}
+	recorder = rpcrec.NewRecorder(stripRecordFlag(os.Args[1:]))
+
+	// Commands like console are only stopped by Ctrl-C
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		saveRecording()
+		os.Exit(130)
+	}()
+}
+
+func saveRecording() {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Save(*recordFile); err != nil {
+		glog.Errorf("failed to save the session: %s", err)
+		return
+	}
+	ourutil.Reportf("Session recorded to %s", *recordFile)
+}
+
+// stripRecordFlag returns args without --record, so that the recorded
+// command can be replayed as is.
+func stripRecordFlag(args []string) []string {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--record":
+			i++
+		case strings.HasPrefix(args[i], "--record="):
+		default:
+			res = append(res, args[i])
+		}
+	}
+	return res
+}
+
+// getReplayMockDevConn returns the connection to the device mocked from the
+// session given with --replay-mock, or nil if it's not given.
+func getReplayMockDevConn() (*dev.DevConn, error) {
+	if *replayMock == "" {
+		return nil, nil
+	}
+	s, err := rpcrec.Load(*replayMock)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return dev.NewDevConnWithRPC(rpcrec.NewMockRPC(s)), nil
+}
+
+// replayConsole prints the console output recorded in the session given with
+// --replay-mock, with the original timing.
+func replayConsole() error {
+	s, err := rpcrec.Load(*replayMock)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	start := time.Now()
+	for _, e := range s.Events {
+		if e.Type != rpcrec.EventConsole {
+			continue
+		}
+		if d := time.Duration(e.TimeMS)*time.Millisecond - time.Since(start); d > 0 {
+			time.Sleep(d)
+		}
+		os.Stdout.Write([]byte(e.Data))
+	}
+	return nil
+}
+
+// replayHandler implements "mos replay session.json": re-runs the recorded
+// command with the device mocked by the recorded responses or, with --live,
+// sends the recorded requests to the real device and reports the responses
+// which differ.
+func replayHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) != 1 {
+		return errors.Errorf("session file is required")
+	}
+	fname := args[0]
+	s, err := rpcrec.Load(fname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if *replayLive {
+		devConn, err := createDevConn(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer devConn.Disconnect(ctx)
+
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+
+		if !*replayLiveWrites {
+			var dropped int
+			if s, dropped = s.ReadOnly(); dropped > 0 {
+				ourutil.Reportf("Skipping %d requests which change the device state, use --live-writes to send them too", dropped)
+			}
+		}
+		total := len(s.RPCEvents())
+		ourutil.Reportf("Sending %d recorded requests...", total)
+		mm, err := rpcrec.Rerun(ctx, s, devConn.RPC, devConn.Dest, rpccreds.GetRPCCreds)
+		for _, m := range mm {
+			ourutil.Reportf("%s", m)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(mm) > 0 {
+			return errors.Errorf("%d of %d responses differ from the recorded ones", len(mm), total)
+		}
+		ourutil.Reportf("All %d responses match", total)
+		return nil
+	}
+
+	exe, err := osext.Executable()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Replaying: mos %s", strings.Join(s.Args, " "))
+	cmd := exec.Command(exe, append(append([]string{}, s.Args...), "--replay-mock", fname)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Annotatef(err, "replayed command failed")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package rpcrec
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"cesanta.com/common/go/mgrpc"
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/mos/redact"
+)
+
+// Recorder accumulates events of a session.
+type Recorder struct {
+	mu      sync.Mutex
+	session Session
+}
+
+// NewRecorder starts recording a session of the invocation with the given
+// args. Secrets, like passwords in the args, in Config.Set and Config.Get
+// payloads or in the console output, are redacted.
+func NewRecorder(args []string) *Recorder {
+	redacted := make([]string, len(args))
+	for i, a := range args {
+		redacted[i] = redact.String(a)
+	}
+	return &Recorder{
+		session: Session{
+			Version: sessionVersion,
+			Args:    redacted,
+			Started: time.Now(),
+			Events:  []*Event{},
+		},
+	}
+}
+
+func (r *Recorder) add(e *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.TimeMS = int64(time.Since(r.session.Started) / time.Millisecond)
+	r.session.Events = append(r.session.Events, e)
+}
+
+// RecordConsole records a chunk of the device console output.
+func (r *Recorder) RecordConsole(data []byte) {
+	r.add(&Event{Type: EventConsole, Data: redact.String(string(data))})
+}
+
+// Save writes the recorded session to the file.
+func (r *Recorder) Save(fname string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session.Save(fname)
+}
+
+// WrapRPC returns the RPC channel which records all calls made through it to
+// the given one.
+func (r *Recorder) WrapRPC(rpc mgrpc.MgRPC) mgrpc.MgRPC {
+	return &recordingRPC{MgRPC: rpc, r: r}
+}
+
+type recordingRPC struct {
+	mgrpc.MgRPC
+	r *Recorder
+}
+
+func (rr *recordingRPC) Call(
+	ctx context.Context, dst string, cmd *frame.Command, getCreds mgrpc.GetCredsCallback,
+) (*frame.Response, error) {
+	e := &Event{Type: EventRPC, Method: cmd.Cmd}
+	if cmd.Args.IsInitialized() {
+		if args, err := cmd.Args.MarshalJSON(); err == nil {
+			e.Args = json.RawMessage(redact.Bytes(args))
+		}
+	}
+
+	resp, err := rr.MgRPC.Call(ctx, dst, cmd, getCreds)
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.Status = resp.Status
+		e.StatusMsg = resp.StatusMsg
+		if resp.Response.IsInitialized() {
+			if data, err := resp.Response.MarshalJSON(); err == nil {
+				e.Response = json.RawMessage(redact.Bytes(data))
+			}
+		}
+	}
+	rr.r.add(e)
+
+	return resp, err
+}
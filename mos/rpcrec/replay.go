@@ -0,0 +1,156 @@
+package rpcrec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"cesanta.com/common/go/mgrpc"
+	"cesanta.com/common/go/mgrpc/codec"
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/common/go/ourjson"
+	"github.com/cesanta/errors"
+)
+
+// NewMockRPC returns the RPC channel which answers calls with the responses
+// recorded in the session, instead of talking to a device. Each recorded
+// call is answered once, in the recorded order; a call with the same method
+// and args is preferred, otherwise the next one with the same method is used.
+func NewMockRPC(s *Session) mgrpc.MgRPC {
+	return &mockRPC{events: s.RPCEvents(), used: map[int]bool{}}
+}
+
+type mockRPC struct {
+	mu     sync.Mutex
+	events []*Event
+	used   map[int]bool
+}
+
+func (m *mockRPC) find(method string, args []byte) *Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	match := -1
+	for i, e := range m.events {
+		if m.used[i] || e.Method != method {
+			continue
+		}
+		if jsonEqual(e.Args, args) {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil
+	}
+	m.used[match] = true
+	return m.events[match]
+}
+
+func (m *mockRPC) Call(
+	ctx context.Context, dst string, cmd *frame.Command, getCreds mgrpc.GetCredsCallback,
+) (*frame.Response, error) {
+	var args []byte
+	if cmd.Args.IsInitialized() {
+		args, _ = cmd.Args.MarshalJSON()
+	}
+	e := m.find(cmd.Cmd, args)
+	if e == nil {
+		return nil, errors.Errorf("replay: no recorded response for %s %s", cmd.Cmd, args)
+	}
+	if e.Error != "" {
+		return nil, errors.Errorf("%s", e.Error)
+	}
+	resp := &frame.Response{ID: cmd.ID, Status: e.Status, StatusMsg: e.StatusMsg}
+	if len(e.Response) > 0 {
+		resp.Response = ourjson.RawJSON(e.Response)
+	}
+	return resp, nil
+}
+
+func (m *mockRPC) AddHandler(method string, handler mgrpc.Handler) {}
+
+func (m *mockRPC) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockRPC) IsConnected() bool {
+	return true
+}
+
+func (m *mockRPC) SetCodecOptions(opts *codec.Options) error {
+	return nil
+}
+
+// Mismatch is a difference between the recorded and the actual response.
+type Mismatch struct {
+	Event    *Event
+	Expected string
+	Actual   string
+}
+
+func (mm *Mismatch) String() string {
+	return fmt.Sprintf("%s %s:\n  recorded: %s\n  actual:   %s", mm.Event.Method, mm.Event.Args, mm.Expected, mm.Actual)
+}
+
+// Rerun sends the recorded requests to the real device over the given RPC
+// channel, in the recorded order, and returns the responses which differ from
+// the recorded ones.
+func Rerun(ctx context.Context, s *Session, rpc mgrpc.MgRPC, dst string, getCreds mgrpc.GetCredsCallback) ([]*Mismatch, error) {
+	var res []*Mismatch
+	for _, e := range s.RPCEvents() {
+		cmd := &frame.Command{Cmd: e.Method}
+		if len(e.Args) > 0 {
+			cmd.Args = ourjson.RawJSON(e.Args)
+		}
+		resp, err := rpc.Call(ctx, dst, cmd, getCreds)
+		actual := describeResult(resp, err)
+		if expected := describeEvent(e); !resultsEqual(e, resp, err) {
+			res = append(res, &Mismatch{Event: e, Expected: expected, Actual: actual})
+		}
+		if err != nil && ctx.Err() != nil {
+			return res, errors.Trace(err)
+		}
+	}
+	return res, nil
+}
+
+func resultsEqual(e *Event, resp *frame.Response, err error) bool {
+	if err != nil || e.Error != "" {
+		// Errors are compared loosely: it only matters that there was one
+		return err != nil && e.Error != ""
+	}
+	if resp.Status != e.Status {
+		return false
+	}
+	var data []byte
+	if resp.Response.IsInitialized() {
+		data, _ = resp.Response.MarshalJSON()
+	}
+	return jsonEqual(data, e.Response)
+}
+
+func describeEvent(e *Event) string {
+	switch {
+	case e.Error != "":
+		return "error: " + e.Error
+	case e.Status != 0:
+		return fmt.Sprintf("status %d: %s", e.Status, e.StatusMsg)
+	}
+	return string(e.Response)
+}
+
+func describeResult(resp *frame.Response, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	e := &Event{Status: resp.Status, StatusMsg: resp.StatusMsg}
+	if resp.Response.IsInitialized() {
+		data, _ := resp.Response.MarshalJSON()
+		e.Response = json.RawMessage(data)
+	}
+	return describeEvent(e)
+}
@@ -0,0 +1,108 @@
+package rpcrec
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/common/go/ourjson"
+	"cesanta.com/mos/redact"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcrec_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "session.json")
+
+	// "Device" which is recorded is itself a mock of a handmade session
+	dev := NewMockRPC(&Session{Events: []*Event{
+		{Type: EventRPC, Method: "Config.Get", Args: []byte(`{"key":"a"}`), Response: []byte(`1`)},
+		{Type: EventRPC, Method: "Config.Get", Args: []byte(`{"key":"b"}`), Response: []byte(`2`)},
+		{Type: EventRPC, Method: "FS.Get", Status: 404, StatusMsg: "not found"},
+		{Type: EventRPC, Method: "Config.Set", Args: []byte(`{"config":{"wifi":{"sta":{"pass":"s3cr3t"}}}}`), Response: []byte(`{}`)},
+	}})
+
+	r := NewRecorder([]string{"config-get"})
+	rpc := r.WrapRPC(dev)
+	ctx := context.Background()
+	for _, c := range []struct{ method, args string }{
+		{"Config.Get", `{"key": "b"}`},
+		{"Config.Get", `{"key": "a"}`},
+		{"FS.Get", `{}`},
+		{"Config.Set", `{"config":{"wifi":{"sta":{"pass":"s3cr3t"}}}}`},
+	} {
+		cmd := &frame.Command{Cmd: c.method, Args: ourjson.RawJSON([]byte(c.args))}
+		if _, err := rpc.Call(ctx, "", cmd, nil); err != nil {
+			t.Fatalf("%s: %s", c.method, err)
+		}
+	}
+	r.RecordConsole([]byte("hello\n"))
+	if err := r.Save(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Events) != 5 || s.Events[4].Type != EventConsole || s.Args[0] != "config-get" {
+		t.Fatalf("unexpected session: %+v", s)
+	}
+	var setArgs struct {
+		Config struct{ Wifi struct{ Sta struct{ Pass string } } }
+	}
+	if err := json.Unmarshal(s.Events[3].Args, &setArgs); err != nil || setArgs.Config.Wifi.Sta.Pass != redact.Replacement {
+		t.Errorf("expected redacted args, got %s (%v)", s.Events[3].Args, err)
+	}
+	if st, err := os.Stat(fname); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("expected the session file to be 0600, got %v, %v", st.Mode(), err)
+	}
+
+	// Replay out of order: responses are matched by args
+	mock := NewMockRPC(s)
+	for _, c := range []struct{ args, expected string }{
+		{`{"key":"a"}`, "1"},
+		{`{"key":"b"}`, "2"},
+	} {
+		resp, err := mock.Call(ctx, "", &frame.Command{Cmd: "Config.Get", Args: ourjson.RawJSON([]byte(c.args))}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := resp.Response.MarshalJSON()
+		if string(data) != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.args, c.expected, data)
+		}
+	}
+	resp, err := mock.Call(ctx, "", &frame.Command{Cmd: "FS.Get"}, nil)
+	if err != nil || resp.Status != 404 {
+		t.Errorf("expected recorded status 404, got %+v, %v", resp, err)
+	}
+	if _, err := mock.Call(ctx, "", &frame.Command{Cmd: "FS.Get"}, nil); err == nil {
+		t.Errorf("expected an error when recorded responses are exhausted")
+	}
+
+	// Rerun against a "device" which now responds differently to one call
+	dev2 := NewMockRPC(&Session{Events: []*Event{
+		{Type: EventRPC, Method: "Config.Get", Args: []byte(`{"key":"b"}`), Response: []byte(`2`)},
+		{Type: EventRPC, Method: "Config.Get", Args: []byte(`{"key":"a"}`), Response: []byte(`100`)},
+		{Type: EventRPC, Method: "FS.Get", Status: 404, StatusMsg: "not found"},
+	}})
+	ro, dropped := s.ReadOnly()
+	if dropped != 1 {
+		t.Errorf("expected Config.Set to be dropped, dropped %d", dropped)
+	}
+	mm, err := Rerun(ctx, ro, dev2, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mm) != 1 || mm[0].Expected != "1" || mm[0].Actual != "100" {
+		t.Errorf("unexpected mismatches: %+v", mm)
+	}
+}
@@ -0,0 +1,138 @@
+// Package rpcrec records RPC sessions with the device (requests, responses
+// and console output) to a file, and replays them: either mocks the device
+// with the recorded responses, or sends the recorded requests to a real
+// device and compares the responses.
+package rpcrec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// Event types
+const (
+	EventRPC     = "rpc"
+	EventConsole = "console"
+)
+
+const sessionVersion = 1
+
+// Session is a recorded mos invocation.
+type Session struct {
+	Version int `json:"version"`
+	// Command line arguments of the recorded invocation, without the program
+	// name and the recording flags.
+	Args    []string  `json:"args"`
+	Started time.Time `json:"started"`
+	Events  []*Event  `json:"events"`
+}
+
+// Event is either an RPC call or a chunk of console output.
+type Event struct {
+	Type string `json:"type"`
+	// Time since the session start, in milliseconds.
+	TimeMS int64 `json:"time_ms"`
+
+	// For RPC events
+	Method string          `json:"method,omitempty"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	// Either the response or an error (e.g. a timeout) is recorded
+	Status    int             `json:"status,omitempty"`
+	StatusMsg string          `json:"status_msg,omitempty"`
+	Response  json.RawMessage `json:"resp,omitempty"`
+	Error     string          `json:"error,omitempty"`
+
+	// For console events
+	Data string `json:"data,omitempty"`
+}
+
+// Load reads the session from the file.
+func Load(fname string) (*Session, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Annotatef(err, "invalid session file %s", fname)
+	}
+	if s.Version != sessionVersion {
+		return nil, errors.Errorf("%s: unsupported session version %d", fname, s.Version)
+	}
+	return s, nil
+}
+
+// Save writes the session to the file. Secrets are redacted while recording,
+// but the file is still only readable by the user.
+func (s *Session) Save(fname string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(fname, append(data, '\n'), 0600); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// RPCEvents returns only the RPC events of the session.
+func (s *Session) RPCEvents() []*Event {
+	var res []*Event
+	for _, e := range s.Events {
+		if e.Type == EventRPC {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Prefixes of method names (after the service name) of the calls which don't
+// change the device state, like Sys.GetInfo or FS.List.
+var readOnlyPrefixes = []string{"Get", "List", "Describe", "Ping", "Read", "Status"}
+
+// IsReadOnly returns whether the method only reads the device state.
+func IsReadOnly(method string) bool {
+	name := method[strings.LastIndex(method, ".")+1:]
+	for _, p := range readOnlyPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnly returns the session with only the calls which don't change the
+// device state, see IsReadOnly, and the number of calls which are dropped.
+func (s *Session) ReadOnly() (*Session, int) {
+	res := *s
+	res.Events = nil
+	dropped := 0
+	for _, e := range s.Events {
+		if e.Type == EventRPC && !IsReadOnly(e.Method) {
+			dropped++
+			continue
+		}
+		res.Events = append(res.Events, e)
+	}
+	return &res, dropped
+}
+
+// jsonEqual compares two JSON documents, ignoring formatting and the order of
+// object keys. Empty documents are equal to "null".
+func jsonEqual(a, b []byte) bool {
+	var va, vb interface{}
+	if len(bytes.TrimSpace(a)) > 0 && json.Unmarshal(a, &va) != nil {
+		return bytes.Equal(a, b)
+	}
+	if len(bytes.TrimSpace(b)) > 0 && json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
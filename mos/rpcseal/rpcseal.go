@@ -0,0 +1,349 @@
+// Package rpcseal encrypts RPC requests and responses end to end, for talking
+// to devices over plain ws:// or UDP on networks which can't be trusted, when
+// TLS is not available on the device.
+//
+// A sealed request is sent as a call to the RPC.Sealed method, with Frame as
+// the args; the data is the Request, encrypted with AES-256-GCM. The device
+// answers with a Frame holding the encrypted Response. The key is either
+// derived from a pre-shared key, or agreed on with ECDH between an ephemeral
+// P-256 key of ours, sent with every request as "epk", and the key of the
+// device, e.g. the one in its crypto chip.
+//
+// The device side, which Device implements and the firmware has to follow
+// (the mongoose-os firmware doesn't implement RPC.Sealed yet, Device is the
+// reference for it and for tests):
+//
+//   - The key is HKDF-SHA256 of the pre-shared key, or of the X coordinate
+//     of the ECDH shared point (32 bytes, big-endian), with no salt and
+//     "mos-rpc-seal v1" as the info, 32 bytes long.
+//   - "nonce" and "data" of the frame are base64; "data" is the ciphertext
+//     with the 16 byte GCM tag appended.
+//   - The additional data of the request is "ts", the time the request was
+//     sealed in milliseconds since the epoch, as 8 bytes big-endian. The
+//     device rejects requests whose "ts" is further than MaxClockSkew from
+//     its clock, and the requests whose nonce it has already seen within
+//     that time, so that a captured request can't be replayed.
+//   - The response is sealed with the same key and a new random nonce, and
+//     the nonce of the request as the additional data, so that it can't be
+//     passed off as the answer to a different request. Errors opening the
+//     request are returned as the status of the RPC.Sealed call itself.
+package rpcseal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	// Method is the RPC method sealed requests are sent to.
+	Method = "RPC.Sealed"
+	// MinPSKLen is the minimum length of a pre-shared key.
+	MinPSKLen = 16
+	// MaxClockSkew is how far the time of a request may be from the clock of
+	// the device.
+	MaxClockSkew = time.Minute
+
+	kdfInfo = "mos-rpc-seal v1"
+)
+
+// Request is the plaintext of a sealed request.
+type Request struct {
+	Method string          `json:"method"`
+	Args   json.RawMessage `json:"args,omitempty"`
+}
+
+// Response is the plaintext of a sealed response.
+type Response struct {
+	Status    int             `json:"status"`
+	StatusMsg string          `json:"status_msg,omitempty"`
+	Resp      json.RawMessage `json:"resp,omitempty"`
+}
+
+// Frame is what is sent over the wire: the args of RPC.Sealed and its result.
+type Frame struct {
+	// Our ephemeral public key, uncompressed, in the device key mode.
+	EPK string `json:"epk,omitempty"`
+	// Time the request was sealed, in milliseconds since the epoch; only in
+	// requests.
+	TS    int64  `json:"ts,omitempty"`
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+// Sealer seals requests and opens responses.
+type Sealer struct {
+	aead cipher.AEAD
+	epk  []byte
+}
+
+// NewPSK returns the sealer with the key derived from the pre-shared key.
+func NewPSK(psk []byte) (*Sealer, error) {
+	if len(psk) < MinPSKLen {
+		return nil, errors.Errorf("pre-shared key is too short, need at least %d bytes", MinPSKLen)
+	}
+	return newSealer(deriveKey(psk), nil)
+}
+
+// NewForDevice returns the sealer with the key agreed on with the given
+// public key of the device.
+func NewForDevice(pub *ecdsa.PublicKey) (*Sealer, error) {
+	if pub.Curve != elliptic.P256() {
+		return nil, errors.Errorf("only P-256 device keys are supported")
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	x, _ := pub.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	epk := elliptic.Marshal(elliptic.P256(), priv.X, priv.Y)
+	return newSealer(deriveKey(padTo32(x.Bytes())), epk)
+}
+
+// ParsePublicKey parses a PEM-encoded P-256 public key, or a certificate.
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data found")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		pub = cert.PublicKey
+	default:
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("not an EC public key")
+	}
+	return ecPub, nil
+}
+
+func newSealer(key []byte, epk []byte) (*Sealer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Sealer{aead: aead, epk: epk}, nil
+}
+
+// SealRequest returns the frame to send as the args of RPC.Sealed.
+func (s *Sealer) SealRequest(req *Request) (*Frame, error) {
+	return s.sealRequestAt(req, time.Now())
+}
+
+func (s *Sealer) sealRequestAt(req *Request, now time.Time) (*Frame, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ts := now.UnixNano() / int64(time.Millisecond)
+	f, err := seal(s.aead, data, requestAD(ts))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f.TS = ts
+	if s.epk != nil {
+		f.EPK = base64.StdEncoding.EncodeToString(s.epk)
+	}
+	return f, nil
+}
+
+// OpenResponse decrypts the response to the given request frame.
+func (s *Sealer) OpenResponse(reqFrame, respFrame *Frame) (*Response, error) {
+	reqNonce, err := base64.StdEncoding.DecodeString(reqFrame.Nonce)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := open(s.aead, respFrame, reqNonce)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to open the sealed response")
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return resp, nil
+}
+
+// Device opens sealed requests and seals the responses to them, as the
+// firmware has to; see the package doc.
+type Device struct {
+	psk  []byte
+	priv *ecdsa.PrivateKey
+	now  func() time.Time
+
+	mu sync.Mutex
+	// Nonces of the requests seen within MaxClockSkew, with their times.
+	seen map[string]time.Time
+}
+
+// NewDevicePSK returns the device side with the pre-shared key.
+func NewDevicePSK(psk []byte) (*Device, error) {
+	if len(psk) < MinPSKLen {
+		return nil, errors.Errorf("pre-shared key is too short, need at least %d bytes", MinPSKLen)
+	}
+	return &Device{psk: psk, now: time.Now, seen: map[string]time.Time{}}, nil
+}
+
+// NewDeviceWithKey returns the device side with the private key of the device.
+func NewDeviceWithKey(priv *ecdsa.PrivateKey) (*Device, error) {
+	if priv.Curve != elliptic.P256() {
+		return nil, errors.Errorf("only P-256 device keys are supported")
+	}
+	return &Device{priv: priv, now: time.Now, seen: map[string]time.Time{}}, nil
+}
+
+func (d *Device) aead(f *Frame) (cipher.AEAD, error) {
+	if d.priv == nil {
+		return newAEAD(deriveKey(d.psk))
+	}
+	epk, err := base64.StdEncoding.DecodeString(f.EPK)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), epk)
+	if x == nil {
+		return nil, errors.Errorf("invalid epk")
+	}
+	sx, _ := elliptic.P256().ScalarMult(x, y, d.priv.D.Bytes())
+	return newAEAD(deriveKey(padTo32(sx.Bytes())))
+}
+
+// OpenRequest decrypts the request, rejecting the ones which are too old or
+// replayed.
+func (d *Device) OpenRequest(f *Frame) (*Request, error) {
+	aead, err := d.aead(f)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := open(aead, f, requestAD(f.TS))
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to open the sealed request")
+	}
+	now := d.now()
+	ts := time.Unix(0, f.TS*int64(time.Millisecond))
+	if ts.Before(now.Add(-MaxClockSkew)) || ts.After(now.Add(MaxClockSkew)) {
+		return nil, errors.Errorf("request time %s is too far from ours, %s", ts.UTC(), now.UTC())
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for n, t := range d.seen {
+		if t.Before(now.Add(-2 * MaxClockSkew)) {
+			delete(d.seen, n)
+		}
+	}
+	if _, ok := d.seen[f.Nonce]; ok {
+		return nil, errors.Errorf("replayed request")
+	}
+	d.seen[f.Nonce] = ts
+	req := &Request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return req, nil
+}
+
+// SealResponse returns the frame to answer the given request frame with.
+func (d *Device) SealResponse(reqFrame *Frame, resp *Response) (*Frame, error) {
+	aead, err := d.aead(reqFrame)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reqNonce, err := base64.StdEncoding.DecodeString(reqFrame.Nonce)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return seal(aead, data, reqNonce)
+}
+
+// deriveKey is HKDF-SHA256 (RFC 5869) with no salt, for a single block of
+// output.
+func deriveKey(secret []byte) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(kdfInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func requestAD(ts int64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, uint64(ts))
+	return ad
+}
+
+func padTo32(b []byte) []byte {
+	if len(b) >= 32 {
+		return b
+	}
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return aead, nil
+}
+
+func seal(aead cipher.AEAD, data, ad []byte) (*Frame, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Frame{
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+		Data:  base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, data, ad)),
+	}, nil
+}
+
+func open(aead cipher.AEAD, f *Frame, ad []byte) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(f.Nonce)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.Errorf("invalid nonce size %d", len(nonce))
+	}
+	data, err := base64.StdEncoding.DecodeString(f.Data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	plain, err := aead.Open(nil, nonce, data, ad)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,128 @@
+package rpcseal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+// device answers the sealed request like the firmware would.
+func device(t *testing.T, d *Device, reqFrame *Frame, resp *Response) *Frame {
+	req, err := d.OpenRequest(reqFrame)
+	if err != nil {
+		t.Fatalf("device failed to open the request: %s", err)
+	}
+	if req.Method != "Sys.GetInfo" {
+		t.Errorf("got method %q", req.Method)
+	}
+	f, err := d.SealResponse(reqFrame, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestPSK(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	s, err := NewPSK(psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqFrame, err := s.SealRequest(&Request{Method: "Sys.GetInfo", Args: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqFrame.EPK != "" {
+		t.Errorf("unexpected epk in PSK mode")
+	}
+
+	d, _ := NewDevicePSK(psk)
+	respFrame := device(t, d, reqFrame, &Response{Resp: json.RawMessage(`{"arch":"esp32"}`)})
+	resp, err := s.OpenResponse(reqFrame, respFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Resp) != `{"arch":"esp32"}` {
+		t.Errorf("got response %s", resp.Resp)
+	}
+
+	// The response to one request is not accepted as the response to another
+	otherFrame, _ := s.SealRequest(&Request{Method: "Sys.GetInfo"})
+	if _, err := s.OpenResponse(otherFrame, respFrame); err == nil {
+		t.Errorf("response accepted for a different request")
+	}
+
+	// Wrong key
+	wrong, _ := NewPSK([]byte("fedcba9876543210"))
+	if _, err := wrong.OpenResponse(reqFrame, respFrame); err == nil {
+		t.Errorf("response opened with a wrong key")
+	}
+
+	if _, err := NewPSK([]byte("short")); err == nil {
+		t.Errorf("short key accepted")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	s, _ := NewPSK(psk)
+	d, _ := NewDevicePSK(psk)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	reqFrame, _ := s.sealRequestAt(&Request{Method: "Sys.GetInfo"}, now)
+	if _, err := d.OpenRequest(reqFrame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.OpenRequest(reqFrame); err == nil {
+		t.Errorf("replayed request accepted")
+	}
+
+	// The time is authenticated
+	reqFrame, _ = s.sealRequestAt(&Request{Method: "Sys.GetInfo"}, now)
+	reqFrame.TS++
+	if _, err := d.OpenRequest(reqFrame); err == nil {
+		t.Errorf("request with a changed time accepted")
+	}
+
+	reqFrame, _ = s.sealRequestAt(&Request{Method: "Sys.GetInfo"}, now.Add(-2*MaxClockSkew))
+	if _, err := d.OpenRequest(reqFrame); err == nil {
+		t.Errorf("old request accepted")
+	}
+}
+
+func TestDeviceKey(t *testing.T) {
+	devKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&devKey.PublicKey)
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewForDevice(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqFrame, err := s.SealRequest(&Request{Method: "Sys.GetInfo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The device derives the key from the ephemeral key of ours
+	d, _ := NewDeviceWithKey(devKey)
+	respFrame := device(t, d, reqFrame, &Response{Status: 404, StatusMsg: "no handler"})
+	resp, err := s.OpenResponse(reqFrame, respFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 404 || resp.StatusMsg != "no handler" {
+		t.Errorf("got %+v", resp)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"context"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/build/fuzz"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	runSim   = flag.Bool("sim", false, "mos run: build the app for the POSIX platform and run it on the host")
+	sanitize = flag.Bool("sanitize", false, "mos build, mos run: build the POSIX platform firmware with AddressSanitizer and UndefinedBehaviorSanitizer")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "sim", "sanitize")
+}
+
+// simPlatform is the mongoose-os platform which builds the firmware as a
+// POSIX executable.
+const simPlatform = "ubuntu"
+
+// sanitizerFlags are added to the compiler flags of sanitized builds, and
+// given to the linker with the APP_LDFLAGS build var.
+var sanitizerFlags = []string{"-fsanitize=address,undefined", "-fno-omit-frame-pointer", "-g"}
+
+// addSanitizerFlags adds the sanitizer flags to the manifest, for the
+// sanitized build.
+func addSanitizerFlags(manifest *build.FWAppManifest) error {
+	if manifest.Platform != simPlatform {
+		return errors.Errorf("--sanitize is only supported for the %s platform, not %s", simPlatform, manifest.Platform)
+	}
+	manifest.CFlags = append(manifest.CFlags, sanitizerFlags...)
+	manifest.CXXFlags = append(manifest.CXXFlags, sanitizerFlags...)
+	appendBuildVar(manifest, "APP_LDFLAGS", strings.Join(sanitizerFlags, " "))
+	return nil
+}
+
+// runHandler implements "mos run --sim [--sanitize] [args...]": builds the
+// app locally for the POSIX platform and runs it on the host with the args.
+// Sanitizer reports are summarized at the end.
+func runHandler(ctx context.Context, devConn *dev.DevConn) error {
+	if !*runSim {
+		return errors.Errorf("only running on the host is supported, use --sim")
+	}
+	if runtime.GOOS != "linux" {
+		return errors.Errorf("the %s platform firmware only runs on Linux", simPlatform)
+	}
+	*local = true
+	bParams, err := newBuildParams(simPlatform)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := doBuild(ctx, bParams); err != nil {
+		return errors.Trace(err)
+	}
+
+	elf := moscommon.GetFirmwareElfFilePath(moscommon.GetBuildDir(projectDir))
+	reportf("Running %s...", elf)
+	cmd := exec.CommandContext(ctx, elf, flag.Args()[1:]...)
+	cmd.Dir = projectDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	cmd.Env = append(os.Environ(),
+		"ASAN_OPTIONS=symbolize=1:detect_leaks=1",
+		"UBSAN_OPTIONS=print_stacktrace=1",
+	)
+	runErr := cmd.Run()
+
+	// Sanitizer reports have the same format as the fuzzer ones
+	crashes, err := fuzz.ParseCrashes(&stderr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(crashes) > 0 {
+		reportf("\nSanitizers reported %d errors:", len(crashes))
+		for _, c := range crashes {
+			reportf("%s", c.Summary)
+			if len(c.Stack) > 0 {
+				reportf("    %s", c.Stack[0])
+			}
+		}
+		return errors.Errorf("%d sanitizer errors", len(crashes))
+	}
+	return errors.Trace(runErr)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cesanta.com/mos/build"
+	"cesanta.com/mos/build/rust"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var rustImage = flag.String("rust-image", "", "mos build: container image with cargo and cbindgen to build the Rust crates of rust_crates with, instead of the build image pinned in mos.lock")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "rust-image")
+}
+
+// buildRustCrates builds the Rust crates of the manifest in outDir, and
+// returns the static libs and the dir with the generated headers.
+func buildRustCrates(manifest *build.FWAppManifest, appDir, outDir string) ([]string, string, error) {
+	if len(manifest.RustCrates) == 0 {
+		return nil, "", nil
+	}
+	image, err := getToolchainImage(*rustImage)
+	if err != nil {
+		return nil, "", errors.Annotatef(err, "building Rust crates (use --rust-image to choose an image with cargo)")
+	}
+	if outDir, err = filepath.Abs(outDir); err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	includeDir := filepath.Join(outDir, "include")
+	var libs []string
+	for _, c := range manifest.RustCrates {
+		crateDir := c.Path
+		if !filepath.IsAbs(crateDir) {
+			crateDir = filepath.Join(appDir, crateDir)
+		}
+		cargoToml, err := ioutil.ReadFile(filepath.Join(crateDir, "Cargo.toml"))
+		if err != nil {
+			return nil, "", errors.Annotatef(err, "Rust crate %s", c.Path)
+		}
+		pkgName, libName, err := rust.Names(cargoToml)
+		if err != nil {
+			return nil, "", errors.Annotatef(err, "Rust crate %s", c.Path)
+		}
+		target, err := rust.Target(&c, manifest.Platform)
+		if err != nil {
+			return nil, "", errors.Annotatef(err, "Rust crate %s", c.Path)
+		}
+		bld := &rust.Build{
+			CrateDir:    getPathForDocker(crateDir),
+			TargetDir:   getPathForDocker(outDir),
+			Header:      getPathForDocker(filepath.Join(includeDir, libName+".h")),
+			Target:      target,
+			Features:    c.Features,
+			PackageName: pkgName,
+			LibName:     libName,
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, libName+".sh"), []byte(bld.Script()), 0644); err != nil {
+			return nil, "", errors.Trace(err)
+		}
+		freportf(logWriter, "Building Rust crate %s for %s...", libName, target)
+		out, err := runToolchainCommand(image, mountRoots([]string{crateDir, outDir}), crateDir, outDir,
+			[]string{"/bin/sh", getPathForDocker(filepath.Join(outDir, libName+".sh"))})
+		freportf(logWriter, "%s", out)
+		if err != nil {
+			return nil, "", errors.Annotatef(err, "building Rust crate %s: %s", c.Path, out)
+		}
+		libs = append(libs, filepath.Join(outDir, filepath.FromSlash(bld.Lib())))
+	}
+	return libs, includeDir, nil
+}
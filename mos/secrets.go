@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"cesanta.com/mos/common/paths"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var ageKey = flag.String("age-key", "", "age identity file to decrypt the encrypted values of config schema and config values with; by default, $SOPS_AGE_KEY_FILE or ~/.config/sops/age/keys.txt, shared with sops")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "age-key")
+}
+
+// getAgeKeyFile returns the age identity file to use.
+func getAgeKeyFile() (string, error) {
+	keyFile := *ageKey
+	if keyFile == "" {
+		keyFile = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if keyFile == "" {
+		keyFile = "~/.config/sops/age/keys.txt"
+	}
+	keyFile, err := paths.NormalizePath(keyFile, "")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return "", errors.Annotatef(err, "age key is needed to decrypt the values, use --age-key")
+	}
+	return keyFile, nil
+}
+
+// decryptAgeValue decrypts the armored value with the age binary.
+func decryptAgeValue(armored string) (string, error) {
+	keyFile, err := getAgeKeyFile()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("age", "--decrypt", "-i", keyFile)
+	cmd.Stdin = strings.NewReader(armored + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Annotatef(err, "age failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
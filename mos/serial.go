@@ -1,13 +1,36 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/farm"
+	"cesanta.com/mos/serialterm"
 	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	serialEcho      = flag.Bool("echo", false, "mos serial: show typed characters locally")
+	serialEOL       = flag.String("eol", "crlf", "mos serial: line ending sent for Enter: cr, lf or crlf")
+	serialSendFile  = flag.String("send-file", "", "mos serial: file to send to the device on start and on Ctrl-T f")
+	serialSendDelay = flag.Duration("send-delay", 0, "mos serial: delay between chunks of the file sent, for devices with small buffers")
 )
 
 var defaultPort string
 
 func getPort() (string, error) {
 	if *portFlag != "auto" {
+		// Names of registered devices can be used instead of ports
+		if port := lookupDevicePort(*portFlag); port != "" {
+			return port, nil
+		}
 		return *portFlag, nil
 	}
 	if defaultPort == "" {
@@ -19,3 +42,172 @@ func getPort() (string, error) {
 	}
 	return defaultPort, nil
 }
+
+// Size of the chunks the file is sent in
+const serialSendChunkSize = 64
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "echo", "eol", "send-file", "send-delay")
+}
+
+// serialHandler implements "mos serial", a raw terminal for devices which
+// don't run mongoose-os, like vendor bootloaders: the device output is shown
+// as is, and keys are sent as they are typed, with Enter sent as --eol.
+// Ctrl-] exits, Ctrl-T f sends --send-file, Ctrl-T e toggles local echo. If
+// stdin is not a terminal, or on Windows, input is sent line by line.
+func serialHandler(ctx context.Context, devConn *dev.DevConn) error {
+	eol, err := serialterm.ParseEOL(*serialEOL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	port, err := getPort()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if farm.IsPort(port) {
+		return errors.Errorf("mos serial doesn't support farm ports, use mos console")
+	}
+
+	cp, err := newConsolePort(port)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := cp.resume(); err != nil {
+		return errors.Trace(err)
+	}
+	if h, err := registerPortHolder(port, cp.suspend, cp.resume); err == nil {
+		defer h.Close()
+	} else {
+		glog.Warningf("failed to register %s for sharing: %s", port, err)
+	}
+
+	raw := false
+	if restore, err := makeStdinRaw(); err == nil {
+		raw = true
+		defer restore()
+	} else {
+		glog.Infof("using the line mode: %s", err)
+	}
+	serialReportf("%s, exit with Ctrl-], Ctrl-T f sends the file, Ctrl-T e toggles echo", port)
+
+	var outMu sync.Mutex
+	write := func(data []byte) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		os.Stdout.Write(data)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	go func() { // Serial -> Stdout
+		out := &serialterm.Output{}
+		for {
+			buf := make([]byte, 100)
+			s := cp.wait()
+			if s == nil {
+				serialReportf("%s failed to reopen", port)
+				cancel()
+				return
+			}
+			n, err := s.Read(buf)
+			if n > 0 {
+				if recorder != nil {
+					recorder.RecordConsole(buf[:n])
+				}
+				if raw {
+					write(out.Translate(buf[:n]))
+				} else {
+					write(buf[:n])
+				}
+			}
+			if err != nil {
+				if cp.waitResumed(s) {
+					continue
+				}
+				serialReportf("read err %s", err)
+				cancel()
+				return
+			}
+		}
+	}()
+	go func() { // Stdin -> Serial
+		defer cancel()
+		if *serialSendFile != "" {
+			if err := serialSendFileTo(cp); err != nil {
+				serialReportf("%s", err)
+			}
+		}
+		// If no input, just block forever
+		if noInput {
+			select {}
+		}
+		in := &serialterm.Input{EOL: eol}
+		echo := *serialEcho
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				send, echoText, action := in.Key(buf[0])
+				switch action {
+				case serialterm.ActionExit:
+					return
+				case serialterm.ActionToggleEcho:
+					echo = !echo
+					serialReportf("local echo %t", echo)
+				case serialterm.ActionSendFile:
+					if err := serialSendFileTo(cp); err != nil {
+						serialReportf("%s", err)
+					}
+				}
+				// Input is dropped while the port is released
+				if s := cp.get(); s != nil && len(send) > 0 {
+					s.Write(send)
+				}
+				if echo && raw {
+					write(echoText)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	<-cctx.Done()
+	return nil
+}
+
+// serialSendFileTo sends --send-file to the port, in chunks with
+// --send-delay between them.
+func serialSendFileTo(cp *sharedConsolePort) error {
+	if *serialSendFile == "" {
+		return errors.Errorf("no file to send, use --send-file")
+	}
+	data, err := ioutil.ReadFile(*serialSendFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	serialReportf("sending %s, %d bytes", *serialSendFile, len(data))
+	for i := 0; i < len(data); i += serialSendChunkSize {
+		chunk := data[i:]
+		if len(chunk) > serialSendChunkSize {
+			chunk = chunk[:serialSendChunkSize]
+		}
+		s := cp.get()
+		if s == nil {
+			return errors.Errorf("port is released, sending aborted")
+		}
+		if _, err := s.Write(chunk); err != nil {
+			return errors.Annotatef(err, "sending %s", *serialSendFile)
+		}
+		if *serialSendDelay > 0 {
+			time.Sleep(*serialSendDelay)
+		}
+	}
+	serialReportf("sent %s", *serialSendFile)
+	return nil
+}
+
+// serialReportf prints a status line of the terminal; line ends are explicit
+// since the terminal may be in the raw mode.
+func serialReportf(f string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r\n--- "+f+" ---\r\n", args...)
+}
@@ -0,0 +1,96 @@
+// Package serialterm implements the key handling of the raw serial terminal:
+// translation of line endings of the input, local echo, and the keys which
+// control the terminal itself rather than being sent to the device.
+package serialterm
+
+import (
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// Keys which are not sent to the device
+const (
+	// ExitKey (Ctrl-]) exits the terminal.
+	ExitKey = 0x1d
+	// MenuKey (Ctrl-T) is followed by a command key: f sends the file,
+	// e toggles local echo; Ctrl-T sends Ctrl-T itself.
+	MenuKey = 0x14
+)
+
+// Action is what the terminal has to do in response to a key.
+type Action int
+
+const (
+	ActionNone Action = iota
+	ActionExit
+	ActionSendFile
+	ActionToggleEcho
+)
+
+// ParseEOL returns the line ending for its name: cr, lf or crlf.
+func ParseEOL(name string) ([]byte, error) {
+	switch strings.ToLower(name) {
+	case "cr":
+		return []byte("\r"), nil
+	case "lf":
+		return []byte("\n"), nil
+	case "crlf":
+		return []byte("\r\n"), nil
+	}
+	return nil, errors.Errorf("unknown line ending %q, expected cr, lf or crlf", name)
+}
+
+// Input processes the keys typed by the user.
+type Input struct {
+	// Line ending sent for Enter
+	EOL []byte
+
+	menu bool
+}
+
+// Key processes the key and returns the bytes to send to the device, the
+// text to show if local echo is on, and the action to take.
+func (in *Input) Key(c byte) ([]byte, []byte, Action) {
+	if in.menu {
+		in.menu = false
+		switch c {
+		case 'f', 'F':
+			return nil, nil, ActionSendFile
+		case 'e', 'E':
+			return nil, nil, ActionToggleEcho
+		case MenuKey:
+			return []byte{c}, nil, ActionNone
+		}
+		return nil, nil, ActionNone
+	}
+	switch c {
+	case ExitKey:
+		return nil, nil, ActionExit
+	case MenuKey:
+		in.menu = true
+		return nil, nil, ActionNone
+	case '\r', '\n':
+		// Enter is \r in the raw mode of the terminal, and \n otherwise
+		return in.EOL, []byte("\r\n"), ActionNone
+	}
+	return []byte{c}, []byte{c}, ActionNone
+}
+
+// Output converts the device output for the terminal in the raw mode, which
+// doesn't return the carriage on line feeds by itself: lone \n become \r\n.
+type Output struct {
+	lastCR bool
+}
+
+func (o *Output) Translate(data []byte) []byte {
+	res := make([]byte, 0, len(data))
+	for _, c := range data {
+		if c == '\n' && !o.lastCR {
+			res = append(res, '\r')
+		}
+		res = append(res, c)
+		o.lastCR = (c == '\r')
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+package serialterm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInput(t *testing.T) {
+	eol, err := ParseEOL("crlf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &Input{EOL: eol}
+	var sent, echo []byte
+	var actions []Action
+	for _, c := range []byte("ab\r\x14e\x14\x14\x14xc\x14f\x1d") {
+		s, e, a := in.Key(c)
+		sent = append(sent, s...)
+		echo = append(echo, e...)
+		if a != ActionNone {
+			actions = append(actions, a)
+		}
+	}
+	if exp := []byte("ab\r\n\x14c"); !bytes.Equal(sent, exp) {
+		t.Errorf("expected to send %q, got %q", exp, sent)
+	}
+	if exp := []byte("ab\r\nc"); !bytes.Equal(echo, exp) {
+		t.Errorf("expected to echo %q, got %q", exp, echo)
+	}
+	if exp := []Action{ActionToggleEcho, ActionSendFile, ActionExit}; len(actions) != len(exp) ||
+		actions[0] != exp[0] || actions[1] != exp[1] || actions[2] != exp[2] {
+		t.Errorf("expected actions %v, got %v", exp, actions)
+	}
+	if _, err := ParseEOL("lfcr"); err == nil {
+		t.Errorf("expected an error for an unknown line ending")
+	}
+}
+
+func TestOutput(t *testing.T) {
+	o := &Output{}
+	got := append(o.Translate([]byte("a\nb\r")), o.Translate([]byte("\nc\r\n"))...)
+	if exp := []byte("a\r\nb\r\nc\r\n"); !bytes.Equal(got, exp) {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
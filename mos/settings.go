@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/settings"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	userSettingsFile = flag.String("user-settings-file", "~/.mos/settings.yml", "User settings, used for the flags not given explicitly and not set by the project settings")
+	settingsGlobal   = flag.Bool("global", false, "mos settings: show or edit the user settings instead of the project ones")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "user-settings-file", "global")
+}
+
+// projectSettingsFlags are the flags the project settings may set. The
+// project settings come with the project, which may be someone else's, so
+// they are limited to the flags which select the device and the build;
+// anything that changes where mos connects to or what it's allowed to do,
+// like --server, --yes-i-mean-it, --policy or credentials, can only be set by
+// the user settings.
+var projectSettingsFlags = map[string]bool{
+	"port":                 true,
+	"baud-rate":            true,
+	"board":                true,
+	"platform":             true,
+	"local":                true,
+	"build-var":            true,
+	"cflags-extra":         true,
+	"cxxflags-extra":       true,
+	"build-parallelism":    true,
+	"prefer-prebuilt-libs": true,
+	"console-encoding":     true,
+	"eol":                  true,
+	"echo":                 true,
+}
+
+func checkProjectSettingsFlag(name string) error {
+	if !projectSettingsFlags[name] {
+		return errors.Errorf("--%s can't be set in the project settings, only in the user settings (mos settings --global)", name)
+	}
+	return nil
+}
+
+func getUserSettingsFilePath() (string, error) {
+	return paths.NormalizePath(*userSettingsFile, "")
+}
+
+// applySettings sets the flags which are not given on the command line or in
+// the environment from .mos/settings.yml of the project, if there is one, and
+// then from the user settings.
+func applySettings() error {
+	userPath, err := getUserSettingsFilePath()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, l := range []struct {
+		path   string
+		source string
+	}{
+		{moscommon.GetSettingsFilePath(projectDir), sourceProjectSettings},
+		{userPath, sourceUserSettings},
+	} {
+		s, err := settings.Load(l.path)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for name, value := range s.FlagValues() {
+			f := flag.Lookup(name)
+			if f == nil {
+				return errors.Errorf("%s: unknown flag --%s", l.path, name)
+			}
+			if l.source == sourceProjectSettings {
+				if err := checkProjectSettingsFlag(name); err != nil {
+					return errors.Annotatef(err, "%s", l.path)
+				}
+			}
+			if f.Changed {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return errors.Annotatef(err, "%s: --%s", l.path, name)
+			}
+			flagSources[name] = fmt.Sprintf("%s (%s)", l.source, l.path)
+		}
+	}
+	return nil
+}
+
+// settingsHandler implements "mos settings", which shows the project
+// settings, "mos settings set <key> <value>" and "mos settings unset <key>".
+// Keys are port, board, build (local or remote) and flags.<name>, for the
+// default value of another flag, see projectSettingsFlags. With --global, the
+// user settings, which can set any flag, are used instead.
+func settingsHandler(ctx context.Context, devConn *dev.DevConn) error {
+	path := moscommon.GetSettingsFilePath(projectDir)
+	if *settingsGlobal {
+		var err error
+		if path, err = getUserSettingsFilePath(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	s, err := settings.Load(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	args := flag.Args()[1:]
+	if len(args) == 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, key := range s.Keys() {
+			v, _ := s.Get(key)
+			fmt.Fprintf(w, "%s\t%s\n", key, v)
+		}
+		return errors.Trace(w.Flush())
+	}
+	switch args[0] {
+	case "set":
+		if len(args) != 3 {
+			return errors.Errorf("usage: mos settings set <key> <value>")
+		}
+		if err := s.Set(args[1], args[2]); err != nil {
+			return errors.Trace(err)
+		}
+		for name := range s.FlagValues() {
+			if flag.Lookup(name) == nil {
+				return errors.Errorf("unknown flag --%s", name)
+			}
+			if !*settingsGlobal {
+				if err := checkProjectSettingsFlag(name); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	case "unset":
+		if len(args) != 2 {
+			return errors.Errorf("usage: mos settings unset <key>")
+		}
+		if _, ok := s.Get(args[1]); !ok {
+			return errors.Errorf("%s is not set", args[1])
+		}
+		if err := s.Set(args[1], ""); err != nil {
+			return errors.Trace(err)
+		}
+	default:
+		return errors.Errorf("unknown command %q, expected set or unset", args[0])
+	}
+	return errors.Trace(s.Save(path))
+}
@@ -0,0 +1,168 @@
+// Package settings implements .mos/settings.yml, the project-local settings:
+// the preferred port, board profile, build backend and default values of
+// other flags, which mos commands run in the project dir pick up
+// automatically, so that they don't have to be given on every command line.
+// The user settings, ~/.mos/settings.yml, have the same format.
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cesanta.com/common/go/ourio"
+	"github.com/cesanta/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// BuildLocal is the build backend which builds in a local container.
+	BuildLocal = "local"
+	// BuildRemote is the build backend which uses the build server.
+	BuildRemote = "remote"
+
+	flagsKeyPrefix = "flags."
+)
+
+// Settings is the contents of .mos/settings.yml.
+type Settings struct {
+	// Port the device of the project is connected to, like --port.
+	Port string `yaml:"port,omitempty"`
+	// Board profile, like --board.
+	Board string `yaml:"board,omitempty"`
+	// Build backend, BuildLocal or BuildRemote.
+	Build string `yaml:"build,omitempty"`
+	// Default values of other flags, by flag name without the dashes.
+	Flags map[string]string `yaml:"flags,omitempty"`
+}
+
+// Load reads the settings file; if the file does not exist, empty settings
+// are returned.
+func Load(path string) (*Settings, error) {
+	s := &Settings{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, errors.Annotatef(err, "parsing %s", path)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, errors.Annotatef(err, "%s", path)
+	}
+	return s, nil
+}
+
+// Save writes the settings file, creating its dir if needed.
+func (s *Settings) Save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ourio.WriteFileIfDiffers(path, data, 0644))
+}
+
+func (s *Settings) Validate() error {
+	switch s.Build {
+	case "", BuildLocal, BuildRemote:
+	default:
+		return errors.Errorf("unknown build backend %q, expected %s or %s", s.Build, BuildLocal, BuildRemote)
+	}
+	for name := range s.Flags {
+		if name == "" || strings.HasPrefix(name, "-") {
+			return errors.Errorf("invalid flag name %q, expected a name without the dashes", name)
+		}
+	}
+	return nil
+}
+
+// FlagValues returns the values of flags set by the settings, by flag name.
+func (s *Settings) FlagValues() map[string]string {
+	res := map[string]string{}
+	for name, value := range s.Flags {
+		res[name] = value
+	}
+	if s.Port != "" {
+		res["port"] = s.Port
+	}
+	if s.Board != "" {
+		res["board"] = s.Board
+	}
+	switch s.Build {
+	case BuildLocal:
+		res["local"] = "true"
+	case BuildRemote:
+		res["local"] = "false"
+	}
+	return res
+}
+
+// Get returns the value of the given key: "port", "board", "build" or
+// "flags.<name>", and whether it's set.
+func (s *Settings) Get(key string) (string, bool) {
+	var v string
+	switch key {
+	case "port":
+		v = s.Port
+	case "board":
+		v = s.Board
+	case "build":
+		v = s.Build
+	default:
+		if !strings.HasPrefix(key, flagsKeyPrefix) {
+			return "", false
+		}
+		v = s.Flags[strings.TrimPrefix(key, flagsKeyPrefix)]
+	}
+	return v, v != ""
+}
+
+// Set sets the value of the given key, see Get. An empty value unsets it.
+func (s *Settings) Set(key, value string) error {
+	switch key {
+	case "port":
+		s.Port = value
+	case "board":
+		s.Board = value
+	case "build":
+		s.Build = value
+	default:
+		if !strings.HasPrefix(key, flagsKeyPrefix) {
+			return errors.Errorf("unknown key %q, expected port, board, build or flags.<name>", key)
+		}
+		name := strings.TrimPrefix(key, flagsKeyPrefix)
+		if value == "" {
+			delete(s.Flags, name)
+			break
+		}
+		if s.Flags == nil {
+			s.Flags = map[string]string{}
+		}
+		s.Flags[name] = value
+	}
+	return errors.Trace(s.Validate())
+}
+
+// Keys returns all keys which are set, sorted.
+func (s *Settings) Keys() []string {
+	var res []string
+	for _, key := range []string{"port", "board", "build"} {
+		if _, ok := s.Get(key); ok {
+			res = append(res, key)
+		}
+	}
+	var flags []string
+	for name := range s.Flags {
+		flags = append(flags, flagsKeyPrefix+name)
+	}
+	sort.Strings(flags)
+	return append(res, flags...)
+}
@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".mos", "settings.yml")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Keys()) != 0 {
+		t.Errorf("expected empty settings")
+	}
+
+	for key, value := range map[string]string{
+		"port":             "/dev/ttyUSB0",
+		"board":            "wemos-d1-mini",
+		"build":            BuildLocal,
+		"flags.repo":       "../mongoose-os",
+		"flags.build-var":  "FOO=1",
+		"flags.unused-one": "x",
+	} {
+		if err := s.Set(key, value); err != nil {
+			t.Fatalf("%s: %s", key, err)
+		}
+	}
+	if err := s.Set("flags.unused-one", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"port", "board", "build", "flags.build-var", "flags.repo"}; !reflect.DeepEqual(s2.Keys(), exp) {
+		t.Errorf("expected keys %v, got %v", exp, s2.Keys())
+	}
+	exp := map[string]string{
+		"port":      "/dev/ttyUSB0",
+		"board":     "wemos-d1-mini",
+		"local":     "true",
+		"repo":      "../mongoose-os",
+		"build-var": "FOO=1",
+	}
+	if got := s2.FlagValues(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected flags %v, got %v", exp, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := &Settings{}
+	if err := s.Set("build", "cloud"); err == nil {
+		t.Errorf("expected an error for an unknown build backend")
+	}
+	s = &Settings{}
+	if err := s.Set("flags.--port", "x"); err == nil {
+		t.Errorf("expected an error for a flag name with dashes")
+	}
+	if err := (&Settings{}).Set("colour", "red"); err == nil {
+		t.Errorf("expected an error for an unknown key")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/udev"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	usbGroup = flag.String("usb-group", "dialout", "mos setup-usb: group which is given access to USB adapters")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "usb-group")
+}
+
+// setupUSBHandler implements "mos setup-usb": on Linux, it installs udev
+// rules which give --usb-group access to common USB-UART and JTAG adapters,
+// adds the user to the group, and checks access to the serial ports.
+// Changes are made with sudo, after confirmation unless --force is given.
+func setupUSBHandler(ctx context.Context, devConn *dev.DevConn) error {
+	if runtime.GOOS != "linux" {
+		return errors.Errorf("mos setup-usb is only needed on Linux; on %s, install the driver of the adapter: %s",
+			runtime.GOOS, serialDriverHint())
+	}
+
+	if !udev.IsValidGroupName(*usbGroup) {
+		return errors.Errorf("invalid --usb-group %q", *usbGroup)
+	}
+	rules := udev.Rules(*usbGroup)
+	if existing, err := ioutil.ReadFile(udev.RulesFilePath); err == nil && bytes.Equal(existing, rules) {
+		ourutil.Reportf("udev rules in %s are up to date", udev.RulesFilePath)
+	} else {
+		if yes, err := confirmSudo("install udev rules for USB adapters to " + udev.RulesFilePath); err != nil {
+			return errors.Trace(err)
+		} else if !yes {
+			return errors.Errorf("aborted, use --force to proceed without confirmation")
+		}
+		if err := installUdevRules(rules); err != nil {
+			return errors.Trace(err)
+		}
+		ourutil.Reportf("Installed udev rules to %s", udev.RulesFilePath)
+	}
+
+	user, err := getCommandOutput("id", "-un")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	user = strings.TrimSpace(user)
+	groups, err := getCommandOutput("id", "-nG", user)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if inGroup(strings.Fields(groups), *usbGroup) {
+		ourutil.Reportf("%s is in the %s group", user, *usbGroup)
+	} else {
+		if yes, err := confirmSudo("add " + user + " to the " + *usbGroup + " group"); err != nil {
+			return errors.Trace(err)
+		} else if !yes {
+			return errors.Errorf("aborted, use --force to proceed without confirmation")
+		}
+		if err := runSudo("usermod", "-aG", *usbGroup, user); err != nil {
+			return errors.Trace(err)
+		}
+		ourutil.Reportf("Added %s to the %s group; log out and in again for it to take effect", user, *usbGroup)
+	}
+
+	return errors.Trace(verifySerialPortAccess())
+}
+
+// inGroup returns whether the group is among the groups of the user. The
+// process itself may not be in it yet, if the user was added to it after
+// logging in.
+func inGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func confirmSudo(what string) (bool, error) {
+	if *force {
+		return true, nil
+	}
+	return confirm("This will "+what+" using sudo. Proceed", "use --force to proceed without confirmation")
+}
+
+func runSudo(args ...string) error {
+	glog.Infof("Running sudo %s", args)
+	cmd := exec.Command("sudo", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Annotatef(err, "sudo %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+func installUdevRules(rules []byte) error {
+	tmp, err := ioutil.TempFile("", "mos-udev-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(rules); err != nil {
+		tmp.Close()
+		return errors.Trace(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := runSudo("install", "-m", "0644", tmp.Name(), udev.RulesFilePath); err != nil {
+		return errors.Trace(err)
+	}
+	if err := runSudo("udevadm", "control", "--reload-rules"); err != nil {
+		return errors.Trace(err)
+	}
+	// Apply the rules to the adapters already plugged in
+	return errors.Trace(runSudo("udevadm", "trigger"))
+}
+
+// verifySerialPortAccess reports access of the user to the serial ports, and
+// fails if there is no access to some of them.
+func verifySerialPortAccess() error {
+	ports := enumerateSerialPorts()
+	if len(ports) == 0 {
+		ourutil.Reportf("No serial ports found, connect the device to check access to it")
+		return nil
+	}
+	var denied []string
+	for _, port := range ports {
+		if err := checkSerialPortAccess(port); err != nil {
+			ourutil.Reportf("%s: no access", port)
+			denied = append(denied, port)
+		} else {
+			ourutil.Reportf("%s: OK", port)
+		}
+	}
+	if len(denied) > 0 {
+		return errors.Errorf("no read/write access to %s; if you've just been added to the group, "+
+			"log out and in again", strings.Join(denied, ", "))
+	}
+	return nil
+}
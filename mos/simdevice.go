@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/simdevice"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	simAddr        = flag.String("sim-addr", "127.0.0.1:8910", "Address the simulated device listens at")
+	simID          = flag.String("sim-id", "", "Device ID of the simulated device")
+	simFSDir       = flag.String("sim-fs-dir", "", "Directory with the initial filesystem contents of the simulated device")
+	simLatency     = flag.Duration("sim-latency", 0, "Simulated device: delay before each response")
+	simErrorRate   = flag.Float64("sim-error-rate", 0, "Simulated device: probability (0 to 1) of a request failing")
+	simDropRate    = flag.Float64("sim-drop-rate", 0, "Simulated device: probability (0 to 1) of a request left without response")
+	simFailMethods = flag.StringSlice("sim-fail-methods", nil, "Simulated device: RPC methods which always fail")
+)
+
+// simDeviceHandler runs a simulated device until interrupted:
+// "mos simdevice". It can then be used like a real one, with
+// "--port ws://127.0.0.1:8910/rpc".
+func simDeviceHandler(ctx context.Context, devConn *dev.DevConn) error {
+	opts := simdevice.Options{
+		ID: *simID,
+		Faults: simdevice.Faults{
+			Latency:     *simLatency,
+			ErrorRate:   *simErrorRate,
+			DropRate:    *simDropRate,
+			FailMethods: *simFailMethods,
+		},
+	}
+
+	if *simFSDir != "" {
+		entries, err := ioutil.ReadDir(*simFSDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts.Files = map[string][]byte{}
+		for _, e := range entries {
+			// Device filesystem is flat
+			if e.IsDir() {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(*simFSDir, e.Name()))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			opts.Files[e.Name()] = data
+		}
+	}
+
+	d := simdevice.New(opts)
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", d)
+	mux.Handle("/rpc/", d)
+
+	l, err := net.Listen("tcp", *simAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer l.Close()
+
+	ourutil.Reportf("Simulated device %s is listening, use it with --port ws://%s/rpc or --port http://%s/rpc",
+		d.ID(), l.Addr(), l.Addr())
+	ourutil.Reportf("Faults can be changed at runtime with the Sim.SetFaults RPC. Press Ctrl-C to stop.")
+
+	return errors.Trace(http.Serve(l, mux))
+}
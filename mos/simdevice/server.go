@@ -0,0 +1,122 @@
+package simdevice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cesanta.com/common/go/mgrpc/codec"
+	"cesanta.com/common/go/mgrpc/frame"
+	"cesanta.com/common/go/ourjson"
+	"github.com/golang/glog"
+	"golang.org/x/net/websocket"
+)
+
+// ServeHTTP serves RPC requests: websocket connections (like "mos --port
+// ws://host/rpc" uses) and plain HTTP POSTs of frames to /rpc or of args to
+// /rpc/<method>.
+func (d *Device) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Handler(d.serveWebsocket).ServeHTTP(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "only POST and websocket requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	// The codec takes the method from the path, or the whole frame from the
+	// body if the path is "/"
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/rpc")
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	c := codec.InboundHTTP(w, r, "")
+	if c == nil {
+		return
+	}
+	d.serveCodec(r.Context(), c)
+	<-c.CloseNotify()
+}
+
+func (d *Device) serveWebsocket(conn *websocket.Conn) {
+	glog.Infof("simdevice: websocket connection from %s", conn.Request().RemoteAddr)
+	c := codec.WebSocket(conn)
+	d.serveCodec(conn.Request().Context(), c)
+}
+
+// serveCodec handles incoming requests until the codec is closed. Requests
+// are handled concurrently, so that a dropped or slow request doesn't delay
+// the others.
+func (d *Device) serveCodec(ctx context.Context, c codec.Codec) {
+	var sendLock sync.Mutex
+	for {
+		f, err := c.Recv(ctx)
+		if err != nil {
+			return
+		}
+		go func(f *frame.Frame) {
+			resp := d.handleFrame(f)
+			if resp == nil || f.NoResponse {
+				return
+			}
+			sendLock.Lock()
+			defer sendLock.Unlock()
+			if err := c.Send(ctx, resp); err != nil {
+				glog.Infof("simdevice: failed to send response: %s", err)
+			}
+		}(f)
+	}
+}
+
+// handleFrame returns the response to the request frame, or nil if the
+// request is dropped.
+func (d *Device) handleFrame(f *frame.Frame) *frame.Frame {
+	method := strings.TrimPrefix(f.Method, "/")
+
+	d.mu.Lock()
+	latency := d.opts.Faults.Latency
+	d.mu.Unlock()
+	if latency > 0 && !strings.HasPrefix(method, "Sim.") {
+		time.Sleep(latency)
+	}
+
+	resp := &frame.Frame{
+		Version: f.Version,
+		ID:      f.ID,
+		Src:     d.opts.ID,
+		Dst:     f.Src,
+		Tag:     f.Tag,
+	}
+
+	drop, err := d.fault(method)
+	if drop {
+		glog.Infof("simdevice: dropping %s (simulated)", method)
+		return nil
+	}
+	var res interface{}
+	if err == nil {
+		var args []byte
+		if f.Args.IsInitialized() {
+			args, _ = f.Args.MarshalJSON()
+		}
+		res, err = d.Call(method, args)
+	}
+	if err != nil {
+		code, msg := errorCode(err)
+		resp.Error = &frame.Error{Code: code, Message: msg}
+		return resp
+	}
+
+	if res != nil {
+		data, err := json.Marshal(res)
+		if err != nil {
+			resp.Error = &frame.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+			return resp
+		}
+		resp.Result = ourjson.RawJSON(data)
+	}
+	return resp
+}
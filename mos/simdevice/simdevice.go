@@ -0,0 +1,698 @@
+// Package simdevice implements a virtual Mongoose OS device which serves the
+// core RPC services (Sys, Config, FS, OTA) over websocket and HTTP, so that
+// scripts, fleet tooling and the web UI can be tested without hardware.
+//
+// The device keeps all its state in memory. It can be configured to
+// misbehave: respond slowly, fail some or all requests, or drop them
+// altogether, see Faults.
+package simdevice
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+// Faults configures misbehavior of the device.
+type Faults struct {
+	// Delay before each response.
+	Latency time.Duration
+	// Probability (0 to 1) that a request fails with an internal error.
+	ErrorRate float64
+	// Probability (0 to 1) that a request is left without a response.
+	DropRate float64
+	// Methods which always fail.
+	FailMethods []string
+}
+
+// Options of the simulated device.
+type Options struct {
+	ID        string
+	App       string
+	Arch      string
+	FWVersion string
+	MAC       string
+	// Size of the filesystem, in bytes.
+	FSSize int
+	// Initial filesystem contents.
+	Files  map[string][]byte
+	Faults Faults
+}
+
+// Error is returned by the RPC handlers, and is sent to the client with the
+// given code.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func newError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type handlerFunc func(args json.RawMessage) (interface{}, error)
+
+// Device is a simulated device.
+type Device struct {
+	opts     Options
+	handlers map[string]handlerFunc
+
+	mu          sync.Mutex
+	rand        *rand.Rand
+	bootTime    time.Time
+	timeOffset  time.Duration
+	config      map[string]interface{}
+	savedConfig map[string]interface{}
+	files       map[string][]byte
+	ota         otaState
+}
+
+// New creates a new simulated device; options which are not set get the
+// default values.
+func New(opts Options) *Device {
+	if opts.MAC == "" {
+		opts.MAC = "5ECCO0000001"
+	}
+	if opts.ID == "" {
+		opts.ID = "sim_" + opts.MAC[len(opts.MAC)-6:]
+	}
+	if opts.App == "" {
+		opts.App = "simdevice"
+	}
+	if opts.Arch == "" {
+		opts.Arch = "sim"
+	}
+	if opts.FWVersion == "" {
+		opts.FWVersion = "1.0"
+	}
+	if opts.FSSize == 0 {
+		opts.FSSize = 256 * 1024
+	}
+
+	d := &Device{
+		opts:     opts,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		bootTime: time.Now(),
+		files:    map[string][]byte{},
+		ota: otaState{
+			Version:     opts.FWVersion,
+			IsCommitted: true,
+		},
+	}
+	for name, data := range opts.Files {
+		d.files[name] = data
+	}
+	d.config = defaultConfig(opts.ID)
+	d.savedConfig = copyConfig(d.config)
+
+	d.handlers = map[string]handlerFunc{
+		"Sys.GetInfo":      d.sysGetInfo,
+		"Sys.Reboot":       d.sysReboot,
+		"Sys.GetTime":      d.sysGetTime,
+		"Sys.SetTime":      d.sysSetTime,
+		"Config.Get":       d.configGet,
+		"Config.Set":       d.configSet,
+		"Config.Save":      d.configSave,
+		"FS.List":          d.fsList,
+		"FS.ListExt":       d.fsListExt,
+		"FS.Get":           d.fsGet,
+		"FS.Put":           d.fsPut,
+		"FS.Remove":        d.fsRemove,
+		"OTA.Update":       d.otaUpdate,
+		"OTA.Commit":       d.otaCommit,
+		"OTA.Revert":       d.otaRevert,
+		"OTA.GetBootState": d.otaGetBootState,
+		"RPC.List":         d.rpcList,
+		"RPC.Describe":     d.rpcDescribe,
+		"Sim.GetFaults":    d.simGetFaults,
+		"Sim.SetFaults":    d.simSetFaults,
+	}
+
+	return d
+}
+
+// ID returns the device ID.
+func (d *Device) ID() string {
+	return d.opts.ID
+}
+
+// Call invokes the RPC method, without any faults injected.
+func (d *Device) Call(method string, args json.RawMessage) (interface{}, error) {
+	h, ok := d.handlers[method]
+	if !ok {
+		return nil, newError(http.StatusNotFound, "Method [%s] not found", method)
+	}
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	return h(args)
+}
+
+// fault decides what should happen to the request to the method: whether
+// it's dropped or fails.
+func (d *Device) fault(method string) (drop bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.opts.Faults
+	if strings.HasPrefix(method, "Sim.") {
+		return false, nil
+	}
+	for _, m := range f.FailMethods {
+		if m == method {
+			return false, newError(http.StatusInternalServerError, "%s failed (simulated)", method)
+		}
+	}
+	if f.DropRate > 0 && d.rand.Float64() < f.DropRate {
+		return true, nil
+	}
+	if f.ErrorRate > 0 && d.rand.Float64() < f.ErrorRate {
+		return false, newError(http.StatusInternalServerError, "internal error (simulated)")
+	}
+	return false, nil
+}
+
+func (d *Device) methods() []string {
+	var res []string
+	for m := range d.handlers {
+		res = append(res, m)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func unmarshalArgs(args json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(args, v); err != nil {
+		return newError(http.StatusBadRequest, "invalid args: %s", err)
+	}
+	return nil
+}
+
+// Sys {{{
+
+func (d *Device) uptime() float64 {
+	return time.Since(d.bootTime).Seconds()
+}
+
+func (d *Device) reboot() {
+	d.bootTime = time.Now()
+	d.config = copyConfig(d.savedConfig)
+	d.ota.reboot()
+}
+
+func (d *Device) sysGetInfo(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fsUsed := 0
+	for _, data := range d.files {
+		fsUsed += len(data)
+	}
+	return map[string]interface{}{
+		"id":         d.opts.ID,
+		"app":        d.opts.App,
+		"fw_version": d.ota.Version,
+		"fw_id":      d.bootTime.UTC().Format("20060102-150405") + "/sim",
+		"mac":        d.opts.MAC,
+		"arch":       d.opts.Arch,
+		"uptime":     int(d.uptime()),
+		"ram_size":   128 * 1024,
+		"ram_free":   64 * 1024,
+		"fs_size":    d.opts.FSSize,
+		"fs_free":    d.opts.FSSize - fsUsed,
+	}, nil
+}
+
+func (d *Device) sysReboot(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		DelayMS int `json:"delay_ms"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if a.DelayMS <= 0 {
+		a.DelayMS = 100
+	}
+	time.AfterFunc(time.Duration(a.DelayMS)*time.Millisecond, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.reboot()
+	})
+	return nil, nil
+}
+
+func (d *Device) sysGetTime(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := time.Now().Add(d.timeOffset)
+	return map[string]interface{}{"time": float64(t.UnixNano()) / 1e9}, nil
+}
+
+func (d *Device) sysSetTime(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Time *float64 `json:"time"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if a.Time == nil {
+		return nil, newError(http.StatusBadRequest, "time is required")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeOffset = time.Unix(0, int64(*a.Time*1e9)).Sub(time.Now())
+	return nil, nil
+}
+
+// simGetFaults and simSetFaults allow changing faults at runtime, e.g. from a
+// test script. They are not affected by faults themselves.
+func (d *Device) simGetFaults(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return faultsJSON(d.opts.Faults), nil
+}
+
+func (d *Device) simSetFaults(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		LatencyMS   *int     `json:"latency_ms"`
+		ErrorRate   *float64 `json:"error_rate"`
+		DropRate    *float64 `json:"drop_rate"`
+		FailMethods []string `json:"fail_methods"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := &d.opts.Faults
+	if a.LatencyMS != nil {
+		f.Latency = time.Duration(*a.LatencyMS) * time.Millisecond
+	}
+	if a.ErrorRate != nil {
+		f.ErrorRate = *a.ErrorRate
+	}
+	if a.DropRate != nil {
+		f.DropRate = *a.DropRate
+	}
+	if a.FailMethods != nil {
+		f.FailMethods = a.FailMethods
+	}
+	return faultsJSON(*f), nil
+}
+
+func faultsJSON(f Faults) map[string]interface{} {
+	fm := f.FailMethods
+	if fm == nil {
+		fm = []string{}
+	}
+	return map[string]interface{}{
+		"latency_ms":   int(f.Latency / time.Millisecond),
+		"error_rate":   f.ErrorRate,
+		"drop_rate":    f.DropRate,
+		"fail_methods": fm,
+	}
+}
+
+func (d *Device) rpcList(args json.RawMessage) (interface{}, error) {
+	return d.methods(), nil
+}
+
+func (d *Device) rpcDescribe(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Name string `json:"name"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if _, ok := d.handlers[a.Name]; !ok {
+		return nil, newError(http.StatusNotFound, "Method [%s] not found", a.Name)
+	}
+	return map[string]interface{}{"name": a.Name}, nil
+}
+
+// }}}
+
+// Config {{{
+
+func defaultConfig(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"device": map[string]interface{}{
+			"id":       id,
+			"password": "",
+		},
+		"debug": map[string]interface{}{
+			"level": 2,
+		},
+		"sys": map[string]interface{}{
+			"tz_spec": "",
+		},
+		"wifi": map[string]interface{}{
+			"sta": map[string]interface{}{
+				"enable": false,
+				"ssid":   "",
+				"pass":   "",
+			},
+			"ap": map[string]interface{}{
+				"enable": true,
+				"ssid":   "Mongoose_??????",
+				"pass":   "Mongoose",
+			},
+		},
+		"sntp": map[string]interface{}{
+			"enable": true,
+			"server": "time.google.com",
+		},
+	}
+}
+
+func copyConfig(c map[string]interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	for k, v := range c {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = copyConfig(m)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+func (d *Device) configGet(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Key string `json:"key"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var v interface{} = d.config
+	if a.Key != "" {
+		for _, part := range strings.Split(a.Key, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, newError(http.StatusNotFound, "key %q not found", a.Key)
+			}
+			if v, ok = m[part]; !ok {
+				return nil, newError(http.StatusNotFound, "key %q not found", a.Key)
+			}
+		}
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return copyConfig(m), nil
+	}
+	return v, nil
+}
+
+// mergeConfig applies values from src to dst. Like the real device, it only
+// accepts known keys with values of the matching type.
+func mergeConfig(dst, src map[string]interface{}, prefix string) error {
+	for k, v := range src {
+		key := prefix + k
+		old, ok := dst[k]
+		if !ok {
+			return newError(http.StatusBadRequest, "unknown config key %q", key)
+		}
+		switch ov := old.(type) {
+		case map[string]interface{}:
+			sv, ok := v.(map[string]interface{})
+			if !ok {
+				return newError(http.StatusBadRequest, "%q must be an object", key)
+			}
+			if err := mergeConfig(ov, sv, key+"."); err != nil {
+				return err
+			}
+			continue
+		case string:
+			if _, ok := v.(string); !ok {
+				return newError(http.StatusBadRequest, "%q must be a string", key)
+			}
+		case bool:
+			if _, ok := v.(bool); !ok {
+				return newError(http.StatusBadRequest, "%q must be a boolean", key)
+			}
+		default:
+			if _, ok := v.(float64); !ok {
+				return newError(http.StatusBadRequest, "%q must be a number", key)
+			}
+		}
+		dst[k] = v
+	}
+	return nil
+}
+
+func (d *Device) configSet(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Config map[string]interface{} `json:"config"`
+		Save   bool                   `json:"save"`
+		Reboot bool                   `json:"reboot"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Apply to a copy, so that an invalid value doesn't leave the config
+	// partially updated
+	c := copyConfig(d.config)
+	if err := mergeConfig(c, a.Config, ""); err != nil {
+		return nil, err
+	}
+	d.config = c
+	if a.Save {
+		d.savedConfig = copyConfig(d.config)
+	}
+	if a.Reboot {
+		d.reboot()
+	}
+	return nil, nil
+}
+
+func (d *Device) configSave(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Reboot bool `json:"reboot"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.savedConfig = copyConfig(d.config)
+	if a.Reboot {
+		d.reboot()
+	}
+	return nil, nil
+}
+
+// }}}
+
+// FS {{{
+
+func (d *Device) fileNames() []string {
+	var res []string
+	for name := range d.files {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (d *Device) fsList(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := d.fileNames()
+	if res == nil {
+		res = []string{}
+	}
+	return res, nil
+}
+
+func (d *Device) fsListExt(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := []map[string]interface{}{}
+	for _, name := range d.fileNames() {
+		res = append(res, map[string]interface{}{"name": name, "size": len(d.files[name])})
+	}
+	return res, nil
+}
+
+func (d *Device) fsGet(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Filename string `json:"filename"`
+		Offset   int    `json:"offset"`
+		Len      int    `json:"len"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.files[a.Filename]
+	if !ok {
+		return nil, newError(http.StatusNotFound, "failed to open %s", a.Filename)
+	}
+	if a.Offset < 0 || a.Offset > len(data) {
+		return nil, newError(http.StatusBadRequest, "invalid offset %d", a.Offset)
+	}
+	end := len(data)
+	if a.Len > 0 && a.Offset+a.Len < end {
+		end = a.Offset + a.Len
+	}
+	return map[string]interface{}{
+		"data": data[a.Offset:end],
+		"left": len(data) - end,
+	}, nil
+}
+
+func (d *Device) fsPut(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Filename string `json:"filename"`
+		Data     []byte `json:"data"`
+		Append   bool   `json:"append"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if a.Filename == "" {
+		return nil, newError(http.StatusBadRequest, "filename is required")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data := a.Data
+	if a.Append {
+		data = append(append([]byte(nil), d.files[a.Filename]...), a.Data...)
+	}
+	used := len(data) - len(d.files[a.Filename])
+	for _, f := range d.files {
+		used += len(f)
+	}
+	if used > d.opts.FSSize {
+		return nil, newError(http.StatusInternalServerError, "no space left on device")
+	}
+	d.files[a.Filename] = data
+	return nil, nil
+}
+
+func (d *Device) fsRemove(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Filename string `json:"filename"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.files[a.Filename]; !ok {
+		return nil, newError(http.StatusNotFound, "failed to remove %s", a.Filename)
+	}
+	delete(d.files, a.Filename)
+	return nil, nil
+}
+
+// }}}
+
+// OTA {{{
+
+// otaState mimics the two-slot OTA of the real devices: an update goes to the
+// inactive slot, becomes active on reboot, and is reverted on the next reboot
+// unless committed.
+type otaState struct {
+	Version     string
+	PrevVersion string
+	// Version downloaded to the inactive slot, which will be booted next.
+	PendingVersion string
+	ActiveSlot     int
+	IsCommitted    bool
+}
+
+func (o *otaState) reboot() {
+	switch {
+	case o.PendingVersion != "":
+		o.PrevVersion, o.Version = o.Version, o.PendingVersion
+		o.PendingVersion = ""
+		o.ActiveSlot = 1 - o.ActiveSlot
+		o.IsCommitted = false
+	case !o.IsCommitted:
+		// Not committed in time: roll back
+		o.Version, o.PrevVersion = o.PrevVersion, ""
+		o.ActiveSlot = 1 - o.ActiveSlot
+		o.IsCommitted = true
+	}
+}
+
+func (d *Device) otaUpdate(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		URL     string `json:"url"`
+		Version string `json:"version"`
+	}
+	if err := unmarshalArgs(args, &a); err != nil {
+		return nil, err
+	}
+	if a.URL == "" {
+		return nil, newError(http.StatusBadRequest, "url is required")
+	}
+	if a.Version == "" {
+		// Nothing is actually downloaded; derive some new version
+		a.Version = fmt.Sprintf("%s+ota%d", d.opts.FWVersion, time.Now().Unix())
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ota.IsCommitted {
+		return nil, newError(http.StatusBadRequest, "current firmware is not committed")
+	}
+	d.ota.PendingVersion = a.Version
+	return nil, nil
+}
+
+func (d *Device) otaCommit(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ota.IsCommitted = true
+	d.ota.PrevVersion = ""
+	return nil, nil
+}
+
+func (d *Device) otaRevert(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ota.IsCommitted {
+		return nil, newError(http.StatusBadRequest, "nothing to revert")
+	}
+	d.ota.reboot()
+	d.bootTime = time.Now()
+	return nil, nil
+}
+
+func (d *Device) otaGetBootState(args json.RawMessage) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	revertSlot := d.ota.ActiveSlot
+	if !d.ota.IsCommitted {
+		revertSlot = 1 - d.ota.ActiveSlot
+	}
+	return map[string]interface{}{
+		"active_slot":  d.ota.ActiveSlot,
+		"revert_slot":  revertSlot,
+		"is_committed": d.ota.IsCommitted,
+		"fw_version":   d.ota.Version,
+	}, nil
+}
+
+// }}}
+
+// errorCode returns the RPC error code for the handler error.
+func errorCode(err error) (int, string) {
+	if e, ok := errors.Cause(err).(*Error); ok {
+		return e.Code, e.Message
+	}
+	return http.StatusInternalServerError, err.Error()
+}
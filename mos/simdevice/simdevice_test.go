@@ -0,0 +1,144 @@
+package simdevice
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func call(t *testing.T, d *Device, method, args string, res interface{}) error {
+	r, err := d.Call(method, json.RawMessage(args))
+	if err != nil {
+		return err
+	}
+	if res != nil {
+		data, _ := json.Marshal(r)
+		if err := json.Unmarshal(data, res); err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+	}
+	return nil
+}
+
+func TestConfig(t *testing.T) {
+	d := New(Options{ID: "sim1"})
+
+	var id string
+	if err := call(t, d, "Config.Get", `{"key": "device.id"}`, &id); err != nil || id != "sim1" {
+		t.Fatalf("unexpected device.id %q (%v)", id, err)
+	}
+
+	if err := call(t, d, "Config.Set", `{"config": {"wifi": {"sta": {"ssid": "foo", "enable": true}}}}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(t, d, "Config.Set", `{"config": {"wifi": {"sta": {"ssid": 123}}}}`, nil); err == nil {
+		t.Errorf("expected an error for a wrong type")
+	}
+	if err := call(t, d, "Config.Set", `{"config": {"nope": 1}}`, nil); err == nil {
+		t.Errorf("expected an error for an unknown key")
+	}
+
+	var sta struct {
+		Enable bool   `json:"enable"`
+		SSID   string `json:"ssid"`
+	}
+	if err := call(t, d, "Config.Get", `{"key": "wifi.sta"}`, &sta); err != nil || !sta.Enable || sta.SSID != "foo" {
+		t.Fatalf("unexpected wifi.sta %+v (%v)", sta, err)
+	}
+
+	// Unsaved config is lost on reboot
+	d.reboot()
+	call(t, d, "Config.Get", `{"key": "wifi.sta"}`, &sta)
+	if sta.SSID != "" {
+		t.Errorf("unsaved config survived reboot: %+v", sta)
+	}
+}
+
+func TestFS(t *testing.T) {
+	d := New(Options{FSSize: 10})
+
+	if err := call(t, d, "FS.Put", `{"filename": "a.txt", "data": "aGVsbG8="}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(t, d, "FS.Put", `{"filename": "a.txt", "data": "IQ==", "append": true}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	var res struct {
+		Data []byte `json:"data"`
+		Left int    `json:"left"`
+	}
+	if err := call(t, d, "FS.Get", `{"filename": "a.txt", "offset": 1, "len": 3}`, &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != "ell" || res.Left != 2 {
+		t.Errorf("unexpected FS.Get result: %q, left %d", res.Data, res.Left)
+	}
+	if err := call(t, d, "FS.Put", `{"filename": "b.txt", "data": "aGVsbG8="}`, nil); err == nil {
+		t.Errorf("expected an error when the filesystem is full")
+	}
+	if err := call(t, d, "FS.Remove", `{"filename": "a.txt"}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if err := call(t, d, "FS.List", `{}`, &names); err != nil || len(names) != 0 {
+		t.Errorf("unexpected FS.List result %v (%v)", names, err)
+	}
+}
+
+func TestOTA(t *testing.T) {
+	d := New(Options{FWVersion: "1.0"})
+
+	var bs struct {
+		IsCommitted bool   `json:"is_committed"`
+		FWVersion   string `json:"fw_version"`
+	}
+	if err := call(t, d, "OTA.Update", `{"url": "http://x/fw.zip", "version": "2.0"}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.reboot()
+	call(t, d, "OTA.GetBootState", `{}`, &bs)
+	if bs.IsCommitted || bs.FWVersion != "2.0" {
+		t.Fatalf("unexpected state after update: %+v", bs)
+	}
+	// Not committed: rolled back on the next reboot
+	d.reboot()
+	call(t, d, "OTA.GetBootState", `{}`, &bs)
+	if !bs.IsCommitted || bs.FWVersion != "1.0" {
+		t.Fatalf("unexpected state after rollback: %+v", bs)
+	}
+
+	call(t, d, "OTA.Update", `{"url": "http://x/fw.zip", "version": "2.0"}`, nil)
+	d.reboot()
+	call(t, d, "OTA.Commit", `{}`, nil)
+	d.reboot()
+	call(t, d, "OTA.GetBootState", `{}`, &bs)
+	if !bs.IsCommitted || bs.FWVersion != "2.0" {
+		t.Fatalf("unexpected state after commit: %+v", bs)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	d := New(Options{Faults: Faults{FailMethods: []string{"Sys.GetInfo"}, DropRate: 1}})
+
+	if drop, err := d.fault("Sys.GetInfo"); drop || err == nil {
+		t.Errorf("Sys.GetInfo must fail, got drop=%v err=%v", drop, err)
+	}
+	if drop, _ := d.fault("FS.List"); !drop {
+		t.Errorf("FS.List must be dropped")
+	}
+	if drop, err := d.fault("Sim.SetFaults"); drop || err != nil {
+		t.Errorf("Sim.* must not be affected by faults")
+	}
+
+	if err := call(t, d, "Sim.SetFaults", `{"drop_rate": 0, "fail_methods": []}`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if drop, err := d.fault("Sys.GetInfo"); drop || err != nil {
+		t.Errorf("faults must be reset, got drop=%v err=%v", drop, err)
+	}
+
+	if err := call(t, d, "Foo.Bar", `{}`, nil); err == nil {
+		t.Errorf("expected an error for an unknown method")
+	} else if code, _ := errorCode(err); code != 404 {
+		t.Errorf("expected 404 for an unknown method, got %d", code)
+	}
+}
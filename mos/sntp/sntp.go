@@ -0,0 +1,103 @@
+// Package sntp implements a minimal SNTP client (RFC 4330), enough to get the
+// current time from an NTP server.
+package sntp
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/cesanta/errors"
+)
+
+const (
+	packetSize = 48
+	// Seconds between 1900-01-01 (NTP epoch) and 1970-01-01 (Unix epoch)
+	ntpEpochOffset = 2208988800
+
+	modeClient = 3
+	modeServer = 4
+	version    = 4
+)
+
+// Result is the result of an SNTP query.
+type Result struct {
+	// Time is the server time, corrected for the network delay.
+	Time time.Time
+	// Offset is the difference between the server time and the local time.
+	Offset time.Duration
+	// RTT is the round trip time.
+	RTT time.Duration
+}
+
+// Query asks the given server ("host" or "host:port") for the current time.
+func Query(server string, timeout time.Duration) (*Result, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	t1 := time.Now()
+	if _, err := conn.Write(encodeRequest(t1)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	buf := make([]byte, packetSize)
+	n, err := conn.Read(buf)
+	t4 := time.Now()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return parseResponse(buf[:n], t1, t4)
+}
+
+func encodeRequest(t time.Time) []byte {
+	p := make([]byte, packetSize)
+	p[0] = version<<3 | modeClient
+	// Transmit timestamp, echoed back by the server as the originate timestamp
+	putTime(p[40:], t)
+	return p
+}
+
+func parseResponse(p []byte, t1, t4 time.Time) (*Result, error) {
+	if len(p) < packetSize {
+		return nil, errors.Errorf("short response (%d bytes)", len(p))
+	}
+	if mode := p[0] & 0x7; mode != modeServer {
+		return nil, errors.Errorf("unexpected mode %d", mode)
+	}
+	if li := p[0] >> 6; li == 3 {
+		return nil, errors.Errorf("server clock is not synchronized")
+	}
+	if stratum := p[1]; stratum == 0 {
+		return nil, errors.Errorf("kiss-o'-death response: %q", string(p[12:16]))
+	}
+
+	// Receive and transmit timestamps of the server
+	t2 := getTime(p[32:])
+	t3 := getTime(p[40:])
+
+	// Standard NTP clock offset and delay calculation
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	rtt := t4.Sub(t1) - t3.Sub(t2)
+
+	return &Result{Time: t4.Add(offset), Offset: offset, RTT: rtt}, nil
+}
+
+func putTime(p []byte, t time.Time) {
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / 1000000000
+	binary.BigEndian.PutUint32(p[0:], uint32(secs))
+	binary.BigEndian.PutUint32(p[4:], uint32(frac))
+}
+
+func getTime(p []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(p[0:])) - ntpEpochOffset
+	frac := uint64(binary.BigEndian.Uint32(p[4:]))
+	return time.Unix(secs, int64(frac*1000000000>>32))
+}
@@ -0,0 +1,47 @@
+package sntp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRoundTrip(t *testing.T) {
+	ts := time.Date(2018, 3, 1, 12, 30, 45, 123456000, time.UTC)
+	p := make([]byte, 8)
+	putTime(p, ts)
+	got := getTime(p)
+	if d := got.Sub(ts); d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("expected %s, got %s", ts, got)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	t1 := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	t4 := t1.Add(100 * time.Millisecond)
+
+	// Server is 10 seconds ahead and takes 20 ms to respond
+	p := make([]byte, packetSize)
+	p[0] = version<<3 | modeServer
+	p[1] = 2
+	putTime(p[32:], t1.Add(10*time.Second+40*time.Millisecond))
+	putTime(p[40:], t1.Add(10*time.Second+60*time.Millisecond))
+
+	r, err := parseResponse(p, t1, t4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.Offset - 10*time.Second; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("unexpected offset %s", r.Offset)
+	}
+	if d := r.RTT - 80*time.Millisecond; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("unexpected RTT %s", r.RTT)
+	}
+
+	p[1] = 0
+	if _, err := parseResponse(p, t1, t4); err == nil {
+		t.Errorf("expected an error for stratum 0")
+	}
+	if _, err := parseResponse(p[:10], t1, t4); err == nil {
+		t.Errorf("expected an error for a short packet")
+	}
+}
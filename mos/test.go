@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"context"
+
+	"cesanta.com/mos/build/coverage"
+	"cesanta.com/mos/build/hosttest"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	testHost  = flag.Bool("host", false, "mos test: build and run the tests on the host")
+	testImage = flag.String("test-image", "", "mos test: container image with the host compiler to use instead of the build image pinned in mos.lock")
+
+	testCoverage      = flag.Bool("coverage", false, "mos test: collect line coverage of the project sources with gcov and lcov")
+	coverageThreshold = flag.Float64("coverage-threshold", 0, "mos test: with --coverage, fail if the total line coverage is below this percentage")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "host", "test-image", "coverage", "coverage-threshold")
+}
+
+// Name of the coverage tracefile of the project sources, among the ones of
+// test binaries.
+const mergedCoverageName = "lcov.info"
+
+var testSourceExts = map[string]bool{".c": true, ".cc": true, ".cpp": true}
+
+// testHandler implements "mos test --host [filter]": compiles the sources
+// listed in "tests" of mos.yml with the host compiler of the build container
+// and the harness of the hosttest package, runs the tests whose names
+// contain the filter, and reports the results. With --coverage, line
+// coverage of the project sources is reported too.
+func testHandler(ctx context.Context, devConn *dev.DevConn) error {
+	if !*testHost {
+		return errors.Errorf("only host tests are supported, use --host")
+	}
+	args := flag.Args()[1:]
+	filter := ""
+	if len(args) > 0 {
+		filter = args[0]
+	}
+
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(manifest.Tests) == 0 {
+		return errors.Errorf("no tests in %s, list test sources in \"tests\"", manifestPath)
+	}
+	files, err := getExportFiles(manifest.Tests, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	testDir, err := filepath.Abs(filepath.Join(moscommon.GetBuildDir(projectDir), "test_host"))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	for name, content := range hosttest.Files() {
+		if err := ioutil.WriteFile(filepath.Join(testDir, name), []byte(content), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	sources := []string{getPathForDocker(filepath.Join(testDir, hosttest.MainName))}
+	dirs := []string{testDir}
+	for _, f := range files {
+		if !testSourceExts[filepath.Ext(f)] {
+			continue
+		}
+		fa, err := filepath.Abs(f)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sources = append(sources, getPathForDocker(fa))
+		dirs = append(dirs, filepath.Dir(fa))
+	}
+	includes := []string{getPathForDocker(testDir)}
+	for _, inc := range manifest.Includes {
+		if inc == "" || inc[0] == '$' || inc[0] == '@' {
+			continue
+		}
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(projectDir, inc)
+		}
+		inc, err = filepath.Abs(inc)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		includes = append(includes, getPathForDocker(inc))
+		dirs = append(dirs, inc)
+	}
+
+	// Test binaries are named after the filter, so that runs with different
+	// filters don't overwrite each other's binaries.
+	binName := "test"
+	if filter != "" {
+		binName += "_" + testNameRE.ReplaceAllString(filter, "_")
+	}
+	bld := &hosttest.Build{
+		Sources:  sources,
+		Includes: includes,
+		CDefs:    manifest.CDefs,
+		Binary:   getPathForDocker(filepath.Join(testDir, binName)),
+		Filter:   filter,
+	}
+	covDir := filepath.Join(testDir, "coverage")
+	if *testCoverage {
+		if err := os.MkdirAll(covDir, 0755); err != nil {
+			return errors.Trace(err)
+		}
+		// Remove the tracefile of the previous run, so that a stale one is
+		// never reported if this run doesn't write it.
+		if err := os.Remove(filepath.Join(covDir, binName+".info")); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+		bld.Coverage = getPathForDocker(filepath.Join(covDir, binName+".info"))
+	}
+	script := bld.Script()
+	scriptName := filepath.Join(testDir, "run.sh")
+	if err := ioutil.WriteFile(scriptName, []byte(script), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	image, err := getToolchainImage(*testImage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	reportf("Building and running %d test sources...", len(sources)-1)
+	out, runErr := runToolchainCommand(image, mountRoots(dirs), testDir, testDir, []string{"/bin/sh", getPathForDocker(scriptName)})
+	res, err := hosttest.ParseResults(bytes.NewReader(out))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if runErr != nil && len(res.Passed)+len(res.Failed) == 0 {
+		// Didn't build or crashed before the first test
+		return errors.Annotatef(runErr, "tests failed to run: %s", out)
+	}
+	if *verbose {
+		reportf("%s", out)
+	}
+	for _, f := range res.Failed {
+		reportf("FAIL %s: %s", f.Name, f.Message)
+	}
+	reportf("%d passed, %d failed", len(res.Passed), len(res.Failed))
+	if len(res.Failed) > 0 {
+		return errors.Errorf("%d tests failed", len(res.Failed))
+	}
+	if runErr != nil {
+		// E.g. a crash in the middle
+		return errors.Annotatef(runErr, "tests failed: %s", out)
+	}
+	if *testCoverage {
+		return errors.Trace(reportCoverage(filepath.Join(covDir, binName+".info"), testDir, sources))
+	}
+	return nil
+}
+
+var testNameRE = regexp.MustCompile(`[^\w-]`)
+
+// reportCoverage reads the tracefile of this run, writes the one with only
+// the sources of the project, except the tests themselves, and the HTML
+// summary next to it, and checks the threshold.
+func reportCoverage(tracefile, testDir string, testSources []string) error {
+	covDir := filepath.Dir(tracefile)
+	f, err := os.Open(tracefile)
+	if err != nil {
+		return errors.Annotatef(err, "no coverage data")
+	}
+	d := coverage.Data{}
+	err = d.ParseLCOV(f)
+	f.Close()
+	if err != nil {
+		return errors.Annotatef(err, "parsing %s", tracefile)
+	}
+	appDir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	isTest := map[string]bool{}
+	for _, s := range testSources {
+		isTest[s] = true
+	}
+	d.Filter(func(f string) bool {
+		return !isTest[f] && isUnderDir(f, getPathForDocker(appDir)) && !isUnderDir(f, getPathForDocker(testDir))
+	})
+	if len(d) == 0 {
+		// E.g. the tests don't use any of the sources, or the paths in the
+		// tracefile don't match ours
+		return errors.Errorf("no project sources in the coverage data of %s", tracefile)
+	}
+
+	var merged bytes.Buffer
+	if err := d.WriteLCOV(&merged); err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(covDir, mergedCoverageName), merged.Bytes(), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	s := d.Summarize()
+	var html bytes.Buffer
+	if err := s.WriteHTML(&html, "Coverage of "+filepath.Base(appDir)); err != nil {
+		return errors.Trace(err)
+	}
+	htmlName := filepath.Join(covDir, "index.html")
+	if err := ioutil.WriteFile(htmlName, html.Bytes(), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	s.WriteText(os.Stdout)
+	reportf("Coverage written to %s", htmlName)
+
+	if pct := s.Total.Percent(); pct < *coverageThreshold {
+		return errors.Errorf("line coverage %.1f%% is below the threshold of %.1f%%", pct, *coverageThreshold)
+	}
+	return nil
+}
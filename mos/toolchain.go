@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build/tsmjs"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/lockfile"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	updateLock = flag.Bool("update-lock", false, "Re-pin build images and modules in mos.lock to their current versions")
+
+	// Guards read-modify-write of the lockfile.
+	lockfileLock sync.Mutex
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "update-lock")
+}
+
+func toolchainHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 || args[0] != "pull" {
+		return errors.Errorf("usage: mos toolchain pull")
+	}
+
+	lf, err := lockfile.Load(moscommon.GetLockFilePath(projectDir))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	images := lf.PinnedImages()
+	if len(images) == 0 {
+		return errors.Errorf("no build images are pinned in %s, run \"mos build --local\" first",
+			moscommon.GetLockFilePath(projectDir))
+	}
+
+	for _, image := range images {
+		ourutil.Reportf("Pulling %s...", image)
+		if err := dockerPull(image); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// resolveBuildImage returns the reference of the build image to use instead
+// of the given one: if the image is pinned in the lockfile, the pinned version
+// is used, and if it can't be obtained, that's an error. Otherwise, the image
+// is pulled if needed and its current digest is pinned.
+func resolveBuildImage(image string) (string, error) {
+	lfPath := moscommon.GetLockFilePath(projectDir)
+	lf, err := lockfile.Load(lfPath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	if pinned := lf.DockerImages[image]; pinned != "" && !*updateLock {
+		if !dockerImageExists(pinned) {
+			freportf(logWriter, "Pulling pinned build image %s...", pinned)
+			if err := dockerPull(pinned); err != nil {
+				return "", errors.Errorf(
+					"build image %s is pinned in %s to %s, which is not available (%s); "+
+						"run with --update-lock to pin the current version",
+					image, lfPath, pinned, err,
+				)
+			}
+		}
+		return pinned, nil
+	}
+
+	if !dockerImageExists(image) || *updateLock {
+		freportf(logWriter, "Pulling build image %s...", image)
+		if err := dockerPull(image); err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+
+	pinned, err := getImageDigestRef(image)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if pinned == "" {
+		// Locally built image, nothing to pin
+		freportf(logWriterStderr, "Warning: build image %s has no digest, not pinning it", image)
+		return image, nil
+	}
+
+	lockfileLock.Lock()
+	defer lockfileLock.Unlock()
+	if lf, err = lockfile.Load(lfPath); err != nil {
+		return "", errors.Trace(err)
+	}
+	lf.PinImage(image, pinned)
+	if err := lf.Save(lfPath); err != nil {
+		return "", errors.Trace(err)
+	}
+	freportf(logWriter, "Pinned build image %s to %s in %s", image, pinned, lfPath)
+
+	return pinned, nil
+}
+
+// getImageDigestRef returns the reference of the local image by digest,
+// "repo@sha256:...", or an empty string if it has none, like locally built
+// images.
+func getImageDigestRef(image string) (string, error) {
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	out, err := exec.Command(rt, "image", "inspect", "--format", "{{json .RepoDigests}}", image).Output()
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to inspect image %s", image)
+	}
+	var repoDigests []string
+	if err := json.Unmarshal(out, &repoDigests); err != nil {
+		return "", errors.Annotatef(err, "failed to parse repo digests of %s", image)
+	}
+	return lockfile.ImageDigestRef(image, repoDigests), nil
+}
+
+// pinTool returns the version of the tool pinned in the lockfile; if there
+// is none, or with --update-lock, the given default is pinned.
+func pinTool(name, defaultVersion string) (string, error) {
+	lockfileLock.Lock()
+	defer lockfileLock.Unlock()
+
+	lfPath := moscommon.GetLockFilePath(projectDir)
+	lf, err := lockfile.Load(lfPath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if v := lf.Tools[name]; v != "" && !*updateLock {
+		return v, nil
+	}
+	lf.PinTool(name, defaultVersion)
+	if err := lf.Save(lfPath); err != nil {
+		return "", errors.Trace(err)
+	}
+	freportf(logWriter, "Pinned %s to %s in %s", name, defaultVersion, lfPath)
+	return defaultVersion, nil
+}
+
+func dockerImageExists(image string) bool {
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return false
+	}
+	return exec.Command(rt, "image", "inspect", image).Run() == nil
+}
+
+func dockerPull(image string) error {
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	out, err := exec.Command(rt, "pull", image).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("%s pull %s failed: %s", rt, image, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getToolchainImage returns the image to run toolchain tools like
+// clang-format in: the given one, or the build image pinned in mos.lock by
+// the last local build.
+func getToolchainImage(image string) (string, error) {
+	if image != "" {
+		return image, nil
+	}
+	lfPath := moscommon.GetLockFilePath(projectDir)
+	lf, err := lockfile.Load(lfPath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	for _, image := range lf.PinnedImages() {
+		// The node image of TypeScript builds has no toolchain
+		if image != lf.DockerImages[tsmjs.Image] {
+			return image, nil
+		}
+	}
+	return "", errors.Errorf("no build image is pinned in %s, run \"mos build --local\" first", lfPath)
+}
+
+// runToolchainCommand runs the command in workDir and returns its combined
+// output, also on failure. Inside of the toolchain container, the command is
+// run directly, otherwise in a container of the image, with the dirs mounted
+// at the same paths. Results written to outDir, if not empty, are synced
+// back from a remote docker host.
+func runToolchainCommand(image string, dirs []string, workDir, outDir string, args []string) ([]byte, error) {
+	if os.Getenv("MGOS_SDK_REVISION") != "" || os.Getenv("MIOT_SDK_REVISION") != "" {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = workDir
+		out, err := cmd.CombinedOutput()
+		return out, errors.Trace(err)
+	}
+
+	rt, err := getContainerRuntime()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rh, err := getRemoteDockerHost()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mp := mountPoints{}
+	for _, d := range dirs {
+		hostDir := d
+		if rh != nil {
+			if hostDir, err = rh.push(d); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if err := mp.addMountPoint(hostDir, getPathForDocker(d)); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	runArgs := []string{"run", "--rm", "-w", getPathForDocker(workDir)}
+	for containerPath, hostPath := range mp {
+		runArgs = append(runArgs, "-v", fmt.Sprintf("%s:%s", hostPath, containerPath))
+	}
+	userArgs, err := getContainerUserArgs(rt)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	runArgs = append(runArgs, userArgs...)
+	runArgs = append(runArgs, image)
+	runArgs = append(runArgs, args...)
+	freportf(logWriter, "%s arguments: %s", rt, strings.Join(runArgs, " "))
+	out, err := exec.Command(rt, runArgs...).CombinedOutput()
+	if err != nil {
+		return out, errors.Trace(err)
+	}
+	if rh != nil && outDir != "" {
+		if err := rh.pull(outDir); err != nil {
+			return out, errors.Trace(err)
+		}
+	}
+	return out, nil
+}
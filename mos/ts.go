@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cesanta.com/mos/build/tsmjs"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var tsImage = flag.String("ts-image", "", "mos build: container image with node to type-check and transpile the TypeScript sources of "+tsmjs.SourceDir+" with, instead of "+tsmjs.Image+" pinned in mos.lock")
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "ts-image")
+}
+
+// buildTypeScript type-checks and transpiles the TypeScript sources of the
+// app to mJS in outDir, and returns the resulting JS files. Type errors are
+// reported with file:line.
+func buildTypeScript(appDir, outDir string) ([]string, error) {
+	srcDir := filepath.Join(appDir, tsmjs.SourceDir)
+	if _, err := os.Stat(srcDir); err != nil {
+		return nil, nil
+	}
+	var files []string
+	err := filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !info.IsDir() && strings.HasSuffix(p, ".ts") {
+			files = append(files, getPathForDocker(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if outDir, err = filepath.Abs(outDir); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Stale outputs of removed sources must not end up on the filesystem
+	jsDir := filepath.Join(outDir, "js")
+	if err := os.RemoveAll(jsDir); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := os.MkdirAll(jsDir, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	declName := filepath.Join(outDir, tsmjs.DeclarationsName)
+	if err := ioutil.WriteFile(declName, []byte(tsmjs.Declarations), 0644); err != nil {
+		return nil, errors.Trace(err)
+	}
+	files = append(files, getPathForDocker(declName))
+	tsVersion, err := pinTool("typescript", tsmjs.TypeScriptVersion)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	image := *tsImage
+	if image == "" && os.Getenv("MGOS_SDK_REVISION") == "" && os.Getenv("MIOT_SDK_REVISION") == "" {
+		if image, err = resolveBuildImage(tsmjs.Image); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	scriptName := filepath.Join(outDir, "tsc.sh")
+	script := tsmjs.Script(files, getPathForDocker(srcDir), getPathForDocker(jsDir), tsVersion)
+	if err := ioutil.WriteFile(scriptName, []byte(script), 0644); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	freportf(logWriter, "Compiling %d TypeScript files...", len(files)-1)
+	out, err := runToolchainCommand(image, mountRoots([]string{appDir, outDir}), appDir, outDir,
+		[]string{"/bin/sh", getPathForDocker(scriptName)})
+	if err != nil {
+		diags := tsmjs.ParseDiagnostics(out)
+		if len(diags) == 0 {
+			return nil, errors.Annotatef(err, "tsc failed (use --ts-image to choose an image with node): %s", out)
+		}
+		for _, d := range diags {
+			freportf(logWriterStderr, "%s", d)
+		}
+		return nil, errors.Errorf("%d TypeScript errors", len(diags))
+	}
+
+	var res []string
+	err = filepath.Walk(jsDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".js") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(p, tsmjs.ToMJS(data), 0644); err != nil {
+			return errors.Trace(err)
+		}
+		res = append(res, p)
+		return nil
+	})
+	return res, errors.Trace(err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/tzspec"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+// Device config key with the POSIX TZ rule string.
+const tzConfigKey = "sys.tz_spec"
+
+// tzHandler implements "mos tz set <zone>", which sets the TZ rule string of
+// the zone, like Europe/Berlin, on the device, "mos tz get" and
+// "mos tz spec <zone>", which only prints the string.
+func tzHandler(ctx context.Context, _ *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) < 1 {
+		return errors.Errorf("command required: set, get or spec")
+	}
+	switch args[0] {
+	case "set", "spec":
+		if len(args) != 2 {
+			return errors.Errorf("usage: mos tz %s <zone>, e.g. Europe/Berlin", args[0])
+		}
+		spec, err := tzspec.Get(args[1], tzspec.DefaultSources())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if args[0] == "spec" {
+			fmt.Println(spec)
+			return nil
+		}
+		reportf("%s: %s", args[1], spec)
+		devConn, err := createDevConn(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer devConn.Disconnect(ctx)
+		return errors.Trace(internalConfigSet(ctx, devConn, []string{tzConfigKey + "=" + spec}))
+	case "get":
+		devConn, err := createDevConn(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer devConn.Disconnect(ctx)
+		devConf, err := devConn.GetConfig(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// Unset value is null
+		spec, _ := devConf.Get(tzConfigKey)
+		if spec == "" {
+			spec = "UTC (not set)"
+		}
+		fmt.Println(spec)
+		return nil
+	default:
+		return errors.Errorf("unknown command %q, expected set, get or spec", args[0])
+	}
+}
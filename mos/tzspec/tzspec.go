@@ -0,0 +1,101 @@
+// Package tzspec finds the POSIX TZ rule string, like
+// "CET-1CEST,M3.5.0,M10.5.0/3", of a time zone given by its tz database name,
+// like "Europe/Berlin". The string is the footer of the compiled tzdata
+// (TZif version 2+) file of the zone.
+package tzspec
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/cesanta/errors"
+)
+
+// DefaultSources are the tzdata dirs and zip files searched for zones, in
+// order.
+func DefaultSources() []string {
+	var res []string
+	if z := os.Getenv("ZONEINFO"); z != "" {
+		res = append(res, z)
+	}
+	res = append(res, "/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ")
+	return append(res, filepath.Join(runtime.GOROOT(), "lib", "time", "zoneinfo.zip"))
+}
+
+// Load returns the TZif file of the zone from the first source which has it.
+func Load(name string, sources []string) ([]byte, error) {
+	if name == "" || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return nil, errors.Errorf("invalid time zone name %q", name)
+	}
+	for _, src := range sources {
+		var data []byte
+		var err error
+		if strings.HasSuffix(src, ".zip") {
+			data, err = loadFromZip(src, name)
+		} else {
+			data, err = ioutil.ReadFile(filepath.Join(src, filepath.FromSlash(name)))
+		}
+		if err == nil && data != nil {
+			return data, nil
+		}
+	}
+	return nil, errors.Errorf("unknown time zone %q (searched %s)", name, strings.Join(sources, ", "))
+}
+
+func loadFromZip(zipFile, name string) ([]byte, error) {
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, nil
+}
+
+// FromTZif returns the TZ rule string in the footer of the TZif data.
+func FromTZif(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("TZif")) || len(data) < 5 {
+		return "", errors.Errorf("not a TZif file")
+	}
+	if data[4] < '2' {
+		return "", errors.Errorf("TZif version 1 files have no TZ string")
+	}
+	if data[len(data)-1] != '\n' {
+		return "", errors.Errorf("no TZ string footer")
+	}
+	i := bytes.LastIndexByte(data[:len(data)-1], '\n')
+	if i < 0 {
+		return "", errors.Errorf("no TZ string footer")
+	}
+	spec := string(data[i+1 : len(data)-1])
+	// An empty footer means the zone has no rule for the future
+	if spec == "" {
+		return "", errors.Errorf("the zone has no TZ string")
+	}
+	return spec, nil
+}
+
+// Get returns the TZ rule string of the zone.
+func Get(name string, sources []string) (string, error) {
+	data, err := Load(name, sources)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	spec, err := FromTZif(data)
+	return spec, errors.Annotatef(err, "%s", name)
+}
@@ -0,0 +1,54 @@
+package tzspec
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A TZif v2 file with no transitions, only the header and the footer matter.
+var berlin = append(append([]byte("TZif2"), make([]byte, 50)...), []byte("\nCET-1CEST,M3.5.0,M10.5.0/3\n")...)
+
+func TestGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tzspec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	zf, err := os.Create(filepath.Join(dir, "zoneinfo.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zf)
+	w, _ := zw.Create("Europe/Berlin")
+	w.Write(berlin)
+	zw.Close()
+	zf.Close()
+
+	spec, err := Get("Europe/Berlin", []string{filepath.Join(dir, "missing"), zf.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec != "CET-1CEST,M3.5.0,M10.5.0/3" {
+		t.Errorf("unexpected spec %q", spec)
+	}
+	if _, err := Get("Europe/Nowhere", []string{zf.Name()}); err == nil {
+		t.Errorf("unknown zone found")
+	}
+	if _, err := Get("../etc/passwd", []string{dir}); err == nil {
+		t.Errorf("invalid name accepted")
+	}
+}
+
+func TestFromTZif(t *testing.T) {
+	v1 := append([]byte("TZif\x00"), make([]byte, 50)...)
+	if _, err := FromTZif(v1); err == nil {
+		t.Errorf("v1 file accepted")
+	}
+	if _, err := FromTZif([]byte("hello\n")); err == nil {
+		t.Errorf("non-TZif file accepted")
+	}
+}
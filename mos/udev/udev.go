@@ -0,0 +1,77 @@
+// Package udev generates udev rules which give users access to the USB-UART
+// and JTAG adapters commonly used with the devices mos works with, so that
+// flashing and debugging don't need root.
+package udev
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// RulesFilePath is where the rules are installed. The uaccess tag only has
+// effect if it's set before 73-seat-late.rules runs.
+const RulesFilePath = "/etc/udev/rules.d/70-mos-usb.rules"
+
+// Same as the default NAME_REGEX of useradd/groupadd.
+var groupNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+// IsValidGroupName returns whether the name can be used as a group name:
+// it's substituted into the rules as is, so it must not contain quotes.
+func IsValidGroupName(name string) bool {
+	return len(name) <= 32 && groupNameRe.MatchString(name)
+}
+
+// Kinds of adapters
+const (
+	// USB-UART bridges, which are accessed via ttyUSB/ttyACM devices.
+	KindSerial = "serial"
+	// JTAG and SWD probes, which are accessed via the raw USB device.
+	KindJTAG = "jtag"
+)
+
+// Adapter is a USB adapter, identified by the vendor and product IDs.
+type Adapter struct {
+	Name    string
+	Kind    string
+	Vendor  string
+	Product string
+}
+
+// Adapters are the adapters rules are generated for.
+var Adapters = []Adapter{
+	{"Silicon Labs CP210x", KindSerial, "10c4", "ea60"},
+	{"WCH CH340", KindSerial, "1a86", "7523"},
+	{"WCH CH9102", KindSerial, "1a86", "55d4"},
+	{"FTDI FT232R", KindSerial, "0403", "6001"},
+	{"FTDI FT2232", KindSerial, "0403", "6010"},
+	{"Prolific PL2303", KindSerial, "067b", "2303"},
+	{"Espressif USB-JTAG/serial", KindSerial, "303a", "1001"},
+	{"TI XDS110", KindJTAG, "0451", "bef3"},
+	{"ST-LINK/V2", KindJTAG, "0483", "3748"},
+	{"ST-LINK/V2-1", KindJTAG, "0483", "374b"},
+	{"SEGGER J-Link", KindJTAG, "1366", "0101"},
+	{"ARM DAPLink/CMSIS-DAP", KindJTAG, "0d28", "0204"},
+	{"ESP-Prog (FTDI FT2232H)", KindJTAG, "0403", "6010"},
+}
+
+// Rules returns the contents of the rules file: tty devices of the serial
+// adapters and USB devices of the JTAG ones get the given group and are
+// made read-writable by it. Devices are also tagged with uaccess, which
+// gives access to the user logged in locally on systemd systems.
+func Rules(group string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by mos setup-usb, do not edit manually.\n")
+	for _, a := range Adapters {
+		fmt.Fprintf(&b, "\n# %s\n", a.Name)
+		switch a.Kind {
+		case KindSerial:
+			fmt.Fprintf(&b, `SUBSYSTEM=="tty", ATTRS{idVendor}=="%s", ATTRS{idProduct}=="%s", MODE="0660", GROUP="%s", TAG+="uaccess"`+"\n",
+				a.Vendor, a.Product, group)
+		case KindJTAG:
+			fmt.Fprintf(&b, `SUBSYSTEM=="usb", ATTR{idVendor}=="%s", ATTR{idProduct}=="%s", MODE="0660", GROUP="%s", TAG+="uaccess"`+"\n",
+				a.Vendor, a.Product, group)
+		}
+	}
+	return b.Bytes()
+}
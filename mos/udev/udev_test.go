@@ -0,0 +1,33 @@
+package udev
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	rules := string(Rules("dialout"))
+	for _, exp := range []string{
+		`SUBSYSTEM=="tty", ATTRS{idVendor}=="10c4", ATTRS{idProduct}=="ea60", MODE="0660", GROUP="dialout", TAG+="uaccess"`,
+		`SUBSYSTEM=="usb", ATTR{idVendor}=="0483", ATTR{idProduct}=="3748", MODE="0660", GROUP="dialout", TAG+="uaccess"`,
+	} {
+		if !strings.Contains(rules, exp) {
+			t.Errorf("rule %q is missing in:\n%s", exp, rules)
+		}
+	}
+	if n := strings.Count(rules, "SUBSYSTEM=="); n != len(Adapters) {
+		t.Errorf("expected %d rules, got %d", len(Adapters), n)
+	}
+}
+
+func TestIsValidGroupName(t *testing.T) {
+	for name, exp := range map[string]bool{
+		"dialout": true, "plugdev": true, "_usb-1": true, "samba$": true,
+		"": false, "1usb": false, "Dialout": false, `x", RUN+="/bin/sh`: false, "a b": false,
+		strings.Repeat("a", 33): false,
+	} {
+		if IsValidGroupName(name) != exp {
+			t.Errorf("%q: expected %t", name, exp)
+		}
+	}
+}
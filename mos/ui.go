@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -64,6 +66,9 @@ var (
 type wsmessage struct {
 	Cmd  string `json:"cmd"`
 	Data string `json:"data"`
+	// Device is set to the device port for messages coming from the devices
+	// managed via /devices/* endpoints.
+	Device string `json:"device,omitempty"`
 }
 
 // Return result of the /list-apps and /list-libs endpoints
@@ -76,12 +81,13 @@ type saveAppLibFileParams struct {
 
 func wsSend(ws *websocket.Conn, m wsmessage) {
 	message := ""
+	key := m.Cmd + m.Device
 	for i, b := range m.Data {
-		if !inLogLine[m.Cmd] {
+		if !inLogLine[key] {
 			message += FormatTimestampNow()
 		}
 		message += string(m.Data[i : i+1])
-		inLogLine[m.Cmd] = (b != '\n')
+		inLogLine[key] = (b != '\n')
 	}
 	m.Data = message
 	t, _ := json.Marshal(m)
@@ -124,7 +130,7 @@ func wsHandler(ws *websocket.Conn) {
 func reportConsoleLogs() {
 	for {
 		data := <-consoleMsgs
-		wsBroadcast(wsmessage{"uart", string(data)})
+		wsBroadcast(wsmessage{Cmd: "uart", Data: string(data)})
 	}
 }
 
@@ -209,7 +215,7 @@ func reconnectToDevice(ctx context.Context) (*dev.DevConn, error) {
 }
 
 func MqttLogHandler(topic string, data []byte) {
-	wsBroadcast(wsmessage{"uart", string(data)})
+	wsBroadcast(wsmessage{Cmd: "uart", Data: string(data)})
 }
 
 func UDPLogCatcher() {
@@ -229,9 +235,9 @@ func UDPLogCatcher() {
 		n, _, err := conn.ReadFromUDP(buf)
 		if err != nil {
 			fmt.Println("Error: ", err)
-			wsBroadcast(wsmessage{"uart", fmt.Sprintf("Error: %v", err)})
+			wsBroadcast(wsmessage{Cmd: "uart", Data: fmt.Sprintf("Error: %v", err)})
 		} else {
-			wsBroadcast(wsmessage{"uart", string(buf[:n])})
+			wsBroadcast(wsmessage{Cmd: "uart", Data: string(buf[:n])})
 		}
 	}
 }
@@ -252,7 +258,7 @@ func startUI(ctx context.Context, devConn *dev.DevConn) error {
 			if err != nil {
 				break
 			}
-			wsBroadcast(wsmessage{"stderr", string(data[:n])})
+			wsBroadcast(wsmessage{Cmd: "stderr", Data: string(data[:n])})
 		}
 	}()
 
@@ -618,13 +624,14 @@ func startUI(ctx context.Context, devConn *dev.DevConn) error {
 	})
 
 	initProjectManagementEndpoints()
+	initDevicesEndpoints(ctx)
 
 	http.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		result := true
 
-		err := update.Update(ctx, devConn)
+		err := update.Update(ctx, readProjectManifestIfAny())
 		if err != nil {
 			err = errors.Trace(err)
 			result = false
@@ -642,24 +649,56 @@ func startUI(ctx context.Context, devConn *dev.DevConn) error {
 		http.Handle("/", addNoCacheHeader(http.FileServer(&assetfs.AssetFS{Asset: Asset,
 			AssetDir: AssetDir, AssetInfo: assetInfo, Prefix: "web_root"})))
 	}
-	url := fmt.Sprintf("http://%s", httpAddr)
+	listenAddr, err := getUIListenAddr()
+	if err != nil {
+		os.Stdout = origStdout
+		os.Stderr = origStderr
+		return errors.Trace(err)
+	}
+	tlsConfig, err := getUITLSConfig()
+	if err == nil {
+		err = setupUIAuth(listenAddr)
+	}
+	if err != nil {
+		os.Stdout = origStdout
+		os.Stderr = origStderr
+		return errors.Trace(err)
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	// When listening on all interfaces, point the browser to the local machine
+	browserAddr := strings.Replace(strings.Replace(listenAddr, "0.0.0.0:", "127.0.0.1:", 1), "[::]:", "[::1]:", 1)
+	url := fmt.Sprintf("%s://%s", scheme, browserAddr)
+	if uiToken != "" {
+		url = fmt.Sprintf("%s/?%s=%s", url, uiTokenParamName, uiToken)
+	}
 
 	ourutil.Reportf("To get a list of available commands, start with --help")
 	ourutil.Reportf("Starting Web UI. If the browser does not start, navigate to %s", url)
-	listener, err := net.Listen("tcp", httpAddr)
+	if !isLoopbackAddr(listenAddr) {
+		ourutil.Reportf("Web UI is accessible from other machines at %s", listenAddr)
+	}
+	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		os.Stdout = origStdout
 		os.Stderr = origStderr
 		return errors.Trace(err)
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	handler := uiAuthHandler(http.DefaultServeMux)
 	if startWebview {
-		go http.Serve(listener, nil)
+		go http.Serve(listener, handler)
 		webview(url)
 	} else {
 		if startBrowser {
 			open.Start(url)
 		}
-		http.Serve(listener, nil)
+		http.Serve(listener, handler)
 	}
 
 	// Unreacahble
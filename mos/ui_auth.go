@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	uiTokenCookieName = "mos_ui_token"
+	uiTokenParamName  = "token"
+)
+
+var (
+	uiListen         = ""
+	uiToken          = ""
+	uiPassword       = ""
+	uiTLSCert        = ""
+	uiTLSKey         = ""
+	uiAllowedOrigins = []string{}
+)
+
+func init() {
+	flag.StringVar(&uiListen, "listen", "", "Web UI listen host, e.g. 0.0.0.0 to make the UI accessible "+
+		"from other machines. Port is taken from --http-addr. When listening on a non-loopback address, "+
+		"authentication is enforced.")
+	flag.StringVar(&uiToken, "ui-token", "", "Web UI access token. If not specified and the UI is "+
+		"accessible from other machines, a random one is generated")
+	flag.StringVar(&uiPassword, "ui-password", "", "Web UI password, used for HTTP basic authentication (user name is ignored)")
+	flag.StringVar(&uiTLSCert, "ui-tls-cert", "", "Web UI TLS certificate file. If set, the UI is served over HTTPS")
+	flag.StringVar(&uiTLSKey, "ui-tls-key", "", "Web UI TLS private key file")
+	flag.StringSliceVar(&uiAllowedOrigins, "ui-allowed-origins", []string{}, "Additional origins "+
+		"allowed to make cross-origin requests to the Web UI, e.g. https://myhost:8080")
+	hiddenFlags = append(hiddenFlags, "ui-token", "ui-password", "ui-tls-cert", "ui-tls-key", "ui-allowed-origins")
+}
+
+// getUIListenAddr returns the address the Web UI should listen on: if
+// --listen is given, it replaces the host part of --http-addr.
+func getUIListenAddr() (string, error) {
+	if uiListen == "" {
+		return httpAddr, nil
+	}
+	// Allow --listen to contain a port as well
+	if _, _, err := net.SplitHostPort(uiListen); err == nil {
+		return uiListen, nil
+	}
+	_, port, err := net.SplitHostPort(httpAddr)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid --http-addr %q", httpAddr)
+	}
+	return net.JoinHostPort(strings.Trim(uiListen, "[]"), port), nil
+}
+
+// isLoopbackAddr returns whether the given listen address is only accessible
+// from the local machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackHost returns whether the given Host header, with or without a
+// port, names the local machine.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return isLoopbackAddr(net.JoinHostPort(strings.Trim(host, "[]"), "0"))
+}
+
+// setupUIAuth generates a random access token if the UI is accessible from
+// other machines and no credentials were given.
+func setupUIAuth(listenAddr string) error {
+	if uiToken != "" || uiPassword != "" || isLoopbackAddr(listenAddr) {
+		return nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Trace(err)
+	}
+	uiToken = hex.EncodeToString(b)
+	return nil
+}
+
+// getUITLSConfig returns TLS config if --ui-tls-cert is given, or nil
+// otherwise.
+func getUITLSConfig() (*tls.Config, error) {
+	if uiTLSCert == "" && uiTLSKey == "" {
+		return nil, nil
+	}
+	if uiTLSCert == "" || uiTLSKey == "" {
+		return nil, errors.Errorf("both --ui-tls-cert and --ui-tls-key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(uiTLSCert, uiTLSKey)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to load Web UI TLS certificate")
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// isUIRequestAuthorized checks credentials of the request. The token can be
+// given as a query parameter (in which case it's remembered in a cookie),
+// a cookie or a bearer token; password is checked with basic auth.
+func isUIRequestAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if uiToken == "" && uiPassword == "" {
+		return true
+	}
+
+	if uiToken != "" {
+		if t := r.URL.Query().Get(uiTokenParamName); t != "" && secureEqual(t, uiToken) {
+			http.SetCookie(w, &http.Cookie{
+				Name: uiTokenCookieName, Value: t, Path: "/", HttpOnly: true, Secure: r.TLS != nil,
+			})
+			return true
+		}
+		if c, err := r.Cookie(uiTokenCookieName); err == nil && secureEqual(c.Value, uiToken) {
+			return true
+		}
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") &&
+			secureEqual(strings.TrimPrefix(auth, "Bearer "), uiToken) {
+			return true
+		}
+	}
+
+	if uiPassword != "" {
+		if _, pass, ok := r.BasicAuth(); ok && secureEqual(pass, uiPassword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isUIOriginAllowed returns whether the Origin of the request (if any)
+// matches the host the UI is served from, or is in --ui-allowed-origins.
+// Without credentials, the UI is only served on a loopback address, and the
+// host must be a loopback one too: otherwise a page could rebind its own
+// name to 127.0.0.1 and make same-origin requests.
+func isUIOriginAllowed(r *http.Request) bool {
+	if uiToken == "" && uiPassword == "" && !isLoopbackHost(r.Host) {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range uiAllowedOrigins {
+		if o == "*" || strings.TrimSuffix(o, "/") == origin {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// uiAuthHandler wraps the given handler with origin and credentials checks.
+func uiAuthHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUIOriginAllowed(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		if !isUIRequestAuthorized(w, r) {
+			if uiPassword != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="mos"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
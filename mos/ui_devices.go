@@ -0,0 +1,255 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"context"
+
+	"cesanta.com/mos/dev"
+	"github.com/cesanta/errors"
+	"github.com/golang/glog"
+)
+
+// uiDevice is a device connection managed by the Web UI. Unlike the "main"
+// device used by the /connect, /call etc endpoints, any number of those can
+// be connected at the same time; each one is identified by its port, and
+// console output of each one is sent to websocket clients separately, with
+// the "device" field set to the port.
+type uiDevice struct {
+	Port string
+
+	mtx     sync.Mutex
+	devConn *dev.DevConn
+}
+
+// uiDeviceStatus is an entry of the /devices/list reply. It's a copy taken
+// under the device lock, so that marshalling it doesn't race with connects.
+type uiDeviceStatus struct {
+	Port      string `json:"port"`
+	Connected bool   `json:"connected"`
+}
+
+var (
+	uiDevices    = map[string]*uiDevice{}
+	uiDevicesMtx sync.Mutex
+
+	// Flashing uses global flasher options (like espFlashOpts), so only one
+	// device can be flashed at a time.
+	uiFlashMtx sync.Mutex
+
+	deviceConsoleMsgs = make(chan wsmessage, 100)
+)
+
+// getUIDevice returns a device with the given port, creating a new
+// (disconnected) one if needed.
+func getUIDevice(port string) *uiDevice {
+	uiDevicesMtx.Lock()
+	defer uiDevicesMtx.Unlock()
+	d, ok := uiDevices[port]
+	if !ok {
+		d = &uiDevice{Port: port}
+		uiDevices[port] = d
+	}
+	return d
+}
+
+func (d *uiDevice) connect(ctx context.Context) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.devConn != nil {
+		return nil
+	}
+
+	devConn, err := createDevConnForPort(
+		ctx, d.Port,
+		func(data []byte) {
+			removeNonText(data)
+			select {
+			case deviceConsoleMsgs <- wsmessage{Cmd: "uart", Data: string(data), Device: d.Port}:
+			default:
+				// Junk overflow; do nothing
+			}
+		},
+		func(topic string, data []byte) {
+			wsBroadcast(wsmessage{Cmd: "uart", Data: string(data), Device: d.Port})
+		},
+	)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.devConn = devConn
+	return nil
+}
+
+func (d *uiDevice) disconnect(ctx context.Context) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.disconnectLocked(ctx)
+}
+
+func (d *uiDevice) disconnectLocked(ctx context.Context) {
+	if d.devConn != nil {
+		d.devConn.Disconnect(ctx)
+		d.devConn = nil
+	}
+}
+
+// flash flashes the given firmware to the device. If the device was
+// connected, it gets reconnected after flashing.
+func (d *uiDevice) flash(ctx context.Context, fwPath string) error {
+	uiFlashMtx.Lock()
+	defer uiFlashMtx.Unlock()
+
+	d.mtx.Lock()
+	wasConnected := d.devConn != nil
+	d.disconnectLocked(ctx)
+
+	ctx2, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	err := flashFirmware(ctx2, nil, fwPath, d.Port)
+	d.mtx.Unlock()
+
+	if wasConnected {
+		// Give the port some time to settle, see the /flash handler
+		time.Sleep(time.Second)
+		if err2 := d.connect(ctx); err2 != nil {
+			glog.Errorf("failed to reconnect to %s: %s", d.Port, err2)
+		}
+	}
+
+	return errors.Trace(err)
+}
+
+func reportDeviceConsoleLogs() {
+	for {
+		wsBroadcast(<-deviceConsoleMsgs)
+	}
+}
+
+// getUIDeviceFromRequest returns the device identified by the "port" form
+// value, or an error if the port is not given.
+func getUIDeviceFromRequest(r *http.Request) (*uiDevice, error) {
+	port := r.FormValue("port")
+	if port == "" {
+		return nil, errors.Errorf("port is required")
+	}
+	return getUIDevice(port), nil
+}
+
+func initDevicesEndpoints(ctx context.Context) {
+	go reportDeviceConsoleLogs()
+
+	http.HandleFunc("/devices/list", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Make sure all ports present on the system are listed, even if we never
+		// connected to them.
+		for _, port := range enumerateSerialPorts() {
+			getUIDevice(port)
+		}
+
+		uiDevicesMtx.Lock()
+		ret := []uiDeviceStatus{}
+		for _, d := range uiDevices {
+			d.mtx.Lock()
+			ret = append(ret, uiDeviceStatus{
+				Port:      d.Port,
+				Connected: d.devConn != nil && d.devConn.IsConnected(),
+			})
+			d.mtx.Unlock()
+		}
+		uiDevicesMtx.Unlock()
+
+		sort.Slice(ret, func(i, j int) bool { return ret[i].Port < ret[j].Port })
+		httpReply(w, ret, nil)
+	})
+
+	http.HandleFunc("/devices/connect", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		d, err := getUIDeviceFromRequest(r)
+		if err != nil {
+			httpReply(w, false, err)
+			return
+		}
+		if r.FormValue("reconnect") != "" {
+			d.disconnect(ctx)
+		}
+		err = d.connect(ctx)
+		httpReply(w, err == nil, err)
+	})
+
+	http.HandleFunc("/devices/disconnect", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		d, err := getUIDeviceFromRequest(r)
+		if err != nil {
+			httpReply(w, false, err)
+			return
+		}
+		d.disconnect(ctx)
+		httpReply(w, true, nil)
+	})
+
+	http.HandleFunc("/devices/call", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		d, err := getUIDeviceFromRequest(r)
+		if err != nil {
+			httpReply(w, nil, err)
+			return
+		}
+
+		method := r.FormValue("method")
+		if method == "" {
+			httpReply(w, nil, errors.Errorf("Expecting method"))
+			return
+		}
+
+		timeout, err2 := strconv.ParseInt(r.FormValue("timeout"), 10, 64)
+		if err2 != nil {
+			timeout = 10
+		}
+		ctx2, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+
+		if d.devConn == nil {
+			httpReply(w, nil, errors.Errorf("Device %s is not connected", d.Port))
+			return
+		}
+
+		glog.Infof("Calling %s on %s", method, d.Port)
+		result, err := callDeviceService(ctx2, d.devConn, method, r.FormValue("args"))
+		httpReply(w, result, err)
+	})
+
+	// Flashes either the given firmware file, or the last built firmware of the
+	// given app, to the given device.
+	http.HandleFunc("/devices/flash", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		d, err := getUIDeviceFromRequest(r)
+		if err != nil {
+			httpReply(w, false, err)
+			return
+		}
+
+		fwPath := r.FormValue("firmware")
+		if fwPath == "" {
+			appDir, err := getProjectPath(r)
+			if err != nil {
+				httpReply(w, false, errors.Errorf("either firmware or project is required"))
+				return
+			}
+			fwPath = filepath.Join(appDir, "build", "fw.zip")
+		}
+
+		err = d.flash(ctx, fwPath)
+		httpReply(w, err == nil, err)
+	})
+}
@@ -61,6 +61,11 @@ func getProjectPath(r *http.Request) (string, error) {
 	if pname == "" {
 		return "", errors.Errorf("%s is required", pt)
 	}
+	// Projects are the immediate subdirs of the root, the name must not lead
+	// anywhere else
+	if pname != filepath.Base(filepath.Clean(pname)) || pname == "." || pname == ".." {
+		return "", errors.Errorf("invalid %s name: %q", pt, pname)
+	}
 	return filepath.Join(rootDir, pname), nil
 }
 
@@ -75,7 +80,13 @@ func getFilePath(r *http.Request) (string, error) {
 		return "", errors.Errorf("filename is required")
 	}
 
-	return filepath.Join(projectPath, filename), nil
+	// Make sure the resulting path doesn't escape the project directory
+	filePath := filepath.Join(projectPath, filename)
+	if !strings.HasPrefix(filePath, projectPath+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid filename: %q", filename)
+	}
+
+	return filePath, nil
 }
 
 func moveAppLibFile(pt projectType, r *http.Request) error {
@@ -290,10 +301,24 @@ func initProjectManagementEndpoints() {
 			return
 		}
 
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			httpReply(w, false, err)
+			return
+		}
+
 		err = ioutil.WriteFile(filePath, decoded, 0755)
 		httpReply(w, err == nil, err)
 	})
 
+	http.HandleFunc("/p/mkdir", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		dirPath, err := getFilePath(r)
+		if err == nil {
+			err = os.MkdirAll(dirPath, 0755)
+		}
+		httpReply(w, err == nil, err)
+	})
+
 	http.HandleFunc("/p/rm", func(w http.ResponseWriter, r *http.Request) {
 		fullPath, err := getFilePath(r)
 		if err == nil {
@@ -0,0 +1,181 @@
+package update
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"cesanta.com/common/go/ourio"
+	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/build"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/common/paths"
+	"cesanta.com/mos/version"
+	"github.com/cesanta/errors"
+	goversion "github.com/mcuadros/go-version"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Set in the environment of the binary executed from the version store, to
+// avoid exec loops.
+const envVersionExec = "MOS_VERSION_EXEC"
+
+// projectVersionReq is the mos version requirement of the project.
+type projectVersionReq struct {
+	MosVersion    string `yaml:"mos_version"`
+	MinMosVersion string `yaml:"min_mos_version"`
+}
+
+func (r *projectVersionReq) String() string {
+	if r.MosVersion != "" {
+		return r.MosVersion
+	}
+	return ">= " + r.MinMosVersion
+}
+
+// satisfiedBy returns whether the given mos version satisfies the
+// requirement. "latest" satisfies any minimal version.
+func (r *projectVersionReq) satisfiedBy(v string) bool {
+	if r.MosVersion != "" && r.MosVersion != v {
+		return false
+	}
+	if r.MinMosVersion != "" && v != "latest" && goversion.Compare(v, r.MinMosVersion, "<") {
+		return false
+	}
+	return true
+}
+
+// readProjectVersionReq reads the version requirement from the plain YAML
+// of the manifest in the given project dir, for manifests which can't be
+// parsed. If there's no manifest, an empty requirement is returned.
+func readProjectVersionReq(projectDir string) (*projectVersionReq, error) {
+	r := &projectVersionReq{}
+	data, err := ioutil.ReadFile(moscommon.GetManifestFilePath(projectDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+func getStoredBinaryPath(mosVersion string) string {
+	name := "mos"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(paths.VersionsDir, mosVersion, name)
+}
+
+// storeBinary copies the binary to the version store, unless it's already
+// there.
+func storeBinary(executable, mosVersion string) error {
+	if !version.LooksLikeVersionNumber(mosVersion) {
+		return nil
+	}
+	dst := getStoredBinaryPath(mosVersion)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(executable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ourio.WriteFileIfDiffers(dst, data, 0755))
+}
+
+// findStoredBinary returns the path to the newest binary from the version
+// store which satisfies the requirement, or an empty string.
+func findStoredBinary(r *projectVersionReq) string {
+	entries, err := ioutil.ReadDir(paths.VersionsDir)
+	if err != nil {
+		return ""
+	}
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() && r.satisfiedBy(e.Name()) {
+			versions = append(versions, e.Name())
+		}
+	}
+	goversion.Sort(versions)
+	for i := len(versions) - 1; i >= 0; i-- {
+		p := getStoredBinaryPath(versions[i])
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// getProjectVersionReq returns the version requirement of the project
+// manifest, as parsed, with includes and the environment expanded. If the
+// manifest can't be parsed, e.g. because it's written for a newer mos,
+// manifest is nil and the requirement is read from the manifest in the given
+// project dir as is.
+func getProjectVersionReq(projectDir string, manifest *build.FWAppManifest) (*projectVersionReq, error) {
+	if manifest == nil {
+		r, err := readProjectVersionReq(projectDir)
+		return r, errors.Trace(err)
+	}
+	return &projectVersionReq{MosVersion: manifest.MosVersion, MinMosVersion: manifest.MinMosVersion}, nil
+}
+
+// EnforceProjectMosVersion checks that the current mos version satisfies the
+// "mos_version" and "min_mos_version" fields of the project manifest, see
+// getProjectVersionReq. If it doesn't, the matching binary from the version
+// store is executed with the given args instead, and this function doesn't
+// return. If there's no such binary, an error is returned.
+func EnforceProjectMosVersion(projectDir string, manifest *build.FWAppManifest, args []string) error {
+	r, err := getProjectVersionReq(projectDir, manifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	curVersion := version.GetMosVersion()
+	if r.satisfiedBy(curVersion) {
+		return nil
+	}
+
+	if os.Getenv(envVersionExec) == "" {
+		if bin := findStoredBinary(r); bin != "" {
+			ourutil.Reportf("Project requires mos %s, running %s", r, bin)
+			execStoredBinary(bin, args)
+		}
+	}
+
+	return errors.Errorf(
+		"project requires mos %s, but this is %s; run \"mos update %s\", or put the right binary to %s",
+		r, curVersion, strings.TrimPrefix(r.String(), ">= "), getStoredBinaryPath("<version>"),
+	)
+}
+
+// execStoredBinary runs the binary with the given args and exits with its
+// exit code.
+func execStoredBinary(bin string, args []string) {
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=1", envVersionExec))
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+				os.Exit(ws.ExitStatus())
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -24,6 +24,7 @@ import (
 	moscommon "cesanta.com/mos/common"
 	"cesanta.com/mos/common/paths"
 	"cesanta.com/mos/common/state"
+	"cesanta.com/mos/download"
 	"cesanta.com/mos/manifest_parser"
 	"cesanta.com/mos/mosgit"
 	"cesanta.com/mos/version"
@@ -32,12 +33,11 @@ import (
 	"github.com/kardianos/osext"
 	goversion "github.com/mcuadros/go-version"
 	flag "github.com/spf13/pflag"
-
-	"cesanta.com/mos/dev"
 )
 
 var (
-	migrateFlag = flag.Bool("migrate", true, "Migrate data from the previous version if needed")
+	migrateFlag  = flag.Bool("migrate", true, "Migrate data from the previous version if needed")
+	rollbackFlag = flag.Bool("rollback", false, "Restore the mos binary which was replaced by the last update")
 )
 
 // mosVersion can be either exact mos version like "1.6", or update channel
@@ -53,25 +53,24 @@ func getMosURL(p, mosVersion string) string {
 // like "latest" or "release".
 func GetServerMosVersion(mosVersion string) (*version.VersionJson, error) {
 	versionUrl := getMosURL("version.json", mosVersion)
-	resp, err := http.Get(versionUrl)
+	// Signed like the binaries, it tells which binary to update to
+	data, err := download.GetSigned(versionUrl)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("got %d when accessing %s", resp.StatusCode, versionUrl)
-	}
-
-	defer resp.Body.Close()
 
 	var serverVersion version.VersionJson
-
-	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&serverVersion)
+	if err := json.Unmarshal(data, &serverVersion); err != nil {
+		return nil, errors.Annotatef(err, "%s", versionUrl)
+	}
 
 	return &serverVersion, nil
 }
 
-func Update(ctx context.Context, devConn *dev.DevConn) error {
+// Update updates mos to the version given in the args, or required by the
+// project in the current dir with the given manifest (see
+// EnforceProjectMosVersion), or the latest one of the update channel.
+func Update(ctx context.Context, manifest *build.FWAppManifest) error {
 	if version.LooksLikeDebianBuildId(version.BuildId) {
 		// It looks like this binary is from Ubuntu's deb, so, use apt to update
 		if err := ourutil.RunCmd(ourutil.CmdOutAlways, "sudo", "apt", "update"); err != nil {
@@ -88,6 +87,10 @@ func Update(ctx context.Context, devConn *dev.DevConn) error {
 		return nil
 	}
 
+	if *rollbackFlag {
+		return errors.Trace(rollback())
+	}
+
 	args := flag.Args()
 
 	// updChannel and newUpdChannel are needed for the logging, so that it's
@@ -103,6 +106,11 @@ func Update(ctx context.Context, devConn *dev.DevConn) error {
 		// Desired mos version is given
 		newMosVersion = args[1]
 		newUpdChannel = getUpdateChannelByMosVersion(newMosVersion)
+	} else if pv, err := getProjectVersionReq(".", manifest); err == nil && pv.MosVersion != "" {
+		// The project in the current dir pins mos version, switch to it
+		ourutil.Reportf("Project requires mos version %s", pv.MosVersion)
+		newMosVersion = pv.MosVersion
+		newUpdChannel = getUpdateChannelByMosVersion(newMosVersion)
 	}
 
 	if updChannel != newUpdChannel {
@@ -180,13 +188,24 @@ func Update(ctx context.Context, devConn *dev.DevConn) error {
 		}
 		tmpfile.Close()
 
+		if err := verifyBinary(mosUrl, tmpfile.Name()); err != nil {
+			os.Remove(tmpfile.Name())
+			return errors.Annotatef(err, "downloaded binary is not trusted")
+		}
+
 		// Determine names for the executable and backup
 		executable, err := osext.Executable()
 		if err != nil {
 			return errors.Trace(err)
 		}
 
-		bak := fmt.Sprintf("%s.bak", executable)
+		bak := getBackupName(executable)
+
+		// Keep the old binary in the version store, so that projects which
+		// require it can still use it
+		if err := storeBinary(executable, version.GetMosVersion()); err != nil {
+			ourutil.Reportf("Failed to save the old binary to the version store: %s", err)
+		}
 
 		ourutil.Reportf("Renaming old binary as %s...", bak)
 		if err := os.Rename(executable, bak); err != nil {
@@ -211,6 +230,39 @@ func Update(ctx context.Context, devConn *dev.DevConn) error {
 	return nil
 }
 
+func getBackupName(executable string) string {
+	return fmt.Sprintf("%s.bak", executable)
+}
+
+// rollback swaps the current binary with the one saved by the last update,
+// so that running rollback again brings the updated binary back.
+func rollback() error {
+	executable, err := osext.Executable()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bak := getBackupName(executable)
+	if _, err := os.Stat(bak); err != nil {
+		return errors.Errorf("no previous binary found (%s)", bak)
+	}
+
+	tmp := fmt.Sprintf("%s.tmp", executable)
+	if err := os.Rename(executable, tmp); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(bak, executable); err != nil {
+		// Try to restore the current binary
+		os.Rename(tmp, executable)
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmp, bak); err != nil {
+		return errors.Trace(err)
+	}
+
+	ourutil.Reportf("Restored the previous binary, the current one is saved as %s", bak)
+	return nil
+}
+
 // GetUpdateChannel returns update channel (either "latest" or "release")
 // depending on current mos version.
 func GetUpdateChannel() string {
@@ -532,3 +584,14 @@ func parseProjectDirname(projectDir string) (basename, projectVersion, dirVersio
 
 	return
 }
+
+// verifyBinary checks the downloaded binary against the published checksum
+// and signature, which is required if the signing key is built in.
+func verifyBinary(mosUrl, fname string) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Verifying...")
+	return errors.Trace(download.VerifySigned(mosUrl, data))
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"cesanta.com/common/go/ourutil"
+	"cesanta.com/mos/ci"
+	"cesanta.com/mos/errcode"
 
 	"github.com/cesanta/errors"
 	"github.com/golang/glog"
@@ -19,8 +26,73 @@ func freportf(logFile io.Writer, f string, args ...interface{}) {
 	ourutil.Freportf(logFile, f, args...)
 }
 
-func prompt(text string) string {
-	return ourutil.Prompt(text)
+// isInteractive returns whether the user can be asked questions: not with
+// --non-interactive, nor in the CI mode.
+func isInteractive() bool {
+	return !*nonInteractive && !ci.Enabled()
+}
+
+// prompt asks the user and returns the answer. In the non-interactive mode,
+// nobody is there to answer, so an error is returned instead, with the hint
+// on how to give the answer with flags.
+func prompt(text, hint string) (string, error) {
+	if !isInteractive() {
+		return "", errcode.Errorf(errcode.UsageError, "%q can't be asked in the non-interactive mode; %s", text, hint)
+	}
+	return ourutil.Prompt(text), nil
+}
+
+// promptPassword asks for the password like prompt does, but without echo,
+// and returns it as typed, without trimming. If the terminal can't be put
+// into the raw mode, the line is read with echo.
+func promptPassword(text, hint string) (string, error) {
+	if !isInteractive() {
+		return "", errcode.Errorf(errcode.UsageError, "%q can't be asked in the non-interactive mode; %s", text, hint)
+	}
+	fmt.Fprintf(os.Stderr, "%s ", text)
+	restore, err := makeStdinRaw()
+	if err != nil {
+		glog.Infof("reading the password with echo: %s", err)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", errors.Trace(err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer func() {
+		restore()
+		fmt.Fprintf(os.Stderr, "\n")
+	}()
+	var passwd []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(b); err != nil {
+			return "", errors.Trace(err)
+		}
+		switch b[0] {
+		case '\r', '\n':
+			return string(passwd), nil
+		case 0x03: // Ctrl-C
+			return "", errors.Errorf("interrupted")
+		case 0x7f, 0x08: // Backspace
+			if len(passwd) > 0 {
+				_, size := utf8.DecodeLastRune(passwd)
+				passwd = passwd[:len(passwd)-size]
+			}
+		default:
+			passwd = append(passwd, b[0])
+		}
+	}
+}
+
+// confirm asks the yes/no question and returns whether the answer is yes;
+// see prompt for the non-interactive mode.
+func confirm(text, hint string) (bool, error) {
+	yn, err := prompt(text+" [y/N]?", hint)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return strings.ToUpper(yn) == "Y", nil
 }
 
 func getCommandOutput(command string, args ...string) (string, error) {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"context"
+
+	"cesanta.com/common/go/ourutil"
+	moscommon "cesanta.com/mos/common"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/release"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	changelogFile = flag.String("changelog", "CHANGELOG.md", "mos version bump: changelog file to add the entry to, relative to the project dir")
+	noTag         = flag.Bool("no-tag", false, "mos version bump: update mos.yml and the changelog, but do not commit and tag")
+)
+
+func init() {
+	hiddenFlags = append(hiddenFlags, "changelog", "no-tag")
+}
+
+// versionHandler implements "mos version", which prints the app version
+// from mos.yml, and "mos version bump [major|minor|patch|<version>]", which
+// updates it, adds the commits since the last tag to the changelog, and
+// commits and tags the result.
+func versionHandler(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	manifestPath := moscommon.GetManifestFilePath(projectDir)
+	manifest, err := readProjectManifest()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(args) == 0 {
+		fmt.Println(manifest.Version)
+		return nil
+	}
+	if args[0] != "bump" {
+		return errors.Errorf("unknown command %q, expected bump", args[0])
+	}
+	what := release.BumpPatch
+	if len(args) > 1 {
+		what = args[1]
+	}
+
+	if !*noTag {
+		if out, err := releaseGit("status", "--porcelain", "--untracked-files=no"); err != nil {
+			return errors.Trace(err)
+		} else if out != "" {
+			return errors.Errorf("working tree has uncommitted changes, commit or stash them first:\n%s", out)
+		}
+	}
+
+	newVersion, err := release.BumpVersion(manifest.Version, what)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tag := "v" + newVersion
+	if _, err := releaseGit("rev-parse", "--verify", "--quiet", "refs/tags/"+tag); err == nil {
+		return errors.Errorf("tag %s already exists", tag)
+	}
+	prevTag, _ := releaseGit("describe", "--tags", "--abbrev=0")
+
+	entry, err := getReleaseChangelog(prevTag, "HEAD", tag)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	clPath := *changelogFile
+	if !filepath.IsAbs(clPath) {
+		clPath = filepath.Join(projectDir, clPath)
+	}
+	clData, err := ioutil.ReadFile(clPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(clPath, release.PrependChangelog(clData, entry), 0644); err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(manifestPath, release.SetManifestVersion(data, newVersion), 0666); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("%s: %s -> %s, changelog entry added to %s", manifest.Name, manifest.Version, newVersion, clPath)
+
+	if *noTag {
+		return nil
+	}
+	if _, err := releaseGit("add", "--", manifestPath, clPath); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := releaseGit("commit", "-m", fmt.Sprintf("Version %s", newVersion), "--", manifestPath, clPath); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := releaseGit("tag", "-a", tag, "-m", fmt.Sprintf("Version %s", newVersion)); err != nil {
+		return errors.Trace(err)
+	}
+	ourutil.Reportf("Created tag %s; push it with \"git push origin HEAD %s\"", tag, tag)
+	return nil
+}
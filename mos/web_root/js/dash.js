@@ -12,7 +12,9 @@ var ui = {
   version: 'latest',
   maxLogMessages: 999,
   apps: {},
-  pageCache: {}
+  pageCache: {},
+  deviceLogs: {},     // Console output of the devices on the Devices page
+  onDeviceLog: null   // Set by the Devices page to show new output
 };
 
 PNotify.prototype.options.styling = 'fontawesome';
@@ -454,6 +456,16 @@ var addLog = function(msg, type) {
   if ($('#autoscroll').is(':checked')) el.scrollTop = el.scrollHeight;
 };
 
+// Console output of the devices managed on the Devices page is kept
+// separately for each device, and doesn't go to the main log.
+var addDeviceLog = function(port, msg) {
+  var log = (ui.deviceLogs[port] || '') + (msg || '');
+  var max = ui.maxLogMessages * 100;
+  if (log.length > max) log = log.substring(log.length - max);
+  ui.deviceLogs[port] = log;
+  if (ui.onDeviceLog) ui.onDeviceLog(port, msg || '');
+};
+
 // https://developer.mozilla.org/en/docs/Web/API/WindowBase64/Base64_encoding_and_decoding
 function b64enc(str) {
   return btoa(
@@ -14,7 +14,11 @@
     };
     ws.onmessage = function(ev) {
       var m = JSON.parse(ev.data || '');
-      addLog(m.data, m.cmd);
+      if (m.device) {
+        addDeviceLog(m.device, m.data);
+      } else {
+        addLog(m.data, m.cmd);
+      }
     };
     ws.onerror = function(ev) {
       console.log('error', ev);
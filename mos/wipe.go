@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	fwfs "cesanta.com/fw/defs/fs"
+	"cesanta.com/mos/dev"
+	"cesanta.com/mos/guard"
+	"github.com/cesanta/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var wipeTargets = []string{"config", "fs", "ota", "nvs", "all"}
+
+var wipeTargetDescriptions = map[string]string{
+	"config": "user configuration (conf1.json - conf9.json)",
+	"fs":     "the filesystem, including the files of the firmware itself",
+	"ota":    "OTA data and secondary app slots",
+	"nvs":    "NVS partitions",
+	"all":    "the entire flash, the firmware will need to be reflashed",
+}
+
+// userConfigFileRe matches the config levels which are written at runtime;
+// conf0.json contains the defaults and comes with the firmware.
+var userConfigFileRe = regexp.MustCompile(`^conf[1-9]\.json$`)
+
+func wipe(ctx context.Context, devConn *dev.DevConn) error {
+	args := flag.Args()[1:]
+	if len(args) == 0 {
+		return errors.Errorf("what to wipe is required: %s", strings.Join(wipeTargets, ", "))
+	}
+	want := map[string]bool{}
+	for _, a := range args {
+		if _, ok := wipeTargetDescriptions[a]; !ok {
+			return errors.Errorf("unknown wipe target %q, expected %s", a, strings.Join(wipeTargets, ", "))
+		}
+		want[a] = true
+	}
+	// Wiping the filesystem removes the config files too.
+	if want["all"] {
+		want = map[string]bool{"all": true}
+	} else if want["fs"] {
+		delete(want, "config")
+	}
+	var targets []string
+	for _, t := range wipeTargets {
+		if want[t] {
+			targets = append(targets, t)
+		}
+	}
+
+	op := guard.OpFlashErase
+	if len(targets) == 1 && targets[0] == "config" {
+		op = guard.OpConfigReset
+	}
+	port, _ := getPort()
+	confirmed, err := guardOperation(op, port, "erase "+strings.Join(targets, ", "))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if !*force && !confirmed {
+		reportf("This will erase:")
+		for _, t := range targets {
+			reportf("  %s", wipeTargetDescriptions[t])
+		}
+		yes, err := confirm("Are you sure", "use --force to wipe without confirmation")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !yes {
+			return errors.Errorf("aborted, use --force to wipe without confirmation")
+		}
+	}
+
+	// Config is wiped via RPC, and the device is also asked about its platform
+	// if it's not given.
+	var flashTargets []string
+	for _, t := range targets {
+		if t != "config" {
+			flashTargets = append(flashTargets, t)
+		}
+	}
+	if want["config"] || (len(flashTargets) > 0 && *platform == "") {
+		dc, err := createDevConn(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if *platform == "" {
+			info, err := dc.GetInfo(ctx)
+			if err != nil {
+				dc.Disconnect(ctx)
+				return errors.Annotatef(err, "failed to get device info, use --platform")
+			}
+			*platform = strings.ToLower(*info.Arch)
+		}
+		if want["config"] {
+			err = wipeConfig(ctx, dc)
+		}
+		dc.Disconnect(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(flashTargets) == 0 {
+		return nil
+	}
+	return errors.Trace(wipeFlash(ctx, flashTargets))
+}
+
+// wipeConfig removes user config files from the device and reboots it, which
+// makes it start with the defaults from conf0.json.
+func wipeConfig(ctx context.Context, devConn *dev.DevConn) error {
+	files, err := devConn.CFilesystem.List(ctx, &fwfs.ListArgs{})
+	if err != nil {
+		return errors.Annotatef(err, "failed to list files")
+	}
+	n := 0
+	for _, f := range files {
+		if !userConfigFileRe.MatchString(f) {
+			continue
+		}
+		reportf("Removing %s...", f)
+		if err := fsRemoveFile(ctx, devConn, f); err != nil {
+			return errors.Annotatef(err, "failed to remove %s", f)
+		}
+		n++
+	}
+	if n == 0 {
+		reportf("No user config files found")
+		return nil
+	}
+	reportf("Rebooting...")
+	if _, err := callDeviceService(ctx, devConn, "Sys.Reboot", ""); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}